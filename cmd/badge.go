@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// badgeKinds are the badges badge can emit, in output order
+var badgeKinds = []string{"validate", "stacks", "deploy"}
+
+// shieldsBadge is a shields.io endpoint badge: https://shields.io/badges/endpoint-badge
+type shieldsBadge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// Badge prints one badge as shields.io endpoint JSON, or writes every badge
+// to <dir>/<kind>.json with --out, for embedding in the repository README from CI
+func Badge(args []string) error {
+	usage := "usage: homelabctl badge <validate|stacks|deploy> | badge --out <dir>"
+
+	var kind, outDir string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--out" || arg == "-o":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value (%s)", arg, usage)
+			}
+			outDir = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--out="):
+			outDir = strings.TrimPrefix(arg, "--out=")
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+		case kind == "":
+			kind = arg
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if (kind == "") == (outDir == "") {
+		return fmt.Errorf("%s", usage)
+	}
+
+	if kind != "" {
+		badge, err := buildBadge(kind)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(badge)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if err := os.MkdirAll(outDir, paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create %s: %w", outDir, err)
+	}
+	for _, kind := range badgeKinds {
+		badge, err := buildBadge(kind)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(badge)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(outDir, kind+".json")
+		if err := os.WriteFile(path, append(data, '\n'), paths.FilePermissions); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		ui.Success("%s: %s", path, badge.Message)
+	}
+	return nil
+}
+
+// buildBadge computes a badge from the repository
+// A failing validation is a badge message, not an error
+func buildBadge(kind string) (*shieldsBadge, error) {
+	badge := &shieldsBadge{SchemaVersion: 1}
+
+	switch kind {
+	case "validate":
+		badge.Label = "homelab"
+		before := len(Warnings())
+		if err := validateRepository(func(string) {}); err != nil {
+			badge.Message, badge.Color = "failing", "red"
+		} else if warnings := len(Warnings()) - before; warnings > 0 {
+			badge.Message, badge.Color = fmt.Sprintf("passing, %d warning(s)", warnings), "yellow"
+		} else {
+			badge.Message, badge.Color = "passing", "brightgreen"
+		}
+	case "stacks":
+		if err := fs.VerifyRepository(); err != nil {
+			return nil, err
+		}
+		enabled, err := fs.GetEnabledStacks()
+		if err != nil {
+			return nil, err
+		}
+		badge.Label = "stacks"
+		badge.Message, badge.Color = fmt.Sprintf("%d enabled", len(enabled)), "blue"
+	case "deploy":
+		if err := fs.VerifyRepository(); err != nil {
+			return nil, err
+		}
+		badge.Label = "last deploy"
+		if at, ok := inventory.LastDeployAt(); ok {
+			badge.Message, badge.Color = at.UTC().Format("2006-01-02"), "informational"
+		} else {
+			badge.Message, badge.Color = "never", "lightgrey"
+		}
+	default:
+		return nil, fmt.Errorf("unknown badge: %s (available: %s)", kind, strings.Join(badgeKinds, ", "))
+	}
+
+	return badge, nil
+}
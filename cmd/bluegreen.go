@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// greenRouterPriority is the Traefik priority of the new instance's routers, so they win
+// over the old routers (default priority is the rule length) as soon as Traefik sees them
+const greenRouterPriority = "100000"
+
+// BlueGreen upgrades a Traefik-exposed service without downtime: a versioned copy is
+// started next to the old container, takes over the routes once healthy, and the old
+// container is removed. The compose service is then recreated and the copy retired
+func BlueGreen(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: homelabctl blue-green <[stack/]service>")
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, service, err := resolveService(args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := Generate(); err != nil {
+		return err
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	if err := checkBlueGreenService(generated, service); err != nil {
+		return err
+	}
+
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+
+	containers, err := e.Ps()
+	if err != nil {
+		return fmt.Errorf("failed to list containers of %s: %w", service, err)
+	}
+	running := false
+	for _, c := range containers {
+		running = running || (c.Service == service && c.Status == "running")
+	}
+	if !running {
+		fmt.Printf("\n%s is not running; starting it normally\n", service)
+		if err := e.Up([]string{service}, engine.UpOptions{NoDeps: true, Wait: true}); err != nil {
+			return fmt.Errorf("docker compose up failed: %w", err)
+		}
+		return nil
+	}
+
+	svcMap := generated.Services[service].(map[string]interface{})
+	if _, ok := svcMap["healthcheck"]; !ok {
+		addWarnings(fmt.Sprintf("service %s has no healthcheck; routes switch as soon as the new container starts", service))
+	}
+
+	version := time.Now().Format("20060102150405")
+	green := service + "-" + version
+
+	overlay := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			green: greenService(svcMap, compose.ServiceLabels(generated, service), version),
+		},
+	}
+	if err := compose.WriteComposeFile(paths.BlueGreenOverride, overlay); err != nil {
+		return err
+	}
+	defer os.Remove(paths.BlueGreenOverride)
+	if err := syncRuntime(); err != nil {
+		return err
+	}
+
+	withGreen, err := projectEngine(paths.BlueGreenOverride)
+	if err != nil {
+		return err
+	}
+
+	// Step 1: start the new instance; Traefik only routes to it once healthy
+	fmt.Printf("\nStarting %s next to %s...\n", green, service)
+	if err := withGreen.Up([]string{green}, engine.UpOptions{NoDeps: true, Wait: true}); err != nil {
+		if rmErr := withGreen.Down([]string{green}, engine.DownOptions{}); rmErr != nil {
+			fmt.Printf("Warning: failed to remove %s: %v\n", green, rmErr)
+		}
+		return errors.Wrap(err, fmt.Sprintf("new instance of %s did not become healthy", service),
+			fmt.Sprintf("Inspect its logs: homelabctl logs %s", service),
+			"The old container was left untouched",
+		).WithClass(errors.ClassDocker)
+	}
+	ui.Detail("✓ %s healthy, routes switched", green)
+
+	// Step 2: remove the old container, traffic now only reaches the new instance
+	fmt.Printf("\nRemoving old %s container...\n", service)
+	if err := e.Down([]string{service}, engine.DownOptions{}); err != nil {
+		return blueGreenRecovery(service, green, fmt.Errorf("failed to remove old container: %w", err))
+	}
+
+	// Step 3: recreate the compose service with the new configuration, then retire the copy
+	// The copy keeps serving (higher router priority) until the recreated service is healthy
+	fmt.Printf("\nRecreating %s...\n", service)
+	if err := e.Up([]string{service}, engine.UpOptions{NoDeps: true, Wait: true}); err != nil {
+		return blueGreenRecovery(service, green, fmt.Errorf("failed to recreate %s: %w", service, err))
+	}
+
+	if err := withGreen.Down([]string{green}, engine.DownOptions{}); err != nil {
+		return blueGreenRecovery(service, green, fmt.Errorf("failed to remove %s: %w", green, err))
+	}
+
+	ui.Blank()
+	ui.Success("Switched %s without downtime", service)
+	return nil
+}
+
+// checkBlueGreenService verifies a service can run twice side by side behind Traefik
+func checkBlueGreenService(generated *compose.ComposeFile, service string) error {
+	svcMap, ok := generated.Services[service].(map[string]interface{})
+	if !ok {
+		return errors.New(
+			fmt.Sprintf("service '%s' not found in %s", service, paths.DockerCompose),
+			"Check that the service is not disabled",
+		).WithClass(errors.ClassNotFound)
+	}
+
+	labels := compose.ServiceLabels(generated, service)
+	if labels["traefik.enable"] != "true" || len(traefikNames(labels, "routers")) == 0 {
+		return errors.New(
+			fmt.Sprintf("service '%s' is not exposed through Traefik", service),
+			"Blue-green switching needs traefik.enable=true and a traefik.http.routers.<name>.rule label",
+			fmt.Sprintf("Use a regular deploy instead: homelabctl up -d %s", service),
+		).WithClass(errors.ClassValidation)
+	}
+
+	var conflicts []string
+	if _, ok := svcMap["container_name"]; ok {
+		conflicts = append(conflicts, "container_name is set (two containers cannot share it)")
+	}
+	if _, ok := svcMap["ports"]; ok {
+		conflicts = append(conflicts, "ports are published on the host (both instances would bind them)")
+	}
+	if len(conflicts) > 0 {
+		return errors.New(
+			fmt.Sprintf("service '%s' cannot run two instances side by side", service),
+			"Remove container_name and host ports; Traefik reaches the service over its network",
+		).WithContext(conflicts...).WithClass(errors.ClassValidation)
+	}
+
+	return nil
+}
+
+// greenService returns a copy of a service definition for the versioned instance
+// Its Traefik routers and services are renamed with the version suffix and given a
+// higher priority, so they take over the old instance's routes once registered
+func greenService(svcMap map[string]interface{}, labels map[string]string, version string) map[string]interface{} {
+	green := make(map[string]interface{}, len(svcMap))
+	for key, value := range svcMap {
+		green[key] = value
+	}
+
+	routers := traefikNames(labels, "routers")
+	services := traefikNames(labels, "services")
+
+	rename := func(key, kind string, names []string) string {
+		prefix := "traefik.http." + kind + "."
+		for _, name := range names {
+			if strings.HasPrefix(key, prefix+name+".") {
+				return prefix + name + "-" + version + strings.TrimPrefix(key, prefix+name)
+			}
+		}
+		return key
+	}
+
+	greenLabels := make(map[string]interface{}, len(labels))
+	for key, value := range labels {
+		newKey := rename(rename(key, "routers", routers), "services", services)
+		if strings.HasSuffix(newKey, ".service") && strings.HasPrefix(newKey, "traefik.http.routers.") {
+			for _, name := range services {
+				if value == name {
+					value = name + "-" + version
+				}
+			}
+		}
+		greenLabels[newKey] = value
+	}
+	for _, router := range routers {
+		greenLabels["traefik.http.routers."+router+"-"+version+".priority"] = greenRouterPriority
+	}
+
+	green["labels"] = greenLabels
+	return green
+}
+
+// traefikNames returns the sorted router or service names declared in traefik.http labels
+func traefikNames(labels map[string]string, kind string) []string {
+	prefix := "traefik.http." + kind + "."
+	seen := make(map[string]bool)
+
+	var names []string
+	for key := range labels {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(key, prefix), ".", 2)[0]
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// blueGreenRecovery explains the state left behind when a switch fails midway
+func blueGreenRecovery(service, green string, cause error) error {
+	return errors.Wrap(cause, fmt.Sprintf("blue-green switch of %s did not complete", service),
+		fmt.Sprintf("%s is still serving traffic", green),
+		fmt.Sprintf("Once %s is healthy, remove the copy: docker rm -f $(docker ps -qf label=com.docker.compose.service=%s)", service, green),
+	).WithClass(errors.ClassDocker)
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/bundle"
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Bundle saves the images of the generated deployment and the runtime tree into a
+// tarball, for deploying on a host without registry access: deploy --from-bundle
+func Bundle(args []string) error {
+	usage := "usage: homelabctl bundle [--out <file>]"
+	out := fmt.Sprintf("homelab-bundle-%s.tar.gz", time.Now().Format("20060102-150405"))
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--out" || arg == "-o":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value (%s)", arg, usage)
+			}
+			out = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--out="):
+			out = strings.TrimPrefix(arg, "--out=")
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	// docker save writes where the images are; with --host that is the remote machine
+	host, err := selectedHost()
+	if err != nil {
+		return err
+	}
+	if host != nil {
+		return errors.New(
+			"bundle is not supported with --host",
+			"Create the bundle on a machine with registry access, then: homelabctl --host <name> deploy --from-bundle <file>",
+		).WithClass(errors.ClassUsage)
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	images := bundleImages(generated)
+	if len(images) == 0 {
+		return fmt.Errorf("no images in %s", paths.DockerCompose)
+	}
+
+	// Images built from source are saved as they are; the others are pulled when missing
+	var missing []string
+	built := make(map[string]bool)
+	for _, svc := range compose.BuiltServices(generated) {
+		built[compose.ServiceImages(generated, []string{svc})[svc]] = true
+	}
+	for _, image := range images {
+		if _, err := dockerOutput("image", "inspect", "--format", "{{.Id}}", image); err != nil {
+			if built[image] {
+				return errors.New(
+					fmt.Sprintf("image %s is built from source and not built yet", image),
+					"Run: homelabctl generate --build",
+				).WithClass(errors.ClassNotFound)
+			}
+			missing = append(missing, image)
+		}
+	}
+	if len(missing) > 0 {
+		if err := registryLogin(missing); err != nil {
+			return err
+		}
+		fmt.Printf("Pulling %d missing image(s)...\n", len(missing))
+		if err := printPullSummary(pullImages(missing, defaultPullParallelism)); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.MkdirTemp("", "homelabctl-bundle-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	imagesTar := filepath.Join(tmp, bundle.ImagesFile)
+	fmt.Printf("Saving %d image(s)...\n", len(images))
+	cmd, err := dockerCommand(false, append([]string{"save", "-o", imagesTar}, images...)...)
+	if err != nil {
+		return err
+	}
+	if _, err := engine.Output(cmd); err != nil {
+		return errors.Wrap(err, "docker save failed").WithClass(errors.ClassDocker)
+	}
+
+	manifest := bundle.Manifest{Created: time.Now().UTC().Truncate(time.Second), Project: composeProjectName(), Images: images}
+	if err := bundle.Create(out, manifest, imagesTar); err != nil {
+		return err
+	}
+
+	size := ""
+	if info, err := os.Stat(out); err == nil {
+		size = fmt.Sprintf(" (%.1f MB)", float64(info.Size())/1e6)
+	}
+	ui.Blank()
+	ui.Success("Wrote %s%s", out, size)
+	fmt.Println("  It contains runtime/ and .env, which may hold secrets: keep it private")
+	fmt.Printf("  Deploy it with: homelabctl deploy --from-bundle %s\n", filepath.Base(out))
+	return nil
+}
+
+// bundleImages returns the distinct images of the generated services, sorted
+func bundleImages(generated *compose.ComposeFile) []string {
+	seen := make(map[string]bool)
+	var images []string
+	for _, image := range compose.ServiceImages(generated, nil) {
+		if !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	sort.Strings(images)
+	return images
+}
+
+// loadBundle unpacks a bundle into runtime/ and loads its images with docker load,
+// on the host selected with --host when there is one
+func loadBundle(path string) error {
+	fmt.Printf("Loading bundle %s...\n", path)
+
+	manifest, err := bundle.Extract(path, func(images io.Reader) error {
+		cmd, err := dockerCommand(false, "load")
+		if err != nil {
+			return err
+		}
+		cmd.Stdin = images
+		if _, err := engine.Output(cmd); err != nil {
+			return errors.Wrap(err, "docker load failed").WithClass(errors.ClassDocker)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ui.Success("Loaded %d image(s) from a bundle created %s", len(manifest.Images), manifest.Created.Local().Format("2006-01-02 15:04"))
+	if manifest.Project != composeProjectName() {
+		addWarnings(fmt.Sprintf("bundle was created for compose project %s, deploying as %s", manifest.Project, composeProjectName()))
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Canary deploys a single service with a new image through a compose overlay,
+// runs its smoke test, then promotes the image into stack.yaml or rolls back
+func Canary(args []string) error {
+	usage := "usage: homelabctl canary <[stack/]service> --image <image>"
+
+	var name, image string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--image":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s", usage)
+			}
+			image = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--image="):
+			image = strings.TrimPrefix(arg, "--image=")
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		case name == "":
+			name = arg
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if name == "" || image == "" {
+		return fmt.Errorf("%s", usage)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	stackName, service, err := resolveService(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	current, ok := compose.ServiceImages(generated, []string{service})[service]
+	if !ok {
+		return errors.New(
+			fmt.Sprintf("service '%s' has no image in %s", service, paths.DockerCompose),
+			"Run: homelabctl generate",
+			"Check that the service is not disabled",
+		).WithClass(errors.ClassNotFound)
+	}
+	if current == image {
+		return fmt.Errorf("service %s already runs %s", service, image)
+	}
+
+	// Promotion rewrites the image in stack.yaml, so it must be defined there
+	// A registry mirror rewrote the generated image; stack.yaml holds the original
+	declared := current
+	if source := compose.ServiceLabels(generated, service)[compose.LabelSourceImage]; source != "" {
+		declared = source
+	}
+	count, err := stacks.CountStackValue(stackName, declared)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New(
+			fmt.Sprintf("image '%s' is not set in stacks/%s/stack.yaml", declared, stackName),
+			"The image may be overridden in inventory/vars.yaml or built from several variables",
+			"Set the full image reference in stack.yaml vars to use canary promotion",
+		).WithClass(errors.ClassValidation)
+	}
+
+	stack, err := stacks.LoadStack(stackName)
+	if err != nil {
+		return err
+	}
+	smokeTest := stack.SmokeTests[service]
+
+	// Promotion rewrites this service's image only: vars.<service>.image when it holds
+	// the image, else the one value equal to it
+	imagePath := []string{"vars", service, "image"}
+	serviceVars, _ := stack.Vars[service].(map[string]interface{})
+	atPath := serviceVars["image"] == declared
+	if !atPath && count > 1 {
+		return errors.New(
+			fmt.Sprintf("image '%s' is set %d times in stacks/%s/stack.yaml", declared, count, stackName),
+			"Promotion would rewrite the other services or variables using it too",
+			fmt.Sprintf("Set the image of %s as vars.%s.image to use canary promotion", service, service),
+		).WithClass(errors.ClassValidation)
+	}
+
+	fmt.Printf("Canary %s/%s: %s → %s\n", stackName, service, current, image)
+
+	if err := writeCanaryOverlay(service, image); err != nil {
+		return err
+	}
+	defer os.Remove(paths.CanaryOverride)
+	if err := syncRuntime(); err != nil {
+		return err
+	}
+
+	withCanary, err := projectEngine(paths.CanaryOverride)
+	if err != nil {
+		return err
+	}
+
+	// Wait blocks until the container is running and healthy
+	fmt.Println("\nDeploying canary...")
+	if err := withCanary.Up([]string{service}, engine.UpOptions{NoDeps: true, Wait: true}); err != nil {
+		return rollbackCanary(service, fmt.Errorf("canary failed to become healthy: %w", err))
+	}
+
+	if smokeTest != "" {
+		fmt.Printf("\nRunning smoke test: %s\n", smokeTest)
+		if err := withCanary.Exec(service, []string{"sh", "-c", smokeTest}, engine.ExecOptions{NoTTY: true}); err != nil {
+			return rollbackCanary(service, fmt.Errorf("smoke test failed: %w", err))
+		}
+	} else {
+		fmt.Printf("\nNo smoke test defined for %s (smoke_tests in stack.yaml); health check passed\n", service)
+	}
+
+	// Promote: the new image becomes the stack default
+	if atPath {
+		_, err = stacks.ReplaceStackValueAt(stackName, imagePath, declared, image)
+	} else {
+		_, err = stacks.ReplaceStackValue(stackName, declared, image)
+	}
+	if err != nil {
+		return rollbackCanary(service, err)
+	}
+	ui.Blank()
+	ui.Success("Promoted %s in stacks/%s/stack.yaml", image, stackName)
+
+	if err := os.Remove(paths.CanaryOverride); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", paths.CanaryOverride, err)
+	}
+
+	fmt.Println()
+	if err := Generate(); err != nil {
+		return err
+	}
+
+	// Converge on the regenerated file (same image, so the container is kept)
+	if err := upService(service); err != nil {
+		return fmt.Errorf("docker compose up failed: %w", err)
+	}
+
+	ui.Blank()
+	ui.Success("Canary %s promoted to %s", service, image)
+	return nil
+}
+
+// upService recreates a service from the generated file, without its dependencies
+func upService(service string) error {
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+	return e.Up([]string{service}, engine.UpOptions{NoDeps: true})
+}
+
+// writeCanaryOverlay writes the compose overlay replacing a service's image
+func writeCanaryOverlay(service, image string) error {
+	overlay := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			service: map[string]interface{}{"image": image},
+		},
+	}
+	return compose.WriteComposeFile(paths.CanaryOverride, overlay)
+}
+
+// rollbackCanary restores the service from the generated file and returns the canary failure
+func rollbackCanary(service string, cause error) error {
+	fmt.Printf("\nRolling back %s...\n", service)
+
+	rollbackErr := upService(service)
+	if rollbackErr != nil {
+		return errors.Wrap(cause, "canary failed and rollback failed",
+			fmt.Sprintf("Restore the service manually: homelabctl up -d %s", service),
+		).WithContext(fmt.Sprintf("Rollback error: %v", rollbackErr)).WithClass(errors.ClassDocker)
+	}
+
+	return errors.Wrap(cause, "canary rolled back",
+		fmt.Sprintf("Inspect the canary logs: homelabctl logs %s", service),
+		"stack.yaml was not changed",
+	).WithClass(errors.ClassDocker)
+}
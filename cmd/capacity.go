@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// meminfoPath is where the host's memory is read from; replaced in tests
+var meminfoPath = "/proc/meminfo"
+
+// capacityStage warns when the merged compose file exceeds the guardrails of
+// inventory/capacity.yaml
+func capacityStage() pipeline.Stage {
+	return func(ctx *pipeline.Context) error {
+		capacity, err := inventory.LoadCapacity()
+		if err != nil || capacity == nil {
+			return err
+		}
+		warnings, err := capacityWarnings(ctx.MergedCompose, capacity)
+		if err != nil {
+			return err
+		}
+		ctx.Warnings = append(ctx.Warnings, warnings...)
+		return nil
+	}
+}
+
+// warnCapacity checks the compose file generate would write against the guardrails of
+// inventory/capacity.yaml, running the generate pipeline without writing runtime/ (its
+// progress on stderr); it reports whether they were checked
+func warnCapacity() (bool, error) {
+	capacity, err := inventory.LoadCapacity()
+	if err != nil || capacity == nil {
+		return false, err
+	}
+
+	// Hold the lock while the pipeline uses the staging dir
+	release, err := lock.Acquire()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	p, err := generatePipeline(false, false, false, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	ui.ToStderr(func() { err = p.Execute() })
+	if removeErr := os.RemoveAll(p.Context().StagingDir); removeErr != nil {
+		addWarnings(fmt.Sprintf("failed to remove %s: %v", p.Context().StagingDir, removeErr))
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to plan the services checked against "+paths.InventoryCapacity,
+			"Run: homelabctl plan",
+		).WithClass(errors.ClassValidation)
+	}
+
+	warnings, err := capacityWarnings(p.Context().MergedCompose, capacity)
+	if err != nil {
+		return false, err
+	}
+	addWarnings(warnings...)
+	return true, nil
+}
+
+// capacityWarnings compares the services of a compose file with the host's capacity:
+// their number with max_services, and their memory (limits, or the category or default
+// assumption of capacity.yaml) with the host memory left after the headroom
+func capacityWarnings(generated *compose.ComposeFile, capacity *inventory.Capacity) ([]string, error) {
+	var warnings []string
+	if capacity.MaxServices > 0 && len(generated.Services) > capacity.MaxServices {
+		warnings = append(warnings, fmt.Sprintf("%d services planned, more than the %d of max_services in %s",
+			len(generated.Services), capacity.MaxServices, paths.InventoryCapacity))
+	}
+
+	hostMemory, err := capacityHostMemory(capacity)
+	if err != nil {
+		return nil, err
+	}
+	if hostMemory <= 0 {
+		return warnings, nil // Unknown: nothing to compare with
+	}
+
+	headroom := 0.0
+	if value := strings.TrimSpace(capacity.MemoryHeadroom); strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || percent < 0 || percent >= 100 {
+			return nil, fmt.Errorf("invalid memory_headroom in %s: %q (use a size like 2GiB or a percentage like 20%%)", paths.InventoryCapacity, value)
+		}
+		headroom = hostMemory * percent / 100
+	} else if value != "" {
+		if headroom = parseSize(value); headroom <= 0 {
+			return nil, fmt.Errorf("invalid memory_headroom in %s: %q (use a size like 2GiB or a percentage like 20%%)", paths.InventoryCapacity, value)
+		}
+	}
+
+	assumed := make(map[string]float64, len(capacity.Categories))
+	for category, value := range capacity.Categories {
+		if assumed[category] = parseSize(value); assumed[category] <= 0 {
+			return nil, fmt.Errorf("invalid memory for category %s in %s: %q (use a size like 512MiB)", category, paths.InventoryCapacity, value)
+		}
+	}
+	fallback := 0.0
+	if capacity.ServiceMemory != "" {
+		if fallback = parseSize(capacity.ServiceMemory); fallback <= 0 {
+			return nil, fmt.Errorf("invalid service_memory in %s: %q (use a size like 256MiB)", paths.InventoryCapacity, capacity.ServiceMemory)
+		}
+	}
+
+	total := 0.0
+	var unknown []string
+	for svc, def := range generated.Services {
+		memory := serviceMemory(def)
+		if memory == 0 {
+			memory = assumed[compose.ServiceLabels(generated, svc)[compose.LabelCategory]]
+		}
+		if memory == 0 {
+			memory = fallback
+		}
+		if memory == 0 {
+			unknown = append(unknown, svc)
+		}
+		total += memory
+	}
+
+	available := hostMemory - headroom
+	if total > available {
+		warning := fmt.Sprintf("planned services need about %s of memory, more than the %s the host has after %s of headroom",
+			formatBytes(total, 1024, "iB"), formatBytes(available, 1024, "iB"), formatBytes(headroom, 1024, "iB"))
+		if len(unknown) > 0 {
+			warning += fmt.Sprintf(" (%d service(s) without a memory limit not counted)", len(unknown))
+		}
+		warnings = append(warnings, warning)
+	} else if len(unknown) > 0 {
+		sort.Strings(unknown)
+		warnings = append(warnings, fmt.Sprintf("memory of %s unknown: set a memory limit, or service_memory or a category in %s",
+			strings.Join(unknown, ", "), paths.InventoryCapacity))
+	}
+	return warnings, nil
+}
+
+// capacityHostMemory returns the host memory of capacity.yaml, or the memory detected on
+// this host (0 when it cannot be read)
+func capacityHostMemory(capacity *inventory.Capacity) (float64, error) {
+	if capacity.HostMemory != "" {
+		memory := parseSize(capacity.HostMemory)
+		if memory <= 0 {
+			return 0, fmt.Errorf("invalid host_memory in %s: %q (use a size like 16GiB)", paths.InventoryCapacity, capacity.HostMemory)
+		}
+		return memory, nil
+	}
+
+	file, err := os.Open(meminfoPath)
+	if err != nil {
+		return 0, nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return 0, nil
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, nil
+}
+
+// serviceMemory returns the memory a service may use: its limit, else its reservation
+// (deploy.resources or mem_limit/mem_reservation); 0 when it sets neither
+func serviceMemory(def interface{}) float64 {
+	service, ok := def.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+
+	resources := map[string]interface{}{}
+	if deploy, ok := service["deploy"].(map[string]interface{}); ok {
+		if r, ok := deploy["resources"].(map[string]interface{}); ok {
+			resources = r
+		}
+	}
+	memoryOf := func(section string) interface{} {
+		if values, ok := resources[section].(map[string]interface{}); ok {
+			return values["memory"]
+		}
+		return nil
+	}
+
+	for _, value := range []interface{}{memoryOf("limits"), service["mem_limit"], memoryOf("reservations"), service["mem_reservation"]} {
+		if bytes := composeBytes(value); bytes > 0 {
+			return bytes
+		}
+	}
+	return 0
+}
+
+// composeBytes parses a compose byte value: a number of bytes, or a number with a b, k,
+// m or g unit (kb, mb... and KiB, MiB... accepted); 0 when unparseable
+func composeBytes(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		s := strings.ToLower(strings.TrimSpace(v))
+		s = strings.TrimSuffix(strings.TrimSuffix(s, "b"), "i")
+		multiplier := 1.0
+		if n := len(s); n > 0 {
+			switch s[n-1] {
+			case 'k':
+				multiplier = 1 << 10
+			case 'm':
+				multiplier = 1 << 20
+			case 'g':
+				multiplier = 1 << 30
+			case 't':
+				multiplier = 1 << 40
+			}
+			if multiplier > 1 {
+				s = s[:n-1]
+			}
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0
+		}
+		return f * multiplier
+	}
+	return 0
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/history"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// defaultHistoryKeep is how many generations runtime/history/ keeps without inventory/history.yaml
+const defaultHistoryKeep = 50
+
+// Clean removes what generate leaves behind in runtime/: the staging directories of
+// interrupted runs, the template contexts of --debug runs, and the generations of runtime/history/ past the retention policy
+// With --history, every generation is removed
+func Clean(args []string) error {
+	usage := "usage: homelabctl clean [--history] [--dry-run]"
+	purge := false
+	dryRun := false
+	for _, arg := range args {
+		switch arg {
+		case "--history":
+			purge = true
+		case "--dry-run":
+			dryRun = true
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	retention, err := historyRetention()
+	if err != nil {
+		return err
+	}
+
+	// generate writes the staging directories
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+
+	leftovers := []struct{ dir, reason string }{
+		{paths.RuntimeStaging, "left by an interrupted generate"},
+		{paths.RuntimeStaging + "-previous", "left by an interrupted generate"},
+		{paths.DebugDir, "template contexts kept by --debug"},
+	}
+	for _, leftover := range leftovers {
+		if _, err := os.Stat(leftover.dir); err != nil {
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(leftover.dir); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", leftover.dir, err)
+			}
+		}
+		ui.Success("%s %s (%s)", verb, leftover.dir, leftover.reason)
+	}
+
+	var removed []string
+	if purge {
+		removed, err = history.Purge(dryRun)
+	} else {
+		removed, err = history.Prune(retention, time.Now(), dryRun)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(removed) == 0 {
+		ui.Success("Nothing to remove from %s", paths.HistoryDir)
+		return nil
+	}
+	ui.Success("%s %d generation(s) from %s: %s", verb, len(removed), paths.HistoryDir, strings.Join(removed, ", "))
+	return nil
+}
+
+// historyRetention returns the retention policy of inventory/history.yaml
+func historyRetention() (history.Retention, error) {
+	settings, err := inventory.LoadHistorySettings()
+	if err != nil {
+		return history.Retention{}, err
+	}
+
+	retention := history.Retention{Keep: defaultHistoryKeep, DefaultKeep: settings.Keep == nil}
+	if settings.Keep != nil {
+		if *settings.Keep < 0 {
+			return retention, fmt.Errorf("invalid keep in %s: %d (use 0 for no limit)", paths.InventoryHistory, *settings.Keep)
+		}
+		retention.Keep = *settings.Keep
+	}
+
+	if settings.MaxAge != "" {
+		age, err := parseAge(settings.MaxAge)
+		if err != nil {
+			return retention, fmt.Errorf("invalid max_age in %s: %q (use a duration like 72h or 30d)", paths.InventoryHistory, settings.MaxAge)
+		}
+		retention.MaxAge = age
+	}
+
+	if settings.MaxSize != "" {
+		size := parseSize(settings.MaxSize)
+		if size <= 0 {
+			return retention, fmt.Errorf("invalid max_size in %s: %q (use a size like 500MB or 1GiB)", paths.InventoryHistory, settings.MaxSize)
+		}
+		retention.MaxSize = int64(size)
+	}
+
+	return retention, nil
+}
+
+// parseAge parses a positive duration, in days with a d suffix (30d)
+func parseAge(value string) (time.Duration, error) {
+	if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && strings.HasSuffix(value, "d") && days > 0 {
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,105 @@
+package cmd
+
+// What the positional arguments of a command are, for completion
+const (
+	argNone          = iota
+	argStacks        // Stacks in stacks/
+	argEnabledStacks // Enabled stacks
+	argServices      // Services of the enabled stacks
+	argTargets       // Enabled stacks and their services
+)
+
+// Command is a homelabctl command: how main runs it, and what completion offers after it
+type Command struct {
+	Name        string
+	Run         func(args []string) error
+	subcommands []string // Offered as the first argument
+	flags       []string // Flags without a value
+	valueFlags  []string // Flags taking a value
+	args        int      // Positional arguments (argNone, argStacks...)
+}
+
+// Commands returns the commands main dispatches, in usage order
+// Other docker compose commands are passed through by Passthrough
+func Commands() []Command {
+	return []Command{
+		{Name: "init", Run: Init, valueFlags: []string{"--template"}},
+		{Name: "enable", Run: Enable, flags: []string{"--with-deps", "--suggest-category", "--configure", "-s"}, args: argStacks},
+		{Name: "disable", Run: Disable, flags: []string{"-s", "--cascade", "--force"}, args: argEnabledStacks},
+		{Name: "list", Run: func([]string) error { return List() }},
+		{Name: "info", Run: Info, args: argStacks},
+		{Name: "graph", Run: Graph, flags: []string{"--all"}, valueFlags: []string{"--format"}},
+		{Name: "validate", Run: Validate, flags: []string{"--render"}},
+		{Name: "doctor", Run: Doctor},
+		{Name: "vars", Run: Vars, subcommands: []string{"example"}, flags: []string{"--all", "--stdout"}},
+		{Name: "docs", Run: Docs, valueFlags: []string{"--out", "--format"}},
+		{Name: "secrets", Run: Secrets, subcommands: []string{"encrypt", "decrypt", "edit", "set"}, args: argStacks},
+		{Name: "scan-secrets", Run: ScanSecrets, args: argStacks},
+		{Name: "state", Run: State, subcommands: []string{"prune"}, flags: []string{"--dry-run"}},
+		{Name: "generate", Run: func(args []string) error { return Generate(args...) },
+			flags:      []string{"--annotate", "--terraform", "--build", "--no-cache", "--pull", "--stages", "--watch", "--deploy"},
+			valueFlags: []string{"--debounce"}, args: argEnabledStacks},
+		{Name: "export", Run: Export, subcommands: []string{"ansible"}, valueFlags: []string{"--out"}},
+		{Name: "firewall", Run: Firewall, subcommands: []string{"generate", "apply"}, valueFlags: []string{"--format"}},
+		{Name: "plan", Run: Plan, args: argEnabledStacks},
+		{Name: "deploy", Run: Deploy, flags: []string{"--waves", "--dry-run", "--no-health-check"},
+			valueFlags: []string{"--at", "--window", "--from-bundle"}, args: argEnabledStacks},
+		{Name: "rollback", Run: Rollback, flags: []string{"--list"}, valueFlags: []string{"--to"}},
+		{Name: "bundle", Run: Bundle, valueFlags: []string{"--out"}},
+		{Name: "badge", Run: Badge, subcommands: []string{"validate", "stacks", "deploy"}, valueFlags: []string{"--out"}},
+		{Name: "canary", Run: Canary, valueFlags: []string{"--image"}, args: argServices},
+		{Name: "blue-green", Run: BlueGreen, args: argServices},
+		{Name: "dev", Run: Dev, args: argEnabledStacks},
+		{Name: "ps", Run: passthrough("ps"), args: argServices},
+		{Name: "top", Run: Top, flags: []string{"--by-stack"}},
+		{Name: "du", Run: Du, args: argEnabledStacks},
+		{Name: "report", Run: Report, subcommands: []string{"record"},
+			valueFlags: []string{"--since", "--until", "--format", "--interval"}, args: argTargets},
+		{Name: "notify", Run: Notify, subcommands: []string{"test"},
+			valueFlags: []string{"--severity", "--stack", "--category", "--event"}},
+		{Name: "ddns", Run: DDNS, subcommands: []string{"watch"}, flags: []string{"--dry-run"}, valueFlags: []string{"--interval"}},
+		{Name: "power", Run: Power, subcommands: []string{"status", "watch"}, valueFlags: []string{"--interval"}},
+		{Name: "install-service", Run: InstallService, flags: []string{"--compose", "--stdout"}, valueFlags: []string{"--name"}},
+		{Name: "logs", Run: Logs, flags: []string{"-f", "--follow", "-t", "--timestamps", "--no-color"},
+			valueFlags: []string{"-n", "--tail", "--since", "--until", "--grep"}, args: argTargets},
+		{Name: "events", Run: Events, valueFlags: []string{"--format", "--since", "--exit-on", "--fail-on", "--timeout"}, args: argTargets},
+		{Name: "wait", Run: Wait, valueFlags: []string{"--timeout"}, args: argTargets},
+		{Name: "verify", Run: Verify, args: argEnabledStacks},
+		{Name: "restart", Run: Restart, flags: []string{"--ordered"}, valueFlags: []string{"--stack"}, args: argServices},
+		{Name: "stop", Run: Stop, args: argTargets},
+		{Name: "pause", Run: Pause, flags: []string{"--stop"}, args: argTargets},
+		{Name: "resume", Run: Resume, args: argTargets},
+		{Name: "down", Run: Down, flags: []string{"--volumes", "--yes"}, args: argEnabledStacks},
+		{Name: "exec", Run: passthrough("exec"), args: argServices},
+		{Name: "pull", Run: Pull, valueFlags: []string{"--parallel"}, args: argEnabledStacks},
+		{Name: "outdated", Run: Outdated, flags: []string{"--json"}, valueFlags: []string{"--parallel"}},
+		{Name: "update", Run: Update, flags: []string{"--scheduled", "--dry-run"}, valueFlags: []string{"--parallel"}},
+		{Name: "prune", Run: Prune, flags: []string{"--images", "--dry-run"}},
+		{Name: "clean", Run: Clean, flags: []string{"--history", "--dry-run"}},
+		{Name: "volumes", Run: Volumes, subcommands: []string{"migrate", "check"}, flags: []string{"--dry-run", "--remove-old"}},
+		{Name: "sbom", Run: Sbom, valueFlags: []string{"--format", "--out"}},
+		{Name: "query", Run: Query, valueFlags: []string{"--format"}},
+		{Name: "config", Run: passthrough("config")},
+		{Name: "compose", Run: ComposeAny},
+		{Name: "env", Run: Env, flags: []string{"--unset"}, valueFlags: []string{"--shell"}},
+		{Name: "demo", Run: Demo, flags: []string{"--no-deploy"}},
+		{Name: "completion", Run: Completion, subcommands: completionShells},
+	}
+}
+
+// LookupCommand returns the command of a name
+func LookupCommand(name string) (Command, bool) {
+	for _, c := range Commands() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Command{}, false
+}
+
+// passthrough runs a docker compose command listed for completion
+func passthrough(command string) func(args []string) error {
+	return func(args []string) error {
+		return Compose(command, args)
+	}
+}
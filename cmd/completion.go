@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/remote"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// completionShells lists the shells completion writes scripts for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// globalFlag is a flag main parses before dispatching, given before the command
+type globalFlag struct {
+	name     string
+	values   func() []string // Values completed after it; nil for a flag without a value
+	anywhere bool            // Also read after the command
+}
+
+// globalFlags returns the flags main parses
+func globalFlags() []globalFlag {
+	return []globalFlag{
+		{name: "--debug", anywhere: true},
+		{name: "--strict"},
+		{name: "--ascii"},
+		{name: "--quiet"},
+		{name: "--error-format", values: func() []string { return []string{"text", "json"} }},
+		{name: "--output", values: func() []string { return outputFormats }},
+		{name: "--engine", values: func() []string { return engine.Kinds }},
+		{name: "--project", values: func() []string {
+			settings, err := inventory.LoadComposeSettings()
+			if err != nil {
+				return nil
+			}
+			var names []string
+			if settings.Project != "" {
+				names = append(names, settings.Project)
+			}
+			for _, name := range settings.Environments {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return names
+		}},
+		{name: "--env", values: func() []string {
+			envs, _ := inventory.Environments()
+			return envs
+		}},
+		{name: "--host", values: func() []string {
+			hosts, err := remote.LoadHosts()
+			if err != nil {
+				return nil
+			}
+			names := make([]string, 0, len(hosts))
+			for _, h := range hosts {
+				names = append(names, h.Name)
+			}
+			return names
+		}},
+	}
+}
+
+// Completion prints the completion script of a shell
+// The scripts ask homelabctl __complete for the candidates, so stacks and services are
+// read from the repository the command line runs in
+func Completion(args []string) error {
+	usage := "usage: homelabctl completion bash|zsh|fish"
+	if len(args) != 1 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		return fmt.Errorf("unknown shell: %s (available: %s)", args[0], strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+// Complete prints the candidates for the last word of a command line, one per line
+// args are the words after homelabctl, the last one being completed (possibly empty)
+// main runs it as homelabctl __complete, before parsing global flags out of the words
+func Complete(args []string) error {
+	for _, candidate := range completeWords(args) {
+		fmt.Println(candidate)
+	}
+	return nil
+}
+
+// completeWords returns the candidates for the last word, which the shell filters by prefix
+// Outside a repository, stacks and services are not offered
+func completeWords(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	previous := words[:len(words)-1]
+
+	var command *Command
+	positional := 0
+	for i := 0; i < len(previous); i++ {
+		word := previous[i]
+		name, _, hasValue := strings.Cut(word, "=")
+
+		if flag, ok := lookupGlobalFlag(name); ok && (command == nil || flag.anywhere) {
+			if flag.values != nil && !hasValue {
+				if i+1 == len(previous) {
+					return flag.values()
+				}
+				i++
+			}
+			continue
+		}
+
+		if command == nil {
+			c, ok := LookupCommand(word)
+			if !ok {
+				// Passed through to docker compose
+				return nil
+			}
+			command = &c
+			continue
+		}
+
+		switch {
+		case contains(command.valueFlags, name):
+			if !hasValue {
+				if i+1 == len(previous) {
+					// Free value, e.g. a file name
+					return nil
+				}
+				i++
+			}
+		case !strings.HasPrefix(word, "-"):
+			positional++
+		}
+	}
+
+	if strings.HasPrefix(current, "-") {
+		var candidates []string
+		if command != nil {
+			candidates = append(append(candidates, command.flags...), command.valueFlags...)
+		}
+		for _, flag := range globalFlags() {
+			if command == nil || flag.anywhere {
+				candidates = append(candidates, flag.name)
+			}
+		}
+		return candidates
+	}
+
+	if command == nil {
+		var names []string
+		for _, c := range Commands() {
+			names = append(names, c.Name)
+		}
+		return append(names, composeCommands...)
+	}
+
+	var candidates []string
+	if positional == 0 {
+		candidates = append(candidates, command.subcommands...)
+	}
+	return append(candidates, argCandidates(command.args)...)
+}
+
+// argCandidates returns the stacks or services a kind of positional argument completes to
+func argCandidates(kind int) []string {
+	if kind == argNone || !fs.IsHomelabRepository() {
+		return nil
+	}
+
+	if kind == argStacks {
+		available, _ := fs.GetAvailableStacks()
+		return available
+	}
+
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return nil
+	}
+	if kind == argEnabledStacks {
+		return enabled
+	}
+
+	var candidates []string
+	if kind == argTargets {
+		candidates = append(candidates, enabled...)
+	}
+	services, err := stacks.GetAllServicesFromStacks(enabled)
+	if err != nil {
+		return candidates
+	}
+	names := make([]string, 0, len(services))
+	for svc := range services {
+		names = append(names, svc)
+	}
+	sort.Strings(names)
+	return append(candidates, names...)
+}
+
+// lookupGlobalFlag returns the global flag of a name
+func lookupGlobalFlag(name string) (globalFlag, bool) {
+	for _, flag := range globalFlags() {
+		if flag.name == name {
+			return flag, true
+		}
+	}
+	return globalFlag{}, false
+}
+
+// contains reports whether a list holds a value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// bashCompletion completes with the candidates of homelabctl __complete, and file names
+// when there are none
+const bashCompletion = `# homelabctl bash completion
+# Load with: source <(homelabctl completion bash)
+_homelabctl() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    local IFS=$'\n'
+    COMPREPLY=($(compgen -W "$(homelabctl __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null)" -- "$cur"))
+}
+complete -o default -F _homelabctl homelabctl
+`
+
+// zshCompletion works from fpath (as _homelabctl) or sourced
+const zshCompletion = `#compdef homelabctl
+# homelabctl zsh completion
+# Load with: source <(homelabctl completion zsh), or save as _homelabctl in $fpath
+_homelabctl() {
+    local -a candidates
+    candidates=(${(f)"$(homelabctl __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    if (( ${#candidates} )); then
+        compadd -a candidates
+    else
+        _files
+    fi
+}
+if [ "$funcstack[1]" = "_homelabctl" ]; then
+    _homelabctl "$@"
+else
+    compdef _homelabctl homelabctl
+fi
+`
+
+// fishCompletion passes the token being completed even when it is empty
+const fishCompletion = `# homelabctl fish completion
+# Load with: homelabctl completion fish | source
+function __homelabctl_complete
+    set -l words (commandline -opc)
+    set -l current (commandline -ct)
+    homelabctl __complete $words[2..-1] "$current" 2>/dev/null
+end
+complete -c homelabctl -f -a '(__homelabctl_complete)'
+`
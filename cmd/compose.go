@@ -3,9 +3,15 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
 	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
 )
 
 // Compose is a passthrough to docker compose for any command
@@ -17,17 +23,157 @@ func Compose(command string, args []string) error {
 	}
 
 	// Build docker compose command
-	cmdArgs := []string{"compose", "-f", paths.DockerCompose, command}
+	cmdArgs := []string{command}
 	cmdArgs = append(cmdArgs, args...)
 
-	cmd := exec.Command("docker", cmdArgs...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin // Allow interactive commands
-
-	if err := cmd.Run(); err != nil {
+	if err := runCompose(cmdArgs...); err != nil {
 		return fmt.Errorf("docker compose %s failed: %w", command, err)
 	}
 
 	return nil
 }
+
+// projectEngine returns the container engine selected with --engine (HOMELAB_ENGINE)
+// for the generated compose file, with overlay files applied on top of it
+func projectEngine(overlays ...string) (engine.Engine, error) {
+	kind := os.Getenv("HOMELAB_ENGINE")
+
+	host, err := selectedHost()
+	if err != nil {
+		return nil, err
+	}
+	if host != nil && kind == engine.KindDockerAPI {
+		return nil, errors.New(
+			"the docker-api engine is not supported with --host",
+			"Use the default engine: --engine compose",
+		).WithClass(errors.ClassUsage)
+	}
+
+	name, err := projectName()
+	if err != nil {
+		return nil, err
+	}
+
+	project := engine.Project{
+		Name:     name,
+		Files:    append([]string{paths.DockerCompose}, overlays...),
+		Commands: hostCommand,
+	}
+
+	// Add --env-file if .env exists in current directory
+	if _, err := os.Stat(".env"); err == nil {
+		project.EnvFile = ".env"
+	}
+
+	if kind == engine.KindDockerAPI {
+		if project.Registries, err = registryAuths(); err != nil {
+			return nil, err
+		}
+	}
+
+	return engine.New(kind, project)
+}
+
+// runCompose runs a compose subcommand against the generated compose file
+func runCompose(args ...string) error {
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+	return e.Compose(args...)
+}
+
+// composeProjectName returns the docker compose project name of the generated file
+// An invalid inventory/compose.yaml falls back to the default; projectEngine reports it
+func composeProjectName() string {
+	name, err := projectName()
+	if err != nil {
+		return defaultProjectName()
+	}
+	return name
+}
+
+// projectName resolves the docker compose project name: --project (HOMELAB_PROJECT),
+// then COMPOSE_PROJECT_NAME, then inventory/compose.yaml for the --env environment,
+// then the default docker compose derives from the compose file's directory
+func projectName() (string, error) {
+	if name := os.Getenv("HOMELAB_PROJECT"); name != "" {
+		if err := inventory.ValidateProjectName(name); err != nil {
+			return "", errors.New(
+				fmt.Sprintf("--project: %v", err),
+				"Use a name like homelab or homelab-staging",
+			).WithClass(errors.ClassUsage)
+		}
+		return name, nil
+	}
+	if name := os.Getenv("COMPOSE_PROJECT_NAME"); name != "" {
+		return name, nil
+	}
+
+	settings, err := inventory.LoadComposeSettings()
+	if err != nil {
+		return "", err
+	}
+	if name := settings.ProjectName(inventory.Environment()); name != "" {
+		return name, nil
+	}
+	return defaultProjectName(), nil
+}
+
+// defaultProjectName is the project name docker compose derives from the compose file's
+// directory
+func defaultProjectName() string {
+	return filepath.Base(filepath.Dir(paths.DockerCompose))
+}
+
+// dockerOutput runs a docker (or podman) command and returns its trimmed standard output
+func dockerOutput(args ...string) (string, error) {
+	cmd, err := dockerCommand(false, args...)
+	if err != nil {
+		return "", err
+	}
+	return engine.Output(cmd)
+}
+
+// resolveStackServices returns the services of a stack that are present in the
+// generated compose file (disabled services are already filtered out there)
+func resolveStackServices(stackName string) ([]string, error) {
+	if !fs.StackExists(stackName) {
+		return nil, errors.New(
+			fmt.Sprintf("stack '%s' does not exist", stackName),
+			"Run: homelabctl list",
+			"Check stacks/ directory for available stacks",
+		).WithClass(errors.ClassNotFound).WithKind(errors.ErrStackNotFound)
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return nil, fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	stackServices, err := stacks.GetServiceNames(stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for _, svc := range stackServices {
+		if _, exists := generated.Services[svc]; exists {
+			services = append(services, svc)
+		}
+	}
+
+	if len(services) == 0 {
+		return nil, errors.New(
+			fmt.Sprintf("stack '%s' has no services in %s", stackName, paths.DockerCompose),
+			fmt.Sprintf("Check that the stack is enabled: homelabctl enable %s", stackName),
+			"Run: homelabctl generate",
+		).WithClass(errors.ClassNotFound)
+	}
+
+	return services, nil
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/secrets"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// varDestination is a file configuration answers can be written to
+type varDestination struct {
+	Name string
+	Vars map[string]interface{} // Current content of the file
+	Base map[string]interface{} // Merged variables of lower precedence
+	Set  func(path string, value interface{}) error
+}
+
+// prompter reads answers from stdin
+type prompter struct {
+	reader *bufio.Reader
+	out    io.Writer
+}
+
+// stdinTerminal reports whether stdin is an interactive terminal
+func stdinTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// configureStack prompts for a stack's vars_schema variables and writes the answers to
+// inventory/vars.yaml or secrets/<stack>.yaml. Only required variables without a value
+// are asked, after confirmation, unless all is set
+// Without a terminal on stdin the missing variables are only listed
+func configureStack(stackName string, all bool) error {
+	stack, err := stacks.LoadStack(stackName)
+	if err != nil {
+		return err
+	}
+	if len(stack.VarsSchema) == 0 {
+		if all {
+			fmt.Printf("%s declares no vars_schema; edit inventory/vars.yaml directly\n", stackName)
+		}
+		return nil
+	}
+
+	inventoryVars, err := inventory.LoadVars()
+	if err != nil {
+		return err
+	}
+
+	// Best effort: secrets may need SOPS, which enabling a stack doesn't require
+	stackSecrets, err := secrets.LoadSecrets(stackName)
+	if err != nil {
+		stackSecrets = nil
+	}
+
+	merged, err := stacks.MergeWithCategoryDefaults(stackName, stack.Vars, inventoryVars, stackSecrets)
+	if err != nil {
+		return err
+	}
+
+	missing := stack.MissingRequiredVars(merged)
+	asked := missing
+	if all {
+		asked = stack.SchemaPaths()
+	}
+	if len(asked) == 0 {
+		return nil
+	}
+
+	if !stdinTerminal() {
+		if all {
+			return errors.New(
+				"enable --configure needs an interactive terminal",
+				"Set the variables in inventory/vars.yaml",
+				"See them with: homelabctl vars example "+stackName,
+			).WithClass(errors.ClassUsage)
+		}
+		fmt.Printf("⚠ %s needs configuration before generate:\n", stackName)
+		printSchemaVars(stack, missing)
+		fmt.Printf("  Set them in inventory/vars.yaml or secrets/%s.yaml (see: homelabctl vars example %s)\n", stackName, stackName)
+		return nil
+	}
+
+	p := &prompter{reader: bufio.NewReader(os.Stdin), out: os.Stdout}
+
+	if !all {
+		fmt.Printf("\n%s needs %d variable(s) without a value:\n", stackName, len(missing))
+		printSchemaVars(stack, missing)
+		ok, err := p.confirm("Configure them now?", true)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Printf("  Configure later with: homelabctl enable --configure %s\n", stackName)
+			return nil
+		}
+	}
+
+	dest, err := chooseVarDestination(p, stackName, stack, inventoryVars, stackSecrets)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nConfigure %s (Enter keeps the value in brackets, or skips):\n", stackName)
+	for _, path := range asked {
+		schema := stack.VarsSchema[path]
+		if schema.Description != "" {
+			fmt.Printf("  %s\n", schema.Description)
+		}
+
+		current, hasCurrent := stacks.LookupVar(merged, path)
+		def := ""
+		if hasCurrent && current != nil {
+			def = fmt.Sprintf("%v", current)
+		}
+		label := path
+		if len(schema.Choices) > 0 {
+			label += " (" + strings.Join(schema.Choices, "/") + ")"
+		}
+
+		for {
+			input, eof, err := p.ask(label, def)
+			if err != nil {
+				return err
+			}
+			if input == "" || input == def {
+				if def == "" {
+					fmt.Printf("  Skipped %s\n", path)
+				}
+				break
+			}
+
+			value, err := schema.ParseValue(input)
+			if err != nil {
+				fmt.Printf("  ⨯ %v\n", err)
+				if eof {
+					break
+				}
+				continue
+			}
+
+			if err := dest.write(path, value); err != nil {
+				return err
+			}
+			ui.Detail("✓ Set %s in %s", path, dest.Name)
+			break
+		}
+	}
+
+	return nil
+}
+
+// chooseVarDestination asks where answers go: inventory/vars.yaml or the stack's
+// secrets file, written in its format (secrets/<stack>.yaml when it has none)
+func chooseVarDestination(p *prompter, stackName string, stack *stacks.Stack, inventoryVars, stackSecrets map[string]interface{}) (*varDestination, error) {
+	base, err := stacks.MergeWithCategoryDefaults(stackName, stack.Vars, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	destinations := []*varDestination{{
+		Name: paths.InventoryVars,
+		Vars: inventoryVars,
+		Base: base,
+		Set:  inventory.SetVar,
+	}}
+
+	secretsFile, _ := secrets.File(stackName)
+	if secretsFile == "" {
+		secretsFile = paths.SecretsFilePath(stackName, paths.SecretsExt)
+	}
+	secretsBase, err := stacks.MergeWithCategoryDefaults(stackName, stack.Vars, inventoryVars, nil)
+	if err != nil {
+		return nil, err
+	}
+	destinations = append(destinations, &varDestination{
+		Name: secretsFile,
+		Vars: stackSecrets,
+		Base: secretsBase,
+		Set: func(path string, value interface{}) error {
+			return secrets.SetVar(stackName, path, value)
+		},
+	})
+
+	names := make([]string, len(destinations))
+	for i, dest := range destinations {
+		names[i] = dest.Name
+	}
+	choice, err := p.choose("Write answers to", names)
+	if err != nil {
+		return nil, err
+	}
+	return destinations[choice], nil
+}
+
+// write sets a variable in the destination file
+// A top-level key replaces the whole block of lower precedence, so that block is
+// copied first when the file doesn't have it yet
+func (d *varDestination) write(path string, value interface{}) error {
+	if d.Vars == nil {
+		d.Vars = make(map[string]interface{})
+	}
+
+	top := strings.Split(path, ".")[0]
+	if _, exists := d.Vars[top]; !exists && top != path {
+		if block, ok := d.Base[top].(map[string]interface{}); ok {
+			if err := d.Set(top, block); err != nil {
+				return err
+			}
+		}
+		d.Vars[top] = true // Copied once
+	}
+
+	return d.Set(path, value)
+}
+
+// printSchemaVars lists variables with their descriptions
+func printSchemaVars(stack *stacks.Stack, vars []string) {
+	for _, path := range vars {
+		if description := stack.VarsSchema[path].Description; description != "" {
+			fmt.Printf("  - %s: %s\n", path, description)
+		} else {
+			fmt.Printf("  - %s\n", path)
+		}
+	}
+}
+
+// ask prints a question with its default and returns the trimmed answer
+// eof is set when stdin has no more input
+func (p *prompter) ask(question, def string) (string, bool, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "  %s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "  %s: ", question)
+	}
+
+	line, err := p.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, fmt.Errorf("failed to read input: %w", err)
+	}
+	if err == io.EOF {
+		fmt.Fprintln(p.out)
+	}
+	return strings.TrimSpace(line), err == io.EOF, nil
+}
+
+// confirm asks a yes/no question
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+
+	for {
+		answer, eof, err := p.ask(question+" ["+hint+"]", "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		if eof {
+			return def, nil
+		}
+	}
+}
+
+// choose asks to pick one of the options by number; Enter picks the first
+func (p *prompter) choose(question string, options []string) (int, error) {
+	fmt.Fprintf(p.out, "  %s:\n", question)
+	for i, option := range options {
+		fmt.Fprintf(p.out, "    %d) %s\n", i+1, option)
+	}
+
+	for {
+		answer, eof, err := p.ask("Choice", "1")
+		if err != nil {
+			return 0, err
+		}
+		if answer == "" {
+			return 0, nil
+		}
+		var n int
+		if _, err := fmt.Sscanf(answer, "%d", &n); err == nil && n >= 1 && n <= len(options) {
+			return n - 1, nil
+		}
+		fmt.Fprintf(p.out, "  ⨯ Enter a number between 1 and %d\n", len(options))
+		if eof {
+			return 0, nil
+		}
+	}
+}
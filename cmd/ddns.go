@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/ddns"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/secrets"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// ddnsSecrets names the secrets file holding the DNS provider's API token
+const ddnsSecrets = "ddns"
+
+// defaultDDNSInterval is how often ddns watch checks the WAN IP
+const defaultDDNSInterval = 5 * time.Minute
+
+// DDNS points the public DNS records of the exposed host names at the WAN IP, once or,
+// with watch, whenever the WAN IP changes
+func DDNS(args []string) error {
+	usage := "usage: homelabctl ddns [--dry-run] | ddns watch [--interval <duration>]"
+
+	if len(args) > 0 && args[0] == "watch" {
+		interval := defaultDDNSInterval
+		rest := args[1:]
+		for i := 0; i < len(rest); i++ {
+			name, value, hasValue := strings.Cut(rest[i], "=")
+			if name != "--interval" {
+				return fmt.Errorf("unexpected argument: %s (%s)", rest[i], usage)
+			}
+			if !hasValue {
+				if i+1 >= len(rest) {
+					return fmt.Errorf("--interval requires a value (%s)", usage)
+				}
+				value = rest[i+1]
+				i++
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < time.Minute {
+				return fmt.Errorf("invalid --interval value: %s (at least 1m, e.g. 5m)", value)
+			}
+			interval = d
+		}
+		return ddnsWatch(interval)
+	}
+
+	dryRun := false
+	for _, arg := range args {
+		if arg != "--dry-run" {
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+		dryRun = true
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+	_, err := ddnsSync(dryRun)
+	return err
+}
+
+// ddnsWatch syncs the records at start, then again each time the WAN IP changes,
+// until interrupted; a failed sync is retried at the next interval
+func ddnsWatch(interval time.Duration) error {
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Watching the WAN IP every %s (Ctrl+C to stop)\n", interval)
+
+	synced := ""
+	for {
+		ip, err := ddnsSync(false)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			synced = ip
+		}
+
+		for {
+			time.Sleep(interval)
+
+			settings, _, err := ddnsSettings()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				continue
+			}
+			current, err := ddns.PublicIP(settings.IPURL)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				continue
+			}
+			if current != synced {
+				fmt.Printf("%s WAN IP changed: %s\n", time.Now().Format("2006-01-02 15:04:05"), current)
+				break
+			}
+		}
+	}
+}
+
+// ddnsSync points the records at the current WAN IP, printing each change, and returns the IP
+// Every record is tried; the error lists the ones that failed
+func ddnsSync(dryRun bool) (string, error) {
+	settings, token, err := ddnsSettings()
+	if err != nil {
+		return "", err
+	}
+
+	routes, err := pipeline.ExposedRoutes()
+	if err != nil {
+		return "", err
+	}
+	// Tunnel and tailnet routes do not reach the homelab through the WAN IP
+	var exposed []string
+	for _, route := range routes {
+		if route.Via == "" {
+			exposed = append(exposed, route.Host)
+		}
+	}
+
+	hosts, skipped := settings.Hosts(exposed)
+	if len(skipped) > 0 {
+		addWarnings(fmt.Sprintf("not in DNS zone %s, left as is: %s", settings.Zone, strings.Join(skipped, ", ")))
+	}
+	if len(hosts) == 0 {
+		return "", errors.New(
+			"no host names to update",
+			"Expose services in stack.yaml, or list host names in ddns.records of inventory/vars.yaml",
+		).WithClass(errors.ClassNotFound)
+	}
+
+	ip, err := ddns.PublicIP(settings.IPURL)
+	if err != nil {
+		return "", err
+	}
+
+	client := ddns.NewClient(settings, token)
+	var failed []string
+	changed := 0
+	for _, host := range hosts {
+		update, err := client.Sync(host, ip, dryRun)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", host, err))
+			continue
+		}
+		if !update.Changed {
+			continue
+		}
+		changed++
+
+		previous := update.Previous
+		if previous == "" {
+			previous = "new record"
+		}
+		if dryRun {
+			fmt.Printf("  ~ %s -> %s (%s)\n", host, ip, previous)
+		} else {
+			ui.Success("%s -> %s (%s)", host, ip, previous)
+		}
+	}
+
+	if len(failed) > 0 {
+		return "", errors.New(
+			fmt.Sprintf("failed to update %d DNS record(s): %s", len(failed), strings.Join(failed, "; ")),
+			fmt.Sprintf("Check the token in %s and its permissions on %s", paths.SecretsFilePath(ddnsSecrets, paths.SecretsEncExt), settings.Zone),
+		).WithClass(errors.ClassValidation)
+	}
+
+	switch {
+	case dryRun:
+		fmt.Printf("Dry run: %d of %d record(s) would point at %s\n", changed, len(hosts), ip)
+	case changed == 0:
+		fmt.Printf("%d record(s) already point at %s\n", len(hosts), ip)
+	}
+	return ip, nil
+}
+
+// ddnsSettings loads the ddns section of inventory/vars.yaml and the provider's API token
+// from secrets/ddns.enc.yaml
+func ddnsSettings() (ddns.Settings, string, error) {
+	vars, err := inventory.LoadVars()
+	if err != nil {
+		return ddns.Settings{}, "", err
+	}
+
+	settings, ok, err := ddns.Load(vars)
+	if !ok {
+		return settings, "", errors.New(
+			"dynamic DNS is not configured",
+			"Add a ddns section to inventory/vars.yaml (provider: cloudflare or desec)",
+		).WithClass(errors.ClassNotFound)
+	}
+	if err != nil {
+		return settings, "", errors.New(err.Error(), "Check the ddns section of inventory/vars.yaml").WithClass(errors.ClassValidation)
+	}
+
+	values, err := secrets.LoadSecrets(ddnsSecrets)
+	if err != nil {
+		return settings, "", err
+	}
+	token, _ := values["token"].(string)
+	if token == "" {
+		return settings, "", errors.New(
+			"no DNS provider API token",
+			fmt.Sprintf("Set token in %s", paths.SecretsFilePath(ddnsSecrets, paths.SecretsEncExt)),
+		).WithClass(errors.ClassNotFound)
+	}
+
+	return settings, token, nil
+}
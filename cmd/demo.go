@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/monkeymonk/homelabctl/internal/demo"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Demo materializes throwaway demo stacks into a temp repository and walks
+// through enable → generate → deploy
+func Demo(args []string) error {
+	deploy := true
+
+	for _, arg := range args {
+		switch arg {
+		case "--no-deploy":
+			deploy = false
+		default:
+			return fmt.Errorf("unexpected argument: %s", arg)
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "homelabctl-demo-*")
+	if err != nil {
+		return fmt.Errorf("failed to create demo directory: %w", err)
+	}
+
+	originalDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		return fmt.Errorf("failed to enter demo directory: %w", err)
+	}
+	defer os.Chdir(originalDir)
+
+	// Keep demo containers apart from a real deployment's compose project
+	os.Setenv("COMPOSE_PROJECT_NAME", demo.ProjectName)
+
+	fmt.Printf("Demo repository: %s\n", dir)
+
+	fmt.Println("\n[1/4] homelabctl init")
+	if err := fs.InitializeRepository(); err != nil {
+		return fmt.Errorf("failed to initialize demo repository: %w", err)
+	}
+	if err := demo.Materialize("."); err != nil {
+		return err
+	}
+	ui.Success("Created repository with demo stacks: whoami, hello")
+
+	fmt.Println("\n[2/4] homelabctl enable")
+	for _, stackName := range demo.Stacks() {
+		if err := Enable([]string{stackName}); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("\n[3/4] homelabctl generate")
+	if err := Generate(); err != nil {
+		return err
+	}
+
+	fmt.Println("\n[4/4] homelabctl deploy")
+	if !deploy {
+		fmt.Println("Skipped (--no-deploy)")
+	} else if _, err := exec.LookPath("docker"); err != nil {
+		fmt.Println("Skipped: docker not found in PATH")
+	} else {
+		if err := deployDemo(); err != nil {
+			return fmt.Errorf("docker compose failed: %w", err)
+		}
+		ui.Blank()
+		ui.Success("Deployment complete")
+		showDeployNotes(demo.Stacks())
+	}
+
+	fmt.Println("\nExplore the demo:")
+	fmt.Printf("  cd %s\n", dir)
+	fmt.Println("  cat runtime/docker-compose.yml")
+	fmt.Printf("  COMPOSE_PROJECT_NAME=%s homelabctl ps\n", demo.ProjectName)
+	fmt.Println("\nClean up:")
+	fmt.Printf("  cd %s && COMPOSE_PROJECT_NAME=%s homelabctl down\n", dir, demo.ProjectName)
+	fmt.Printf("  rm -rf %s\n", dir)
+
+	return nil
+}
+
+// deployDemo starts the demo's containers
+func deployDemo() error {
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+	return e.Up(nil, engine.UpOptions{})
+}
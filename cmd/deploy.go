@@ -3,39 +3,556 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"sort"
+	"strings"
+	"time"
 
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/lock"
 	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
 )
 
+// deployWave is the set of services of one category, started together
+type deployWave struct {
+	Category string
+	Services []string
+}
+
 // Deploy generates runtime files and deploys using docker compose
-func Deploy() error {
-	// Step 1: Run generate
-	if err := Generate(); err != nil {
+// --waves runs one docker compose up per category (in category order), waiting for
+// each wave to be healthy before starting the next
+// --at and --window delay the whole deploy (generate included) to a maintenance window
+// --from-bundle deploys the runtime tree and images of a bundle instead of generating
+// --dry-run shows the containers a deploy would create, recreate or start, applying nothing
+// Afterwards, deploy waits for the services with a healthcheck to be healthy and fails
+// on the ones that are not within their stack's health_timeout (--no-health-check skips it)
+// The stacks' pre-deploy hooks run before docker compose, their post-deploy hooks once
+// the services are up (and healthy)
+// Given stacks, only they are regenerated and only their services are brought up
+func Deploy(args []string) error {
+	usage := "usage: homelabctl deploy [stack...] [--waves] [--at HH:MM | --window HH:MM-HH:MM] [--from-bundle <file>] [--dry-run] [--no-health-check]"
+	waves := false
+	dryRun := false
+	healthCheck := true
+	var at, window, fromBundle string
+	var selected []string
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--waves":
+			waves = true
+		case arg == "--dry-run":
+			dryRun = true
+		case arg == "--no-health-check":
+			healthCheck = false
+		case arg == "--at" || arg == "--window" || arg == "--from-bundle":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value (%s)", arg, usage)
+			}
+			switch arg {
+			case "--at":
+				at = args[i+1]
+			case "--window":
+				window = args[i+1]
+			default:
+				fromBundle = args[i+1]
+			}
+			i++
+		case strings.HasPrefix(arg, "--at="):
+			at = strings.TrimPrefix(arg, "--at=")
+		case strings.HasPrefix(arg, "--window="):
+			window = strings.TrimPrefix(arg, "--window=")
+		case strings.HasPrefix(arg, "--from-bundle="):
+			fromBundle = strings.TrimPrefix(arg, "--from-bundle=")
+		case !strings.HasPrefix(arg, "-"):
+			selected = append(selected, arg)
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if at != "" && window != "" {
+		return fmt.Errorf("--at and --window cannot be combined")
+	}
+	if dryRun && (at != "" || window != "" || fromBundle != "") {
+		return fmt.Errorf("--dry-run cannot be combined with --at, --window or --from-bundle")
+	}
+	if len(selected) > 0 && fromBundle != "" {
+		return fmt.Errorf("stacks cannot be combined with --from-bundle: a bundle deploys its whole runtime tree")
+	}
+	if dryRun {
+		return deployDryRun(selected)
+	}
+
+	// Generating early would already apply hot-reloaded files (e.g. Traefik dynamic
+	// config), so nothing happens before the scheduled time
+	switch {
+	case at != "":
+		clock, err := parseClock(at)
+		if err != nil {
+			return err
+		}
+		waitUntil(nextAt(time.Now(), clock))
+	case window != "":
+		start, end, err := parseWindow(window)
+		if err != nil {
+			return err
+		}
+		waitUntil(windowStart(time.Now(), start, end))
+	}
+
+	// Hold the lock across generate and docker compose
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Step 1: Run generate, or unpack the bundle's runtime tree and images
+	if fromBundle != "" {
+		if err := loadBundle(fromBundle); err != nil {
+			return err
+		}
+		if err := syncRuntime(); err != nil {
+			return err
+		}
+	} else {
+		if err := Generate(selected...); err != nil {
+			return err
+		}
+
+		// Log in to private registries; a bundle brings its images
+		if err := registryLogin(nil); err != nil {
+			return err
+		}
+	}
+
+	// Step 2: Start the containers, only the selected stacks' if any
+	var services []string
+	if len(selected) > 0 {
+		generated, err := compose.LoadComposeFile(paths.DockerCompose)
+		if err != nil {
+			return err
+		}
+		// An empty service list would bring up every service
+		if services = stackServices(generated, selected); len(services) == 0 {
+			ui.Blank()
+			ui.Step("No services to deploy in %s", strings.Join(selected, ", "))
+			return nil
+		}
+	}
+
+	if err := runDeployHooks(stacks.HookPreDeploy, selected); err != nil {
 		return err
 	}
 
-	fmt.Println("\nDeploying with docker compose...")
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+	if waves {
+		if err := deployInWaves(e, services); err != nil {
+			return err
+		}
+	} else {
+		ui.Blank()
+		ui.Step("Deploying with docker compose...")
 
-	// Step 2: Run docker compose
-	// Check if .env file exists and pass it explicitly
-	args := []string{"compose", "-f", paths.DockerCompose}
+		if err := e.Up(services, engine.UpOptions{}); err != nil {
+			return fmt.Errorf("docker compose failed: %w", err)
+		}
+	}
+
+	ui.Blank()
+	ui.Success("Deployment complete")
 
-	// Add --env-file if .env exists in current directory
-	if _, err := os.Stat(".env"); err == nil {
-		args = append(args, "--env-file", ".env")
+	// Recorded for the last-deploy badge
+	if err := inventory.MarkDeployed(); err != nil {
+		addWarnings(fmt.Sprintf("failed to record the deploy time: %v", err))
 	}
 
-	args = append(args, "up", "-d")
+	if healthCheck {
+		if err := verifyDeployHealth(services); err != nil {
+			return err
+		}
+	}
 
-	cmd := exec.Command("docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if err := runDeployHooks(stacks.HookPostDeploy, selected); err != nil {
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker compose failed: %w", err)
+	// Print post-install notes of newly deployed stacks
+	if len(selected) > 0 {
+		showDeployNotes(selected)
+	} else if enabled, err := fs.GetEnabledStacks(); err == nil {
+		showDeployNotes(enabled)
 	}
 
-	fmt.Println("\n✓ Deployment complete")
 	return nil
 }
+
+// Changes a deploy makes to a service's containers
+const (
+	changeCreate   = "create"   // No container yet
+	changeRecreate = "recreate" // Image, config or definition changed
+	changeStart    = "start"    // Container stopped, otherwise current
+	changeOrphan   = "orphan"   // Container of a service no longer generated, left as is
+)
+
+// deployChange is what a deploy would do with one service
+type deployChange struct {
+	Service string
+	Action  string
+	Reasons []string
+}
+
+// deployDryRun runs the generate pipeline without writing runtime/, then compares the
+// result with the running containers to show what a deploy would change
+// Given stacks, only the changes to their services are shown
+func deployDryRun(selected []string) error {
+	// Hold the lock while the pipeline uses the staging dir
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	planned, err := generatePlan(selected)
+	if err != nil {
+		return err
+	}
+
+	// The last generated file is what the containers were deployed from
+	var previous *compose.ComposeFile
+	if _, err := os.Stat(paths.DockerCompose); err == nil {
+		if previous, err = compose.LoadComposeFile(paths.DockerCompose); err != nil {
+			return err
+		}
+	}
+
+	states, err := containerStates()
+	if err != nil {
+		return err
+	}
+
+	imageIDs := make(map[string]string)
+	imageID := func(image string) string {
+		if id, ok := imageIDs[image]; ok {
+			return id
+		}
+		id, _ := dockerOutput("image", "inspect", "--format", "{{.Id}}", image)
+		imageIDs[image] = id
+		return id
+	}
+
+	changes := planDeployChanges(planned, previous, states, imageID)
+	var inScope map[string]bool
+	if len(selected) > 0 {
+		inScope = make(map[string]bool)
+		for _, svc := range stackServices(planned, selected) {
+			inScope[svc] = true
+		}
+		// Services a selected stack no longer generates
+		if previous != nil {
+			for _, svc := range stackServices(previous, selected) {
+				inScope[svc] = true
+			}
+		}
+		changes = filterChanges(changes, inScope)
+	}
+	byService := make(map[string]deployChange)
+	for _, c := range changes {
+		byService[c.Service] = c
+	}
+
+	fmt.Println("\nDeploy plan (dry run, nothing applied):")
+	counts := make(map[string]int)
+	for _, wave := range categoryWaves(planned) {
+		var lines []string
+		for _, svc := range wave.Services {
+			if inScope != nil && !inScope[svc] {
+				continue
+			}
+			c, ok := byService[svc]
+			if !ok {
+				counts["unchanged"]++
+				continue
+			}
+			counts[c.Action]++
+			lines = append(lines, fmt.Sprintf("    %s %-24s %s", changeSymbol(c.Action), svc, describeChange(c)))
+		}
+		if len(lines) > 0 {
+			fmt.Printf("  %s\n%s\n", wave.Category, strings.Join(lines, "\n"))
+		}
+	}
+
+	var orphans []string
+	for _, c := range changes {
+		if c.Action == changeOrphan {
+			counts[changeOrphan]++
+			orphans = append(orphans, fmt.Sprintf("    %s %-24s %s", changeSymbol(c.Action), c.Service, describeChange(c)))
+		}
+	}
+	if len(orphans) > 0 {
+		fmt.Printf("  not generated\n%s\n", strings.Join(orphans, "\n"))
+	}
+
+	fmt.Printf("\n%d to create, %d to recreate, %d to start, %d unchanged, %d orphaned\n",
+		counts[changeCreate], counts[changeRecreate], counts[changeStart], counts["unchanged"], counts[changeOrphan])
+	return nil
+}
+
+// planDeployChanges compares the services of a generated compose file with their
+// containers: a container is recreated when its image, its stack's config hash or
+// its service definition (compared with the previously generated file) changed
+// imageID returns the ID an image reference points to locally ("" if not pulled)
+func planDeployChanges(planned, previous *compose.ComposeFile, states map[string][]containerState, imageID func(string) string) []deployChange {
+	images := compose.ServiceImages(planned, nil)
+
+	services := make([]string, 0, len(planned.Services))
+	for svc := range planned.Services {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+
+	var changes []deployChange
+	for _, svc := range services {
+		containers := states[svc]
+		if len(containers) == 0 {
+			changes = append(changes, deployChange{Service: svc, Action: changeCreate})
+			continue
+		}
+
+		var reasons []string
+		if image, ok := images[svc]; ok {
+			want := imageID(image)
+			switch {
+			case want == "":
+				reasons = append(reasons, "image "+image+" not pulled yet")
+			case containers[0].Image != want:
+				reasons = append(reasons, "image "+image+" changed")
+			}
+		}
+
+		wantHash := compose.ServiceLabels(planned, svc)[compose.LabelConfigHash]
+		if containers[0].ConfigHash != wantHash {
+			reasons = append(reasons, "config changed")
+		} else if previous != nil && !sameServiceDefinition(planned, previous, svc) {
+			// A config change also changes the definition's label; report it once
+			reasons = append(reasons, "definition changed")
+		}
+
+		running := true
+		for _, c := range containers {
+			if c.Status != "running" && !(c.Status == "exited" && c.ExitCode == "0") {
+				running = false
+			}
+		}
+
+		switch {
+		case len(reasons) > 0:
+			changes = append(changes, deployChange{Service: svc, Action: changeRecreate, Reasons: reasons})
+		case !running:
+			changes = append(changes, deployChange{Service: svc, Action: changeStart, Reasons: []string{containers[0].Status}})
+		}
+	}
+
+	var orphans []string
+	for svc := range states {
+		if _, ok := planned.Services[svc]; !ok {
+			orphans = append(orphans, svc)
+		}
+	}
+	sort.Strings(orphans)
+	for _, svc := range orphans {
+		changes = append(changes, deployChange{Service: svc, Action: changeOrphan})
+	}
+
+	return changes
+}
+
+// filterChanges returns the changes to some services
+func filterChanges(changes []deployChange, services map[string]bool) []deployChange {
+	var filtered []deployChange
+	for _, c := range changes {
+		if services[c.Service] {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// stackServices returns the sorted services of a compose file labeled with one of the stacks
+func stackServices(generated *compose.ComposeFile, stackNames []string) []string {
+	wanted := make(map[string]bool, len(stackNames))
+	for _, name := range stackNames {
+		wanted[name] = true
+	}
+
+	var services []string
+	for svc := range generated.Services {
+		if wanted[compose.ServiceLabels(generated, svc)[compose.LabelStack]] {
+			services = append(services, svc)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// sameServiceDefinition reports whether a service is defined identically in two compose files
+func sameServiceDefinition(a, b *compose.ComposeFile, svc string) bool {
+	defA, okA := a.Services[svc]
+	defB, okB := b.Services[svc]
+	if !okA || !okB {
+		return okA == okB
+	}
+
+	dataA, errA := yaml.Marshal(defA)
+	dataB, errB := yaml.Marshal(defB)
+	return errA == nil && errB == nil && string(dataA) == string(dataB)
+}
+
+// changeSymbol marks a change in the deploy plan
+func changeSymbol(action string) string {
+	switch action {
+	case changeCreate:
+		return "+"
+	case changeRecreate:
+		return "~"
+	case changeOrphan:
+		return "-"
+	default:
+		return ">"
+	}
+}
+
+// describeChange explains a change in the deploy plan
+func describeChange(c deployChange) string {
+	switch c.Action {
+	case changeCreate:
+		return "create (no container)"
+	case changeOrphan:
+		return "orphaned: not generated anymore, deploy leaves its container as is"
+	default:
+		return fmt.Sprintf("%s (%s)", c.Action, strings.Join(c.Reasons, ", "))
+	}
+}
+
+// deployInWaves starts each category's services and waits for them to be healthy, in order
+// Stops at the first wave that fails, then prints a summary of every wave
+// only limits the waves to some services (all when empty)
+func deployInWaves(e engine.Engine, only []string) error {
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	waves := categoryWaves(generated)
+	if len(only) > 0 {
+		waves = filterWaves(waves, only)
+	}
+	ui.Blank()
+	ui.Step("Deploying in %d wave(s)...", len(waves))
+
+	results := make([]string, 0, len(waves))
+	var failed error
+
+	for i, wave := range waves {
+		if failed != nil {
+			results = append(results, fmt.Sprintf("  - %-16s skipped", wave.Category))
+			continue
+		}
+
+		ui.Blank()
+		ui.Step("[%d/%d] %s: %s", i+1, len(waves), wave.Category, strings.Join(wave.Services, ", "))
+
+		// Wait blocks until the wave's containers are running and healthy
+		start := time.Now()
+		if err := e.Up(wave.Services, engine.UpOptions{Wait: true}); err != nil {
+			failed = fmt.Errorf("wave %s failed: %w", wave.Category, err)
+			results = append(results, fmt.Sprintf("  ✗ %-16s failed after %s", wave.Category, time.Since(start).Round(time.Second)))
+			continue
+		}
+
+		results = append(results, fmt.Sprintf("  ✓ %-16s %d service(s) healthy in %s",
+			wave.Category, len(wave.Services), time.Since(start).Round(time.Second)))
+	}
+
+	ui.Blank()
+	ui.Step("Wave summary:")
+	for _, line := range results {
+		fmt.Println(line)
+	}
+
+	return failed
+}
+
+// filterWaves keeps the given services in their waves, dropping the waves left empty
+func filterWaves(waves []deployWave, services []string) []deployWave {
+	keep := make(map[string]bool, len(services))
+	for _, svc := range services {
+		keep[svc] = true
+	}
+
+	var filtered []deployWave
+	for _, wave := range waves {
+		var kept []string
+		for _, svc := range wave.Services {
+			if keep[svc] {
+				kept = append(kept, svc)
+			}
+		}
+		if len(kept) > 0 {
+			filtered = append(filtered, deployWave{Category: wave.Category, Services: kept})
+		}
+	}
+	return filtered
+}
+
+// categoryWaves groups the generated services by their category label, in category order
+// Services without a category label are deployed in a last wave
+func categoryWaves(generated *compose.ComposeFile) []deployWave {
+	byCategory := make(map[string][]string)
+	for svc := range generated.Services {
+		category := compose.ServiceLabels(generated, svc)[compose.LabelCategory]
+		byCategory[category] = append(byCategory[category], svc)
+	}
+
+	names := make([]string, 0, len(byCategory))
+	for name := range byCategory {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		// Unlabeled services go last
+		if names[i] == "" || names[j] == "" {
+			return names[j] == ""
+		}
+		if oi, oj := categories.GetOrder(names[i]), categories.GetOrder(names[j]); oi != oj {
+			return oi < oj
+		}
+		return names[i] < names[j]
+	})
+
+	waves := make([]deployWave, 0, len(names))
+	for _, name := range names {
+		services := byCategory[name]
+		sort.Strings(services)
+
+		category := name
+		if category == "" {
+			category = "unlabeled"
+		}
+		waves = append(waves, deployWave{Category: category, Services: services})
+	}
+
+	return waves
+}
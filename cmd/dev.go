@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// Dev runs docker compose watch on a stack's services, with the develop.watch rules
+// from the develop section of its stack.yaml applied through a compose overlay
+func Dev(args []string) error {
+	usage := "usage: homelabctl dev <stack>"
+
+	var stackName string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+		case stackName == "":
+			stackName = arg
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+	if stackName == "" {
+		return fmt.Errorf("%s", usage)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	// Watched paths are on this machine; a remote daemon could not sync them
+	host, err := selectedHost()
+	if err != nil {
+		return err
+	}
+	if host != nil {
+		return errors.New(
+			"dev is not supported with --host",
+			"Run it on the machine holding the stack sources",
+		).WithClass(errors.ClassUsage)
+	}
+
+	if !fs.IsStackEnabled(stackName) {
+		return errors.New(
+			fmt.Sprintf("stack '%s' is not enabled", stackName),
+			fmt.Sprintf("Run: homelabctl enable %s", stackName),
+		).WithClass(errors.ClassNotFound)
+	}
+
+	stack, err := stacks.LoadStack(stackName)
+	if err != nil {
+		return err
+	}
+	if len(stack.Develop) == 0 {
+		return errors.New(
+			fmt.Sprintf("stack '%s' has no develop section", stackName),
+			fmt.Sprintf("Add watch rules to stacks/%s/stack.yaml:", stackName),
+			"  develop:",
+			"    <service>:",
+			"      - path: ./src",
+			"        target: /app",
+		).WithClass(errors.ClassValidation)
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	overlay, services, err := devOverlay(stack, generated)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(paths.RuntimeStackDir(stackName), paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create %s: %w", paths.RuntimeStackDir(stackName), err)
+	}
+	if err := compose.WriteComposeFile(paths.DevOverride(stackName), overlay); err != nil {
+		return err
+	}
+
+	e, err := projectEngine(paths.DevOverride(stackName))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Watching %s: %s (Ctrl+C to stop)\n", stackName, strings.Join(services, ", "))
+	if err := e.Compose(append([]string{"watch"}, services...)...); err != nil {
+		return fmt.Errorf("docker compose watch failed: %w", err)
+	}
+	return nil
+}
+
+// devOverlay builds the compose overlay adding develop.watch sections to a stack's
+// services, and returns the watched services sorted
+// Watch paths are absolute, since compose resolves relative ones against runtime/
+func devOverlay(stack *stacks.Stack, generated *compose.ComposeFile) (*compose.ComposeFile, []string, error) {
+	overlay := &compose.ComposeFile{Services: make(map[string]interface{})}
+
+	var services []string
+	for service, rules := range stack.Develop {
+		if _, ok := generated.Services[service]; !ok {
+			return nil, nil, errors.New(
+				fmt.Sprintf("service '%s' is not in %s", service, paths.DockerCompose),
+				"Run: homelabctl generate",
+				"Check that the service is not disabled",
+			).WithClass(errors.ClassNotFound)
+		}
+
+		watch := make([]interface{}, 0, len(rules))
+		for _, rule := range rules {
+			path, err := stack.WatchPath(rule)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			entry := map[string]interface{}{"path": path, "action": rule.Action}
+			if rule.Target != "" {
+				entry["target"] = rule.Target
+			}
+			if len(rule.Ignore) > 0 {
+				entry["ignore"] = rule.Ignore
+			}
+			watch = append(watch, entry)
+		}
+
+		overlay.Services[service] = map[string]interface{}{
+			"develop": map[string]interface{}{"watch": watch},
+		}
+		services = append(services, service)
+	}
+
+	sort.Strings(services)
+	return overlay, services, nil
+}
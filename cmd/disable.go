@@ -2,23 +2,34 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/monkeymonk/homelabctl/internal/errors"
 	"github.com/monkeymonk/homelabctl/internal/fs"
 	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/lock"
 	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
 )
 
 // Disable disables a stack or service
+// A stack other enabled stacks require is only disabled with --cascade, which disables
+// them first, or --force, which leaves them enabled
 func Disable(args []string) error {
 	// Parse flags
 	isService := false
+	cascade := false
+	force := false
 	var name string
 
 	for i := 0; i < len(args); i++ {
 		switch args[i] {
 		case "-s", "--service":
 			isService = true
+		case "--cascade":
+			cascade = true
+		case "--force":
+			force = true
 		default:
 			if name == "" {
 				name = args[i]
@@ -32,78 +43,114 @@ func Disable(args []string) error {
 		if isService {
 			return fmt.Errorf("usage: homelabctl disable -s <service>")
 		}
-		return fmt.Errorf("usage: homelabctl disable <stack>")
+		return fmt.Errorf("usage: homelabctl disable <stack> [--cascade | --force]")
+	}
+	if cascade && force {
+		return fmt.Errorf("--cascade and --force are mutually exclusive")
+	}
+	if isService && (cascade || force) {
+		return fmt.Errorf("--cascade and --force apply to stacks, not services")
 	}
 
 	if err := fs.VerifyRepository(); err != nil {
 		return err
 	}
 
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	if isService {
 		return disableService(name)
 	}
-	return disableStack(name)
+	return disableStackWithDependents(name, cascade, force)
 }
 
-func disableStack(stackName string) error {
-	// Disable the stack
-	if err := fs.DisableStack(stackName); err != nil {
-		return err
+// disableStackWithDependents disables a stack after checking the enabled stacks
+// requiring it: refused by default, disabled first with cascade, left enabled with force
+func disableStackWithDependents(stackName string, cascade, force bool) error {
+	if !fs.IsStackEnabled(stackName) {
+		return disableStack(stackName) // Fails with the reason
 	}
 
-	fmt.Printf("✓ Disabled stack: %s\n", stackName)
-	fmt.Println("  Warning: This does not check if other stacks depend on this one")
-	return nil
-}
-
-func disableService(serviceName string) error {
-	// Get enabled stacks
 	enabled, err := fs.GetEnabledStacks()
 	if err != nil {
 		return err
 	}
+	dependents, err := stacks.EnabledDependents(stackName, enabled)
+	if err != nil {
+		return err
+	}
 
-	// Check if service exists in any enabled stack
-	exists, stackName := stacks.ServiceExists(serviceName, enabled)
-	if !exists {
-		// Get all available services
-		allServices, err := stacks.GetAllServicesFromStacks(enabled)
-		if err != nil {
-			return err
+	switch {
+	case len(dependents) == 0:
+	case cascade:
+		// Dependents of dependents first
+		for i := len(dependents) - 1; i >= 0; i-- {
+			if err := disableStack(dependents[i]); err != nil {
+				return err
+			}
 		}
+	case force:
+		addWarnings(fmt.Sprintf("stack %s was disabled but is required by enabled stacks: %s",
+			stackName, strings.Join(dependents, ", ")))
+	default:
+		return errors.New(
+			fmt.Sprintf("stack '%s' is required by enabled stacks: %s", stackName, strings.Join(dependents, ", ")),
+			fmt.Sprintf("Disable them too: homelabctl disable --cascade %s", stackName),
+			fmt.Sprintf("Or disable it anyway: homelabctl disable --force %s", stackName),
+		).WithContext(
+			"Enabled stacks requiring it, directly or not:",
+			"  "+strings.Join(dependents, ", "),
+		).WithClass(errors.ClassDependency)
+	}
 
-		suggestions := []string{
-			"Run: homelabctl list",
-			"Check that the service's stack is enabled",
-		}
+	if err := disableStack(stackName); err != nil {
+		return err
+	}
+	if cascade && len(dependents) > 0 {
+		ui.Success("Disabled %d stacks: %s and its dependents", len(dependents)+1, stackName)
+	}
+	return nil
+}
 
-		context := []string{
-			"Available services in enabled stacks:",
-		}
-		for svc, stack := range allServices {
-			context = append(context, fmt.Sprintf("  - %s (from %s)", svc, stack))
-		}
+func disableStack(stackName string) error {
+	// Disable the stack
+	if err := fs.DisableStack(stackName); err != nil {
+		return err
+	}
 
-		return errors.New(
-			fmt.Sprintf("service '%s' not found in enabled stacks", serviceName),
-			suggestions...,
-		).WithContext(context...)
+	if err := inventory.MarkStackDisabled(stackName); err != nil {
+		return err
+	}
+
+	ui.Success("Disabled stack: %s", stackName)
+	return nil
+}
+
+func disableService(name string) error {
+	// Find the enabled stack defining the service (name may be stack/service)
+	stackName, serviceName, err := resolveService(name)
+	if err != nil {
+		return err
 	}
 
 	// Disable the service (add to disabled list)
-	if err := inventory.DisableService(serviceName); err != nil {
+	if err := inventory.DisableService(stackName, serviceName); err != nil {
 		// Handle case where service is already disabled
-		if err.Error() == "service already disabled" || err.Error() == fmt.Sprintf("service '%s' is already disabled", serviceName) {
+		if err.Error() == fmt.Sprintf("service '%s' is already disabled", inventory.QualifiedName(stackName, serviceName)) {
 			return errors.New(
 				fmt.Sprintf("service '%s' is already disabled", serviceName),
 				"Use 'homelabctl list' to see disabled services",
-				fmt.Sprintf("Run: homelabctl enable -s %s", serviceName),
+				fmt.Sprintf("Run: homelabctl enable -s %s", inventory.QualifiedName(stackName, serviceName)),
 			)
 		}
 		return err
 	}
 
-	fmt.Printf("✓ Disabled service: %s (from stack: %s)\n", serviceName, stackName)
-	fmt.Println("  Run 'homelabctl deploy' to apply changes")
+	ui.Success("Disabled service: %s (from stack: %s)", serviceName, stackName)
+	ui.Hint("Run 'homelabctl deploy' to apply changes")
 	return nil
 }
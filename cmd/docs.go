@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/monkeymonk/homelabctl/internal/docgen"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// defaultDocsDir is where the documentation site is written by default
+const defaultDocsDir = "docs"
+
+// Docs generates a markdown or HTML site describing every available stack
+func Docs(args []string) error {
+	outDir := defaultDocsDir
+	format := "markdown"
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--out", "-o":
+			if i+1 >= len(args) {
+				return fmt.Errorf("usage: homelabctl docs [--out <dir>] [--format markdown|html]")
+			}
+			i++
+			outDir = args[i]
+		case "--format":
+			if i+1 >= len(args) {
+				return fmt.Errorf("usage: homelabctl docs [--out <dir>] [--format markdown|html]")
+			}
+			i++
+			format = args[i]
+		default:
+			return fmt.Errorf("unexpected argument: %s", args[i])
+		}
+	}
+
+	var ext string
+	var render func(*docgen.Page) string
+	switch format {
+	case "markdown", "md":
+		ext, render = ".md", (*docgen.Page).Markdown
+	case "html":
+		ext, render = ".html", (*docgen.Page).HTML
+	default:
+		return fmt.Errorf("invalid --format value: %s (available: markdown, html)", format)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	available, err := fs.GetAvailableStacks()
+	if err != nil {
+		return err
+	}
+
+	var all []*stacks.Stack
+	for _, stackName := range available {
+		stack, err := stacks.LoadStack(stackName)
+		if err != nil {
+			return err
+		}
+		all = append(all, stack)
+	}
+
+	if len(all) == 0 {
+		fmt.Println("No stacks found in stacks/")
+		return nil
+	}
+
+	stacksDir := filepath.Join(outDir, "stacks")
+	if err := os.MkdirAll(stacksDir, paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create %s: %w", stacksDir, err)
+	}
+
+	requiredBy := docgen.RequiredBy(all)
+	for _, stack := range all {
+		// README rendered by the last generate, when available
+		readme, _ := os.ReadFile(paths.RuntimeReadme(stack.Name))
+
+		page := docgen.StackPage(stack, requiredBy[stack.Name], string(readme))
+		if err := writeDocsPage(filepath.Join(stacksDir, stack.Name+ext), render(page)); err != nil {
+			return err
+		}
+	}
+
+	indexPath := filepath.Join(outDir, "index"+ext)
+	if err := writeDocsPage(indexPath, render(docgen.IndexPage(all))); err != nil {
+		return err
+	}
+
+	ui.Success("Documented %d stack(s) in %s", len(all), outDir)
+	fmt.Printf("  Start at: %s\n", indexPath)
+	return nil
+}
+
+// writeDocsPage writes one generated documentation page
+func writeDocsPage(path, content string) error {
+	if err := os.WriteFile(path, []byte(content), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
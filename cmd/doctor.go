@@ -0,0 +1,361 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/render"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Statuses of a doctor check
+const (
+	doctorOK      = "ok"
+	doctorWarning = "warning" // homelabctl works without it, with less
+	doctorProblem = "problem"
+)
+
+// doctorTimeout bounds each command doctor runs, so a hung daemon can't hang it
+const doctorTimeout = 10 * time.Second
+
+// doctorResult is the outcome of one doctor check
+type doctorResult struct {
+	Name    string          `json:"name" yaml:"name"`
+	Status  string          `json:"status" yaml:"status"`
+	Detail  string          `json:"detail,omitempty" yaml:"detail,omitempty"`
+	Problem *errors.Details `json:"problem,omitempty" yaml:"problem,omitempty"`
+}
+
+// doctorOutput is the machine-readable form of doctor (--output json|yaml)
+type doctorOutput struct {
+	Healthy bool           `json:"healthy" yaml:"healthy"`
+	Checks  []doctorResult `json:"checks" yaml:"checks"`
+}
+
+// doctorPassed returns a passed check
+func doctorPassed(name, detail string) doctorResult {
+	return doctorResult{Name: name, Status: doctorOK, Detail: detail}
+}
+
+// doctorFailed returns a failed check, with how to fix it; status is doctorWarning or
+// doctorProblem
+func doctorFailed(name, status string, err *errors.Error) doctorResult {
+	details := errors.DetailsOf(err)
+	return doctorResult{Name: name, Status: status, Problem: &details}
+}
+
+// Doctor checks the environment homelabctl runs in: the container engine and compose,
+// the template and secrets tools, and the repository (its structure, enabled/ links,
+// inventory/state.yaml and the published ports of the generated compose file). Each
+// problem is printed with how to fix it; any problem fails the command, warnings don't
+func Doctor(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected argument: %s (usage: homelabctl doctor)", args[0])
+	}
+
+	results := doctorEnvironment()
+	results = append(results, doctorRepository()...)
+
+	problems, warnings := 0, 0
+	for _, result := range results {
+		switch result.Status {
+		case doctorProblem:
+			problems++
+		case doctorWarning:
+			warnings++
+		}
+	}
+
+	var err error
+	if problems > 0 {
+		err = errors.New(
+			fmt.Sprintf("doctor found %d problem(s)", problems),
+			"Fix them as suggested above, then run: homelabctl doctor",
+		).WithClass(errors.ClassValidation)
+	}
+
+	if machineOutput() {
+		if writeErr := writeOutput(doctorOutput{Healthy: problems == 0, Checks: results}); writeErr != nil {
+			return writeErr
+		}
+		return err
+	}
+
+	fmt.Println("Checking the homelabctl environment...")
+	for _, result := range results {
+		printDoctorResult(result)
+	}
+	if err != nil {
+		fmt.Println()
+		return err
+	}
+	ui.Blank()
+	if warnings > 0 {
+		ui.Success("No problems found (%d warning(s))", warnings)
+	} else {
+		ui.Success("No problems found")
+	}
+	return nil
+}
+
+// printDoctorResult prints a check, and for a failed one its context and fixes
+func printDoctorResult(result doctorResult) {
+	switch result.Status {
+	case doctorOK:
+		ui.Success("%s: %s", result.Name, result.Detail)
+		return
+	case doctorWarning:
+		fmt.Printf("⚠ %s: %s\n", result.Name, result.Problem.Message)
+	default:
+		fmt.Printf("✗ %s: %s\n", result.Name, result.Problem.Message)
+	}
+	for _, line := range result.Problem.Context {
+		fmt.Printf("    %s\n", line)
+	}
+	for _, suggestion := range result.Problem.Suggestions {
+		fmt.Printf("    → %s\n", suggestion)
+	}
+}
+
+// doctorEnvironment checks the container engine and its compose, gomplate and sops
+// age is built in, so .age secrets need no tool
+func doctorEnvironment() []doctorResult {
+	results := doctorEngine()
+	results = append(results, doctorGomplate())
+	results = append(results, doctorSecretsTool("sops", paths.SecretsEncExt,
+		"Install sops: https://github.com/getsops/sops/releases"))
+	return results
+}
+
+// doctorEngine checks the CLI of the engine selected with --engine, its daemon and its
+// compose
+func doctorEngine() []doctorResult {
+	kind := os.Getenv("HOMELAB_ENGINE")
+	binary := engine.Binary(kind)
+
+	version, err := toolVersion(binary, "--version")
+	if err != nil {
+		return []doctorResult{doctorFailed(binary, doctorProblem, errors.New(err.Error(),
+			engineInstallHint(binary),
+			"Or select another engine: --engine "+strings.Join(engine.Kinds, "|"),
+		).WithClass(errors.ClassDocker))}
+	}
+	results := []doctorResult{doctorPassed(binary, version)}
+
+	// The daemon answers docker info; podman runs without one
+	if binary == "docker" {
+		if server, err := toolVersion(binary, "info", "--format", "{{.ServerVersion}}"); err != nil {
+			results = append(results, doctorFailed("docker daemon", doctorProblem, errors.Wrap(err,
+				"the docker daemon is not reachable",
+				"Start it: sudo systemctl start docker",
+				"Or let your user reach it: sudo usermod -aG docker $USER, then log in again",
+			).WithClass(errors.ClassDocker)))
+		} else {
+			results = append(results, doctorPassed("docker daemon", "server "+server))
+		}
+	}
+
+	if version, err := toolVersion(binary, "compose", "version", "--short"); err != nil {
+		hint := "Install the compose plugin: https://docs.docker.com/compose/install/linux/"
+		if binary == "podman" {
+			hint = "Install podman-compose: https://github.com/containers/podman-compose"
+		}
+		results = append(results, doctorFailed(binary+" compose", doctorProblem, errors.Wrap(err,
+			fmt.Sprintf("%s compose is not available", binary), hint,
+		).WithClass(errors.ClassDocker)))
+	} else {
+		results = append(results, doctorPassed(binary+" compose", version))
+	}
+	return results
+}
+
+// engineInstallHint returns where to get an engine's CLI
+func engineInstallHint(binary string) string {
+	if binary == "podman" {
+		return "Install podman: https://podman.io/docs/installation"
+	}
+	return "Install Docker: https://docs.docker.com/engine/install/"
+}
+
+// doctorGomplate checks gomplate, which render.engine: auto falls back from and native
+// does not need
+func doctorGomplate() doctorResult {
+	renderEngine := render.EngineAuto
+	if vars, err := inventory.LoadVars(); err == nil && inventory.RenderEngine(vars) != "" {
+		renderEngine = inventory.RenderEngine(vars)
+	}
+	if renderEngine == render.EngineNative {
+		return doctorPassed("gomplate", "not needed (render.engine: native)")
+	}
+
+	version, err := toolVersion("gomplate", "--version")
+	if err == nil {
+		return doctorPassed("gomplate", version)
+	}
+	status := doctorProblem
+	problem := errors.New(err.Error(),
+		"Install gomplate: https://docs.gomplate.ca/installing/",
+		"Or use the built-in engine: set render.engine: native in inventory/vars.yaml",
+	).WithClass(errors.ClassRender)
+	if renderEngine == render.EngineAuto {
+		status = doctorWarning
+		problem.WithContext("Templates render with the built-in engine, without gomplate's datasources")
+	}
+	return doctorFailed("gomplate", status, problem)
+}
+
+// doctorSecretsTool checks the tool that decrypts secrets files with extension ext:
+// a problem when the repository has such files, else a warning
+func doctorSecretsTool(tool, ext, installHint string) doctorResult {
+	version, err := toolVersion(tool, "--version")
+	if err == nil {
+		return doctorPassed(tool, version)
+	}
+
+	encrypted, _ := filepath.Glob(filepath.Join(paths.Secrets, "*"+ext))
+	if len(encrypted) == 0 {
+		return doctorFailed(tool, doctorWarning, errors.New(
+			fmt.Sprintf("%v (no %s secrets use it yet)", err, ext),
+			installHint,
+		))
+	}
+	return doctorFailed(tool, doctorProblem, errors.Wrap(err,
+		fmt.Sprintf("%s is needed to decrypt %d secrets file(s)", tool, len(encrypted)),
+		installHint,
+	).WithContext(encrypted...).WithClass(errors.ClassDependency))
+}
+
+// toolVersion runs a tool and returns the first line it prints, its version
+func toolVersion(tool string, args ...string) (string, error) {
+	if _, err := exec.LookPath(tool); err != nil {
+		return "", fmt.Errorf("%s not found in PATH", tool)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	out, err := engine.Output(exec.CommandContext(ctx, tool, args...))
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(out, "\n")
+	return strings.TrimSpace(line), nil
+}
+
+// doctorRepository checks the repository: its structure, then enabled/ links, state
+// entries and port conflicts. Outside a repository only the structure is reported
+func doctorRepository() []doctorResult {
+	if err := fs.VerifyRepository(); err != nil {
+		return []doctorResult{doctorFailed("repository", doctorProblem, errors.Wrap(err,
+			"repository structure is invalid",
+			"Run: homelabctl init",
+			"Check that you're in a homelab repository root",
+		).WithClass(errors.ClassValidation))}
+	}
+
+	results := []doctorResult{doctorPassed("repository", "structure valid")}
+	results = append(results, doctorEnabledLinks())
+	results = append(results, doctorState())
+	results = append(results, doctorPorts())
+	return results
+}
+
+// doctorEnabledLinks checks that each entry of enabled/ is a symlink to a stack
+func doctorEnabledLinks() doctorResult {
+	entries, err := os.ReadDir(paths.Enabled)
+	if err != nil {
+		return doctorFailed("enabled stacks", doctorProblem, errors.Wrap(err,
+			fmt.Sprintf("failed to read %s", paths.Enabled),
+			fmt.Sprintf("Check the permissions of %s/", paths.Enabled),
+		))
+	}
+
+	var broken []string
+	valid := 0
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		link := paths.EnabledStackLink(entry.Name())
+		target, err := os.Readlink(link)
+		if err != nil {
+			broken = append(broken, fmt.Sprintf("%s is not a symlink", link))
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(link), target)); err != nil {
+			broken = append(broken, fmt.Sprintf("%s → %s (missing)", link, target))
+			continue
+		}
+		valid++
+	}
+
+	if len(broken) > 0 {
+		return doctorFailed("enabled stacks", doctorProblem, errors.New(
+			fmt.Sprintf("%d link(s) in %s/ don't point to a stack", len(broken), paths.Enabled),
+			fmt.Sprintf("Remove them: rm %s/<name>", paths.Enabled),
+			"Or restore the stack under stacks/, then: homelabctl enable <name>",
+		).WithContext(broken...).WithClass(errors.ClassValidation))
+	}
+	return doctorPassed("enabled stacks", fmt.Sprintf("%d enabled, all links valid", valid))
+}
+
+// doctorState checks inventory/state.yaml for disabled services no enabled stack defines
+func doctorState() doctorResult {
+	// LoadState creates a missing file; doctor only looks
+	if _, err := os.Stat(paths.InventoryState); os.IsNotExist(err) {
+		return doctorPassed("state", "no state recorded yet")
+	}
+
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return doctorPassed("state", "skipped until enabled/ is fixed")
+	}
+	stale, err := inventory.StaleDisabledServices(enabled)
+	if err != nil {
+		return doctorFailed("state", doctorProblem, errors.Wrap(err,
+			fmt.Sprintf("failed to load %s", paths.InventoryState),
+			fmt.Sprintf("Check its syntax, or remove it: homelabctl recreates %s", paths.InventoryState),
+		).WithClass(errors.ClassValidation))
+	}
+	if len(stale) > 0 {
+		return doctorFailed("state", doctorWarning, errors.New(
+			fmt.Sprintf("%d disabled service(s) no enabled stack defines", len(stale)),
+			"Remove them: homelabctl state prune",
+		).WithContext(stale...))
+	}
+	return doctorPassed("state", "no orphaned entries")
+}
+
+// doctorPorts checks that no two services of the generated compose file publish the
+// same host port
+func doctorPorts() doctorResult {
+	if _, err := os.Stat(paths.DockerCompose); os.IsNotExist(err) {
+		return doctorPassed("ports", "skipped until homelabctl generate runs")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return doctorFailed("ports", doctorProblem, errors.Wrap(err,
+			fmt.Sprintf("failed to load %s", paths.DockerCompose),
+			"Generate it again: homelabctl generate",
+		).WithClass(errors.ClassValidation))
+	}
+	if conflicts := compose.PortConflicts(generated); len(conflicts) > 0 {
+		return doctorFailed("ports", doctorProblem, errors.New(
+			fmt.Sprintf("%d host port(s) published by several services", len(conflicts)),
+			"Change the host port of all but one in their stack's compose.yml.tmpl",
+			"Or bind them to different host IPs, e.g. 127.0.0.1:8080:80",
+		).WithContext(conflicts...).WithClass(errors.ClassValidation))
+	}
+	return doctorPassed("ports", "no conflicts")
+}
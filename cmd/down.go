@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Down stops and removes containers
+// With a stack name, only that stack's services are removed; otherwise this is
+// the project-level docker compose down
+// A stack's --volumes also removes the named volumes only its services mount, after
+// confirmation (--yes skips it)
+func Down(args []string) error {
+	stackName, rest := splitStackArg(args)
+	if stackName == "" {
+		return Compose("down", args)
+	}
+
+	removeVolumes, yes := false, false
+	for _, arg := range rest {
+		switch arg {
+		case "-v", "--volumes":
+			removeVolumes = true
+		case "-y", "--yes":
+			yes = true
+		default:
+			return fmt.Errorf("unexpected argument: %s (usage: homelabctl down <stack> [--volumes [--yes]])", arg)
+		}
+	}
+
+	services, err := resolveStackServices(stackName)
+	if err != nil {
+		return err
+	}
+
+	var volumes []string
+	if removeVolumes {
+		generated, err := compose.LoadComposeFile(paths.DockerCompose)
+		if err != nil {
+			return err
+		}
+		volumes = stackVolumes(generated, composeProjectName(), services)
+	}
+
+	fmt.Printf("Removing %d service(s) from stack %s: %s\n", len(services), stackName, strings.Join(services, ", "))
+	if len(volumes) > 0 {
+		fmt.Printf("  Volumes to remove (⚠️  their data is lost): %s\n", strings.Join(volumes, ", "))
+		if !yes {
+			p := &prompter{reader: bufio.NewReader(os.Stdin), out: os.Stdout}
+			ok, err := p.confirm("Remove these volumes?", false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Println("Keeping the volumes")
+				volumes = nil
+			}
+		}
+	}
+
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+
+	// Only the named containers, unlike down which is project-wide
+	if err := e.Down(services, engine.DownOptions{Volumes: removeVolumes}); err != nil {
+		return fmt.Errorf("docker compose rm failed: %w", err)
+	}
+
+	for _, volume := range volumes {
+		if _, err := dockerOutput("volume", "rm", volume); err != nil {
+			return fmt.Errorf("failed to remove volume %s: %w", volume, err)
+		}
+		fmt.Printf("  volume %s removed\n", volume)
+	}
+
+	ui.Success("Stack %s is down", stackName)
+	return nil
+}
+
+// stackVolumes returns the docker names of the named volumes mounted only by the given
+// services; external volumes and volumes other services share are kept
+func stackVolumes(generated *compose.ComposeFile, project string, services []string) []string {
+	selected := make(map[string]bool)
+	for _, service := range services {
+		selected[service] = true
+	}
+
+	var volumes []string
+	for key, def := range generated.Volumes {
+		if def, ok := def.(map[string]interface{}); ok {
+			if external, ok := def["external"].(bool); ok && external {
+				continue
+			}
+		}
+
+		users := compose.ServicesUsingVolume(generated, key)
+		owned := len(users) > 0
+		for _, user := range users {
+			if !selected[user] {
+				owned = false
+				break
+			}
+		}
+		if owned {
+			volumes = append(volumes, compose.VolumeName(generated, project, key))
+		}
+	}
+
+	sort.Strings(volumes)
+	return volumes
+}
+
+// splitStackArg returns the stack name if the first argument names an existing
+// stack, along with the remaining arguments
+func splitStackArg(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", args
+	}
+
+	if !fs.StackExists(args[0]) {
+		return "", args
+	}
+
+	return args[0], args[1:]
+}
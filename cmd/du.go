@@ -0,0 +1,358 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// diskEntry is a named volume or host path counted in a stack's disk usage
+type diskEntry struct {
+	Stack  string
+	Volume bool    // Named volume, otherwise a host path
+	Source string  // Docker volume name or host path
+	Size   float64 // Bytes
+}
+
+// stackDisk is the summed disk usage of a stack
+type stackDisk struct {
+	Stack    string
+	Category string
+	Volumes  int
+	Paths    int
+	Size     float64
+}
+
+// Du measures the disk usage of each stack's named volumes and persistence paths and
+// prints it per stack and category; stacks or categories over their inventory/quotas.yaml
+// threshold are marked in the status column and reported as warnings
+func Du(args []string) error {
+	usage := "usage: homelabctl du [stack...]"
+
+	var selected []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+		}
+		if !fs.IsStackEnabled(arg) {
+			return errors.New(
+				fmt.Sprintf("stack '%s' is not enabled", arg),
+				fmt.Sprintf("Run: homelabctl enable %s", arg),
+			).WithClass(errors.ClassNotFound)
+		}
+		selected = append(selected, arg)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	if len(selected) == 0 {
+		if selected, err = fs.GetEnabledStacks(); err != nil {
+			return err
+		}
+	}
+	ordered, err := stacks.SortByCategory(selected)
+	if err != nil {
+		return err
+	}
+
+	var loaded []*stacks.Stack
+	for _, stackName := range ordered {
+		stack, err := stacks.LoadStack(stackName)
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, stack)
+	}
+
+	quotas, err := inventory.LoadQuotas()
+	if err != nil {
+		return err
+	}
+
+	entries := diskEntries(generated, loaded, composeProjectName())
+	if len(entries) == 0 {
+		fmt.Println("No volumes or persistence paths to measure")
+		return nil
+	}
+
+	fmt.Printf("Measuring %d volume(s) and path(s)...\n\n", len(entries))
+	if entries, err = measureDisk(entries); err != nil {
+		return err
+	}
+
+	categories := make(map[string]string)
+	for _, stack := range loaded {
+		categories[stack.Name] = stack.Category
+	}
+	perStack := aggregateDisk(entries, categories)
+
+	// Also rejects invalid quotas before the table shows them
+	warnings, err := diskQuotaWarnings(perStack, quotas)
+	if err != nil {
+		return err
+	}
+	printDiskUsage(perStack, quotas)
+	addWarnings(warnings...)
+	return nil
+}
+
+// diskEntries lists the volumes and host paths of each stack, in stack order: the named
+// volumes its services mount or its persistence section declares, and its absolute
+// persistence paths
+// Network shares are not local disk and are skipped; an entry shared by several stacks
+// counts for the first one only
+func diskEntries(generated *compose.ComposeFile, stackList []*stacks.Stack, project string) []diskEntry {
+	volumeStacks := make(map[string][]string)
+	for key := range generated.Volumes {
+		for _, svc := range compose.ServicesUsingVolume(generated, key) {
+			if stackName := compose.ServiceLabels(generated, svc)[compose.LabelStack]; stackName != "" {
+				volumeStacks[stackName] = append(volumeStacks[stackName], key)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var entries []diskEntry
+	add := func(entry diskEntry) {
+		if !seen[entry.Source] {
+			seen[entry.Source] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	for _, stack := range stackList {
+		keys := append(append([]string{}, stack.Persistence.Volumes...), volumeStacks[stack.Name]...)
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, share := stack.Persistence.Shares[key]; share {
+				continue
+			}
+			add(diskEntry{Stack: stack.Name, Volume: true, Source: compose.VolumeName(generated, project, key)})
+		}
+
+		for _, path := range stack.Persistence.Paths {
+			if filepath.IsAbs(path) {
+				add(diskEntry{Stack: stack.Name, Source: filepath.Clean(path)})
+			}
+		}
+	}
+
+	return entries
+}
+
+// measureDisk sizes the entries with du in a single helper container mounting each
+// one read-only, on the host selected with --host when there is one
+// Volumes not created yet count as empty; missing paths are warned about and dropped
+func measureDisk(entries []diskEntry) ([]diskEntry, error) {
+	listing, err := dockerOutput("volume", "ls", "--format", "{{.Name}}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	existing := make(map[string]bool)
+	for _, name := range strings.Split(listing, "\n") {
+		existing[name] = true
+	}
+
+	var measured []diskEntry
+	args := []string{"run", "--rm"}
+	var targets []string
+	for _, entry := range entries {
+		mountType := "volume"
+		if entry.Volume {
+			if !existing[entry.Source] {
+				measured = append(measured, entry)
+				continue
+			}
+		} else {
+			mountType = "bind"
+			cmd, err := hostCommand(false, "test", "-e", entry.Source)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := engine.Output(cmd); err != nil {
+				addWarnings(fmt.Sprintf("persistence path %s of stack %s does not exist", entry.Source, entry.Stack))
+				continue
+			}
+		}
+
+		target := fmt.Sprintf("/du/%d", len(measured))
+		args = append(args, "--mount", fmt.Sprintf("type=%s,source=%s,target=%s,readonly", mountType, entry.Source, target))
+		targets = append(targets, target)
+		measured = append(measured, entry)
+	}
+	if len(targets) == 0 {
+		return measured, nil
+	}
+
+	args = append(append(args, migrationHelperImage, "du", "-sk"), targets...)
+	output, err := dockerOutput(args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to measure disk usage").WithClass(errors.ClassDocker)
+	}
+
+	// "<KiB>\t/du/<index>" per mount
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		kib, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		if i, err := strconv.Atoi(strings.TrimPrefix(fields[1], "/du/")); err == nil && i < len(measured) {
+			measured[i].Size = kib * 1024
+		}
+	}
+
+	return measured, nil
+}
+
+// aggregateDisk sums entries per stack, ordered by category total size,
+// then by stack size (largest first)
+func aggregateDisk(entries []diskEntry, categories map[string]string) []stackDisk {
+	byStack := make(map[string]*stackDisk)
+	categorySize := make(map[string]float64)
+
+	for _, e := range entries {
+		s, ok := byStack[e.Stack]
+		if !ok {
+			s = &stackDisk{Stack: e.Stack, Category: categories[e.Stack]}
+			byStack[e.Stack] = s
+		}
+		if e.Volume {
+			s.Volumes++
+		} else {
+			s.Paths++
+		}
+		s.Size += e.Size
+		categorySize[s.Category] += e.Size
+	}
+
+	result := make([]stackDisk, 0, len(byStack))
+	for _, s := range byStack {
+		result = append(result, *s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Category != b.Category {
+			if categorySize[a.Category] != categorySize[b.Category] {
+				return categorySize[a.Category] > categorySize[b.Category]
+			}
+			return a.Category < b.Category
+		}
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Stack < b.Stack
+	})
+
+	return result
+}
+
+// printDiskUsage prints per-stack disk usage grouped under category subtotals, with the
+// status of each row against its quota
+func printDiskUsage(usage []stackDisk, quotas *inventory.Quotas) {
+	format := "%-24s %8s %6s %10s %10s  %s\n"
+	fmt.Printf(format, "CATEGORY / STACK", "VOLUMES", "PATHS", "SIZE", "QUOTA", "STATUS")
+
+	row := func(name string, d stackDisk, limit string) {
+		quota, status := "-", "-"
+		if limit != "" {
+			quota, status = limit, "ok"
+			if d.Size > parseSize(limit) {
+				status = errors.Red("over quota")
+			}
+		}
+		fmt.Printf(format, name, strconv.Itoa(d.Volumes), strconv.Itoa(d.Paths), formatBytes(d.Size, 1024, "iB"), quota, status)
+	}
+
+	var total stackDisk
+	for i := 0; i < len(usage); {
+		category := usage[i].Category
+
+		var subtotal stackDisk
+		j := i
+		for ; j < len(usage) && usage[j].Category == category; j++ {
+			addDisk(&subtotal, usage[j])
+		}
+		addDisk(&total, subtotal)
+
+		row(category, subtotal, quotas.Categories[category])
+		for _, s := range usage[i:j] {
+			row("  "+s.Stack, s, quotas.Stacks[s.Stack])
+		}
+
+		i = j
+	}
+
+	fmt.Println()
+	row("total", total, "")
+}
+
+// addDisk adds b's totals to a
+func addDisk(a *stackDisk, b stackDisk) {
+	a.Volumes += b.Volumes
+	a.Paths += b.Paths
+	a.Size += b.Size
+}
+
+// diskQuotaWarnings returns a warning for each stack and category over its quota
+func diskQuotaWarnings(usage []stackDisk, quotas *inventory.Quotas) ([]string, error) {
+	categorySize := make(map[string]float64)
+	stackSize := make(map[string]float64)
+	for _, s := range usage {
+		categorySize[s.Category] += s.Size
+		stackSize[s.Stack] = s.Size
+	}
+
+	var warnings []string
+	check := func(kind string, limits map[string]string, sizes map[string]float64) error {
+		names := make([]string, 0, len(limits))
+		for name := range limits {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			limit := parseSize(limits[name])
+			if limit <= 0 {
+				return fmt.Errorf("invalid quota for %s %s in %s: %q (use a size like 50GB or 1.5TiB)", kind, name, paths.InventoryQuotas, limits[name])
+			}
+			if size, ok := sizes[name]; ok && size > limit {
+				warnings = append(warnings, fmt.Sprintf("%s %s uses %s, over its %s quota (%s)",
+					kind, name, formatBytes(size, 1024, "iB"), limits[name], paths.InventoryQuotas))
+			}
+		}
+		return nil
+	}
+
+	if err := check("category", quotas.Categories, categorySize); err != nil {
+		return nil, err
+	}
+	if err := check("stack", quotas.Stacks, stackSize); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
@@ -2,11 +2,16 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/monkeymonk/homelabctl/internal/catalog"
 	"github.com/monkeymonk/homelabctl/internal/errors"
 	"github.com/monkeymonk/homelabctl/internal/fs"
 	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/paths"
 	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
 )
 
 // Enable enables a stack or service
@@ -14,6 +19,8 @@ func Enable(args []string) error {
 	// Parse flags
 	isService := false
 	suggestCategory := false
+	configure := false
+	withDeps := false
 	var name string
 
 	for i := 0; i < len(args); i++ {
@@ -22,6 +29,10 @@ func Enable(args []string) error {
 			isService = true
 		case "--suggest-category":
 			suggestCategory = true
+		case "--configure":
+			configure = true
+		case "--with-deps":
+			withDeps = true
 		default:
 			if name == "" {
 				name = args[i]
@@ -35,17 +46,45 @@ func Enable(args []string) error {
 		if isService {
 			return fmt.Errorf("usage: homelabctl enable -s <service>")
 		}
-		return fmt.Errorf("usage: homelabctl enable <stack> [--suggest-category]")
+		return fmt.Errorf("usage: homelabctl enable <[catalog/]stack> [--with-deps] [--suggest-category] [--configure]")
 	}
 
 	if err := fs.VerifyRepository(); err != nil {
 		return err
 	}
 
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	if isService {
 		return enableService(name)
 	}
-	return enableStack(name, suggestCategory)
+
+	if withDeps {
+		_, stackName := stacks.SplitRequire(name)
+		enabled, err := enableWithDeps(name, suggestCategory)
+		if err != nil {
+			return err
+		}
+		for _, enabledName := range enabled {
+			if err := configureStack(enabledName, configure && enabledName == stackName); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Reconfigure an enabled stack instead of failing on it
+	if configure && fs.IsStackEnabled(name) {
+		return configureStack(name, true)
+	}
+	if err := enableStack(name, suggestCategory); err != nil {
+		return err
+	}
+	return configureStack(name, configure)
 }
 
 func enableStack(stackName string, suggestCategory bool) error {
@@ -71,13 +110,13 @@ func enableStack(stackName string, suggestCategory bool) error {
 			return errors.New(
 				fmt.Sprintf("stack '%s' does not exist", stackName),
 				suggestions...,
-			).WithContext(context...)
+			).WithContext(context...).WithClass(errors.ClassNotFound).WithKind(errors.ErrStackNotFound)
 		}
 
 		return errors.New(
 			fmt.Sprintf("stack '%s' does not exist", stackName),
 			suggestions...,
-		)
+		).WithClass(errors.ClassNotFound).WithKind(errors.ErrStackNotFound)
 	}
 
 	// Get currently enabled stacks
@@ -111,58 +150,160 @@ func enableStack(stackName string, suggestCategory bool) error {
 		return err
 	}
 
-	fmt.Printf("✓ Enabled stack: %s\n", stackName)
+	if err := inventory.MarkStackEnabled(stackName); err != nil {
+		return err
+	}
+
+	ui.Success("Enabled stack: %s", stackName)
 	return nil
 }
 
-func enableService(serviceName string) error {
-	// Get enabled stacks
-	enabled, err := fs.GetEnabledStacks()
-	if err != nil {
-		return err
+// enableWithDeps enables a stack and its transitive requires in dependency order,
+// installing those missing from stacks/ from their catalog: the one named in
+// catalog/<stack>, or the configured catalog for plain names
+// Returns the stacks it enabled
+func enableWithDeps(name string, suggestCategory bool) ([]string, error) {
+	catalogs := make(map[string]*catalog.Catalog)
+	defer func() {
+		for _, cat := range catalogs {
+			cat.Close()
+		}
+	}()
+
+	type pendingStack struct {
+		Name   string
+		Source string // Catalog to install from when missing
 	}
 
-	// Check if service exists in any enabled stack
-	exists, stackName := stacks.ServiceExists(serviceName, enabled)
-	if !exists {
-		// Get all available services
-		allServices, err := stacks.GetAllServicesFromStacks(enabled)
+	source, stackName := stacks.SplitRequire(name)
+	queue := []pendingStack{{Name: stackName, Source: source}}
+	seen := make(map[string]bool)
+	var all []string
+
+	for len(queue) > 0 {
+		item := queue[0]
+		queue = queue[1:]
+		if seen[item.Name] {
+			continue
+		}
+		seen[item.Name] = true
+
+		if !fs.StackExists(item.Name) {
+			if err := installCatalogStack(item.Name, item.Source, catalogs); err != nil {
+				return nil, err
+			}
+		}
+
+		stack, err := stacks.LoadStack(item.Name)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		all = append(all, item.Name)
 
-		suggestions := []string{
-			"Run: homelabctl list",
-			"Check that the service's stack is enabled",
+		for _, dep := range stack.Requires {
+			queue = append(queue, pendingStack{Name: dep, Source: stack.RequireSources[dep]})
 		}
+	}
 
-		context := []string{
-			"Available services in enabled stacks:",
+	ordered, err := stacks.SortByDependencies(all)
+	if err != nil {
+		return nil, err
+	}
+
+	var enabled []string
+	for _, name := range ordered {
+		if fs.IsStackEnabled(name) {
+			continue
 		}
-		for svc, stack := range allServices {
-			context = append(context, fmt.Sprintf("  - %s (from %s)", svc, stack))
+		if err := enableStack(name, suggestCategory && name == stackName); err != nil {
+			return nil, err
 		}
+		enabled = append(enabled, name)
+	}
+
+	switch {
+	case len(enabled) == 0:
+		ui.Success("%s and its dependencies are already enabled", stackName)
+	case len(enabled) > 1:
+		ui.Success("Enabled %d stacks, in dependency order: %s", len(enabled), strings.Join(enabled, ", "))
+	}
+
+	return enabled, nil
+}
+
+// installCatalogStack copies a stack from a catalog into stacks/, opening each catalog once
+func installCatalogStack(name, source string, catalogs map[string]*catalog.Catalog) error {
+	if source == "" {
+		source = catalog.DefaultName
+	}
+
+	cat, ok := catalogs[source]
+	if !ok {
+		cfg, err := catalog.ConfigFor(source)
+		if err != nil {
+			return err
+		}
+		ui.Step("Fetching catalog %s (%s)...", source, cfg.Source)
+		cat, err = catalog.OpenConfig(cfg)
+		if err != nil {
+			return err
+		}
+		catalogs[source] = cat
+	}
 
+	if !cat.HasStack(name) {
 		return errors.New(
-			fmt.Sprintf("service '%s' not found in enabled stacks", serviceName),
-			suggestions...,
-		).WithContext(context...)
+			fmt.Sprintf("stack '%s' does not exist locally or in catalog %s", name, source),
+			"Check the requires entries of the stacks being enabled",
+			fmt.Sprintf("Point to another catalog: <catalog>/%s, with the catalog in %s", name, paths.InventoryCatalogs),
+		).WithClass(errors.ClassNotFound).WithKind(errors.ErrStackNotFound)
+	}
+
+	verification, err := cat.InstallStack(name)
+	if err != nil {
+		return err
+	}
+	ui.Success("Installed stack %s from catalog %s (%s)", name, source, describeVerification(verification))
+	if verification.Method == catalog.VerifiedNone {
+		addWarnings(fmt.Sprintf("stack %s from catalog %s is not verified: the catalog publishes no %s", name, source, catalog.ChecksumsFile))
+	}
+
+	return nil
+}
+
+// describeVerification summarizes how an installed stack was verified
+func describeVerification(v *catalog.Verification) string {
+	switch v.Method {
+	case catalog.VerifiedNone:
+		return "unverified"
+	case catalog.VerifiedChecksum:
+		return "checksum verified, unsigned"
+	default:
+		return "signature verified with " + v.Method
+	}
+}
+
+func enableService(name string) error {
+	// Find the enabled stack defining the service (name may be stack/service)
+	stackName, serviceName, err := resolveService(name)
+	if err != nil {
+		return err
 	}
 
 	// Re-enable the service (remove from disabled list)
-	if err := inventory.EnableService(serviceName); err != nil {
+	if err := inventory.EnableService(stackName, serviceName); err != nil {
 		// Handle case where service is not disabled
-		if err.Error() == "service not disabled" || err.Error() == fmt.Sprintf("service '%s' is not disabled", serviceName) {
+		if err.Error() == fmt.Sprintf("service '%s' is not disabled", inventory.QualifiedName(stackName, serviceName)) {
 			return errors.New(
 				fmt.Sprintf("service '%s' is not disabled", serviceName),
 				fmt.Sprintf("Service is already enabled in stack '%s'", stackName),
 				"Use 'homelabctl list' to see disabled services",
-			)
+			).WithKind(errors.ErrAlreadyEnabled)
 		}
 		return err
 	}
 
-	fmt.Printf("✓ Enabled service: %s (from stack: %s)\n", serviceName, stackName)
-	fmt.Println("  Run 'homelabctl deploy' to apply changes")
+	ui.Success("Enabled service: %s (from stack: %s)", serviceName, stackName)
+	ui.Hint("Run 'homelabctl deploy' to apply changes")
 	return nil
 }
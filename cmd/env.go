@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// envShells are the shells env prints exports for
+var envShells = []string{"sh", "fish"}
+
+// Env prints shell exports that point docker compose at the generated compose file, so
+// `eval "$(homelabctl env)"` lets raw docker compose commands run against it
+func Env(args []string) error {
+	usage := "usage: homelabctl env [--shell sh|fish] [--unset]"
+	shell := "sh"
+	unset := false
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--unset":
+			unset = true
+		case arg == "--shell":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--shell requires a value (%s)", usage)
+			}
+			shell = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--shell="):
+			shell = strings.TrimPrefix(arg, "--shell=")
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+	if shell != "sh" && shell != "fish" {
+		return fmt.Errorf("unknown shell: %s (available: %s)", shell, strings.Join(envShells, ", "))
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+	if os.Getenv("HOMELAB_HOST") != "" {
+		return errors.New(
+			"env points docker compose at the local runtime/, not at a --host",
+			"Run docker compose on the host: homelabctl --host <name> <compose command>",
+		).WithClass(errors.ClassUsage)
+	}
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	exports, err := envExports()
+	if err != nil {
+		return err
+	}
+	for _, e := range exports {
+		if unset {
+			fmt.Println(shellUnset(shell, e[0]))
+		} else {
+			fmt.Println(shellExport(shell, e[0], e[1]))
+		}
+	}
+	return nil
+}
+
+// envExports returns the variables docker compose needs to use the generated compose
+// file as homelabctl does, as name/value pairs
+func envExports() ([][2]string, error) {
+	composeFile, err := filepath.Abs(paths.DockerCompose)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", paths.DockerCompose, err)
+	}
+
+	project, err := projectName()
+	if err != nil {
+		return nil, err
+	}
+
+	exports := [][2]string{
+		{"COMPOSE_FILE", composeFile},
+		{"COMPOSE_PROJECT_NAME", project},
+	}
+	// The same .env homelabctl passes with --env-file (docker compose 2.24+)
+	if _, err := os.Stat(".env"); err == nil {
+		envFile, err := filepath.Abs(".env")
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve .env: %w", err)
+		}
+		exports = append(exports, [2]string{"COMPOSE_ENV_FILES", envFile})
+	}
+	if env := os.Getenv("HOMELAB_ENV"); env != "" {
+		exports = append(exports, [2]string{"HOMELAB_ENV", env})
+	}
+	return exports, nil
+}
+
+// shellExport returns the line that exports a variable in a shell
+func shellExport(shell, name, value string) string {
+	if shell == "fish" {
+		return fmt.Sprintf("set -gx %s %s;", name, shellQuote(value))
+	}
+	return fmt.Sprintf("export %s=%s", name, shellQuote(value))
+}
+
+// shellUnset returns the line that removes a variable in a shell
+func shellUnset(shell, name string) string {
+	if shell == "fish" {
+		return fmt.Sprintf("set -e %s;", name)
+	}
+	return "unset " + name
+}
+
+// shellQuote single-quotes a value; a quote in it closes the quoting, is escaped and
+// reopens it, which both sh and fish read
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,264 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/docker"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// containerEvent is a Docker container event annotated with homelabctl provenance
+type containerEvent struct {
+	Time      string `json:"time"`
+	Container string `json:"container"`
+	Service   string `json:"service"`
+	Stack     string `json:"stack"`
+	Category  string `json:"category"`
+	Event     string `json:"event"`  // Normalized name, e.g. healthy or die
+	Action    string `json:"action"` // Raw Docker action, e.g. "health_status: healthy"
+	ExitCode  string `json:"exit_code,omitempty"`
+}
+
+// eventCondition matches an event of a stack or service (any container when Target is empty)
+type eventCondition struct {
+	Target string
+	Event  string
+}
+
+// Events streams Docker events of the deployment's containers, annotated with
+// stack and category, until interrupted or an --exit-on/--fail-on condition is met
+func Events(args []string) error {
+	usage := "usage: homelabctl events [stack|service...] [--format text|json] [--since <time>] [--exit-on <[target=]event>] [--fail-on <[target=]event>] [--timeout <duration>]"
+	format := "text"
+	since := ""
+	var timeout time.Duration
+	var targets []string
+	var exitOn, failOn []eventCondition
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		switch name {
+		case "--format", "--since", "--exit-on", "--fail-on", "--timeout":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("%s requires a value (%s)", name, usage)
+				}
+				value = args[i+1]
+				i++
+			}
+			switch name {
+			case "--format":
+				format = value
+			case "--since":
+				ts, err := parseLogTime(value, time.Now())
+				if err != nil {
+					return err
+				}
+				since = ts
+			case "--exit-on", "--fail-on":
+				cond, err := parseEventCondition(value)
+				if err != nil {
+					return err
+				}
+				if name == "--exit-on" {
+					exitOn = append(exitOn, cond)
+				} else {
+					failOn = append(failOn, cond)
+				}
+			case "--timeout":
+				d, err := time.ParseDuration(value)
+				if err != nil || d <= 0 {
+					return fmt.Errorf("invalid --timeout value: %s (e.g. 120s, 5m)", value)
+				}
+				timeout = d
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+			}
+			targets = append(targets, arg)
+		}
+	}
+
+	if format != "text" && format != "json" {
+		return fmt.Errorf("invalid --format value: %s (available: text, json)", format)
+	}
+
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	stream, err := e.Events(ctx, since)
+	if err != nil {
+		if ctx.Err() != nil {
+			return eventTimeout(timeout, exitOn)
+		}
+		return err
+	}
+	defer stream.Close()
+
+	seen := make([]bool, len(exitOn))
+	for {
+		raw, err := stream.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return eventTimeout(timeout, exitOn)
+			}
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("docker event stream failed: %w", err)
+		}
+
+		event := annotateEvent(raw)
+		// Healthchecks run as execs; they would drown everything else
+		if strings.HasPrefix(event.Event, "exec_") || !eventSelected(event, targets) {
+			continue
+		}
+
+		if format == "json" {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("failed to marshal event: %w", err)
+			}
+			fmt.Println(string(data))
+		} else {
+			printEvent(event)
+		}
+
+		for _, cond := range failOn {
+			if cond.matches(event) {
+				return errors.New(
+					fmt.Sprintf("%s/%s: %s", event.Stack, event.Service, event.Event),
+					"Check the logs: homelabctl logs "+event.Service,
+				).WithClass(errors.ClassDocker).WithContext("Condition: --fail-on " + cond.String())
+			}
+		}
+
+		if len(exitOn) == 0 {
+			continue
+		}
+		done := true
+		for i, cond := range exitOn {
+			if cond.matches(event) {
+				seen[i] = true
+			}
+			done = done && seen[i]
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// parseEventCondition parses "target=event" or a bare "event" matching any container
+func parseEventCondition(value string) (eventCondition, error) {
+	cond := eventCondition{Event: value}
+	if target, event, ok := strings.Cut(value, "="); ok {
+		cond = eventCondition{Target: target, Event: event}
+	}
+	if cond.Event == "" || (strings.Contains(value, "=") && cond.Target == "") {
+		return cond, fmt.Errorf("invalid event condition: %s (e.g. grafana=healthy or die)", value)
+	}
+	return cond, nil
+}
+
+// matches reports whether an event satisfies the condition
+func (c eventCondition) matches(event containerEvent) bool {
+	return event.Event == c.Event && (c.Target == "" || targetMatches(c.Target, event.Stack, event.Service))
+}
+
+func (c eventCondition) String() string {
+	if c.Target == "" {
+		return c.Event
+	}
+	return c.Target + "=" + c.Event
+}
+
+// eventTimeout is the result of a stream ending on --timeout: an error while
+// --exit-on conditions are still pending, success otherwise
+func eventTimeout(timeout time.Duration, exitOn []eventCondition) error {
+	if len(exitOn) == 0 {
+		return nil
+	}
+
+	var pending []string
+	for _, cond := range exitOn {
+		pending = append(pending, cond.String())
+	}
+	return errors.New(
+		fmt.Sprintf("timed out after %s waiting for %s", timeout, strings.Join(pending, ", ")),
+		"Check the service state: homelabctl ps",
+	).WithClass(errors.ClassDocker)
+}
+
+// annotateEvent extracts the container, service, stack and category of a raw event
+// Container events carry the container labels as actor attributes
+func annotateEvent(raw docker.Event) containerEvent {
+	attrs := raw.Actor.Attributes
+
+	// "health_status: healthy" becomes healthy; "exec_start: sh -c ..." becomes exec_start
+	name := strings.TrimPrefix(raw.Action, "health_status: ")
+	name = strings.SplitN(name, ":", 2)[0]
+
+	event := containerEvent{
+		Time:      time.Unix(0, raw.TimeNano).Format(time.RFC3339),
+		Container: attrs["name"],
+		Service:   attrs["com.docker.compose.service"],
+		Stack:     attrs[compose.LabelStack],
+		Category:  attrs[compose.LabelCategory],
+		Event:     name,
+		Action:    raw.Action,
+	}
+	if name == "die" {
+		event.ExitCode = attrs["exitCode"]
+	}
+	return event
+}
+
+// eventSelected reports whether an event belongs to one of the targets (all when empty)
+func eventSelected(event containerEvent, targets []string) bool {
+	if len(targets) == 0 {
+		return true
+	}
+	for _, target := range targets {
+		if targetMatches(target, event.Stack, event.Service) {
+			return true
+		}
+	}
+	return false
+}
+
+// printEvent prints one event as a text line
+func printEvent(event containerEvent) {
+	source := event.Service
+	if event.Stack != "" {
+		source = event.Stack + "/" + event.Service
+	}
+	category := event.Category
+	if category == "" {
+		category = "-"
+	}
+
+	line := fmt.Sprintf("%s  %-32s %-14s %s", event.Time, source, category, event.Event)
+	if event.ExitCode != "" {
+		line += fmt.Sprintf(" (exit code %s)", event.ExitCode)
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/ansible"
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/remote"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Export writes the deployment in another tool's format: export ansible writes an
+// inventory of the hosts and group_vars derived from the enabled stacks
+func Export(args []string) error {
+	usage := "usage: homelabctl export ansible [--out <dir>]"
+	if len(args) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	switch args[0] {
+	case "ansible":
+		return exportAnsible(args[1:], usage)
+	default:
+		return fmt.Errorf("unknown export format: %s (available: ansible)", args[0])
+	}
+}
+
+// exportAnsible writes inventory.yml and group_vars/homelab.yml: packages, persistence
+// directories, network shares and the firewall ports of published services
+func exportAnsible(args []string, usage string) error {
+	outDir := paths.AnsibleDir
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--out" || arg == "-o":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value (%s)", arg, usage)
+			}
+			outDir = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--out="):
+			outDir = strings.TrimPrefix(arg, "--out=")
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+	generated, err := interpolatedCompose()
+	if err != nil {
+		return err
+	}
+
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return err
+	}
+	ordered, err := stacks.SortByCategory(enabled)
+	if err != nil {
+		return err
+	}
+	var loaded []*stacks.Stack
+	for _, stackName := range ordered {
+		stack, err := stacks.LoadStack(stackName)
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, stack)
+	}
+
+	hosts, err := remote.LoadHosts()
+	if err != nil {
+		return err
+	}
+	localPath, err := filepath.Abs(".")
+	if err != nil {
+		return err
+	}
+
+	inventory := ansible.Inventory(hosts, localPath)
+	vars := ansible.GroupVars(composeProjectName(), loaded, generated, len(hosts) > 0)
+	if err := ansible.Write(outDir, inventory, vars); err != nil {
+		return err
+	}
+
+	hostCount := len(hosts)
+	if hostCount == 0 {
+		hostCount = 1 // localhost
+	}
+	ui.Success("Written: %s (%d host(s)), %s",
+		filepath.Join(outDir, "inventory.yml"), hostCount, filepath.Join(outDir, "group_vars", ansible.Group+".yml"))
+	fmt.Printf("  %d package(s), %d persistence path(s), %d share(s), %d firewall port(s)\n",
+		len(vars.Packages), len(vars.Directories), len(vars.Shares), len(vars.FirewallPorts))
+	return nil
+}
+
+// interpolatedCompose loads runtime/docker-compose.yml with the variables of .env and
+// the environment substituted, as docker compose reads it
+func interpolatedCompose() (*compose.ComposeFile, error) {
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return nil, fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+	return interpolateComposeFile(paths.DockerCompose)
+}
+
+// interpolateComposeFile loads a compose file with the variables of .env and the
+// environment substituted
+func interpolateComposeFile(path string) (*compose.ComposeFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	envFile := ""
+	if _, err := os.Stat(".env"); err == nil {
+		envFile = ".env"
+	}
+	env, err := engine.ProjectEnv(envFile)
+	if err != nil {
+		return nil, err
+	}
+
+	text, err := engine.Interpolate(string(data), env)
+	if err != nil {
+		return nil, fmt.Errorf("failed to interpolate %s: %w", path, err)
+	}
+	var generated compose.ComposeFile
+	if err := yaml.Unmarshal([]byte(text), &generated); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &generated, nil
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/firewall"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// nftablesConfig is the ruleset firewall apply reloads for the nftables format
+const nftablesConfig = "/etc/nftables.conf"
+
+// Firewall generates host firewall rules for the ports the deployment publishes,
+// and applies them on the host (the --host one, if selected)
+func Firewall(args []string) error {
+	usage := "usage: homelabctl firewall generate | firewall apply [--format ufw|nftables|firewalld]"
+	if len(args) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	switch args[0] {
+	case "generate":
+		if len(args) > 1 {
+			return fmt.Errorf("unexpected argument: %s (%s)", args[1], usage)
+		}
+		return firewallGenerate()
+	case "apply":
+		return firewallApply(args[1:], usage)
+	default:
+		return fmt.Errorf("unknown firewall subcommand: %s (available: generate, apply)", args[0])
+	}
+}
+
+// firewallGenerate writes every format to runtime/firewall/ and prints the rules
+func firewallGenerate() error {
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+	generated, err := interpolatedCompose()
+	if err != nil {
+		return err
+	}
+
+	rules := firewall.Rules(generated)
+	if err := firewall.Write(composeProjectName(), rules); err != nil {
+		return err
+	}
+
+	ui.Success("Written: %s (%d port(s))", paths.FirewallDir, len(rules))
+	for _, r := range rules {
+		owner := r.Service
+		if r.Stack != "" {
+			owner = r.Stack + "/" + r.Service
+		}
+		fmt.Printf("  %-12s %s\n", r.Port+"/"+r.Proto, owner)
+	}
+	return nil
+}
+
+// firewallApply regenerates the rules and loads one format into the host firewall
+// Needs root on the host: run with sudo, or a --host whose SSH user may use it
+func firewallApply(args []string, usage string) error {
+	format := firewall.FormatUFW
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--format":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--format requires a value (%s)", usage)
+			}
+			format = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+	if _, err := firewall.Render(format, "", nil); err != nil {
+		return err
+	}
+
+	if err := firewallGenerate(); err != nil {
+		return err
+	}
+
+	// Remote hosts run the copy of runtime/ synced to their path
+	if err := syncRuntime(); err != nil {
+		return err
+	}
+	file := filepath.Join(paths.FirewallDir, firewall.FileName(format))
+
+	var script string
+	switch format {
+	case firewall.FormatUFW:
+		script = "sh " + file
+	case firewall.FormatFirewalld:
+		service := "/etc/firewalld/services/" + composeProjectName() + ".xml"
+		script = fmt.Sprintf("install -m 0644 %s %s && firewall-cmd --reload && firewall-cmd --permanent --add-service=%s && firewall-cmd --reload",
+			file, service, composeProjectName())
+	case firewall.FormatNftables:
+		// The ruleset includes the port sets, so reloading it picks up the new ones
+		script = fmt.Sprintf("nft -c -f %s && nft -f %s", nftablesConfig, nftablesConfig)
+	}
+
+	fmt.Printf("\nApplying %s rules...\n", format)
+	cmd, err := hostCommand(false, "sh", "-c", script)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		suggestions := []string{"Run as root: sudo homelabctl firewall apply --format " + format}
+		if format == firewall.FormatNftables {
+			suggestions = append(suggestions, fmt.Sprintf("Include the absolute path of %s from %s", file, nftablesConfig))
+		}
+		return errors.Wrap(err, fmt.Sprintf("failed to apply %s rules", format), suggestions...)
+	}
+
+	ui.Success("Host firewall matches the deployment (%s)", format)
+	return nil
+}
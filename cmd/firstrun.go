@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/style"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// firstRunSkipped lists the commands that guide the user themselves, or that scripts run
+var firstRunSkipped = map[string]bool{
+	"init":       true,
+	"demo":       true,
+	"completion": true,
+	"__complete": true,
+	"env":        true,
+}
+
+// FirstRun prints guided next steps on stderr after the first command run in a repository
+// where no stack is enabled yet, once: runtime/.first-run records it was shown. Nothing is
+// printed when quiet, with output.hints: false, with --output json|yaml or when stdout is
+// not a terminal, so piped data stays clean
+func FirstRun(command string) {
+	if firstRunSkipped[command] || !style.Hints() || machineOutput() || !stdoutTerminal() || !fs.IsHomelabRepository() {
+		return
+	}
+	if _, err := os.Stat(paths.FirstRunMarker); err == nil {
+		return
+	}
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil || len(enabled) > 0 {
+		return
+	}
+
+	ui.ToStderr(func() {
+		ui.Blank()
+		ui.Step("Getting started: no stack is enabled in this repository yet")
+		if available, _ := fs.GetAvailableStacks(); len(available) > 0 {
+			ui.NextSteps(
+				"See the stacks: homelabctl list",
+				"Enable one, with its dependencies: homelabctl enable <stack> --with-deps",
+				"Deploy: homelabctl deploy",
+			)
+		} else {
+			ui.NextSteps(
+				"Add stacks from the catalog: homelabctl init --template <name>",
+				"Or write one: stacks/<name>/stack.yaml and compose.yml.tmpl",
+				"Or try a throwaway walkthrough: homelabctl demo",
+			)
+		}
+		ui.Hint("Shown once; hide guidance with output.hints: false in %s", style.ConfigFile())
+	})
+
+	// The guidance is best effort: a repository that can't record it shows it again
+	if err := os.MkdirAll(paths.Runtime, paths.DirPermissions); err == nil {
+		os.WriteFile(paths.FirstRunMarker, nil, paths.FilePermissions)
+	}
+}
+
+// stdoutTerminal reports whether stdout is an interactive terminal; tests replace it
+var stdoutTerminal = func() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -3,37 +3,373 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
 	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/history"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/paths"
 	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/render"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/terraform"
+	"github.com/monkeymonk/homelabctl/internal/ui"
 )
 
 // Generate renders all templates and creates runtime files
-func Generate() error {
-	fmt.Println("Generating runtime files...")
+// --annotate comments each merged service, volume and network with its source stack
+// --build then builds the services with a build section (--no-cache, --pull passed on)
+// --terraform also writes the deployment as Terraform configuration for the docker provider
+// Once done, it summarizes the impact: stacks, services, volumes, networks and published
+// ports, and what changed since the previous generation
+// --stages lists the pipeline stages, plugins of inventory/plugins.yaml included, and exits
+// --watch generates again whenever the repository changes, --deploy deploying instead
+// Given stacks, only their templates are rendered; the other stacks are kept as generated
+func Generate(args ...string) error {
+	usage := "usage: homelabctl generate [stack...] [--annotate] [--terraform] [--build [--no-cache] [--pull]] [--stages] [--watch [--deploy] [--debounce <duration>]]"
+	annotate := false
+	listStages := false
+	terraformOutput := false
+	build := false
+	watchMode := false
+	deploy := false
+	debounce := defaultWatchDebounce
+	var buildArgs []string
+	var selected []string
+	var runArgs []string // The arguments each run of --watch generates with
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch name, value, hasValue := strings.Cut(arg, "="); {
+		case arg == "--annotate":
+			annotate = true
+		case arg == "--terraform":
+			terraformOutput = true
+		case arg == "--build":
+			build = true
+		case arg == "--stages":
+			listStages = true
+		case arg == "--no-cache" || arg == "--pull":
+			buildArgs = append(buildArgs, arg)
+		case arg == "--watch":
+			watchMode = true
+			continue
+		case arg == "--deploy":
+			deploy = true
+			continue
+		case name == "--debounce":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("--debounce requires a value (%s)", usage)
+				}
+				value = args[i+1]
+				i++
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return fmt.Errorf("invalid --debounce value: %s (e.g. 500ms, 2s)", value)
+			}
+			debounce = d
+			continue
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+			}
+			selected = append(selected, arg)
+		}
+		runArgs = append(runArgs, arg)
+	}
+	if len(buildArgs) > 0 && !build {
+		return fmt.Errorf("%s requires --build (%s)", buildArgs[0], usage)
+	}
+	if listStages {
+		return printStages()
+	}
+	if (deploy || debounce != defaultWatchDebounce) && !watchMode {
+		return fmt.Errorf("--deploy and --debounce require --watch (%s)", usage)
+	}
+	if watchMode {
+		if deploy && (annotate || terraformOutput || build) {
+			return fmt.Errorf("--watch --deploy only takes stack names: deploy generates itself (%s)", usage)
+		}
+		run := func() error { return Generate(runArgs...) }
+		if deploy {
+			run = func() error { return Deploy(selected) }
+		}
+		return watchRepository(run, debounce)
+	}
+
+	ui.Step("Generating runtime files...")
 
 	// Verify repository
 	if err := fs.VerifyRepository(); err != nil {
 		return err
 	}
 
+	// Prevent concurrent runs from interleaving runtime writes
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Check debug mode
 	debug := os.Getenv("HOMELAB_DEBUG") == "1"
 	if debug {
-		fmt.Println("DEBUG MODE: Temporary files will be preserved")
+		ui.Step("DEBUG MODE: Temporary files will be preserved")
+		ui.Step("DEBUG MODE: Template contexts are kept in %s/", paths.DebugDir)
+	}
+	render.SetDebug(debug)
+
+	retention, err := historyRetention()
+	if err != nil {
+		return err
 	}
 
+	// The generation the impact summary compares with
+	previous, previousCompose := previousGeneration()
+
 	// Build and execute pipeline
-	p := pipeline.New()
-	p.AddStage(pipeline.LoadStacksStage()).
-		AddStage(pipeline.LoadInventoryStage()).
-		AddStage(pipeline.MergeVariablesStage()).
-		AddStage(pipeline.FilterServicesStage()).
-		AddStage(pipeline.RenderTemplatesStage()).
-		AddStage(pipeline.MergeComposeStage()).
-		AddStage(pipeline.FilterDisabledComposeStage()).
-		AddStage(pipeline.WriteOutputStage()).
-		AddStage(pipeline.CleanupStage(debug)) // Skip cleanup in debug mode
-
-	return p.Execute()
+	p, err := generatePipeline(annotate, true, debug, selected, &retention)
+	if err != nil {
+		return err
+	}
+	err = p.Execute()
+
+	// Report warnings even when a later stage failed
+	addWarnings(p.Context().Warnings...)
+
+	if err != nil {
+		return err
+	}
+
+	if generated := p.Context().MergedCompose; generated != nil {
+		printImpact(generationImpact(generated, previous, previousCompose))
+	}
+
+	if terraformOutput {
+		if err := writeTerraform(); err != nil {
+			return err
+		}
+	}
+
+	// Copy the new runtime/ to the host selected with --host
+	if err := syncRuntime(); err != nil {
+		return err
+	}
+
+	if build {
+		return buildServices(buildArgs)
+	}
+	return nil
+}
+
+// generatePipeline builds the generate pipeline; without apply it stops before
+// writing, leaving the merged compose file in the context and runtime/ untouched
+// selected limits rendering to some stacks (all when empty); retention prunes
+// runtime/history/ once done (nil: left as is). The plugins of inventory/plugins.yaml
+// are inserted where they ask to run
+func generatePipeline(annotate, apply, debug bool, selected []string, retention *history.Retention) (*pipeline.Pipeline, error) {
+	plugins, err := inventory.LoadPlugins()
+	if err != nil {
+		return nil, err
+	}
+	stages, err := pipeline.InsertPlugins(generateStages(annotate, apply, debug, selected, retention), plugins, apply)
+	if err != nil {
+		return nil, err
+	}
+	return pipeline.New().AddStages(stages...), nil
+}
+
+// generateStages lists the stages of the generate pipeline by name, the names
+// inventory/plugins.yaml orders plugins against
+func generateStages(annotate, apply, debug bool, selected []string, retention *history.Retention) []pipeline.NamedStage {
+	stages := []pipeline.NamedStage{
+		{Name: "load-stacks", Stage: pipeline.LoadStacksStage()},
+		{Name: "select-stacks", Stage: pipeline.SelectStacksStage(selected)},
+		{Name: "load-inventory", Stage: pipeline.LoadInventoryStage()},
+		{Name: "merge-variables", Stage: pipeline.MergeVariablesStage()},
+	}
+
+	// Hooks change the host, only a generate that writes runs them
+	if apply {
+		stages = append(stages, pipeline.NamedStage{Name: "pre-generate-hooks", Stage: pipeline.HooksStage(stacks.HookPreGenerate)})
+	}
+
+	stages = append(stages, []pipeline.NamedStage{
+		{Name: "filter-services", Stage: pipeline.FilterServicesStage()},
+		{Name: "render-templates", Stage: pipeline.RenderTemplatesStage()},
+		{Name: "remote-access", Stage: pipeline.RemoteAccessStage()},                 // Cloudflare Tunnel and Tailscale configs of expose_via
+		{Name: "contribution-manifest", Stage: pipeline.ContributionManifestStage()}, // Remove contributions no longer rendered
+		{Name: "contribution-index", Stage: pipeline.ContributionIndexStage()},       // Index (and combine) each provider's contributions
+		{Name: "merge-compose", Stage: pipeline.MergeComposeStage()},
+		{Name: "filter-disabled", Stage: pipeline.FilterDisabledComposeStage()},
+		{Name: "compose-secrets", Stage: pipeline.ComposeSecretsStage()}, // Mount secrets as files (inventory/secrets.yaml render: compose)
+		{Name: "label-services", Stage: pipeline.LabelServicesStage()},
+		{Name: "expose", Stage: pipeline.ExposeStage()},                  // Publish expose sections through the inventory's proxy
+		{Name: "share-volumes", Stage: pipeline.ShareVolumesStage()},     // Declare NFS/SMB shares as driver_opts volumes
+		{Name: "build-context", Stage: pipeline.BuildContextStage()},     // Point build contexts into stacks/, apply build cache settings
+		{Name: "registry-mirror", Stage: pipeline.RegistryMirrorStage()}, // Pull images through the inventory's registry mirrors
+		{Name: "keep-unselected", Stage: pipeline.KeepUnselectedStage()}, // Take unselected stacks from the current output
+		{Name: "security-logs", Stage: pipeline.SecurityLogsStage()},     // Mount the logs security contributions read
+		{Name: "config-hash", Stage: pipeline.ConfigHashStage()},         // Label services with their stack's config digest
+		{Name: "policy", Stage: pipeline.PolicyStage()},                  // Enforce inventory/policies.yaml
+		{Name: "startup-order", Stage: pipeline.StartupOrderStage()},     // Warn about services restarted at boot before their dependencies
+		{Name: "capacity", Stage: capacityStage()},                       // Warn when services exceed inventory/capacity.yaml
+		{Name: "strict", Stage: pipeline.StrictStage(strictMode())},      // Fail on warnings before writing output
+	}...)
+
+	if apply {
+		stages = append(stages, []pipeline.NamedStage{
+			{Name: "write-output", Stage: pipeline.WriteOutputStage(annotate)},
+			{Name: "permissions", Stage: pipeline.PermissionsStage()},              // Mode and owner of staged configs and persistence paths
+			{Name: "scan-secrets", Stage: pipeline.ScanSecretsStage(strictMode())}, // Warn about secret values in world-readable outputs
+			{Name: "commit-output", Stage: pipeline.CommitOutputStage()},           // Swap staged outputs into runtime/
+			{Name: "record-history", Stage: pipeline.RecordHistoryStage()},
+			{Name: "post-generate-hooks", Stage: pipeline.HooksStage(stacks.HookPostGenerate)}, // Once runtime/ holds the new files
+		}...)
+	}
+	return append(stages, pipeline.NamedStage{Name: "cleanup", Stage: pipeline.CleanupStage(debug, retention)}) // Skip cleanup in debug mode
+}
+
+// printStages lists the stages of a generate, marking plugins and those plan skips
+func printStages() error {
+	plugins, err := inventory.LoadPlugins()
+	if err != nil {
+		return err
+	}
+	planned, err := pipeline.InsertPlugins(generateStages(false, false, false, nil, nil), plugins, false)
+	if err != nil {
+		return err
+	}
+	stages, err := pipeline.InsertPlugins(generateStages(false, true, false, nil, nil), plugins, true)
+	if err != nil {
+		return err
+	}
+
+	inPlan := make(map[string]bool, len(planned))
+	for _, stage := range planned {
+		inPlan[stage.Name] = true
+	}
+	commands := make(map[string]string, len(plugins))
+	for _, plugin := range plugins {
+		commands[plugin.Name] = plugin.Command
+	}
+
+	for i, stage := range stages {
+		var notes []string
+		if command, ok := commands[stage.Name]; ok {
+			notes = append(notes, "plugin: "+command)
+		}
+		if !inPlan[stage.Name] {
+			notes = append(notes, "not in plan")
+		}
+		line := fmt.Sprintf("%2d. %s", i+1, stage.Name)
+		if len(notes) > 0 {
+			line += "  (" + strings.Join(notes, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// generatePlan runs the generate pipeline without writing runtime/ and returns the
+// compose file generate would write
+func generatePlan(selected []string) (*compose.ComposeFile, error) {
+	var planned *compose.ComposeFile
+	err := stagePlan(selected, func(merged *compose.ComposeFile, _ string) error {
+		planned = merged
+		return nil
+	})
+	return planned, err
+}
+
+// stagePlan runs the generate pipeline without writing runtime/, then calls use with the
+// compose file generate would write and the staging dir holding the other outputs
+// (configs, env files), which is removed once use returns
+func stagePlan(selected []string, use func(planned *compose.ComposeFile, stagingDir string) error) error {
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	p, err := generatePipeline(false, false, false, selected, nil)
+	if err != nil {
+		return err
+	}
+	err = p.Execute()
+	addWarnings(p.Context().Warnings...)
+	if err == nil {
+		err = use(p.Context().MergedCompose, p.Context().StagingDir)
+	}
+
+	// The staged outputs are never committed
+	if removeErr := os.RemoveAll(p.Context().StagingDir); removeErr != nil {
+		addWarnings(fmt.Sprintf("failed to remove %s: %v", p.Context().StagingDir, removeErr))
+	}
+	return err
+}
+
+// writeTerraform translates the generated compose file to runtime/terraform/main.tf.json
+func writeTerraform() error {
+	project := engine.Project{Name: composeProjectName(), Files: []string{paths.DockerCompose}}
+	data, err := terraform.Render(project)
+	if err != nil {
+		return errors.Wrap(err, "failed to write the terraform configuration",
+			"Services must use keys the docker-api engine supports",
+		).WithClass(errors.ClassValidation)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(paths.TerraformFile), paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(paths.TerraformFile), err)
+	}
+	if err := os.WriteFile(paths.TerraformFile, data, paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", paths.TerraformFile, err)
+	}
+
+	ui.Success("Written: %s", paths.TerraformFile)
+	return nil
+}
+
+// buildServices builds the images of the generated services that have a build section
+func buildServices(buildArgs []string) error {
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	built := compose.BuiltServices(generated)
+	if len(built) == 0 {
+		ui.Step("No services to build")
+		return nil
+	}
+
+	// Build contexts point into the local stacks/ directory, which is not synced
+	host, err := selectedHost()
+	if err != nil {
+		return err
+	}
+	if host != nil {
+		return errors.New(
+			"--build is not supported with --host",
+			"Build on the host, or push the images to a registry the host pulls from",
+		).WithClass(errors.ClassUsage)
+	}
+
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+
+	ui.Blank()
+	ui.Step("Building %d service(s): %s", len(built), strings.Join(built, ", "))
+	args := append(append([]string{"build"}, buildArgs...), built...)
+	if err := e.Compose(args...); err != nil {
+		return fmt.Errorf("docker compose build failed: %w", err)
+	}
+	return nil
 }
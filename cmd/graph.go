@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// Graph prints the dependency graph of the enabled stacks (every available one with
+// --all): as a tree of each stack's requires, or in Graphviz DOT with --format dot
+func Graph(args []string) error {
+	usage := "usage: homelabctl graph [--all] [--format text|dot]"
+	format := "text"
+	all := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--all":
+			all = true
+		case arg == "--format":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--format requires a value (%s)", usage)
+			}
+			format = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+	if format != "text" && format != "dot" {
+		return fmt.Errorf("invalid --format value: %s (available: text, dot)", format)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	names, err := fs.GetEnabledStacks()
+	if all {
+		names, err = fs.GetAvailableStacks()
+	}
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		fmt.Println("No stacks enabled")
+		return nil
+	}
+
+	sorted, err := stacks.SortByCategory(names)
+	if err != nil {
+		return err
+	}
+	graph, err := stacks.NewCycleDetector(sorted)
+	if err != nil {
+		return err
+	}
+
+	// Requires outside the graph
+	missing := "not enabled"
+	if all {
+		missing = "missing"
+	}
+
+	if format == "dot" {
+		groups, err := stacks.GroupByCategory(sorted)
+		if err != nil {
+			return err
+		}
+		fmt.Print(graphDOT(graph, groups, missing))
+		return nil
+	}
+	fmt.Print(graphTree(graph, sorted, missing))
+	return nil
+}
+
+// graphTree renders the stacks no other stack requires, in order, each with the tree of
+// its requires; a stack already expanded is not expanded again, and requires outside the
+// graph are marked missing
+func graphTree(graph *stacks.CycleDetector, order []string, missing string) string {
+	inGraph := make(map[string]bool)
+	for _, name := range graph.Stacks() {
+		inGraph[name] = true
+	}
+
+	var b strings.Builder
+	expanded := make(map[string]bool)
+	var walk func(name, prefix string, path map[string]bool)
+	walk = func(name, prefix string, path map[string]bool) {
+		deps := graph.Dependencies(name)
+		for i, dep := range deps {
+			branch, indent := "├── ", "│   "
+			if i == len(deps)-1 {
+				branch, indent = "└── ", "    "
+			}
+
+			switch {
+			case !inGraph[dep]:
+				fmt.Fprintf(&b, "%s%s%s (%s)\n", prefix, branch, dep, missing)
+			case path[dep]:
+				fmt.Fprintf(&b, "%s%s%s (cycle)\n", prefix, branch, dep)
+			case expanded[dep] && len(graph.Dependencies(dep)) > 0:
+				fmt.Fprintf(&b, "%s%s%s (see above)\n", prefix, branch, dep)
+			default:
+				fmt.Fprintf(&b, "%s%s%s\n", prefix, branch, dep)
+				expanded[dep] = true
+				path[dep] = true
+				walk(dep, prefix+indent, path)
+				delete(path, dep)
+			}
+		}
+	}
+
+	root := func(name string) {
+		expanded[name] = true
+		b.WriteString(name + "\n")
+		walk(name, "", map[string]bool{name: true})
+	}
+	for _, name := range order {
+		if len(graph.Dependents(name)) == 0 {
+			root(name)
+		}
+	}
+	// Stacks of a cycle are all required by another one
+	for _, name := range order {
+		if !expanded[name] {
+			root(name)
+		}
+	}
+
+	return b.String()
+}
+
+// graphDOT renders the graph in Graphviz DOT, with a cluster per category and an
+// edge from each stack to the stacks it requires; requires outside the graph are dashed
+func graphDOT(graph *stacks.CycleDetector, groups map[string][]string, missing string) string {
+	var b strings.Builder
+	b.WriteString("digraph homelab {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+
+	inGraph := make(map[string]bool)
+	for _, cat := range categories.AllCategories() {
+		names := groups[cat.Name]
+		if len(names) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "  subgraph %q {\n", "cluster_"+cat.Name)
+		fmt.Fprintf(&b, "    label=%q;\n", cat.DisplayName)
+		for _, name := range names {
+			fmt.Fprintf(&b, "    %q;\n", name)
+			inGraph[name] = true
+		}
+		b.WriteString("  }\n")
+	}
+
+	for _, name := range graph.Stacks() {
+		for _, dep := range graph.Dependencies(name) {
+			if !inGraph[dep] {
+				fmt.Fprintf(&b, "  %q [style=dashed, label=%q];\n", dep, dep+" ("+missing+")")
+				inGraph[dep] = true
+			}
+			fmt.Fprintf(&b, "  %q -> %q;\n", name, dep)
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
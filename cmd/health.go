@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/health"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// verifyDeployHealth waits for the deployed services that have a healthcheck to become
+// healthy, each within its stack's health_timeout, and fails on the ones that do not
+// No services means every generated service
+func verifyDeployHealth(services []string) error {
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	checks := deployHealthChecks(generated, services)
+	if len(checks) == 0 {
+		return nil
+	}
+
+	ui.Blank()
+	ui.Step("Verifying service health...")
+	result, err := health.Verify(checks, healthStates, waitInterval)
+	if err != nil {
+		return err
+	}
+
+	for _, svc := range result.Healthy {
+		ui.Success("%s healthy", svc)
+	}
+	if len(result.Failures) == 0 {
+		if len(result.Healthy) == 0 {
+			ui.Step("No deployed service has a healthcheck")
+		}
+		return nil
+	}
+
+	failed := make([]string, 0, len(result.Failures))
+	for _, f := range result.Failures {
+		failed = append(failed, fmt.Sprintf("%s (%s): %s", f.Service, f.Stack, f.State))
+	}
+	return errors.New(
+		fmt.Sprintf("%d service(s) not healthy after deploy", len(result.Failures)),
+		"Check the logs: homelabctl logs "+result.Failures[0].Service,
+		"Give slow services more time: health_timeout in their stack.yaml",
+	).WithClass(errors.ClassDocker).WithContext(append([]string{"Unhealthy:"}, failed...)...)
+}
+
+// deployHealthChecks returns a check per service with its stack's health_timeout,
+// the default for stacks without one
+func deployHealthChecks(generated *compose.ComposeFile, services []string) []health.Check {
+	if len(services) == 0 {
+		for svc := range generated.Services {
+			services = append(services, svc)
+		}
+		sort.Strings(services)
+	}
+
+	timeouts := make(map[string]time.Duration)
+	checks := make([]health.Check, 0, len(services))
+	for _, svc := range services {
+		stackName := compose.ServiceLabels(generated, svc)[compose.LabelStack]
+		if _, ok := timeouts[stackName]; !ok {
+			timeouts[stackName] = health.DefaultTimeout
+			if stack, err := stacks.LoadStack(stackName); err == nil {
+				timeouts[stackName] = stack.HealthTimeoutDuration(health.DefaultTimeout)
+			}
+		}
+		checks = append(checks, health.Check{Service: svc, Stack: stackName, Timeout: timeouts[stackName]})
+	}
+	return checks
+}
+
+// healthStates returns the containers of each service of the project
+func healthStates() (map[string][]health.Container, error) {
+	states, err := containerStates()
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make(map[string][]health.Container, len(states))
+	for svc, list := range states {
+		for _, s := range list {
+			containers[svc] = append(containers[svc], health.Container{
+				Service:  s.Service,
+				Status:   s.Status,
+				Health:   s.Health,
+				ExitCode: s.ExitCode,
+			})
+		}
+	}
+	return containers, nil
+}
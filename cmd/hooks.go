@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/hooks"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// runDeployHooks runs the hooks of the deployed stacks (the selected ones, all enabled
+// stacks when none) for a deploy event, with each stack's merged variables
+func runDeployHooks(event string, selected []string) error {
+	stackNames := selected
+	if len(stackNames) == 0 {
+		enabled, err := fs.GetEnabledStacks()
+		if err != nil {
+			return err
+		}
+		stackNames = enabled
+	}
+
+	var inventoryVars map[string]interface{}
+	loaded := false
+	for _, name := range stackNames {
+		stack, err := stacks.LoadStack(name)
+		if err != nil {
+			return err
+		}
+		if len(stack.Hooks[event]) == 0 {
+			continue
+		}
+
+		// Only load the variables once a stack has hooks to run
+		if !loaded {
+			if inventoryVars, err = inventory.LoadVars(); err != nil {
+				return err
+			}
+			loaded = true
+		}
+		config, err := pipeline.BuildStackConfig(name, inventoryVars)
+		if err != nil {
+			return err
+		}
+		if err := hooks.Run(name, event, stack.Hooks[event], config.MergedVars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
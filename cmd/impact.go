@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/history"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// impact is what a generation changes compared with the previous one
+type impact struct {
+	Previous       string // ID of the generation compared with ("" for the first)
+	Stacks         int
+	Services       int
+	Added          []string // Services the previous generation did not have
+	Removed        []string // Services of the previous generation no longer generated
+	Volumes        int
+	NewVolumes     []string
+	Networks       int
+	NewNetworks    []string
+	PublishedPorts int
+	Compared       bool // Volumes and networks compared with the previous compose file
+}
+
+// previousGeneration returns the latest generation of runtime/history/ and its archived
+// compose file (nil when it has none); nil when nothing was generated yet
+func previousGeneration() (*history.Snapshot, *compose.ComposeFile) {
+	snapshots, err := history.List()
+	if err != nil || len(snapshots) == 0 {
+		return nil, nil
+	}
+	latest := snapshots[len(snapshots)-1]
+
+	rel, err := filepath.Rel(paths.Runtime, paths.DockerCompose)
+	if err != nil {
+		return latest, nil
+	}
+	file := history.OutputFile(latest.ID, rel)
+	if _, err := os.Stat(file); err != nil {
+		return latest, nil
+	}
+	previous, err := compose.LoadComposeFile(file)
+	if err != nil {
+		return latest, nil
+	}
+	return latest, previous
+}
+
+// generationImpact compares a generated compose file with the previous generation's
+// snapshot and, when archived, its compose file
+func generationImpact(generated *compose.ComposeFile, previous *history.Snapshot, previousCompose *compose.ComposeFile) impact {
+	result := impact{
+		Services: len(generated.Services),
+		Volumes:  len(generated.Volumes),
+		Networks: len(generated.Networks),
+	}
+
+	stackNames := make(map[string]bool)
+	for svc := range generated.Services {
+		if stack := compose.ServiceLabels(generated, svc)[compose.LabelStack]; stack != "" {
+			stackNames[stack] = true
+		}
+		result.PublishedPorts += len(compose.PublishedPorts(generated, svc))
+	}
+	result.Stacks = len(stackNames)
+
+	if previous == nil {
+		return result
+	}
+	result.Previous = previous.ID
+
+	before := make(map[string]bool)
+	for _, stack := range previous.Stacks {
+		for svc := range stack.Services {
+			before[svc] = true
+		}
+	}
+	for svc := range generated.Services {
+		if !before[svc] {
+			result.Added = append(result.Added, svc)
+		}
+	}
+	for svc := range before {
+		if _, ok := generated.Services[svc]; !ok {
+			result.Removed = append(result.Removed, svc)
+		}
+	}
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+
+	if previousCompose != nil {
+		result.Compared = true
+		result.NewVolumes = newKeys(generated.Volumes, previousCompose.Volumes)
+		result.NewNetworks = newKeys(generated.Networks, previousCompose.Networks)
+	}
+	return result
+}
+
+// newKeys returns the keys of current missing from previous, sorted
+func newKeys(current, previous map[string]interface{}) []string {
+	var keys []string
+	for key := range current {
+		if _, ok := previous[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// printImpact prints the summary of a generation's impact
+func printImpact(i impact) {
+	fmt.Println("\nImpact:")
+
+	services := fmt.Sprintf("%d stack(s), %d service(s)", i.Stacks, i.Services)
+	if i.Previous == "" {
+		services += " (first generation)"
+	} else {
+		services += fmt.Sprintf(" (%d new, %d removed since %s)", len(i.Added), len(i.Removed), i.Previous)
+	}
+	fmt.Println("  " + services)
+	if len(i.Added) > 0 {
+		fmt.Println("    + " + strings.Join(i.Added, ", "))
+	}
+	if len(i.Removed) > 0 {
+		fmt.Println("    - " + strings.Join(i.Removed, ", "))
+	}
+
+	objects := func(kind string, total int, added []string) string {
+		line := fmt.Sprintf("%d %s", total, kind)
+		if i.Compared && len(added) > 0 {
+			line += fmt.Sprintf(" (new: %s)", strings.Join(added, ", "))
+		}
+		return line
+	}
+	fmt.Printf("  %s, %s\n", objects("volume(s)", i.Volumes, i.NewVolumes), objects("network(s)", i.Networks, i.NewNetworks))
+	fmt.Printf("  %d published port(s)\n", i.PublishedPorts)
+}
@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/render"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// Info shows a stack's metadata and its rendered README and post-install notes
+func Info(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: homelabctl info <stack>")
+	}
+	stackName := args[0]
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	if !fs.StackExists(stackName) {
+		return errors.New(
+			fmt.Sprintf("stack '%s' does not exist", stackName),
+			"Run: homelabctl list",
+			"Check stacks/ directory for available stacks",
+		).WithClass(errors.ClassNotFound).WithKind(errors.ErrStackNotFound)
+	}
+
+	stack, err := stacks.LoadStack(stackName)
+	if err != nil {
+		return err
+	}
+
+	disabledServices, err := inventory.GetDisabledServices()
+	if err != nil {
+		return err
+	}
+	disabled := stacks.EnabledStacksMap(disabledServices)
+
+	status := "disabled"
+	if fs.IsStackEnabled(stackName) {
+		status = "enabled"
+		if enabledAt, ok := inventory.StackEnabledAt(stackName); ok {
+			status += fmt.Sprintf(" (since %s)", enabledAt.Local().Format("2006-01-02 15:04"))
+		}
+	}
+
+	fmt.Printf("Stack: %s\n", stack.Name)
+	fmt.Printf("  Category: %s\n", categoryColor(stack.Category))
+	fmt.Printf("  Status:   %s\n", status)
+	if len(stack.Requires) > 0 {
+		fmt.Printf("  Requires: %s\n", strings.Join(stack.Requires, ", "))
+	}
+
+	fmt.Println("  Services:")
+	for _, svc := range stack.Services {
+		if disabled[inventory.QualifiedName(stackName, svc)] {
+			fmt.Printf("    ⨯ %s (disabled)\n", svc)
+		} else {
+			fmt.Printf("    • %s\n", svc)
+		}
+	}
+
+	if len(stack.Persistence.Volumes) > 0 || len(stack.Persistence.Paths) > 0 || len(stack.Persistence.Shares) > 0 {
+		fmt.Println("  Persistence:")
+		for _, vol := range stack.Persistence.Volumes {
+			fmt.Printf("    • volume: %s\n", vol)
+		}
+		for _, path := range stack.Persistence.Paths {
+			fmt.Printf("    • path:   %s\n", path)
+		}
+		for _, name := range stack.ShareNames() {
+			fmt.Printf("    • share:  %s (%s)\n", name, stack.Persistence.Shares[name])
+		}
+	}
+
+	if len(stack.VarsSchema) > 0 {
+		fmt.Println("  Variables:")
+		for _, path := range stack.SchemaPaths() {
+			schema := stack.VarsSchema[path]
+			value := "(required)"
+			if current, ok := stacks.LookupVar(stack.Vars, path); ok {
+				value = fmt.Sprintf("default: %v", current)
+			} else if !schema.Required {
+				value = "(optional)"
+			}
+			fmt.Printf("    • %s %s\n", path, value)
+			if schema.Description != "" {
+				fmt.Printf("        %s\n", schema.Description)
+			}
+		}
+	}
+
+	docs := []struct {
+		title    string
+		template string
+		rendered string
+	}{
+		{"README", paths.StackReadmeTemplate(stackName), paths.RuntimeReadme(stackName)},
+		{"Notes", paths.StackNotesTemplate(stackName), paths.RuntimeNotes(stackName)},
+	}
+
+	for _, doc := range docs {
+		if _, err := os.Stat(doc.template); err != nil {
+			continue
+		}
+
+		content, err := renderStackDocument(stackName, doc.template)
+		if err != nil {
+			// Fall back to the copy rendered by the last generate
+			data, readErr := os.ReadFile(doc.rendered)
+			if readErr != nil {
+				fmt.Printf("\nWarning: failed to render %s: %v\n", doc.template, err)
+				continue
+			}
+			content = string(data)
+		}
+
+		fmt.Printf("\n%s:\n\n%s\n", doc.title, strings.TrimRight(content, "\n"))
+	}
+
+	return nil
+}
+
+// renderStackDocument renders a stack template in memory with the stack's merged vars
+func renderStackDocument(stackName, templatePath string) (string, error) {
+	inventoryVars, err := inventory.LoadVars()
+	if err != nil {
+		return "", err
+	}
+	if err := render.SetEngine(inventory.RenderEngine(inventoryVars)); err != nil {
+		return "", err
+	}
+
+	config, err := pipeline.BuildStackConfig(stackName, inventoryVars)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := inventory.LoadState()
+	if err != nil {
+		return "", err
+	}
+	for _, svc := range config.Services {
+		if state.IsServiceDisabled(stackName, svc) {
+			config.Disabled = append(config.Disabled, svc)
+		}
+	}
+
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return "", err
+	}
+
+	return render.RenderTemplate(templatePath, pipeline.TemplateContext(config, enabled))
+}
+
+// showDeployNotes prints post-install notes of deployed stacks that were not shown yet
+// Notes are shown again whenever their rendered content changes
+func showDeployNotes(stackNames []string) {
+	for _, stackName := range stackNames {
+		data, err := os.ReadFile(paths.RuntimeNotes(stackName))
+		if err != nil {
+			continue
+		}
+
+		hash := contentHash(data)
+		if shown, err := os.ReadFile(paths.RuntimeNotesShown(stackName)); err == nil && string(shown) == hash {
+			continue
+		}
+
+		fmt.Printf("\nNotes for %s:\n\n%s\n", stackName, strings.TrimRight(string(data), "\n"))
+
+		if err := os.WriteFile(paths.RuntimeNotesShown(stackName), []byte(hash), paths.FilePermissions); err != nil {
+			fmt.Printf("Warning: failed to record shown notes for %s: %v\n", stackName, err)
+		}
+	}
+}
+
+// contentHash returns the hex sha256 of data
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
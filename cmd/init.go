@@ -4,43 +4,82 @@ import (
 	"fmt"
 	"os"
 
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/catalog"
 	"github.com/monkeymonk/homelabctl/internal/fs"
 	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
 )
 
 // Init initializes a new homelab repository or verifies an existing one
-func Init() error {
+// With --template, the repository is seeded with a bundle of stacks from a catalog
+func Init(args []string) error {
+	var templateArg string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--template", "-t":
+			if i+1 >= len(args) {
+				return fmt.Errorf("usage: homelabctl init [--template <name|git-url>]")
+			}
+			i++
+			templateArg = args[i]
+		default:
+			return fmt.Errorf("unexpected argument: %s", args[i])
+		}
+	}
+
+	if err := initRepository(templateArg == ""); err != nil {
+		return err
+	}
+
+	if templateArg != "" {
+		return seedFromTemplate(templateArg)
+	}
+
+	return nil
+}
+
+// initRepository creates or verifies the repository structure
+func initRepository(showNextSteps bool) error {
 	// Check if this is already a homelab repository
 	if !fs.IsHomelabRepository() {
-		fmt.Println("No homelab repository found. Initializing new repository...")
+		ui.Step("No homelab repository found. Initializing new repository...")
 
 		if err := fs.InitializeRepository(); err != nil {
 			return fmt.Errorf("failed to initialize repository: %w", err)
 		}
 
-		fmt.Println()
-		fmt.Println("✓ Repository initialized successfully!")
-		fmt.Println()
-		fmt.Println("Created structure:")
-		fmt.Println("  stacks/           - Place your stack definitions here")
-		fmt.Println("  enabled/          - Symlinks to enabled stacks")
-		fmt.Println("  inventory/        - Your environment configuration")
-		fmt.Println("  secrets/          - Encrypted secrets")
-		fmt.Println("  .gitignore        - Protects sensitive files")
-		fmt.Println("  README.md         - Getting started guide")
-		fmt.Println()
-		fmt.Println("Next steps:")
-		fmt.Println("  1. Create stack definitions in stacks/")
-		fmt.Println("  2. Enable stacks: homelabctl enable <stack>")
-		fmt.Println("  3. Configure: edit inventory/vars.yaml")
-		fmt.Println("  4. Deploy: homelabctl deploy")
-		fmt.Println()
+		ui.Blank()
+		ui.Success("Repository initialized successfully!")
+		ui.Blank()
+		ui.Step("Created structure:")
+		ui.Detail("stacks/           - Place your stack definitions here")
+		ui.Detail("enabled/          - Symlinks to enabled stacks")
+		ui.Detail("inventory/        - Your environment configuration")
+		ui.Detail("secrets/          - Encrypted secrets")
+		ui.Detail(".gitignore        - Protects sensitive files")
+		ui.Detail("README.md         - Getting started guide")
+		ui.Blank()
+		if !showNextSteps {
+			return nil
+		}
+		ui.NextSteps(
+			"Create stack definitions in stacks/",
+			"Enable stacks: homelabctl enable <stack>",
+			"Configure: edit inventory/vars.yaml",
+			"Deploy: homelabctl deploy",
+		)
+		ui.Blank()
 
 		return nil
 	}
 
 	// Existing repository - verify it
-	fmt.Println("Verifying homelab repository structure...")
+	ui.Step("Verifying homelab repository structure...")
 
 	if err := fs.VerifyRepository(); err != nil {
 		return fmt.Errorf("repository verification failed: %w", err)
@@ -57,8 +96,145 @@ func Init() error {
 		return fmt.Errorf("failed to read enabled stacks: %w", err)
 	}
 
-	fmt.Printf("✓ Repository structure valid\n")
-	fmt.Printf("✓ Found %d enabled stack(s)\n", len(enabled))
+	ui.Success("Repository structure valid")
+	ui.Success("Found %d enabled stack(s)", len(enabled))
+
+	return nil
+}
+
+// seedFromTemplate copies a template's stacks (with their dependencies) from a catalog,
+// enables them and seeds inventory variables
+// The argument is a template name in the configured catalog, or a git URL / directory
+// containing a template.yaml bundle at its root
+func seedFromTemplate(arg string) error {
+	source, name := catalog.Source(), arg
+	if catalog.IsGitURL(arg) || isDir(arg) {
+		source, name = arg, ""
+	}
+
+	// The configured catalog keeps its signing keys from inventory/catalogs.yaml
+	cfg := &catalog.Config{Name: source, Source: source}
+	if name != "" {
+		var err error
+		if cfg, err = catalog.ConfigFor(catalog.DefaultName); err != nil {
+			return err
+		}
+	}
+
+	ui.Blank()
+	ui.Step("Fetching template from %s...", source)
+	cat, err := catalog.OpenConfig(cfg)
+	if err != nil {
+		return err
+	}
+	defer cat.Close()
+
+	tmpl, err := cat.LoadTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	stackNames, err := cat.ResolveStacks(tmpl.Stacks)
+	if err != nil {
+		return err
+	}
+
+	ui.Step("Template: %s", tmpl.Name)
+	if tmpl.Description != "" {
+		ui.Detail("%s", tmpl.Description)
+	}
+
+	for _, stackName := range stackNames {
+		if fs.StackExists(stackName) {
+			ui.Detail("• %s (already present, kept)", stackName)
+			continue
+		}
+		verification, err := cat.InstallStack(stackName)
+		if err != nil {
+			return err
+		}
+		ui.Detail("✓ Added stack %s (%s)", stackName, describeVerification(verification))
+		if verification.Method == catalog.VerifiedNone {
+			addWarnings(fmt.Sprintf("stack %s is not verified: the catalog publishes no %s", stackName, catalog.ChecksumsFile))
+		}
+	}
+
+	ordered, err := stacks.SortByDependencies(stackNames)
+	if err != nil {
+		return err
+	}
+
+	for _, stackName := range ordered {
+		if fs.IsStackEnabled(stackName) {
+			continue
+		}
+		if err := fs.EnableStack(stackName); err != nil {
+			return err
+		}
+	}
+	ui.Success("Enabled %d stack(s)", len(ordered))
+
+	added, err := seedInventoryVars(tmpl)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		ui.Success("Added %d variable(s) to %s", added, paths.InventoryVars)
+	}
+
+	ui.Blank()
+	ui.NextSteps(
+		"Review variables: homelabctl vars example",
+		"Configure: edit inventory/vars.yaml",
+		"Deploy: homelabctl deploy",
+	)
 
 	return nil
 }
+
+// seedInventoryVars appends template variables missing from inventory/vars.yaml
+// The existing file (and its comments) is left untouched
+func seedInventoryVars(tmpl *catalog.Template) (int, error) {
+	if len(tmpl.Vars) == 0 {
+		return 0, nil
+	}
+
+	existing, err := inventory.LoadBaseVars()
+	if err != nil {
+		return 0, err
+	}
+
+	missing := make(map[string]interface{})
+	for key, value := range tmpl.Vars {
+		if _, exists := existing[key]; !exists {
+			missing[key] = value
+		}
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	data, err := yaml.Marshal(missing)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode template variables: %w", err)
+	}
+
+	f, err := os.OpenFile(paths.InventoryVars, os.O_APPEND|os.O_WRONLY, paths.FilePermissions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", paths.InventoryVars, err)
+	}
+	defer f.Close()
+
+	content := fmt.Sprintf("\n# From template %s\n%s", tmpl.Name, data)
+	if _, err := f.WriteString(content); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", paths.InventoryVars, err)
+	}
+
+	return len(missing), nil
+}
+
+// isDir reports whether path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
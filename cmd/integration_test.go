@@ -1,10 +1,36 @@
 package cmd
 
 import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
-
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/catalog"
+	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/docker"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/health"
+	"github.com/monkeymonk/homelabctl/internal/history"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/query"
+	"github.com/monkeymonk/homelabctl/internal/registry"
+	"github.com/monkeymonk/homelabctl/internal/render"
+	"github.com/monkeymonk/homelabctl/internal/sbom"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
 	"github.com/monkeymonk/homelabctl/internal/testutil"
 )
 
@@ -143,7 +169,7 @@ func TestValidateCommand(t *testing.T) {
 	testutil.EnableStack(t, "monitoring")
 
 	// Validate should succeed
-	err := Validate()
+	err := Validate(nil)
 	if err != nil {
 		t.Errorf("Validate() failed: %v", err)
 	}
@@ -152,7 +178,7 @@ func TestValidateCommand(t *testing.T) {
 	testutil.CreateStack(t, "broken", []string{"nonexistent"}, []string{"app"})
 	testutil.EnableStack(t, "broken")
 
-	err = Validate()
+	err = Validate(nil)
 	if err == nil {
 		t.Error("Validate() should fail with unsatisfied dependencies")
 	}
@@ -175,7 +201,7 @@ func TestValidateCommand_NoCycle(t *testing.T) {
 	testutil.EnableStack(t, "stack-b")
 
 	// Validate should detect cycle
-	err := Validate()
+	err := Validate(nil)
 	if err == nil {
 		t.Error("Validate() should detect circular dependency")
 	}
@@ -191,7 +217,7 @@ func TestValidateCommand_NoStacks(t *testing.T) {
 	testutil.CreateRepoStructure(t)
 
 	// Validate with no enabled stacks should fail
-	err := Validate()
+	err := Validate(nil)
 	if err == nil {
 		t.Error("Validate() should fail with no enabled stacks")
 	}
@@ -215,7 +241,7 @@ func TestValidateCommand_MissingStackYaml(t *testing.T) {
 	testutil.CreateSymlink(t, target, link)
 
 	// Validate should fail
-	err := Validate()
+	err := Validate(nil)
 	if err == nil {
 		t.Error("Validate() should fail with missing stack.yaml")
 	}
@@ -239,12 +265,59 @@ func TestValidateCommand_MissingComposeTemplate(t *testing.T) {
 	testutil.EnableStack(t, "incomplete")
 
 	// Validate should fail
-	err := Validate()
+	err := Validate(nil)
 	if err == nil {
 		t.Error("Validate() should fail with missing compose.yml.tmpl")
 	}
 }
 
+func TestValidateCommand_Render(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", []string{}, []string{"traefik"})
+	testutil.EnableStack(t, "core")
+
+	// gomplate is selected, but --render uses the built-in engine
+	testutil.WriteFile(t, "inventory/vars.yaml", "render:\n  engine: gomplate\n")
+	testutil.WriteFile(t, "stacks/core/config/traefik.yml.tmpl", "entryPoints: {{ .stack.name }}\n")
+
+	engine := render.SelectedEngine()
+	out, err := captureStdout(t, func() error { return Validate([]string{"--render"}) })
+	if err != nil {
+		t.Fatalf("Validate(--render) failed: %v", err)
+	}
+	if !strings.Contains(out, "✓ All 2 templates render with the built-in engine") {
+		t.Errorf("Validate(--render) output = %q, want the rendered templates", out)
+	}
+	if _, err := os.Stat("runtime/core"); err == nil {
+		t.Error("Validate(--render) should not write runtime/")
+	}
+	if render.SelectedEngine() != engine {
+		t.Errorf("Validate(--render) left the engine set to %s", render.SelectedEngine())
+	}
+
+	// Rendered YAML must parse
+	testutil.WriteFile(t, "stacks/core/config/traefik.yml.tmpl", "entryPoints: [{{ .stack.name }}\n")
+	if _, err := captureStdout(t, func() error { return Validate([]string{"--render"}) }); err == nil {
+		t.Error("Validate(--render) should fail on invalid rendered YAML")
+	}
+
+	// Unknown functions fail to type-check
+	testutil.WriteFile(t, "stacks/core/config/traefik.yml.tmpl", "entryPoints: {{ nosuchfunc }}\n")
+	if _, err := captureStdout(t, func() error { return Validate([]string{"--render"}) }); err == nil {
+		t.Error("Validate(--render) should fail on an unknown template function")
+	}
+
+	if err := Validate([]string{"--bogus"}); err == nil {
+		t.Error("Validate() should reject unknown arguments")
+	}
+}
+
 func TestGenerateCommand(t *testing.T) {
 	tmpDir, cleanup := testutil.TempDir(t)
 	defer cleanup()
@@ -277,10 +350,7 @@ networks:
 
 	testutil.EnableStack(t, "core")
 
-	// Generate should succeed
-	// Note: This requires gomplate to be installed
-	// In CI, we might want to skip or mock this
-	t.Skip("Skipping generate test - requires gomplate binary")
+	// Generate should succeed (with the native engine when gomplate is not installed)
 
 	err := Generate()
 	if err != nil {
@@ -386,6 +456,55 @@ func TestDisableService(t *testing.T) {
 	}
 }
 
+func TestDisableService_Qualified(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+
+	// Two enabled stacks define grafana
+	testutil.CreateStack(t, "monitoring", []string{}, []string{"grafana", "prometheus"})
+	testutil.CreateStack(t, "dashboards", []string{}, []string{"grafana"})
+	testutil.EnableStack(t, "monitoring")
+	testutil.EnableStack(t, "dashboards")
+
+	// A bare name defined by several stacks is ambiguous
+	err := Disable([]string{"-s", "grafana"})
+	if err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("Disable(grafana) error = %v, want ambiguous name error", err)
+	}
+
+	// The stack-qualified name disables only that stack's service
+	if err := Disable([]string{"-s", "monitoring/grafana"}); err != nil {
+		t.Fatalf("Disable(monitoring/grafana) failed: %v", err)
+	}
+
+	disabled, err := inventory.GetDisabledServices()
+	if err != nil {
+		t.Fatalf("GetDisabledServices() failed: %v", err)
+	}
+	if len(disabled) != 1 || disabled[0] != "monitoring/grafana" {
+		t.Errorf("disabled services = %v, want [monitoring/grafana]", disabled)
+	}
+
+	// A unique bare name still works
+	if err := Disable([]string{"-s", "prometheus"}); err != nil {
+		t.Errorf("Disable(prometheus) failed: %v", err)
+	}
+
+	// Qualified names must match a stack that defines the service
+	if err := Disable([]string{"-s", "dashboards/prometheus"}); err == nil {
+		t.Error("Disable(dashboards/prometheus) should fail")
+	}
+
+	if err := Enable([]string{"-s", "monitoring/grafana"}); err != nil {
+		t.Errorf("Enable(monitoring/grafana) failed: %v", err)
+	}
+}
+
 func TestEnableWithDependencyCheck(t *testing.T) {
 	tmpDir, cleanup := testutil.TempDir(t)
 	defer cleanup()
@@ -429,25 +548,68 @@ func TestDisableWithDependents(t *testing.T) {
 
 	testutil.CreateRepoStructure(t)
 
-	// Create stacks with dependencies
+	// Create stacks with dependencies: app requires db, which requires core
 	testutil.CreateStack(t, "core", []string{}, []string{"traefik"})
-	testutil.CreateStack(t, "app", []string{"core"}, []string{"webapp"})
-
-	// Enable both
-	testutil.EnableStack(t, "core")
-	testutil.EnableStack(t, "app")
+	testutil.CreateStack(t, "db", []string{"core"}, []string{"postgres"})
+	testutil.CreateStack(t, "app", []string{"db"}, []string{"webapp"})
+	testutil.CreateStack(t, "tools", []string{}, []string{"whoami"})
+	for _, name := range []string{"core", "db", "app", "tools"} {
+		testutil.EnableStack(t, name)
+	}
+	disabled := func(name string) bool {
+		_, err := os.Lstat(filepath.Join("enabled", name))
+		return os.IsNotExist(err)
+	}
 
-	// Disable core (has dependent)
-	// Current implementation shows a warning but doesn't fail
+	// Refused while enabled stacks require it, directly or not
 	err := Disable([]string{"core"})
-	if err != nil {
-		t.Errorf("Disable(core) should succeed with warning: %v", err)
+	if err == nil || !strings.Contains(err.Error(), "required by enabled stacks: db, app") {
+		t.Errorf("Disable(core) error = %v, want refusal naming db and app", err)
+	}
+	if disabled("core") {
+		t.Error("core should still be enabled")
 	}
 
-	// Verify core is disabled
-	linkPath := filepath.Join("enabled", "core")
-	if _, err := os.Lstat(linkPath); !os.IsNotExist(err) {
-		t.Error("Symlink should have been removed")
+	// --cascade disables the dependents first
+	if err := Disable([]string{"db", "--cascade"}); err != nil {
+		t.Fatalf("Disable(db --cascade) error = %v", err)
+	}
+	if !disabled("db") || !disabled("app") || disabled("core") || disabled("tools") {
+		t.Error("--cascade should disable db and app only")
+	}
+
+	// --force leaves the dependents enabled, with a warning
+	testutil.EnableStack(t, "db")
+	collectedWarnings = nil
+	if err := Disable([]string{"core", "--force"}); err != nil {
+		t.Fatalf("Disable(core --force) error = %v", err)
+	}
+	if !disabled("core") || disabled("db") {
+		t.Error("--force should disable core only")
+	}
+	if warnings := Warnings(); len(warnings) != 1 || !strings.Contains(warnings[0], "required by enabled stacks: db") {
+		t.Errorf("Warnings() = %v, want the dependents left enabled", warnings)
+	}
+
+	if err := Disable([]string{"db", "--cascade", "--force"}); err == nil {
+		t.Error("--cascade with --force should fail")
+	}
+}
+
+func TestResumableServices(t *testing.T) {
+	states := map[string][]containerState{
+		"grafana":    {{Service: "grafana", Status: "paused"}},
+		"prometheus": {{Service: "prometheus", Status: "exited"}},
+		"loki":       {{Service: "loki", Status: "running"}},
+		"promtail":   {{Service: "promtail", Status: "paused"}, {Service: "promtail", Status: "created"}},
+	}
+
+	paused, stopped := resumableServices([]string{"grafana", "loki", "prometheus", "promtail", "tempo"}, states)
+	if !reflect.DeepEqual(paused, []string{"grafana", "promtail"}) {
+		t.Errorf("paused = %v, want grafana and promtail", paused)
+	}
+	if !reflect.DeepEqual(stopped, []string{"prometheus", "promtail"}) {
+		t.Errorf("stopped = %v, want prometheus and promtail", stopped)
 	}
 }
 
@@ -478,8 +640,2283 @@ vars:
 	testutil.EnableStack(t, "broken")
 
 	// Validate should fail
-	err := Validate()
+	err := Validate(nil)
 	if err == nil {
 		t.Error("Validate() should fail with missing service definition")
 	}
 }
+
+func TestResolveStackServices(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+
+	testutil.CreateStack(t, "monitoring", []string{}, []string{"grafana", "prometheus"})
+	testutil.CreateStack(t, "media", []string{}, []string{"jellyfin"})
+	testutil.EnableStack(t, "monitoring")
+
+	// prometheus is disabled, so it is absent from the generated file
+	testutil.WriteFile(t, "runtime/docker-compose.yml", `services:
+  grafana:
+    image: grafana/grafana
+  jellyfin:
+    image: jellyfin/jellyfin
+`)
+
+	services, err := resolveStackServices("monitoring")
+	if err != nil {
+		t.Fatalf("resolveStackServices(monitoring) failed: %v", err)
+	}
+
+	if len(services) != 1 || services[0] != "grafana" {
+		t.Errorf("Expected [grafana], got %v", services)
+	}
+
+	// Unknown stacks fail
+	if _, err := resolveStackServices("nonexistent"); err == nil {
+		t.Error("resolveStackServices(nonexistent) should fail")
+	}
+
+	// Argument splitting only treats existing stacks as scoped targets
+	if name, rest := splitStackArg([]string{"monitoring", "--volumes"}); name != "monitoring" || len(rest) != 1 {
+		t.Errorf("splitStackArg() = %q, %v", name, rest)
+	}
+
+	if name, _ := splitStackArg([]string{"grafana"}); name != "" {
+		t.Errorf("splitStackArg(grafana) should not resolve a stack, got %q", name)
+	}
+}
+
+func TestTemplatesReferencing(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "media", []string{}, []string{"jellyfin"})
+	testutil.WriteFile(t, "stacks/media/compose.yml.tmpl", `services:
+  jellyfin:
+    volumes:
+      - jellyfin_config:/config
+      - jellyfin_config_backup:/backup
+      - {{ .vars.jellyfin.cache_volume }}:/cache
+volumes:
+  jellyfin_config:
+`)
+
+	refs := templatesReferencing([]string{"media"}, "jellyfin_config")
+	if len(refs) != 2 {
+		t.Errorf("Expected 2 references (mount and declaration), got %v", refs)
+	}
+
+	if refs := templatesReferencing([]string{"media"}, "jellyfin_cache"); len(refs) != 0 {
+		t.Errorf("Variables should not count as references, got %v", refs)
+	}
+}
+
+func TestInfoCommand(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", []string{}, []string{"traefik"})
+	testutil.EnableStack(t, "core")
+
+	if err := Info([]string{"core"}); err != nil {
+		t.Errorf("Info(core) failed: %v", err)
+	}
+
+	if err := Info([]string{"nonexistent"}); err == nil {
+		t.Error("Info(nonexistent) should fail")
+	}
+
+	if err := Info([]string{}); err == nil {
+		t.Error("Info() without a stack should fail")
+	}
+}
+
+func TestShowDeployNotes(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.WriteFile(t, "runtime/core/NOTES.md", "Admin UI: https://traefik.test.local\n")
+
+	showDeployNotes([]string{"core", "missing"})
+
+	shown, err := os.ReadFile("runtime/core/.notes-shown")
+	if err != nil {
+		t.Fatalf("Shown notes should be recorded: %v", err)
+	}
+
+	if string(shown) != contentHash([]byte("Admin UI: https://traefik.test.local\n")) {
+		t.Error("Recorded hash should match the notes content")
+	}
+}
+
+func TestInitFromTemplate(t *testing.T) {
+	catalogDir, cleanupCatalog := testutil.TempDir(t)
+	defer cleanupCatalog()
+
+	testutil.WriteFile(t, filepath.Join(catalogDir, "templates/media-server/template.yaml"), `name: media-server
+stacks:
+  - media
+vars:
+  timezone: UTC
+`)
+	testutil.WriteFile(t, filepath.Join(catalogDir, "stacks/media/stack.yaml"), `name: media
+category: media
+requires:
+  - proxy
+services:
+  - jellyfin
+`)
+	testutil.WriteFile(t, filepath.Join(catalogDir, "stacks/media/compose.yml.tmpl"), "services: {}\n")
+	testutil.WriteFile(t, filepath.Join(catalogDir, "stacks/proxy/stack.yaml"), `name: proxy
+category: core
+services:
+  - traefik
+`)
+	testutil.WriteFile(t, filepath.Join(catalogDir, "stacks/proxy/compose.yml.tmpl"), "services: {}\n")
+
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	t.Setenv("HOMELAB_CATALOG", catalogDir)
+
+	if err := Init([]string{"--template", "media-server"}); err != nil {
+		t.Fatalf("Init(--template) failed: %v", err)
+	}
+
+	for _, name := range []string{"media", "proxy"} {
+		if _, err := os.Lstat(filepath.Join("enabled", name)); err != nil {
+			t.Errorf("stack %s should be copied and enabled: %v", name, err)
+		}
+	}
+
+	vars, err := os.ReadFile("inventory/vars.yaml")
+	if err != nil {
+		t.Fatalf("failed to read vars.yaml: %v", err)
+	}
+	if !strings.Contains(string(vars), "timezone: UTC") {
+		t.Errorf("template vars not seeded:\n%s", vars)
+	}
+
+	if err := Init([]string{"--template", "unknown"}); err == nil {
+		t.Error("Init with unknown template should fail")
+	}
+}
+
+func TestCategoryWaves(t *testing.T) {
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"traefik":  map[string]interface{}{"labels": map[string]interface{}{compose.LabelCategory: "core"}},
+			"grafana":  map[string]interface{}{"labels": []interface{}{compose.LabelCategory + "=monitoring"}},
+			"postgres": map[string]interface{}{"labels": map[string]interface{}{compose.LabelCategory: "infrastructure"}},
+			"redis":    map[string]interface{}{"labels": map[string]interface{}{compose.LabelCategory: "infrastructure"}},
+			"manual":   map[string]interface{}{"image": "busybox"},
+		},
+	}
+
+	waves := categoryWaves(generated)
+
+	var got []string
+	for _, wave := range waves {
+		got = append(got, wave.Category+":"+strings.Join(wave.Services, ","))
+	}
+
+	want := []string{"core:traefik", "infrastructure:postgres,redis", "monitoring:grafana", "unlabeled:manual"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("categoryWaves() = %v, want %v", got, want)
+	}
+}
+
+func TestStackServicesWaves(t *testing.T) {
+	labels := func(stack, category string) map[string]interface{} {
+		return map[string]interface{}{"labels": map[string]interface{}{
+			compose.LabelStack:    stack,
+			compose.LabelCategory: category,
+		}}
+	}
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"traefik":  labels("proxy", "core"),
+			"postgres": labels("cloud", "infrastructure"),
+			"redis":    labels("cloud", "infrastructure"),
+			"jellyfin": labels("media", "media"),
+		},
+	}
+
+	services := stackServices(generated, []string{"cloud"})
+	if strings.Join(services, ",") != "postgres,redis" {
+		t.Errorf("stackServices() = %v, want [postgres redis]", services)
+	}
+
+	var got []string
+	for _, wave := range filterWaves(categoryWaves(generated), services) {
+		got = append(got, wave.Category+":"+strings.Join(wave.Services, ","))
+	}
+	if strings.Join(got, " ") != "infrastructure:postgres,redis" {
+		t.Errorf("filterWaves() = %v, want [infrastructure:postgres,redis]", got)
+	}
+}
+
+func TestCanary_RequiresImageInStack(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.WriteFile(t, "stacks/web/stack.yaml", `name: web
+category: apps
+services:
+  - nginx
+vars:
+  nginx:
+    image: nginx:1.25
+`)
+	testutil.EnableStack(t, "web")
+
+	if err := Canary([]string{"nginx"}); err == nil {
+		t.Error("Canary without --image should fail")
+	}
+
+	// The generated image differs from stack.yaml (e.g. overridden in inventory)
+	testutil.WriteFile(t, "runtime/docker-compose.yml", "services:\n  nginx:\n    image: nginx:1.26\n")
+
+	err := Canary([]string{"nginx", "--image", "nginx:1.27"})
+	if err == nil || !strings.Contains(err.Error(), "not set in stacks/web/stack.yaml") {
+		t.Errorf("Canary() error = %v, want image not in stack.yaml error", err)
+	}
+	if _, err := os.Stat("runtime/canary.override.yml"); !os.IsNotExist(err) {
+		t.Error("No overlay should be written when promotion is impossible")
+	}
+
+	// The same image in several places, none of them the service's own image
+	testutil.WriteFile(t, "stacks/web/stack.yaml", `name: web
+category: apps
+services:
+  - nginx
+  - docs
+vars:
+  frontend_image: nginx:1.26
+  docs:
+    image: nginx:1.26
+`)
+	err = Canary([]string{"nginx", "--image", "nginx:1.27"})
+	if err == nil || !strings.Contains(err.Error(), "set 2 times") {
+		t.Errorf("Canary() error = %v, want the ambiguous image refused", err)
+	}
+	if _, err := os.Stat("runtime/canary.override.yml"); !os.IsNotExist(err) {
+		t.Error("No overlay should be written when promotion is ambiguous")
+	}
+}
+
+func TestGreenService(t *testing.T) {
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"grafana": map[string]interface{}{
+				"image": "grafana/grafana:11.2.0",
+				"labels": []interface{}{
+					"traefik.enable=true",
+					"traefik.http.routers.grafana.rule=Host(`grafana.example.com`)",
+					"traefik.http.routers.grafana.service=grafana-web",
+					"traefik.http.services.grafana-web.loadbalancer.server.port=3000",
+				},
+			},
+			"exporter": map[string]interface{}{
+				"image":  "prom/node-exporter",
+				"labels": map[string]interface{}{"traefik.enable": "true"},
+			},
+			"pinned": map[string]interface{}{
+				"container_name": "pinned",
+				"labels":         map[string]interface{}{"traefik.enable": "true", "traefik.http.routers.pinned.rule": "Host(`p`)"},
+			},
+		},
+	}
+
+	if err := checkBlueGreenService(generated, "grafana"); err != nil {
+		t.Errorf("checkBlueGreenService(grafana) unexpected error: %v", err)
+	}
+	if err := checkBlueGreenService(generated, "exporter"); err == nil {
+		t.Error("checkBlueGreenService(exporter) should fail without routers")
+	}
+	if err := checkBlueGreenService(generated, "pinned"); err == nil {
+		t.Error("checkBlueGreenService(pinned) should fail with container_name")
+	}
+
+	svcMap := generated.Services["grafana"].(map[string]interface{})
+	green := greenService(svcMap, compose.ServiceLabels(generated, "grafana"), "v2")
+
+	labels := green["labels"].(map[string]interface{})
+	want := map[string]interface{}{
+		"traefik.enable":                                                "true",
+		"traefik.http.routers.grafana-v2.rule":                          "Host(`grafana.example.com`)",
+		"traefik.http.routers.grafana-v2.service":                       "grafana-web-v2",
+		"traefik.http.routers.grafana-v2.priority":                      greenRouterPriority,
+		"traefik.http.services.grafana-web-v2.loadbalancer.server.port": "3000",
+	}
+	if len(labels) != len(want) {
+		t.Errorf("green labels = %v, want %v", labels, want)
+	}
+	for key, value := range want {
+		if labels[key] != value {
+			t.Errorf("green label %s = %v, want %v", key, labels[key], value)
+		}
+	}
+
+	if green["image"] != "grafana/grafana:11.2.0" {
+		t.Errorf("green image = %v", green["image"])
+	}
+	if _, ok := svcMap["labels"].([]interface{}); !ok {
+		t.Error("greenService() should not modify the original service")
+	}
+}
+
+func TestDeploySchedule(t *testing.T) {
+	now := time.Date(2025, 3, 10, 14, 30, 0, 0, time.UTC)
+
+	clock, err := parseClock("03:00")
+	if err != nil {
+		t.Fatalf("parseClock() unexpected error: %v", err)
+	}
+	if got := nextAt(now, clock); !got.Equal(time.Date(2025, 3, 11, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("nextAt(03:00) = %v, want tomorrow 03:00", got)
+	}
+
+	clock, _ = parseClock("18:15")
+	if got := nextAt(now, clock); !got.Equal(time.Date(2025, 3, 10, 18, 15, 0, 0, time.UTC)) {
+		t.Errorf("nextAt(18:15) = %v, want today 18:15", got)
+	}
+
+	if _, err := parseClock("3am"); err == nil {
+		t.Error("parseClock(3am) should fail")
+	}
+
+	tests := []struct {
+		window string
+		want   time.Time
+	}{
+		{"14:00-16:00", now},
+		{"02:00-05:00", time.Date(2025, 3, 11, 2, 0, 0, 0, time.UTC)},
+		{"22:00-15:00", now}, // spans midnight, still open
+		{"23:00-01:00", time.Date(2025, 3, 10, 23, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		start, end, err := parseWindow(tt.window)
+		if err != nil {
+			t.Fatalf("parseWindow(%s) unexpected error: %v", tt.window, err)
+		}
+		if got := windowStart(now, start, end); !got.Equal(tt.want) {
+			t.Errorf("windowStart(%s) = %v, want %v", tt.window, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{"02:00", "02:00-02:00", "02:00-25:00"} {
+		if _, _, err := parseWindow(invalid); err == nil {
+			t.Errorf("parseWindow(%s) should fail", invalid)
+		}
+	}
+
+	if err := Deploy([]string{"--at", "03:00", "--window", "02:00-05:00"}); err == nil {
+		t.Error("Deploy with --at and --window should fail")
+	}
+}
+
+func TestDeployedImages(t *testing.T) {
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"grafana": map[string]interface{}{
+				"image":  "grafana/grafana:11.2.0",
+				"labels": map[string]interface{}{compose.LabelStack: "monitoring"},
+			},
+			"worker": map[string]interface{}{
+				"image":  "app:1",
+				"labels": map[string]interface{}{compose.LabelStack: "apps"},
+			},
+			"web": map[string]interface{}{
+				"image":  "app:1",
+				"labels": map[string]interface{}{compose.LabelStack: "apps"},
+			},
+			"builder": map[string]interface{}{"build": "."},
+		},
+	}
+
+	images := deployedImages(generated)
+	if len(images) != 2 {
+		t.Fatalf("deployedImages() = %+v, want 2 images", images)
+	}
+	if images[0].Ref != "app:1" || strings.Join(images[0].Services, ",") != "web,worker" || strings.Join(images[0].Stacks, ",") != "apps" {
+		t.Errorf("shared image = %+v", images[0])
+	}
+
+	if digest := imageDigest(sbom.Image{Ref: "redis@sha256:abc"}); digest != "sha256:abc" {
+		t.Errorf("imageDigest() from reference = %s, want sha256:abc", digest)
+	}
+}
+
+func TestStackImages(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "monitoring", []string{}, []string{"grafana", "loki"})
+	testutil.CreateStack(t, "apps", []string{}, []string{"web", "worker"})
+	testutil.CreateStack(t, "unused", []string{}, []string{"other"})
+	testutil.EnableStack(t, "monitoring")
+	testutil.EnableStack(t, "apps")
+
+	// Services built from source have a local image, not checked against a registry
+	testutil.WriteFile(t, "runtime/docker-compose.yml", "services:\n  worker:\n    build: ./worker\n    image: nginx:latest\n  web:\n    image: ghcr.io/org/web:1.2\n")
+
+	// Inventory vars override the stack's image; disabled services are not checked
+	testutil.WriteFile(t, "inventory/vars.yaml", "web:\n  image: ghcr.io/org/web:1.2\n")
+	if err := inventory.DisableService("monitoring", "loki"); err != nil {
+		t.Fatalf("DisableService() failed: %v", err)
+	}
+
+	images, err := stackImages()
+	if err != nil {
+		t.Fatalf("stackImages() failed: %v", err)
+	}
+	want := []stackImage{
+		{Stack: "apps", Service: "web", Image: "ghcr.io/org/web:1.2"},
+		{Stack: "monitoring", Service: "grafana", Image: "nginx:latest"},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("stackImages() = %+v, want %+v", images, want)
+	}
+
+	if err := Outdated([]string{"--parallel", "0"}); err == nil {
+		t.Error("Outdated() should reject --parallel 0")
+	}
+
+	t.Setenv("HOMELAB_OUTPUT", outputYAML)
+	if err := Outdated([]string{"--json"}); err == nil || !strings.Contains(err.Error(), "--json conflicts with --output yaml") {
+		t.Errorf("Outdated(--json) with --output yaml error = %v, want the conflict", err)
+	}
+}
+
+func TestQueryServices(t *testing.T) {
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"traefik": map[string]interface{}{
+				"image":  "traefik:v3",
+				"ports":  []interface{}{"80:80", "443:443"},
+				"labels": map[string]interface{}{compose.LabelStack: "proxy", compose.LabelCategory: "core"},
+			},
+			"grafana": map[string]interface{}{
+				"image":       "grafana/grafana",
+				"environment": []interface{}{"GF_LOG_LEVEL=debug"},
+				"labels":      []interface{}{"traefik.enable=true", compose.LabelStack + "=monitoring"},
+			},
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`ports`, "traefik"},
+		{`labels["traefik.enable"] == "true"`, "grafana"},
+		{`environment.GF_LOG_LEVEL == "debug"`, "grafana"},
+		{`category == "core" || stack == "monitoring"`, "grafana,traefik"},
+		{`name == "missing"`, ""},
+	}
+
+	for _, tt := range tests {
+		expr, err := query.Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%s) unexpected error: %v", tt.expr, err)
+		}
+
+		var names []string
+		for _, r := range queryServices(generated, expr) {
+			names = append(names, r.Service)
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("query %s = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestAggregateUsage(t *testing.T) {
+	usage, id, err := parseStatsLine(`{"ID":"abc123","CPUPerc":"2.50%","MemUsage":"512MiB / 7.6GiB","NetIO":"1.5kB / 2MB"}`)
+	if err != nil {
+		t.Fatalf("parseStatsLine() unexpected error: %v", err)
+	}
+	if id != "abc123" || usage.CPU != 2.5 || usage.Memory != 512*(1<<20) || usage.NetRx != 1500 || usage.NetTx != 2e6 {
+		t.Errorf("parseStatsLine() = %+v, %s", usage, id)
+	}
+	if parseSize("--") != 0 || parseSize("1.5GiB") != 1.5*(1<<30) {
+		t.Error("parseSize() should handle invalid and binary units")
+	}
+
+	usages := []containerUsage{
+		{Stack: "proxy", Category: "core", CPU: 1, Memory: 100},
+		{Stack: "media", Category: "media", CPU: 10, Memory: 2000},
+		{Stack: "media", Category: "media", CPU: 5, Memory: 1000},
+		{Stack: "books", Category: "media", CPU: 1, Memory: 500},
+	}
+
+	stacks := aggregateUsage(usages)
+
+	var got []string
+	for _, s := range stacks {
+		got = append(got, fmt.Sprintf("%s/%s:%d:%.0f", s.Category, s.Stack, s.Containers, s.Memory))
+	}
+	want := "media/media:2:3000 media/books:1:500 core/proxy:1:100"
+	if strings.Join(got, " ") != want {
+		t.Errorf("aggregateUsage() = %v, want %s", got, want)
+	}
+
+	if got := formatBytes(3*(1<<30), 1024, "iB"); got != "3.0GiB" {
+		t.Errorf("formatBytes() = %s, want 3.0GiB", got)
+	}
+	if got := formatBytes(1500, 1000, "B"); got != "1.5kB" {
+		t.Errorf("formatBytes() = %s, want 1.5kB", got)
+	}
+}
+
+func TestLogs(t *testing.T) {
+	containers := []docker.Container{
+		{ID: "1", Labels: map[string]string{"com.docker.compose.service": "grafana", compose.LabelStack: "monitoring"}},
+		{ID: "2", Labels: map[string]string{"com.docker.compose.service": "prometheus", compose.LabelStack: "monitoring"}},
+		{ID: "3", Labels: map[string]string{"com.docker.compose.service": "jellyfin", compose.LabelStack: "media"}},
+		{ID: "4", Labels: map[string]string{"com.docker.compose.service": "jellyfin", compose.LabelStack: "media", "com.docker.compose.container-number": "2"}},
+	}
+
+	tests := []struct {
+		name    string
+		targets []string
+		want    string
+		wantErr bool
+	}{
+		{"all", nil, "media/jellyfin media/jellyfin-2 monitoring/grafana monitoring/prometheus", false},
+		{"stack", []string{"monitoring"}, "monitoring/grafana monitoring/prometheus", false},
+		{"service and qualified", []string{"jellyfin", "monitoring/grafana"}, "media/jellyfin media/jellyfin-2 monitoring/grafana", false},
+		{"unknown", []string{"monitoring", "nextcloud"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, err := selectLogSources(containers, tt.targets)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selectLogSources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, s := range sources {
+				got = append(got, s.Prefix)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("selectLogSources() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+
+	sources := []logSource{{Prefix: "media/jellyfin"}, {Prefix: "monitoring/grafana"}}
+	prefixes := formatLogPrefixes(sources, false)
+	if prefixes[0] != "media/jellyfin     |" || prefixes[1] != "monitoring/grafana |" {
+		t.Errorf("formatLogPrefixes() = %q", prefixes)
+	}
+	if colored := formatLogPrefixes(sources, true); colored[0] == colored[1] || !strings.HasPrefix(colored[0], "\033[") {
+		t.Errorf("formatLogPrefixes(color) = %q", colored)
+	}
+
+	var out strings.Builder
+	printer := &logPrinter{out: &out, filter: regexp.MustCompile("(?i)error")}
+	w := &logLineWriter{printer: printer, prefix: "app |"}
+	fmt.Fprint(w, "ok\nERROR: disk ")
+	fmt.Fprint(w, "full\r\nstill ok\nerror without newline")
+	w.Flush()
+	if want := "app | ERROR: disk full\napp | error without newline\n"; out.String() != want {
+		t.Errorf("log output = %q, want %q", out.String(), want)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for value, want := range map[string]string{
+		"1h":                   fmt.Sprint(now.Add(-time.Hour).Unix()),
+		"7d":                   fmt.Sprint(now.Add(-7 * 24 * time.Hour).Unix()),
+		"1714564800":           "1714564800",
+		"2024-05-01T10:00:00Z": fmt.Sprint(now.Add(-2 * time.Hour).Unix()),
+		"2024-05-01":           fmt.Sprint(now.Add(-12 * time.Hour).Unix()),
+	} {
+		if got, err := parseLogTime(value, now); err != nil || got != want {
+			t.Errorf("parseLogTime(%q) = %s, %v; want %s", value, got, err, want)
+		}
+	}
+	if _, err := parseLogTime("yesterday", now); err == nil {
+		t.Error("parseLogTime() should reject an invalid time")
+	}
+}
+
+func TestEventConditions(t *testing.T) {
+	raw := docker.Event{Action: "health_status: healthy", TimeNano: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).UnixNano()}
+	raw.Actor.Attributes = map[string]string{
+		"name":                       "runtime-grafana-1",
+		"com.docker.compose.service": "grafana",
+		compose.LabelStack:           "monitoring",
+		compose.LabelCategory:        "monitoring",
+	}
+
+	event := annotateEvent(raw)
+	if event.Event != "healthy" || event.Stack != "monitoring" || event.Service != "grafana" || event.Container != "runtime-grafana-1" {
+		t.Errorf("annotateEvent() = %+v", event)
+	}
+
+	raw.Action = "die"
+	raw.Actor.Attributes["exitCode"] = "137"
+	if died := annotateEvent(raw); died.Event != "die" || died.ExitCode != "137" {
+		t.Errorf("annotateEvent(die) = %+v", died)
+	}
+
+	tests := []struct {
+		value   string
+		matches bool
+		wantErr bool
+	}{
+		{"grafana=healthy", true, false},
+		{"monitoring=healthy", true, false},
+		{"monitoring/grafana=healthy", true, false},
+		{"healthy", true, false},
+		{"prometheus=healthy", false, false},
+		{"grafana=die", false, false},
+		{"grafana=", false, true},
+		{"=healthy", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			cond, err := parseEventCondition(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseEventCondition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cond.matches(event) != tt.matches {
+				t.Errorf("matches() = %v, want %v", !tt.matches, tt.matches)
+			}
+		})
+	}
+
+	if !eventSelected(event, []string{"media", "monitoring"}) || eventSelected(event, []string{"media"}) {
+		t.Error("eventSelected() should select by stack or service")
+	}
+	if err := eventTimeout(time.Minute, nil); err != nil {
+		t.Errorf("eventTimeout() without conditions = %v, want nil", err)
+	}
+	if err := eventTimeout(time.Minute, []eventCondition{{Target: "grafana", Event: "healthy"}}); err == nil || !strings.Contains(err.Error(), "grafana=healthy") {
+		t.Errorf("eventTimeout() = %v, want pending condition", err)
+	}
+}
+
+func TestServiceReadiness(t *testing.T) {
+	tests := []struct {
+		name      string
+		states    []containerState
+		wantReady bool
+		wantState string
+		wantErr   bool
+	}{
+		{"not created", nil, false, "not created", false},
+		{"running", []containerState{{Status: "running"}}, true, "running", false},
+		{"healthy", []containerState{{Status: "running", Health: "healthy"}}, true, "running, healthy", false},
+		{"starting", []containerState{{Status: "running", Health: "starting"}}, false, "running, starting", false},
+		{"one replica unhealthy", []containerState{{Status: "running", Health: "healthy"}, {Status: "running", Health: "unhealthy"}}, false, "running, unhealthy", false},
+		{"restarting", []containerState{{Status: "restarting"}}, false, "restarting", false},
+		{"completed", []containerState{{Status: "exited", ExitCode: "0"}}, true, "completed", false},
+		{"crashed", []containerState{{Status: "exited", ExitCode: "1"}}, false, "", true},
+		{"dead", []containerState{{Status: "dead", ExitCode: "137"}}, false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, state, err := serviceReadiness("app", tt.states)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("serviceReadiness() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ready != tt.wantReady || state != tt.wantState {
+				t.Errorf("serviceReadiness() = %v, %q; want %v, %q", ready, state, tt.wantReady, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestVerifyServices(t *testing.T) {
+	generated := &compose.ComposeFile{Services: map[string]interface{}{
+		"grafana": map[string]interface{}{
+			"image":  "grafana/grafana",
+			"labels": map[string]interface{}{compose.LabelConfigHash: "abc123"},
+		},
+		"migrate": map[string]interface{}{"image": "app"},
+	}}
+	imageID := func(image string) string {
+		return map[string]string{"grafana/grafana": "sha256:new", "app": "sha256:app"}[image]
+	}
+
+	tests := []struct {
+		name   string
+		states map[string][]containerState
+		want   []string // Substrings of the expected problems, in order
+	}{
+		{
+			name: "as declared",
+			states: map[string][]containerState{
+				"grafana": {{Status: "running", Image: "sha256:new", ConfigHash: "abc123"}},
+				"migrate": {{Status: "exited", ExitCode: "0", Image: "sha256:app"}},
+			},
+		},
+		{
+			name:   "missing containers",
+			states: map[string][]containerState{},
+			want:   []string{"grafana: no container", "migrate: no container"},
+		},
+		{
+			name: "drift",
+			states: map[string][]containerState{
+				"grafana": {{Status: "running", Image: "sha256:old", ConfigHash: "stale"}},
+				"migrate": {{Status: "exited", ExitCode: "1", Image: "sha256:app"}},
+			},
+			want: []string{"grafana: runs old", "grafana: config changed", "migrate: exited (exit code 1)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := verifyServices(generated, []string{"grafana", "migrate"}, tt.states, imageID)
+			if len(problems) != len(tt.want) {
+				t.Fatalf("verifyServices() = %v, want %d problem(s)", problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("problem %d = %q, want %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyGeneratedFiles(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "runtime/monitoring/grafana.ini", "[server]\n")
+	digest, err := pipeline.FileDigest("runtime/monitoring/grafana.ini")
+	if err != nil {
+		t.Fatalf("FileDigest() error = %v", err)
+	}
+
+	files := map[string]string{
+		"runtime/monitoring/grafana.ini":                 digest,
+		"runtime/traefik/dynamic/monitoring-grafana.yml": digest,
+	}
+	problems := verifyGeneratedFiles(files)
+	if len(problems) != 1 || !strings.Contains(problems[0], "monitoring-grafana.yml: missing") {
+		t.Errorf("verifyGeneratedFiles() = %v, want the missing contribution", problems)
+	}
+
+	testutil.WriteFile(t, "runtime/monitoring/grafana.ini", "[server]\nhttp_port = 3001\n")
+	problems = verifyGeneratedFiles(files)
+	if len(problems) != 2 || !strings.Contains(problems[0], "grafana.ini: edited") {
+		t.Errorf("verifyGeneratedFiles() = %v, want the edited config first", problems)
+	}
+}
+
+func TestWaitServices(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "monitoring", nil, []string{"grafana", "prometheus"})
+	testutil.EnableStack(t, "monitoring")
+	testutil.WriteFile(t, "runtime/docker-compose.yml", "services:\n  grafana:\n    image: grafana\n  prometheus:\n    image: prom\n")
+
+	services, err := waitServices([]string{"monitoring", "grafana"})
+	if err != nil {
+		t.Fatalf("waitServices() error = %v", err)
+	}
+	if strings.Join(services, ",") != "grafana,prometheus" {
+		t.Errorf("waitServices() = %v, want grafana,prometheus", services)
+	}
+
+	if _, err := waitServices([]string{"nextcloud"}); err == nil {
+		t.Error("waitServices() should fail for an unknown service")
+	}
+}
+
+func TestDeployHealthChecks(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "monitoring", nil, []string{"grafana"})
+	testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\ncategory: media\nservices: [jellyfin]\nhealth_timeout: 5m\n")
+	generated := &compose.ComposeFile{Services: map[string]interface{}{
+		"grafana":  map[string]interface{}{"labels": map[string]interface{}{compose.LabelStack: "monitoring"}},
+		"jellyfin": map[string]interface{}{"labels": map[string]interface{}{compose.LabelStack: "media"}},
+	}}
+
+	want := []health.Check{
+		{Service: "grafana", Stack: "monitoring", Timeout: health.DefaultTimeout},
+		{Service: "jellyfin", Stack: "media", Timeout: 5 * time.Minute},
+	}
+	if got := deployHealthChecks(generated, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("deployHealthChecks() = %+v, want %+v", got, want)
+	}
+	if got := deployHealthChecks(generated, []string{"jellyfin"}); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("deployHealthChecks(jellyfin) = %+v, want %+v", got, want[1:])
+	}
+}
+
+func TestPrompter(t *testing.T) {
+	var out strings.Builder
+	p := &prompter{reader: bufio.NewReader(strings.NewReader("maybe\nn\n3\n2\n\n")), out: &out}
+
+	ok, err := p.confirm("Configure them now?", true)
+	if err != nil || ok {
+		t.Errorf("confirm() = %v, %v; want false after an invalid answer", ok, err)
+	}
+
+	choice, err := p.choose("Write answers to", []string{"inventory/vars.yaml", "secrets/app.yaml"})
+	if err != nil || choice != 1 {
+		t.Errorf("choose() = %d, %v; want 1 after an out-of-range answer", choice, err)
+	}
+
+	answer, eof, err := p.ask("domain", "home.lan")
+	if err != nil || answer != "" || eof {
+		t.Errorf("ask() = %q, %v, %v; want empty answer", answer, eof, err)
+	}
+
+	// stdin is exhausted: the default is used
+	ok, err = p.confirm("Continue?", true)
+	if err != nil || !ok {
+		t.Errorf("confirm() at EOF = %v, %v; want default true", ok, err)
+	}
+
+	if !strings.Contains(out.String(), "domain [home.lan]: ") || !strings.Contains(out.String(), "Enter a number between 1 and 2") {
+		t.Errorf("unexpected prompts:\n%s", out.String())
+	}
+}
+
+func TestVarDestinationWrite(t *testing.T) {
+	written := make(map[string]interface{})
+	dest := &varDestination{
+		Name: "inventory/vars.yaml",
+		Vars: map[string]interface{}{"domain": "home.lan"},
+		Base: map[string]interface{}{"grafana": map[string]interface{}{"image": "grafana/grafana:latest"}},
+		Set: func(path string, value interface{}) error {
+			written[path] = value
+			return nil
+		},
+	}
+
+	if err := dest.write("grafana.admin_password", "s3cret"); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+	if err := dest.write("grafana.admin_user", "admin"); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	// The stack default block is copied once, so the image isn't lost
+	block, ok := written["grafana"].(map[string]interface{})
+	if !ok || block["image"] != "grafana/grafana:latest" {
+		t.Errorf("grafana block = %v, want stack defaults copied", written["grafana"])
+	}
+	if written["grafana.admin_password"] != "s3cret" || written["grafana.admin_user"] != "admin" {
+		t.Errorf("written = %v", written)
+	}
+	if len(written) != 3 {
+		t.Errorf("written %d values, want 3: %v", len(written), written)
+	}
+}
+
+func TestStagePlan(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", []string{}, []string{"traefik"})
+	testutil.EnableStack(t, "core")
+	testutil.WriteFile(t, "stacks/core/compose.yml.tmpl", "services:\n  traefik:\n    image: traefik:v3\n")
+	testutil.WriteFile(t, "stacks/core/config/traefik.yml.tmpl", "entryPoints: {{ .stack.name }}\n")
+
+	// The configs the planned file references are staged, not in runtime/
+	var staging string
+	err := stagePlan(nil, func(planned *compose.ComposeFile, stagingDir string) error {
+		staging = stagingDir
+		if _, ok := planned.Services["traefik"]; !ok {
+			t.Errorf("planned services = %v, want traefik", planned.Services)
+		}
+		if _, err := os.Stat(filepath.Join(stagingDir, "core", "traefik.yml")); err != nil {
+			t.Errorf("staged config missing: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("stagePlan() error = %v", err)
+	}
+	if _, err := os.Stat(paths.RuntimeConfigFile("core", "traefik.yml")); err == nil {
+		t.Error("stagePlan() should not write runtime/")
+	}
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Errorf("stagePlan() left %s behind", staging)
+	}
+}
+
+func TestPlanUpdates(t *testing.T) {
+	waves := []deployWave{
+		{Category: "core", Services: []string{"vpn"}},
+		{Category: "monitoring", Services: []string{"grafana"}},
+		{Category: "media", Services: []string{"jellyfin"}},
+	}
+	overrides := map[string]categories.UpdatePolicy{"media": {Window: "02:00-05:00"}}
+
+	night := time.Date(2024, 5, 1, 3, 0, 0, 0, time.Local)
+	day := time.Date(2024, 5, 1, 14, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name      string
+		scheduled bool
+		now       time.Time
+		want      []string
+	}{
+		{"manual run updates everything", false, day, []string{"update", "update", "update"}},
+		{"scheduled inside window", true, night, []string{"skip", "check", "update"}},
+		{"scheduled outside window", true, day, []string{"skip", "check", "skip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planUpdates(waves, overrides, tt.scheduled, tt.now)
+			if err != nil {
+				t.Fatalf("planUpdates() error = %v", err)
+			}
+			var actions []string
+			for _, p := range plan {
+				actions = append(actions, p.Action)
+			}
+			if strings.Join(actions, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("actions = %v, want %v", actions, tt.want)
+			}
+		})
+	}
+
+	bad := map[string]categories.UpdatePolicy{"media": {Window: "late"}}
+	if _, err := planUpdates(waves, bad, true, night); err == nil {
+		t.Error("planUpdates() should fail on an invalid window")
+	}
+}
+
+func TestCheckDigests_PinnedImage(t *testing.T) {
+	// An image pinned by digest alone is up to date without asking the registry
+	results := checkDigests(registry.NewClient(nil), []string{"nginx@sha256:abc"}, 1)
+	if len(results) != 1 || results[0].Err != nil {
+		t.Fatalf("checkDigests() = %+v", results)
+	}
+	if results[0].OldID != "sha256:abc" || results[0].OldID != results[0].NewID {
+		t.Errorf("digests = %q, %q, want both sha256:abc", results[0].OldID, results[0].NewID)
+	}
+}
+
+func TestEnableWithDeps(t *testing.T) {
+	mainCatalog, cleanupMain := testutil.TempDir(t)
+	defer cleanupMain()
+	extrasCatalog, cleanupExtras := testutil.TempDir(t)
+	defer cleanupExtras()
+
+	testutil.WriteFile(t, filepath.Join(mainCatalog, "stacks/postgres/stack.yaml"), `name: postgres
+category: infrastructure
+services:
+  - postgres
+`)
+	testutil.WriteFile(t, filepath.Join(extrasCatalog, "stacks/redis/stack.yaml"), `name: redis
+category: infrastructure
+requires:
+  - catalog/postgres
+services:
+  - redis
+`)
+
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "app", []string{"catalog/postgres", "extras/redis"}, []string{"app"})
+	testutil.WriteFile(t, "inventory/catalogs.yaml", "catalogs:\n  extras: "+extrasCatalog+"\n")
+	t.Setenv("HOMELAB_CATALOG", mainCatalog)
+
+	var enabled []string
+	out, err := captureStdout(t, func() error {
+		var err error
+		enabled, err = enableWithDeps("app", false)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("enableWithDeps() error = %v", err)
+	}
+
+	// Dependencies first
+	if strings.Join(enabled, ",") != "postgres,redis,app" {
+		t.Errorf("enabled = %v, want postgres,redis,app", enabled)
+	}
+	if !strings.Contains(out, "✓ Enabled 3 stacks, in dependency order: postgres, redis, app") {
+		t.Errorf("output should list the enabled stacks:\n%s", out)
+	}
+	for _, name := range []string{"postgres", "redis"} {
+		if _, err := os.Stat(filepath.Join("stacks", name, "stack.yaml")); err != nil {
+			t.Errorf("stack %s should be installed from its catalog: %v", name, err)
+		}
+	}
+
+	// Installs are recorded in stacks.lock, unverified without SHA256SUMS
+	lock, err := catalog.LoadLockFile()
+	if err != nil {
+		t.Fatalf("LoadLockFile() error = %v", err)
+	}
+	if lock.Stacks["redis"].Catalog != "extras" || lock.Stacks["redis"].Verified != catalog.VerifiedNone {
+		t.Errorf("stacks.lock redis = %+v, want unverified from extras", lock.Stacks["redis"])
+	}
+	if lock.Stacks["postgres"].Catalog != catalog.DefaultName {
+		t.Errorf("stacks.lock postgres = %+v, want default catalog", lock.Stacks["postgres"])
+	}
+
+	// A namespace without a catalog entry fails
+	testutil.CreateStack(t, "broken", []string{"missing/thing"}, []string{"broken"})
+	if _, err := enableWithDeps("broken", false); err == nil || !strings.Contains(err.Error(), "catalog 'missing' is not defined") {
+		t.Errorf("enableWithDeps(broken) error = %v, want undefined catalog", err)
+	}
+}
+
+func TestDevOverlay(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "stacks/blog/stack.yaml", `name: blog
+category: apps
+services: [web, worker]
+develop:
+  web:
+    - path: ./src
+      target: /app
+      ignore: [node_modules/]
+    - path: package.json
+      action: rebuild
+`)
+
+	stack, err := stacks.LoadStack("blog")
+	if err != nil {
+		t.Fatalf("LoadStack() error = %v", err)
+	}
+
+	generated := &compose.ComposeFile{Services: map[string]interface{}{"web": map[string]interface{}{"build": "."}}}
+	overlay, services, err := devOverlay(stack, generated)
+	if err != nil {
+		t.Fatalf("devOverlay() error = %v", err)
+	}
+	if strings.Join(services, ",") != "web" {
+		t.Errorf("services = %v, want web", services)
+	}
+
+	watch := overlay.Services["web"].(map[string]interface{})["develop"].(map[string]interface{})["watch"].([]interface{})
+	if len(watch) != 2 {
+		t.Fatalf("watch = %v, want 2 rules", watch)
+	}
+	sync := watch[0].(map[string]interface{})
+	src, _ := filepath.Abs(filepath.Join("stacks", "blog", "src"))
+	if sync["path"] != src || sync["action"] != "sync" || sync["target"] != "/app" {
+		t.Errorf("sync rule = %v, want absolute path and default action", sync)
+	}
+	if rebuild := watch[1].(map[string]interface{}); rebuild["action"] != "rebuild" || rebuild["target"] != nil {
+		t.Errorf("rebuild rule = %v", rebuild)
+	}
+
+	// A service missing from the generated file (disabled) fails
+	stack.Develop["worker"] = []stacks.WatchRule{{Path: ".", Action: "restart"}}
+	if _, _, err := devOverlay(stack, generated); err == nil || !strings.Contains(err.Error(), "worker") {
+		t.Errorf("devOverlay() error = %v, want missing worker", err)
+	}
+}
+
+func TestBuildBadge(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", []string{}, []string{"traefik"})
+	testutil.EnableStack(t, "core")
+
+	tests := []struct {
+		kind    string
+		message string
+		color   string
+	}{
+		{"validate", "passing", "brightgreen"},
+		{"stacks", "1 enabled", "blue"},
+		{"deploy", "never", "lightgrey"},
+	}
+	for _, tt := range tests {
+		badge, err := buildBadge(tt.kind)
+		if err != nil {
+			t.Fatalf("buildBadge(%s) error = %v", tt.kind, err)
+		}
+		if badge.SchemaVersion != 1 || badge.Message != tt.message || badge.Color != tt.color {
+			t.Errorf("buildBadge(%s) = %+v, want %s/%s", tt.kind, badge, tt.message, tt.color)
+		}
+	}
+
+	if err := inventory.MarkDeployed(); err != nil {
+		t.Fatalf("MarkDeployed() error = %v", err)
+	}
+	badge, err := buildBadge("deploy")
+	if err != nil {
+		t.Fatalf("buildBadge(deploy) error = %v", err)
+	}
+	if badge.Message != time.Now().UTC().Format("2006-01-02") {
+		t.Errorf("deploy badge message = %q, want today", badge.Message)
+	}
+
+	// A failing validation is reported by the badge, not as an error
+	testutil.CreateStack(t, "broken", []string{"nonexistent"}, []string{"app"})
+	testutil.EnableStack(t, "broken")
+	badge, err = buildBadge("validate")
+	if err != nil {
+		t.Fatalf("buildBadge(validate) error = %v", err)
+	}
+	if badge.Message != "failing" || badge.Color != "red" {
+		t.Errorf("validate badge = %+v, want failing/red", badge)
+	}
+
+	if _, err := buildBadge("uptime"); err == nil {
+		t.Error("buildBadge() should reject an unknown badge")
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"jellyfin": map[string]interface{}{
+				"volumes": []interface{}{"jellyfin_config:/config", "media:/data", "/srv/cache:/cache"},
+				"labels":  map[string]interface{}{compose.LabelStack: "media"},
+			},
+			"grafana": map[string]interface{}{
+				"volumes": []interface{}{"grafana_data:/var/lib/grafana", "jellyfin_config:/extra"},
+				"labels":  map[string]interface{}{compose.LabelStack: "monitoring"},
+			},
+		},
+		Volumes: map[string]interface{}{
+			"jellyfin_config": nil,
+			"grafana_data":    map[string]interface{}{"name": "grafana"},
+			"media":           map[string]interface{}{"driver": "local"},
+		},
+	}
+
+	media := &stacks.Stack{Name: "media", Category: "media"}
+	media.Persistence.Paths = []string{"/srv/library", "relative/path"}
+	media.Persistence.Shares = map[string]stacks.Share{"media": {Server: "nas", Export: "/media"}}
+	monitoring := &stacks.Stack{Name: "monitoring", Category: "monitoring"}
+
+	entries := diskEntries(generated, []*stacks.Stack{media, monitoring}, "homelab")
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Stack+":"+e.Source)
+	}
+	want := "media:homelab_jellyfin_config media:/srv/library monitoring:grafana"
+	if strings.Join(got, " ") != want {
+		t.Errorf("diskEntries() = %v, want %s (shares and relative paths skipped, shared volume counted once)", got, want)
+	}
+
+	entries[0].Size, entries[1].Size, entries[2].Size = 2<<30, 1<<30, 4<<30
+	usage := aggregateDisk(entries, map[string]string{"media": "media", "monitoring": "monitoring"})
+	if len(usage) != 2 || usage[0].Stack != "monitoring" || usage[1].Volumes != 1 || usage[1].Paths != 1 || usage[1].Size != 3<<30 {
+		t.Errorf("aggregateDisk() = %+v", usage)
+	}
+
+	quotas := &inventory.Quotas{
+		Stacks:     map[string]string{"media": "2GiB", "monitoring": "10GB"},
+		Categories: map[string]string{"media": "1TB"},
+	}
+	warnings, err := diskQuotaWarnings(usage, quotas)
+	if err != nil {
+		t.Fatalf("diskQuotaWarnings() error = %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "stack media uses 3.0GiB") {
+		t.Errorf("diskQuotaWarnings() = %v, want only media over quota", warnings)
+	}
+
+	// The table marks each row against its quota
+	out, err := captureStdout(t, func() error {
+		printDiskUsage(usage, quotas)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"STATUS", "2GiB  over quota", "10GB  ok", "1TB  ok"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("printDiskUsage() lacks %q:\n%s", want, out)
+		}
+	}
+
+	quotas.Stacks["media"] = "lots"
+	if _, err := diskQuotaWarnings(usage, quotas); err == nil {
+		t.Error("diskQuotaWarnings() should reject an unparseable quota")
+	}
+}
+
+func TestEventNotification(t *testing.T) {
+	unhealthy := make(map[string]bool)
+	event := func(name, exitCode string) containerEvent {
+		return containerEvent{Container: "homelab-jellyfin-1", Service: "jellyfin", Stack: "media", Category: "media", Event: name, ExitCode: exitCode}
+	}
+
+	if _, ok := eventNotification(event("die", "0"), unhealthy); ok {
+		t.Error("a clean stop should not notify")
+	}
+	if n, ok := eventNotification(event("die", "137"), unhealthy); !ok || n.Severity != "critical" || n.Category != "media" {
+		t.Errorf("crash = %+v, %v, want a critical notification", n, ok)
+	}
+	if _, ok := eventNotification(event("healthy", ""), unhealthy); ok {
+		t.Error("healthy without a previous unhealthy should not notify")
+	}
+	if n, ok := eventNotification(event("unhealthy", ""), unhealthy); !ok || n.Severity != "warning" {
+		t.Errorf("unhealthy = %+v, %v, want a warning", n, ok)
+	}
+	if n, ok := eventNotification(event("healthy", ""), unhealthy); !ok || n.Event != "recovered" || len(unhealthy) != 0 {
+		t.Errorf("recovery = %+v, %v, want a recovered notification", n, ok)
+	}
+}
+
+func TestPlanDeployChanges(t *testing.T) {
+	service := func(image, hash string, ports ...interface{}) map[string]interface{} {
+		svc := map[string]interface{}{
+			"image":  image,
+			"labels": map[string]interface{}{compose.LabelConfigHash: hash},
+		}
+		if len(ports) > 0 {
+			svc["ports"] = ports
+		}
+		return svc
+	}
+	planned := &compose.ComposeFile{Services: map[string]interface{}{
+		"grafana":    service("grafana/grafana", "abc123"),
+		"prometheus": service("prom/prometheus", "def456", "9090:9090"),
+		"loki":       service("grafana/loki", "abc123"),
+		"jellyfin":   service("jellyfin/jellyfin", "111"),
+		"sonarr":     service("linuxserver/sonarr", "222"),
+	}}
+	previous := &compose.ComposeFile{Services: map[string]interface{}{
+		"grafana":    service("grafana/grafana", "abc123"),
+		"prometheus": service("prom/prometheus", "def456"),
+		"loki":       service("grafana/loki", "abc123"),
+		"jellyfin":   service("jellyfin/jellyfin", "000"),
+	}}
+	imageID := func(image string) string {
+		return map[string]string{"grafana/grafana": "sha256:new", "prom/prometheus": "sha256:prom", "grafana/loki": "sha256:loki", "jellyfin/jellyfin": "sha256:jf"}[image]
+	}
+	states := map[string][]containerState{
+		"grafana":    {{Status: "running", Image: "sha256:old", ConfigHash: "abc123"}},
+		"prometheus": {{Status: "running", Image: "sha256:prom", ConfigHash: "def456"}},
+		"loki":       {{Status: "exited", ExitCode: "1", Image: "sha256:loki", ConfigHash: "abc123"}},
+		"jellyfin":   {{Status: "running", Image: "sha256:jf", ConfigHash: "000"}},
+		"radarr":     {{Status: "running"}},
+	}
+
+	var got []string
+	for _, c := range planDeployChanges(planned, previous, states, imageID) {
+		got = append(got, c.Service+":"+c.Action+":"+strings.Join(c.Reasons, ","))
+	}
+	want := []string{
+		"grafana:recreate:image grafana/grafana changed",
+		"jellyfin:recreate:config changed",
+		"loki:start:exited",
+		"prometheus:recreate:definition changed",
+		"sonarr:create:",
+		"radarr:orphan:",
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("planDeployChanges() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestDiffCompose(t *testing.T) {
+	deployed := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"grafana": map[string]interface{}{"image": "grafana/grafana:11.1.0", "restart": "unless-stopped"},
+			"radarr":  map[string]interface{}{"image": "linuxserver/radarr"},
+			"traefik": map[string]interface{}{"image": "traefik:v3"},
+		},
+		Volumes: map[string]interface{}{"grafana_data": nil},
+	}
+	planned := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"grafana": map[string]interface{}{"image": "grafana/grafana:11.2.0", "restart": "unless-stopped", "user": "472"},
+			"loki":    map[string]interface{}{"image": "grafana/loki"},
+			"traefik": map[string]interface{}{"image": "traefik:v3"},
+		},
+		Volumes:  map[string]interface{}{"grafana_data": nil},
+		Networks: map[string]interface{}{"monitoring": nil},
+	}
+
+	var got []string
+	for _, c := range diffCompose(deployed, planned) {
+		got = append(got, fmt.Sprintf("%s %s %s %s", c.Kind, c.Name, c.Action, strings.Join(c.Keys, ",")))
+	}
+	want := []string{
+		"service grafana changed image,user",
+		"service loki added ",
+		"service radarr removed ",
+		"network monitoring added ",
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("diffCompose() = %q, want %q", got, want)
+	}
+
+	// Nothing deployed yet: everything is added
+	if changes := diffCompose(nil, planned); len(changes) != 5 {
+		t.Errorf("diffCompose(nil) = %d change(s), want 5", len(changes))
+	}
+}
+
+func TestCompleteWords(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", []string{}, []string{"traefik"})
+	testutil.CreateStack(t, "monitoring", []string{"core"}, []string{"grafana", "prometheus"})
+	if err := Enable([]string{"core"}); err != nil {
+		t.Fatalf("Enable(core) failed: %v", err)
+	}
+	testutil.WriteFile(t, "inventory/environments/staging/vars.yaml", "domain: staging.lan\n")
+
+	tests := []struct {
+		words []string
+		want  string
+	}{
+		{[]string{"enable", ""}, "core,monitoring"},      // Stacks in stacks/
+		{[]string{"generate", "--annotate", ""}, "core"}, // Enabled stacks
+		{[]string{"logs", ""}, "core,traefik"},           // Enabled stacks and services
+		{[]string{"ddns", ""}, "watch"},                  // Subcommands
+		{[]string{"ddns", "watch", ""}, ""},              // Only as the first argument
+		{[]string{"deploy", "--from-bundle", ""}, ""},    // Free flag values
+		{[]string{"deploy", "--from-bundle", "b.tar", ""}, "core"},
+		{[]string{"--env", ""}, "staging"}, // Global flag values
+		{[]string{"--engine", ""}, "compose,podman,docker-api"},
+		{[]string{"images", ""}, ""}, // Passed through to docker compose
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(completeWords(tt.words), ","); got != tt.want {
+			t.Errorf("completeWords(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+
+	commands := strings.Join(completeWords([]string{"--debug", "gen"}), ",")
+	if !strings.HasPrefix(commands, "init,") || !strings.Contains(commands, ",generate,") {
+		t.Errorf("completeWords(--debug gen) = %q, want the commands", commands)
+	}
+
+	flags := strings.Join(completeWords([]string{"deploy", "--"}), ",")
+	if !strings.Contains(flags, "--waves") || !strings.Contains(flags, "--debug") {
+		t.Errorf("completeWords(deploy --) = %q, want deploy flags and --debug", flags)
+	}
+	// Other global flags are only read before the command
+	if strings.Contains(flags, "--host") {
+		t.Errorf("completeWords(deploy --) = %q, should not offer --host after the command", flags)
+	}
+	if err := Completion([]string{"powershell"}); err == nil {
+		t.Error("Completion(powershell) should fail")
+	}
+}
+
+// captureStdout returns what fn prints on stdout
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe() failed: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	fnErr := fn()
+	os.Stdout = stdout
+	w.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("failed to read stdout: %v", err)
+	}
+	return string(data), fnErr
+}
+
+func TestMachineOutput(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", []string{}, []string{"traefik", "whoami"})
+	testutil.EnableStack(t, "core")
+	if err := inventory.DisableService("core", "whoami"); err != nil {
+		t.Fatalf("DisableService() failed: %v", err)
+	}
+
+	t.Setenv("HOMELAB_OUTPUT", outputJSON)
+
+	out, err := captureStdout(t, List)
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	var listed listOutput
+	if err := json.Unmarshal([]byte(out), &listed); err != nil {
+		t.Fatalf("List() printed invalid JSON: %v\n%s", err, out)
+	}
+	if len(listed.Stacks) != 1 || listed.Stacks[0].Name != "core" || listed.Stacks[0].Category != "other" {
+		t.Errorf("List() stacks = %+v", listed.Stacks)
+	}
+	if len(listed.Categories) != 1 || listed.Categories[0].Name != "other" {
+		t.Errorf("List() categories = %+v", listed.Categories)
+	}
+	if strings.Join(listed.DisabledServices, ",") != "core/whoami" || strings.Join(listed.Stacks[0].DisabledServices, ",") != "whoami" {
+		t.Errorf("List() disabled services = %v, %v", listed.DisabledServices, listed.Stacks[0].DisabledServices)
+	}
+
+	out, err = captureStdout(t, func() error { return Validate(nil) })
+	if err != nil {
+		t.Fatalf("Validate() failed: %v", err)
+	}
+	var validated validateOutput
+	if err := json.Unmarshal([]byte(out), &validated); err != nil {
+		t.Fatalf("Validate() printed invalid JSON: %v\n%s", err, out)
+	}
+	if !validated.Valid || validated.Error != nil || len(validated.Checks) == 0 || strings.HasPrefix(validated.Checks[0], "✓") {
+		t.Errorf("Validate() = %+v", validated)
+	}
+
+	// A failed validation is reported in the document and still returns its error
+	testutil.CreateStack(t, "broken", []string{"nonexistent"}, []string{"app"})
+	testutil.EnableStack(t, "broken")
+	t.Setenv("HOMELAB_OUTPUT", outputYAML)
+
+	out, err = captureStdout(t, func() error { return Validate(nil) })
+	if err == nil {
+		t.Error("Validate() should fail with unsatisfied dependencies")
+	}
+	validated = validateOutput{}
+	if err := yaml.Unmarshal([]byte(out), &validated); err != nil {
+		t.Fatalf("Validate() printed invalid YAML: %v\n%s", err, out)
+	}
+	if validated.Valid || validated.Error == nil || validated.Error.Message == "" {
+		t.Errorf("Validate() = %+v, want the dependency error", validated)
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	unit := systemdUnit("/srv/homelab", "/usr/local/bin/homelabctl", []string{"deploy"}, []string{"HOMELAB_ENV=prod"}, "")
+
+	for _, want := range []string{
+		"Requires=docker.service\n",
+		"After=docker.service network-online.target\n",
+		"Wants=network-online.target\n",
+		"WorkingDirectory=/srv/homelab\n",
+		"Environment=HOMELAB_ENV=prod\n",
+		"ExecStart=/usr/local/bin/homelabctl deploy\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("systemdUnit() lacks %q:\n%s", want, unit)
+		}
+	}
+
+	// Paths with spaces, quotes and specifiers
+	unit = systemdUnit("/srv/my homelab", "/opt/home lab/homelabctl", []string{"deploy"}, []string{"HOMELAB_ENV=prod 50%"}, "")
+	for _, want := range []string{
+		"WorkingDirectory=/srv/my homelab\n",
+		`Environment="HOMELAB_ENV=prod 50%%"` + "\n",
+		`ExecStart="/opt/home lab/homelabctl" deploy` + "\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("systemdUnit() lacks %q:\n%s", want, unit)
+		}
+	}
+
+	// Podman runs without a daemon, its socket is wanted but not required
+	unit = systemdUnit("/srv/homelab", "/usr/local/bin/homelabctl", []string{"deploy"}, nil, engine.KindPodman)
+	if strings.Contains(unit, "docker.service") || strings.Contains(unit, "Requires=") {
+		t.Errorf("systemdUnit(podman) depends on docker:\n%s", unit)
+	}
+	for _, want := range []string{"Wants=podman.socket network-online.target\n", "After=podman.socket network-online.target\n"} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("systemdUnit(podman) lacks %q:\n%s", want, unit)
+		}
+	}
+
+	if got, want := systemdQuote(`/a "b"\c`), `"/a \"b\"\\c"`; got != want {
+		t.Errorf("systemdQuote() = %s, want %s", got, want)
+	}
+
+	// The settings of the run the unit keeps
+	t.Setenv("HOMELAB_ENV", "prod")
+	t.Setenv("HOMELAB_ENGINE", "")
+	t.Setenv("HOMELAB_PROJECT", "lab")
+	if got, want := serviceEnvironment(), []string{"HOMELAB_ENV=prod", "HOMELAB_PROJECT=lab"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("serviceEnvironment() = %v, want %v", got, want)
+	}
+
+	if err := InstallService([]string{"--name", "bad/name"}); err == nil {
+		t.Error("InstallService() should reject a unit name with a slash")
+	}
+	if err := InstallService([]string{"--force"}); err == nil {
+		t.Error("InstallService() should reject unknown flags")
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+
+	retention, err := historyRetention()
+	if err != nil || retention.Keep != defaultHistoryKeep || !retention.DefaultKeep || retention.MaxAge != 0 || retention.MaxSize != 0 {
+		t.Errorf("historyRetention() without a file = %+v, %v, want the defaults", retention, err)
+	}
+
+	testutil.WriteFile(t, paths.InventoryHistory, "keep: 0\nmax_age: 30d\nmax_size: 1GiB\n")
+	retention, err = historyRetention()
+	if err != nil || retention.Keep != 0 || retention.DefaultKeep || retention.MaxAge != 30*24*time.Hour || retention.MaxSize != 1<<30 {
+		t.Errorf("historyRetention() = %+v, %v", retention, err)
+	}
+
+	for _, content := range []string{"keep: -1\n", "max_age: soon\n", "max_size: big\n"} {
+		testutil.WriteFile(t, paths.InventoryHistory, content)
+		if _, err := historyRetention(); err == nil {
+			t.Errorf("historyRetention() with %q should fail", content)
+		}
+	}
+
+	testutil.WriteFile(t, paths.InventoryHistory, "keep: 1\n")
+	testutil.WriteFile(t, filepath.Join(paths.RuntimeStaging, "docker-compose.yml"), "services: {}\n")
+	for _, id := range []string{"20240101-000000", "20240102-000000"} {
+		testutil.WriteFile(t, filepath.Join(paths.HistoryEntryDir(id), paths.HistorySnapshot), "stacks: {}\n")
+	}
+
+	if err := Clean(nil); err != nil {
+		t.Fatalf("Clean() failed: %v", err)
+	}
+	if _, err := os.Stat(paths.RuntimeStaging); !os.IsNotExist(err) {
+		t.Error("Clean() should remove the staging directory")
+	}
+	if _, err := os.Stat(paths.HistoryEntryDir("20240101-000000")); !os.IsNotExist(err) {
+		t.Error("Clean() should remove the generations past keep")
+	}
+
+	if err := Clean([]string{"--history"}); err != nil {
+		t.Fatalf("Clean(--history) failed: %v", err)
+	}
+	if _, err := os.Stat(paths.HistoryEntryDir("20240102-000000")); !os.IsNotExist(err) {
+		t.Error("Clean(--history) should remove every generation")
+	}
+}
+
+func TestGraph(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", []string{}, []string{"traefik"})
+	testutil.CreateStack(t, "database", []string{"core"}, []string{"postgres"})
+	testutil.CreateStack(t, "cloud", []string{"core", "database", "auth"}, []string{"nextcloud"})
+	for _, name := range []string{"core", "database", "cloud"} {
+		testutil.EnableStack(t, name)
+	}
+
+	graph, err := stacks.NewCycleDetector([]string{"core", "database", "cloud"})
+	if err != nil {
+		t.Fatalf("NewCycleDetector() failed: %v", err)
+	}
+
+	want := "cloud\n" +
+		"├── core\n" +
+		"├── database\n" +
+		"│   └── core\n" +
+		"└── auth (not enabled)\n"
+	if got := graphTree(graph, []string{"core", "database", "cloud"}, "not enabled"); got != want {
+		t.Errorf("graphTree() =\n%s\nwant\n%s", got, want)
+	}
+
+	dot := graphDOT(graph, map[string][]string{"other": {"core", "database", "cloud"}}, "not enabled")
+	for _, line := range []string{`"cloud" -> "database";`, `"database" -> "core";`, `"auth" [style=dashed`, `subgraph "cluster_other"`} {
+		if !strings.Contains(dot, line) {
+			t.Errorf("graphDOT() lacks %s:\n%s", line, dot)
+		}
+	}
+
+	if err := Graph([]string{"--format", "svg"}); err == nil {
+		t.Error("Graph() should reject unknown formats")
+	}
+	if err := Graph([]string{"--format", "dot"}); err != nil {
+		t.Errorf("Graph(--format dot) failed: %v", err)
+	}
+}
+
+func TestRollbackRestore(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	generations := map[string]string{
+		"20240101-000000": "grafana/grafana:10.1",
+		"20240102-000000": "grafana/grafana:10.2",
+	}
+	for id, image := range generations {
+		testutil.WriteFile(t, filepath.Join(paths.HistoryEntryDir(id), paths.HistorySnapshot), "stacks: {}\n")
+		testutil.WriteFile(t, filepath.Join(paths.HistoryEntryDir(id), paths.HistoryOutputs, "docker-compose.yml"),
+			"services:\n  grafana:\n    image: "+image+"\n")
+	}
+	// Recorded before outputs were archived
+	testutil.WriteFile(t, filepath.Join(paths.HistoryEntryDir("20231231-000000"), paths.HistorySnapshot), "stacks: {}\n")
+	testutil.WriteFile(t, paths.DockerCompose, "services:\n  grafana:\n    image: grafana/grafana:10.2\n")
+
+	restorable, err := restorableGenerations()
+	if err != nil || len(restorable) != 2 {
+		t.Fatalf("restorableGenerations() = %v, %v; want 2", restorable, err)
+	}
+
+	target, err := rollbackTarget(restorable, "")
+	if err != nil || target.ID != "20240101-000000" {
+		t.Fatalf("rollbackTarget() = %v, %v; want the generation before the latest", target, err)
+	}
+	if target, err := rollbackTarget(restorable, "20240102-000000"); err != nil || target.ID != "20240102-000000" {
+		t.Errorf("rollbackTarget(--to) = %v, %v", target, err)
+	}
+	for _, to := range []string{"20231231-000000", "nope"} {
+		if _, err := rollbackTarget(restorable, to); err == nil {
+			t.Errorf("rollbackTarget(%s) should fail", to)
+		}
+	}
+	if _, err := rollbackTarget(restorable[:1], ""); err == nil {
+		t.Error("rollbackTarget() should fail without an earlier generation")
+	}
+
+	// Once rolled back, the next rollback goes further back
+	if err := history.SetCurrent("20240101-000000"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rollbackTarget(restorable, ""); err == nil {
+		t.Error("rollbackTarget() should fail once the oldest generation is current")
+	}
+	if err := history.SetCurrent("20240102-000000"); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := rollbackTarget(restorable, ""); err != nil || again.ID != target.ID {
+		t.Errorf("rollbackTarget() with the latest current = %v, %v; want %s", again, err, target.ID)
+	}
+
+	if _, err := restoreGeneration(target.ID); err != nil {
+		t.Fatalf("restoreGeneration() failed: %v", err)
+	}
+	data, err := os.ReadFile(paths.DockerCompose)
+	if err != nil || !strings.Contains(string(data), "grafana/grafana:10.1") {
+		t.Errorf("restored compose file = %q, %v", data, err)
+	}
+	if _, err := os.Stat(paths.RuntimeStaging); !os.IsNotExist(err) {
+		t.Error("restoreGeneration() should not leave the staging directory")
+	}
+}
+
+func TestPullServices(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", nil, []string{"traefik", "whoami"})
+	testutil.WriteFile(t, paths.DockerCompose, "services:\n  traefik:\n    image: traefik:v3\n  whoami:\n    image: traefik/whoami\n  grafana:\n    image: grafana/grafana\n")
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if services, err := pullServices(generated, nil); err != nil || services != nil {
+		t.Errorf("pullServices() = %v, %v; want every service", services, err)
+	}
+	services, err := pullServices(generated, []string{"grafana", "core", "whoami"})
+	if want := []string{"grafana", "traefik", "whoami"}; err != nil || !reflect.DeepEqual(services, want) {
+		t.Errorf("pullServices() = %v, %v; want %v", services, err, want)
+	}
+	if _, err := pullServices(generated, []string{"nope"}); err == nil {
+		t.Error("pullServices() should reject a name that is neither a stack nor a service")
+	}
+}
+
+func TestBindMountPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"/srv/media":  "/srv/media",
+		"/srv/media/": "/srv/media",
+		"./data/app":  filepath.Join(wd, "runtime", "data", "app"),
+		"../data":     filepath.Join(wd, "data"),
+		"~/media":     filepath.Join(home, "media"),
+		"~":           home,
+	}
+	for name, want := range tests {
+		if got, err := bindMountPath(name); err != nil || got != want {
+			t.Errorf("bindMountPath(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestCapacityWarnings(t *testing.T) {
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"jellyfin": map[string]interface{}{
+				"labels": map[string]interface{}{compose.LabelCategory: "media"},
+				"deploy": map[string]interface{}{"resources": map[string]interface{}{"limits": map[string]interface{}{"memory": "4g"}}},
+			},
+			"sonarr":   map[string]interface{}{"labels": map[string]interface{}{compose.LabelCategory: "media"}},
+			"postgres": map[string]interface{}{"mem_limit": "1GiB"},
+			"whoami":   map[string]interface{}{"image": "traefik/whoami"},
+		},
+	}
+
+	capacity := &inventory.Capacity{HostMemory: "8GiB", MemoryHeadroom: "25%", Categories: map[string]string{"media": "1GiB"}}
+	warnings, err := capacityWarnings(generated, capacity)
+	if err != nil {
+		t.Fatalf("capacityWarnings() error = %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "memory of whoami unknown") {
+		t.Errorf("warnings = %v, want only whoami without a memory estimate", warnings)
+	}
+
+	// 4 + 1 + 1 + 0.5 GiB against 8 GiB less 2 GiB of headroom
+	capacity.ServiceMemory = "512MiB"
+	capacity.MaxServices = 3
+	warnings, err = capacityWarnings(generated, capacity)
+	if err != nil {
+		t.Fatalf("capacityWarnings() error = %v", err)
+	}
+	want := []string{
+		"4 services planned, more than the 3 of max_services",
+		"need about 6.5GiB of memory, more than the 6.0GiB the host has after 2.0GiB of headroom",
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("warnings = %v, want %d", warnings, len(want))
+	}
+	for i, w := range want {
+		if !strings.Contains(warnings[i], w) {
+			t.Errorf("warning %d = %q, want it to contain %q", i, warnings[i], w)
+		}
+	}
+
+	capacity.MemoryHeadroom = "lots"
+	if _, err := capacityWarnings(generated, capacity); err == nil {
+		t.Error("capacityWarnings() should reject an invalid memory_headroom")
+	}
+
+	// Without host_memory, the host's own memory is read
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	meminfo := filepath.Join(tmpDir, "meminfo")
+	if err := os.WriteFile(meminfo, []byte("MemTotal:        4194304 kB\nMemFree:  1024 kB\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	meminfoPath = meminfo
+	defer func() { meminfoPath = "/proc/meminfo" }()
+	if memory, err := capacityHostMemory(&inventory.Capacity{}); err != nil || memory != 4<<30 {
+		t.Errorf("capacityHostMemory() = %v, %v; want 4GiB from meminfo", memory, err)
+	}
+}
+
+func TestWarnCapacity(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "media", []string{}, []string{"jellyfin", "sonarr"})
+	testutil.WriteFile(t, "stacks/media/compose.yml.tmpl", "services:\n  jellyfin:\n    image: jellyfin/jellyfin\n  sonarr:\n    image: linuxserver/sonarr\n")
+	testutil.EnableStack(t, "media")
+	testutil.WriteFile(t, paths.InventoryCapacity, "max_services: 1\nhost_memory: 8GiB\nservice_memory: 256MiB\n")
+
+	// Checked against the services generate would write, nothing is generated yet
+	collectedWarnings = nil
+	defer func() { collectedWarnings = nil }()
+	checked, err := warnCapacity()
+	if err != nil || !checked {
+		t.Fatalf("warnCapacity() = %v, %v; want checked", checked, err)
+	}
+	if len(Warnings()) != 1 || !strings.Contains(Warnings()[0], "2 services planned, more than the 1 of max_services") {
+		t.Errorf("warnings = %v, want the service count", Warnings())
+	}
+	if _, err := os.Stat(paths.DockerCompose); err == nil {
+		t.Error("warnCapacity() should not write runtime/")
+	}
+	if _, err := os.Stat(paths.RuntimeStaging); err == nil {
+		t.Error("warnCapacity() left the staging dir behind")
+	}
+}
+
+func TestComposeBytes(t *testing.T) {
+	tests := map[interface{}]float64{
+		"512m": 512 << 20, "1gb": 1 << 30, "1.5GiB": 1.5 * (1 << 30), "2048k": 2 << 20,
+		"100b": 100, 1048576: 1 << 20, "lots": 0,
+	}
+	for value, want := range tests {
+		if got := composeBytes(value); got != want {
+			t.Errorf("composeBytes(%v) = %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestGenerationImpact(t *testing.T) {
+	labels := func(stack string, extra map[string]interface{}) map[string]interface{} {
+		svc := map[string]interface{}{"labels": map[string]interface{}{compose.LabelStack: stack}}
+		for k, v := range extra {
+			svc[k] = v
+		}
+		return svc
+	}
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"traefik": labels("proxy", map[string]interface{}{"ports": []interface{}{"80:80", "443:443", "8080"}}),
+			"grafana": labels("monitoring", nil),
+			"loki":    labels("monitoring", map[string]interface{}{"ports": []interface{}{"127.0.0.1:3100:3100"}}),
+		},
+		Volumes:  map[string]interface{}{"grafana_data": nil, "loki_data": nil},
+		Networks: map[string]interface{}{"proxy": nil},
+	}
+
+	first := generationImpact(generated, nil, nil)
+	if first.Previous != "" || first.Stacks != 2 || first.Services != 3 || first.PublishedPorts != 3 || len(first.Added) != 0 {
+		t.Errorf("first generation impact = %+v", first)
+	}
+
+	previous := &history.Snapshot{ID: "20240501-120000", Stacks: map[string]history.StackSnapshot{
+		"proxy":      {Services: map[string]string{"traefik": "traefik:v3"}},
+		"monitoring": {Services: map[string]string{"grafana": "grafana/grafana", "prometheus": "prom/prometheus"}},
+	}}
+	previousCompose := &compose.ComposeFile{
+		Volumes:  map[string]interface{}{"grafana_data": nil},
+		Networks: map[string]interface{}{"proxy": nil},
+	}
+
+	got := generationImpact(generated, previous, previousCompose)
+	if got.Previous != previous.ID || !got.Compared {
+		t.Errorf("impact = %+v, want it compared with %s", got, previous.ID)
+	}
+	if !reflect.DeepEqual(got.Added, []string{"loki"}) || !reflect.DeepEqual(got.Removed, []string{"prometheus"}) {
+		t.Errorf("added %v, removed %v; want loki added and prometheus removed", got.Added, got.Removed)
+	}
+	if !reflect.DeepEqual(got.NewVolumes, []string{"loki_data"}) || len(got.NewNetworks) != 0 {
+		t.Errorf("new volumes %v, networks %v; want loki_data only", got.NewVolumes, got.NewNetworks)
+	}
+}
+
+func TestScanSecrets(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "cloud", nil, []string{"nextcloud"})
+	testutil.EnableStack(t, "cloud")
+	testutil.WriteFile(t, paths.SecretsFilePath("cloud", paths.SecretsExt), "db:\n  password: s3cret-db\n")
+	testutil.WriteFile(t, paths.DockerCompose, "services:\n  nextcloud:\n    environment:\n      POSTGRES_PASSWORD: s3cret-db\n")
+	testutil.WriteFile(t, filepath.Join(paths.HistoryDir, "20261015-080000", "outputs", "docker-compose.yml"), "s3cret-db\n")
+	t.Setenv("HOMELAB_STRICT", "")
+
+	collectedWarnings = nil
+	if err := ScanSecrets(nil); err != nil {
+		t.Fatalf("ScanSecrets() error = %v", err)
+	}
+	want := []string{"runtime/docker-compose.yml (mode 0644) contains secret db.password of stack cloud"}
+	if !reflect.DeepEqual(Warnings(), want) {
+		t.Errorf("Warnings() = %v, want %v (runtime/history/ left out)", Warnings(), want)
+	}
+
+	t.Setenv("HOMELAB_STRICT", "1")
+	collectedWarnings = nil
+	if err := ScanSecrets(nil); err == nil || !strings.Contains(err.Error(), "1 secret value(s)") {
+		t.Errorf("ScanSecrets() error = %v with --strict, want the leak", err)
+	}
+
+	if err := os.Chmod(paths.DockerCompose, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ScanSecrets([]string{"cloud"}); err != nil {
+		t.Errorf("ScanSecrets() error = %v, want none once the compose file is private", err)
+	}
+	collectedWarnings = nil
+}
+
+func TestFirstRun(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	t.Setenv("HOMELAB_OUTPUT", "")
+
+	shown := func() bool {
+		_, err := os.Stat(paths.FirstRunMarker)
+		return err == nil
+	}
+
+	t.Setenv("HOMELAB_QUIET", "1")
+	FirstRun("list")
+	if shown() {
+		t.Error("FirstRun() should stay silent with --quiet")
+	}
+
+	t.Setenv("HOMELAB_QUIET", "")
+	FirstRun("list")
+	if shown() {
+		t.Error("FirstRun() should stay silent when stdout is not a terminal")
+	}
+
+	defer func(terminal func() bool) { stdoutTerminal = terminal }(stdoutTerminal)
+	stdoutTerminal = func() bool { return true }
+	FirstRun("init")
+	if shown() {
+		t.Error("FirstRun() should leave init to its own next steps")
+	}
+	FirstRun("list")
+	if !shown() {
+		t.Errorf("FirstRun() should record %s once the guidance was shown", paths.FirstRunMarker)
+	}
+
+	if err := os.Remove(paths.FirstRunMarker); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CreateStack(t, "core", nil, []string{"traefik"})
+	testutil.EnableStack(t, "core")
+	FirstRun("list")
+	if shown() {
+		t.Error("FirstRun() should not guide a repository with enabled stacks")
+	}
+}
+
+func TestGenerateWatchArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"deploy without watch", []string{"--deploy"}, "require --watch"},
+		{"debounce without watch", []string{"--debounce=2s"}, "require --watch"},
+		{"missing debounce", []string{"--watch", "--debounce"}, "requires a value"},
+		{"invalid debounce", []string{"--watch", "--debounce", "soon"}, "invalid --debounce value: soon"},
+		{"deploy with annotate", []string{"--watch", "--deploy", "--annotate"}, "only takes stack names"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Generate(tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Generate(%v) error = %v, want it to contain %q", tt.args, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribeChanges(t *testing.T) {
+	if got := describeChanges([]string{"a", "b"}); got != "a, b" {
+		t.Errorf("describeChanges() = %q, want %q", got, "a, b")
+	}
+	if got := describeChanges([]string{"a", "b", "c", "d", "e"}); got != "a, b, c and 2 more" {
+		t.Errorf("describeChanges() = %q, want %q", got, "a, b, c and 2 more")
+	}
+}
+
+func TestDoctor(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	// Fake docker and gomplate; sops is missing
+	bin := filepath.Join(tmpDir, "bin")
+	testutil.WriteFile(t, filepath.Join(bin, "docker"), `#!/bin/sh
+case "$1" in
+  --version) echo "Docker version 27.1.1, build 6312585" ;;
+  info) echo "27.1.1" ;;
+  compose) echo "2.29.1" ;;
+esac
+`)
+	testutil.WriteFile(t, filepath.Join(bin, "gomplate"), "#!/bin/sh\necho 'gomplate version 3.11.5'\n")
+	for _, tool := range []string{"docker", "gomplate"} {
+		if err := os.Chmod(filepath.Join(bin, tool), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin)
+	t.Setenv("HOMELAB_ENGINE", "")
+	t.Setenv("HOMELAB_OUTPUT", "")
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "core", nil, []string{"traefik"})
+	testutil.EnableStack(t, "core")
+	testutil.CreateSymlink(t, filepath.Join("..", "stacks", "gone"), filepath.Join("enabled", "gone"))
+	testutil.WriteFile(t, "inventory/state.yaml", "version: 2\nstacks:\n  media:\n    disabled_services: [plex]\n")
+	testutil.WriteFile(t, "runtime/docker-compose.yml", "services:\n  traefik:\n    ports: ['80:80']\n  caddy:\n    ports: ['80:80']\n")
+
+	statuses := func() map[string]string {
+		results := append(doctorEnvironment(), doctorRepository()...)
+		got := make(map[string]string)
+		for _, result := range results {
+			got[result.Name] = result.Status
+		}
+		return got
+	}
+
+	want := map[string]string{
+		"docker":         doctorOK,
+		"docker daemon":  doctorOK,
+		"docker compose": doctorOK,
+		"gomplate":       doctorOK,
+		"sops":           doctorWarning,
+		"repository":     doctorOK,
+		"enabled stacks": doctorProblem,
+		"state":          doctorOK, // Skipped while enabled/ has a broken link
+		"ports":          doctorProblem,
+	}
+	if got := statuses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("doctor checks = %v, want %v", got, want)
+	}
+	if err := Doctor(nil); err == nil {
+		t.Error("Doctor() should fail with problems")
+	}
+
+	// Fixed links reveal the orphaned state entry; encrypted secrets make sops required
+	if err := os.Remove(filepath.Join("enabled", "gone")); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WriteFile(t, "runtime/docker-compose.yml", "services:\n  traefik:\n    ports: ['80:80']\n")
+	testutil.WriteFile(t, "secrets/core.enc.yaml", "sops: {}\n")
+	want["enabled stacks"], want["state"], want["ports"], want["sops"] = doctorOK, doctorWarning, doctorOK, doctorProblem
+	if got := statuses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("doctor checks = %v, want %v", got, want)
+	}
+
+	os.Remove("secrets/core.enc.yaml")
+	if err := Doctor(nil); err != nil {
+		t.Errorf("Doctor() with warnings only error = %v", err)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	err := Passthrough("dploy", nil)
+	enhanced, ok := err.(*errors.Error)
+	if !ok {
+		t.Fatalf("Passthrough(dploy) error = %v, want an unknown command error", err)
+	}
+	if enhanced.Class != errors.ClassUsage || enhanced.Suggestions[0] != "Did you mean: homelabctl deploy" {
+		t.Errorf("Passthrough(dploy) = %q %v, want a usage error suggesting deploy", enhanced.Message, enhanced.Suggestions)
+	}
+
+	// Known compose commands reach docker compose, which needs the generated file
+	if err := Passthrough("images", nil); err == nil || !strings.Contains(err.Error(), "run 'generate' first") {
+		t.Errorf("Passthrough(images) error = %v, want it passed to docker compose", err)
+	}
+	if err := ComposeAny([]string{"--", "alpha", "viz"}); err == nil || !strings.Contains(err.Error(), "run 'generate' first") {
+		t.Errorf("ComposeAny(alpha) error = %v, want it passed to docker compose", err)
+	}
+	if err := ComposeAny([]string{"--"}); err == nil || !strings.Contains(err.Error(), "usage") {
+		t.Errorf("ComposeAny() error = %v, want usage", err)
+	}
+
+	tests := map[string][]string{
+		"lgos":     {"logs", "ls"},
+		"imgaes":   {"images"},
+		"valiadte": {"validate"},
+		"xyzzy":    nil,
+	}
+	for command, want := range tests {
+		if got := similarCommands(command); !reflect.DeepEqual(got, want) {
+			t.Errorf("similarCommands(%s) = %v, want %v", command, got, want)
+		}
+	}
+}
+
+func TestStackVolumes(t *testing.T) {
+	generated := &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"grafana":    map[string]interface{}{"volumes": []interface{}{"grafana-data:/var/lib/grafana", "shared:/shared"}},
+			"prometheus": map[string]interface{}{"volumes": []interface{}{map[string]interface{}{"type": "volume", "source": "prom-data", "target": "/prometheus"}, "backups:/backups"}},
+			"traefik":    map[string]interface{}{"volumes": []interface{}{"shared:/shared", "/var/run/docker.sock:/var/run/docker.sock"}},
+		},
+		Volumes: map[string]interface{}{
+			"grafana-data": nil,
+			"prom-data":    map[string]interface{}{"name": "prometheus"},
+			"backups":      map[string]interface{}{"external": true},
+			"shared":       nil,
+			"unused":       nil,
+		},
+	}
+
+	got := stackVolumes(generated, "homelab", []string{"grafana", "prometheus"})
+	want := []string{"homelab_grafana-data", "prometheus"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stackVolumes() = %v, want %v", got, want)
+	}
+}
+
+func TestProjectName(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	t.Setenv("HOMELAB_PROJECT", "")
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	t.Setenv("HOMELAB_ENV", "")
+
+	if name, err := projectName(); err != nil || name != "runtime" {
+		t.Errorf("projectName() = %q, %v without settings, want runtime", name, err)
+	}
+
+	testutil.WriteFile(t, paths.InventoryCompose, "project: homelab\n")
+	if name, _ := projectName(); name != "homelab" {
+		t.Errorf("projectName() = %q, want homelab from %s", name, paths.InventoryCompose)
+	}
+	t.Setenv("HOMELAB_ENV", "staging")
+	if name, _ := projectName(); name != "homelab-staging" {
+		t.Errorf("projectName() = %q with --env staging, want homelab-staging", name)
+	}
+
+	t.Setenv("COMPOSE_PROJECT_NAME", "compose")
+	if name, _ := projectName(); name != "compose" {
+		t.Errorf("projectName() = %q, want COMPOSE_PROJECT_NAME over the inventory", name)
+	}
+	t.Setenv("HOMELAB_PROJECT", "flag")
+	if name, _ := projectName(); name != "flag" {
+		t.Errorf("projectName() = %q, want --project over COMPOSE_PROJECT_NAME", name)
+	}
+
+	t.Setenv("HOMELAB_PROJECT", "Not Valid")
+	if _, err := projectName(); err == nil {
+		t.Error("projectName() should reject an invalid --project")
+	}
+	if _, err := projectEngine(); err == nil {
+		t.Error("projectEngine() should fail with an invalid --project")
+	}
+}
+
+func TestEnvExports(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateRepoStructure(t)
+	testutil.WriteFile(t, paths.DockerCompose, "services: {}\n")
+	testutil.WriteFile(t, ".env", "TZ=UTC\n")
+	t.Setenv("COMPOSE_PROJECT_NAME", "")
+	t.Setenv("HOMELAB_PROJECT", "")
+	t.Setenv("HOMELAB_ENV", "staging")
+
+	exports, err := envExports()
+	if err != nil {
+		t.Fatalf("envExports() error = %v", err)
+	}
+	// The temporary directory may be reached through a symlink
+	dir, _ := os.Getwd()
+	want := [][2]string{
+		{"COMPOSE_FILE", filepath.Join(dir, paths.DockerCompose)},
+		{"COMPOSE_PROJECT_NAME", "runtime"},
+		{"COMPOSE_ENV_FILES", filepath.Join(dir, ".env")},
+		{"HOMELAB_ENV", "staging"},
+	}
+	if !reflect.DeepEqual(exports, want) {
+		t.Errorf("envExports() = %v, want %v", exports, want)
+	}
+
+	if got := shellExport("sh", "COMPOSE_FILE", "/srv/it's/compose.yml"); got != `export COMPOSE_FILE='/srv/it'\''s/compose.yml'` {
+		t.Errorf("shellExport(sh) = %s", got)
+	}
+	if got := shellExport("fish", "HOMELAB_ENV", "prod"); got != "set -gx HOMELAB_ENV 'prod';" {
+		t.Errorf("shellExport(fish) = %s", got)
+	}
+	if got := shellUnset("sh", "COMPOSE_FILE"); got != "unset COMPOSE_FILE" {
+		t.Errorf("shellUnset(sh) = %s", got)
+	}
+}
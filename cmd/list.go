@@ -7,26 +7,39 @@ import (
 	"github.com/monkeymonk/homelabctl/internal/fs"
 	"github.com/monkeymonk/homelabctl/internal/inventory"
 	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/style"
 )
 
-// categoryColor returns a colored category badge
+// categoryColor returns a colored category badge, in the user's theme color if set
 func categoryColor(catName string) string {
 	cat, _ := categories.Get(catName)
 	if cat == nil {
 		return catName
 	}
+	return style.Colorize(style.CategoryColor(cat.Name, cat.Color), cat.DisplayName)
+}
 
-	// Simple color mapping
-	switch cat.Color {
-	case "blue":
-		return "\033[34m" + cat.DisplayName + "\033[0m"
-	case "magenta":
-		return "\033[35m" + cat.DisplayName + "\033[0m"
-	case "cyan":
-		return "\033[36m" + cat.DisplayName + "\033[0m"
-	default:
-		return cat.DisplayName
-	}
+// listOutput is the machine-readable form of list (--output json|yaml)
+type listOutput struct {
+	Stacks           []listedStack    `json:"stacks" yaml:"stacks"`
+	Categories       []listedCategory `json:"categories" yaml:"categories"`
+	DisabledServices []string         `json:"disabled_services" yaml:"disabled_services"` // stack/service
+}
+
+// listedStack is an enabled stack in list's machine-readable form
+type listedStack struct {
+	Name             string   `json:"name" yaml:"name"`
+	Category         string   `json:"category" yaml:"category"`
+	Services         []string `json:"services" yaml:"services"`
+	DisabledServices []string `json:"disabled_services" yaml:"disabled_services"`
+}
+
+// listedCategory is a category with enabled stacks in list's machine-readable form
+type listedCategory struct {
+	Name        string   `json:"name" yaml:"name"`
+	DisplayName string   `json:"display_name" yaml:"display_name"`
+	Order       int      `json:"order" yaml:"order"`
+	Stacks      []string `json:"stacks" yaml:"stacks"`
 }
 
 // List shows enabled stacks grouped by category
@@ -40,6 +53,10 @@ func List() error {
 		return err
 	}
 
+	if machineOutput() {
+		return listMachine(enabled)
+	}
+
 	if len(enabled) == 0 {
 		fmt.Println("No stacks enabled")
 		fmt.Println("\nRun: homelabctl enable <stack>")
@@ -80,7 +97,7 @@ func List() error {
 			if stack != nil {
 				for _, svc := range stack.Services {
 					for _, disabled := range disabledServices {
-						if svc == disabled {
+						if inventory.QualifiedName(stackName, svc) == disabled {
 							fmt.Printf("      ⨯ %s (disabled)\n", svc)
 						}
 					}
@@ -90,6 +107,10 @@ func List() error {
 		fmt.Println()
 	}
 
+	if err := warnStaleDisabledServices(enabled); err != nil {
+		return err
+	}
+
 	// Summary
 	fmt.Printf("Total: %d stack(s) enabled", len(enabled))
 	if len(disabledServices) > 0 {
@@ -99,3 +120,56 @@ func List() error {
 
 	return nil
 }
+
+// listMachine prints the enabled stacks, their categories and the disabled services as a
+// JSON or YAML document, in category order
+func listMachine(enabled []string) error {
+	groups, err := stacks.GroupByCategory(enabled)
+	if err != nil {
+		return err
+	}
+
+	disabledServices, err := inventory.GetDisabledServices()
+	if err != nil {
+		return err
+	}
+	disabled := make(map[string]bool, len(disabledServices))
+	for _, svc := range disabledServices {
+		disabled[svc] = true
+	}
+
+	output := listOutput{Stacks: []listedStack{}, Categories: []listedCategory{}, DisabledServices: disabledServices}
+	if output.DisabledServices == nil {
+		output.DisabledServices = []string{}
+	}
+
+	for _, cat := range categories.AllCategories() {
+		stacksInCat := groups[cat.Name]
+		if len(stacksInCat) == 0 {
+			continue
+		}
+		output.Categories = append(output.Categories, listedCategory{Name: cat.Name, DisplayName: cat.DisplayName, Order: cat.Order, Stacks: stacksInCat})
+
+		for _, stackName := range stacksInCat {
+			stack, err := stacks.LoadStack(stackName)
+			if err != nil {
+				return err
+			}
+			listed := listedStack{Name: stackName, Category: cat.Name, Services: stack.Services, DisabledServices: []string{}}
+			if listed.Services == nil {
+				listed.Services = []string{}
+			}
+			for _, svc := range stack.Services {
+				if disabled[inventory.QualifiedName(stackName, svc)] {
+					listed.DisabledServices = append(listed.DisabledServices, svc)
+				}
+			}
+			output.Stacks = append(output.Stacks, listed)
+		}
+	}
+
+	if err := warnStaleDisabledServices(enabled); err != nil {
+		return err
+	}
+	return writeOutput(output)
+}
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/docker"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/style"
+)
+
+// logColors are the ANSI colors cycled through for service prefixes
+var logColors = []string{"36", "33", "32", "35", "34", "96", "93", "92", "95", "94"}
+
+// logSource is a container whose logs are streamed
+type logSource struct {
+	ID     string
+	Prefix string // stack/service
+}
+
+// Logs streams the logs of services or whole stacks at once, each line prefixed
+// with stack/service in its own color, optionally filtered by a regular expression
+func Logs(args []string) error {
+	usage := "usage: homelabctl logs [stack|service...] [-f] [-n <lines>] [--since <time>] [--until <time>] [-t] [--grep <regex>] [--no-color]"
+	var targets []string
+	var grep string
+	noColor := false
+	opts := docker.LogOptions{}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		switch name {
+		case "-f", "--follow":
+			opts.Follow = true
+		case "-t", "--timestamps":
+			opts.Timestamps = true
+		case "--no-color":
+			noColor = true
+		case "-n", "--tail", "--since", "--until", "--grep":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("%s requires a value (%s)", name, usage)
+				}
+				value = args[i+1]
+				i++
+			}
+			switch name {
+			case "-n", "--tail":
+				if _, err := strconv.Atoi(value); err != nil && value != "all" {
+					return fmt.Errorf("invalid %s value: %s (a number of lines or 'all')", name, value)
+				}
+				opts.Tail = value
+			case "--since", "--until":
+				ts, err := parseLogTime(value, time.Now())
+				if err != nil {
+					return err
+				}
+				if name == "--since" {
+					opts.Since = ts
+				} else {
+					opts.Until = ts
+				}
+			case "--grep":
+				grep = value
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+			}
+			targets = append(targets, arg)
+		}
+	}
+
+	var filter *regexp.Regexp
+	if grep != "" {
+		re, err := regexp.Compile(grep)
+		if err != nil {
+			return fmt.Errorf("invalid --grep pattern: %w", err)
+		}
+		filter = re
+	}
+
+	// --host machines are reached through docker compose over SSH
+	host, err := selectedHost()
+	if err != nil {
+		return err
+	}
+	if host != nil {
+		if filter != nil {
+			return fmt.Errorf("--grep is not supported with --host (pipe the output through grep instead)")
+		}
+		return Compose("logs", args)
+	}
+
+	client, err := docker.FromEnv()
+	if err != nil {
+		return err
+	}
+
+	containers, err := client.ListContainers(true, map[string][]string{
+		"label": {"com.docker.compose.project=" + composeProjectName()},
+	})
+	if err != nil {
+		return err
+	}
+
+	sources, err := selectLogSources(containers, targets)
+	if err != nil {
+		return err
+	}
+
+	printer := &logPrinter{out: os.Stdout, filter: filter}
+	prefixes := formatLogPrefixes(sources, !noColor && colorOutput())
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(sources))
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source logSource) {
+			defer wg.Done()
+			errs[i] = streamLogs(client, source, opts, printer, prefixes[i])
+		}(i, source)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectLogSources picks the containers of the targeted stacks and services
+// (all when no target is given), sorted by prefix
+func selectLogSources(containers []docker.Container, targets []string) ([]logSource, error) {
+	matched := make(map[string]bool)
+	var sources []logSource
+
+	for _, c := range containers {
+		service := c.Labels["com.docker.compose.service"]
+		stack := c.Labels[compose.LabelStack]
+
+		selected := len(targets) == 0
+		for _, target := range targets {
+			if targetMatches(target, stack, service) {
+				matched[target] = true
+				selected = true
+			}
+		}
+		if !selected {
+			continue
+		}
+
+		prefix := service
+		if stack != "" {
+			prefix = stack + "/" + service
+		}
+		if n := c.Labels["com.docker.compose.container-number"]; n != "" && n != "1" {
+			prefix += "-" + n
+		}
+		sources = append(sources, logSource{ID: c.ID, Prefix: prefix})
+	}
+
+	for _, target := range targets {
+		if !matched[target] {
+			return nil, errors.New(
+				fmt.Sprintf("no container found for '%s'", target),
+				"Check the stack or service name: homelabctl ps",
+				"Start it first: homelabctl deploy",
+			).WithClass(errors.ClassNotFound)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no containers found - run 'deploy' first")
+	}
+
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Prefix < sources[j].Prefix })
+	return sources, nil
+}
+
+// targetMatches reports whether a stack, service or stack/service name selects a container
+func targetMatches(target, stack, service string) bool {
+	return target == service || (stack != "" && (target == stack || target == stack+"/"+service))
+}
+
+// formatLogPrefixes pads the prefixes to the same width and, with color, gives
+// each source its own color
+func formatLogPrefixes(sources []logSource, color bool) []string {
+	width := 0
+	for _, s := range sources {
+		if len(s.Prefix) > width {
+			width = len(s.Prefix)
+		}
+	}
+
+	prefixes := make([]string, len(sources))
+	for i, s := range sources {
+		prefix := fmt.Sprintf("%-*s |", width, s.Prefix)
+		if color {
+			prefix = "\033[" + logColors[i%len(logColors)] + "m" + prefix + "\033[0m"
+		}
+		prefixes[i] = prefix
+	}
+	return prefixes
+}
+
+// colorOutput reports whether output is colored (NO_COLOR, the user config, a terminal)
+func colorOutput() bool {
+	return style.ColorEnabled()
+}
+
+// streamLogs copies one container's logs to the printer until the stream ends
+func streamLogs(client *docker.Client, source logSource, opts docker.LogOptions, printer *logPrinter, prefix string) error {
+	tty, err := client.HasTTY(source.ID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", source.Prefix, err)
+	}
+
+	stream, err := client.Logs(context.Background(), source.ID, opts)
+	if err != nil {
+		return fmt.Errorf("failed to read logs of %s: %w", source.Prefix, err)
+	}
+	defer stream.Close()
+
+	stdout := &logLineWriter{printer: printer, prefix: prefix}
+	stderr := &logLineWriter{printer: printer, prefix: prefix}
+	defer stdout.Flush()
+	defer stderr.Flush()
+
+	if tty {
+		_, err = io.Copy(stdout, stream)
+	} else {
+		err = docker.Demux(stream, stdout, stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read logs of %s: %w", source.Prefix, err)
+	}
+	return nil
+}
+
+// logPrinter writes prefixed lines from concurrent streams without interleaving them
+type logPrinter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	filter *regexp.Regexp
+}
+
+// Print writes one line unless it is filtered out
+func (p *logPrinter) Print(prefix, line string) {
+	if p.filter != nil && !p.filter.MatchString(line) {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.out, "%s %s\n", prefix, line)
+}
+
+// logLineWriter splits a log stream into lines for a logPrinter
+type logLineWriter struct {
+	printer *logPrinter
+	prefix  string
+	partial []byte
+}
+
+func (w *logLineWriter) Write(p []byte) (int, error) {
+	data := append(w.partial, p...)
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			break
+		}
+		w.printer.Print(w.prefix, strings.TrimSuffix(string(data[:end]), "\r"))
+		data = data[end+1:]
+	}
+	w.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// Flush prints a trailing line that has no newline
+func (w *logLineWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.printer.Print(w.prefix, strings.TrimSuffix(string(w.partial), "\r"))
+		w.partial = nil
+	}
+}
+
+// parseLogTime converts a --since/--until value to a Unix timestamp: a duration
+// back from now (10m, 1h, 7d), a Unix timestamp, or an RFC 3339 or local date/time
+func parseLogTime(value string, now time.Time) (string, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return strconv.FormatInt(now.Add(-d).Unix(), 10), nil
+	}
+	if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && strings.HasSuffix(value, "d") && days >= 0 {
+		return strconv.FormatInt(now.AddDate(0, 0, -days).Unix(), 10), nil
+	}
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return value, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return strconv.FormatInt(t.Unix(), 10), nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return strconv.FormatInt(t.Unix(), 10), nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid time: %s (use a duration like 1h or a timestamp like 2024-01-02T15:04:05)", value)
+}
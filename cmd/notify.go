@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/notify"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Notify manages notifications: notify test sends a test notification through the
+// routes of inventory/notifications.yaml
+func Notify(args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		return fmt.Errorf("usage: homelabctl notify test [--severity <level>] [--stack <name>] [--category <name>] [--event <name>] [message]")
+	}
+	return notifyTest(args[1:])
+}
+
+// notifyTest sends a notification with the given attributes, printing the channels
+// its routes select, to check routing and channel settings
+func notifyTest(args []string) error {
+	usage := "usage: homelabctl notify test [--severity <level>] [--stack <name>] [--category <name>] [--event <name>] [message]"
+	n := notify.Notification{
+		Time:     time.Now(),
+		Severity: notify.SeverityInfo,
+		Title:    "homelabctl test notification",
+		Event:    "test",
+	}
+	var message []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		switch name {
+		case "--severity", "--stack", "--category", "--event":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("%s requires a value (%s)", name, usage)
+				}
+				value = args[i+1]
+				i++
+			}
+			switch name {
+			case "--severity":
+				n.Severity = value
+			case "--stack":
+				n.Stack = value
+			case "--category":
+				n.Category = value
+			default:
+				n.Event = value
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+			}
+			message = append(message, arg)
+		}
+	}
+
+	switch n.Severity {
+	case notify.SeverityInfo, notify.SeverityWarning, notify.SeverityCritical:
+	default:
+		return fmt.Errorf("invalid --severity value: %s (available: %s, %s, %s)",
+			n.Severity, notify.SeverityInfo, notify.SeverityWarning, notify.SeverityCritical)
+	}
+	n.Message = strings.Join(message, " ")
+	if n.Message == "" {
+		n.Message = "Notifications from homelabctl reach this channel"
+	}
+
+	config, err := notify.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if !config.Enabled() {
+		return errors.New(
+			"no notification routes configured",
+			fmt.Sprintf("Add channels and routes to %s", paths.InventoryNotify),
+		).WithClass(errors.ClassNotFound)
+	}
+
+	channels, err := config.Send(n)
+	if len(channels) == 0 {
+		fmt.Println("No route matches this notification (or its route is in quiet hours)")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "notification delivery failed", "Check the channel URLs and tokens").WithClass(errors.ClassValidation)
+	}
+
+	ui.Success("Sent to %s", strings.Join(channels, ", "))
+	return nil
+}
+
+// sendNotification delivers a notification when routes are configured
+// Callers report a failed delivery as a warning, never as the command's error
+func sendNotification(config *notify.Config, n notify.Notification) error {
+	if config == nil || !config.Enabled() {
+		return nil
+	}
+	_, err := config.Send(n)
+	return err
+}
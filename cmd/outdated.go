@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/registry"
+	"github.com/monkeymonk/homelabctl/internal/sbom"
+)
+
+// stackImage is an image a service of an enabled stack runs, from the stack's vars
+type stackImage struct {
+	Stack   string
+	Service string
+	Image   string
+}
+
+// outdatedImage is an available update of a stack image (--json)
+type outdatedImage struct {
+	Stack   string `json:"stack" yaml:"stack"`
+	Service string `json:"service" yaml:"service"`
+	Image   string `json:"image" yaml:"image"`
+	Tag     string `json:"tag,omitempty" yaml:"tag,omitempty"`       // Newer tag of the same shape
+	Digest  string `json:"digest,omitempty" yaml:"digest,omitempty"` // Newer digest of the same tag
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`   // Registry query failure
+}
+
+// Outdated queries the registries for newer tags, or newer digests of the same tag, of
+// the images the enabled stacks' vars reference, and reports the available updates
+func Outdated(args []string) error {
+	usage := "usage: homelabctl outdated [--json] [--parallel <n>]"
+	jsonOutput := false
+	parallel := defaultPullParallelism
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--json":
+			jsonOutput = true
+		case "--parallel", "-j":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--parallel requires a value (%s)", usage)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid --parallel value: %s", args[i])
+			}
+			parallel = n
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", args[i], usage)
+		}
+	}
+	// --json is --output json, it cannot ask for another format too
+	if jsonOutput && machineOutput() && outputFormat() != outputJSON {
+		return fmt.Errorf("--json conflicts with --output %s (%s)", outputFormat(), usage)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	images, err := stackImages()
+	if err != nil {
+		return err
+	}
+
+	client, err := registryClient()
+	if err != nil {
+		return err
+	}
+
+	updates := checkImages(client, images, parallel)
+
+	if jsonOutput || machineOutput() {
+		return writeOutput(updates)
+	}
+
+	failed := 0
+	var rows []outdatedImage
+	for _, u := range updates {
+		if u.Error != "" {
+			addWarnings(fmt.Sprintf("failed to check %s (%s/%s): %s", u.Image, u.Stack, u.Service, u.Error))
+			failed++
+			continue
+		}
+		rows = append(rows, u)
+	}
+
+	if len(rows) == 0 {
+		fmt.Printf("All %d image(s) are up to date\n", len(images)-failed)
+		return nil
+	}
+
+	fmt.Printf("%-16s %-20s %-40s %s\n", "STACK", "SERVICE", "IMAGE", "UPDATE")
+	for _, u := range rows {
+		update := u.Tag
+		if update == "" {
+			update = "new digest " + shortImageID(u.Digest)
+		}
+		fmt.Printf("%-16s %-20s %-40s %s\n", u.Stack, u.Service, u.Image, update)
+	}
+	fmt.Printf("\n%d of %d image(s) have updates\n", len(rows), len(images))
+	return nil
+}
+
+// stackImages returns the images of the enabled services of the enabled stacks, as
+// their merged vars set them, sorted by stack and service. Services the generated
+// compose file builds from source are skipped: their image is a local tag
+func stackImages() ([]stackImage, error) {
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return nil, err
+	}
+	inventoryVars, err := inventory.LoadVars()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load inventory vars: %w", err)
+	}
+	state, err := inventory.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	built := make(map[string]bool)
+	if generated, err := compose.LoadComposeFile(paths.DockerCompose); err == nil {
+		for _, svc := range compose.BuiltServices(generated) {
+			built[svc] = true
+		}
+	}
+
+	var images []stackImage
+	for _, name := range enabled {
+		config, err := pipeline.BuildStackConfig(name, inventoryVars)
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range config.Services {
+			if state.IsServiceDisabled(name, svc) || built[svc] {
+				continue
+			}
+			vars, _ := config.MergedVars[svc].(map[string]interface{})
+			if image, _ := vars["image"].(string); image != "" {
+				images = append(images, stackImage{Stack: name, Service: svc, Image: image})
+			}
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].Stack != images[j].Stack {
+			return images[i].Stack < images[j].Stack
+		}
+		return images[i].Service < images[j].Service
+	})
+	return images, nil
+}
+
+// registryClient returns a registry API client with the credentials of inventory/registries.yaml
+func registryClient() (*registry.Client, error) {
+	registries, err := inventory.LoadRegistries()
+	if err != nil {
+		return nil, err
+	}
+	return registry.NewClient(func(host string) (string, string, bool, error) {
+		credential, ok := registries.Credentials[host]
+		if !ok {
+			return "", "", false, nil
+		}
+		password, err := credential.Password()
+		if err != nil {
+			return "", "", false, registryCredentialError(host, err)
+		}
+		return credential.Username, password, true, nil
+	}), nil
+}
+
+// checkImages queries the registries for updates of each distinct image, with bounded
+// parallelism, and returns the images with an update or a failed check, in input order
+func checkImages(client *registry.Client, images []stackImage, parallel int) []outdatedImage {
+	distinct := make(map[string]int)
+	var refs []string
+	for _, image := range images {
+		if _, ok := distinct[image.Image]; !ok {
+			distinct[image.Image] = len(refs)
+			refs = append(refs, image.Image)
+		}
+	}
+
+	checked := make([]outdatedImage, len(refs))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, image := range refs {
+		wg.Add(1)
+		go func(i int, image string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			checked[i] = checkImage(client, image)
+		}(i, image)
+	}
+	wg.Wait()
+
+	updates := []outdatedImage{}
+	for _, image := range images {
+		result := checked[distinct[image.Image]]
+		if result.Tag == "" && result.Digest == "" && result.Error == "" {
+			continue
+		}
+		result.Stack, result.Service, result.Image = image.Stack, image.Service, image.Image
+		updates = append(updates, result)
+	}
+	return updates
+}
+
+// checkImage looks for a newer tag of an image's tag, else for a newer digest of the
+// tag than the one pinned in the reference or pulled locally
+func checkImage(client *registry.Client, image string) outdatedImage {
+	ref := registry.ParseReference(image)
+	if ref.Tag == "" {
+		return outdatedImage{} // Pinned by digest alone: nothing to compare with
+	}
+
+	tags, err := client.Tags(ref)
+	if err != nil {
+		return outdatedImage{Error: err.Error()}
+	}
+	if newer := registry.NewerTag(ref.Tag, tags); newer != "" {
+		return outdatedImage{Tag: newer}
+	}
+
+	current := ref.Digest
+	if current == "" {
+		current = imageDigest(sbom.Image{Ref: image})
+	}
+	if current == "" {
+		return outdatedImage{} // Never pulled
+	}
+	digest, err := client.Digest(ref)
+	if err != nil {
+		return outdatedImage{Error: err.Error()}
+	}
+	if digest != current {
+		return outdatedImage{Digest: digest}
+	}
+	return outdatedImage{}
+}
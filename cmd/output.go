@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats of --output (HOMELAB_OUTPUT)
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// outputFormats lists the formats --output accepts
+var outputFormats = []string{outputText, outputJSON, outputYAML}
+
+// outputFormat returns the format selected with --output, text by default
+func outputFormat() string {
+	if format := os.Getenv("HOMELAB_OUTPUT"); format != "" {
+		return format
+	}
+	return outputText
+}
+
+// machineOutput reports whether the command prints a JSON or YAML document instead of text
+func machineOutput() bool {
+	return outputFormat() != outputText
+}
+
+// writeOutput prints a result as a JSON or YAML document on stdout
+func writeOutput(result interface{}) error {
+	var data []byte
+	var err error
+	if outputFormat() == outputYAML {
+		data, err = yaml.Marshal(result)
+	} else {
+		data, err = json.MarshalIndent(result, "", "  ")
+		data = append(data, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// composeCommands are the docker compose commands passed through by name. Commands
+// homelabctl has its own version of (logs, ps, pull, stop...) are not listed
+var composeCommands = []string{
+	"attach", "build", "commit", "cp", "create", "export", "images", "kill", "ls",
+	"port", "push", "rm", "run", "scale", "start", "stats", "unpause", "up", "version",
+	"watch",
+}
+
+// maxSimilarCommands is how many close commands an unknown command suggests
+const maxSimilarCommands = 3
+
+// Passthrough runs a command homelabctl has no command of its own for: a known docker
+// compose command is passed to docker compose, anything else is rejected with the closest
+// commands, so a typo like dploy doesn't end up as a confusing compose error
+func Passthrough(command string, args []string) error {
+	for _, name := range composeCommands {
+		if name == command {
+			return Compose(command, args)
+		}
+	}
+	return errors.CommandNotFound(command, similarCommands(command))
+}
+
+// ComposeAny runs any docker compose command on the generated file, the escape hatch
+// for those not passed through by name: homelabctl compose -- <command> [args...]
+func ComposeAny(args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("usage: homelabctl compose -- <command> [args...]")
+	}
+	return Compose(args[0], args[1:])
+}
+
+// similarCommands returns the homelabctl and passed-through commands within a few edits
+// of command, closest first
+func similarCommands(command string) []string {
+	names := append([]string{}, composeCommands...)
+	for _, c := range Commands() {
+		names = append(names, c.Name)
+	}
+
+	// One edit per three letters, so short words don't match everything
+	maxDistance := len(command)/3 + 1
+	distances := make(map[string]int)
+	var similar []string
+	for _, name := range names {
+		if d := editDistance(command, name); d <= maxDistance {
+			distances[name] = d
+			similar = append(similar, name)
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if distances[similar[i]] != distances[similar[j]] {
+			return distances[similar[i]] < distances[similar[j]]
+		}
+		return similar[i] < similar[j]
+	})
+
+	if len(similar) > maxSimilarCommands {
+		similar = similar[:maxSimilarCommands]
+	}
+	return similar
+}
+
+// editDistance returns the Levenshtein distance between two words
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
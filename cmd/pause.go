@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Pause frees the resources of a stack's services without a disable and deploy: their
+// containers are frozen with docker compose pause, or stopped with --stop, and stay
+// in the compose file. Without a stack name, the arguments are passed through to
+// docker compose pause
+func Pause(args []string) error {
+	stackName, rest := splitStackArg(args)
+	if stackName == "" {
+		return Compose("pause", args)
+	}
+
+	stop := false
+	for _, arg := range rest {
+		switch arg {
+		case "--stop":
+			stop = true
+		default:
+			return fmt.Errorf("unexpected argument: %s (usage: homelabctl pause <stack> [--stop])", arg)
+		}
+	}
+
+	services, err := resolveStackServices(stackName)
+	if err != nil {
+		return err
+	}
+
+	// pause keeps the memory of the containers, stop frees it
+	subcommand := "pause"
+	if stop {
+		subcommand = "stop"
+	}
+	fmt.Printf("Pausing %d service(s) from stack %s: %s\n", len(services), stackName, strings.Join(services, ", "))
+	if err := runCompose(append([]string{subcommand}, services...)...); err != nil {
+		return fmt.Errorf("docker compose %s failed: %w", subcommand, err)
+	}
+
+	ui.Success("Stack %s paused", stackName)
+	fmt.Printf("  Resume it: homelabctl resume %s\n", stackName)
+	return nil
+}
+
+// Resume runs the services of a stack paused with pause again: paused containers are
+// unpaused, stopped ones started. Without a stack name, the arguments are passed
+// through to docker compose unpause
+func Resume(args []string) error {
+	stackName, rest := splitStackArg(args)
+	if stackName == "" {
+		return Compose("unpause", args)
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("unexpected argument: %s (usage: homelabctl resume <stack>)", rest[0])
+	}
+
+	services, err := resolveStackServices(stackName)
+	if err != nil {
+		return err
+	}
+	states, err := containerStates()
+	if err != nil {
+		return err
+	}
+
+	paused, stopped := resumableServices(services, states)
+	if len(paused) == 0 && len(stopped) == 0 {
+		fmt.Printf("Stack %s is not paused\n", stackName)
+		return nil
+	}
+
+	if len(paused) > 0 {
+		fmt.Printf("Unpausing: %s\n", strings.Join(paused, ", "))
+		if err := runCompose(append([]string{"unpause"}, paused...)...); err != nil {
+			return fmt.Errorf("docker compose unpause failed: %w", err)
+		}
+	}
+	if len(stopped) > 0 {
+		fmt.Printf("Starting: %s\n", strings.Join(stopped, ", "))
+		if err := runCompose(append([]string{"start"}, stopped...)...); err != nil {
+			return fmt.Errorf("docker compose start failed: %w", err)
+		}
+	}
+
+	ui.Success("Stack %s resumed", stackName)
+	return nil
+}
+
+// resumableServices splits the services with a paused container from those with a
+// stopped one (exited or created); services without containers are left out, deploy
+// creates them
+func resumableServices(services []string, states map[string][]containerState) ([]string, []string) {
+	var paused, stopped []string
+	for _, svc := range services {
+		isPaused, isStopped := false, false
+		for _, s := range states[svc] {
+			switch s.Status {
+			case "paused":
+				isPaused = true
+			case "exited", "created":
+				isStopped = true
+			}
+		}
+		if isPaused {
+			paused = append(paused, svc)
+		}
+		if isStopped {
+			stopped = append(stopped, svc)
+		}
+	}
+	return paused, stopped
+}
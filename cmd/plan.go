@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Changes plan reports for a service, volume or network
+const (
+	planAdded   = "added"
+	planChanged = "changed"
+	planRemoved = "removed"
+)
+
+// planChange is a difference between the deployed and the newly generated compose file
+type planChange struct {
+	Kind   string // service, volume or network
+	Name   string
+	Action string
+	Keys   []string // Changed top-level keys of the definition
+}
+
+// Plan renders the compose file generate would write into the staging dir, next to the
+// configs and env files it references, and diffs it against runtime/docker-compose.yml,
+// both as docker compose config resolves them, then compares the plan with the running
+// containers; nothing in runtime/ is written
+// Given stacks, only they are rendered again, as with generate <stack>...
+func Plan(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unexpected argument: %s (usage: homelabctl plan [stack...])", arg)
+		}
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	// Hold the lock while the pipeline uses the staging dir
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var resolvedPlan *compose.ComposeFile
+	err = stagePlan(args, func(planned *compose.ComposeFile, stagingDir string) error {
+		file := filepath.Join(stagingDir, filepath.Base(paths.DockerCompose))
+		if err := compose.WriteComposeFile(file, planned); err != nil {
+			return fmt.Errorf("failed to write the planned compose file: %w", err)
+		}
+		resolved, err := resolvedCompose(file, stagingDir)
+		resolvedPlan = resolved
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var resolvedDeployed *compose.ComposeFile
+	if _, err := os.Stat(paths.DockerCompose); err == nil {
+		if resolvedDeployed, err = resolvedCompose(paths.DockerCompose, paths.Runtime); err != nil {
+			return err
+		}
+	}
+
+	changes := diffCompose(resolvedDeployed, resolvedPlan)
+	printPlan(changes)
+
+	// Containers can differ from runtime/ (a generate without deploy, manual changes)
+	states, err := containerStates()
+	if err != nil {
+		addWarnings(fmt.Sprintf("running state not compared: %v", err))
+		return nil
+	}
+	var missing, orphaned []string
+	for svc := range resolvedPlan.Services {
+		if len(states[svc]) == 0 {
+			missing = append(missing, svc)
+		}
+	}
+	for svc := range states {
+		if _, ok := resolvedPlan.Services[svc]; !ok {
+			orphaned = append(orphaned, svc)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(orphaned)
+
+	fmt.Println("\nRunning state:")
+	if len(missing) == 0 && len(orphaned) == 0 {
+		fmt.Println("  Every planned service has containers, and every container is planned")
+	}
+	if len(missing) > 0 {
+		fmt.Printf("  %s %s\n", errors.Green(fmt.Sprintf("%d without containers:", len(missing))), strings.Join(missing, ", "))
+	}
+	if len(orphaned) > 0 {
+		fmt.Printf("  %s %s\n", errors.Red(fmt.Sprintf("%d orphaned (left as is by deploy):", len(orphaned))), strings.Join(orphaned, ", "))
+	}
+	return nil
+}
+
+// resolvedCompose returns a compose file as docker compose config resolves it (variables
+// substituted, defaults and short syntax expanded), or with the variables substituted
+// only when docker compose is not available
+// Files resolve against dir, where the files they reference are; paths under dir are
+// reported under runtime/, so a planned file compares with the deployed one
+func resolvedCompose(file, dir string) (*compose.ComposeFile, error) {
+	args := []string{"compose", "-f", file, "--project-directory", dir, "-p", composeProjectName()}
+	if _, err := os.Stat(".env"); err == nil {
+		args = append(args, "--env-file", ".env")
+	}
+	args = append(args, "config")
+
+	out, err := engine.Output(exec.Command(engine.Binary(os.Getenv("HOMELAB_ENGINE")), args...))
+	if err != nil {
+		if _, lookErr := exec.LookPath(engine.Binary(os.Getenv("HOMELAB_ENGINE"))); lookErr == nil {
+			return nil, errors.Wrap(err, "docker compose config failed for "+file,
+				"Check the generated file: homelabctl validate").WithClass(errors.ClassRender)
+		}
+		return interpolateComposeFile(file)
+	}
+
+	if dir != paths.Runtime {
+		from, fromErr := filepath.Abs(dir)
+		to, toErr := filepath.Abs(paths.Runtime)
+		if fromErr == nil && toErr == nil {
+			out = strings.ReplaceAll(out, from, to)
+		}
+	}
+
+	var resolved compose.ComposeFile
+	if err := yaml.Unmarshal([]byte(out), &resolved); err != nil {
+		return nil, fmt.Errorf("failed to parse docker compose config of %s: %w", file, err)
+	}
+	return &resolved, nil
+}
+
+// diffCompose returns the services, volumes and networks added, changed or removed from
+// deployed (nil when nothing was generated yet) to planned, sorted by kind and name
+func diffCompose(deployed, planned *compose.ComposeFile) []planChange {
+	if deployed == nil {
+		deployed = &compose.ComposeFile{}
+	}
+
+	var changes []planChange
+	for _, section := range []struct {
+		kind     string
+		old, new map[string]interface{}
+	}{
+		{"service", deployed.Services, planned.Services},
+		{"volume", deployed.Volumes, planned.Volumes},
+		{"network", deployed.Networks, planned.Networks},
+	} {
+		names := make(map[string]bool)
+		for name := range section.old {
+			names[name] = true
+		}
+		for name := range section.new {
+			names[name] = true
+		}
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, name)
+		}
+		sort.Strings(sorted)
+
+		for _, name := range sorted {
+			oldDef, inOld := section.old[name]
+			newDef, inNew := section.new[name]
+			switch {
+			case !inOld:
+				changes = append(changes, planChange{Kind: section.kind, Name: name, Action: planAdded})
+			case !inNew:
+				changes = append(changes, planChange{Kind: section.kind, Name: name, Action: planRemoved})
+			case !reflect.DeepEqual(oldDef, newDef):
+				changes = append(changes, planChange{Kind: section.kind, Name: name, Action: planChanged, Keys: changedKeys(oldDef, newDef)})
+			}
+		}
+	}
+	return changes
+}
+
+// changedKeys returns the sorted top-level keys two definitions differ in
+func changedKeys(a, b interface{}) []string {
+	mapA, okA := a.(map[string]interface{})
+	mapB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		return nil
+	}
+
+	var keys []string
+	for key, value := range mapA {
+		if !reflect.DeepEqual(value, mapB[key]) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range mapB {
+		if _, ok := mapA[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// printPlan prints the changes per kind, colored, then counts them
+func printPlan(changes []planChange) {
+	if len(changes) == 0 {
+		fmt.Println("\nNo changes: runtime/docker-compose.yml is up to date")
+		return
+	}
+
+	counts := make(map[string]int)
+	kind := ""
+	for _, c := range changes {
+		if c.Kind != kind {
+			kind = c.Kind
+			fmt.Printf("\n%s:\n", errors.Bold(strings.ToUpper(kind[:1])+kind[1:]+"s"))
+		}
+		counts[c.Action]++
+
+		line := fmt.Sprintf("%-24s %s", c.Name, c.Action)
+		switch c.Action {
+		case planAdded:
+			fmt.Println("  " + errors.Green("+ "+line))
+		case planRemoved:
+			fmt.Println("  " + errors.Red("- "+line))
+		default:
+			if len(c.Keys) > 0 {
+				line += " (" + strings.Join(c.Keys, ", ") + ")"
+			}
+			fmt.Println("  " + errors.Yellow("~ "+line))
+		}
+	}
+
+	fmt.Printf("\nPlan: %d to add, %d to change, %d to remove\n", counts[planAdded], counts[planChanged], counts[planRemoved])
+}
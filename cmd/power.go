@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/notify"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/power"
+)
+
+// defaultPowerInterval is how often power watch reads the UPS status
+const defaultPowerInterval = 10 * time.Second
+
+// Power follows the UPS of inventory/power.yaml: power status prints its state and what
+// an outage stops when, power watch stops and restarts the stacks as its state changes
+func Power(args []string) error {
+	usage := "usage: homelabctl power status | power watch [--interval <duration>]"
+	if len(args) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	switch args[0] {
+	case "status":
+		if len(args) > 1 {
+			return fmt.Errorf("unexpected argument: %s (%s)", args[1], usage)
+		}
+		return powerStatus()
+	case "watch":
+		interval := defaultPowerInterval
+		rest := args[1:]
+		for i := 0; i < len(rest); i++ {
+			name, value, hasValue := strings.Cut(rest[i], "=")
+			if name != "--interval" {
+				return fmt.Errorf("unexpected argument: %s (%s)", rest[i], usage)
+			}
+			if !hasValue {
+				if i+1 >= len(rest) {
+					return fmt.Errorf("--interval requires a value (%s)", usage)
+				}
+				value = rest[i+1]
+				i++
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < time.Second {
+				return fmt.Errorf("invalid --interval value: %s (e.g. 10s, 1m)", value)
+			}
+			interval = d
+		}
+		return powerWatch(interval)
+	default:
+		return fmt.Errorf("unknown power subcommand: %s (available: status, watch)", args[0])
+	}
+}
+
+// loadPowerConfig loads inventory/power.yaml, which must configure a UPS source
+func loadPowerConfig() (*power.Config, error) {
+	if err := fs.VerifyRepository(); err != nil {
+		return nil, err
+	}
+
+	config, err := power.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !config.Enabled() {
+		return nil, errors.New(
+			"no UPS configured",
+			fmt.Sprintf("Create %s with a source (%s)", paths.InventoryPower, strings.Join(power.Sources, " or ")),
+		).WithClass(errors.ClassNotFound)
+	}
+	return config, nil
+}
+
+// powerStatus prints the UPS state and the grace period of each category, in stop order
+func powerStatus() error {
+	config, err := loadPowerConfig()
+	if err != nil {
+		return err
+	}
+
+	status, err := config.ReadStatus()
+	if err != nil {
+		return errors.Wrap(err, "failed to read the UPS status",
+			fmt.Sprintf("Check that the %s daemon runs and its client is installed", config.Source)).WithClass(errors.ClassDependency)
+	}
+	state := "online"
+	if status.OnBattery {
+		state = "on battery"
+	}
+	if status.LowBattery {
+		state += ", battery low"
+	}
+	fmt.Printf("UPS: %s (%s)\n", state, status.Raw)
+
+	waves, err := powerWaves()
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nOn battery, stops:")
+	for _, wave := range waves {
+		after := "when the battery is low"
+		if grace, ok := config.GraceOf(wave.Category); ok {
+			after = "after " + grace.String()
+		}
+		fmt.Printf("  %-16s %-24s %s\n", wave.Category, after, strings.Join(wave.Services, ", "))
+	}
+	return nil
+}
+
+// powerWaves returns the deployed services by category, last category first
+func powerWaves() ([]deployWave, error) {
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return nil, err
+	}
+
+	waves := categoryWaves(generated)
+	for i, j := 0, len(waves)-1; i < j; i, j = i+1, j-1 {
+		waves[i], waves[j] = waves[j], waves[i]
+	}
+	return waves, nil
+}
+
+// powerWatch reads the UPS status at an interval until interrupted
+// On battery, each category's services stop once its grace period is over (all of them
+// when the battery is low); when power is back, the stopped ones start again in waves
+func powerWatch(interval time.Duration) error {
+	config, err := loadPowerConfig()
+	if err != nil {
+		return err
+	}
+
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+
+	notifications, err := notify.LoadConfig()
+	if err != nil {
+		return err
+	}
+	warn := func(err error) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	notice := func(severity, title, message string) {
+		n := notify.Notification{Time: time.Now(), Severity: severity, Title: title, Message: message, Event: "power"}
+		if err := sendNotification(notifications, n); err != nil {
+			warn(err)
+		}
+	}
+
+	fmt.Printf("Watching the UPS (%s) every %s (Ctrl+C to stop)\n", config.Source, interval)
+
+	var onBatterySince time.Time
+	stopped := make(map[string]bool) // Categories stopped during this outage
+	var stoppedServices []string
+
+	for ; ; time.Sleep(interval) {
+		status, err := config.ReadStatus()
+		if err != nil {
+			warn(err)
+			continue
+		}
+		now := time.Now()
+
+		if !status.OnBattery {
+			if onBatterySince.IsZero() {
+				continue
+			}
+			message := fmt.Sprintf("Power restored after %s", now.Sub(onBatterySince).Round(time.Second))
+			fmt.Printf("%s %s\n", now.Format("2006-01-02 15:04:05"), message)
+			onBatterySince = time.Time{}
+
+			if len(stoppedServices) > 0 {
+				message += fmt.Sprintf(", restarting %d service(s)", len(stoppedServices))
+				notice(notify.SeverityInfo, "UPS back online", message)
+				if err := deployInWaves(e, stoppedServices); err != nil {
+					warn(err)
+					notice(notify.SeverityCritical, "Restart after power outage failed", err.Error())
+				}
+			} else {
+				notice(notify.SeverityInfo, "UPS back online", message)
+			}
+			stopped = make(map[string]bool)
+			stoppedServices = nil
+			continue
+		}
+
+		if onBatterySince.IsZero() {
+			onBatterySince = now
+			fmt.Printf("%s UPS on battery (%s)\n", now.Format("2006-01-02 15:04:05"), status.Raw)
+			notice(notify.SeverityWarning, "UPS on battery", "Stacks stop as their category's grace period ends")
+		}
+
+		waves, err := powerWaves()
+		if err != nil {
+			warn(err)
+			continue
+		}
+		for _, wave := range waves {
+			if stopped[wave.Category] || !config.Due(wave.Category, now.Sub(onBatterySince), status.LowBattery) {
+				continue
+			}
+
+			fmt.Printf("%s Stopping %s: %s\n", now.Format("2006-01-02 15:04:05"), wave.Category, strings.Join(wave.Services, ", "))
+			if err := e.Compose(append([]string{"stop"}, wave.Services...)...); err != nil {
+				warn(fmt.Errorf("failed to stop %s: %w", wave.Category, err))
+				continue
+			}
+			stopped[wave.Category] = true
+			stoppedServices = append(stoppedServices, wave.Services...)
+			notice(notify.SeverityWarning, "Stacks stopped on battery",
+				fmt.Sprintf("Stopped %s after %s on battery", wave.Category, now.Sub(onBatterySince).Round(time.Second)))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/history"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Prune removes resources that are no longer needed by enabled stacks
+func Prune(args []string) error {
+	pruneImagesFlag := false
+	dryRun := false
+
+	for _, arg := range args {
+		switch arg {
+		case "--images":
+			pruneImagesFlag = true
+		case "--dry-run":
+			dryRun = true
+		default:
+			return fmt.Errorf("unexpected argument: %s", arg)
+		}
+	}
+
+	if !pruneImagesFlag {
+		return fmt.Errorf("usage: homelabctl prune --images [--dry-run]")
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	return pruneImages(dryRun)
+}
+
+// pruneImages removes images referenced only by stacks that are disabled or deleted
+// Candidates come from the generation history and from provenance labels on containers
+func pruneImages(dryRun bool) error {
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first so images in use are known")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	inUse := make(map[string]bool)
+	for _, image := range compose.ServiceImages(generated, nil) {
+		inUse[compose.NormalizeImage(image)] = true
+	}
+
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return err
+	}
+	enabledMap := stacks.EnabledStacksMap(enabled)
+
+	available, err := fs.GetAvailableStacks()
+	if err != nil {
+		return err
+	}
+	availableMap := stacks.EnabledStacksMap(available)
+
+	// image -> stacks that referenced it
+	candidates := make(map[string]map[string]bool)
+	addCandidate := func(stackName, image string) {
+		if enabledMap[stackName] || image == "" {
+			return
+		}
+		image = compose.NormalizeImage(image)
+		if inUse[image] {
+			return
+		}
+		if candidates[image] == nil {
+			candidates[image] = make(map[string]bool)
+		}
+		candidates[image][stackName] = true
+	}
+
+	snapshots, err := history.List()
+	if err != nil {
+		return err
+	}
+	for stackName, images := range history.ImagesByStack(snapshots) {
+		for image := range images {
+			addCandidate(stackName, image)
+		}
+	}
+
+	// Containers labelled by previous generations (e.g. left over after disabling a stack)
+	out, err := dockerOutput("ps", "-a", "--filter", "label="+compose.LabelStack,
+		"--format", "{{.Label \""+compose.LabelStack+"\"}}|{{.Image}}")
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "|", 2)
+		if len(parts) == 2 {
+			addCandidate(parts[0], parts[1])
+		}
+	}
+
+	images := make([]string, 0, len(candidates))
+	for image := range candidates {
+		// Only consider images that are still present locally
+		if _, err := dockerOutput("image", "inspect", "--format", "{{.Id}}", image); err != nil {
+			continue
+		}
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	if len(images) == 0 {
+		ui.Success("No images of disabled or deleted stacks found")
+		return nil
+	}
+
+	fmt.Printf("Images referenced only by disabled or deleted stacks (%d):\n", len(images))
+	for _, image := range images {
+		var reasons []string
+		for stackName := range candidates[image] {
+			state := "disabled"
+			if !availableMap[stackName] {
+				state = "deleted"
+			}
+			reasons = append(reasons, fmt.Sprintf("%s: %s", state, stackName))
+		}
+		sort.Strings(reasons)
+		fmt.Printf("  • %s (%s)\n", image, strings.Join(reasons, ", "))
+	}
+
+	if dryRun {
+		fmt.Println("\nDry run: no images removed")
+		return nil
+	}
+
+	fmt.Println()
+	removed := 0
+	for _, image := range images {
+		// No --force: images still used by a container are kept
+		if _, err := dockerOutput("image", "rm", image); err != nil {
+			fmt.Printf("  ⨯ Skipped %s: %v\n", image, err)
+			continue
+		}
+		ui.Detail("✓ Removed %s", image)
+		removed++
+	}
+
+	ui.Blank()
+	ui.Success("Removed %d of %d image(s)", removed, len(images))
+	return nil
+}
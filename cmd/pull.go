@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// defaultPullParallelism is the number of images pulled at the same time
+const defaultPullParallelism = 4
+
+// pullResult records the outcome of pulling one image
+type pullResult struct {
+	Image    string
+	Services []string
+	OldID    string
+	NewID    string
+	Err      error
+}
+
+// pullValueFlags are the docker compose pull flags that take a value
+var pullValueFlags = map[string]bool{"--policy": true}
+
+// Pull pulls the images of enabled services in parallel, or of the services and stacks
+// named. Other docker compose pull flags (--ignore-pull-failures, -q...) hand the pull
+// over to docker compose pull, for the same services.
+// Disabled services are never pulled because they are absent from the generated compose file
+func Pull(args []string) error {
+	usage := "usage: homelabctl pull [stack|service...] [--parallel <n>] [docker compose pull flags]"
+	var names, composeFlags []string
+	parallel := defaultPullParallelism
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--parallel" || arg == "-j":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value (%s)", arg, usage)
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid --parallel value: %s", args[i])
+			}
+			parallel = n
+		case pullValueFlags[arg]:
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value (%s)", arg, usage)
+			}
+			composeFlags = append(composeFlags, arg, args[i+1])
+			i++
+		case strings.HasPrefix(arg, "-"):
+			composeFlags = append(composeFlags, arg)
+		default:
+			names = append(names, arg)
+		}
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	services, err := pullServices(generated, names)
+	if err != nil {
+		return err
+	}
+	if len(composeFlags) > 0 {
+		return Compose("pull", append(composeFlags, services...))
+	}
+
+	// Group services by image so shared images are pulled once
+	// Images built from source are local tags with nothing to pull
+	built := skipBuiltServices(generated)
+	imageServices := make(map[string][]string)
+	for svc, image := range compose.ServiceImages(generated, services) {
+		if !built[svc] {
+			imageServices[image] = append(imageServices[image], svc)
+		}
+	}
+
+	if len(imageServices) == 0 {
+		ui.Step("No images to pull")
+		return nil
+	}
+
+	images := make([]string, 0, len(imageServices))
+	for image := range imageServices {
+		images = append(images, image)
+		sort.Strings(imageServices[image])
+	}
+	sort.Strings(images)
+
+	if err := registryLogin(images); err != nil {
+		return err
+	}
+
+	ui.Step("Pulling %d image(s) (%d in parallel)...", len(images), parallel)
+
+	results := pullImages(images, parallel)
+	for i := range results {
+		results[i].Services = imageServices[results[i].Image]
+	}
+
+	return printPullSummary(results)
+}
+
+// pullServices resolves the stacks and services named on the command line to services
+// of the generated compose file; none named is every service (nil)
+func pullServices(generated *compose.ComposeFile, names []string) ([]string, error) {
+	var services []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		var resolved []string
+		switch {
+		case fs.StackExists(name):
+			stackServices, err := resolveStackServices(name)
+			if err != nil {
+				return nil, err
+			}
+			resolved = stackServices
+		case generated.Services[name] != nil:
+			resolved = []string{name}
+		default:
+			return nil, errors.New(
+				fmt.Sprintf("'%s' is neither a stack nor a service of %s", name, paths.DockerCompose),
+				"Run: homelabctl list",
+				"Disabled services are left out of the generated compose file",
+			).WithClass(errors.ClassNotFound)
+		}
+		for _, svc := range resolved {
+			if !seen[svc] {
+				seen[svc] = true
+				services = append(services, svc)
+			}
+		}
+	}
+	return services, nil
+}
+
+// skipBuiltServices returns the services built from source, printing that they are skipped
+func skipBuiltServices(generated *compose.ComposeFile) map[string]bool {
+	built := make(map[string]bool)
+	services := compose.BuiltServices(generated)
+	for _, svc := range services {
+		built[svc] = true
+	}
+	if len(services) > 0 {
+		ui.Step("Skipping %d service(s) built from source: %s", len(services), strings.Join(services, ", "))
+	}
+	return built
+}
+
+// pullImages pulls images with bounded parallelism, preserving input order in the results
+func pullImages(images []string, parallel int) []pullResult {
+	results := make([]pullResult, len(images))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, image := range images {
+		wg.Add(1)
+		go func(i int, image string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := pullResult{Image: image}
+			// Missing local images simply have no previous ID
+			result.OldID, _ = dockerOutput("image", "inspect", "--format", "{{.Id}}", image)
+
+			if _, err := dockerOutput("pull", "--quiet", image); err != nil {
+				result.Err = err
+			} else {
+				result.NewID, _ = dockerOutput("image", "inspect", "--format", "{{.Id}}", image)
+				ui.Detail("✓ %s", image)
+			}
+
+			results[i] = result
+		}(i, image)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// printPullSummary reports updated, unchanged and failed images
+func printPullSummary(results []pullResult) error {
+	var updated, unchanged, failed []pullResult
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			failed = append(failed, r)
+		case r.OldID == r.NewID:
+			unchanged = append(unchanged, r)
+		default:
+			updated = append(updated, r)
+		}
+	}
+
+	ui.Blank()
+	if len(updated) > 0 {
+		ui.Step("Updated (%d):", len(updated))
+		for _, r := range updated {
+			old := shortImageID(r.OldID)
+			if old == "" {
+				old = "(new)"
+			}
+			ui.Detail("• %s: %s → %s [%s]", r.Image, old, shortImageID(r.NewID), strings.Join(r.Services, ", "))
+		}
+	}
+
+	if len(failed) > 0 {
+		fmt.Printf("Failed (%d):\n", len(failed))
+		for _, r := range failed {
+			fmt.Printf("  ⨯ %s: %v\n", r.Image, r.Err)
+		}
+	}
+
+	ui.Step("Summary: %d updated, %d unchanged, %d failed", len(updated), len(unchanged), len(failed))
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to pull %d image(s)", len(failed))
+	}
+
+	if len(updated) > 0 {
+		ui.Hint("Run 'homelabctl deploy' to recreate containers with updated images")
+	}
+
+	return nil
+}
+
+// shortImageID shortens a sha256 image ID for display
+func shortImageID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
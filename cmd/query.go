@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/query"
+)
+
+// queryResult is one matching service in query output
+type queryResult struct {
+	Service  string `json:"service"`
+	Stack    string `json:"stack"`
+	Category string `json:"category"`
+	Image    string `json:"image"`
+}
+
+// Query lists the generated services matching an expression
+func Query(args []string) error {
+	usage := "usage: homelabctl query '<expression>' [--format table|json]"
+	format := "table"
+	var expression string
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--format":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--format requires a value (%s)", usage)
+			}
+			format = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case expression == "":
+			expression = arg
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if expression == "" {
+		return fmt.Errorf("%s", usage)
+	}
+	if format != "table" && format != "json" {
+		return fmt.Errorf("invalid --format value: %s (available: table, json)", format)
+	}
+
+	expr, err := query.Parse(expression)
+	if err != nil {
+		return errors.Wrap(err, "invalid query",
+			`Example: homelabctl query 'labels["traefik.enable"] == "true"'`,
+			`Example: homelabctl query 'ports && stack != "proxy"'`,
+		).WithClass(errors.ClassUsage)
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	results := queryServices(generated, expr)
+
+	if format == "json" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal query results: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(results) == 0 {
+		fmt.Println("No matching services")
+		return nil
+	}
+
+	fmt.Printf("%-24s %-16s %-16s %s\n", "SERVICE", "STACK", "CATEGORY", "IMAGE")
+	for _, r := range results {
+		fmt.Printf("%-24s %-16s %-16s %s\n", r.Service, r.Stack, r.Category, r.Image)
+	}
+	fmt.Printf("\n%d service(s) matched\n", len(results))
+
+	return nil
+}
+
+// queryServices returns the services matching expr, sorted by name
+func queryServices(generated *compose.ComposeFile, expr *query.Expr) []queryResult {
+	results := []queryResult{}
+
+	for name := range generated.Services {
+		record := serviceRecord(generated, name)
+		if !expr.Match(record) {
+			continue
+		}
+
+		image, _ := record["image"].(string)
+		results = append(results, queryResult{
+			Service:  name,
+			Stack:    record["stack"].(string),
+			Category: record["category"].(string),
+			Image:    image,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Service < results[j].Service })
+	return results
+}
+
+// serviceRecord builds the fields a query sees for a service: its compose keys, plus
+// name, stack and category, with labels and environment always in map form
+func serviceRecord(generated *compose.ComposeFile, name string) map[string]interface{} {
+	record := make(map[string]interface{})
+	if svcMap, ok := generated.Services[name].(map[string]interface{}); ok {
+		for key, value := range svcMap {
+			record[key] = value
+		}
+	}
+
+	labels := compose.ServiceLabels(generated, name)
+	labelMap := make(map[string]interface{}, len(labels))
+	for key, value := range labels {
+		labelMap[key] = value
+	}
+	record["labels"] = labelMap
+
+	if env, ok := record["environment"].([]interface{}); ok {
+		envMap := make(map[string]interface{}, len(env))
+		for _, entry := range env {
+			if s, ok := entry.(string); ok {
+				parts := strings.SplitN(s, "=", 2)
+				if len(parts) == 2 {
+					envMap[parts[0]] = parts[1]
+				} else {
+					envMap[parts[0]] = ""
+				}
+			}
+		}
+		record["environment"] = envMap
+	}
+
+	record["name"] = name
+	record["stack"] = labels[compose.LabelStack]
+	record["category"] = labels[compose.LabelCategory]
+
+	return record
+}
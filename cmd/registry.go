@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/docker"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// registryLogin logs the engine's CLI in to the registries of inventory/registries.yaml
+// that the given images (all generated images when empty) are pulled from
+// The docker-api engine sends the credentials with each pull instead
+func registryLogin(images []string) error {
+	if os.Getenv("HOMELAB_ENGINE") == engine.KindDockerAPI {
+		return nil
+	}
+
+	registries, err := inventory.LoadRegistries()
+	if err != nil || len(registries.Credentials) == 0 {
+		return err
+	}
+
+	if len(images) == 0 {
+		generated, err := compose.LoadComposeFile(paths.DockerCompose)
+		if err != nil {
+			return err
+		}
+		for _, image := range compose.ServiceImages(generated, nil) {
+			images = append(images, image)
+		}
+	}
+
+	logins := make(map[string]inventory.RegistryCredential)
+	for _, image := range images {
+		if registry, credential, ok := registries.Credential(image); ok {
+			logins[registry] = credential
+		}
+	}
+
+	names := make([]string, 0, len(logins))
+	for registry := range logins {
+		names = append(names, registry)
+	}
+	sort.Strings(names)
+
+	for _, registry := range names {
+		credential := logins[registry]
+		password, err := credential.Password()
+		if err != nil {
+			return registryCredentialError(registry, err)
+		}
+
+		cmd, err := dockerCommand(false, "login", registry, "--username", credential.Username, "--password-stdin")
+		if err != nil {
+			return err
+		}
+		cmd.Stdin = strings.NewReader(password)
+		if _, err := engine.Output(cmd); err != nil {
+			return errors.Wrap(err,
+				fmt.Sprintf("failed to log in to %s", registry),
+				fmt.Sprintf("Check the credentials for %s in %s", registry, paths.InventoryRegistry),
+			).WithClass(errors.ClassDocker)
+		}
+		fmt.Printf("Logged in to %s as %s\n", registry, credential.Username)
+	}
+
+	return nil
+}
+
+// registryAuths returns the accounts the docker-api engine pulls with, by registry host
+// A password that can't be read is a warning: most commands never pull
+func registryAuths() (map[string]docker.AuthConfig, error) {
+	registries, err := inventory.LoadRegistries()
+	if err != nil {
+		return nil, err
+	}
+
+	auths := make(map[string]docker.AuthConfig)
+	for registry, credential := range registries.Credentials {
+		password, err := credential.Password()
+		if err != nil {
+			addWarnings(fmt.Sprintf("no password for registry %s, pulling anonymously: %v", registry, err))
+			continue
+		}
+		auths[registry] = docker.AuthConfig{Username: credential.Username, Password: password, ServerAddress: registry}
+	}
+	return auths, nil
+}
+
+// registryCredentialError reports a registry password that could not be read
+func registryCredentialError(registry string, err error) error {
+	return errors.Wrap(err,
+		fmt.Sprintf("no password for registry %s", registry),
+		fmt.Sprintf("Set the password_env variable or password_file of %s in %s", registry, paths.InventoryRegistry),
+	).WithClass(errors.ClassValidation)
+}
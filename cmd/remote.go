@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/remote"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// selectedHost returns the remote host chosen with --host, or nil to run locally
+func selectedHost() (*remote.Host, error) {
+	name := os.Getenv("HOMELAB_HOST")
+	if name == "" {
+		return nil, nil
+	}
+	return remote.LoadHost(name)
+}
+
+// dockerCommand builds a command of the selected engine's CLI (docker or podman),
+// run over SSH when a remote host is selected
+func dockerCommand(interactive bool, args ...string) (*exec.Cmd, error) {
+	return hostCommand(interactive, engine.Binary(os.Getenv("HOMELAB_ENGINE")), args...)
+}
+
+// hostCommand builds a command, run over SSH when a remote host is selected
+// interactive allocates a remote terminal when stdin is one
+func hostCommand(interactive bool, name string, args ...string) (*exec.Cmd, error) {
+	host, err := selectedHost()
+	if err != nil {
+		return nil, err
+	}
+	if host == nil {
+		return exec.Command(name, args...), nil
+	}
+
+	tty := false
+	if interactive {
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			tty = true
+		}
+	}
+
+	return host.Command(tty, name, args...), nil
+}
+
+// syncRuntime copies runtime/ to the remote host selected with --host, if any
+func syncRuntime() error {
+	host, err := selectedHost()
+	if err != nil || host == nil {
+		return err
+	}
+
+	fmt.Printf("Syncing runtime/ to %s (%s:%s)...\n", host.Name, host.SSH, host.Path)
+	if err := host.Sync(); err != nil {
+		return err
+	}
+	ui.Success("Synced runtime/ to %s", host.Name)
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/notify"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/uptime"
+)
+
+// defaultRecordInterval is how often report record polls the containers
+const defaultRecordInterval = time.Minute
+
+// recordedEvents are the container events report record keeps
+var recordedEvents = map[string]bool{"start": true, "die": true, "healthy": true, "unhealthy": true}
+
+// Report prints per-service uptime and restart counts over a period, from the
+// records of report record, as markdown or JSON
+func Report(args []string) error {
+	if len(args) > 0 && args[0] == "record" {
+		return reportRecord(args[1:])
+	}
+
+	usage := "usage: homelabctl report [stack|service...] [--since <time>] [--until <time>] [--format markdown|json] | report record [--interval <duration>]"
+	now := time.Now()
+	since, until := now.AddDate(0, 0, -7), now
+	format := "markdown"
+	var targets []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		switch name {
+		case "--since", "--until", "--format":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("%s requires a value (%s)", name, usage)
+				}
+				value = args[i+1]
+				i++
+			}
+			if name == "--format" {
+				format = value
+				continue
+			}
+			ts, err := parseLogTime(value, now)
+			if err != nil {
+				return err
+			}
+			unix, _ := strconv.ParseInt(ts, 10, 64)
+			if name == "--since" {
+				since = time.Unix(unix, 0)
+			} else {
+				until = time.Unix(unix, 0)
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+			}
+			targets = append(targets, arg)
+		}
+	}
+
+	if format != "markdown" && format != "json" {
+		return fmt.Errorf("invalid --format value: %s (available: markdown, json)", format)
+	}
+	if !since.Before(until) {
+		return fmt.Errorf("--since must be before --until")
+	}
+
+	records, err := uptime.Load(since, until)
+	if err != nil {
+		return err
+	}
+
+	var selected []uptime.Record
+	for _, r := range records {
+		if len(targets) == 0 || selectedRecord(r, targets) {
+			selected = append(selected, r)
+		}
+	}
+
+	report := uptime.Compute(selected, since, until)
+	if report.Polls == 0 {
+		return errors.New(
+			fmt.Sprintf("no uptime records between %s and %s", since.Format("2006-01-02 15:04"), until.Format("2006-01-02 15:04")),
+			"Run the recorder on the Docker host: homelabctl report record",
+			"Keep it running, e.g. as a systemd service",
+		).WithClass(errors.ClassNotFound)
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Print(report.Markdown())
+	return nil
+}
+
+// selectedRecord reports whether a record belongs to one of the targets
+func selectedRecord(r uptime.Record, targets []string) bool {
+	for _, target := range targets {
+		if targetMatches(target, r.Stack, r.Service) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportRecord polls the deployment's containers at an interval and records the
+// container events in between to runtime/uptime/, until interrupted
+// Crashes and healthcheck changes are also sent through the notification routes
+func reportRecord(args []string) error {
+	usage := "usage: homelabctl report record [--interval <duration>]"
+	interval := defaultRecordInterval
+
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "--interval" {
+			return fmt.Errorf("unexpected argument: %s (%s)", args[i], usage)
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return fmt.Errorf("--interval requires a value (%s)", usage)
+			}
+			value = args[i+1]
+			i++
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("invalid --interval value: %s (e.g. 30s, 1m)", value)
+		}
+		interval = d
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+
+	notifications, err := notify.LoadConfig()
+	if err != nil {
+		return err
+	}
+	unhealthy := make(map[string]bool)
+
+	stream, err := e.Events(context.Background(), "")
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	events := make(chan containerEvent)
+	streamErr := make(chan error, 1)
+	go func() {
+		for {
+			raw, err := stream.Next()
+			if err != nil {
+				streamErr <- err
+				return
+			}
+			events <- annotateEvent(raw)
+		}
+	}()
+
+	fmt.Printf("Recording uptime to %s every %s (Ctrl+C to stop)\n", paths.UptimeDir, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// A failed poll is skipped: the daemon may be restarting
+		if err := uptime.Append(pollRecords(e, time.Now())); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+
+	wait:
+		for {
+			select {
+			case <-ticker.C:
+				break wait
+			case event := <-events:
+				if event.Stack == "" || !recordedEvents[event.Event] {
+					continue
+				}
+				record := uptime.Record{Time: time.Now().UTC(), Kind: uptime.KindEvent, Service: event.Service, Stack: event.Stack, Event: event.Event}
+				if t, err := time.Parse(time.RFC3339, event.Time); err == nil {
+					record.Time = t.UTC()
+				}
+				if err := uptime.Append([]uptime.Record{record}); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+				if n, ok := eventNotification(event, unhealthy); ok {
+					n.Time = record.Time
+					if err := sendNotification(notifications, n); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+					}
+				}
+			case err := <-streamErr:
+				return fmt.Errorf("docker event stream failed: %w", err)
+			}
+		}
+	}
+}
+
+// eventNotification turns a container event into a notification: a crash is critical,
+// a failing healthcheck a warning, and a recovery from it info
+// unhealthy tracks the services whose healthcheck failed, keyed by container
+func eventNotification(event containerEvent, unhealthy map[string]bool) (notify.Notification, bool) {
+	n := notify.Notification{
+		Stack:    event.Stack,
+		Category: event.Category,
+		Service:  event.Service,
+		Event:    event.Event,
+	}
+
+	switch event.Event {
+	case "die":
+		if event.ExitCode == "" || event.ExitCode == "0" {
+			return n, false
+		}
+		n.Severity = notify.SeverityCritical
+		n.Title = fmt.Sprintf("%s crashed", event.Service)
+		n.Message = fmt.Sprintf("%s (%s) exited with code %s", event.Container, event.Stack, event.ExitCode)
+	case "unhealthy":
+		unhealthy[event.Container] = true
+		n.Severity = notify.SeverityWarning
+		n.Title = fmt.Sprintf("%s is unhealthy", event.Service)
+		n.Message = fmt.Sprintf("The healthcheck of %s (%s) is failing", event.Container, event.Stack)
+	case "healthy":
+		if !unhealthy[event.Container] {
+			return n, false
+		}
+		delete(unhealthy, event.Container)
+		n.Severity = notify.SeverityInfo
+		n.Event = "recovered"
+		n.Title = fmt.Sprintf("%s recovered", event.Service)
+		n.Message = fmt.Sprintf("The healthcheck of %s (%s) passes again", event.Container, event.Stack)
+	default:
+		return n, false
+	}
+	return n, true
+}
+
+// pollRecords samples the state of the deployment's containers
+func pollRecords(e engine.Engine, now time.Time) []uptime.Record {
+	containers, err := e.Ps()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to poll containers: %v\n", err)
+		return nil
+	}
+
+	now = now.UTC().Truncate(time.Second)
+	var records []uptime.Record
+	for _, c := range containers {
+		stack := c.Labels[compose.LabelStack]
+		if stack == "" {
+			continue
+		}
+
+		state := c.Status
+		if c.Health != "" {
+			state += "/" + c.Health
+		}
+		records = append(records, uptime.Record{
+			Time:    now,
+			Kind:    uptime.KindPoll,
+			Service: c.Service,
+			Stack:   stack,
+			Up:      c.Status == "running" && c.Health != "unhealthy",
+			State:   state,
+		})
+	}
+	return records
+}
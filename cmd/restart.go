@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Restart restarts services
+// --stack limits the restart to one stack's services; --ordered restarts services
+// one at a time in dependency order (requires, depends_on, network_mode: service:)
+// Without either flag the arguments are passed through to docker compose restart
+func Restart(args []string) error {
+	var stackName string
+	ordered := false
+	var passthrough []string
+
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--stack":
+			if i+1 >= len(args) {
+				return fmt.Errorf("usage: homelabctl restart --stack <stack> [--ordered]")
+			}
+			i++
+			stackName = args[i]
+		case "--ordered":
+			ordered = true
+		default:
+			passthrough = append(passthrough, args[i])
+		}
+	}
+
+	if stackName == "" && !ordered {
+		return Compose("restart", args)
+	}
+
+	if len(passthrough) > 0 {
+		return fmt.Errorf("unexpected argument: %s (usage: homelabctl restart [--stack <stack>] [--ordered])", passthrough[0])
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	var services []string
+	var err error
+	if stackName != "" {
+		services, err = resolveStackServices(stackName)
+	} else {
+		services, err = restartOrderForEnabledStacks()
+	}
+	if err != nil {
+		return err
+	}
+
+	if !ordered {
+		fmt.Printf("Restarting stack %s: %s\n", stackName, strings.Join(services, ", "))
+		if err := runCompose(append([]string{"restart"}, services...)...); err != nil {
+			return fmt.Errorf("docker compose restart failed: %w", err)
+		}
+		ui.Success("Stack %s restarted", stackName)
+		return nil
+	}
+
+	order, err := dependencyOrder(services)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restarting %d service(s) in dependency order:\n", len(order))
+	for i, svc := range order {
+		fmt.Printf("  [%d/%d] %s\n", i+1, len(order), svc)
+		if err := runCompose("restart", svc); err != nil {
+			return fmt.Errorf("docker compose restart %s failed: %w", svc, err)
+		}
+	}
+
+	ui.Blank()
+	ui.Success("Restart complete")
+	return nil
+}
+
+// restartOrderForEnabledStacks lists the generated services of all enabled stacks,
+// grouped by stack in dependency order
+func restartOrderForEnabledStacks() ([]string, error) {
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return nil, err
+	}
+
+	sorted, err := stacks.SortByDependencies(enabled)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for _, name := range sorted {
+		stackServices, err := resolveStackServices(name)
+		if err != nil {
+			// Stacks whose services are all disabled have nothing to restart
+			continue
+		}
+		services = append(services, stackServices...)
+	}
+
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no services found in %s - run 'generate' first", paths.DockerCompose)
+	}
+
+	return services, nil
+}
+
+// dependencyOrder orders services using the generated compose file and stack requires
+// Services of a stack come after every service of the stacks it requires
+func dependencyOrder(services []string) ([]string, error) {
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := compose.ServiceDependencies(generated)
+
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return nil, err
+	}
+
+	serviceStacks, err := stacks.GetAllServicesFromStacks(enabled)
+	if err != nil {
+		return nil, err
+	}
+
+	stackServices := make(map[string][]string)
+	for svc, stackName := range serviceStacks {
+		stackServices[stackName] = append(stackServices[stackName], svc)
+	}
+
+	for _, svc := range services {
+		stack, err := stacks.LoadStack(serviceStacks[svc])
+		if err != nil {
+			continue
+		}
+		for _, required := range stack.Requires {
+			deps[svc] = append(deps[svc], stackServices[required]...)
+		}
+	}
+
+	return compose.OrderServices(services, deps)
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/history"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Rollback redeploys the compose file and configs an earlier generate archived in
+// runtime/history/: the generation before the latest, or the one --to names
+// --list shows the generations that can be restored
+func Rollback(args []string) error {
+	usage := "usage: homelabctl rollback [--to <timestamp>] [--list]"
+	list := false
+	to := ""
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--list":
+			list = true
+		case arg == "--to":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--to requires a value (%s)", usage)
+			}
+			to = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--to="):
+			to = strings.TrimPrefix(arg, "--to=")
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	generations, err := restorableGenerations()
+	if err != nil {
+		return err
+	}
+
+	if list {
+		if len(generations) == 0 {
+			fmt.Println("No generation can be restored yet: generate archives its outputs in runtime/history/")
+			return nil
+		}
+		currentID := currentGeneration(generations)
+		for _, g := range generations {
+			current := ""
+			if g.ID == currentID {
+				current = "  (current)"
+			}
+			fmt.Printf("%s  %s  %d stack(s)%s\n", g.ID, g.Time.Local().Format("2006-01-02 15:04:05"), len(g.Stacks), current)
+		}
+		return nil
+	}
+
+	target, err := rollbackTarget(generations, to)
+	if err != nil {
+		return err
+	}
+
+	// Hold the lock across the restore and docker compose
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	fmt.Printf("Rolling back to generation %s (%s)...\n", target.ID, target.Time.Local().Format("2006-01-02 15:04:05"))
+	files, err := restoreGeneration(target.ID)
+	if err != nil {
+		return err
+	}
+	ui.Success("Restored %d file(s) into %s", len(files), paths.Runtime)
+
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nDeploying with docker compose...")
+	if err := e.Up(nil, engine.UpOptions{}); err != nil {
+		return fmt.Errorf("docker compose failed: %w", err)
+	}
+
+	if err := history.SetCurrent(target.ID); err != nil {
+		addWarnings(err.Error())
+	}
+	ui.Blank()
+	ui.Success("Rolled back to %s", target.ID)
+	if err := inventory.MarkDeployed(); err != nil {
+		addWarnings(fmt.Sprintf("failed to record the deploy time: %v", err))
+	}
+	fmt.Println("  The next generate renders the stacks again: revert their changes to keep this version")
+
+	return verifyDeployHealth(nil)
+}
+
+// restorableGenerations returns the generations of runtime/history/ with archived
+// outputs, oldest first
+func restorableGenerations() ([]*history.Snapshot, error) {
+	snapshots, err := history.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var restorable []*history.Snapshot
+	for _, snapshot := range snapshots {
+		files, err := history.Outputs(snapshot.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			restorable = append(restorable, snapshot)
+		}
+	}
+	return restorable, nil
+}
+
+// currentGeneration returns the ID of the generation runtime/ holds: the one recorded by
+// the last generate or rollback, else the latest
+func currentGeneration(generations []*history.Snapshot) string {
+	if len(generations) == 0 {
+		return ""
+	}
+	current := history.Current()
+	for _, g := range generations {
+		if g.ID == current {
+			return current
+		}
+	}
+	return generations[len(generations)-1].ID
+}
+
+// rollbackTarget picks the generation to restore: the one named, or the one before the
+// current one, so repeated rollbacks go further back
+func rollbackTarget(generations []*history.Snapshot, to string) (*history.Snapshot, error) {
+	if to != "" {
+		for _, g := range generations {
+			if g.ID == to {
+				return g, nil
+			}
+		}
+		return nil, errors.New(
+			fmt.Sprintf("no restorable generation %s in %s", to, paths.HistoryDir),
+			"List them: homelabctl rollback --list",
+		).WithClass(errors.ClassValidation)
+	}
+
+	current := currentGeneration(generations)
+	for i, g := range generations {
+		if g.ID == current && i > 0 {
+			return generations[i-1], nil
+		}
+	}
+	return nil, errors.New(
+		"no earlier generation to roll back to",
+		"Each generate archives its outputs in runtime/history/; the retention policy is in inventory/history.yaml",
+	).WithClass(errors.ClassValidation)
+}
+
+// restoreGeneration swaps a generation's archived files into runtime/, through the
+// staging directory generate uses, and returns them (paths relative to runtime/)
+func restoreGeneration(id string) ([]string, error) {
+	if err := os.RemoveAll(paths.RuntimeStaging); err != nil {
+		return nil, fmt.Errorf("failed to clear %s: %w", paths.RuntimeStaging, err)
+	}
+
+	files, err := history.Restore(id, paths.RuntimeStaging)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.CommitStaged(paths.RuntimeStaging, paths.Runtime); err != nil {
+		return nil, fmt.Errorf("failed to restore generation %s: %w", id, err)
+	}
+	return files, nil
+}
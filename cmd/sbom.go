@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/sbom"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Sbom writes a CycloneDX or SPDX document listing every image of the generated deployment
+func Sbom(args []string) error {
+	usage := "usage: homelabctl sbom [--format cyclonedx|spdx] [--out <file>]"
+	format := sbom.FormatCycloneDX
+	out := ""
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--format" || arg == "--out":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value (%s)", arg, usage)
+			}
+			if arg == "--format" {
+				format = args[i+1]
+			} else {
+				out = args[i+1]
+			}
+			i++
+		case strings.HasPrefix(arg, "--format="):
+			format = strings.TrimPrefix(arg, "--format=")
+		case strings.HasPrefix(arg, "--out="):
+			out = strings.TrimPrefix(arg, "--out=")
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	images := deployedImages(generated)
+	for i := range images {
+		images[i].Digest = imageDigest(images[i])
+		if images[i].Digest == "" {
+			addWarnings(fmt.Sprintf("no digest for %s (image not pulled?); listed without checksum", images[i].Ref))
+		}
+	}
+
+	data, err := sbom.Generate(format, images, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(out, data, paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+
+	ui.Success("Wrote %s SBOM with %d image(s) to %s", format, len(images), out)
+	return nil
+}
+
+// deployedImages groups the generated services by image, with their stacks
+func deployedImages(generated *compose.ComposeFile) []sbom.Image {
+	byRef := make(map[string]*sbom.Image)
+
+	for svc, ref := range compose.ServiceImages(generated, nil) {
+		image, ok := byRef[ref]
+		if !ok {
+			image = &sbom.Image{Ref: ref}
+			byRef[ref] = image
+		}
+		image.Services = append(image.Services, svc)
+
+		if stack := compose.ServiceLabels(generated, svc)[compose.LabelStack]; stack != "" {
+			image.Stacks = appendUnique(image.Stacks, stack)
+		}
+	}
+
+	images := make([]sbom.Image, 0, len(byRef))
+	for _, image := range byRef {
+		sort.Strings(image.Services)
+		sort.Strings(image.Stacks)
+		images = append(images, *image)
+	}
+
+	sort.Slice(images, func(i, j int) bool { return images[i].Ref < images[j].Ref })
+	return images
+}
+
+// imageDigest returns the repository digest of an image, from its reference or the local image store
+func imageDigest(image sbom.Image) string {
+	if parts := strings.SplitN(image.Ref, "@", 2); len(parts) == 2 {
+		return parts[1]
+	}
+
+	output, err := dockerOutput("image", "inspect", "--format", "{{json .RepoDigests}}", image.Ref)
+	if err != nil {
+		return ""
+	}
+
+	var repoDigests []string
+	if err := json.Unmarshal([]byte(output), &repoDigests); err != nil {
+		return ""
+	}
+
+	// Prefer the digest of the referenced repository
+	for _, repoDigest := range repoDigests {
+		parts := strings.SplitN(repoDigest, "@", 2)
+		if len(parts) == 2 && parts[0] == image.Name() {
+			return parts[1]
+		}
+	}
+	if len(repoDigests) > 0 {
+		if parts := strings.SplitN(repoDigests[0], "@", 2); len(parts) == 2 {
+			return parts[1]
+		}
+	}
+
+	return ""
+}
+
+// appendUnique appends value to list unless already present
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
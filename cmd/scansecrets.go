@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/secrets"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// ScanSecrets looks for the values of the enabled stacks' secrets (or those of the
+// stacks named) in the world-readable files of runtime/, as generate does for the files
+// it writes. Leaks are warnings; --strict makes them fail the command
+func ScanSecrets(args []string) error {
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	stackNames := args
+	if len(stackNames) == 0 {
+		enabled, err := fs.GetEnabledStacks()
+		if err != nil {
+			return err
+		}
+		stackNames = enabled
+	}
+
+	stackSecrets := make(map[string]map[string]interface{})
+	for _, stackName := range stackNames {
+		if !fs.StackExists(stackName) {
+			return fmt.Errorf("stack '%s' does not exist", stackName)
+		}
+		loaded, err := secrets.LoadSecrets(stackName)
+		if err != nil {
+			return err
+		}
+		stackSecrets[stackName] = loaded
+	}
+	values := secrets.Values(stackSecrets)
+	if len(values) == 0 {
+		fmt.Println("No secrets to scan for")
+		return nil
+	}
+
+	files, err := runtimeFiles()
+	if err != nil {
+		return err
+	}
+
+	var leaks []string
+	for _, file := range files {
+		found, err := secrets.ScanFile(file, file, values)
+		if err != nil {
+			return err
+		}
+		for _, leak := range found {
+			leaks = append(leaks, leak.String())
+		}
+	}
+
+	if len(leaks) == 0 {
+		ui.Success("No secret value in the world-readable files of %s (%d file(s), %d secret(s))",
+			paths.Runtime, len(files), len(values))
+		return nil
+	}
+	if strictMode() {
+		return pipeline.SecretLeaksError(leaks)
+	}
+	addWarnings(leaks...)
+	fmt.Printf("⨯ %d secret value(s) in the world-readable files of %s\n", len(leaks), paths.Runtime)
+	return nil
+}
+
+// runtimeFiles returns the files of runtime/, sorted, leaving out the staging directory
+// and the archived generations of runtime/history/
+func runtimeFiles() ([]string, error) {
+	var files []string
+	err := filepath.Walk(paths.Runtime, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == paths.Runtime {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() {
+			if path == paths.RuntimeStaging || path == paths.HistoryDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.Runtime, err)
+	}
+	return files, nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseClock parses a "HH:MM" time of day
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM, e.g. 03:00)", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// nextAt returns the next occurrence of a time of day at or after now
+func nextAt(now time.Time, clock time.Duration) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(clock)
+	if next.Before(now) {
+		next = midnight.AddDate(0, 0, 1).Add(clock)
+	}
+	return next
+}
+
+// parseWindow parses a "HH:MM-HH:MM" maintenance window; it may span midnight
+func parseWindow(value string) (time.Duration, time.Duration, error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid window %q (expected HH:MM-HH:MM, e.g. 02:00-05:00)", value)
+	}
+
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("invalid window %q: start and end are equal", value)
+	}
+
+	return start, end, nil
+}
+
+// windowStart returns now if it falls inside the window, otherwise the window's next start
+func windowStart(now time.Time, start, end time.Duration) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	sinceMidnight := now.Sub(midnight)
+
+	inside := sinceMidnight >= start && sinceMidnight < end
+	if start > end {
+		// The window spans midnight
+		inside = sinceMidnight >= start || sinceMidnight < end
+	}
+	if inside {
+		return now
+	}
+
+	return nextAt(now, start)
+}
+
+// waitUntil blocks until the given time, reporting how long the wait is
+func waitUntil(when time.Time) {
+	wait := time.Until(when)
+	if wait <= 0 {
+		return
+	}
+
+	fmt.Printf("Waiting until %s (in %s) to deploy; press Ctrl+C to cancel\n",
+		when.Format("2006-01-02 15:04"), wait.Round(time.Minute))
+	time.Sleep(wait)
+}
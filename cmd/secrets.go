@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/secrets"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Secrets manages a stack's secrets file whatever encrypts it: sops (.enc.yaml) or age
+// (.age, no sops needed). encrypt turns secrets/<stack>.yaml into secrets/<stack>.age
+func Secrets(args []string) error {
+	usage := "usage: homelabctl secrets encrypt|decrypt|edit <stack> | secrets set <stack> <key> <value|->"
+	if len(args) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+	if args[0] == "set" {
+		if len(args) != 4 {
+			return fmt.Errorf("%s", usage)
+		}
+	} else if len(args) != 2 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	stackName := args[1]
+	switch args[0] {
+	case "encrypt":
+		if err := secrets.Encrypt(stackName); err != nil {
+			return err
+		}
+		ui.Success("Encrypted: %s (removed %s)",
+			paths.SecretsFilePath(stackName, paths.SecretsAgeExt), paths.SecretsFilePath(stackName, paths.SecretsExt))
+		return nil
+	case "decrypt":
+		data, err := secrets.Decrypt(stackName)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	case "edit":
+		return secretsEdit(stackName)
+	case "set":
+		return secretsSet(stackName, args[2], args[3])
+	default:
+		return fmt.Errorf("unknown secrets subcommand: %s (available: encrypt, decrypt, edit, set)", args[0])
+	}
+}
+
+// secretsEdit opens a stack's decrypted secrets in the editor and encrypts them on save:
+// sops files through sops itself, others with age. A stack without secrets gets a sops
+// file when the repository has a .sops.yaml, else an age file
+func secretsEdit(stackName string) error {
+	file, format := secrets.File(stackName)
+	if format == "" {
+		if _, err := os.Stat(paths.SOPSConfig); err == nil {
+			file, format = paths.SecretsFilePath(stackName, paths.SecretsEncExt), secrets.FormatSOPS
+		}
+	}
+
+	if format == secrets.FormatSOPS {
+		sopsPath, err := secrets.LookupSOPS(file)
+		if err != nil {
+			return err
+		}
+		if err := runInTerminal(exec.Command(sopsPath, file)); err != nil {
+			return fmt.Errorf("sops failed to edit %s: %w", file, err)
+		}
+		ui.Success("Saved: %s", file)
+		return nil
+	}
+
+	changed, err := secrets.Edit(stackName, runEditor)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		fmt.Println("No changes")
+		return nil
+	}
+	ui.Success("Encrypted: %s", paths.SecretsFilePath(stackName, paths.SecretsAgeExt))
+	return nil
+}
+
+// secretsSet sets one secret, stored as a string; "-" reads the value from stdin, so it
+// stays out of the shell history
+func secretsSet(stackName, key, value string) error {
+	if key == "" || strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") || strings.Contains(key, "..") {
+		return fmt.Errorf("invalid key '%s' (a dotted path, e.g. %s.db_password)", key, stackName)
+	}
+	if value == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read the value from stdin: %w", err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	}
+
+	if err := secrets.SetVar(stackName, key, value); err != nil {
+		return err
+	}
+	file, _ := secrets.File(stackName)
+	ui.Success("Set %s in %s", key, file)
+	return nil
+}
+
+// runEditor opens a file in $VISUAL, else $EDITOR, else vi
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// The editor may carry arguments (e.g. "code --wait")
+	if err := runInTerminal(exec.Command("sh", "-c", editor+` "$1"`, "sh", path)); err != nil {
+		return fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+	return nil
+}
+
+// runInTerminal runs an interactive program on the terminal
+func runInTerminal(cmd *exec.Cmd) error {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
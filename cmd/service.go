@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// resolveService finds the enabled stack defining a service given as "service" or "stack/service"
+// A bare name defined by several enabled stacks is ambiguous and must be qualified
+func resolveService(name string) (string, string, error) {
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return "", "", err
+	}
+
+	stackName, serviceName := inventory.SplitQualifiedName(name)
+
+	candidates := enabled
+	if stackName != "" {
+		candidates = nil
+		for _, s := range enabled {
+			if s == stackName {
+				candidates = []string{s}
+				break
+			}
+		}
+	}
+
+	owners, err := stacks.StacksDefiningService(serviceName, candidates)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch len(owners) {
+	case 0:
+		return "", "", serviceNotFound(name, enabled)
+	case 1:
+		return owners[0], serviceName, nil
+	}
+
+	context := []string{"Defined by enabled stacks:"}
+	suggestions := []string{}
+	for _, owner := range owners {
+		qualified := inventory.QualifiedName(owner, serviceName)
+		context = append(context, fmt.Sprintf("  - %s", qualified))
+		suggestions = append(suggestions, fmt.Sprintf("Use the stack-qualified name: %s", qualified))
+	}
+
+	return "", "", errors.New(
+		fmt.Sprintf("service name '%s' is ambiguous", serviceName),
+		suggestions...,
+	).WithContext(context...).WithClass(errors.ClassUsage)
+}
+
+// serviceNotFound creates the error for a service that no enabled stack defines
+func serviceNotFound(name string, enabled []string) error {
+	suggestions := []string{
+		"Run: homelabctl list",
+		"Check that the service's stack is enabled",
+	}
+
+	context := []string{
+		"Available services in enabled stacks:",
+	}
+	var available []string
+	for _, stackName := range enabled {
+		services, err := stacks.GetServiceNames(stackName)
+		if err != nil {
+			return err
+		}
+		for _, svc := range services {
+			available = append(available, fmt.Sprintf("  - %s (from %s)", svc, stackName))
+		}
+	}
+	sort.Strings(available)
+	context = append(context, available...)
+
+	return errors.New(
+		fmt.Sprintf("service '%s' not found in enabled stacks", name),
+		suggestions...,
+	).WithContext(context...).WithClass(errors.ClassNotFound)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/lock"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// State provides maintenance helpers for inventory/state.yaml
+func State(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: homelabctl state prune [--dry-run]")
+	}
+
+	switch args[0] {
+	case "prune":
+		return statePrune(args[1:])
+	default:
+		return fmt.Errorf("unknown state subcommand: %s (available: prune)", args[0])
+	}
+}
+
+// statePrune removes disabled-service entries that no enabled stack defines anymore
+func statePrune(args []string) error {
+	dryRun := false
+
+	for _, arg := range args {
+		switch arg {
+		case "--dry-run":
+			dryRun = true
+		default:
+			return fmt.Errorf("unexpected argument: %s", arg)
+		}
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	release, err := lock.Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return err
+	}
+
+	var stale []string
+	if dryRun {
+		stale, err = inventory.StaleDisabledServices(enabled)
+	} else {
+		stale, err = inventory.PruneDisabledServices(enabled)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(stale) == 0 {
+		ui.Success("No stale disabled services")
+		return nil
+	}
+
+	for _, name := range stale {
+		if dryRun {
+			fmt.Printf("  Would remove: %s\n", name)
+		} else {
+			fmt.Printf("  Removed: %s\n", name)
+		}
+	}
+
+	if dryRun {
+		fmt.Printf("\n%d stale disabled service(s) (dry run, nothing changed)\n", len(stale))
+	} else {
+		ui.Blank()
+		ui.Success("Pruned %d stale disabled service(s) from inventory/state.yaml", len(stale))
+	}
+	return nil
+}
+
+// warnStaleDisabledServices reports disabled services that no enabled stack defines
+func warnStaleDisabledServices(enabled []string) error {
+	stale, err := inventory.StaleDisabledServices(enabled)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range stale {
+		addWarnings(fmt.Sprintf("disabled service '%s' is not defined by any enabled stack (run: homelabctl state prune)", name))
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Stop stops services without removing containers
+// With a stack name, only that stack's services are stopped; otherwise the
+// arguments are passed through to docker compose stop
+func Stop(args []string) error {
+	stackName, rest := splitStackArg(args)
+	if stackName == "" {
+		return Compose("stop", args)
+	}
+
+	services, err := resolveStackServices(stackName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Stopping %d service(s) from stack %s: %s\n", len(services), stackName, strings.Join(services, ", "))
+
+	cmdArgs := append([]string{"stop"}, rest...)
+	cmdArgs = append(cmdArgs, services...)
+
+	if err := runCompose(cmdArgs...); err != nil {
+		return fmt.Errorf("docker compose stop failed: %w", err)
+	}
+
+	ui.Success("Stack %s stopped", stackName)
+	return nil
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// systemdUnitDir is where install-service writes its unit
+const systemdUnitDir = "/etc/systemd/system"
+
+// defaultServiceName is the unit name of install-service without --name
+const defaultServiceName = "homelab"
+
+// InstallService writes and enables a systemd unit deploying the homelab at boot, once
+// the container engine and the network are up; with --compose it only starts the
+// generated compose file
+func InstallService(args []string) error {
+	usage := "usage: homelabctl install-service [--name <unit>] [--compose] [--stdout]"
+	name := defaultServiceName
+	composeOnly := false
+	stdout := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--name":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--name requires a value (%s)", usage)
+			}
+			name = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--name="):
+			name = strings.TrimPrefix(arg, "--name=")
+		case arg == "--compose":
+			composeOnly = true
+		case arg == "--stdout":
+			stdout = true
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+	name = strings.TrimSuffix(name, ".service")
+	if name == "" || strings.ContainsAny(name, "/ ") {
+		return fmt.Errorf("invalid unit name: %q (%s)", name, usage)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+	if os.Getenv("HOMELAB_HOST") != "" {
+		return errors.New(
+			"install-service installs the unit on this machine",
+			"Run it on the Docker host, without --host",
+		).WithClass(errors.ClassUsage)
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get the repository path: %w", err)
+	}
+	binary, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate homelabctl: %w", err)
+	}
+
+	command := []string{"deploy"}
+	if composeOnly {
+		command = []string{"up", "-d"}
+	}
+	unit := systemdUnit(root, binary, command, serviceEnvironment(), os.Getenv("HOMELAB_ENGINE"))
+
+	if stdout {
+		fmt.Print(unit)
+		return nil
+	}
+
+	unitPath := filepath.Join(systemdUnitDir, name+".service")
+	if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to write %s", unitPath),
+			"Run as root: sudo homelabctl install-service",
+			"Or print the unit and install it yourself: homelabctl install-service --stdout",
+		)
+	}
+	ui.Success("Written: %s", unitPath)
+
+	for _, systemctl := range [][]string{{"daemon-reload"}, {"enable", name + ".service"}} {
+		cmd := exec.Command("systemctl", systemctl...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("systemctl %s failed", strings.Join(systemctl, " ")),
+				"Check that systemd manages this machine",
+			).WithClass(errors.ClassDependency)
+		}
+	}
+
+	ui.Success("Enabled %s.service: homelabctl %s runs at boot", name, strings.Join(command, " "))
+	fmt.Printf("  Start it now: sudo systemctl start %s.service\n", name)
+	return nil
+}
+
+// serviceEnvironment returns the homelabctl settings of this run the unit keeps,
+// as KEY=value pairs: the inventory environment, the container engine and the
+// compose project name
+func serviceEnvironment() []string {
+	var env []string
+	for _, key := range []string{"HOMELAB_ENV", "HOMELAB_ENGINE", "HOMELAB_PROJECT"} {
+		if value := os.Getenv(key); value != "" {
+			env = append(env, key+"="+value)
+		}
+	}
+	return env
+}
+
+// runtimeUnit returns the systemd unit serving the containers of an engine kind, and
+// whether the homelab cannot start without it: podman runs without a daemon, its
+// socket only serves the compose provider
+func runtimeUnit(kind string) (string, bool) {
+	if kind == engine.KindPodman {
+		return "podman.socket", false
+	}
+	return "docker.service", true
+}
+
+// systemdUnit renders a oneshot unit running homelabctl in the repository after the
+// runtime unit of the engine kind and network-online.target
+// Paths are written as systemd reads them: WorkingDirectory= takes the rest of the
+// line as is, while ExecStart= and Environment= split words, so those get quoted
+func systemdUnit(root, binary string, command []string, env []string, kind string) string {
+	runtime, required := runtimeUnit(kind)
+
+	var b strings.Builder
+	b.WriteString("# Generated by homelabctl install-service\n")
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=Homelab stacks (%s)\n", systemdEscape(root))
+	if required {
+		fmt.Fprintf(&b, "Requires=%s\n", runtime)
+		b.WriteString("Wants=network-online.target\n")
+	} else {
+		fmt.Fprintf(&b, "Wants=%s network-online.target\n", runtime)
+	}
+	fmt.Fprintf(&b, "After=%s network-online.target\n", runtime)
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=oneshot\n")
+	b.WriteString("RemainAfterExit=yes\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdEscape(root))
+	for _, pair := range env {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(pair))
+	}
+	words := make([]string, 0, len(command)+1)
+	for _, word := range append([]string{binary}, command...) {
+		// ExecStart= also expands $VARIABLE
+		words = append(words, strings.ReplaceAll(systemdQuote(word), "$", "$$"))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(words, " "))
+	b.WriteString("TimeoutStartSec=0\n")
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// systemdEscape escapes the specifiers (%n, %h...) systemd expands in unit settings
+func systemdEscape(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+// systemdQuote escapes a word of ExecStart= or Environment=, in double quotes when it
+// holds whitespace, quotes or backslashes, which systemd would split or unescape
+func systemdQuote(word string) string {
+	word = systemdEscape(word)
+	if !strings.ContainsAny(word, " \t\"'\\") {
+		return word
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(word) + `"`
+}
@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+)
+
+// containerUsage is one container's resource usage from docker stats
+type containerUsage struct {
+	Stack    string
+	Category string
+	CPU      float64 // Percent of one CPU
+	Memory   float64 // Bytes
+	NetRx    float64 // Bytes
+	NetTx    float64 // Bytes
+}
+
+// stackUsage is the summed resource usage of a stack's containers
+type stackUsage struct {
+	Stack      string
+	Category   string
+	Containers int
+	CPU        float64
+	Memory     float64
+	NetRx      float64
+	NetTx      float64
+}
+
+// Top shows running processes (docker compose top), or with --by-stack,
+// resource usage summed per stack and category
+func Top(args []string) error {
+	byStack := false
+	var passthrough []string
+	for _, arg := range args {
+		if arg == "--by-stack" {
+			byStack = true
+			continue
+		}
+		passthrough = append(passthrough, arg)
+	}
+
+	if !byStack {
+		return Compose("top", args)
+	}
+	if len(passthrough) > 0 {
+		return fmt.Errorf("unexpected argument: %s (usage: homelabctl top --by-stack)", passthrough[0])
+	}
+
+	// Only containers generated by homelabctl for this compose project
+	listing, err := dockerOutput("ps",
+		"--filter", "label=com.docker.compose.project="+composeProjectName(),
+		"--filter", "label="+compose.LabelStack,
+		"--format", fmt.Sprintf("{{.ID}}\t{{.Label %q}}\t{{.Label %q}}", compose.LabelStack, compose.LabelCategory))
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	if listing == "" {
+		fmt.Println("No running containers")
+		return nil
+	}
+
+	stackOf := make(map[string][2]string)
+	var ids []string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		stackOf[fields[0]] = [2]string{fields[1], fields[2]}
+		ids = append(ids, fields[0])
+	}
+
+	statsArgs := append([]string{"stats", "--no-stream", "--format", "{{json .}}"}, ids...)
+	output, err := dockerOutput(statsArgs...)
+	if err != nil {
+		return fmt.Errorf("docker stats failed: %w", err)
+	}
+
+	var usages []containerUsage
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		usage, id, err := parseStatsLine(line)
+		if err != nil {
+			return err
+		}
+		// docker stats prints the truncated container ID
+		for fullID, owner := range stackOf {
+			if strings.HasPrefix(fullID, id) || strings.HasPrefix(id, fullID) {
+				usage.Stack, usage.Category = owner[0], owner[1]
+				break
+			}
+		}
+		usages = append(usages, usage)
+	}
+
+	printStackUsage(aggregateUsage(usages))
+	return nil
+}
+
+// parseStatsLine parses one `docker stats --format '{{json .}}'` line
+func parseStatsLine(line string) (containerUsage, string, error) {
+	var stats struct {
+		ID       string `json:"ID"`
+		CPUPerc  string `json:"CPUPerc"`
+		MemUsage string `json:"MemUsage"`
+		NetIO    string `json:"NetIO"`
+	}
+	if err := json.Unmarshal([]byte(line), &stats); err != nil {
+		return containerUsage{}, "", fmt.Errorf("failed to parse docker stats output: %w", err)
+	}
+
+	usage := containerUsage{CPU: parsePercent(stats.CPUPerc)}
+
+	// "12.5MiB / 1.944GiB": used / limit
+	usage.Memory = parseSize(strings.SplitN(stats.MemUsage, "/", 2)[0])
+
+	// "1.2kB / 3.4MB": received / sent
+	if netIO := strings.SplitN(stats.NetIO, "/", 2); len(netIO) == 2 {
+		usage.NetRx = parseSize(netIO[0])
+		usage.NetTx = parseSize(netIO[1])
+	}
+
+	return usage, stats.ID, nil
+}
+
+// parsePercent parses "1.23%"; unparseable values count as zero
+func parsePercent(value string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	return f
+}
+
+// sizeUnits maps docker's size suffixes (SI and binary) to bytes
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	// Longest suffixes first so "MiB" is not read as "B"
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses sizes such as "12.5MiB" or "3.4kB" into bytes; unparseable values count as zero
+func parseSize(value string) float64 {
+	value = strings.TrimSpace(value)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			f, err := strconv.ParseFloat(strings.TrimSuffix(value, unit.suffix), 64)
+			if err != nil {
+				return 0
+			}
+			return f * unit.multiplier
+		}
+	}
+	return 0
+}
+
+// aggregateUsage sums container usage per stack, ordered by category total memory,
+// then by stack memory (largest first)
+func aggregateUsage(usages []containerUsage) []stackUsage {
+	byStack := make(map[string]*stackUsage)
+	categoryMemory := make(map[string]float64)
+
+	for _, u := range usages {
+		stack := u.Stack
+		if stack == "" {
+			stack = "unknown"
+		}
+
+		s, ok := byStack[stack]
+		if !ok {
+			s = &stackUsage{Stack: stack, Category: u.Category}
+			byStack[stack] = s
+		}
+		s.Containers++
+		s.CPU += u.CPU
+		s.Memory += u.Memory
+		s.NetRx += u.NetRx
+		s.NetTx += u.NetTx
+		categoryMemory[u.Category] += u.Memory
+	}
+
+	result := make([]stackUsage, 0, len(byStack))
+	for _, s := range byStack {
+		result = append(result, *s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Category != b.Category {
+			if categoryMemory[a.Category] != categoryMemory[b.Category] {
+				return categoryMemory[a.Category] > categoryMemory[b.Category]
+			}
+			return a.Category < b.Category
+		}
+		if a.Memory != b.Memory {
+			return a.Memory > b.Memory
+		}
+		return a.Stack < b.Stack
+	})
+
+	return result
+}
+
+// printStackUsage prints per-stack usage grouped under category subtotals
+func printStackUsage(stacks []stackUsage) {
+	format := "%-24s %10s %8s %10s %10s %10s\n"
+	fmt.Printf(format, "CATEGORY / STACK", "CONTAINERS", "CPU %", "MEMORY", "NET RX", "NET TX")
+
+	var total stackUsage
+	for i := 0; i < len(stacks); {
+		category := stacks[i].Category
+
+		var subtotal stackUsage
+		j := i
+		for ; j < len(stacks) && stacks[j].Category == category; j++ {
+			addUsage(&subtotal, stacks[j])
+		}
+		addUsage(&total, subtotal)
+
+		name := category
+		if name == "" {
+			name = "uncategorized"
+		}
+		printUsageRow(format, name, subtotal)
+		for _, s := range stacks[i:j] {
+			printUsageRow(format, "  "+s.Stack, s)
+		}
+
+		i = j
+	}
+
+	fmt.Println()
+	printUsageRow(format, "total", total)
+}
+
+// addUsage adds b's totals to a
+func addUsage(a *stackUsage, b stackUsage) {
+	a.Containers += b.Containers
+	a.CPU += b.CPU
+	a.Memory += b.Memory
+	a.NetRx += b.NetRx
+	a.NetTx += b.NetTx
+}
+
+// printUsageRow prints one line of the usage table
+func printUsageRow(format, name string, u stackUsage) {
+	fmt.Printf(format, name, strconv.Itoa(u.Containers), fmt.Sprintf("%.1f", u.CPU),
+		formatBytes(u.Memory, 1024, "iB"), formatBytes(u.NetRx, 1000, "B"), formatBytes(u.NetTx, 1000, "B"))
+}
+
+// formatBytes renders a byte count with the largest fitting unit
+// base 1024 with suffix "iB" gives KiB/MiB/GiB; base 1000 with "B" gives kB/MB/GB
+func formatBytes(bytes, base float64, suffix string) string {
+	units := []string{"K", "M", "G", "T"}
+	if base == 1000 {
+		units[0] = "k"
+	}
+
+	if bytes < base {
+		return fmt.Sprintf("%.0fB", bytes)
+	}
+
+	value := bytes
+	unit := ""
+	for _, u := range units {
+		if value < base {
+			break
+		}
+		value /= base
+		unit = u
+	}
+	return fmt.Sprintf("%.1f%s%s", value, unit, suffix)
+}
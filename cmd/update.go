@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/notify"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/registry"
+	"github.com/monkeymonk/homelabctl/internal/sbom"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// What an update run does with a category
+const (
+	updateApply = "update" // Pull images and recreate services with a newer image
+	updateCheck = "check"  // Compare image digests with the registry and report services with a newer image
+	updateSkip  = "skip"
+)
+
+// plannedUpdate is the action an update run takes for one category wave
+type plannedUpdate struct {
+	Wave   deployWave
+	Action string
+	Reason string
+}
+
+// Update pulls images and recreates the services they changed, category by category
+// With --scheduled (for cron or a systemd timer) each category follows its update
+// policy: auto categories are updated within their window, notify categories are
+// only checked, manual categories are skipped
+// Available and failed updates are sent through the notification routes
+func Update(args []string) error {
+	usage := "usage: homelabctl update [--scheduled] [--dry-run] [--parallel <n>]"
+	scheduled := false
+	dryRun := false
+	parallel := defaultPullParallelism
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		switch name {
+		case "--scheduled":
+			scheduled = true
+		case "--dry-run":
+			dryRun = true
+		case "--parallel", "-j":
+			if !hasValue {
+				if i+1 >= len(args) {
+					return fmt.Errorf("%s requires a value (%s)", name, usage)
+				}
+				value = args[i+1]
+				i++
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid --parallel value: %s", value)
+			}
+			parallel = n
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := inventory.LoadUpdatePolicies()
+	if err != nil {
+		return err
+	}
+
+	plan, err := planUpdates(categoryWaves(generated), overrides, scheduled, time.Now())
+	if err != nil {
+		return err
+	}
+
+	notifications, err := notify.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Update plan:")
+	for _, p := range plan {
+		fmt.Printf("  %-16s %-7s %s\n", p.Wave.Category, p.Action, p.Reason)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	// Group services by image so shared images are pulled once
+	// Images built from source have no registry version to compare with
+	images := compose.ServiceImages(generated, nil)
+	built := skipBuiltServices(generated)
+	imageServices := make(map[string][]string)
+	applied := make(map[string]bool)
+	for _, p := range plan {
+		if p.Action == updateSkip {
+			continue
+		}
+		for _, svc := range p.Wave.Services {
+			if image, ok := images[svc]; ok && !built[svc] {
+				imageServices[image] = append(imageServices[image], svc)
+				applied[image] = applied[image] || p.Action == updateApply
+			}
+		}
+	}
+
+	if len(imageServices) == 0 {
+		ui.Blank()
+		ui.Step("Nothing to update")
+		return nil
+	}
+
+	// Images of updated categories are pulled; the others are only compared with their
+	// registry, so a check leaves the local images, and the next deploy, as they are
+	var pullList, checkList []string
+	for image := range imageServices {
+		if applied[image] {
+			pullList = append(pullList, image)
+		} else {
+			checkList = append(checkList, image)
+		}
+	}
+	sort.Strings(pullList)
+	sort.Strings(checkList)
+
+	var results []pullResult
+	if len(pullList) > 0 {
+		if err := registryLogin(pullList); err != nil {
+			return err
+		}
+
+		ui.Blank()
+		ui.Step("Pulling %d image(s) (%d in parallel)...", len(pullList), parallel)
+		results = pullImages(pullList, parallel)
+	}
+	if len(checkList) > 0 {
+		client, err := registryClient()
+		if err != nil {
+			return err
+		}
+
+		ui.Blank()
+		ui.Step("Checking %d image(s) against their registry...", len(checkList))
+		results = append(results, checkDigests(client, checkList, parallel)...)
+	}
+
+	changed := make(map[string]bool)
+	var pullFailed int
+	for _, r := range results {
+		if r.Err != nil {
+			pullFailed++
+			fmt.Printf("  ⨯ %s: %v\n", r.Image, r.Err)
+			continue
+		}
+		if r.OldID != r.NewID {
+			for _, svc := range imageServices[r.Image] {
+				changed[svc] = true
+			}
+		}
+	}
+
+	e, err := projectEngine()
+	if err != nil {
+		return err
+	}
+
+	ui.Blank()
+	var failed []string
+	var pending []notify.Notification
+	for _, p := range plan {
+		if p.Action == updateSkip {
+			continue
+		}
+
+		var outdated []string
+		for _, svc := range p.Wave.Services {
+			if changed[svc] {
+				outdated = append(outdated, svc)
+			}
+		}
+
+		switch {
+		case len(outdated) == 0:
+			ui.Success("%s: up to date", p.Wave.Category)
+		case p.Action == updateCheck:
+			fmt.Printf("⚠ %s: newer images for %s (policy %s; apply with: homelabctl update)\n",
+				p.Wave.Category, strings.Join(outdated, ", "), categories.UpdateNotify)
+			pending = append(pending, notify.Notification{
+				Severity: notify.SeverityInfo,
+				Title:    fmt.Sprintf("Updates available for %s", p.Wave.Category),
+				Message:  fmt.Sprintf("Newer images for %s (apply with: homelabctl update)", strings.Join(outdated, ", ")),
+				Category: p.Wave.Category,
+				Event:    "update-available",
+			})
+		default:
+			ui.Step("Recreating %s: %s", p.Wave.Category, strings.Join(outdated, ", "))
+			// Waiting keeps the category order meaningful: the next one starts once this one is healthy
+			if err := e.Up(outdated, engine.UpOptions{NoDeps: true, Wait: true}); err != nil {
+				failed = append(failed, p.Wave.Category)
+				fmt.Printf("✗ %s: %v\n", p.Wave.Category, err)
+				pending = append(pending, notify.Notification{
+					Severity: notify.SeverityCritical,
+					Title:    fmt.Sprintf("Update of %s failed", p.Wave.Category),
+					Message:  err.Error(),
+					Category: p.Wave.Category,
+					Event:    "update-failed",
+				})
+				continue
+			}
+			ui.Success("%s: updated %s", p.Wave.Category, strings.Join(outdated, ", "))
+		}
+	}
+
+	for _, n := range pending {
+		if err := sendNotification(notifications, n); err != nil {
+			addWarnings(err.Error())
+		}
+	}
+
+	if pullFailed > 0 {
+		return fmt.Errorf("failed to pull or check %d image(s)", pullFailed)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("update failed for %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// checkDigests compares the local digest of images with the digest their tag points to
+// in the registry, without pulling, with bounded parallelism and in input order
+// OldID and NewID of the results hold the local and registry digests; an image never
+// pulled has no local digest, so it differs
+func checkDigests(client *registry.Client, images []string, parallel int) []pullResult {
+	results := make([]pullResult, len(images))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for i, image := range images {
+		wg.Add(1)
+		go func(i int, image string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := pullResult{Image: image}
+			ref := registry.ParseReference(image)
+			if ref.Tag == "" {
+				// Pinned by digest alone: the registry cannot serve another image for it
+				result.OldID, result.NewID = ref.Digest, ref.Digest
+			} else {
+				result.OldID = imageDigest(sbom.Image{Ref: image})
+				result.NewID, result.Err = client.Digest(ref)
+			}
+			if result.Err == nil {
+				ui.Detail("✓ %s", image)
+			}
+
+			results[i] = result
+		}(i, image)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// planUpdates decides the action for every category wave
+func planUpdates(waves []deployWave, overrides map[string]categories.UpdatePolicy, scheduled bool, now time.Time) ([]plannedUpdate, error) {
+	plan := make([]plannedUpdate, 0, len(waves))
+	for _, wave := range waves {
+		action, reason, err := updateAction(inventory.UpdatePolicy(wave.Category, overrides), scheduled, now)
+		if err != nil {
+			return nil, fmt.Errorf("category %s: %w", wave.Category, err)
+		}
+		plan = append(plan, plannedUpdate{Wave: wave, Action: action, Reason: reason})
+	}
+	return plan, nil
+}
+
+// updateAction decides what a run does with a category under its policy
+// Unscheduled runs update every category; scheduled runs follow the policy
+func updateAction(policy categories.UpdatePolicy, scheduled bool, now time.Time) (string, string, error) {
+	if !scheduled {
+		return updateApply, "manual run", nil
+	}
+
+	switch policy.Policy {
+	case categories.UpdateAuto:
+		if policy.Window == "" {
+			return updateApply, "policy auto", nil
+		}
+		start, end, err := parseWindow(policy.Window)
+		if err != nil {
+			return "", "", err
+		}
+		if windowStart(now, start, end).Equal(now) {
+			return updateApply, "policy auto, inside window " + policy.Window, nil
+		}
+		return updateSkip, "policy auto, outside window " + policy.Window, nil
+	case categories.UpdateNotify:
+		return updateCheck, "policy notify", nil
+	default:
+		return updateSkip, "policy manual", nil
+	}
+}
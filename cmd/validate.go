@@ -2,16 +2,102 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/monkeymonk/homelabctl/internal/catalog"
+	"github.com/monkeymonk/homelabctl/internal/compose"
 	"github.com/monkeymonk/homelabctl/internal/errors"
 	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/render"
 	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
 )
 
+// validateOutput is the machine-readable form of validate (--output json|yaml)
+type validateOutput struct {
+	Valid    bool            `json:"valid" yaml:"valid"`
+	Checks   []string        `json:"checks" yaml:"checks"` // Passed checks
+	Warnings []string        `json:"warnings" yaml:"warnings"`
+	Error    *errors.Details `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
 // Validate checks the repository for errors
-func Validate() error {
+// With --render it also renders every template of the enabled stacks in memory with the
+// built-in engine, so templates can be checked where gomplate is not installed
+func Validate(args []string) error {
+	usage := "usage: homelabctl validate [--render]"
+	renderTemplates := false
+	for _, arg := range args {
+		switch arg {
+		case "--render":
+			renderTemplates = true
+		default:
+			return fmt.Errorf("unexpected argument: %s (%s)", arg, usage)
+		}
+	}
+
+	validate := func(progress func(string)) error {
+		if err := validateRepository(progress); err != nil {
+			return err
+		}
+		if renderTemplates {
+			return validateRender(progress)
+		}
+		return nil
+	}
+
+	if machineOutput() {
+		return validateMachine(validate)
+	}
+
 	fmt.Println("Validating homelab configuration...")
 
+	if err := validate(func(line string) { fmt.Println(line) }); err != nil {
+		return err
+	}
+
+	if strictMode() && len(Warnings()) > 0 {
+		return errors.StrictWarnings(Warnings())
+	}
+
+	ui.Blank()
+	ui.Success("Validation successful")
+	return nil
+}
+
+// validateMachine prints the validation findings as a JSON or YAML document
+// A failed validation still returns its error, so the exit code stays non-zero
+func validateMachine(validate func(progress func(string)) error) error {
+	output := validateOutput{Checks: []string{}}
+	err := validate(func(line string) {
+		output.Checks = append(output.Checks, strings.TrimPrefix(line, "✓ "))
+	})
+	if err == nil && strictMode() && len(Warnings()) > 0 {
+		err = errors.StrictWarnings(Warnings())
+	}
+
+	output.Valid = err == nil
+	output.Warnings = append([]string{}, Warnings()...)
+	if err != nil {
+		details := errors.DetailsOf(err)
+		output.Error = &details
+	}
+
+	if writeErr := writeOutput(output); writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// validateRepository runs the validation checks, reporting each passed check to progress
+// Problems that do not fail validation are collected as warnings
+func validateRepository(progress func(string)) error {
 	// Verify repository structure
 	if err := fs.VerifyRepository(); err != nil {
 		return errors.Wrap(
@@ -21,7 +107,7 @@ func Validate() error {
 			"Check that you're in a homelab repository root",
 		)
 	}
-	fmt.Println("✓ Repository structure valid")
+	progress("✓ Repository structure valid")
 
 	// Get enabled stacks
 	enabled, err := fs.GetEnabledStacks()
@@ -39,23 +125,25 @@ func Validate() error {
 			"no stacks enabled",
 			"Run: homelabctl enable <stack>",
 			"Example: homelabctl enable core",
-		)
+		).WithClass(errors.ClassValidation)
 	}
 
-	fmt.Printf("Enabled stacks: %d\n", len(enabled))
+	progress(fmt.Sprintf("Enabled stacks: %d", len(enabled)))
 
 	// Verify all enabled stacks have stack.yaml
 	for _, name := range enabled {
-		if _, err := stacks.LoadStack(name); err != nil {
+		stack, err := stacks.LoadStack(name)
+		if err != nil {
 			return errors.Wrap(
 				err,
 				fmt.Sprintf("invalid stack '%s'", name),
 				fmt.Sprintf("Check: stacks/%s/stack.yaml", name),
 				fmt.Sprintf("Run: homelabctl disable %s", name),
-			)
+			).WithClass(errors.ClassValidation)
 		}
+		addWarnings(stack.Warnings...)
 	}
-	fmt.Printf("✓ All %d enabled stacks have valid stack.yaml\n", len(enabled))
+	progress(fmt.Sprintf("✓ All %d enabled stacks have valid stack.yaml", len(enabled)))
 
 	// Verify all enabled stacks have compose.yml.tmpl
 	for _, name := range enabled {
@@ -64,16 +152,16 @@ func Validate() error {
 				fmt.Sprintf("stack '%s' missing compose.yml.tmpl", name),
 				fmt.Sprintf("Create: stacks/%s/compose.yml.tmpl", name),
 				"See documentation for template format",
-			)
+			).WithClass(errors.ClassValidation)
 		}
 	}
-	fmt.Println("✓ All enabled stacks have compose.yml.tmpl")
+	progress("✓ All enabled stacks have compose.yml.tmpl")
 
 	// Validate dependencies
 	if err := stacks.ValidateDependencies(enabled); err != nil {
 		return err // Already has enhanced error from stacks package
 	}
-	fmt.Println("✓ All dependencies satisfied")
+	progress("✓ All dependencies satisfied")
 
 	// Validate service definitions
 	for _, stackName := range enabled {
@@ -83,17 +171,211 @@ func Validate() error {
 				fmt.Sprintf("invalid service definitions in stack '%s'", stackName),
 				fmt.Sprintf("Edit: stacks/%s/stack.yaml", stackName),
 				"Ensure all services in 'services:' list have definitions in 'vars:'",
-			)
+			).WithClass(errors.ClassValidation)
 		}
 	}
-	fmt.Println("✓ All service definitions are valid")
+	progress("✓ All service definitions are valid")
 
 	// Validate category hierarchy
 	if err := stacks.ValidateCategoryDependencies(enabled); err != nil {
 		return err
 	}
-	fmt.Println("✓ Category dependencies are valid")
+	progress("✓ Category dependencies are valid")
+
+	// Deployment constraints of inventory/policies.yaml
+	checked, err := checkPolicies()
+	if err != nil {
+		return err
+	}
+	if checked {
+		progress("✓ Generated services satisfy " + paths.InventoryPolicies)
+	}
+
+	// Service count and memory guardrails of inventory/capacity.yaml
+	checked, err = warnCapacity()
+	if err != nil {
+		return err
+	}
+	if checked {
+		progress("✓ Planned services checked against " + paths.InventoryCapacity)
+	}
+
+	// Docker compose project name of --project or inventory/compose.yaml
+	project, err := projectName()
+	if err != nil {
+		return err
+	}
+	progress("✓ Compose project: " + project)
+
+	// Disabled services left behind by disabled or deleted stacks
+	if err := warnStaleDisabledServices(enabled); err != nil {
+		return err
+	}
 
-	fmt.Println("\n✓ Validation successful")
+	// Catalog stacks edited since they were installed
+	if err := warnModifiedCatalogStacks(); err != nil {
+		return err
+	}
+
+	// Services restarted at boot before the services of other stacks they depend on
+	if err := warnStartupOrder(enabled); err != nil {
+		return err
+	}
+
+	// Templates of third-party catalog stacks that will not render sandboxed
+	if err := warnSandboxViolations(enabled); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateRender renders the templates of the enabled stacks in memory with the built-in
+// engine, whatever render.engine selects; nothing is written to runtime/
+func validateRender(progress func(string)) error {
+	inventoryVars, err := inventory.LoadVars()
+	if err != nil {
+		return fmt.Errorf("failed to load inventory vars: %w", err)
+	}
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return err
+	}
+
+	previous := render.SelectedEngine()
+	if err := render.SetEngine(render.EngineNative); err != nil {
+		return err
+	}
+	defer render.SetEngine(previous)
+
+	rendered := 0
+	for _, name := range enabled {
+		config, err := pipeline.BuildStackConfig(name, inventoryVars)
+		if err != nil {
+			return err
+		}
+		templates, err := pipeline.DryRender(config, enabled)
+		if err != nil {
+			return err // Already names the template
+		}
+		rendered += len(templates)
+	}
+
+	progress(fmt.Sprintf("✓ All %d templates render with the built-in engine", rendered))
+	return nil
+}
+
+// warnSandboxViolations audits the templates of the enabled stacks installed from
+// untrusted catalogs, which generate refuses to render
+func warnSandboxViolations(enabled []string) error {
+	for _, name := range enabled {
+		untrusted, err := catalog.Untrusted(name)
+		if err != nil {
+			return err
+		}
+		if !untrusted {
+			continue
+		}
+
+		stackDir := paths.StackDir(name)
+		err = filepath.Walk(stackDir, func(tmplPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(tmplPath) != paths.TemplateExt {
+				return nil
+			}
+
+			findings, err := render.Audit(tmplPath, stackDir)
+			if err != nil {
+				return err
+			}
+			for _, finding := range findings {
+				addWarnings(fmt.Sprintf("%s of untrusted stack '%s': %s", tmplPath, name, finding))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPolicies checks the generated compose file, if any, against inventory/policies.yaml
+// and reports whether it did; generate enforces them on what it renders
+func checkPolicies() (bool, error) {
+	if _, err := os.Stat(paths.InventoryPolicies); err != nil {
+		return false, nil // No constraints
+	}
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return false, nil // Not generated yet
+	}
+
+	policies, err := inventory.LoadPolicies()
+	if err != nil {
+		return false, err
+	}
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return false, err
+	}
+
+	// Services are mapped to their stacks through the provenance labels
+	return true, pipeline.CheckPolicies(generated, nil, policies)
+}
+
+// warnStartupOrder checks the startup order of the generated compose file, if any
+// Its services are mapped to their enabled stacks through the provenance labels
+func warnStartupOrder(enabled []string) error {
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return nil // Not generated yet
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	serviceStacks := make(map[string]string)
+	for svc := range generated.Services {
+		if stackName := compose.ServiceLabels(generated, svc)[compose.LabelStack]; contains(enabled, stackName) {
+			serviceStacks[svc] = stackName
+		}
+	}
+
+	warnings, err := pipeline.StartupOrderWarnings(generated, serviceStacks)
+	if err != nil {
+		return err
+	}
+	addWarnings(warnings...)
+	return nil
+}
+
+// warnModifiedCatalogStacks warns about stacks whose files no longer match the digest
+// recorded in stacks.lock when they were installed from a catalog
+func warnModifiedCatalogStacks() error {
+	lock, err := catalog.LoadLockFile()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(lock.Stacks))
+	for name := range lock.Stacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		locked := lock.Stacks[name]
+		digest, err := catalog.StackDigest(paths.StackDir(name))
+		if err != nil {
+			addWarnings(fmt.Sprintf("stack '%s' is listed in %s but not installed", name, paths.StacksLock))
+			continue
+		}
+		if digest != locked.Digest {
+			addWarnings(fmt.Sprintf("stack '%s' was modified since it was installed from catalog %s (%s)", name, locked.Catalog, paths.StacksLock))
+		}
+	}
 	return nil
 }
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Vars provides helpers around inventory variables
+func Vars(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: homelabctl vars example [--all] [--stdout]")
+	}
+
+	switch args[0] {
+	case "example":
+		return varsExample(args[1:])
+	default:
+		return fmt.Errorf("unknown vars subcommand: %s (available: example)", args[0])
+	}
+}
+
+// varsExample writes inventory/vars.example.yaml from stack defaults and template references
+func varsExample(args []string) error {
+	all := false
+	toStdout := false
+
+	for _, arg := range args {
+		switch arg {
+		case "--all":
+			all = true
+		case "--stdout":
+			toStdout = true
+		default:
+			return fmt.Errorf("unexpected argument: %s", arg)
+		}
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	var stackNames []string
+	var err error
+	if all {
+		stackNames, err = fs.GetAvailableStacks()
+	} else {
+		stackNames, err = fs.GetEnabledStacks()
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(stackNames) == 0 {
+		if all {
+			fmt.Println("No stacks found in stacks/")
+		} else {
+			fmt.Println("No stacks enabled")
+			fmt.Println("\nRun: homelabctl vars example --all")
+		}
+		return nil
+	}
+
+	stackNames, err = stacks.SortByCategory(stackNames)
+	if err != nil {
+		return err
+	}
+
+	data, err := stacks.VarsExample(stackNames)
+	if err != nil {
+		return err
+	}
+
+	if toStdout {
+		fmt.Print(string(data))
+		return nil
+	}
+
+	if err := os.WriteFile(paths.InventoryExample, data, paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", paths.InventoryExample, err)
+	}
+
+	ui.Success("Wrote %s (%d stack(s))", paths.InventoryExample, len(stackNames))
+	fmt.Printf("  Copy the values to change into %s\n", paths.InventoryVars)
+	return nil
+}
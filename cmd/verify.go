@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/pipeline"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Verify checks that the deployment matches the last generate: every service of the
+// enabled stacks has a running container on the current image and config hash, and the
+// rendered config files were not edited or deleted since
+func Verify(args []string) error {
+	usage := "usage: homelabctl verify [stack]..."
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+		}
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := pipeline.LoadGeneratedManifest()
+	if err != nil {
+		return errors.Wrap(err, "no record of the last generate", "Run: homelabctl generate").WithClass(errors.ClassNotFound)
+	}
+
+	// Containers deployed from another environment's output differ from this one's
+	if env := inventory.Environment(); manifest.Environment != env {
+		addWarnings(fmt.Sprintf("runtime/ was generated for environment %q, not %q: run generate first", manifest.Environment, env))
+	}
+
+	targets, err := verifyTargets(args)
+	if err != nil {
+		return err
+	}
+
+	states, err := containerStates()
+	if err != nil {
+		return err
+	}
+
+	// Image IDs the tags currently point to, looked up once per image
+	imageIDs := make(map[string]string)
+	imageID := func(image string) string {
+		if id, ok := imageIDs[image]; ok {
+			return id
+		}
+		id, _ := dockerOutput("image", "inspect", "--format", "{{.Id}}", image)
+		imageIDs[image] = id
+		return id
+	}
+
+	fmt.Printf("Verifying %d stack(s)...\n", len(targets))
+
+	problems := 0
+	var failed []string
+	for _, stackName := range targets {
+		services := stackServicesIn(generated, stackName)
+		found := verifyServices(generated, services, states, imageID)
+		found = append(found, verifyGeneratedFiles(manifest.Stacks[stackName].Files)...)
+
+		if len(found) == 0 {
+			ui.Success("%s: %d service(s) as declared", stackName, len(services))
+			continue
+		}
+
+		fmt.Printf("⨯ %s:\n", stackName)
+		for _, problem := range found {
+			fmt.Printf("  - %s\n", problem)
+		}
+		problems += len(found)
+		failed = append(failed, stackName)
+	}
+
+	if problems > 0 {
+		return errors.New(
+			fmt.Sprintf("%d problem(s) found in %d stack(s): %s", problems, len(failed), strings.Join(failed, ", ")),
+			"Restore edited files and redeploy: homelabctl generate && homelabctl deploy",
+			"Check stopped services: homelabctl logs <service>",
+		).WithClass(errors.ClassValidation)
+	}
+
+	ui.Blank()
+	ui.Success("Deployment matches the last generate")
+	return nil
+}
+
+// verifyTargets returns the stacks to verify: the given ones, or every enabled stack
+func verifyTargets(args []string) ([]string, error) {
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read enabled stacks: %w", err)
+	}
+	if len(args) == 0 {
+		sort.Strings(enabled)
+		return enabled, nil
+	}
+
+	for _, stackName := range args {
+		if !fs.IsStackEnabled(stackName) {
+			return nil, errors.New(
+				fmt.Sprintf("stack '%s' is not enabled", stackName),
+				"Run: homelabctl list",
+			).WithClass(errors.ClassNotFound)
+		}
+	}
+	return args, nil
+}
+
+// stackServicesIn returns the generated services labeled with a stack, sorted
+func stackServicesIn(generated *compose.ComposeFile, stackName string) []string {
+	var services []string
+	for svc := range generated.Services {
+		if compose.ServiceLabels(generated, svc)[compose.LabelStack] == stackName {
+			services = append(services, svc)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// verifyServices compares the containers of each service with the generated compose file
+// imageID returns the ID a service's image reference points to locally ("" if unknown)
+func verifyServices(generated *compose.ComposeFile, services []string, states map[string][]containerState, imageID func(string) string) []string {
+	images := compose.ServiceImages(generated, services)
+
+	var problems []string
+	for _, svc := range services {
+		containers := states[svc]
+		if len(containers) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: no container (run: homelabctl deploy)", svc))
+			continue
+		}
+
+		wantHash := compose.ServiceLabels(generated, svc)[compose.LabelConfigHash]
+		for _, c := range containers {
+			switch {
+			case c.Status == "exited" && c.ExitCode == "0":
+				// One-shot container that completed
+			case c.Status != "running":
+				problems = append(problems, fmt.Sprintf("%s: %s (exit code %s)", svc, c.Status, c.ExitCode))
+			case c.Health == "unhealthy":
+				problems = append(problems, fmt.Sprintf("%s: unhealthy", svc))
+			}
+
+			if image, ok := images[svc]; ok {
+				if want := imageID(image); want != "" && c.Image != want {
+					problems = append(problems, fmt.Sprintf("%s: runs %s, not the current %s image %s (run: homelabctl deploy)",
+						svc, shortImageID(c.Image), image, shortImageID(want)))
+				}
+			}
+
+			if c.ConfigHash != wantHash {
+				problems = append(problems, fmt.Sprintf("%s: config changed since it was deployed (run: homelabctl deploy)", svc))
+			}
+		}
+	}
+
+	return problems
+}
+
+// verifyGeneratedFiles compares rendered files with the digests recorded by generate
+func verifyGeneratedFiles(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for file := range files {
+		names = append(names, file)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	for _, file := range names {
+		digest, err := pipeline.FileDigest(file)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: missing (run: homelabctl generate)", file))
+			continue
+		}
+		if digest != files[file] {
+			problems = append(problems, fmt.Sprintf("%s: edited since the last generate", file))
+		}
+	}
+
+	return problems
+}
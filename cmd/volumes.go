@@ -0,0 +1,396 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// migrationHelperImage is the image used to copy volume data
+const migrationHelperImage = "alpine:3"
+
+// Volumes manages stack volumes
+func Volumes(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: homelabctl volumes migrate <old> <new> [--dry-run] [--remove-old] | volumes check [stack]")
+	}
+
+	switch args[0] {
+	case "migrate":
+		return volumesMigrate(args[1:])
+	case "check":
+		return volumesCheck(args[1:])
+	default:
+		return fmt.Errorf("unknown volumes subcommand: %s (available: migrate, check)", args[0])
+	}
+}
+
+// volumesMigrate moves data from one named volume (or bind path) to another:
+// stop users, copy with ownership preserved, update stack.yaml, regenerate and restart
+func volumesMigrate(args []string) error {
+	dryRun := false
+	removeOld := false
+	var positional []string
+
+	for _, arg := range args {
+		switch arg {
+		case "--dry-run":
+			dryRun = true
+		case "--remove-old":
+			removeOld = true
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	if len(positional) != 2 {
+		return fmt.Errorf("usage: homelabctl volumes migrate <old> <new> [--dry-run] [--remove-old]")
+	}
+	oldName, newName := positional[0], positional[1]
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	bindMode := isBindPath(oldName)
+	if bindMode != isBindPath(newName) {
+		return fmt.Errorf("cannot migrate between a named volume and a bind path (%s → %s)", oldName, newName)
+	}
+	if bindMode {
+		// As written in the stacks, so the references match; "./data" cleaned is "data"
+		oldName = strings.TrimSuffix(oldName, "/")
+		newName = strings.TrimSuffix(newName, "/")
+	}
+
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return err
+	}
+
+	services := compose.ServicesUsingVolume(generated, oldName)
+	if !bindMode {
+		if _, declared := generated.Volumes[oldName]; !declared && len(services) == 0 {
+			return errors.New(
+				fmt.Sprintf("volume '%s' not found in %s", oldName, paths.DockerCompose),
+				"Use the volume key from the compose template (not the docker volume name)",
+				"Run: homelabctl generate",
+			).WithClass(errors.ClassNotFound)
+		}
+	}
+
+	affectedStacks, err := stacksReferencing(generated, services, oldName)
+	if err != nil {
+		return err
+	}
+
+	// Templates hardcoding the old name would recreate it on the next generate
+	if hardcoded := templatesReferencing(affectedStacks, oldName); len(hardcoded) > 0 {
+		context := []string{"Templates still referencing the old name:"}
+		for _, ref := range hardcoded {
+			context = append(context, "  "+ref)
+		}
+		return errors.New(
+			fmt.Sprintf("compose templates reference '%s' directly", oldName),
+			fmt.Sprintf("Change these references to '%s' (or to a stack variable) first", newName),
+			"Then re-run: homelabctl volumes migrate "+oldName+" "+newName,
+		).WithContext(context...)
+	}
+
+	project := composeProjectName()
+	srcMount, dstMount := oldName, newName
+	if bindMode {
+		if srcMount, err = bindMountPath(oldName); err != nil {
+			return err
+		}
+		if dstMount, err = bindMountPath(newName); err != nil {
+			return err
+		}
+	} else {
+		srcMount = compose.VolumeName(generated, project, oldName)
+		dstMount = project + "_" + newName
+	}
+
+	fmt.Printf("Migrating %s → %s\n", oldName, newName)
+	if len(services) > 0 {
+		fmt.Printf("  Services to stop and restart: %s\n", strings.Join(services, ", "))
+	}
+	fmt.Printf("  Copy: %s → %s (ownership preserved)\n", srcMount, dstMount)
+	if len(affectedStacks) > 0 {
+		fmt.Printf("  Update stack.yaml: %s\n", strings.Join(affectedStacks, ", "))
+	}
+
+	if dryRun {
+		fmt.Println("\nDry run: nothing changed")
+		return nil
+	}
+
+	if len(services) > 0 {
+		fmt.Println("\nStopping services...")
+		if err := runCompose(append([]string{"stop"}, services...)...); err != nil {
+			return fmt.Errorf("docker compose stop failed: %w", err)
+		}
+	}
+
+	if !bindMode {
+		// Label the volume so docker compose adopts it as its own
+		if _, err := dockerOutput("volume", "create",
+			"--label", "com.docker.compose.project="+project,
+			"--label", "com.docker.compose.volume="+newName,
+			dstMount); err != nil {
+			return fmt.Errorf("failed to create volume %s: %w", dstMount, err)
+		}
+	}
+
+	fmt.Println("Copying data...")
+	if _, err := dockerOutput("run", "--rm",
+		"-v", srcMount+":/from:ro",
+		"-v", dstMount+":/to",
+		migrationHelperImage, "sh", "-c", "cp -a /from/. /to/"); err != nil {
+		return errors.Wrap(err, "failed to copy data",
+			fmt.Sprintf("Services were stopped; restart them with: homelabctl up -d %s", strings.Join(services, " ")),
+			fmt.Sprintf("The source %s was not modified", srcMount),
+		).WithClass(errors.ClassDocker)
+	}
+
+	for _, stackName := range affectedStacks {
+		count, err := stacks.ReplaceStackValue(stackName, oldName, newName)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			ui.Detail("✓ Updated %d reference(s) in stacks/%s/stack.yaml", count, stackName)
+		}
+	}
+
+	fmt.Println()
+	if err := Generate(); err != nil {
+		return err
+	}
+
+	if len(services) > 0 {
+		fmt.Println("\nStarting services...")
+		e, err := projectEngine()
+		if err != nil {
+			return err
+		}
+		if err := e.Up(services, engine.UpOptions{}); err != nil {
+			return fmt.Errorf("docker compose up failed: %w", err)
+		}
+	}
+
+	if removeOld && !bindMode {
+		if _, err := dockerOutput("volume", "rm", srcMount); err != nil {
+			fmt.Printf("Warning: failed to remove old volume %s: %v\n", srcMount, err)
+		} else {
+			ui.Success("Removed old volume %s", srcMount)
+		}
+	}
+
+	ui.Blank()
+	ui.Success("Migrated %s → %s", oldName, newName)
+	if !removeOld {
+		fmt.Printf("  The old data was kept in %s; remove it once verified\n", srcMount)
+	}
+	return nil
+}
+
+// volumesCheck mounts each network share declared by the enabled stacks (or one stack)
+// in a throwaway container, so an unreachable server or a wrong export shows before deploy
+func volumesCheck(args []string) error {
+	usage := "usage: homelabctl volumes check [stack]"
+	if len(args) > 1 || (len(args) == 1 && strings.HasPrefix(args[0], "-")) {
+		return fmt.Errorf("unexpected argument: %s (%s)", args[len(args)-1], usage)
+	}
+
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	stackNames, err := fs.GetEnabledStacks()
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		if !fs.IsStackEnabled(args[0]) {
+			return errors.New(
+				fmt.Sprintf("stack '%s' is not enabled", args[0]),
+				fmt.Sprintf("Run: homelabctl enable %s", args[0]),
+			).WithClass(errors.ClassNotFound)
+		}
+		stackNames = []string{args[0]}
+	}
+
+	// Share options may reference .env variables, as compose would resolve them
+	envFile := ""
+	if _, err := os.Stat(".env"); err == nil {
+		envFile = ".env"
+	}
+	env, err := engine.ProjectEnv(envFile)
+	if err != nil {
+		return err
+	}
+
+	checked, failed := 0, 0
+	for _, stackName := range stackNames {
+		stack, err := stacks.LoadStack(stackName)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range stack.ShareNames() {
+			share := stack.Persistence.Shares[name]
+			checked++
+			if err := checkShare(name, share, env); err != nil {
+				failed++
+				fmt.Printf("  ✗ %s/%s (%s): %v\n", stackName, name, share, err)
+				continue
+			}
+			ui.Detail("✓ %s/%s (%s)", stackName, name, share)
+		}
+	}
+
+	if checked == 0 {
+		fmt.Println("No network shares declared")
+		return nil
+	}
+	if failed > 0 {
+		return errors.New(
+			fmt.Sprintf("%d of %d share(s) could not be mounted", failed, checked),
+			"Check that the server is reachable from the Docker host and exports the path to it",
+			"Check the mount options in the persistence section of stack.yaml",
+		).WithClass(errors.ClassDocker)
+	}
+
+	ui.Blank()
+	ui.Success("All %d share(s) mounted", checked)
+	return nil
+}
+
+// checkShare creates a temporary volume for a share and mounts it read-only in a container
+func checkShare(name string, share stacks.Share, env map[string]string) error {
+	volume := "homelabctl-check-" + name
+	create := []string{"volume", "create", "--driver", "local"}
+	opts := share.DriverOpts()
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := engine.Interpolate(fmt.Sprint(opts[key]), env)
+		if err != nil {
+			return fmt.Errorf("driver option %s: %w", key, err)
+		}
+		create = append(create, "--opt", key+"="+value)
+	}
+
+	if _, err := dockerOutput(append(create, volume)...); err != nil {
+		return err
+	}
+	defer dockerOutput("volume", "rm", "--force", volume)
+
+	// The local driver mounts the share when a container starts using it
+	_, err := dockerOutput("run", "--rm", "-v", volume+":/mnt:ro", migrationHelperImage, "ls", "/mnt")
+	return err
+}
+
+// isBindPath reports whether a volume argument is a host path rather than a volume name
+func isBindPath(name string) bool {
+	return strings.HasPrefix(name, "/") || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~")
+}
+
+// bindMountPath returns the host directory of a bind path as docker compose resolves
+// it: ~ is the home directory, and relative paths are relative to runtime/, where the
+// compose file is
+func bindMountPath(name string) (string, error) {
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve %s: %w", name, err)
+		}
+		return filepath.Join(home, name[1:]), nil
+	}
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name), nil
+	}
+	abs, err := filepath.Abs(filepath.Join(paths.Runtime, name))
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %s: %w", name, err)
+	}
+	return abs, nil
+}
+
+// stacksReferencing returns the stacks owning the given services plus stacks
+// whose persistence section declares the volume or path
+func stacksReferencing(generated *compose.ComposeFile, services []string, name string) ([]string, error) {
+	found := make(map[string]bool)
+
+	for _, svc := range services {
+		if stackName := compose.ServiceLabels(generated, svc)[compose.LabelStack]; stackName != "" {
+			found[stackName] = true
+		}
+	}
+
+	available, err := fs.GetAvailableStacks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stackName := range available {
+		stack, err := stacks.LoadStack(stackName)
+		if err != nil {
+			continue
+		}
+		entries := append(append(stack.Persistence.Volumes, stack.Persistence.Paths...), stack.ShareNames()...)
+		for _, entry := range entries {
+			if entry == name {
+				found[stackName] = true
+			}
+		}
+	}
+
+	result := make([]string, 0, len(found))
+	for stackName := range found {
+		result = append(result, stackName)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// templatesReferencing lists template lines ("file:line") mentioning name as a whole token
+func templatesReferencing(stackNames []string, name string) []string {
+	pattern := regexp.MustCompile(`(^|[^A-Za-z0-9_.\-/])` + regexp.QuoteMeta(name) + `($|[^A-Za-z0-9_\-])`)
+
+	var refs []string
+	for _, stackName := range stackNames {
+		template := paths.StackComposeTemplate(stackName)
+		data, err := os.ReadFile(template)
+		if err != nil {
+			continue
+		}
+
+		for i, line := range strings.Split(string(data), "\n") {
+			if pattern.MatchString(line) {
+				refs = append(refs, fmt.Sprintf("%s:%d: %s", template, i+1, strings.TrimSpace(line)))
+			}
+		}
+	}
+
+	return refs
+}
@@ -0,0 +1,232 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// waitInterval is the delay between two container state checks
+var waitInterval = 2 * time.Second
+
+// containerState is the state of one container, from docker inspect
+type containerState struct {
+	Service    string
+	Status     string // created, running, restarting, exited, dead...
+	Health     string // healthy, unhealthy, starting; empty without a healthcheck
+	ExitCode   string
+	Image      string // ID of the image the container runs
+	ConfigHash string // homelabctl.config-hash label
+}
+
+// Wait blocks until every container of the given services and stacks is running,
+// and healthy when it has a healthcheck
+func Wait(args []string) error {
+	usage := "usage: homelabctl wait <service|stack>... [--timeout 120s]"
+	timeout := 120 * time.Second
+	var targets []string
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--timeout" || strings.HasPrefix(arg, "--timeout="):
+			value := strings.TrimPrefix(arg, "--timeout=")
+			if arg == "--timeout" {
+				if i+1 >= len(args) {
+					return fmt.Errorf("--timeout requires a value (%s)", usage)
+				}
+				value = args[i+1]
+				i++
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid --timeout value: %s (e.g. 120s, 5m)", value)
+			}
+			timeout = d
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("unknown flag: %s (%s)", arg, usage)
+		default:
+			targets = append(targets, arg)
+		}
+	}
+
+	if len(targets) == 0 {
+		return fmt.Errorf("%s", usage)
+	}
+
+	services, err := waitServices(targets)
+	if err != nil {
+		return err
+	}
+
+	ui.Step("Waiting for %d service(s): %s (timeout %s)", len(services), strings.Join(services, ", "), timeout)
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	ready := make(map[string]bool)
+
+	for {
+		states, err := containerStates()
+		if err != nil {
+			return err
+		}
+
+		var pending []string
+		for _, svc := range services {
+			if ready[svc] {
+				continue
+			}
+
+			done, state, err := serviceReadiness(svc, states[svc])
+			if err != nil {
+				return err
+			}
+			if done {
+				ready[svc] = true
+				ui.Success("%s %s after %s", svc, state, time.Since(start).Round(time.Second))
+				continue
+			}
+			pending = append(pending, fmt.Sprintf("%s (%s)", svc, state))
+		}
+
+		if len(pending) == 0 {
+			ui.Success("All %d service(s) ready", len(services))
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New(
+				fmt.Sprintf("timed out after %s waiting for %d service(s)", timeout, len(pending)),
+				"Check the logs: homelabctl logs "+strings.Fields(pending[0])[0],
+				"Raise the limit: --timeout 5m",
+			).WithClass(errors.ClassDocker).WithContext(append([]string{"Not ready:"}, pending...)...)
+		}
+
+		time.Sleep(waitInterval)
+	}
+}
+
+// waitServices resolves stack and service names to the generated services to wait for
+func waitServices(targets []string) ([]string, error) {
+	if _, err := os.Stat(paths.DockerCompose); err != nil {
+		return nil, fmt.Errorf("no runtime/docker-compose.yml found - run 'generate' first")
+	}
+
+	generated, err := compose.LoadComposeFile(paths.DockerCompose)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var services []string
+	add := func(svc string) {
+		if !seen[svc] {
+			seen[svc] = true
+			services = append(services, svc)
+		}
+	}
+
+	for _, target := range targets {
+		if fs.StackExists(target) {
+			stackServices, err := resolveStackServices(target)
+			if err != nil {
+				return nil, err
+			}
+			for _, svc := range stackServices {
+				add(svc)
+			}
+			continue
+		}
+
+		_, svc, err := resolveService(target)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := generated.Services[svc]; !ok {
+			return nil, errors.New(
+				fmt.Sprintf("service '%s' is not in runtime/docker-compose.yml", svc),
+				"Run: homelabctl generate",
+				fmt.Sprintf("Check whether it is disabled: homelabctl enable -s %s", target),
+			).WithClass(errors.ClassNotFound)
+		}
+		add(svc)
+	}
+
+	sort.Strings(services)
+	return services, nil
+}
+
+// containerStates returns the state of the deployment's containers, grouped by service
+func containerStates() (map[string][]containerState, error) {
+	e, err := projectEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := e.Ps()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string][]containerState)
+	for _, c := range containers {
+		state := containerState{
+			Service:    c.Service,
+			Status:     c.Status,
+			Health:     c.Health,
+			ExitCode:   c.ExitCode,
+			Image:      c.Image,
+			ConfigHash: c.Labels[compose.LabelConfigHash],
+		}
+		states[state.Service] = append(states[state.Service], state)
+	}
+
+	return states, nil
+}
+
+// serviceReadiness reports whether all containers of a service are ready, with a
+// short description of their state; a container that exited with an error fails the wait
+func serviceReadiness(svc string, states []containerState) (bool, string, error) {
+	if len(states) == 0 {
+		return false, "not created", nil
+	}
+
+	for _, s := range states {
+		if s.Status == "dead" || (s.Status == "exited" && s.ExitCode != "0") {
+			return false, "", errors.New(
+				fmt.Sprintf("service '%s' stopped: %s (exit code %s)", svc, s.Status, s.ExitCode),
+				"Check the logs: homelabctl logs "+svc,
+			).WithClass(errors.ClassDocker)
+		}
+	}
+
+	for _, s := range states {
+		// One-shot containers that completed successfully count as ready
+		if s.Status == "exited" {
+			continue
+		}
+		if s.Status != "running" || (s.Health != "" && s.Health != "healthy") {
+			return false, s.describe(), nil
+		}
+	}
+
+	return true, states[0].describe(), nil
+}
+
+// describe renders a state as "running" or "running, healthy"
+func (s containerState) describe() string {
+	if s.Status == "exited" {
+		return "completed"
+	}
+	if s.Health == "" {
+		return s.Status
+	}
+	return s.Status + ", " + s.Health
+}
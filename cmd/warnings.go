@@ -0,0 +1,21 @@
+package cmd
+
+import "os"
+
+// collectedWarnings holds non-fatal problems reported by the current command
+var collectedWarnings []string
+
+// addWarnings records non-fatal problems to report once the command ends
+func addWarnings(warnings ...string) {
+	collectedWarnings = append(collectedWarnings, warnings...)
+}
+
+// Warnings returns the non-fatal problems collected while running a command
+func Warnings() []string {
+	return collectedWarnings
+}
+
+// strictMode reports whether warnings must fail the command (--strict)
+func strictMode() bool {
+	return os.Getenv("HOMELAB_STRICT") == "1"
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+	"github.com/monkeymonk/homelabctl/internal/watch"
+)
+
+// defaultWatchDebounce is how long generate --watch waits for changes to settle
+const defaultWatchDebounce = 500 * time.Millisecond
+
+// watchedDirs are the directories whose changes alter the generated files
+var watchedDirs = []string{paths.Stacks, paths.Inventory, paths.Enabled, paths.Secrets}
+
+// watchIgnored are the files of watchedDirs a run writes itself
+var watchIgnored = []string{paths.InventoryState}
+
+// watchRepository runs run once, then again each time the files of watchedDirs change,
+// until interrupted. A failed run is reported and the watch goes on, so a template can
+// be fixed and saved again
+func watchRepository(run func() error, debounce time.Duration) error {
+	if err := fs.VerifyRepository(); err != nil {
+		return err
+	}
+
+	runOnce := func() {
+		err := run()
+		// Each run reports its own warnings, as main does once for other commands
+		if warnings := Warnings(); len(warnings) > 0 {
+			fmt.Fprint(os.Stderr, "\n"+errors.FormatWarnings(warnings))
+			collectedWarnings = nil
+		}
+		if err != nil {
+			if enhanced, ok := err.(*errors.Error); ok {
+				fmt.Fprint(os.Stderr, enhanced.Error())
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}
+		ui.Blank()
+		ui.Step("Watching %s for changes (Ctrl+C to stop)...", strings.Join(watchedDirs, "/, ")+"/")
+	}
+
+	runOnce()
+	return watch.Watch(watchedDirs, watchIgnored, debounce, func(changed []string) bool {
+		ui.Blank()
+		ui.Step("Changed: %s", describeChanges(changed))
+		runOnce()
+		return true
+	})
+}
+
+// describeChanges lists the first changed files, and how many more there are
+func describeChanges(changed []string) string {
+	const shown = 3
+	if len(changed) <= shown {
+		return strings.Join(changed, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(changed[:shown], ", "), len(changed)-shown)
+}
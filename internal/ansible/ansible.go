@@ -0,0 +1,157 @@
+// Package ansible exports the deployment as an Ansible inventory and group variables,
+// so the OS under it (packages, mounts, firewall ports) is configured from the same stacks
+package ansible
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/firewall"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/remote"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// Group is the inventory group of the deployment hosts
+const Group = "homelab"
+
+// header is the comment on top of the exported files
+const header = "# Generated by homelabctl export ansible - do not edit\n"
+
+// Vars are the group variables of the deployment hosts
+type Vars struct {
+	Project       string         `yaml:"homelab_project"`
+	Packages      []string       `yaml:"homelab_packages"`       // Debian/Ubuntu package names
+	Directories   []Directory    `yaml:"homelab_directories"`    // Persistence paths to create
+	Shares        []Share        `yaml:"homelab_shares"`         // Network shares, in ansible.posix.mount terms
+	FirewallPorts []FirewallPort `yaml:"homelab_firewall_ports"` // Ports published on all or public interfaces
+}
+
+// Directory is a host path a stack persists data in
+type Directory struct {
+	Path  string `yaml:"path"`
+	Stack string `yaml:"stack"`
+}
+
+// Share is a network share a stack mounts as a volume
+type Share struct {
+	Name   string `yaml:"name"`
+	Stack  string `yaml:"stack"`
+	Src    string `yaml:"src"`    // server:/export or //server/share
+	Fstype string `yaml:"fstype"` // nfs or cifs
+	Opts   string `yaml:"opts,omitempty"`
+}
+
+// FirewallPort is a port a service publishes on the host
+type FirewallPort struct {
+	Port    string `yaml:"port"` // Port or range, e.g. 8000-8005
+	Proto   string `yaml:"proto"`
+	Service string `yaml:"service"`
+	Stack   string `yaml:"stack"`
+}
+
+// Inventory returns the inventory of the hosts of inventory/hosts.yaml, or of the
+// local machine (with the repository at localPath) when none is defined
+func Inventory(hosts []*remote.Host, localPath string) map[string]interface{} {
+	members := make(map[string]interface{})
+	for _, h := range hosts {
+		vars := map[string]interface{}{"homelab_path": h.Path}
+		address := h.SSH
+		if user, host, ok := strings.Cut(address, "@"); ok {
+			vars["ansible_user"] = user
+			address = host
+		}
+		vars["ansible_host"] = address
+		if h.Port != 0 {
+			vars["ansible_port"] = h.Port
+		}
+		if h.Identity != "" {
+			vars["ansible_ssh_private_key_file"] = h.Identity
+		}
+		members[h.Name] = vars
+	}
+
+	if len(members) == 0 {
+		members["localhost"] = map[string]interface{}{"ansible_connection": "local", "homelab_path": localPath}
+	}
+
+	return map[string]interface{}{
+		"all": map[string]interface{}{
+			"children": map[string]interface{}{
+				Group: map[string]interface{}{"hosts": members},
+			},
+		},
+	}
+}
+
+// GroupVars derives the group variables from the enabled stacks and the generated compose file
+// remoteHosts adds rsync, which --host needs on the deployment hosts
+func GroupVars(project string, enabled []*stacks.Stack, generated *compose.ComposeFile, remoteHosts bool) *Vars {
+	vars := &Vars{Project: project, Packages: []string{}, Directories: []Directory{}, Shares: []Share{}, FirewallPorts: []FirewallPort{}}
+	packages := make(map[string]bool)
+	if remoteHosts {
+		packages["rsync"] = true
+	}
+
+	for _, stack := range enabled {
+		for _, path := range stack.Persistence.Paths {
+			if filepath.IsAbs(path) {
+				vars.Directories = append(vars.Directories, Directory{Path: path, Stack: stack.Name})
+			}
+		}
+
+		for _, name := range stack.ShareNames() {
+			share := stack.Persistence.Shares[name]
+			entry := Share{Name: name, Stack: stack.Name, Opts: strings.Join(share.Options, ",")}
+			if share.Type == stacks.ShareSMB {
+				entry.Src = "//" + share.Server + "/" + strings.TrimPrefix(share.Export, "/")
+				entry.Fstype = "cifs"
+				packages["cifs-utils"] = true
+			} else {
+				entry.Src = share.Server + ":" + share.Export
+				entry.Fstype = stacks.ShareNFS
+				packages["nfs-common"] = true
+			}
+			vars.Shares = append(vars.Shares, entry)
+		}
+	}
+
+	for _, rule := range firewall.Rules(generated) {
+		vars.FirewallPorts = append(vars.FirewallPorts, FirewallPort{Port: rule.Port, Proto: rule.Proto, Service: rule.Service, Stack: rule.Stack})
+	}
+
+	for name := range packages {
+		vars.Packages = append(vars.Packages, name)
+	}
+	sort.Strings(vars.Packages)
+
+	return vars
+}
+
+// Write writes inventory.yml and group_vars/homelab.yml to dir
+func Write(dir string, inventory map[string]interface{}, vars *Vars) error {
+	files := map[string]interface{}{
+		filepath.Join(dir, "inventory.yml"):            inventory,
+		filepath.Join(dir, "group_vars", Group+".yml"): vars,
+	}
+
+	for path, content := range files {
+		data, err := yaml.Marshal(content)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s: %w", path, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), paths.DirPermissions); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+		}
+		if err := os.WriteFile(path, append([]byte(header), data...), paths.FilePermissions); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
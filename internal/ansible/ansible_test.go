@@ -0,0 +1,96 @@
+package ansible
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/remote"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestInventory(t *testing.T) {
+	hosts := []*remote.Host{{Name: "nas", SSH: "admin@nas.lan", Port: 2222, Path: "/opt/homelab"}}
+	inventory := Inventory(hosts, "/home/me/homelab")
+
+	members := inventory["all"].(map[string]interface{})["children"].(map[string]interface{})[Group].(map[string]interface{})["hosts"].(map[string]interface{})
+	want := map[string]interface{}{"ansible_host": "nas.lan", "ansible_user": "admin", "ansible_port": 2222, "homelab_path": "/opt/homelab"}
+	if !reflect.DeepEqual(members["nas"], want) {
+		t.Errorf("nas = %v, want %v", members["nas"], want)
+	}
+
+	local := Inventory(nil, "/home/me/homelab")
+	members = local["all"].(map[string]interface{})["children"].(map[string]interface{})[Group].(map[string]interface{})["hosts"].(map[string]interface{})
+	if vars := members["localhost"].(map[string]interface{}); vars["ansible_connection"] != "local" {
+		t.Errorf("Inventory() without hosts = %v, want localhost", local)
+	}
+}
+
+func TestGroupVars(t *testing.T) {
+	media := &stacks.Stack{Name: "media"}
+	media.Persistence.Paths = []string{"/srv/media", "relative"}
+	media.Persistence.Shares = map[string]stacks.Share{
+		"library": {Type: stacks.ShareNFS, Server: "nas", Export: "/volume1/library", Options: []string{"nfsvers=4"}},
+		"photos":  {Type: stacks.ShareSMB, Server: "nas", Export: "photos"},
+	}
+
+	generated := &compose.ComposeFile{Services: map[string]interface{}{
+		"traefik": map[string]interface{}{
+			"ports":  []interface{}{"80:80", "443:443", "127.0.0.1:8080:8080"},
+			"labels": map[string]interface{}{compose.LabelStack: "core"},
+		},
+		"adguard": map[string]interface{}{
+			"ports":  []interface{}{"53:53/udp", "53:53/tcp"},
+			"labels": map[string]interface{}{compose.LabelStack: "dns"},
+		},
+	}}
+
+	vars := GroupVars("homelab", []*stacks.Stack{media}, generated, true)
+
+	if want := []string{"cifs-utils", "nfs-common", "rsync"}; !reflect.DeepEqual(vars.Packages, want) {
+		t.Errorf("Packages = %v, want %v", vars.Packages, want)
+	}
+	if len(vars.Directories) != 1 || vars.Directories[0].Path != "/srv/media" {
+		t.Errorf("Directories = %+v, want only the absolute path", vars.Directories)
+	}
+	wantShares := []Share{
+		{Name: "library", Stack: "media", Src: "nas:/volume1/library", Fstype: "nfs", Opts: "nfsvers=4"},
+		{Name: "photos", Stack: "media", Src: "//nas/photos", Fstype: "cifs"},
+	}
+	if !reflect.DeepEqual(vars.Shares, wantShares) {
+		t.Errorf("Shares = %+v, want %+v", vars.Shares, wantShares)
+	}
+
+	var ports []string
+	for _, p := range vars.FirewallPorts {
+		ports = append(ports, p.Port+"/"+p.Proto+":"+p.Stack)
+	}
+	if got := strings.Join(ports, " "); got != "53/udp:dns 53/tcp:dns 80/tcp:core 443/tcp:core" {
+		t.Errorf("FirewallPorts = %s, want loopback bindings skipped", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	vars := GroupVars("homelab", nil, &compose.ComposeFile{}, false)
+	if err := Write(tmpDir, Inventory(nil, tmpDir), vars); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "group_vars", "homelab.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# Generated by homelabctl") || !strings.Contains(string(data), "homelab_firewall_ports: []") {
+		t.Errorf("group_vars = %s", data)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "inventory.yml")); err != nil {
+		t.Errorf("inventory.yml not written: %v", err)
+	}
+}
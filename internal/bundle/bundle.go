@@ -0,0 +1,215 @@
+// Package bundle packs a generated deployment into a single tarball for offline hosts
+// A bundle holds the runtime/ tree, .env, and the images saved with docker save, so
+// the target host deploys without registry access or a copy of the repository
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Entry names inside a bundle
+const (
+	ManifestFile = "bundle.yaml"
+	ImagesFile   = "images.tar" // docker save archive
+	EnvFile      = ".env"
+)
+
+// skipped are runtime/ entries that only matter on the machine that generated them
+var skipped = map[string]bool{".lock": true, ".staging": true, ".staging-previous": true, "history": true, "uptime": true}
+
+// Manifest describes a bundle
+type Manifest struct {
+	Created time.Time `yaml:"created"`
+	Project string    `yaml:"project"` // Compose project name
+	Images  []string  `yaml:"images"`
+}
+
+// Create writes a gzipped tarball with the manifest, the docker save archive at
+// imagesTar, runtime/ and .env when present
+func Create(out string, manifest Manifest, imagesTar string) (err error) {
+	file, err := os.OpenFile(out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, paths.SecureFilePermissions)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", out, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(out)
+		}
+	}()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: ManifestFile, Mode: paths.FilePermissions, Size: int64(len(data)), ModTime: manifest.Created}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+
+	if err := addFile(tw, imagesTar, ImagesFile); err != nil {
+		return err
+	}
+	if err := addRuntime(tw); err != nil {
+		return err
+	}
+	if _, err := os.Stat(EnvFile); err == nil {
+		if err := addFile(tw, EnvFile, EnvFile); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// addRuntime adds the runtime/ tree, without local-only entries
+func addRuntime(tw *tar.Writer) error {
+	return filepath.Walk(paths.Runtime, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(paths.Runtime, path)
+		if err != nil {
+			return err
+		}
+		if skipped[strings.Split(filepath.ToSlash(rel), "/")[0]] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		return addFile(tw, path, filepath.ToSlash(path))
+	})
+}
+
+// addFile copies a file into the archive under name
+func addFile(tw *tar.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("failed to add %s to the bundle: %w", path, err)
+	}
+	return nil
+}
+
+// Extract unpacks a bundle: runtime/ files are written in place, .env only when there is
+// none yet, and the image archive is streamed to loadImages (e.g. docker load)
+func Extract(path string, loadImages func(io.Reader) error) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a bundle: %w", path, err)
+	}
+	defer gz.Close()
+
+	var manifest *Manifest
+	loaded := false
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		switch {
+		case name == ManifestFile:
+			manifest = &Manifest{}
+			if err := yaml.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", ManifestFile, err)
+			}
+		case name == ImagesFile:
+			if err := loadImages(tr); err != nil {
+				return nil, err
+			}
+			loaded = true
+		case name == EnvFile:
+			if _, err := os.Stat(EnvFile); err == nil {
+				continue // Keep the host's own settings
+			}
+			if err := writeFile(name, tr, paths.SecureFilePermissions); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(name, paths.Runtime+string(filepath.Separator)) && header.Typeflag == tar.TypeReg:
+			if err := writeFile(name, tr, os.FileMode(header.Mode).Perm()); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unexpected entry in bundle: %s", header.Name)
+		}
+	}
+
+	if manifest == nil || !loaded {
+		return nil, fmt.Errorf("%s is not a complete bundle (missing %s or %s)", path, ManifestFile, ImagesFile)
+	}
+	return manifest, nil
+}
+
+// writeFile writes an archive entry, creating its directory
+func writeFile(path string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), paths.DirPermissions); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,80 @@
+package bundle
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestCreateExtract(t *testing.T) {
+	src, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	restoreDir := testutil.Chdir(t, src)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "runtime/docker-compose.yml", "services: {}\n")
+	testutil.WriteFile(t, "runtime/monitoring/grafana.ini", "[server]\n")
+	testutil.WriteFile(t, "runtime/history/1/snapshot.yaml", "local only\n")
+	testutil.WriteFile(t, "runtime/.lock", "")
+	testutil.WriteFile(t, ".env", "TZ=UTC\n")
+	testutil.WriteFile(t, "save.tar", "image layers")
+
+	out := filepath.Join(src, "bundle.tar.gz")
+	manifest := Manifest{Created: time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC), Project: "runtime", Images: []string{"nginx:1.27"}}
+	if err := Create(out, manifest, "save.tar"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	dst, cleanupDst := testutil.TempDir(t)
+	defer cleanupDst()
+	if err := os.Chdir(dst); err != nil {
+		t.Fatal(err)
+	}
+	testutil.WriteFile(t, ".env", "TZ=Europe/Paris\n")
+
+	var loaded string
+	got, err := Extract(out, func(r io.Reader) error {
+		data, err := io.ReadAll(r)
+		loaded = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+
+	if got.Project != "runtime" || strings.Join(got.Images, ",") != "nginx:1.27" || !got.Created.Equal(manifest.Created) {
+		t.Errorf("manifest = %+v", got)
+	}
+	if loaded != "image layers" {
+		t.Errorf("images = %q, want the saved archive", loaded)
+	}
+
+	if data, err := os.ReadFile("runtime/monitoring/grafana.ini"); err != nil || string(data) != "[server]\n" {
+		t.Errorf("grafana.ini = %q, %v", data, err)
+	}
+	for _, local := range []string{"runtime/history", "runtime/.lock"} {
+		if _, err := os.Stat(local); err == nil {
+			t.Errorf("%s should not be bundled", local)
+		}
+	}
+	if data, _ := os.ReadFile(".env"); string(data) != "TZ=Europe/Paris\n" {
+		t.Errorf(".env = %q, the host's own file should be kept", data)
+	}
+}
+
+func TestExtractRejectsIncomplete(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	restoreDir := testutil.Chdir(t, dir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "not-a-bundle.tar.gz", "plain text")
+	if _, err := Extract("not-a-bundle.tar.gz", func(io.Reader) error { return nil }); err == nil {
+		t.Error("Extract() should reject a file that is not a gzipped tarball")
+	}
+}
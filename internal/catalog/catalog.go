@@ -0,0 +1,398 @@
+package catalog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// DefaultURL is the git repository of the curated stack catalog
+const DefaultURL = "https://github.com/monkeymonk/homelabctl-catalog.git"
+
+// EnvCatalog overrides the catalog location (git URL or local directory)
+const EnvCatalog = "HOMELAB_CATALOG"
+
+// DefaultName is the namespace of the configured catalog in requires (catalog/<stack>)
+// Other namespaces are declared in inventory/catalogs.yaml
+const DefaultName = "catalog"
+
+// Catalog layout
+const (
+	// TemplateFile describes a bundle of stacks
+	TemplateFile = "template.yaml"
+	// TemplatesDir holds named bundles: templates/<name>/template.yaml
+	TemplatesDir = "templates"
+	// StacksDir holds the stack definitions shared by all bundles
+	StacksDir = "stacks"
+)
+
+// Template is a curated bundle of stacks used to seed a repository
+type Template struct {
+	Name        string                 `yaml:"name"`
+	Description string                 `yaml:"description"`
+	Stacks      []string               `yaml:"stacks"`
+	Vars        map[string]interface{} `yaml:"vars"`
+}
+
+// Catalog is a checked-out stack catalog
+type Catalog struct {
+	Dir     string
+	Config  *Config // Name, source and signing keys
+	cleanup func()
+}
+
+// Source returns the configured catalog location
+func Source() string {
+	if source := os.Getenv(EnvCatalog); source != "" {
+		return source
+	}
+	return DefaultURL
+}
+
+// Config is a named catalog from inventory/catalogs.yaml
+type Config struct {
+	Name        string `yaml:"-"`
+	Source      string `yaml:"source"`       // Git URL or local directory
+	MinisignKey string `yaml:"minisign_key"` // Public key (or key file) signing SHA256SUMS
+	CosignKey   string `yaml:"cosign_key"`   // Public key file (or KMS URI) signing SHA256SUMS
+	Trusted     bool   `yaml:"trusted"`      // Render its stacks without the template sandbox
+}
+
+// UnmarshalYAML accepts a bare source string as well as a mapping
+func (c *Config) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		c.Source = node.Value
+		return nil
+	}
+
+	type plain Config
+	return node.Decode((*plain)(c))
+}
+
+// catalogsFile is the layout of inventory/catalogs.yaml
+type catalogsFile struct {
+	Catalogs map[string]*Config `yaml:"catalogs"`
+}
+
+// ConfigFor returns a named catalog: its entry in inventory/catalogs.yaml, with the
+// configured catalog as source of DefaultName unless the entry sets one
+func ConfigFor(name string) (*Config, error) {
+	data, err := os.ReadFile(paths.InventoryCatalogs)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryCatalogs, err)
+	}
+
+	var file catalogsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryCatalogs, err)
+	}
+
+	cfg := file.Catalogs[name]
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	cfg.Name = name
+	if cfg.Source == "" && name == DefaultName {
+		cfg.Source = Source()
+	}
+
+	if cfg.Source == "" {
+		return nil, errors.New(
+			fmt.Sprintf("catalog '%s' is not defined", name),
+			fmt.Sprintf("Add it to %s: catalogs.%s: <git-url|dir>", paths.InventoryCatalogs, name),
+			fmt.Sprintf("Or use the configured catalog: %s/<stack>", DefaultName),
+		).WithClass(errors.ClassNotFound)
+	}
+
+	return cfg, nil
+}
+
+// IsGitURL reports whether source looks like a git remote rather than a name or path
+func IsGitURL(source string) bool {
+	return strings.Contains(source, "://") ||
+		strings.HasPrefix(source, "git@") ||
+		strings.HasSuffix(source, ".git")
+}
+
+// Open returns a catalog from a local directory or a shallow git clone
+// Close must be called to remove the clone
+func Open(source string) (*Catalog, error) {
+	if info, err := os.Stat(source); err == nil && info.IsDir() {
+		return &Catalog{Dir: source, Config: &Config{Name: source, Source: source}, cleanup: func() {}}, nil
+	}
+
+	if !IsGitURL(source) {
+		return nil, fmt.Errorf("catalog not found: %s (expected a git URL or a directory)", source)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, errors.New(
+			"git not found in PATH",
+			"Install git to fetch stack templates",
+			fmt.Sprintf("Or point %s at a local catalog directory", EnvCatalog),
+		)
+	}
+
+	dir, err := os.MkdirTemp("", "homelabctl-catalog-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+
+	cmd := exec.Command("git", "clone", "--quiet", "--depth", "1", source, dir)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(dir)
+		return nil, errors.New(
+			fmt.Sprintf("failed to fetch catalog %s", source),
+			"Check the URL and your network connection",
+			fmt.Sprintf("Override the catalog with: %s=<git-url|dir>", EnvCatalog),
+		).WithContext(strings.TrimSpace(stderr.String()))
+	}
+
+	return &Catalog{Dir: dir, Config: &Config{Name: source, Source: source}, cleanup: func() { os.RemoveAll(dir) }}, nil
+}
+
+// OpenConfig opens a named catalog, keeping its signing keys for VerifyStack
+func OpenConfig(cfg *Config) (*Catalog, error) {
+	c, err := Open(cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+	c.Config = cfg
+	return c, nil
+}
+
+// Close removes a cloned catalog
+func (c *Catalog) Close() {
+	c.cleanup()
+}
+
+// Templates returns the names of the bundles in the catalog
+func (c *Catalog) Templates() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(c.Dir, TemplatesDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read catalog templates: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// LoadTemplate reads a named bundle, or the catalog's root template.yaml when name is empty
+func (c *Catalog) LoadTemplate(name string) (*Template, error) {
+	path := filepath.Join(c.Dir, TemplateFile)
+	if name != "" {
+		path = filepath.Join(c.Dir, TemplatesDir, name, TemplateFile)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && name != "" {
+			available, _ := c.Templates()
+			suggestions := []string{"Check the template name"}
+			if len(available) > 0 {
+				suggestions = append(suggestions, "Available templates: "+strings.Join(available, ", "))
+			}
+			return nil, errors.New(fmt.Sprintf("template '%s' not found in catalog", name), suggestions...).WithClass(errors.ClassNotFound)
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", TemplateFile, err)
+	}
+
+	var tmpl Template
+	if err := yaml.Unmarshal(data, &tmpl); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if tmpl.Name == "" {
+		tmpl.Name = name
+	}
+
+	if len(tmpl.Stacks) == 0 {
+		return nil, fmt.Errorf("template %s lists no stacks", tmpl.Name)
+	}
+
+	return &tmpl, nil
+}
+
+// HasStack reports whether the catalog provides a stack
+func (c *Catalog) HasStack(name string) bool {
+	info, err := os.Stat(filepath.Join(c.Dir, StacksDir, name))
+	return err == nil && info.IsDir()
+}
+
+// ResolveStacks expands stack names with their transitive requires
+// Every resulting stack must be present in the catalog, except namespaced requires
+// (other/<stack>) the catalog doesn't provide, which are left to 'enable --with-deps'
+func (c *Catalog) ResolveStacks(names []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+
+	queue := append([]string{}, names...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		if !c.HasStack(name) {
+			return nil, errors.New(
+				fmt.Sprintf("stack '%s' not found in catalog", name),
+				"The template or one of its stacks references a stack the catalog does not provide",
+			).WithClass(errors.ClassNotFound)
+		}
+		result = append(result, name)
+
+		requires, err := c.stackRequires(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range requires {
+			_, local, namespaced := strings.Cut(dep, "/")
+			if !namespaced {
+				local = dep
+			}
+			if !namespaced || c.HasStack(local) {
+				queue = append(queue, local)
+			}
+		}
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
+// stackRequires reads the requires list of a catalog stack
+func (c *Catalog) stackRequires(name string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(c.Dir, StacksDir, name, paths.StackYAML))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stack.yaml for catalog stack %s: %w", name, err)
+	}
+
+	var stack struct {
+		Requires []string `yaml:"requires"`
+	}
+	if err := yaml.Unmarshal(data, &stack); err != nil {
+		return nil, fmt.Errorf("failed to parse stack.yaml for catalog stack %s: %w", name, err)
+	}
+
+	return stack.Requires, nil
+}
+
+// CopyStack copies a catalog stack into the repository's stacks/ directory. Symbolic
+// links are copied as links when they point inside the stack, and refused otherwise,
+// so a catalog cannot copy host files into the repository
+func (c *Catalog) CopyStack(name string) error {
+	src := filepath.Join(c.Dir, StacksDir, name)
+	dst := paths.StackDir(name)
+
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, paths.DirPermissions)
+		case info.Mode()&os.ModeSymlink != 0:
+			return copyLink(src, path, target, name)
+		case !info.Mode().IsRegular():
+			return fmt.Errorf("catalog stack %s: %s is not a regular file", name, rel)
+		}
+
+		return copyFile(path, target, info.Mode().Perm())
+	})
+}
+
+// copyLink recreates the symbolic link at path, relative to where it points, if that is
+// inside the stack directory src
+func copyLink(src, path, dst, name string) error {
+	link, err := os.Readlink(path)
+	if err != nil {
+		return fmt.Errorf("failed to read link %s: %w", path, err)
+	}
+
+	resolved := link
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(path), resolved)
+	}
+	root, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil {
+		return err
+	}
+	inside, err := filepath.Rel(root, resolved)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		rel, _ := filepath.Rel(src, path)
+		return fmt.Errorf("catalog stack %s: %s links outside the stack (%s)", name, rel, link)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	relative, err := filepath.Rel(dir, resolved)
+	if err != nil {
+		return fmt.Errorf("failed to copy link %s: %w", path, err)
+	}
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s: %w", dst, err)
+	}
+	if err := os.Symlink(relative, dst); err != nil {
+		return fmt.Errorf("failed to copy link %s: %w", path, err)
+	}
+	return nil
+}
+
+// copyFile copies a regular file, keeping its permissions
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+
+	return out.Close()
+}
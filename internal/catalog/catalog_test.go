@@ -0,0 +1,191 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+// setupCatalog creates a local catalog with a media-server bundle
+func setupCatalog(t *testing.T, dir string) {
+	t.Helper()
+
+	testutil.WriteFile(t, filepath.Join(dir, "templates/media-server/template.yaml"), `name: media-server
+description: Jellyfin behind Traefik
+stacks:
+  - jellyfin
+vars:
+  timezone: UTC
+`)
+	testutil.WriteFile(t, filepath.Join(dir, "stacks/jellyfin/stack.yaml"), `name: jellyfin
+category: media
+requires:
+  - traefik
+services:
+  - jellyfin
+`)
+	testutil.WriteFile(t, filepath.Join(dir, "stacks/jellyfin/compose.yml.tmpl"), "services: {}\n")
+	testutil.WriteFile(t, filepath.Join(dir, "stacks/traefik/stack.yaml"), `name: traefik
+category: core
+services:
+  - traefik
+`)
+	testutil.WriteFile(t, filepath.Join(dir, "stacks/traefik/compose.yml.tmpl"), "services: {}\n")
+}
+
+func TestIsGitURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://github.com/user/catalog.git": true,
+		"git@github.com:user/catalog.git":     true,
+		"ssh://host/catalog":                  true,
+		"media-server":                        false,
+		"./local-dir":                         false,
+	}
+
+	for source, want := range tests {
+		if got := IsGitURL(source); got != want {
+			t.Errorf("IsGitURL(%q) = %v, want %v", source, got, want)
+		}
+	}
+}
+
+func TestLoadTemplateAndResolveStacks(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	setupCatalog(t, dir)
+
+	cat, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer cat.Close()
+
+	names, err := cat.Templates()
+	if err != nil || len(names) != 1 || names[0] != "media-server" {
+		t.Fatalf("Templates() = %v, %v", names, err)
+	}
+
+	tmpl, err := cat.LoadTemplate("media-server")
+	if err != nil {
+		t.Fatalf("LoadTemplate() error: %v", err)
+	}
+	if tmpl.Vars["timezone"] != "UTC" {
+		t.Errorf("template vars = %v", tmpl.Vars)
+	}
+
+	resolved, err := cat.ResolveStacks(tmpl.Stacks)
+	if err != nil {
+		t.Fatalf("ResolveStacks() error: %v", err)
+	}
+	if strings.Join(resolved, ",") != "jellyfin,traefik" {
+		t.Errorf("ResolveStacks() = %v, want dependencies included", resolved)
+	}
+
+	if _, err := cat.LoadTemplate("missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("LoadTemplate(missing) error = %v", err)
+	}
+}
+
+func TestCopyStack(t *testing.T) {
+	catalogDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	setupCatalog(t, catalogDir)
+
+	repoDir, cleanupRepo := testutil.TempDir(t)
+	defer cleanupRepo()
+	restoreDir := testutil.Chdir(t, repoDir)
+	defer restoreDir()
+
+	cat, err := Open(catalogDir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+
+	if err := cat.CopyStack("jellyfin"); err != nil {
+		t.Fatalf("CopyStack() error: %v", err)
+	}
+
+	for _, file := range []string{"stacks/jellyfin/stack.yaml", "stacks/jellyfin/compose.yml.tmpl"} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("expected %s to be copied: %v", file, err)
+		}
+	}
+}
+
+func TestCopyStack_Symlinks(t *testing.T) {
+	catalogDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	setupCatalog(t, catalogDir)
+
+	repoDir, cleanupRepo := testutil.TempDir(t)
+	defer cleanupRepo()
+	restoreDir := testutil.Chdir(t, repoDir)
+	defer restoreDir()
+
+	stackDir := filepath.Join(catalogDir, StacksDir, "jellyfin")
+	if err := os.Symlink(filepath.Join(stackDir, "compose.yml.tmpl"), filepath.Join(stackDir, "compose.alt.yml.tmpl")); err != nil {
+		t.Fatal(err)
+	}
+
+	cat, err := Open(catalogDir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if err := cat.CopyStack("jellyfin"); err != nil {
+		t.Fatalf("CopyStack() error: %v", err)
+	}
+	if link, err := os.Readlink("stacks/jellyfin/compose.alt.yml.tmpl"); err != nil || link != "compose.yml.tmpl" {
+		t.Errorf("copied link = %q, %v; want a link to compose.yml.tmpl", link, err)
+	}
+
+	secret := filepath.Join(t.TempDir(), "secret")
+	testutil.WriteFile(t, secret, "password\n")
+	if err := os.Symlink(secret, filepath.Join(stackDir, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cat.CopyStack("jellyfin"); err == nil {
+		t.Error("CopyStack() should refuse a link outside the stack")
+	}
+	if _, err := os.Stat("stacks/jellyfin/notes.txt"); !os.IsNotExist(err) {
+		t.Error("CopyStack() should not copy the target of a link outside the stack")
+	}
+}
+
+func TestOpenMissingSource(t *testing.T) {
+	if _, err := Open("not-a-dir-or-url"); err == nil {
+		t.Error("Open() should fail for an unknown source")
+	}
+}
+
+func TestConfigFor(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	t.Setenv(EnvCatalog, "/srv/catalog")
+
+	if cfg, err := ConfigFor(DefaultName); err != nil || cfg.Source != "/srv/catalog" {
+		t.Errorf("ConfigFor(%s) = %+v, %v; want the configured catalog", DefaultName, cfg, err)
+	}
+	if _, err := ConfigFor("community"); err == nil {
+		t.Error("ConfigFor(community) should fail without inventory/catalogs.yaml")
+	}
+
+	testutil.WriteFile(t, "inventory/catalogs.yaml", `catalogs:
+  community: https://example.com/stacks.git
+  catalog:
+    minisign_key: RWQexample
+`)
+	if cfg, err := ConfigFor("community"); err != nil || cfg.Source != "https://example.com/stacks.git" {
+		t.Errorf("ConfigFor(community) = %+v, %v", cfg, err)
+	}
+	// Keys can be set for the configured catalog without repeating its source
+	if cfg, err := ConfigFor(DefaultName); err != nil || cfg.Source != "/srv/catalog" || cfg.MinisignKey != "RWQexample" {
+		t.Errorf("ConfigFor(%s) = %+v, %v", DefaultName, cfg, err)
+	}
+}
@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// LockFile records the catalog stacks installed in stacks/ (stacks.lock)
+type LockFile struct {
+	Stacks map[string]LockedStack `yaml:"stacks"`
+}
+
+// LockedStack is the origin and digest of an installed catalog stack
+type LockedStack struct {
+	Catalog   string `yaml:"catalog"`
+	Source    string `yaml:"source"`
+	Digest    string `yaml:"digest"`
+	Verified  string `yaml:"verified"` // minisign, cosign, checksum or none
+	Installed string `yaml:"installed"`
+}
+
+// LoadLockFile reads stacks.lock; a missing file is an empty lock
+func LoadLockFile() (*LockFile, error) {
+	lock := &LockFile{Stacks: make(map[string]LockedStack)}
+
+	data, err := os.ReadFile(paths.StacksLock)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.StacksLock, err)
+	}
+
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.StacksLock, err)
+	}
+	if lock.Stacks == nil {
+		lock.Stacks = make(map[string]LockedStack)
+	}
+
+	return lock, nil
+}
+
+// RecordInstall adds or replaces a stack's entry in stacks.lock
+func RecordInstall(name string, cfg *Config, verification *Verification) error {
+	lock, err := LoadLockFile()
+	if err != nil {
+		return err
+	}
+
+	lock.Stacks[name] = LockedStack{
+		Catalog:   catalogName(cfg),
+		Source:    cfg.Source,
+		Digest:    verification.Digest,
+		Verified:  verification.Method,
+		Installed: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", paths.StacksLock, err)
+	}
+
+	header := "# Generated by homelabctl - catalog stacks installed in stacks/, commit this file\n"
+	if err := os.WriteFile(paths.StacksLock, append([]byte(header), data...), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", paths.StacksLock, err)
+	}
+
+	return nil
+}
+
+// InstallStack verifies a catalog stack, copies it into stacks/ and records it in stacks.lock
+func (c *Catalog) InstallStack(name string) (*Verification, error) {
+	verification, err := c.VerifyStack(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.CopyStack(name); err != nil {
+		return nil, fmt.Errorf("failed to install stack %s: %w", name, err)
+	}
+
+	if err := RecordInstall(name, c.Config, verification); err != nil {
+		return nil, err
+	}
+
+	return verification, nil
+}
+
+// Untrusted reports whether a stack was installed from a third-party catalog, whose
+// templates render sandboxed: a stack missing from stacks.lock is local, and the
+// curated catalog or a catalog set trusted in inventory/catalogs.yaml is trusted
+func Untrusted(name string) (bool, error) {
+	lock, err := LoadLockFile()
+	if err != nil {
+		return false, err
+	}
+
+	entry, ok := lock.Stacks[name]
+	if !ok || entry.Source == DefaultURL {
+		return false, nil
+	}
+
+	cfg, err := ConfigFor(entry.Catalog)
+	if err != nil {
+		return true, nil // No longer declared, so not trusted either
+	}
+	return !cfg.Trusted, nil
+}
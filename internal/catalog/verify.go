@@ -0,0 +1,219 @@
+package catalog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// Checksum files published at the catalog root
+const (
+	// ChecksumsFile lists "<digest>  <stack>" lines, digests as computed by StackDigest
+	ChecksumsFile = "SHA256SUMS"
+	// MinisignSignature is the minisign signature of ChecksumsFile
+	MinisignSignature = "SHA256SUMS.minisig"
+	// CosignSignature is the cosign (sign-blob) signature of ChecksumsFile
+	CosignSignature = "SHA256SUMS.sig"
+)
+
+// Verification methods, strongest first
+const (
+	VerifiedMinisign = "minisign"
+	VerifiedCosign   = "cosign"
+	VerifiedChecksum = "checksum" // Checksum matched, but SHA256SUMS is not signed
+	VerifiedNone     = "none"     // The catalog publishes no checksums
+)
+
+// Verification is the outcome of checking a catalog stack before installing it
+type Verification struct {
+	Digest string // sha256:<hex> of the stack's files
+	Method string
+}
+
+// StackDigest hashes every file of a stack directory: the sha256 of the sorted
+// "<file sha256>  <relative path>" lines, as printed by sha256sum
+func StackDigest(dir string) (string, error) {
+	var lines []string
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		lines = append(lines, hex.EncodeToString(h.Sum(nil))+"  "+filepath.ToSlash(rel)+"\n")
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", dir, err)
+	}
+
+	sort.Slice(lines, func(i, j int) bool {
+		// Sort by path, the part after the file hash
+		return lines[i][66:] < lines[j][66:]
+	})
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "")))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyStack checks a catalog stack against the catalog's SHA256SUMS, after verifying
+// its signature when the catalog has a minisign or cosign key
+// With a key configured, a missing or invalid signature is an error
+func (c *Catalog) VerifyStack(name string) (*Verification, error) {
+	cfg := c.Config
+	digest, err := StackDigest(filepath.Join(c.Dir, StacksDir, name))
+	if err != nil {
+		return nil, err
+	}
+	result := &Verification{Digest: digest, Method: VerifiedNone}
+
+	checksums := filepath.Join(c.Dir, ChecksumsFile)
+	signed := cfg != nil && (cfg.MinisignKey != "" || cfg.CosignKey != "")
+
+	if _, err := os.Stat(checksums); os.IsNotExist(err) {
+		if signed {
+			return nil, errors.New(
+				fmt.Sprintf("catalog %s publishes no %s to verify", cfg.Name, ChecksumsFile),
+				fmt.Sprintf("Remove the signing key of %s from inventory/catalogs.yaml to install unverified stacks", cfg.Name),
+			).WithClass(errors.ClassValidation)
+		}
+		return result, nil
+	}
+
+	result.Method = VerifiedChecksum
+	if signed {
+		method, err := verifySignature(c.Dir, cfg)
+		if err != nil {
+			return nil, err
+		}
+		result.Method = method
+	}
+
+	sums, err := readChecksums(checksums)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, ok := sums[name]
+	if !ok {
+		return nil, errors.New(
+			fmt.Sprintf("catalog %s lists no checksum for stack %s", catalogName(cfg), name),
+			fmt.Sprintf("The catalog's %s must cover every stack", ChecksumsFile),
+		).WithClass(errors.ClassValidation)
+	}
+	if "sha256:"+strings.TrimPrefix(expected, "sha256:") != digest {
+		return nil, errors.New(
+			fmt.Sprintf("checksum mismatch for stack %s from catalog %s", name, catalogName(cfg)),
+			"The stack was modified after the catalog published its checksums",
+			"Do not install it; report the mismatch to the catalog maintainers",
+		).WithClass(errors.ClassValidation).WithContext(
+			"Expected: sha256:"+strings.TrimPrefix(expected, "sha256:"),
+			"Actual:   "+digest,
+		)
+	}
+
+	return result, nil
+}
+
+// verifySignature checks SHA256SUMS with minisign or cosign, depending on the configured key
+func verifySignature(dir string, cfg *Config) (string, error) {
+	checksums := filepath.Join(dir, ChecksumsFile)
+
+	var method, tool string
+	var args []string
+	if cfg.MinisignKey != "" {
+		method, tool = VerifiedMinisign, "minisign"
+		keyFlag := "-P"
+		if _, err := os.Stat(cfg.MinisignKey); err == nil {
+			keyFlag = "-p"
+		}
+		args = []string{"-V", "-q", keyFlag, cfg.MinisignKey, "-m", checksums, "-x", filepath.Join(dir, MinisignSignature)}
+	} else {
+		method, tool = VerifiedCosign, "cosign"
+		args = []string{"verify-blob", "--key", cfg.CosignKey, "--signature", filepath.Join(dir, CosignSignature), checksums}
+	}
+
+	if _, err := exec.LookPath(tool); err != nil {
+		return "", errors.New(
+			fmt.Sprintf("%s not found in PATH", tool),
+			fmt.Sprintf("Install %s to verify catalog %s", tool, cfg.Name),
+		).WithClass(errors.ClassValidation)
+	}
+
+	cmd := exec.Command(tool, args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return "", errors.New(
+			fmt.Sprintf("signature verification of catalog %s failed", cfg.Name),
+			"Check the catalog's public key in inventory/catalogs.yaml",
+			"Do not install stacks from an unverified catalog",
+		).WithClass(errors.ClassValidation).WithContext(strings.TrimSpace(output.String()))
+	}
+
+	return method, nil
+}
+
+// readChecksums parses "<digest>  <stack>" lines
+func readChecksums(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ChecksumsFile, err)
+	}
+	defer f.Close()
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line in %s: %q (expected '<digest>  <stack>')", ChecksumsFile, scanner.Text())
+		}
+		sums[strings.TrimPrefix(fields[1], StacksDir+"/")] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ChecksumsFile, err)
+	}
+
+	return sums, nil
+}
+
+// catalogName names a catalog in messages
+func catalogName(cfg *Config) string {
+	if cfg == nil || cfg.Name == "" {
+		return "catalog"
+	}
+	return cfg.Name
+}
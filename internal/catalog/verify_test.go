@@ -0,0 +1,172 @@
+package catalog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestStackDigest(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	setupCatalog(t, dir)
+
+	stackDir := filepath.Join(dir, "stacks/jellyfin")
+	first, err := StackDigest(stackDir)
+	if err != nil {
+		t.Fatalf("StackDigest() error: %v", err)
+	}
+	if !strings.HasPrefix(first, "sha256:") || len(first) != len("sha256:")+64 {
+		t.Errorf("StackDigest() = %q, want sha256:<hex>", first)
+	}
+
+	again, _ := StackDigest(stackDir)
+	if again != first {
+		t.Errorf("StackDigest() is not stable: %s != %s", again, first)
+	}
+
+	testutil.WriteFile(t, filepath.Join(stackDir, "compose.yml.tmpl"), "services:\n  jellyfin: {}\n")
+	changed, _ := StackDigest(stackDir)
+	if changed == first {
+		t.Error("StackDigest() should change when a file changes")
+	}
+}
+
+func TestVerifyStack(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	setupCatalog(t, dir)
+
+	cat, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+
+	// No SHA256SUMS: installable, but unverified
+	verification, err := cat.VerifyStack("jellyfin")
+	if err != nil {
+		t.Fatalf("VerifyStack() error: %v", err)
+	}
+	if verification.Method != VerifiedNone {
+		t.Errorf("Method = %s, want %s", verification.Method, VerifiedNone)
+	}
+
+	digest, _ := StackDigest(filepath.Join(dir, "stacks/jellyfin"))
+	testutil.WriteFile(t, filepath.Join(dir, ChecksumsFile), "# published checksums\n"+digest+"  stacks/jellyfin\n")
+
+	verification, err = cat.VerifyStack("jellyfin")
+	if err != nil {
+		t.Fatalf("VerifyStack() error: %v", err)
+	}
+	if verification.Method != VerifiedChecksum || verification.Digest != digest {
+		t.Errorf("VerifyStack() = %+v, want checksum %s", verification, digest)
+	}
+
+	if _, err := cat.VerifyStack("traefik"); err == nil || !strings.Contains(err.Error(), "lists no checksum") {
+		t.Errorf("expected missing checksum error for traefik, got %v", err)
+	}
+
+	// Tampered stack
+	testutil.WriteFile(t, filepath.Join(dir, "stacks/jellyfin/compose.yml.tmpl"), "services:\n  miner: {}\n")
+	if _, err := cat.VerifyStack("jellyfin"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("expected checksum mismatch, got %v", err)
+	}
+}
+
+func TestVerifyStackRequiresChecksumsWithKey(t *testing.T) {
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	setupCatalog(t, dir)
+
+	cat, err := OpenConfig(&Config{Name: "community", Source: dir, MinisignKey: "RWQexample"})
+	if err != nil {
+		t.Fatalf("OpenConfig() error: %v", err)
+	}
+
+	if _, err := cat.VerifyStack("jellyfin"); err == nil || !strings.Contains(err.Error(), "publishes no SHA256SUMS") {
+		t.Errorf("expected missing SHA256SUMS error, got %v", err)
+	}
+}
+
+func TestInstallStackRecordsLock(t *testing.T) {
+	catalogDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	setupCatalog(t, catalogDir)
+
+	digest, _ := StackDigest(filepath.Join(catalogDir, "stacks/jellyfin"))
+	testutil.WriteFile(t, filepath.Join(catalogDir, ChecksumsFile), digest+"  jellyfin\n")
+
+	repoDir, cleanupRepo := testutil.TempDir(t)
+	defer cleanupRepo()
+	restoreDir := testutil.Chdir(t, repoDir)
+	defer restoreDir()
+
+	cat, err := OpenConfig(&Config{Name: "community", Source: catalogDir})
+	if err != nil {
+		t.Fatalf("OpenConfig() error: %v", err)
+	}
+
+	if _, err := cat.InstallStack("jellyfin"); err != nil {
+		t.Fatalf("InstallStack() error: %v", err)
+	}
+	if _, err := os.Stat("stacks/jellyfin/stack.yaml"); err != nil {
+		t.Errorf("expected stack to be copied: %v", err)
+	}
+
+	lock, err := LoadLockFile()
+	if err != nil {
+		t.Fatalf("LoadLockFile() error: %v", err)
+	}
+	locked, ok := lock.Stacks["jellyfin"]
+	if !ok {
+		t.Fatal("expected jellyfin in stacks.lock")
+	}
+	if locked.Catalog != "community" || locked.Source != catalogDir || locked.Digest != digest || locked.Verified != VerifiedChecksum {
+		t.Errorf("unexpected lock entry: %+v", locked)
+	}
+
+	// The installed copy hashes to the recorded digest
+	local, _ := StackDigest("stacks/jellyfin")
+	if local != digest {
+		t.Errorf("installed digest %s != recorded %s", local, digest)
+	}
+}
+
+func TestUntrusted(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "stacks.lock", `stacks:
+  jellyfin:
+    catalog: catalog
+    source: `+DefaultURL+`
+  immich:
+    catalog: community
+    source: https://example.com/stacks.git
+  paperless:
+    catalog: friends
+    source: https://example.org/stacks.git
+`)
+	testutil.WriteFile(t, "inventory/catalogs.yaml", `catalogs:
+  community: https://example.com/stacks.git
+  friends:
+    source: https://example.org/stacks.git
+    trusted: true
+`)
+
+	for name, want := range map[string]bool{
+		"local":     false, // Not installed from a catalog
+		"jellyfin":  false, // Curated catalog
+		"immich":    true,
+		"paperless": false, // Trusted in inventory/catalogs.yaml
+	} {
+		if got, err := Untrusted(name); err != nil || got != want {
+			t.Errorf("Untrusted(%s) = %v, %v; want %v", name, got, err, want)
+		}
+	}
+}
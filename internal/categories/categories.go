@@ -13,6 +13,25 @@ type Category struct {
 	Order       int                    // Deployment order (lower = earlier)
 	Color       string                 // Terminal color
 	Defaults    map[string]interface{} // Category-wide defaults
+	Update      UpdatePolicy           // How scheduled updates treat the category
+}
+
+// Update policies
+const (
+	UpdateAuto   = "auto"   // Scheduled updates pull and recreate services (within the window)
+	UpdateNotify = "notify" // Scheduled updates only report newer images
+	UpdateManual = "manual" // Only updated by an explicit, unscheduled run
+)
+
+// UpdatePolicy controls how 'update --scheduled' treats a category's services
+type UpdatePolicy struct {
+	Policy string `yaml:"policy"` // auto, notify or manual
+	Window string `yaml:"window"` // HH:MM-HH:MM during which auto updates run; empty means any time
+}
+
+// ValidUpdatePolicy checks if an update policy name is known
+func ValidUpdatePolicy(policy string) bool {
+	return policy == UpdateAuto || policy == UpdateNotify || policy == UpdateManual
 }
 
 // defaultMetadata provides default metadata for known categories
@@ -29,6 +48,7 @@ var defaultMetadata = map[string]*Category{
 				"no-new-privileges:true",
 			},
 		},
+		Update: UpdatePolicy{Policy: UpdateManual},
 	},
 	"infrastructure": {
 		Name:        "infrastructure",
@@ -41,6 +61,7 @@ var defaultMetadata = map[string]*Category{
 				"no-new-privileges:true",
 			},
 		},
+		Update: UpdatePolicy{Policy: UpdateManual},
 	},
 	"monitoring": {
 		Name:        "monitoring",
@@ -50,6 +71,7 @@ var defaultMetadata = map[string]*Category{
 		Defaults: map[string]interface{}{
 			"restart": "unless-stopped",
 		},
+		Update: UpdatePolicy{Policy: UpdateNotify},
 	},
 	"automation": {
 		Name:        "automation",
@@ -59,6 +81,7 @@ var defaultMetadata = map[string]*Category{
 		Defaults: map[string]interface{}{
 			"restart": "unless-stopped",
 		},
+		Update: UpdatePolicy{Policy: UpdateNotify},
 	},
 	"media": {
 		Name:        "media",
@@ -72,6 +95,7 @@ var defaultMetadata = map[string]*Category{
 				"PGID": "1000",
 			},
 		},
+		Update: UpdatePolicy{Policy: UpdateAuto, Window: "02:00-05:00"},
 	},
 	"tools": {
 		Name:        "tools",
@@ -79,6 +103,7 @@ var defaultMetadata = map[string]*Category{
 		Order:       6,
 		Color:       "white",
 		Defaults:    map[string]interface{}{},
+		Update:      UpdatePolicy{Policy: UpdateAuto, Window: "02:00-05:00"},
 	},
 }
 
@@ -103,6 +128,7 @@ func RegisterCategory(name string) {
 			Order:       999, // Unknown categories go last
 			Color:       "white",
 			Defaults:    map[string]interface{}{},
+			Update:      UpdatePolicy{Policy: UpdateNotify},
 		}
 	}
 }
@@ -361,3 +361,22 @@ func TestMediaDefaults(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdatePolicies(t *testing.T) {
+	Reset()
+	defer Reset()
+
+	if got := GetOrDefault("core").Update.Policy; got != UpdateManual {
+		t.Errorf("core update policy = %s, want %s", got, UpdateManual)
+	}
+	if got := GetOrDefault("media").Update; got.Policy != UpdateAuto || got.Window == "" {
+		t.Errorf("media update policy = %+v, want auto with a window", got)
+	}
+	if got := GetOrDefault("custom-category").Update.Policy; got != UpdateNotify {
+		t.Errorf("custom category update policy = %s, want %s", got, UpdateNotify)
+	}
+
+	if ValidUpdatePolicy("nightly") {
+		t.Error("ValidUpdatePolicy(nightly) = true, want false")
+	}
+}
@@ -3,25 +3,56 @@ package compose
 import (
 	"fmt"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/monkeymonk/homelabctl/internal/errors"
 	"github.com/monkeymonk/homelabctl/internal/paths"
 )
 
+// Provenance labels added to every generated service
+const (
+	LabelStack       = "homelabctl.stack"
+	LabelCategory    = "homelabctl.category"
+	LabelConfigHash  = "homelabctl.config-hash"  // Digest of the stack's rendered config files
+	LabelSourceImage = "homelabctl.source-image" // Image as the stack sets it, before a registry mirror rewrote it
+)
+
+// unknownAnchorPattern matches the YAML error for an alias whose anchor is not in the file
+var unknownAnchorPattern = regexp.MustCompile(`unknown anchor '([^']+)' referenced`)
+
 // ComposeFile represents a docker-compose.yml structure
 type ComposeFile struct {
 	Services map[string]interface{} `yaml:"services,omitempty"`
 	Volumes  map[string]interface{} `yaml:"volumes,omitempty"`
 	Networks map[string]interface{} `yaml:"networks,omitempty"`
+	Secrets  map[string]interface{} `yaml:"secrets,omitempty"`
+
+	// ServiceSources maps each merged service to the file it came from (not serialized)
+	ServiceSources map[string]string `yaml:"-"`
+
+	// VolumeSources and NetworkSources map each kept definition to its file (not serialized)
+	VolumeSources  map[string]string `yaml:"-"`
+	NetworkSources map[string]string `yaml:"-"`
+
+	// Warnings lists non-fatal merge conflicts (not serialized)
+	Warnings []string `yaml:"-"`
 }
 
 // MergeComposeFiles merges multiple rendered compose files into one
 func MergeComposeFiles(files []string) (*ComposeFile, error) {
 	merged := &ComposeFile{
-		Services: make(map[string]interface{}),
-		Volumes:  make(map[string]interface{}),
-		Networks: make(map[string]interface{}),
+		Services:       make(map[string]interface{}),
+		Volumes:        make(map[string]interface{}),
+		Networks:       make(map[string]interface{}),
+		ServiceSources: make(map[string]string),
+		VolumeSources:  make(map[string]string),
+		NetworkSources: make(map[string]string),
 	}
 
 	for _, file := range files {
@@ -30,9 +61,9 @@ func MergeComposeFiles(files []string) (*ComposeFile, error) {
 			return nil, fmt.Errorf("failed to read %s: %w", file, err)
 		}
 
-		var compose ComposeFile
-		if err := yaml.Unmarshal(data, &compose); err != nil {
-			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		compose, err := parseComposeFile(file, data)
+		if err != nil {
+			return nil, err
 		}
 
 		// Merge services
@@ -41,13 +72,15 @@ func MergeComposeFiles(files []string) (*ComposeFile, error) {
 				return nil, fmt.Errorf("duplicate service name: %s", name)
 			}
 			merged.Services[name] = svc
+			merged.ServiceSources[name] = file
 		}
 
 		// Merge volumes
 		for name, vol := range compose.Volumes {
 			if existing, exists := merged.Volumes[name]; exists {
 				// Warn about duplicate volume definitions
-				fmt.Fprintf(os.Stderr, "WARNING: Duplicate volume '%s' in %s (using first definition)\n", name, file)
+				merged.Warnings = append(merged.Warnings,
+					fmt.Sprintf("duplicate volume '%s' in %s (using first definition)", name, file))
 
 				// Quick check if they might differ (pointer comparison is cheap)
 				if fmt.Sprintf("%p", existing) != fmt.Sprintf("%p", vol) {
@@ -55,13 +88,15 @@ func MergeComposeFiles(files []string) (*ComposeFile, error) {
 					existingYAML, _ := yaml.Marshal(existing)
 					newYAML, _ := yaml.Marshal(vol)
 					if string(existingYAML) != string(newYAML) {
-						fmt.Fprintf(os.Stderr, "WARNING: Volume '%s' has conflicting definitions:\n  First: %s\n  Ignored: %s\n",
-							name, string(existingYAML), string(newYAML))
+						merged.Warnings = append(merged.Warnings,
+							fmt.Sprintf("volume '%s' has conflicting definitions (first: %s, ignored: %s)",
+								name, strings.TrimSpace(string(existingYAML)), strings.TrimSpace(string(newYAML))))
 					}
 				}
 				continue
 			}
 			merged.Volumes[name] = vol
+			merged.VolumeSources[name] = file
 		}
 
 		// Merge networks
@@ -95,24 +130,407 @@ func MergeComposeFiles(files []string) (*ComposeFile, error) {
 				} else if !newIsExternal && existingIsExternal {
 					// New creates it, existing is external - replace with new (expected)
 					merged.Networks[name] = net
+					merged.NetworkSources[name] = file
 					continue
 				} else if !newIsExternal && !existingIsExternal {
 					// Both trying to create - this is a REAL conflict
-					fmt.Fprintf(os.Stderr, "WARNING: Duplicate network '%s' in %s\n", name, file)
-					fmt.Fprintf(os.Stderr, "  → Multiple stacks trying to create the same network\n")
-					fmt.Fprintf(os.Stderr, "  → Keeping first definition\n")
+					merged.Warnings = append(merged.Warnings,
+						fmt.Sprintf("duplicate network '%s' in %s: multiple stacks create it (keeping first definition)", name, file))
 					continue
 				}
 				// Both are external - silently keep first (expected)
 				continue
 			}
 			merged.Networks[name] = net
+			merged.NetworkSources[name] = file
+		}
+
+		// Merge secrets: stacks sharing one must define it the same way
+		for name, secret := range compose.Secrets {
+			if existing, exists := merged.Secrets[name]; exists {
+				existingYAML, _ := yaml.Marshal(existing)
+				newYAML, _ := yaml.Marshal(secret)
+				if string(existingYAML) != string(newYAML) {
+					merged.Warnings = append(merged.Warnings,
+						fmt.Sprintf("secret '%s' has conflicting definitions in %s (keeping first definition)", name, file))
+				}
+				continue
+			}
+			if merged.Secrets == nil {
+				merged.Secrets = make(map[string]interface{})
+			}
+			merged.Secrets[name] = secret
 		}
 	}
 
 	return merged, nil
 }
 
+// LoadComposeFile reads and parses a compose file from disk
+func LoadComposeFile(path string) (*ComposeFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	compose, err := parseComposeFile(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if compose.Services == nil {
+		compose.Services = make(map[string]interface{})
+	}
+
+	return compose, nil
+}
+
+// parseComposeFile decodes a compose file
+// Anchors, aliases and merge keys (<<: *base) are expanded while decoding, so every
+// service gets its own copy and later edits (labels, filtering) never leak between
+// services. Anchors are scoped to one file: each stack is rendered and parsed on its own
+func parseComposeFile(file string, data []byte) (*ComposeFile, error) {
+	var compose ComposeFile
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return nil, parseError(file, err)
+	}
+
+	return &compose, nil
+}
+
+// parseError explains a decoding error of a compose file
+func parseError(file string, err error) error {
+	if match := unknownAnchorPattern.FindStringSubmatch(err.Error()); match != nil {
+		return errors.New(
+			fmt.Sprintf("%s references anchor '%s' that is not defined in the same file", file, match[1]),
+			"YAML anchors only work within one compose.yml.tmpl; they cannot be shared across stacks",
+			"Define the anchor (e.g. an x-"+match[1]+" block) before its first use in this stack's template",
+			"To share settings between stacks, use an inventory variable or a template partial instead",
+		).WithClass(errors.ClassValidation)
+	}
+	return fmt.Errorf("failed to parse %s: %w", file, err)
+}
+
+// MergeLayers merges a stack's compose files into out, in order, as docker compose
+// merges files given with -f: mappings merge key by key, sequences are appended
+// (skipping entries already there) and other values are replaced by later files.
+// As in compose, a service's command, entrypoint and healthcheck.test are replaced,
+// its environment, labels and extra_hosts merge by key, in either syntax, its volumes,
+// devices, ports, secrets and configs by target, and its networks by name
+// Anchors are expanded per file, so each layer defines its own
+func MergeLayers(files []string, out string) error {
+	merged := map[string]interface{}{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file, err)
+		}
+
+		var layer map[string]interface{}
+		if err := yaml.Unmarshal(data, &layer); err != nil {
+			return parseError(file, err)
+		}
+		merged = mergeLayer(merged, layer)
+	}
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return fmt.Errorf("failed to marshal compose file: %w", err)
+	}
+	if err := os.WriteFile(out, data, paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	return nil
+}
+
+// How a service field merges, when not as mappings and sequences generally do
+const (
+	mergeDefault  = iota
+	mergeReplace  // The later layer's value replaces the earlier one
+	mergeByKey    // KEY=VALUE entries merge by key
+	mergeByTarget // Entries merge by the path or port they mount or publish
+	mergeByName   // Network names, as a list or a mapping
+)
+
+// serviceMergeRules are the service fields compose merges differently, by dotted path
+var serviceMergeRules = map[string]int{
+	"command":          mergeReplace,
+	"entrypoint":       mergeReplace,
+	"healthcheck.test": mergeReplace,
+	"environment":      mergeByKey,
+	"labels":           mergeByKey,
+	"extra_hosts":      mergeByKey,
+	"volumes":          mergeByTarget,
+	"devices":          mergeByTarget,
+	"ports":            mergeByTarget,
+	"secrets":          mergeByTarget,
+	"configs":          mergeByTarget,
+	"networks":         mergeByName,
+}
+
+// mergeLayer returns base with layer merged in; path is where base is in the file
+func mergeLayer(base, layer map[string]interface{}, path ...string) map[string]interface{} {
+	for key, value := range layer {
+		keyPath := append(append([]string{}, path...), key)
+		rule := mergeDefault
+		if len(keyPath) > 2 && keyPath[0] == "services" {
+			rule = serviceMergeRules[strings.Join(keyPath[2:], ".")]
+		}
+
+		switch rule {
+		case mergeReplace:
+			base[key] = value
+			continue
+		case mergeByKey:
+			if base[key] != nil && value != nil {
+				base[key] = mergeKeyed(base[key], value, key)
+				continue
+			}
+		case mergeByTarget:
+			existing, baseIsList := base[key].([]interface{})
+			if list, ok := value.([]interface{}); ok && baseIsList {
+				base[key] = mergeTargeted(existing, list, key)
+				continue
+			}
+		case mergeByName:
+			if base[key] != nil && value != nil {
+				base[key] = mergeNetworks(base[key], value, keyPath)
+				continue
+			}
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if existing, ok := base[key].(map[string]interface{}); ok {
+				base[key] = mergeLayer(existing, v, keyPath...)
+				continue
+			}
+		case []interface{}:
+			if existing, ok := base[key].([]interface{}); ok {
+				base[key] = appendMissing(existing, v)
+				continue
+			}
+		}
+		base[key] = value
+	}
+	return base
+}
+
+// keyedEntry is an entry of environment, labels or extra_hosts
+type keyedEntry struct {
+	key   string
+	entry interface{}
+}
+
+// mergeKeyed merges the environment, labels or extra_hosts (field) of two layers by
+// key, the later value winning. Mappings stay a mapping; with a list on either side,
+// the result is a list, in the order keys first appear
+func mergeKeyed(base, layer interface{}, field string) interface{} {
+	baseMap, baseIsMap := base.(map[string]interface{})
+	layerMap, layerIsMap := layer.(map[string]interface{})
+	if baseIsMap && layerIsMap {
+		for key, value := range layerMap {
+			baseMap[key] = value
+		}
+		return baseMap
+	}
+
+	entries := keyedEntries(base, field)
+	for _, add := range keyedEntries(layer, field) {
+		replaced := false
+		for i := range entries {
+			if entries[i].key == add.key {
+				entries[i], replaced = add, true
+				break
+			}
+		}
+		if !replaced {
+			entries = append(entries, add)
+		}
+	}
+
+	list := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e.entry)
+	}
+	return list
+}
+
+// keyedEntries returns the entries of environment, labels or extra_hosts in either
+// syntax; extra_hosts separate the host with : or =, the others their key with =
+func keyedEntries(value interface{}, field string) []keyedEntry {
+	separators, separator := "=", "="
+	if field == "extra_hosts" {
+		separators, separator = "=:", ":"
+	}
+
+	var entries []keyedEntry
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			text := fmt.Sprint(item)
+			key := text
+			if i := strings.IndexAny(text, separators); i >= 0 {
+				key = text[:i]
+			}
+			entries = append(entries, keyedEntry{key, item})
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := key // environment: KEY alone passes the host's value through
+			if v[key] != nil {
+				entry = key + separator + fmt.Sprint(v[key])
+			}
+			entries = append(entries, keyedEntry{key, entry})
+		}
+	}
+	return entries
+}
+
+// mergeTargeted merges the volumes, devices, ports, secrets or configs (field) of two
+// layers: an entry of layer replaces the one of list with the same target, in place,
+// and the others are appended
+func mergeTargeted(list, layer []interface{}, field string) []interface{} {
+	for _, item := range layer {
+		target := entryTarget(item, field)
+		replaced := false
+		for i, existing := range list {
+			if entryTarget(existing, field) == target {
+				list[i], replaced = item, true
+				break
+			}
+		}
+		if !replaced {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// entryTarget returns what identifies an entry of a service's volumes, devices, ports,
+// secrets or configs, in the short or the long syntax: the path it mounts in the
+// container, or for ports the host IP, published port, container port and protocol
+func entryTarget(item interface{}, field string) string {
+	if long, ok := item.(map[string]interface{}); ok {
+		switch field {
+		case "ports":
+			protocol := "tcp"
+			if long["protocol"] != nil {
+				protocol = fmt.Sprint(long["protocol"])
+			}
+			return portTarget(long["host_ip"], long["published"], long["target"], protocol)
+		case "secrets", "configs":
+			if long["target"] != nil {
+				return mountTarget(fmt.Sprint(long["target"]), field)
+			}
+			return mountTarget(fmt.Sprint(long["source"]), field)
+		}
+		return fmt.Sprint(long["target"])
+	}
+
+	text := fmt.Sprint(item)
+	switch field {
+	case "ports":
+		protocol := "tcp"
+		if spec, proto, ok := strings.Cut(text, "/"); ok {
+			text, protocol = spec, proto
+		}
+		var ip, published interface{}
+		target := text
+		if i := strings.LastIndex(text, ":"); i >= 0 {
+			published, target = text[:i], text[i+1:]
+			if j := strings.LastIndex(text[:i], ":"); j >= 0 {
+				ip, published = text[:j], text[j+1:i]
+			}
+		}
+		return portTarget(ip, published, target, protocol)
+	case "secrets", "configs":
+		return mountTarget(text, field)
+	}
+	// source:target[:mode], or target alone for an anonymous volume
+	parts := strings.Split(text, ":")
+	if len(parts) >= 2 {
+		return parts[1]
+	}
+	return parts[0]
+}
+
+// portTarget formats the identity of a published port
+func portTarget(ip, published, target interface{}, protocol string) string {
+	value := func(v interface{}) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+	return strings.Join([]string{value(ip), value(published), value(target), protocol}, "|")
+}
+
+// mountTarget returns the path a secret or config is mounted at: /run/secrets/<name>
+// and /<name> by default
+func mountTarget(target, field string) string {
+	if strings.HasPrefix(target, "/") {
+		return target
+	}
+	if field == "secrets" {
+		return "/run/secrets/" + target
+	}
+	return "/" + target
+}
+
+// mergeNetworks merges the networks of a service in two layers by name; with a mapping
+// on either side the result is a mapping, whose network settings merge key by key
+func mergeNetworks(base, layer interface{}, path []string) interface{} {
+	baseList, baseIsList := base.([]interface{})
+	layerList, layerIsList := layer.([]interface{})
+	if baseIsList && layerIsList {
+		return appendMissing(baseList, layerList)
+	}
+
+	asMap := func(value interface{}) map[string]interface{} {
+		if networks, ok := value.(map[string]interface{}); ok {
+			return networks
+		}
+		networks := map[string]interface{}{}
+		if list, ok := value.([]interface{}); ok {
+			for _, name := range list {
+				networks[fmt.Sprint(name)] = nil
+			}
+		}
+		return networks
+	}
+	merged, added := asMap(base), asMap(layer)
+	for name, settings := range added {
+		// A network listed by name keeps the settings of the earlier layer
+		if _, ok := merged[name]; ok && settings == nil {
+			delete(added, name)
+		}
+	}
+	return mergeLayer(merged, added, path...)
+}
+
+// appendMissing appends the items of extra that list does not hold yet
+func appendMissing(list, extra []interface{}) []interface{} {
+	for _, item := range extra {
+		found := false
+		for _, existing := range list {
+			if reflect.DeepEqual(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // WriteComposeFile writes a ComposeFile to disk as YAML
 func WriteComposeFile(path string, compose *ComposeFile) error {
 	data, err := yaml.Marshal(compose)
@@ -127,6 +545,41 @@ func WriteComposeFile(path string, compose *ComposeFile) error {
 	return nil
 }
 
+// Annotations maps a top-level section (services, volumes, networks) and entry name to a comment
+type Annotations map[string]map[string]string
+
+// WriteAnnotatedComposeFile writes a ComposeFile with a header comment and a comment
+// above each annotated service, volume or network
+func WriteAnnotatedComposeFile(path string, compose *ComposeFile, header string, annotations Annotations) error {
+	var doc yaml.Node
+	if err := doc.Encode(compose); err != nil {
+		return fmt.Errorf("failed to marshal compose file: %w", err)
+	}
+	doc.HeadComment = header
+
+	// Content alternates key, value
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		comments := annotations[doc.Content[i].Value]
+		entries := doc.Content[i+1]
+		for j := 0; j+1 < len(entries.Content); j += 2 {
+			if comment, ok := comments[entries.Content[j].Value]; ok {
+				entries.Content[j].HeadComment = comment
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(&doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal compose file: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write compose file: %w", err)
+	}
+
+	return nil
+}
+
 // FilterDisabledServices removes disabled services from a ComposeFile
 func FilterDisabledServices(compose *ComposeFile, disabledServices []string) []string {
 	if len(disabledServices) == 0 {
@@ -149,3 +602,415 @@ func FilterDisabledServices(compose *ComposeFile, disabledServices []string) []s
 
 	return removed
 }
+
+// ServiceDependencies returns, for each service, the services it must start after
+// Dependencies come from depends_on (list or map form), network_mode: service:<name>
+// (e.g. containers routed through a VPN/gateway) and volumes_from
+func ServiceDependencies(compose *ComposeFile) map[string][]string {
+	deps := make(map[string][]string)
+
+	for name, svc := range compose.Services {
+		svcMap, ok := svc.(map[string]interface{})
+		if !ok {
+			deps[name] = nil
+			continue
+		}
+
+		var serviceDeps []string
+
+		switch dependsOn := svcMap["depends_on"].(type) {
+		case []interface{}:
+			for _, dep := range dependsOn {
+				if s, ok := dep.(string); ok {
+					serviceDeps = append(serviceDeps, s)
+				}
+			}
+		case map[string]interface{}:
+			for dep := range dependsOn {
+				serviceDeps = append(serviceDeps, dep)
+			}
+		}
+
+		if mode, ok := svcMap["network_mode"].(string); ok && strings.HasPrefix(mode, "service:") {
+			serviceDeps = append(serviceDeps, strings.TrimPrefix(mode, "service:"))
+		}
+
+		if volumesFrom, ok := svcMap["volumes_from"].([]interface{}); ok {
+			for _, entry := range volumesFrom {
+				s, ok := entry.(string)
+				if !ok || strings.HasPrefix(s, "container:") {
+					continue
+				}
+				serviceDeps = append(serviceDeps, strings.SplitN(s, ":", 2)[0])
+			}
+		}
+
+		sort.Strings(serviceDeps)
+		deps[name] = serviceDeps
+	}
+
+	return deps
+}
+
+// OrderServices sorts services so that every service comes after its dependencies
+// Only dependencies within the given list are considered; otherwise the input
+// order is preserved, so callers can pre-sort by category or stack
+func OrderServices(services []string, deps map[string][]string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, svc := range services {
+		selected[svc] = true
+	}
+
+	ordered := make([]string, 0, len(services))
+	placed := make(map[string]bool)
+
+	for len(ordered) < len(services) {
+		progress := false
+
+		for _, svc := range services {
+			if placed[svc] {
+				continue
+			}
+
+			ready := true
+			for _, dep := range deps[svc] {
+				if selected[dep] && !placed[dep] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				ordered = append(ordered, svc)
+				placed[svc] = true
+				progress = true
+				// Restart the scan so earlier services keep priority
+				break
+			}
+		}
+
+		if !progress {
+			var remaining []string
+			for _, svc := range services {
+				if !placed[svc] {
+					remaining = append(remaining, svc)
+				}
+			}
+			return nil, fmt.Errorf("%w between services: %s", errors.ErrCycle, strings.Join(remaining, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+// ServiceImages returns the image reference of each given service (all services if empty)
+// Services without an image, such as build-only services, are skipped
+func ServiceImages(compose *ComposeFile, services []string) map[string]string {
+	if len(services) == 0 {
+		for name := range compose.Services {
+			services = append(services, name)
+		}
+	}
+
+	images := make(map[string]string)
+	for _, name := range services {
+		svcMap, ok := compose.Services[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if image, ok := svcMap["image"].(string); ok && image != "" {
+			images[name] = image
+		}
+	}
+
+	return images
+}
+
+// PublishedPort is a port a service publishes on the host
+type PublishedPort struct {
+	HostIP   string // Empty: all interfaces
+	Port     string // Host port or range, e.g. 8080 or 8000-8005
+	Protocol string // tcp or udp
+}
+
+// PublishedPorts returns the host ports a service publishes, from short or long syntax
+// Container ports published on a random host port are skipped
+func PublishedPorts(compose *ComposeFile, service string) []PublishedPort {
+	svcMap, ok := compose.Services[service].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	entries, _ := svcMap["ports"].([]interface{})
+
+	var ports []PublishedPort
+	for _, entry := range entries {
+		port := PublishedPort{Protocol: "tcp"}
+
+		if long, ok := entry.(map[string]interface{}); ok {
+			if long["published"] == nil {
+				continue
+			}
+			port.Port = fmt.Sprint(long["published"])
+			if ip, ok := long["host_ip"].(string); ok {
+				port.HostIP = ip
+			}
+			if protocol, ok := long["protocol"].(string); ok && protocol != "" {
+				port.Protocol = protocol
+			}
+			ports = append(ports, port)
+			continue
+		}
+
+		// [[host_ip:]host_port:]container_port[/protocol]; IPv6 host IPs are bracketed
+		short := fmt.Sprint(entry)
+		if i := strings.LastIndex(short, "/"); i >= 0 {
+			short, port.Protocol = short[:i], short[i+1:]
+		}
+		if strings.HasPrefix(short, "[") {
+			end := strings.Index(short, "]")
+			if end < 0 {
+				continue
+			}
+			port.HostIP = short[1:end]
+			short = strings.TrimPrefix(short[end+1:], ":")
+		}
+		parts := strings.Split(short, ":")
+		switch {
+		case len(parts) == 3:
+			port.HostIP, port.Port = parts[0], parts[1]
+		case len(parts) == 2:
+			port.Port = parts[0]
+		}
+		if port.Port == "" {
+			continue
+		}
+		ports = append(ports, port)
+	}
+
+	return ports
+}
+
+// PortRange parses a port or a port range like 8000-8005
+func PortRange(port string) (int, int, error) {
+	lowText, highText, isRange := strings.Cut(port, "-")
+	low, err := strconv.Atoi(strings.TrimSpace(lowText))
+	if err != nil {
+		return 0, 0, err
+	}
+	high := low
+	if isRange {
+		if high, err = strconv.Atoi(strings.TrimSpace(highText)); err != nil {
+			return 0, 0, err
+		}
+	}
+	return low, high, nil
+}
+
+// PortConflicts returns the host ports several services publish on overlapping host
+// IPs, e.g. "8080/tcp: grafana, homepage", sorted; compose fails to start all but one
+// An empty host IP, 0.0.0.0 and :: bind every interface, so they overlap any other
+func PortConflicts(file *ComposeFile) []string {
+	type binding struct{ service, hostIP string }
+	bindings := make(map[string][]binding) // By port/protocol
+	for service := range file.Services {
+		for _, published := range PublishedPorts(file, service) {
+			low, high, err := PortRange(published.Port)
+			if err != nil {
+				continue // Not a port compose would publish either
+			}
+			for port := low; port <= high; port++ {
+				key := fmt.Sprintf("%d/%s", port, published.Protocol)
+				bindings[key] = append(bindings[key], binding{service, published.HostIP})
+			}
+		}
+	}
+
+	wildcard := func(ip string) bool { return ip == "" || ip == "0.0.0.0" || ip == "::" }
+	var conflicts []string
+	for key, bound := range bindings {
+		clashing := make(map[string]bool)
+		for i, a := range bound {
+			for _, b := range bound[i+1:] {
+				if a.service != b.service && (a.hostIP == b.hostIP || wildcard(a.hostIP) || wildcard(b.hostIP)) {
+					clashing[a.service], clashing[b.service] = true, true
+				}
+			}
+		}
+		if len(clashing) == 0 {
+			continue
+		}
+		services := make([]string, 0, len(clashing))
+		for service := range clashing {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		conflicts = append(conflicts, fmt.Sprintf("%s: %s", key, strings.Join(services, ", ")))
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// BuiltServices returns the services built from source (with a build section), sorted
+// Their image is a local tag, so there is nothing to pull from a registry
+func BuiltServices(compose *ComposeFile) []string {
+	var built []string
+	for name, svc := range compose.Services {
+		if svcMap, ok := svc.(map[string]interface{}); ok && svcMap["build"] != nil {
+			built = append(built, name)
+		}
+	}
+	sort.Strings(built)
+	return built
+}
+
+// SetServiceLabel sets a label on a service, supporting both map and list label syntax
+func SetServiceLabel(compose *ComposeFile, service, key, value string) {
+	svcMap, ok := compose.Services[service].(map[string]interface{})
+	if !ok {
+		svcMap = make(map[string]interface{})
+		compose.Services[service] = svcMap
+	}
+
+	switch labels := svcMap["labels"].(type) {
+	case map[string]interface{}:
+		labels[key] = value
+	case []interface{}:
+		entry := key + "=" + value
+		for i, existing := range labels {
+			if s, ok := existing.(string); ok && strings.SplitN(s, "=", 2)[0] == key {
+				labels[i] = entry
+				return
+			}
+		}
+		svcMap["labels"] = append(labels, entry)
+	default:
+		svcMap["labels"] = map[string]interface{}{key: value}
+	}
+}
+
+// ServiceLabels returns a service's labels as a map, whichever syntax was used
+func ServiceLabels(compose *ComposeFile, service string) map[string]string {
+	result := make(map[string]string)
+
+	svcMap, ok := compose.Services[service].(map[string]interface{})
+	if !ok {
+		return result
+	}
+
+	switch labels := svcMap["labels"].(type) {
+	case map[string]interface{}:
+		for k, v := range labels {
+			result[k] = fmt.Sprintf("%v", v)
+		}
+	case []interface{}:
+		for _, entry := range labels {
+			if s, ok := entry.(string); ok {
+				parts := strings.SplitN(s, "=", 2)
+				if len(parts) == 2 {
+					result[parts[0]] = parts[1]
+				} else {
+					result[parts[0]] = ""
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// NormalizeImage adds the implicit :latest tag to image references without tag or digest
+func NormalizeImage(image string) string {
+	if strings.Contains(image, "@") {
+		return image
+	}
+
+	// A colon after the last slash is a tag; before it, a registry port
+	lastSlash := strings.LastIndex(image, "/")
+	if strings.Contains(image[lastSlash+1:], ":") {
+		return image
+	}
+
+	return image + ":latest"
+}
+
+// DockerHub is the registry of image references without a registry host
+const DockerHub = "docker.io"
+
+// ImageRegistry splits an image reference into its registry host and repository path
+// References without a host are Docker Hub images; official ones live under library/
+func ImageRegistry(image string) (string, string) {
+	first, rest, ok := strings.Cut(image, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		if first == "index.docker.io" {
+			first = DockerHub
+		}
+		if first == DockerHub && !strings.Contains(rest, "/") {
+			rest = "library/" + rest
+		}
+		return first, rest
+	}
+	if !ok {
+		return DockerHub, "library/" + image
+	}
+	return DockerHub, image
+}
+
+// ServicesUsingVolume returns the services mounting the given named volume or bind source
+// Both short ("name:/path[:ro]") and long ({type, source, target}) volume syntax are supported
+func ServicesUsingVolume(compose *ComposeFile, source string) []string {
+	var users []string
+
+	for name, svc := range compose.Services {
+		svcMap, ok := svc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		volumes, ok := svcMap["volumes"].([]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, vol := range volumes {
+			if volumeSource(vol) == source {
+				users = append(users, name)
+				break
+			}
+		}
+	}
+
+	sort.Strings(users)
+	return users
+}
+
+// volumeSource extracts the source (volume name or host path) of a service volume entry
+func volumeSource(vol interface{}) string {
+	switch v := vol.(type) {
+	case string:
+		parts := strings.SplitN(v, ":", 2)
+		if len(parts) < 2 {
+			return "" // Anonymous volume
+		}
+		return parts[0]
+	case map[string]interface{}:
+		if s, ok := v["source"].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// VolumeName returns the docker volume name compose uses for a top-level volume key
+// Explicit names and external volumes keep their name; others are prefixed by the project
+func VolumeName(compose *ComposeFile, project, key string) string {
+	if def, ok := compose.Volumes[key].(map[string]interface{}); ok {
+		if name, ok := def["name"].(string); ok && name != "" {
+			return name
+		}
+		if external, ok := def["external"].(bool); ok && external {
+			return key
+		}
+	}
+	return project + "_" + key
+}
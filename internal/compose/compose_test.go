@@ -3,8 +3,11 @@ package compose
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
 )
 
 func TestMergeComposeFiles_Basic(t *testing.T) {
@@ -147,6 +150,41 @@ volumes:
 			t.Errorf("Volume %s should exist in merged result", vol)
 		}
 	}
+
+	// The duplicate is reported as a warning instead of printed
+	if len(merged.Warnings) != 1 || !strings.Contains(merged.Warnings[0], "shared_data") {
+		t.Errorf("Expected one warning about shared_data, got %v", merged.Warnings)
+	}
+}
+
+func TestMergeComposeFiles_Secrets(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	contents := map[string]string{
+		"stack1.yml": "services:\n  app1:\n    image: nginx:1\nsecrets:\n  token:\n    file: ./token\n",
+		"stack2.yml": "services:\n  app2:\n    image: nginx:2\nsecrets:\n  token:\n    file: ./other\n  key:\n    environment: KEY\n",
+	}
+	var files []string
+	for _, name := range []string{"stack1.yml", "stack2.yml"} {
+		file := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(file, []byte(contents[name]), 0644); err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
+		files = append(files, file)
+	}
+
+	merged, err := MergeComposeFiles(files)
+	if err != nil {
+		t.Fatalf("MergeComposeFiles() unexpected error: %v", err)
+	}
+
+	token, _ := merged.Secrets["token"].(map[string]interface{})
+	if len(merged.Secrets) != 2 || token["file"] != "./token" {
+		t.Errorf("Secrets = %v, want token (first definition) and key", merged.Secrets)
+	}
+	if len(merged.Warnings) != 1 || !strings.Contains(merged.Warnings[0], "secret 'token'") {
+		t.Errorf("Expected one warning about secret token, got %v", merged.Warnings)
+	}
 }
 
 func TestMergeComposeFiles_NetworkDeduplication(t *testing.T) {
@@ -336,3 +374,676 @@ func contains(slice []string, item string) bool {
 	}
 	return false
 }
+
+func TestLoadComposeFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	path := filepath.Join(tmpDir, "docker-compose.yml")
+	content := `services:
+  web:
+    image: nginx:latest
+  db:
+    image: postgres:16
+volumes:
+  db_data: {}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	loaded, err := LoadComposeFile(path)
+	if err != nil {
+		t.Fatalf("LoadComposeFile() unexpected error: %v", err)
+	}
+
+	if len(loaded.Services) != 2 {
+		t.Errorf("Expected 2 services, got %d", len(loaded.Services))
+	}
+
+	if _, exists := loaded.Volumes["db_data"]; !exists {
+		t.Error("Volume db_data should be loaded")
+	}
+
+	// Missing files should fail
+	if _, err := LoadComposeFile(filepath.Join(tmpDir, "missing.yml")); err == nil {
+		t.Error("LoadComposeFile() should fail for missing file")
+	}
+}
+
+func TestServiceDependencies(t *testing.T) {
+	compose := &ComposeFile{
+		Services: map[string]interface{}{
+			"app": map[string]interface{}{
+				"depends_on": []interface{}{"db", "cache"},
+			},
+			"worker": map[string]interface{}{
+				"depends_on": map[string]interface{}{
+					"db": map[string]interface{}{"condition": "service_healthy"},
+				},
+			},
+			"qbittorrent": map[string]interface{}{
+				"network_mode": "service:gluetun",
+			},
+			"backup": map[string]interface{}{
+				"volumes_from": []interface{}{"db:ro", "container:external"},
+			},
+			"db":      map[string]interface{}{"image": "postgres"},
+			"cache":   map[string]interface{}{"image": "redis"},
+			"gluetun": map[string]interface{}{"image": "qmcgaw/gluetun"},
+		},
+	}
+
+	deps := ServiceDependencies(compose)
+
+	tests := map[string][]string{
+		"app":         {"cache", "db"},
+		"worker":      {"db"},
+		"qbittorrent": {"gluetun"},
+		"backup":      {"db"},
+		"db":          nil,
+	}
+
+	for svc, want := range tests {
+		got := deps[svc]
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("deps[%s] = %v, want %v", svc, got, want)
+		}
+	}
+}
+
+func TestOrderServices(t *testing.T) {
+	deps := map[string][]string{
+		"app":         {"db", "cache"},
+		"qbittorrent": {"gluetun"},
+		"db":          nil,
+		"cache":       nil,
+		"gluetun":     nil,
+	}
+
+	ordered, err := OrderServices([]string{"app", "qbittorrent", "db", "cache", "gluetun"}, deps)
+	if err != nil {
+		t.Fatalf("OrderServices() unexpected error: %v", err)
+	}
+
+	position := make(map[string]int)
+	for i, svc := range ordered {
+		position[svc] = i
+	}
+
+	if position["db"] > position["app"] || position["cache"] > position["app"] {
+		t.Errorf("Dependencies should come before app: %v", ordered)
+	}
+
+	if position["gluetun"] > position["qbittorrent"] {
+		t.Errorf("gluetun should come before qbittorrent: %v", ordered)
+	}
+
+	// Dependencies outside the selection are ignored
+	ordered, err = OrderServices([]string{"app"}, deps)
+	if err != nil || len(ordered) != 1 {
+		t.Errorf("OrderServices(app) = %v, %v", ordered, err)
+	}
+
+	// Cycles are reported
+	if _, err := OrderServices([]string{"a", "b"}, map[string][]string{"a": {"b"}, "b": {"a"}}); !errors.Is(err, errors.ErrCycle) {
+		t.Errorf("OrderServices() error = %v, want ErrCycle", err)
+	}
+}
+
+func TestServiceImages(t *testing.T) {
+	compose := &ComposeFile{
+		Services: map[string]interface{}{
+			"web":   map[string]interface{}{"image": "nginx:latest"},
+			"db":    map[string]interface{}{"image": "postgres:16"},
+			"local": map[string]interface{}{"build": "./app"},
+		},
+	}
+
+	all := ServiceImages(compose, nil)
+	if len(all) != 2 {
+		t.Errorf("Expected 2 images, got %d: %v", len(all), all)
+	}
+
+	if _, exists := all["local"]; exists {
+		t.Error("Build-only service should be skipped")
+	}
+
+	selected := ServiceImages(compose, []string{"db"})
+	if len(selected) != 1 || selected["db"] != "postgres:16" {
+		t.Errorf("ServiceImages(db) = %v", selected)
+	}
+}
+
+func TestPublishedPorts(t *testing.T) {
+	compose := &ComposeFile{Services: map[string]interface{}{
+		"traefik": map[string]interface{}{
+			"ports": []interface{}{
+				"80:80",
+				"127.0.0.1:8080:8080",
+				"[::1]:9000:9000",
+				"53:53/udp",
+				"8000-8005:8000-8005",
+				"9090",
+				map[string]interface{}{"target": 443, "published": 443, "protocol": "tcp"},
+				map[string]interface{}{"target": 22},
+			},
+		},
+	}}
+
+	got := PublishedPorts(compose, "traefik")
+	want := []PublishedPort{
+		{Port: "80", Protocol: "tcp"},
+		{HostIP: "127.0.0.1", Port: "8080", Protocol: "tcp"},
+		{HostIP: "::1", Port: "9000", Protocol: "tcp"},
+		{Port: "53", Protocol: "udp"},
+		{Port: "8000-8005", Protocol: "tcp"},
+		{Port: "443", Protocol: "tcp"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PublishedPorts() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPortConflicts(t *testing.T) {
+	compose := &ComposeFile{Services: map[string]interface{}{
+		"grafana":  map[string]interface{}{"ports": []interface{}{"3000:3000", "127.0.0.1:9000:9000"}},
+		"homepage": map[string]interface{}{"ports": []interface{}{"0.0.0.0:3000:3000", "192.168.1.2:9000:9000"}},
+		"adguard":  map[string]interface{}{"ports": []interface{}{"53:53/udp", "127.0.0.2:9000:9000"}},
+		"pihole":   map[string]interface{}{"ports": []interface{}{"53:53/tcp", "52-54:52-54/udp"}},
+	}}
+
+	want := []string{
+		"3000/tcp: grafana, homepage",
+		"53/udp: adguard, pihole",
+	}
+	if got := PortConflicts(compose); !reflect.DeepEqual(got, want) {
+		t.Errorf("PortConflicts() = %v, want %v", got, want)
+	}
+}
+
+func TestSetServiceLabel(t *testing.T) {
+	compose := &ComposeFile{
+		Services: map[string]interface{}{
+			"map":  map[string]interface{}{"labels": map[string]interface{}{"a": "1"}},
+			"list": map[string]interface{}{"labels": []interface{}{"a=1", "homelabctl.stack=old"}},
+			"none": map[string]interface{}{"image": "nginx"},
+		},
+	}
+
+	for _, svc := range []string{"map", "list", "none"} {
+		SetServiceLabel(compose, svc, LabelStack, "core")
+
+		labels := ServiceLabels(compose, svc)
+		if labels[LabelStack] != "core" {
+			t.Errorf("%s: label %s = %q, want core", svc, LabelStack, labels[LabelStack])
+		}
+	}
+
+	// Existing list entries are replaced, not duplicated
+	list := compose.Services["list"].(map[string]interface{})["labels"].([]interface{})
+	if len(list) != 2 {
+		t.Errorf("Expected 2 list labels, got %v", list)
+	}
+
+	if ServiceLabels(compose, "map")["a"] != "1" {
+		t.Error("Existing map labels should be preserved")
+	}
+}
+
+func TestNormalizeImage(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                         "nginx:latest",
+		"nginx:1.25":                    "nginx:1.25",
+		"ghcr.io/org/app":               "ghcr.io/org/app:latest",
+		"registry.local:5000/app":       "registry.local:5000/app:latest",
+		"registry.local:5000/app:v1":    "registry.local:5000/app:v1",
+		"nginx@sha256:abcdef0123456789": "nginx@sha256:abcdef0123456789",
+	}
+
+	for input, want := range tests {
+		if got := NormalizeImage(input); got != want {
+			t.Errorf("NormalizeImage(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestServicesUsingVolume(t *testing.T) {
+	compose := &ComposeFile{
+		Services: map[string]interface{}{
+			"app": map[string]interface{}{
+				"volumes": []interface{}{"app_data:/data", "/srv/media:/media:ro"},
+			},
+			"backup": map[string]interface{}{
+				"volumes": []interface{}{
+					map[string]interface{}{"type": "volume", "source": "app_data", "target": "/backup"},
+				},
+			},
+			"other": map[string]interface{}{
+				"volumes": []interface{}{"/data"},
+			},
+		},
+	}
+
+	users := ServicesUsingVolume(compose, "app_data")
+	if strings.Join(users, ",") != "app,backup" {
+		t.Errorf("ServicesUsingVolume(app_data) = %v", users)
+	}
+
+	users = ServicesUsingVolume(compose, "/srv/media")
+	if strings.Join(users, ",") != "app" {
+		t.Errorf("ServicesUsingVolume(/srv/media) = %v", users)
+	}
+}
+
+func TestVolumeName(t *testing.T) {
+	compose := &ComposeFile{
+		Volumes: map[string]interface{}{
+			"plain":    nil,
+			"named":    map[string]interface{}{"name": "custom"},
+			"external": map[string]interface{}{"external": true},
+		},
+	}
+
+	tests := map[string]string{
+		"plain":    "runtime_plain",
+		"named":    "custom",
+		"external": "external",
+	}
+
+	for key, want := range tests {
+		if got := VolumeName(compose, "runtime", key); got != want {
+			t.Errorf("VolumeName(%s) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestMergeComposeFiles_Anchors(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	file := filepath.Join(tmpDir, "apps.yml")
+	content := `x-common: &common
+  restart: unless-stopped
+  environment:
+    TZ: UTC
+  labels: &labels
+    - traefik.enable=true
+
+services:
+  web:
+    <<: *common
+    image: nginx:1
+  api:
+    <<: *common
+    image: api:1
+    environment:
+      MODE: api
+  worker:
+    image: api:1
+    labels: *labels
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	merged, err := MergeComposeFiles([]string{file})
+	if err != nil {
+		t.Fatalf("MergeComposeFiles() unexpected error: %v", err)
+	}
+
+	if len(merged.Services) != 3 {
+		t.Fatalf("Expected 3 services (x- blocks are not services), got %d", len(merged.Services))
+	}
+
+	web := merged.Services["web"].(map[string]interface{})
+	if web["restart"] != "unless-stopped" || web["image"] != "nginx:1" {
+		t.Errorf("web should include the merged anchor: %v", web)
+	}
+
+	// Keys set next to the merge key override the anchor's (shallow merge)
+	api := merged.Services["api"].(map[string]interface{})
+	env := api["environment"].(map[string]interface{})
+	if env["MODE"] != "api" || env["TZ"] != nil {
+		t.Errorf("api environment = %v, want only MODE", env)
+	}
+
+	// Aliased values are independent copies: labeling one service leaves the others alone
+	SetServiceLabel(merged, "web", LabelStack, "apps")
+	if labels := ServiceLabels(merged, "worker"); labels[LabelStack] != "" {
+		t.Errorf("label added to web leaked into worker: %v", labels)
+	}
+	if labels := ServiceLabels(merged, "api"); labels[LabelStack] != "" {
+		t.Errorf("label added to web leaked into api: %v", labels)
+	}
+
+	// The written file contains plain values, no anchors or aliases
+	output := filepath.Join(tmpDir, "merged.yml")
+	if err := WriteComposeFile(output, merged); err != nil {
+		t.Fatalf("WriteComposeFile() unexpected error: %v", err)
+	}
+	data, _ := os.ReadFile(output)
+	if strings.Contains(string(data), "&") || strings.Contains(string(data), "*") || strings.Contains(string(data), "<<") {
+		t.Errorf("merged output should not contain anchors:\n%s", data)
+	}
+}
+
+func TestMergeComposeFiles_CrossFileAnchor(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	file1 := filepath.Join(tmpDir, "base.yml")
+	if err := os.WriteFile(file1, []byte("x-common: &common\n  restart: always\nservices:\n  db:\n    <<: *common\n    image: postgres\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	file2 := filepath.Join(tmpDir, "app.yml")
+	if err := os.WriteFile(file2, []byte("services:\n  app:\n    <<: *common\n    image: app\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	_, err := MergeComposeFiles([]string{file1, file2})
+	if err == nil {
+		t.Fatal("MergeComposeFiles() should fail when an anchor comes from another file")
+	}
+
+	enhanced, ok := err.(*errors.Error)
+	if !ok {
+		t.Fatalf("Expected an enhanced error, got %T: %v", err, err)
+	}
+	if !strings.Contains(enhanced.Message, "app.yml references anchor 'common'") {
+		t.Errorf("Unexpected error message: %s", enhanced.Message)
+	}
+	if enhanced.Class != errors.ClassValidation {
+		t.Errorf("Class = %s, want %s", enhanced.Class, errors.ClassValidation)
+	}
+}
+
+func TestWriteAnnotatedComposeFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	compose := &ComposeFile{
+		Services: map[string]interface{}{
+			"grafana": map[string]interface{}{"image": "grafana/grafana"},
+			"manual":  map[string]interface{}{"image": "busybox"},
+		},
+		Volumes: map[string]interface{}{"grafana_data": map[string]interface{}{}},
+	}
+	annotations := Annotations{
+		"services": {"grafana": "From stack monitoring"},
+		"volumes":  {"grafana_data": "From stack monitoring"},
+	}
+
+	path := filepath.Join(tmpDir, "docker-compose.yml")
+	if err := WriteAnnotatedComposeFile(path, compose, "Generated", annotations); err != nil {
+		t.Fatalf("WriteAnnotatedComposeFile() unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	content := string(data)
+
+	if !strings.HasPrefix(content, "# Generated\n") {
+		t.Errorf("Output should start with the header:\n%s", content)
+	}
+	if !strings.Contains(content, "# From stack monitoring\n    grafana:") {
+		t.Errorf("Service should be annotated:\n%s", content)
+	}
+	if !strings.Contains(content, "# From stack monitoring\n    grafana_data:") {
+		t.Errorf("Volume should be annotated:\n%s", content)
+	}
+	if strings.Count(content, "# From") != 2 {
+		t.Errorf("Only annotated entries should get comments:\n%s", content)
+	}
+
+	// Comments do not change the compose content
+	loaded, err := LoadComposeFile(path)
+	if err != nil {
+		t.Fatalf("Annotated file should load: %v", err)
+	}
+	if len(loaded.Services) != 2 || len(loaded.Volumes) != 1 {
+		t.Errorf("Unexpected content after reload: %+v", loaded)
+	}
+}
+
+func TestMergeLayers(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	base := filepath.Join(tmpDir, "compose.yml")
+	content := `x-common: &common
+  restart: unless-stopped
+services:
+  jellyfin:
+    <<: *common
+    image: jellyfin/jellyfin:10.9
+    ports:
+      - "8096:8096"
+    environment:
+      TZ: UTC
+`
+	if err := os.WriteFile(base, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	gpu := filepath.Join(tmpDir, "compose.gpu.yml")
+	content = `services:
+  jellyfin:
+    image: jellyfin/jellyfin:10.9-gpu
+    ports:
+      - "8096:8096"
+      - "8920:8920"
+    devices:
+      - /dev/dri:/dev/dri
+    environment:
+      JELLYFIN_FFMPEG: /usr/lib/jellyfin-ffmpeg/ffmpeg
+volumes:
+  transcodes: {}
+`
+	if err := os.WriteFile(gpu, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	out := filepath.Join(tmpDir, "merged.yml")
+	if err := MergeLayers([]string{base, gpu}, out); err != nil {
+		t.Fatalf("MergeLayers() unexpected error: %v", err)
+	}
+
+	merged, err := LoadComposeFile(out)
+	if err != nil {
+		t.Fatalf("LoadComposeFile() unexpected error: %v", err)
+	}
+	svc := merged.Services["jellyfin"].(map[string]interface{})
+	if svc["image"] != "jellyfin/jellyfin:10.9-gpu" {
+		t.Errorf("image = %v, want the variant's", svc["image"])
+	}
+	if svc["restart"] != "unless-stopped" {
+		t.Errorf("restart = %v, want the base's merge key", svc["restart"])
+	}
+	if want := []interface{}{"8096:8096", "8920:8920"}; !reflect.DeepEqual(svc["ports"], want) {
+		t.Errorf("ports = %v, want %v", svc["ports"], want)
+	}
+	env := svc["environment"].(map[string]interface{})
+	if env["TZ"] != "UTC" || env["JELLYFIN_FFMPEG"] == nil {
+		t.Errorf("environment = %v, want both layers", env)
+	}
+	if _, ok := merged.Volumes["transcodes"]; !ok {
+		t.Errorf("volumes = %v, want the variant's transcodes", merged.Volumes)
+	}
+
+	// Anchors stay scoped to their file
+	broken := filepath.Join(tmpDir, "compose.broken.yml")
+	if err := os.WriteFile(broken, []byte("services:\n  jellyfin:\n    <<: *common\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	err = MergeLayers([]string{base, broken}, out)
+	if enhanced, ok := err.(*errors.Error); !ok || !strings.Contains(enhanced.Message, "compose.broken.yml references anchor 'common'") {
+		t.Errorf("MergeLayers() error = %v, want the anchor explanation", err)
+	}
+}
+
+func TestMergeLayersServiceFields(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	base := filepath.Join(tmpDir, "compose.yml")
+	content := `services:
+  app:
+    command: ["serve", "--port", "80"]
+    entrypoint: /init
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost"]
+      interval: 30s
+    environment:
+      - MODE=prod
+      - TZ=UTC
+    labels:
+      traefik.enable: "true"
+      tier: web
+    extra_hosts:
+      - "db:10.0.0.2"
+`
+	if err := os.WriteFile(base, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	dev := filepath.Join(tmpDir, "compose.dev.yml")
+	content = `services:
+  app:
+    command: ["serve", "--debug"]
+    entrypoint: ["/init", "--dev"]
+    healthcheck:
+      test: ["CMD", "true"]
+    environment:
+      MODE: dev
+      DEBUG: "1"
+    labels:
+      - tier=dev
+    extra_hosts:
+      db: 127.0.0.1
+      cache: 127.0.0.1
+`
+	if err := os.WriteFile(dev, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	out := filepath.Join(tmpDir, "merged.yml")
+	if err := MergeLayers([]string{base, dev}, out); err != nil {
+		t.Fatalf("MergeLayers() unexpected error: %v", err)
+	}
+	merged, err := LoadComposeFile(out)
+	if err != nil {
+		t.Fatalf("LoadComposeFile() unexpected error: %v", err)
+	}
+	svc := merged.Services["app"].(map[string]interface{})
+
+	tests := []struct {
+		field string
+		got   interface{}
+		want  interface{}
+	}{
+		{"command", svc["command"], []interface{}{"serve", "--debug"}},
+		{"entrypoint", svc["entrypoint"], []interface{}{"/init", "--dev"}},
+		{"healthcheck", svc["healthcheck"], map[string]interface{}{"test": []interface{}{"CMD", "true"}, "interval": "30s"}},
+		{"environment", svc["environment"], []interface{}{"MODE=dev", "TZ=UTC", "DEBUG=1"}},
+		{"labels", svc["labels"], []interface{}{"tier=dev", "traefik.enable=true"}},
+		{"extra_hosts", svc["extra_hosts"], []interface{}{"db:127.0.0.1", "cache:127.0.0.1"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+
+	// Mappings on both sides stay a mapping
+	got := mergeKeyed(map[string]interface{}{"A": "1"}, map[string]interface{}{"A": "2", "B": nil}, "environment")
+	if want := map[string]interface{}{"A": "2", "B": nil}; !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeKeyed() = %v, want %v", got, want)
+	}
+}
+
+func TestMergeLayersTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	base := filepath.Join(tmpDir, "compose.yml")
+	content := `services:
+  app:
+    volumes:
+      - ./data:/data
+      - /cache
+    devices:
+      - /dev/dri/renderD128:/dev/dri/renderD128
+    ports:
+      - "8080:80"
+      - "127.0.0.1:9090:9090"
+    secrets:
+      - db_password
+    networks:
+      frontend:
+        aliases: [web]
+`
+	if err := os.WriteFile(base, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	dev := filepath.Join(tmpDir, "compose.dev.yml")
+	content = `services:
+  app:
+    volumes:
+      - type: bind
+        source: ./src
+        target: /data
+      - ./logs:/logs
+    devices:
+      - /dev/dri/renderD129:/dev/dri/renderD128
+    ports:
+      - "8081:80"
+      - target: 9090
+        published: "9090"
+        host_ip: 127.0.0.1
+        protocol: tcp
+    secrets:
+      - source: db_password_dev
+        target: db_password
+    networks:
+      - frontend
+      - backend
+`
+	if err := os.WriteFile(dev, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	out := filepath.Join(tmpDir, "merged.yml")
+	if err := MergeLayers([]string{base, dev}, out); err != nil {
+		t.Fatalf("MergeLayers() unexpected error: %v", err)
+	}
+	merged, err := LoadComposeFile(out)
+	if err != nil {
+		t.Fatalf("LoadComposeFile() unexpected error: %v", err)
+	}
+	svc := merged.Services["app"].(map[string]interface{})
+
+	tests := []struct {
+		field string
+		got   interface{}
+		want  interface{}
+	}{
+		// The variant's /data mount replaces the base's, in place
+		{"volumes", svc["volumes"], []interface{}{
+			map[string]interface{}{"type": "bind", "source": "./src", "target": "/data"}, "/cache", "./logs:/logs",
+		}},
+		{"devices", svc["devices"], []interface{}{"/dev/dri/renderD129:/dev/dri/renderD128"}},
+		// Another host port for the same container port is another port, as in compose
+		{"ports", svc["ports"], []interface{}{
+			"8080:80",
+			map[string]interface{}{"target": 9090, "published": "9090", "host_ip": "127.0.0.1", "protocol": "tcp"},
+			"8081:80",
+		}},
+		{"secrets", svc["secrets"], []interface{}{map[string]interface{}{"source": "db_password_dev", "target": "db_password"}}},
+		{"networks", svc["networks"], map[string]interface{}{
+			"frontend": map[string]interface{}{"aliases": []interface{}{"web"}}, "backend": nil,
+		}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(tt.got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.field, tt.got, tt.want)
+		}
+	}
+}
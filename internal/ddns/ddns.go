@@ -0,0 +1,347 @@
+// Package ddns keeps the public DNS records of the exposed host names pointed at the
+// homelab's WAN IP, through the API of the DNS provider the inventory selects
+// (Cloudflare or deSEC)
+package ddns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DNS providers
+const (
+	ProviderCloudflare = "cloudflare" // Cloudflare API v4, with an API token allowed to edit the zone's DNS
+	ProviderDesec      = "desec"      // deSEC API, with a token of the account owning the domain
+)
+
+// Providers lists the available providers
+var Providers = []string{ProviderCloudflare, ProviderDesec}
+
+// Defaults of the ddns section
+const (
+	defaultIPURL = "https://api.ipify.org"
+	// deSEC refuses TTLs below 3600
+	defaultCloudflareTTL = 300
+	defaultDesecTTL      = 3600
+)
+
+// requestTimeout bounds each API call
+const requestTimeout = 15 * time.Second
+
+// API endpoints, replaced in tests
+var (
+	cloudflareAPI = "https://api.cloudflare.com/client/v4"
+	desecAPI      = "https://desec.io/api/v1"
+)
+
+// Settings is the ddns section of inventory/vars.yaml
+type Settings struct {
+	Provider string
+	Zone     string   // DNS zone the records are in; defaults to the domain variable
+	TTL      int      // Record TTL in seconds
+	Proxied  bool     // Cloudflare only: proxy the records through Cloudflare
+	IPURL    string   // URL answering the WAN IP as plain text
+	Records  []string // Host names to update besides the exposed ones
+}
+
+// Load reads the ddns section of the inventory variables; ok is false without one
+func Load(vars map[string]interface{}) (settings Settings, ok bool, err error) {
+	section, ok := vars["ddns"].(map[string]interface{})
+	if !ok {
+		return Settings{}, false, nil
+	}
+
+	settings.Provider, _ = section["provider"].(string)
+	settings.Zone, _ = section["zone"].(string)
+	if settings.Zone == "" {
+		settings.Zone, _ = vars["domain"].(string)
+	}
+	settings.TTL, _ = section["ttl"].(int)
+	settings.Proxied, _ = section["proxied"].(bool)
+	settings.IPURL, _ = section["ip_url"].(string)
+	if settings.IPURL == "" {
+		settings.IPURL = defaultIPURL
+	}
+	records, _ := section["records"].([]interface{})
+	for _, r := range records {
+		if name, ok := r.(string); ok && name != "" {
+			settings.Records = append(settings.Records, settings.qualify(name))
+		}
+	}
+
+	switch settings.Provider {
+	case ProviderCloudflare:
+		if settings.TTL == 0 {
+			settings.TTL = defaultCloudflareTTL
+		}
+	case ProviderDesec:
+		if settings.TTL == 0 {
+			settings.TTL = defaultDesecTTL
+		}
+		if settings.Proxied {
+			return settings, true, fmt.Errorf("ddns.proxied is a Cloudflare setting, not available with %s", ProviderDesec)
+		}
+	default:
+		return settings, true, fmt.Errorf("unknown ddns provider: '%s' (available: %s)", settings.Provider, strings.Join(Providers, ", "))
+	}
+	if settings.Zone == "" {
+		return settings, true, fmt.Errorf("ddns needs a zone: set ddns.zone or domain")
+	}
+
+	return settings, true, nil
+}
+
+// qualify makes a name without a dot a host name of the zone
+func (s Settings) qualify(name string) string {
+	if strings.Contains(name, ".") || s.Zone == "" {
+		return name
+	}
+	return name + "." + s.Zone
+}
+
+// InZone reports whether a host name belongs to the zone
+func (s Settings) InZone(host string) bool {
+	return host == s.Zone || strings.HasSuffix(host, "."+s.Zone)
+}
+
+// Hosts returns the sorted, distinct host names to update: the given exposed ones in the
+// zone, then the extra records; skipped lists the exposed ones outside the zone
+func (s Settings) Hosts(exposed []string) (hosts, skipped []string) {
+	seen := make(map[string]bool)
+	for _, host := range append(append([]string{}, exposed...), s.Records...) {
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		if !s.InZone(host) {
+			skipped = append(skipped, host)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	sort.Strings(skipped)
+	return hosts, skipped
+}
+
+// PublicIP returns the WAN IPv4 address the URL answers
+func PublicIP(ipURL string) (string, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Get(ipURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the WAN IP from %s: %w", ipURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", fmt.Errorf("failed to get the WAN IP from %s: %w", ipURL, err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("%s returned %s", ipURL, resp.Status)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil || ip.To4() == nil {
+		return "", fmt.Errorf("%s did not answer an IPv4 address: %q", ipURL, strings.TrimSpace(string(body)))
+	}
+	return ip.String(), nil
+}
+
+// Client updates the A records of a zone
+type Client struct {
+	settings Settings
+	token    string
+	http     *http.Client
+	zoneID   string // Cloudflare zone ID, looked up once
+}
+
+// NewClient returns a client of the settings' provider, authenticated with the API token
+func NewClient(settings Settings, token string) *Client {
+	return &Client{settings: settings, token: token, http: &http.Client{Timeout: requestTimeout}}
+}
+
+// Update is the outcome of syncing a record
+type Update struct {
+	Host     string
+	Previous string // Address the record had; "" when it did not exist
+	Changed  bool
+}
+
+// Sync points a host's A record at the IP, creating the record when missing
+// With dryRun, the record is only compared
+func (c *Client) Sync(host, ip string, dryRun bool) (Update, error) {
+	if c.settings.Provider == ProviderDesec {
+		return c.syncDesec(host, ip, dryRun)
+	}
+	return c.syncCloudflare(host, ip, dryRun)
+}
+
+// cloudflareResponse is the envelope of Cloudflare API responses
+type cloudflareResponse struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// cloudflareRecord is a DNS record of the Cloudflare API
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+func (c *Client) syncCloudflare(host, ip string, dryRun bool) (Update, error) {
+	update := Update{Host: host}
+
+	if c.zoneID == "" {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := c.cloudflare(http.MethodGet, "/zones?name="+url.QueryEscape(c.settings.Zone), nil, &zones); err != nil {
+			return update, err
+		}
+		if len(zones) == 0 {
+			return update, fmt.Errorf("zone %s not found in the Cloudflare account of the token", c.settings.Zone)
+		}
+		c.zoneID = zones[0].ID
+	}
+
+	var records []cloudflareRecord
+	query := "/zones/" + c.zoneID + "/dns_records?type=A&name=" + url.QueryEscape(host)
+	if err := c.cloudflare(http.MethodGet, query, nil, &records); err != nil {
+		return update, err
+	}
+
+	record := cloudflareRecord{Type: "A", Name: host, Content: ip, TTL: c.settings.TTL, Proxied: c.settings.Proxied}
+	if len(records) > 0 {
+		update.Previous = records[0].Content
+		if records[0].Content == ip && records[0].Proxied == c.settings.Proxied {
+			return update, nil
+		}
+	}
+	update.Changed = true
+	if dryRun {
+		return update, nil
+	}
+
+	if len(records) == 0 {
+		return update, c.cloudflare(http.MethodPost, "/zones/"+c.zoneID+"/dns_records", record, nil)
+	}
+	return update, c.cloudflare(http.MethodPut, "/zones/"+c.zoneID+"/dns_records/"+records[0].ID, record, nil)
+}
+
+// cloudflare calls the Cloudflare API and decodes the result into out (if not nil)
+func (c *Client) cloudflare(method, path string, body, out interface{}) error {
+	var response cloudflareResponse
+	if _, err := c.call(method, cloudflareAPI+path, "Bearer "+c.token, body, &response); err != nil {
+		return err
+	}
+	if !response.Success {
+		var messages []string
+		for _, e := range response.Errors {
+			messages = append(messages, e.Message)
+		}
+		return fmt.Errorf("Cloudflare API %s %s failed: %s", method, path, strings.Join(messages, "; "))
+	}
+	if out != nil {
+		if err := json.Unmarshal(response.Result, out); err != nil {
+			return fmt.Errorf("failed to parse Cloudflare API response: %w", err)
+		}
+	}
+	return nil
+}
+
+// desecRRset is a record set of the deSEC API
+type desecRRset struct {
+	Subname string   `json:"subname"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	Records []string `json:"records"`
+}
+
+func (c *Client) syncDesec(host, ip string, dryRun bool) (Update, error) {
+	update := Update{Host: host}
+	subname := strings.TrimSuffix(strings.TrimSuffix(host, c.settings.Zone), ".")
+	base := desecAPI + "/domains/" + c.settings.Zone + "/rrsets/"
+
+	// The zone apex is @ in rrset URLs
+	name := subname
+	if name == "" {
+		name = "@"
+	}
+
+	var current desecRRset
+	status, err := c.call(http.MethodGet, base+name+"/A/", "Token "+c.token, nil, &current)
+	if err != nil && status != http.StatusNotFound {
+		return update, err
+	}
+	if status != http.StatusNotFound && len(current.Records) > 0 {
+		update.Previous = current.Records[0]
+		if len(current.Records) == 1 && current.Records[0] == ip {
+			return update, nil
+		}
+	}
+	update.Changed = true
+	if dryRun {
+		return update, nil
+	}
+
+	// A PUT on the collection creates or replaces the record sets it lists
+	rrsets := []desecRRset{{Subname: subname, Type: "A", TTL: c.settings.TTL, Records: []string{ip}}}
+	_, err = c.call(http.MethodPut, base, "Token "+c.token, rrsets, nil)
+	return update, err
+}
+
+// call sends a JSON request and decodes the JSON response into out (if not nil)
+// It returns the response status, with an error for a status of 300 or more
+func (c *Client) call(method, endpoint, authorization string, body, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, endpoint, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil && resp.StatusCode < 300 {
+			return resp.StatusCode, fmt.Errorf("failed to parse the response of %s: %w", endpoint, err)
+		}
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s %s returned %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(data)))
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,189 @@
+package ddns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	if _, ok, err := Load(map[string]interface{}{}); ok || err != nil {
+		t.Errorf("Load() without a ddns section: ok = %v, err = %v, want not configured", ok, err)
+	}
+
+	settings, ok, err := Load(map[string]interface{}{
+		"domain": "home.example.com",
+		"ddns": map[string]interface{}{
+			"provider": "desec",
+			"records":  []interface{}{"vpn", "mail.home.example.com"},
+		},
+	})
+	if !ok || err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if settings.Zone != "home.example.com" || settings.TTL != defaultDesecTTL || settings.IPURL != defaultIPURL {
+		t.Errorf("Load() = %+v, want the domain as zone and the deSEC defaults", settings)
+	}
+	if want := []string{"vpn.home.example.com", "mail.home.example.com"}; !reflect.DeepEqual(settings.Records, want) {
+		t.Errorf("Records = %v, want %v", settings.Records, want)
+	}
+
+	for name, section := range map[string]map[string]interface{}{
+		"unknown provider":  {"provider": "route53"},
+		"proxied on deSEC":  {"provider": "desec", "proxied": true},
+		"no zone or domain": {"provider": "cloudflare"},
+	} {
+		vars := map[string]interface{}{"ddns": section}
+		if name != "no zone or domain" {
+			vars["domain"] = "example.com"
+		}
+		if _, _, err := Load(vars); err == nil {
+			t.Errorf("Load() with %s: want an error", name)
+		}
+	}
+}
+
+func TestHosts(t *testing.T) {
+	settings := Settings{Zone: "example.com", Records: []string{"example.com", "vpn.example.com"}}
+	hosts, skipped := settings.Hosts([]string{"media.example.com", "media.example.com", "grafana.lan", "notexample.com"})
+
+	if want := []string{"example.com", "media.example.com", "vpn.example.com"}; !reflect.DeepEqual(hosts, want) {
+		t.Errorf("hosts = %v, want %v", hosts, want)
+	}
+	if want := []string{"grafana.lan", "notexample.com"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Query().Get("answer"))
+	}))
+	defer server.Close()
+
+	if ip, err := PublicIP(server.URL + "?answer=203.0.113.7%0A"); err != nil || ip != "203.0.113.7" {
+		t.Errorf("PublicIP() = %q, %v, want 203.0.113.7", ip, err)
+	}
+	if _, err := PublicIP(server.URL + "?answer=2001:db8::1"); err == nil {
+		t.Error("PublicIP() should refuse an IPv6 answer")
+	}
+}
+
+func TestSyncCloudflare(t *testing.T) {
+	var writes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"success":false,"errors":[{"message":"Invalid token"}]}`)
+			return
+		}
+
+		result := "[]"
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones":
+			result = `[{"id":"zone1"}]`
+		case r.Method == http.MethodGet && r.URL.Query().Get("name") == "media.example.com":
+			result = `[{"id":"rec1","type":"A","name":"media.example.com","content":"198.51.100.1","ttl":300}]`
+		case r.Method == http.MethodGet && r.URL.Query().Get("name") == "vpn.example.com":
+			result = `[{"id":"rec2","type":"A","name":"vpn.example.com","content":"203.0.113.7","ttl":300}]`
+		case r.Method != http.MethodGet:
+			var record cloudflareRecord
+			json.NewDecoder(r.Body).Decode(&record)
+			writes = append(writes, r.Method+" "+r.URL.Path+" "+record.Name+"="+record.Content)
+			result = "{}"
+		}
+		fmt.Fprintf(w, `{"success":true,"errors":[],"result":%s}`, result)
+	}))
+	defer server.Close()
+	defer func(api string) { cloudflareAPI = api }(cloudflareAPI)
+	cloudflareAPI = server.URL
+
+	client := NewClient(Settings{Provider: ProviderCloudflare, Zone: "example.com", TTL: 300}, "secret")
+	for _, host := range []string{"media.example.com", "vpn.example.com", "new.example.com"} {
+		if _, err := client.Sync(host, "203.0.113.7", false); err != nil {
+			t.Fatalf("Sync(%s) error = %v", host, err)
+		}
+	}
+
+	want := []string{
+		"PUT /zones/zone1/dns_records/rec1 media.example.com=203.0.113.7",
+		"POST /zones/zone1/dns_records new.example.com=203.0.113.7",
+	}
+	if !reflect.DeepEqual(writes, want) {
+		t.Errorf("writes = %v, want %v", writes, want)
+	}
+
+	// A dry run compares without writing
+	writes = nil
+	update, err := client.Sync("media.example.com", "192.0.2.1", true)
+	if err != nil || !update.Changed || update.Previous != "198.51.100.1" || len(writes) > 0 {
+		t.Errorf("dry run Sync() = %+v, %v, writes %v", update, err, writes)
+	}
+
+	client = NewClient(Settings{Provider: ProviderCloudflare, Zone: "example.com"}, "wrong")
+	if _, err := client.Sync("media.example.com", "203.0.113.7", false); err == nil || !strings.Contains(err.Error(), "Invalid token") {
+		t.Errorf("Sync() with a wrong token error = %v, want the API message", err)
+	}
+}
+
+func TestSyncDesec(t *testing.T) {
+	var puts [][]desecRRset
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/domains/example.com/rrsets/media/A/":
+			fmt.Fprint(w, `{"subname":"media","type":"A","ttl":3600,"records":["203.0.113.7"]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/domains/example.com/rrsets/@/A/":
+			fmt.Fprint(w, `{"subname":"","type":"A","ttl":3600,"records":["198.51.100.1"]}`)
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"detail":"Not found."}`)
+		case r.Method == http.MethodPut && r.URL.Path == "/domains/example.com/rrsets/":
+			var rrsets []desecRRset
+			json.NewDecoder(r.Body).Decode(&rrsets)
+			puts = append(puts, rrsets)
+			fmt.Fprint(w, "[]")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	defer func(api string) { desecAPI = api }(desecAPI)
+	desecAPI = server.URL
+
+	client := NewClient(Settings{Provider: ProviderDesec, Zone: "example.com", TTL: 3600}, "secret")
+	tests := []struct {
+		host     string
+		changed  bool
+		previous string
+	}{
+		{"media.example.com", false, "203.0.113.7"},
+		{"example.com", true, "198.51.100.1"},
+		{"new.example.com", true, ""},
+	}
+	for _, tt := range tests {
+		update, err := client.Sync(tt.host, "203.0.113.7", false)
+		if err != nil {
+			t.Fatalf("Sync(%s) error = %v", tt.host, err)
+		}
+		if update.Changed != tt.changed || update.Previous != tt.previous {
+			t.Errorf("Sync(%s) = %+v, want changed %v from %q", tt.host, update, tt.changed, tt.previous)
+		}
+	}
+
+	want := [][]desecRRset{
+		{{Subname: "", Type: "A", TTL: 3600, Records: []string{"203.0.113.7"}}},
+		{{Subname: "new", Type: "A", TTL: 3600, Records: []string{"203.0.113.7"}}},
+	}
+	if !reflect.DeepEqual(puts, want) {
+		t.Errorf("PUT bodies = %+v, want %+v", puts, want)
+	}
+}
@@ -0,0 +1,46 @@
+package demo
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// ProjectName is the docker compose project used by the demo, kept apart from real deployments
+const ProjectName = "homelabctl-demo"
+
+//go:embed stacks
+var files embed.FS
+
+// Stacks returns the demo stacks in the order they are enabled
+func Stacks() []string {
+	return []string{"whoami", "hello"}
+}
+
+// Materialize writes the embedded demo stacks into dir/stacks
+func Materialize(dir string) error {
+	return fs.WalkDir(files, paths.Stacks, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(path))
+		if entry.IsDir() {
+			return os.MkdirAll(target, paths.DirPermissions)
+		}
+
+		data, err := files.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read embedded %s: %w", path, err)
+		}
+
+		if err := os.WriteFile(target, data, paths.FilePermissions); err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+		return nil
+	})
+}
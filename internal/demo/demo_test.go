@@ -0,0 +1,31 @@
+package demo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestMaterialize(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	if err := Materialize(tmpDir); err != nil {
+		t.Fatalf("Materialize() error: %v", err)
+	}
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	for _, name := range Stacks() {
+		if _, err := stacks.LoadStack(name); err != nil {
+			t.Errorf("demo stack %s is invalid: %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join("stacks", name, "compose.yml.tmpl")); err != nil {
+			t.Errorf("demo stack %s has no compose template: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package docgen
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+)
+
+// Page is a format-independent documentation page
+type Page struct {
+	Title    string
+	Intro    string
+	Sections []Section
+}
+
+// Section is a titled block holding paragraphs, a bullet list, a table
+// and/or raw markdown (shown preformatted in HTML)
+type Section struct {
+	Heading    string
+	Paragraphs []string
+	List       []string
+	Table      *Table
+	Raw        string
+}
+
+// Table is a simple header + rows table
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// Markdown renders the page as GitHub-flavored markdown
+func (p *Page) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", p.Title)
+	if p.Intro != "" {
+		fmt.Fprintf(&b, "%s\n\n", p.Intro)
+	}
+
+	for _, section := range p.Sections {
+		fmt.Fprintf(&b, "## %s\n\n", section.Heading)
+
+		for _, para := range section.Paragraphs {
+			fmt.Fprintf(&b, "%s\n\n", para)
+		}
+
+		if len(section.List) > 0 {
+			for _, item := range section.List {
+				fmt.Fprintf(&b, "- %s\n", item)
+			}
+			b.WriteString("\n")
+		}
+
+		if section.Table != nil && len(section.Table.Rows) > 0 {
+			b.WriteString("| " + strings.Join(section.Table.Header, " | ") + " |\n")
+			b.WriteString("|" + strings.Repeat("---|", len(section.Table.Header)) + "\n")
+			for _, row := range section.Table.Rows {
+				cells := make([]string, len(row))
+				for i, cell := range row {
+					cells[i] = strings.ReplaceAll(cell, "|", "\\|")
+				}
+				b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			}
+			b.WriteString("\n")
+		}
+
+		if section.Raw != "" {
+			fmt.Fprintf(&b, "%s\n\n", section.Raw)
+		}
+	}
+
+	return b.String()
+}
+
+// HTML renders the page as a standalone HTML document
+func (p *Page) HTML() string {
+	var b strings.Builder
+
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(p.Title))
+	b.WriteString("<style>body{font-family:sans-serif;max-width:60em;margin:2em auto;padding:0 1em}" +
+		"table{border-collapse:collapse}td,th{border:1px solid #ccc;padding:.3em .6em;text-align:left}" +
+		"code{background:#f4f4f4;padding:0 .2em}</style>\n")
+	b.WriteString("</head>\n<body>\n")
+
+	fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(p.Title))
+	if p.Intro != "" {
+		fmt.Fprintf(&b, "<p>%s</p>\n", inlineHTML(p.Intro))
+	}
+
+	for _, section := range p.Sections {
+		fmt.Fprintf(&b, "<h2>%s</h2>\n", html.EscapeString(section.Heading))
+
+		for _, para := range section.Paragraphs {
+			fmt.Fprintf(&b, "<p>%s</p>\n", inlineHTML(para))
+		}
+
+		if len(section.List) > 0 {
+			b.WriteString("<ul>\n")
+			for _, item := range section.List {
+				fmt.Fprintf(&b, "<li>%s</li>\n", inlineHTML(item))
+			}
+			b.WriteString("</ul>\n")
+		}
+
+		if section.Table != nil && len(section.Table.Rows) > 0 {
+			b.WriteString("<table>\n<tr>")
+			for _, h := range section.Table.Header {
+				fmt.Fprintf(&b, "<th>%s</th>", html.EscapeString(h))
+			}
+			b.WriteString("</tr>\n")
+			for _, row := range section.Table.Rows {
+				b.WriteString("<tr>")
+				for _, cell := range row {
+					fmt.Fprintf(&b, "<td>%s</td>", inlineHTML(cell))
+				}
+				b.WriteString("</tr>\n")
+			}
+			b.WriteString("</table>\n")
+		}
+
+		if section.Raw != "" {
+			fmt.Fprintf(&b, "<pre>%s</pre>\n", html.EscapeString(section.Raw))
+		}
+	}
+
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+// MarkdownLink formats a link to a generated page (converted to .html by HTML)
+func MarkdownLink(text, page string) string {
+	return fmt.Sprintf("[%s](%s.md)", text, page)
+}
+
+// inlineHTML escapes text and converts the markdown subset produced here
+// (`code` spans and [text](page.md) links) to HTML
+func inlineHTML(text string) string {
+	var b strings.Builder
+
+	for len(text) > 0 {
+		switch {
+		case text[0] == '`':
+			end := strings.IndexByte(text[1:], '`')
+			if end < 0 {
+				b.WriteString(html.EscapeString(text))
+				return b.String()
+			}
+			fmt.Fprintf(&b, "<code>%s</code>", html.EscapeString(text[1:end+1]))
+			text = text[end+2:]
+		case text[0] == '[':
+			closeText := strings.Index(text, "](")
+			closeLink := strings.IndexByte(text, ')')
+			if closeText < 0 || closeLink < closeText {
+				b.WriteString(html.EscapeString(text[:1]))
+				text = text[1:]
+				continue
+			}
+			label := text[1:closeText]
+			href := strings.TrimSuffix(text[closeText+2:closeLink], ".md") + ".html"
+			fmt.Fprintf(&b, "<a href=\"%s\">%s</a>", html.EscapeString(href), html.EscapeString(label))
+			text = text[closeLink+1:]
+		default:
+			next := strings.IndexAny(text, "`[")
+			if next < 0 {
+				next = len(text)
+			}
+			b.WriteString(html.EscapeString(text[:next]))
+			text = text[next:]
+		}
+	}
+
+	return b.String()
+}
+
+// FlattenVars turns nested variables into sorted dotted-path rows with printable defaults
+func FlattenVars(vars map[string]interface{}) [][]string {
+	var rows [][]string
+	flattenInto("", vars, &rows)
+
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i][0] < rows[j][0]
+	})
+
+	return rows
+}
+
+func flattenInto(prefix string, vars map[string]interface{}, rows *[][]string) {
+	for key, value := range vars {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			flattenInto(path, nested, rows)
+			continue
+		}
+
+		*rows = append(*rows, []string{"`" + path + "`", FormatValue(value)})
+	}
+}
+
+// FormatValue renders a variable default for documentation
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "_(none)_"
+	case string:
+		if v == "" {
+			return "`\"\"`"
+		}
+		return "`" + v + "`"
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprintf("%v", item)
+		}
+		return "`[" + strings.Join(items, ", ") + "]`"
+	default:
+		return fmt.Sprintf("`%v`", v)
+	}
+}
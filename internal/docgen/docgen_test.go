@@ -0,0 +1,127 @@
+package docgen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+func testStack() *stacks.Stack {
+	stack := &stacks.Stack{
+		Name:     "jellyfin",
+		Category: "media",
+		Requires: []string{"traefik"},
+		Services: []string{"jellyfin"},
+		Vars: map[string]interface{}{
+			"jellyfin": map[string]interface{}{
+				"image": "jellyfin/jellyfin:latest",
+				"port":  8096,
+			},
+		},
+	}
+	stack.Persistence.Volumes = []string{"jellyfin_config"}
+	return stack
+}
+
+func TestFlattenVars(t *testing.T) {
+	rows := FlattenVars(map[string]interface{}{
+		"app": map[string]interface{}{
+			"image": "nginx",
+			"env":   map[string]interface{}{"TZ": "UTC"},
+		},
+		"debug": false,
+		"tags":  []interface{}{"a", "b"},
+	})
+
+	want := [][]string{
+		{"`app.env.TZ`", "`UTC`"},
+		{"`app.image`", "`nginx`"},
+		{"`debug`", "`false`"},
+		{"`tags`", "`[a, b]`"},
+	}
+
+	if len(rows) != len(want) {
+		t.Fatalf("FlattenVars() returned %d rows, want %d: %v", len(rows), len(want), rows)
+	}
+	for i := range want {
+		if rows[i][0] != want[i][0] || rows[i][1] != want[i][1] {
+			t.Errorf("row %d = %v, want %v", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestStackPageMarkdown(t *testing.T) {
+	page := StackPage(testStack(), []string{"jellyseerr"}, "# Jellyfin\n\nMedia server")
+	md := page.Markdown()
+
+	for _, want := range []string{
+		"# jellyfin",
+		"| `jellyfin` | `jellyfin/jellyfin:latest` |",
+		"Requires [traefik](traefik.md)",
+		"Required by [jellyseerr](jellyseerr.md)",
+		"| `jellyfin.port` | `8096` |",
+		"Volume `jellyfin_config`",
+		"Media server",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q\n%s", want, md)
+		}
+	}
+}
+
+func TestStackPageVarsSchema(t *testing.T) {
+	stack := testStack()
+	stack.VarsSchema = map[string]stacks.VarSchema{
+		"jellyfin.port":       {Description: "Web UI port"},
+		"jellyfin.public_url": {Description: "External URL", Required: true},
+	}
+
+	md := StackPage(stack, nil, "").Markdown()
+	for _, want := range []string{
+		"| Variable | Default | Description |",
+		"| `jellyfin.image` | `jellyfin/jellyfin:latest` |  |",
+		"| `jellyfin.port` | `8096` | Web UI port |",
+		"| `jellyfin.public_url` | _(required)_ | External URL |",
+	} {
+		if !strings.Contains(md, want) {
+			t.Errorf("markdown missing %q\n%s", want, md)
+		}
+	}
+}
+
+func TestStackPageHTML(t *testing.T) {
+	page := StackPage(testStack(), nil, "<b>raw</b>")
+	out := page.HTML()
+
+	for _, want := range []string{
+		"<title>jellyfin</title>",
+		`<a href="traefik.html">traefik</a>`,
+		"<code>jellyfin/jellyfin:latest</code>",
+		"<pre>&lt;b&gt;raw&lt;/b&gt;</pre>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("HTML missing %q\n%s", want, out)
+		}
+	}
+}
+
+func TestIndexPageAndRequiredBy(t *testing.T) {
+	traefik := &stacks.Stack{Name: "traefik", Category: "core", Services: []string{"traefik"}}
+	all := []*stacks.Stack{testStack(), traefik}
+
+	requiredBy := RequiredBy(all)
+	if got := requiredBy["traefik"]; len(got) != 1 || got[0] != "jellyfin" {
+		t.Errorf("RequiredBy()[traefik] = %v, want [jellyfin]", got)
+	}
+
+	md := IndexPage(all).Markdown()
+	core := strings.Index(md, "## Core")
+	media := strings.Index(md, "## Media")
+	if core < 0 || media < 0 || core > media {
+		t.Errorf("index should list Core before Media\n%s", md)
+	}
+	if !strings.Contains(md, "[jellyfin](stacks/jellyfin.md)") {
+		t.Errorf("index missing stack link\n%s", md)
+	}
+}
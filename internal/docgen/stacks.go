@@ -0,0 +1,174 @@
+package docgen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// StackPage builds the documentation page of a single stack
+// requiredBy lists the stacks that depend on it
+func StackPage(stack *stacks.Stack, requiredBy []string, readme string) *Page {
+	page := &Page{
+		Title: stack.Name,
+		Intro: fmt.Sprintf("Category: %s · %s", stack.Category, MarkdownLink("All stacks", "../index")),
+	}
+
+	serviceRows := make([][]string, 0, len(stack.Services))
+	for _, svc := range stack.Services {
+		image := ""
+		if vars, ok := stack.Vars[svc].(map[string]interface{}); ok {
+			if img, ok := vars["image"].(string); ok {
+				image = "`" + img + "`"
+			}
+		}
+		serviceRows = append(serviceRows, []string{"`" + svc + "`", image})
+	}
+	page.Sections = append(page.Sections, Section{
+		Heading: "Services",
+		Table:   &Table{Header: []string{"Service", "Image"}, Rows: serviceRows},
+	})
+
+	deps := Section{Heading: "Dependencies"}
+	if len(stack.Requires) == 0 && len(requiredBy) == 0 {
+		deps.Paragraphs = append(deps.Paragraphs, "None")
+	}
+	for _, dep := range stack.Requires {
+		deps.List = append(deps.List, "Requires "+MarkdownLink(dep, dep))
+	}
+	for _, dep := range requiredBy {
+		deps.List = append(deps.List, "Required by "+MarkdownLink(dep, dep))
+	}
+	page.Sections = append(page.Sections, deps)
+
+	variables := Section{Heading: "Variables"}
+	if rows := stackVarRows(stack); len(rows) > 0 {
+		variables.Paragraphs = append(variables.Paragraphs,
+			"Defaults from `stack.yaml`; override them in `inventory/vars.yaml`.")
+		header := []string{"Variable", "Default"}
+		if len(stack.VarsSchema) > 0 {
+			header = append(header, "Description")
+		}
+		variables.Table = &Table{Header: header, Rows: rows}
+	} else {
+		variables.Paragraphs = append(variables.Paragraphs, "None")
+	}
+	page.Sections = append(page.Sections, variables)
+
+	if len(stack.Persistence.Volumes) > 0 || len(stack.Persistence.Paths) > 0 || len(stack.Persistence.Shares) > 0 {
+		persistence := Section{Heading: "Persistence"}
+		for _, vol := range stack.Persistence.Volumes {
+			persistence.List = append(persistence.List, "Volume `"+vol+"`")
+		}
+		for _, path := range stack.Persistence.Paths {
+			persistence.List = append(persistence.List, "Path `"+path+"`")
+		}
+		for _, name := range stack.ShareNames() {
+			persistence.List = append(persistence.List, "Share `"+name+"` ("+stack.Persistence.Shares[name].String()+")")
+		}
+		page.Sections = append(page.Sections, persistence)
+	}
+
+	if readme = strings.TrimSpace(readme); readme != "" {
+		page.Sections = append(page.Sections, Section{
+			Heading: "README",
+			Raw:     readme,
+		})
+	}
+
+	return page
+}
+
+// stackVarRows lists a stack's variables with their defaults, adding required
+// vars_schema variables that have no default and a description column when a schema exists
+func stackVarRows(stack *stacks.Stack) [][]string {
+	rows := FlattenVars(stack.Vars)
+	if len(stack.VarsSchema) == 0 {
+		return rows
+	}
+
+	listed := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		listed[strings.Trim(row[0], "`")] = true
+	}
+	for _, path := range stack.SchemaPaths() {
+		if !listed[path] {
+			rows = append(rows, []string{"`" + path + "`", ""})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i][0] < rows[j][0]
+	})
+
+	for i, row := range rows {
+		path := strings.Trim(row[0], "`")
+		schema := stack.VarsSchema[path]
+		if row[1] == "" || row[1] == FormatValue(nil) {
+			rows[i][1] = FormatValue(nil)
+			if schema.Required {
+				rows[i][1] = "_(required)_"
+			}
+		}
+		rows[i] = append(rows[i], schema.Description)
+	}
+	return rows
+}
+
+// IndexPage builds the overview page listing every stack grouped by category
+func IndexPage(all []*stacks.Stack) *Page {
+	page := &Page{
+		Title: "Stacks",
+		Intro: fmt.Sprintf("%d stack(s) available in this repository.", len(all)),
+	}
+
+	byCategory := make(map[string][]*stacks.Stack)
+	for _, stack := range all {
+		categories.RegisterCategory(stack.Category)
+		byCategory[stack.Category] = append(byCategory[stack.Category], stack)
+	}
+
+	for _, category := range categories.AllCategories() {
+		members := byCategory[category.Name]
+		if len(members) == 0 {
+			continue
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return members[i].Name < members[j].Name
+		})
+
+		rows := make([][]string, 0, len(members))
+		for _, stack := range members {
+			rows = append(rows, []string{
+				MarkdownLink(stack.Name, "stacks/"+stack.Name),
+				strings.Join(stack.Services, ", "),
+				strings.Join(stack.Requires, ", "),
+			})
+		}
+
+		page.Sections = append(page.Sections, Section{
+			Heading: category.DisplayName,
+			Table:   &Table{Header: []string{"Stack", "Services", "Requires"}, Rows: rows},
+		})
+	}
+
+	return page
+}
+
+// RequiredBy maps each stack to the sorted list of stacks requiring it
+func RequiredBy(all []*stacks.Stack) map[string][]string {
+	result := make(map[string][]string)
+	for _, stack := range all {
+		for _, dep := range stack.Requires {
+			result[dep] = append(result[dep], stack.Name)
+		}
+	}
+
+	for name := range result {
+		sort.Strings(result[name])
+	}
+
+	return result
+}
@@ -0,0 +1,353 @@
+// Package docker is a minimal client for the Docker Engine API
+// It talks HTTP to the daemon of DOCKER_HOST or the docker context for the calls where
+// structured data beats parsing docker CLI output, and for native deploys
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// DefaultHost is the daemon address used without DOCKER_HOST or a docker context
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Client sends requests to the Docker Engine API
+type Client struct {
+	http *http.Client
+	base string // URL prefix, e.g. http://docker
+	host string // Daemon address, for error messages
+}
+
+// Container is an entry of the container list
+type Container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// ContainerDetails holds the container inspect fields homelabctl reads
+// docker inspect and podman inspect print the same layout
+type ContainerDetails struct {
+	ID    string `json:"Id"`
+	Image string `json:"Image"` // Image ID
+	State struct {
+		Status   string `json:"Status"`
+		ExitCode int    `json:"ExitCode"`
+		Health   *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+		Tty    bool              `json:"Tty"`
+	} `json:"Config"`
+}
+
+// Event is a message from the daemon event stream
+type Event struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+	Actor  struct {
+		ID         string            `json:"ID"`
+		Attributes map[string]string `json:"Attributes"` // Container name, image and labels
+	} `json:"Actor"`
+	TimeNano int64 `json:"timeNano"`
+}
+
+// EventStream decodes events as the daemon sends them
+type EventStream struct {
+	body    io.ReadCloser
+	decoder *json.Decoder
+}
+
+// Next blocks until the next event arrives
+func (s *EventStream) Next() (Event, error) {
+	var event Event
+	err := s.decoder.Decode(&event)
+	return event, err
+}
+
+// Close ends the stream
+func (s *EventStream) Close() error {
+	return s.body.Close()
+}
+
+// LogOptions selects which log lines to stream
+type LogOptions struct {
+	Follow     bool
+	Since      string // Unix timestamp
+	Until      string // Unix timestamp
+	Tail       string // Number of lines, or "all"
+	Timestamps bool
+}
+
+// NewClient creates a client for host (unix://, tcp:// or ssh://), or DefaultHost when empty
+func NewClient(host string) (*Client, error) {
+	return Connect(Endpoint{Host: host})
+}
+
+// Connect creates a client for an endpoint, or DefaultHost when its host is empty
+func Connect(endpoint Endpoint) (*Client, error) {
+	host := endpoint.Host
+	if host == "" {
+		host = DefaultHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &Client{http: &http.Client{Transport: transport}, base: "http://docker", host: host}, nil
+	case "ssh":
+		transport := &http.Transport{DialContext: sshDialer(u)}
+		return &Client{http: &http.Client{Transport: transport}, base: "http://docker", host: host}, nil
+	case "tcp", "http", "https":
+		if endpoint.TLS != nil || u.Scheme == "https" {
+			transport := &http.Transport{TLSClientConfig: endpoint.TLS}
+			return &Client{http: &http.Client{Transport: transport}, base: "https://" + u.Host, host: host}, nil
+		}
+		return &Client{http: &http.Client{}, base: "http://" + u.Host, host: host}, nil
+	default:
+		return nil, errors.New(
+			fmt.Sprintf("unsupported docker host %q", host),
+			"Use a unix://, tcp:// or ssh:// DOCKER_HOST or docker context",
+			"For remote machines, use --host with inventory/hosts.yaml",
+		).WithClass(errors.ClassDocker)
+	}
+}
+
+// ListContainers returns containers matching the filters (e.g. "label": {"a=b"})
+// Stopped containers are included when all is set
+func (c *Client) ListContainers(all bool, filters map[string][]string) ([]Container, error) {
+	query := url.Values{}
+	if all {
+		query.Set("all", "1")
+	}
+	if len(filters) > 0 {
+		data, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(data))
+	}
+
+	var containers []Container
+	if err := c.getJSON("/containers/json?"+query.Encode(), &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// InspectContainer returns a container's state and configuration
+func (c *Client) InspectContainer(id string) (*ContainerDetails, error) {
+	var details ContainerDetails
+	if err := c.getJSON("/containers/"+url.PathEscape(id)+"/json", &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// HasTTY reports whether a container was created with a terminal, in which case its
+// logs are a raw stream instead of a multiplexed one
+func (c *Client) HasTTY(id string) (bool, error) {
+	details, err := c.InspectContainer(id)
+	if err != nil {
+		return false, err
+	}
+	return details.Config.Tty, nil
+}
+
+// Logs streams a container's stdout and stderr; the caller closes the stream
+// Without a TTY the stream is multiplexed, see Demux
+func (c *Client) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("stdout", "1")
+	query.Set("stderr", "1")
+	if opts.Follow {
+		query.Set("follow", "1")
+	}
+	if opts.Since != "" {
+		query.Set("since", opts.Since)
+	}
+	if opts.Until != "" {
+		query.Set("until", opts.Until)
+	}
+	if opts.Tail != "" {
+		query.Set("tail", opts.Tail)
+	}
+	if opts.Timestamps {
+		query.Set("timestamps", "1")
+	}
+
+	resp, err := c.get(ctx, "/containers/"+url.PathEscape(id)+"/logs?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Events streams daemon events matching the filters, replaying those after since
+// (a Unix timestamp, optional) first; the stream ends when ctx is done
+func (c *Client) Events(ctx context.Context, since string, filters map[string][]string) (*EventStream, error) {
+	query := url.Values{}
+	if since != "" {
+		query.Set("since", since)
+	}
+	if len(filters) > 0 {
+		data, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		query.Set("filters", string(data))
+	}
+
+	resp, err := c.get(ctx, "/events?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return &EventStream{body: resp.Body, decoder: json.NewDecoder(resp.Body)}, nil
+}
+
+// Demux splits a multiplexed log stream into stdout and stderr
+// Each frame is an 8-byte header (stream type, 3 zero bytes, big-endian size) and its payload
+func Demux(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read log frame: %w", err)
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case 0, 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			return fmt.Errorf("invalid log stream type %d", header[0])
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return fmt.Errorf("failed to read log frame: %w", err)
+		}
+	}
+}
+
+// getJSON performs a GET request and decodes the JSON response into v
+func (c *Client) getJSON(path string, v interface{}) error {
+	resp, err := c.get(context.Background(), path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode docker API response: %w", err)
+	}
+	return nil
+}
+
+// get performs a GET request, turning API error responses into errors
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, path, nil)
+}
+
+// do performs a request with an optional JSON body, turning API error responses into errors
+func (c *Client) do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	req, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(req)
+}
+
+// request builds a request with an optional JSON body
+func (c *Client) request(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// send performs a request, turning API error responses into errors
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err,
+			fmt.Sprintf("cannot connect to the Docker daemon at %s", c.host),
+			"Check that Docker is running: docker info",
+			"Check DOCKER_HOST, the docker context and your access to the Docker socket",
+		).WithClass(errors.ClassDocker)
+	}
+
+	// 304: the container was already started or stopped
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(body))
+		}
+		return nil, &APIError{Status: resp.StatusCode, Message: fmt.Sprintf("docker API %s: %s", resp.Status, apiErr.Message)}
+	}
+
+	return resp, nil
+}
+
+// APIError is an error response of the daemon
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// IsNotFound reports whether err is a 404 from the daemon (no such container, image...)
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return stderrors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
@@ -0,0 +1,274 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// frame builds one multiplexed log frame
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func TestDemux(t *testing.T) {
+	var input []byte
+	input = append(input, frame(1, "hello\n")...)
+	input = append(input, frame(2, "oops\n")...)
+	input = append(input, frame(1, "partial ")...)
+	input = append(input, frame(1, "line\n")...)
+
+	var stdout, stderr bytes.Buffer
+	if err := Demux(bytes.NewReader(input), &stdout, &stderr); err != nil {
+		t.Fatalf("Demux() error = %v", err)
+	}
+
+	if stdout.String() != "hello\npartial line\n" {
+		t.Errorf("stdout = %q", stdout.String())
+	}
+	if stderr.String() != "oops\n" {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+
+	// A truncated frame is an error
+	truncated := frame(1, "hello\n")[:10]
+	if err := Demux(bytes.NewReader(truncated), io.Discard, io.Discard); err == nil {
+		t.Error("Demux() should fail on a truncated frame")
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		host    string
+		wantErr bool
+	}{
+		{"", false},
+		{"unix:///run/user/1000/docker.sock", false},
+		{"tcp://127.0.0.1:2375", false},
+		{"ssh://admin@nas.lan:2222", false},
+		{"npipe:////./pipe/docker_engine", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			_, err := NewClient(tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewClient(%q) error = %v, wantErr %v", tt.host, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/containers/json":
+			if r.URL.Query().Get("all") != "1" || !strings.Contains(r.URL.Query().Get("filters"), "com.docker.compose.project=runtime") {
+				t.Errorf("unexpected list query: %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, `[{"Id":"abc","Names":["/runtime-grafana-1"],"State":"running","Labels":{"homelabctl.stack":"monitoring"}}]`)
+		case r.URL.Path == "/containers/abc/json":
+			fmt.Fprint(w, `{"Config":{"Tty":true}}`)
+		case r.URL.Path == "/containers/abc/logs":
+			if r.URL.Query().Get("tail") != "10" || r.URL.Query().Get("follow") != "" {
+				t.Errorf("unexpected logs query: %s", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, "line one\nline two\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"No such container: missing"}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	containers, err := client.ListContainers(true, map[string][]string{"label": {"com.docker.compose.project=runtime"}})
+	if err != nil {
+		t.Fatalf("ListContainers() error = %v", err)
+	}
+	if len(containers) != 1 || containers[0].ID != "abc" || containers[0].Labels["homelabctl.stack"] != "monitoring" {
+		t.Errorf("ListContainers() = %+v", containers)
+	}
+
+	tty, err := client.HasTTY("abc")
+	if err != nil || !tty {
+		t.Errorf("HasTTY() = %v, %v; want true", tty, err)
+	}
+
+	stream, err := client.Logs(context.Background(), "abc", LogOptions{Tail: "10"})
+	if err != nil {
+		t.Fatalf("Logs() error = %v", err)
+	}
+	data, _ := io.ReadAll(stream)
+	stream.Close()
+	if string(data) != "line one\nline two\n" {
+		t.Errorf("Logs() = %q", data)
+	}
+
+	_, err = client.HasTTY("missing")
+	if err == nil || !strings.Contains(err.Error(), "No such container") {
+		t.Errorf("HasTTY(missing) error = %v, want API message", err)
+	}
+}
+
+func TestClientResources(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		switch r.URL.Path {
+		case "/containers/create":
+			if r.URL.Query().Get("name") != "runtime-grafana-1" || !strings.Contains(string(body), `"Image":"grafana/grafana"`) {
+				t.Errorf("unexpected create request: %s %s", r.URL.RawQuery, body)
+			}
+			fmt.Fprint(w, `{"Id":"abc"}`)
+		case "/containers/abc/start":
+			w.WriteHeader(http.StatusNotModified) // Already running
+		case "/images/create":
+			if r.URL.Query().Get("fromImage") != "grafana/grafana" || r.URL.Query().Get("tag") != "10.2" {
+				t.Errorf("unexpected pull query: %s", r.URL.RawQuery)
+			}
+			auth, _ := base64.URLEncoding.DecodeString(r.Header.Get("X-Registry-Auth"))
+			if !strings.Contains(string(auth), `"username":"homelab"`) {
+				t.Errorf("unexpected registry auth: %s", auth)
+			}
+			fmt.Fprint(w, `{"status":"Pulling"}`+"\n"+`{"error":"toomanyrequests"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"no such image"}`)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	id, err := client.CreateContainer("runtime-grafana-1", &ContainerSpec{Image: "grafana/grafana"})
+	if err != nil || id != "abc" {
+		t.Fatalf("CreateContainer() = %q, %v", id, err)
+	}
+	if err := client.StartContainer(id); err != nil {
+		t.Errorf("StartContainer() on a running container error = %v", err)
+	}
+
+	if err := client.PullImage("grafana/grafana:10.2", &AuthConfig{Username: "homelab", Password: "secret"}); err == nil || !strings.Contains(err.Error(), "toomanyrequests") {
+		t.Errorf("PullImage() error = %v, want the pull error", err)
+	}
+
+	if _, err := client.ImageID("missing"); !IsNotFound(err) {
+		t.Errorf("ImageID(missing) error = %v, want not found", err)
+	}
+
+	want := "POST /containers/create,POST /containers/abc/start,POST /images/create,GET /images/missing/json"
+	if got := strings.Join(requests, ","); got != want {
+		t.Errorf("requests = %s, want %s", got, want)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" || r.URL.Query().Get("since") != "1714557600" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
+		fmt.Fprintln(w, `{"Type":"container","Action":"start","Actor":{"ID":"abc","Attributes":{"name":"runtime-grafana-1"}},"timeNano":1714557600000000000}`)
+		fmt.Fprintln(w, `{"Type":"container","Action":"health_status: healthy","Actor":{"ID":"abc","Attributes":{"name":"runtime-grafana-1"}},"timeNano":1714557601000000000}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	stream, err := client.Events(context.Background(), "1714557600", map[string][]string{"type": {"container"}})
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+	defer stream.Close()
+
+	var actions []string
+	for {
+		event, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if event.Actor.Attributes["name"] != "runtime-grafana-1" {
+			t.Errorf("event actor = %+v", event.Actor)
+		}
+		actions = append(actions, event.Action)
+	}
+
+	if strings.Join(actions, ",") != "start,health_status: healthy" {
+		t.Errorf("actions = %v", actions)
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	t.Setenv("DOCKER_TLS", "")
+	t.Setenv("DOCKER_TLS_VERIFY", "")
+
+	endpoint, err := ResolveEndpoint()
+	if err != nil || endpoint.Host != DefaultHost {
+		t.Errorf("ResolveEndpoint() = %+v, %v; want the default socket", endpoint, err)
+	}
+
+	// A context selected with 'docker context use', stored under the SHA-256 of its name
+	sum := sha256.Sum256([]byte("nas"))
+	meta := filepath.Join(dir, "contexts", "meta", hex.EncodeToString(sum[:]))
+	if err := os.MkdirAll(meta, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(meta, "meta.json"), []byte(`{"Name":"nas","Endpoints":{"docker":{"Host":"ssh://admin@nas.lan","SkipTLSVerify":false}}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext":"nas"}`), 0644)
+
+	endpoint, err = ResolveEndpoint()
+	if err != nil || endpoint.Host != "ssh://admin@nas.lan" || endpoint.TLS != nil {
+		t.Errorf("ResolveEndpoint() = %+v, %v; want the nas context", endpoint, err)
+	}
+
+	t.Setenv("DOCKER_CONTEXT", "missing")
+	if _, err := ResolveEndpoint(); err == nil || !strings.Contains(err.Error(), `docker context "missing" not found`) {
+		t.Errorf("ResolveEndpoint() error = %v, want missing context", err)
+	}
+
+	// DOCKER_HOST wins over contexts, with TLS from the environment
+	t.Setenv("DOCKER_HOST", "tcp://docker.lan:2376")
+	t.Setenv("DOCKER_TLS_VERIFY", "1")
+	t.Setenv("DOCKER_CERT_PATH", t.TempDir())
+	endpoint, err = ResolveEndpoint()
+	if err != nil || endpoint.Host != "tcp://docker.lan:2376" || endpoint.TLS == nil || endpoint.TLS.InsecureSkipVerify {
+		t.Errorf("ResolveEndpoint() = %+v, %v; want verified TLS to docker.lan", endpoint, err)
+	}
+	client, err := Connect(endpoint)
+	if err != nil || client.base != "https://docker.lan:2376" {
+		t.Errorf("Connect() = %+v, %v; want https", client, err)
+	}
+}
@@ -0,0 +1,226 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Endpoint is a daemon address with the TLS settings to reach it
+type Endpoint struct {
+	Host string      // unix://, tcp:// or ssh:// address
+	TLS  *tls.Config // nil for plain connections
+}
+
+// ResolveEndpoint returns the daemon the docker CLI talks to: DOCKER_HOST with the
+// DOCKER_TLS_VERIFY and DOCKER_CERT_PATH settings, else the docker context named by
+// DOCKER_CONTEXT or selected with 'docker context use', else DefaultHost
+func ResolveEndpoint() (Endpoint, error) {
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		config, err := envTLS()
+		if err != nil {
+			return Endpoint{}, err
+		}
+		return Endpoint{Host: host, TLS: config}, nil
+	}
+
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		name = currentContext()
+	}
+	if name == "" || name == "default" {
+		return Endpoint{Host: DefaultHost}, nil
+	}
+	return contextEndpoint(name)
+}
+
+// FromEnv creates a client for the daemon of ResolveEndpoint
+func FromEnv() (*Client, error) {
+	endpoint, err := ResolveEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	return Connect(endpoint)
+}
+
+// configDir is the docker CLI configuration directory
+func configDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// currentContext returns the context selected with 'docker context use', if any
+func currentContext() string {
+	data, err := os.ReadFile(filepath.Join(configDir(), "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if json.Unmarshal(data, &config) != nil {
+		return ""
+	}
+	return config.CurrentContext
+}
+
+// contextEndpoint reads the docker endpoint of a context from the CLI context store,
+// where each context lives under the SHA-256 of its name
+func contextEndpoint(name string) (Endpoint, error) {
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+
+	data, err := os.ReadFile(filepath.Join(configDir(), "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Endpoint{}, fmt.Errorf("docker context %q not found (see 'docker context ls')", name)
+		}
+		return Endpoint{}, fmt.Errorf("failed to read docker context %q: %w", name, err)
+	}
+
+	var meta struct {
+		Endpoints map[string]struct {
+			Host          string `json:"Host"`
+			SkipTLSVerify bool   `json:"SkipTLSVerify"`
+		} `json:"Endpoints"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return Endpoint{}, fmt.Errorf("failed to parse docker context %q: %w", name, err)
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return Endpoint{}, fmt.Errorf("docker context %q has no docker endpoint", name)
+	}
+
+	// TLS material is only stored for contexts that use it
+	tlsDir := filepath.Join(configDir(), "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tlsDir); err != nil {
+		if endpoint.SkipTLSVerify {
+			return Endpoint{Host: endpoint.Host, TLS: &tls.Config{InsecureSkipVerify: true}}, nil
+		}
+		return Endpoint{Host: endpoint.Host}, nil
+	}
+	config, err := loadTLS(tlsDir, !endpoint.SkipTLSVerify)
+	if err != nil {
+		return Endpoint{}, fmt.Errorf("docker context %q: %w", name, err)
+	}
+	return Endpoint{Host: endpoint.Host, TLS: config}, nil
+}
+
+// envTLS returns the TLS settings of DOCKER_TLS, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH,
+// or nil when TLS is off
+func envTLS() (*tls.Config, error) {
+	verify := os.Getenv("DOCKER_TLS_VERIFY") != ""
+	if !verify && os.Getenv("DOCKER_TLS") == "" {
+		return nil, nil
+	}
+	dir := os.Getenv("DOCKER_CERT_PATH")
+	if dir == "" {
+		dir = configDir()
+	}
+	return loadTLS(dir, verify)
+}
+
+// loadTLS builds a TLS configuration from the ca.pem, cert.pem and key.pem files of a
+// directory; the ones missing are left out
+func loadTLS(dir string, verify bool) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: !verify}
+
+	if ca, err := os.ReadFile(filepath.Join(dir, "ca.pem")); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid CA certificate in %s", filepath.Join(dir, "ca.pem"))
+		}
+		config.RootCAs = pool
+	}
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate in %s: %w", dir, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// sshDialer connects to the daemon of an ssh:// host through 'docker system dial-stdio'
+// on that host, as the docker CLI does; each connection runs its own ssh process
+func sshDialer(u *url.URL) func(ctx context.Context, _, _ string) (net.Conn, error) {
+	args := []string{}
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, "--", u.Hostname(), "docker", "system", "dial-stdio")
+
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		// The process outlives the dial, so it is not bound to the dial context
+		cmd := exec.Command("ssh", args...)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stderr = io.Discard
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("failed to run ssh: %w", err)
+		}
+		return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, host: u.Host}, nil
+	}
+}
+
+// commandConn is a connection over the standard input and output of a process
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	host   string
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+// Close ends the process; its exit status is of no interest once the connection is done
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr("ssh") }
+func (c *commandConn) RemoteAddr() net.Addr { return commandAddr(c.host) }
+
+// Deadlines are not supported by pipes; requests are bounded by their context instead
+func (c *commandConn) SetDeadline(time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(time.Time) error { return nil }
+
+// commandAddr is the address of a process connection
+type commandAddr string
+
+func (a commandAddr) Network() string { return "ssh" }
+func (a commandAddr) String() string  { return string(a) }
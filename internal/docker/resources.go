@@ -0,0 +1,280 @@
+package docker
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ContainerSpec is the body of a container create request
+type ContainerSpec struct {
+	Image            string              `json:"Image"`
+	Cmd              []string            `json:"Cmd,omitempty"`
+	Entrypoint       []string            `json:"Entrypoint,omitempty"`
+	Env              []string            `json:"Env,omitempty"`
+	Labels           map[string]string   `json:"Labels,omitempty"`
+	User             string              `json:"User,omitempty"`
+	WorkingDir       string              `json:"WorkingDir,omitempty"`
+	Hostname         string              `json:"Hostname,omitempty"`
+	ExposedPorts     map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Volumes          map[string]struct{} `json:"Volumes,omitempty"` // Anonymous volumes
+	Tty              bool                `json:"Tty,omitempty"`
+	OpenStdin        bool                `json:"OpenStdin,omitempty"`
+	StopTimeout      *int                `json:"StopTimeout,omitempty"` // Seconds
+	Healthcheck      *Healthcheck        `json:"Healthcheck,omitempty"`
+	HostConfig       HostConfig          `json:"HostConfig"`
+	NetworkingConfig NetworkingConfig    `json:"NetworkingConfig"`
+}
+
+// Healthcheck configures a container healthcheck; durations are in nanoseconds
+type Healthcheck struct {
+	Test        []string      `json:"Test,omitempty"`
+	Interval    time.Duration `json:"Interval,omitempty"`
+	Timeout     time.Duration `json:"Timeout,omitempty"`
+	StartPeriod time.Duration `json:"StartPeriod,omitempty"`
+	Retries     int           `json:"Retries,omitempty"`
+}
+
+// HostConfig holds the host side of a container: mounts, ports, restart policy
+type HostConfig struct {
+	Binds         []string                 `json:"Binds,omitempty"`
+	PortBindings  map[string][]PortBinding `json:"PortBindings,omitempty"`
+	RestartPolicy RestartPolicy            `json:"RestartPolicy"`
+	NetworkMode   string                   `json:"NetworkMode,omitempty"`
+	ExtraHosts    []string                 `json:"ExtraHosts,omitempty"`
+	CapAdd        []string                 `json:"CapAdd,omitempty"`
+	CapDrop       []string                 `json:"CapDrop,omitempty"`
+	SecurityOpt   []string                 `json:"SecurityOpt,omitempty"`
+	Privileged    bool                     `json:"Privileged,omitempty"`
+	Devices       []DeviceMapping          `json:"Devices,omitempty"`
+}
+
+// PortBinding publishes a container port on the host
+type PortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// RestartPolicy is the container restart policy (no, always, unless-stopped, on-failure)
+type RestartPolicy struct {
+	Name string `json:"Name"`
+}
+
+// DeviceMapping exposes a host device in the container
+type DeviceMapping struct {
+	PathOnHost        string `json:"PathOnHost"`
+	PathInContainer   string `json:"PathInContainer"`
+	CgroupPermissions string `json:"CgroupPermissions"`
+}
+
+// NetworkingConfig attaches a container to networks at creation
+type NetworkingConfig struct {
+	EndpointsConfig map[string]EndpointSettings `json:"EndpointsConfig,omitempty"`
+}
+
+// EndpointSettings configures a container's attachment to one network
+type EndpointSettings struct {
+	Aliases []string `json:"Aliases,omitempty"`
+}
+
+// CreateContainer creates a container and returns its ID
+func (c *Client) CreateContainer(name string, spec *ContainerSpec) (string, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.sendJSON(http.MethodPost, "/containers/create?name="+url.QueryEscape(name), spec, &created); err != nil {
+		return "", fmt.Errorf("failed to create container %s: %w", name, err)
+	}
+	return created.ID, nil
+}
+
+// StartContainer starts a container; starting a running container is not an error
+func (c *Client) StartContainer(id string) error {
+	return c.sendJSON(http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil, nil)
+}
+
+// StopContainer stops a container, killing it after its stop timeout
+func (c *Client) StopContainer(id string) error {
+	return c.sendJSON(http.MethodPost, "/containers/"+url.PathEscape(id)+"/stop", nil, nil)
+}
+
+// RemoveContainer removes a container, stopping it first when force is set
+// With volumes, its anonymous volumes are removed too
+func (c *Client) RemoveContainer(id string, force, volumes bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "1")
+	}
+	if volumes {
+		query.Set("v", "1")
+	}
+	return c.sendJSON(http.MethodDelete, "/containers/"+url.PathEscape(id)+"?"+query.Encode(), nil, nil)
+}
+
+// Network is an entry of the network list
+type Network struct {
+	ID     string            `json:"Id"`
+	Name   string            `json:"Name"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// ListNetworks returns networks matching the filters
+func (c *Client) ListNetworks(filters map[string][]string) ([]Network, error) {
+	var networks []Network
+	if err := c.getJSON("/networks?"+filterQuery(filters), &networks); err != nil {
+		return nil, err
+	}
+	return networks, nil
+}
+
+// CreateNetwork creates a bridge network
+func (c *Client) CreateNetwork(name string, labels map[string]string) error {
+	body := map[string]interface{}{"Name": name, "Labels": labels, "CheckDuplicate": true}
+	if err := c.sendJSON(http.MethodPost, "/networks/create", body, nil); err != nil {
+		return fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+	return nil
+}
+
+// RemoveNetwork removes a network
+func (c *Client) RemoveNetwork(name string) error {
+	return c.sendJSON(http.MethodDelete, "/networks/"+url.PathEscape(name), nil, nil)
+}
+
+// ConnectNetwork attaches a container to a network under the given aliases
+func (c *Client) ConnectNetwork(network, id string, aliases []string) error {
+	body := map[string]interface{}{"Container": id, "EndpointConfig": EndpointSettings{Aliases: aliases}}
+	if err := c.sendJSON(http.MethodPost, "/networks/"+url.PathEscape(network)+"/connect", body, nil); err != nil {
+		return fmt.Errorf("failed to connect %s to network %s: %w", id, network, err)
+	}
+	return nil
+}
+
+// Volume is an entry of the volume list
+type Volume struct {
+	Name   string            `json:"Name"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// ListVolumes returns volumes matching the filters
+func (c *Client) ListVolumes(filters map[string][]string) ([]Volume, error) {
+	var list struct {
+		Volumes []Volume `json:"Volumes"`
+	}
+	if err := c.getJSON("/volumes?"+filterQuery(filters), &list); err != nil {
+		return nil, err
+	}
+	return list.Volumes, nil
+}
+
+// CreateVolume creates a local volume; creating an existing volume is not an error
+func (c *Client) CreateVolume(name string, labels map[string]string) error {
+	body := map[string]interface{}{"Name": name, "Labels": labels}
+	if err := c.sendJSON(http.MethodPost, "/volumes/create", body, nil); err != nil {
+		return fmt.Errorf("failed to create volume %s: %w", name, err)
+	}
+	return nil
+}
+
+// RemoveVolume removes a volume
+func (c *Client) RemoveVolume(name string) error {
+	return c.sendJSON(http.MethodDelete, "/volumes/"+url.PathEscape(name), nil, nil)
+}
+
+// ImageID returns the ID of a local image, or an error satisfying IsNotFound
+func (c *Client) ImageID(ref string) (string, error) {
+	var image struct {
+		ID string `json:"Id"`
+	}
+	if err := c.getJSON("/images/"+ref+"/json", &image); err != nil {
+		return "", err
+	}
+	return image.ID, nil
+}
+
+// AuthConfig is a registry account sent with a pull
+type AuthConfig struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	ServerAddress string `json:"serveraddress"`
+}
+
+// PullImage pulls an image, waiting for the pull to complete
+// auth is the account for the image's registry, nil for anonymous pulls
+func (c *Client) PullImage(ref string, auth *AuthConfig) error {
+	name, tag := ref, "latest"
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	if i := strings.Index(ref, "@"); i >= 0 {
+		name, tag = ref[:i], ref[i+1:]
+	}
+
+	query := url.Values{"fromImage": {name}, "tag": {tag}}
+	req, err := c.request(context.Background(), http.MethodPost, "/images/create?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if auth != nil {
+		data, err := json.Marshal(auth)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Registry-Auth", base64.URLEncoding.EncodeToString(data))
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+
+	// Progress messages; a failure mid-pull is reported as an error message
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to pull %s: %w", ref, err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", ref, message.Error)
+		}
+	}
+}
+
+// sendJSON performs a request with a JSON body and decodes the response into out, if set
+func (c *Client) sendJSON(method, path string, body, out interface{}) error {
+	resp, err := c.do(context.Background(), method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode docker API response: %w", err)
+	}
+	return nil
+}
+
+// filterQuery encodes list filters as a query string
+func filterQuery(filters map[string][]string) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(filters)
+	return url.Values{"filters": {string(data)}}.Encode()
+}
@@ -0,0 +1,388 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/docker"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// API deploys the project and reads container state and events through the Docker
+// Engine API; exec, logs and other compose commands go through the docker compose CLI
+type API struct {
+	*CLI
+	client *docker.Client
+}
+
+// newAPI connects to the daemon of DOCKER_HOST or the docker context (or the default socket)
+func newAPI(project Project) (*API, error) {
+	client, err := docker.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	project.Binary = "docker"
+	return &API{CLI: &CLI{kind: KindDockerAPI, project: project}, client: client}, nil
+}
+
+// Ps lists the project's containers from the API
+func (a *API) Ps() ([]Container, error) {
+	listed, err := a.client.ListContainers(true, a.filters())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	containers := make([]Container, 0, len(listed))
+	for _, c := range listed {
+		details, err := a.client.InspectContainer(c.ID)
+		if err != nil {
+			// Removed since the list; the next call sees the new state
+			if docker.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to inspect container %s: %w", c.ID, err)
+		}
+		containers = append(containers, containerFrom(details))
+	}
+	return containers, nil
+}
+
+// Events streams the project's container events from the API
+func (a *API) Events(ctx context.Context, since string) (EventStream, error) {
+	filters := a.filters()
+	filters["type"] = []string{"container"}
+	stream, err := a.client.Events(ctx, since, filters)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// filters selects the project's containers
+func (a *API) filters() map[string][]string {
+	return map[string][]string{"label": {ProjectLabel + "=" + a.project.Name}}
+}
+
+// Up creates the networks, volumes and containers of services (all when empty) from
+// the compose model, then starts them in dependency order
+// Containers whose spec and image are unchanged are left running
+func (a *API) Up(services []string, opts UpOptions) error {
+	m, err := loadModel(a.project)
+	if err != nil {
+		return err
+	}
+
+	services, err = a.upOrder(m, services, opts.NoDeps)
+	if err != nil {
+		return err
+	}
+
+	specs := make(map[string]*docker.ContainerSpec, len(services))
+	for _, service := range services {
+		if specs[service], err = m.containerSpec(service); err != nil {
+			return err
+		}
+	}
+
+	if err := a.ensureNetworks(m, services); err != nil {
+		return err
+	}
+	if err := a.ensureVolumes(m); err != nil {
+		return err
+	}
+
+	existing, err := a.Ps()
+	if err != nil {
+		return err
+	}
+	byService := make(map[string]Container)
+	for _, c := range existing {
+		byService[c.Service] = c
+	}
+
+	for _, service := range services {
+		action, err := a.upService(m, service, specs[service], byService)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to deploy %s", service),
+				"Compare with the compose CLI: --engine compose").WithClass(errors.ClassDocker)
+		}
+		fmt.Printf("  %s %s\n", m.containerName(service), action)
+	}
+
+	if !opts.Wait {
+		return nil
+	}
+	for _, service := range services {
+		if err := a.waitHealthy(m.containerName(service)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upOrder returns the services to start in dependency order, with their dependencies
+// unless noDeps is set
+func (a *API) upOrder(m *model, services []string, noDeps bool) ([]string, error) {
+	deps := compose.ServiceDependencies(m.file)
+
+	selected := make(map[string]bool)
+	var visit func(string) error
+	visit = func(service string) error {
+		if selected[service] {
+			return nil
+		}
+		if _, ok := m.file.Services[service]; !ok {
+			return errors.New(fmt.Sprintf("no such service: %s", service)).WithClass(errors.ClassNotFound)
+		}
+		selected[service] = true
+		if noDeps {
+			return nil
+		}
+		for _, dep := range deps[service] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(services) == 0 {
+		for service := range m.file.Services {
+			services = append(services, service)
+		}
+	}
+	for _, service := range services {
+		if err := visit(service); err != nil {
+			return nil, err
+		}
+	}
+
+	ordered := make([]string, 0, len(selected))
+	for service := range selected {
+		ordered = append(ordered, service)
+	}
+	sort.Strings(ordered)
+	return compose.OrderServices(ordered, deps)
+}
+
+// ensureNetworks creates the project networks the services join
+// External networks must already exist
+func (a *API) ensureNetworks(m *model, services []string) error {
+	keys := make(map[string]bool)
+	for _, service := range services {
+		for key := range m.serviceNetworks(service) {
+			keys[key] = true
+		}
+	}
+
+	for key := range keys {
+		name := m.networkName(key)
+		found, err := a.client.ListNetworks(map[string][]string{"name": {name}})
+		if err != nil {
+			return fmt.Errorf("failed to list networks: %w", err)
+		}
+		exists := false
+		for _, network := range found {
+			exists = exists || network.Name == name // The name filter also matches substrings
+		}
+
+		switch {
+		case exists:
+			continue
+		case external(m.file.Networks[key]):
+			return errors.New(
+				fmt.Sprintf("external network %s does not exist", name),
+				"Run: docker network create "+name,
+			).WithClass(errors.ClassNotFound)
+		}
+
+		labels := map[string]string{ProjectLabel: m.project, networkLabel: key}
+		if err := a.client.CreateNetwork(name, labels); err != nil {
+			return err
+		}
+		fmt.Printf("  network %s created\n", name)
+	}
+	return nil
+}
+
+// ensureVolumes creates the project's named volumes; creating an existing volume is a no-op
+func (a *API) ensureVolumes(m *model) error {
+	for key, def := range m.file.Volumes {
+		if external(def) {
+			continue
+		}
+		labels := map[string]string{ProjectLabel: m.project, volumeLabel: key}
+		if err := a.client.CreateVolume(compose.VolumeName(m.file, m.project, key), labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upService brings one service's container to its spec and reports what it did:
+// unchanged, started, created or recreated
+func (a *API) upService(m *model, service string, spec *docker.ContainerSpec, existing map[string]Container) (string, error) {
+	imageID, err := a.client.ImageID(spec.Image)
+	if docker.IsNotFound(err) {
+		fmt.Printf("  pulling %s\n", spec.Image)
+		if err := a.client.PullImage(spec.Image, a.registryAuth(spec.Image)); err != nil {
+			return "", err
+		}
+		imageID, err = a.client.ImageID(spec.Image)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", spec.Image, err)
+	}
+
+	action := "created"
+	if current, ok := existing[service]; ok {
+		if current.Labels[SpecHashLabel] == spec.Labels[SpecHashLabel] && current.Image == imageID {
+			if current.Status == "running" {
+				return "unchanged", nil
+			}
+			return "started", a.client.StartContainer(current.ID)
+		}
+
+		if err := a.client.StopContainer(current.ID); err != nil && !docker.IsNotFound(err) {
+			return "", err
+		}
+		if err := a.client.RemoveContainer(current.ID, true, false); err != nil && !docker.IsNotFound(err) {
+			return "", err
+		}
+		action = "recreated"
+	}
+
+	// Older daemons take a single network at creation; the others are connected after
+	endpoints := spec.NetworkingConfig.EndpointsConfig
+	create := *spec
+	if primary, ok := endpoints[spec.HostConfig.NetworkMode]; ok {
+		create.NetworkingConfig.EndpointsConfig = map[string]docker.EndpointSettings{spec.HostConfig.NetworkMode: primary}
+	}
+
+	id, err := a.client.CreateContainer(m.containerName(service), &create)
+	if err != nil {
+		return "", err
+	}
+	for name, endpoint := range endpoints {
+		if name == spec.HostConfig.NetworkMode {
+			continue
+		}
+		if err := a.client.ConnectNetwork(name, id, endpoint.Aliases); err != nil {
+			return "", err
+		}
+	}
+	if err := a.client.StartContainer(id); err != nil {
+		return "", fmt.Errorf("failed to start %s: %w", m.containerName(service), err)
+	}
+	return action, nil
+}
+
+// registryAuth returns the account for an image's registry, nil for anonymous pulls
+func (a *API) registryAuth(image string) *docker.AuthConfig {
+	registry, _ := compose.ImageRegistry(image)
+	if auth, ok := a.project.Registries[registry]; ok {
+		return &auth
+	}
+	return nil
+}
+
+// waitHealthy polls a container until it runs and passes its healthcheck, if any
+// A container that exited with code 0 completed a one-shot job
+func (a *API) waitHealthy(name string) error {
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		details, err := a.client.InspectContainer(name)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", name, err)
+		}
+
+		state := details.State
+		health := ""
+		if state.Health != nil {
+			health = state.Health.Status
+		}
+		switch {
+		case state.Status == "exited" && state.ExitCode == 0:
+			return nil
+		case state.Status == "exited" || state.Status == "dead":
+			return errors.New(
+				fmt.Sprintf("%s exited with code %d", name, state.ExitCode),
+				"Check its logs: docker logs "+name,
+			).WithClass(errors.ClassDocker)
+		case health == "unhealthy":
+			return errors.New(
+				fmt.Sprintf("%s is unhealthy", name),
+				"Check its logs: docker logs "+name,
+			).WithClass(errors.ClassDocker)
+		case state.Status == "running" && (health == "" || health == "healthy"):
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New(
+				fmt.Sprintf("%s is not healthy after %s", name, waitTimeout),
+				"Check its logs: docker logs "+name,
+			).WithClass(errors.ClassDocker)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// Down stops and removes the given services' containers, or the whole project with its
+// networks and, with Volumes, its volumes
+func (a *API) Down(services []string, opts DownOptions) error {
+	containers, err := a.Ps()
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[string]bool)
+	for _, service := range services {
+		selected[service] = true
+	}
+
+	for _, c := range containers {
+		if len(services) > 0 && !selected[c.Service] {
+			continue
+		}
+		if err := a.client.StopContainer(c.ID); err != nil && !docker.IsNotFound(err) {
+			return fmt.Errorf("failed to stop %s: %w", c.Service, err)
+		}
+		if err := a.client.RemoveContainer(c.ID, true, opts.Volumes); err != nil && !docker.IsNotFound(err) {
+			return fmt.Errorf("failed to remove %s: %w", c.Service, err)
+		}
+		fmt.Printf("  %s removed\n", c.Service)
+	}
+	if len(services) > 0 {
+		return nil
+	}
+
+	networks, err := a.client.ListNetworks(a.filters())
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, network := range networks {
+		if err := a.client.RemoveNetwork(network.Name); err != nil && !docker.IsNotFound(err) {
+			return fmt.Errorf("failed to remove network %s: %w", network.Name, err)
+		}
+		fmt.Printf("  network %s removed\n", network.Name)
+	}
+
+	if !opts.Volumes {
+		return nil
+	}
+	volumes, err := a.client.ListVolumes(a.filters())
+	if err != nil {
+		return fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for _, volume := range volumes {
+		if err := a.client.RemoveVolume(volume.Name); err != nil && !docker.IsNotFound(err) {
+			return fmt.Errorf("failed to remove volume %s: %w", volume.Name, err)
+		}
+		fmt.Printf("  volume %s removed\n", volume.Name)
+	}
+	return nil
+}
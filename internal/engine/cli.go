@@ -0,0 +1,286 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/docker"
+)
+
+// ProjectLabel is the label compose puts on every container of a project
+const ProjectLabel = "com.docker.compose.project"
+
+// ServiceLabel is the label naming a container's compose service
+const ServiceLabel = "com.docker.compose.service"
+
+// CLI drives docker compose or podman compose
+// podman compose must use the docker-compose provider, which labels containers like docker compose
+type CLI struct {
+	kind    string
+	project Project
+}
+
+// Name returns the engine kind
+func (c *CLI) Name() string {
+	return c.kind
+}
+
+// Up runs compose up -d
+func (c *CLI) Up(services []string, opts UpOptions) error {
+	args := []string{"up", "-d"}
+	if opts.NoDeps {
+		args = append(args, "--no-deps")
+	}
+	if opts.Wait {
+		args = append(args, "--wait")
+	}
+	return c.run(false, append(args, services...)...)
+}
+
+// Down runs compose down, or compose rm --stop for a subset of services,
+// since down is always project-wide
+func (c *CLI) Down(services []string, opts DownOptions) error {
+	if len(services) == 0 {
+		args := []string{"down"}
+		if opts.Volumes {
+			args = append(args, "--volumes")
+		}
+		return c.run(false, args...)
+	}
+
+	args := []string{"rm", "--stop", "--force"}
+	if opts.Volumes {
+		args = append(args, "--volumes")
+	}
+	return c.run(false, append(args, services...)...)
+}
+
+// Ps lists the project's containers with docker ps and docker inspect
+func (c *CLI) Ps() ([]Container, error) {
+	ids, err := c.output("ps", "-aq", "--filter", "label="+ProjectLabel+"="+c.project.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	if ids == "" {
+		return nil, nil
+	}
+
+	data, err := c.output(append([]string{"inspect"}, strings.Fields(ids)...)...)
+	if err != nil {
+		// A container removed between ps and inspect; the next call sees the new state
+		if strings.Contains(err.Error(), "No such") || strings.Contains(err.Error(), "no such") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to inspect containers: %w", err)
+	}
+
+	return parseInspect([]byte(data))
+}
+
+// Logs runs compose logs
+func (c *CLI) Logs(services []string, opts LogOptions) error {
+	args := []string{"logs"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Tail != "" {
+		args = append(args, "--tail", opts.Tail)
+	}
+	if opts.Timestamps {
+		args = append(args, "--timestamps")
+	}
+	return c.run(false, append(args, services...)...)
+}
+
+// Exec runs compose exec
+func (c *CLI) Exec(service string, command []string, opts ExecOptions) error {
+	args := []string{"exec"}
+	if opts.NoTTY {
+		args = append(args, "-T")
+	}
+	args = append(args, service)
+	return c.run(!opts.NoTTY, append(args, command...)...)
+}
+
+// Events streams docker events (or podman events) as JSON lines
+func (c *CLI) Events(ctx context.Context, since string) (EventStream, error) {
+	args := []string{"events", "--format", "{{json .}}",
+		"--filter", "type=container",
+		"--filter", "label=" + ProjectLabel + "=" + c.project.Name}
+	if since != "" {
+		args = append(args, "--since", since)
+	}
+
+	cmd, err := c.project.Commands(false, c.project.Binary, args...)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s events: %w", c.project.Binary, err)
+	}
+
+	stream := &cliEventStream{cmd: cmd, decoder: json.NewDecoder(stdout), podman: c.kind == KindPodman}
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+	return stream, nil
+}
+
+// Compose runs a compose subcommand attached to the terminal
+func (c *CLI) Compose(args ...string) error {
+	return c.run(true, args...)
+}
+
+// composeArgs returns the arguments selecting the project's name and compose files
+func (c *CLI) composeArgs(args ...string) []string {
+	base := []string{"compose"}
+	if c.project.Name != "" {
+		base = append(base, "-p", c.project.Name)
+	}
+	for _, file := range c.project.Files {
+		base = append(base, "-f", file)
+	}
+	if c.project.EnvFile != "" {
+		base = append(base, "--env-file", c.project.EnvFile)
+	}
+	return append(base, args...)
+}
+
+// run runs a compose subcommand with the terminal's streams
+func (c *CLI) run(interactive bool, args ...string) error {
+	cmd, err := c.project.Commands(interactive, c.project.Binary, c.composeArgs(args...)...)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if interactive {
+		cmd.Stdin = os.Stdin // Allow interactive commands
+	}
+	return cmd.Run()
+}
+
+// output runs a plain CLI command (not compose) and returns its trimmed standard output
+func (c *CLI) output(args ...string) (string, error) {
+	cmd, err := c.project.Commands(false, c.project.Binary, args...)
+	if err != nil {
+		return "", err
+	}
+	return Output(cmd)
+}
+
+// Output runs a command and returns its trimmed standard output; on failure the
+// error carries its standard error
+func Output(cmd *exec.Cmd) (string, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s", msg)
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// parseInspect converts docker inspect output to containers
+func parseInspect(data []byte) ([]Container, error) {
+	var inspected []docker.ContainerDetails
+	if err := json.Unmarshal(data, &inspected); err != nil {
+		return nil, fmt.Errorf("failed to parse inspect output: %w", err)
+	}
+
+	containers := make([]Container, 0, len(inspected))
+	for i := range inspected {
+		containers = append(containers, containerFrom(&inspected[i]))
+	}
+	return containers, nil
+}
+
+// containerFrom converts inspect details to a Container
+func containerFrom(details *docker.ContainerDetails) Container {
+	container := Container{
+		ID:       details.ID,
+		Service:  details.Config.Labels[ServiceLabel],
+		Status:   details.State.Status,
+		ExitCode: fmt.Sprintf("%d", details.State.ExitCode),
+		Image:    details.Image,
+		Labels:   details.Config.Labels,
+	}
+	if details.State.Health != nil {
+		container.Health = details.State.Health.Status
+	}
+	return container
+}
+
+// cliEventStream decodes the JSON lines of a running events command
+type cliEventStream struct {
+	cmd     *exec.Cmd
+	decoder *json.Decoder
+	podman  bool
+	once    sync.Once
+}
+
+// podmanEvent is the JSON layout of podman events
+type podmanEvent struct {
+	ID         string            `json:"ID"`
+	Image      string            `json:"Image"`
+	Name       string            `json:"Name"`
+	Status     string            `json:"Status"`
+	Type       string            `json:"Type"`
+	Time       time.Time         `json:"Time"`
+	Attributes map[string]string `json:"Attributes"`
+}
+
+// Next blocks until the next event arrives; io.EOF once the command ended
+func (s *cliEventStream) Next() (docker.Event, error) {
+	var event docker.Event
+	if !s.podman {
+		err := s.decoder.Decode(&event)
+		return event, err
+	}
+
+	var pe podmanEvent
+	if err := s.decoder.Decode(&pe); err != nil {
+		return event, err
+	}
+	event.Type = strings.ToLower(pe.Type)
+	event.Action = pe.Status
+	event.Actor.ID = pe.ID
+	event.Actor.Attributes = map[string]string{"name": pe.Name, "image": pe.Image}
+	for k, v := range pe.Attributes {
+		event.Actor.Attributes[k] = v
+	}
+	event.TimeNano = pe.Time.UnixNano()
+	return event, nil
+}
+
+// Close stops the events command
+func (s *cliEventStream) Close() error {
+	s.once.Do(func() {
+		if s.cmd.Process != nil {
+			s.cmd.Process.Kill()
+		}
+		s.cmd.Wait() // Killed: the exit status carries no information
+	})
+	return nil
+}
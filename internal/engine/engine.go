@@ -0,0 +1,130 @@
+// Package engine runs the generated compose project on a container runtime
+// Commands go through an Engine instead of building docker compose command lines,
+// so the same code drives docker compose, podman and the Docker Engine API
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/docker"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// Engine kinds, selected with --engine (HOMELAB_ENGINE)
+const (
+	KindCompose   = "compose"    // docker compose CLI (default)
+	KindPodman    = "podman"     // podman compose CLI
+	KindDockerAPI = "docker-api" // Docker Engine API, compose CLI for exec and logs
+)
+
+// Kinds lists the available engines
+var Kinds = []string{KindCompose, KindPodman, KindDockerAPI}
+
+// Engine runs operations on the containers of a compose project
+type Engine interface {
+	// Name is the engine kind
+	Name() string
+	// Up creates and starts services (all when empty), detached
+	Up(services []string, opts UpOptions) error
+	// Down removes the project, or only the given services' containers
+	Down(services []string, opts DownOptions) error
+	// Ps lists the project's containers, stopped ones included
+	Ps() ([]Container, error)
+	// Logs prints the logs of services (all when empty)
+	Logs(services []string, opts LogOptions) error
+	// Exec runs a command in a service's running container
+	Exec(service string, command []string, opts ExecOptions) error
+	// Events streams the project's container events until ctx is done
+	Events(ctx context.Context, since string) (EventStream, error)
+	// Compose runs any other compose subcommand, attached to the terminal
+	Compose(args ...string) error
+}
+
+// Project is the compose project an engine works on
+type Project struct {
+	Name     string   // Compose project name, used to find its containers
+	Files    []string // Compose files, later ones override earlier ones
+	EnvFile  string   // Optional --env-file
+	Binary   string   // CLI used by compose engines: docker or podman
+	Commands Runner
+
+	// Registries holds the accounts the docker-api engine pulls with, by registry host
+	// CLI engines use the credentials of docker login instead
+	Registries map[string]docker.AuthConfig
+}
+
+// Runner builds the command for a CLI invocation, e.g. over SSH for a remote host
+// interactive asks for a terminal when stdin is one
+type Runner func(interactive bool, name string, args ...string) (*exec.Cmd, error)
+
+// UpOptions tunes Up
+type UpOptions struct {
+	Wait   bool // Block until containers are running and healthy
+	NoDeps bool // Don't start linked services
+}
+
+// DownOptions tunes Down
+type DownOptions struct {
+	Volumes bool // Also remove volumes
+}
+
+// LogOptions tunes Logs
+type LogOptions struct {
+	Follow     bool
+	Since      string
+	Tail       string
+	Timestamps bool
+}
+
+// ExecOptions tunes Exec
+type ExecOptions struct {
+	NoTTY bool // Don't allocate a terminal (scripts, smoke tests)
+}
+
+// Container is a container of the project
+type Container struct {
+	ID       string
+	Service  string
+	Status   string // created, running, restarting, exited, dead...
+	Health   string // healthy, unhealthy, starting; empty without a healthcheck
+	ExitCode string
+	Image    string // ID of the image the container runs
+	Labels   map[string]string
+}
+
+// EventStream yields container events as the runtime reports them
+type EventStream interface {
+	Next() (docker.Event, error)
+	Close() error
+}
+
+// New creates the engine of the given kind for a project
+func New(kind string, project Project) (Engine, error) {
+	switch kind {
+	case "", KindCompose:
+		project.Binary = "docker"
+		return &CLI{kind: KindCompose, project: project}, nil
+	case KindPodman:
+		project.Binary = "podman"
+		return &CLI{kind: KindPodman, project: project}, nil
+	case KindDockerAPI:
+		return newAPI(project)
+	default:
+		return nil, errors.New(
+			fmt.Sprintf("unknown engine: %s", kind),
+			"Available engines: "+strings.Join(Kinds, ", "),
+		).WithClass(errors.ClassUsage)
+	}
+}
+
+// Binary returns the CLI an engine kind drives, for commands outside compose
+// (image inspect, pull, volume create)
+func Binary(kind string) string {
+	if kind == KindPodman {
+		return "podman"
+	}
+	return "docker"
+}
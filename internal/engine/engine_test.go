@@ -0,0 +1,338 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingProject returns a project whose commands are recorded instead of run
+func recordingProject(calls *[]string) Project {
+	return Project{
+		Name:    "runtime",
+		Files:   []string{"runtime/docker-compose.yml"},
+		EnvFile: ".env",
+		Commands: func(interactive bool, name string, args ...string) (*exec.Cmd, error) {
+			*calls = append(*calls, name+" "+strings.Join(args, " "))
+			return exec.Command("true"), nil
+		},
+	}
+}
+
+func TestNew(t *testing.T) {
+	for kind, want := range map[string]string{"": KindCompose, KindCompose: KindCompose, KindPodman: KindPodman} {
+		e, err := New(kind, Project{})
+		if err != nil {
+			t.Fatalf("New(%q) error = %v", kind, err)
+		}
+		if e.Name() != want {
+			t.Errorf("New(%q).Name() = %s, want %s", kind, e.Name(), want)
+		}
+	}
+
+	if _, err := New("nerdctl", Project{}); err == nil || !strings.Contains(err.Error(), "unknown engine") {
+		t.Errorf("New(nerdctl) error = %v, want unknown engine", err)
+	}
+}
+
+func TestCLICommands(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("true not available")
+	}
+
+	var calls []string
+	e, err := New(KindPodman, recordingProject(&calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []func() error{
+		func() error { return e.Up(nil, UpOptions{}) },
+		func() error { return e.Up([]string{"grafana"}, UpOptions{NoDeps: true, Wait: true}) },
+		func() error { return e.Down(nil, DownOptions{Volumes: true}) },
+		func() error { return e.Down([]string{"grafana"}, DownOptions{}) },
+		func() error { return e.Exec("grafana", []string{"sh", "-c", "true"}, ExecOptions{NoTTY: true}) },
+		func() error { return e.Logs([]string{"grafana"}, LogOptions{Follow: true, Tail: "10"}) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("command failed: %v", err)
+		}
+	}
+
+	base := "podman compose -p runtime -f runtime/docker-compose.yml --env-file .env "
+	want := []string{
+		base + "up -d",
+		base + "up -d --no-deps --wait grafana",
+		base + "down --volumes",
+		base + "rm --stop --force grafana",
+		base + "exec -T grafana sh -c true",
+		base + "logs --follow --tail 10 grafana",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseInspect(t *testing.T) {
+	data := `[
+  {"Id": "abc", "Image": "sha256:111", "State": {"Status": "running", "ExitCode": 0, "Health": {"Status": "healthy"}},
+   "Config": {"Labels": {"com.docker.compose.service": "grafana", "homelabctl.stack": "monitoring"}}},
+  {"Id": "def", "Image": "sha256:222", "State": {"Status": "exited", "ExitCode": 1},
+   "Config": {"Labels": {"com.docker.compose.service": "migrate"}}}
+]`
+
+	containers, err := parseInspect([]byte(data))
+	if err != nil {
+		t.Fatalf("parseInspect() error = %v", err)
+	}
+	if len(containers) != 2 {
+		t.Fatalf("parseInspect() returned %d containers, want 2", len(containers))
+	}
+
+	grafana := containers[0]
+	if grafana.Service != "grafana" || grafana.Health != "healthy" || grafana.Image != "sha256:111" || grafana.Labels["homelabctl.stack"] != "monitoring" {
+		t.Errorf("unexpected container: %+v", grafana)
+	}
+	if migrate := containers[1]; migrate.Status != "exited" || migrate.ExitCode != "1" || migrate.Health != "" {
+		t.Errorf("unexpected container: %+v", migrate)
+	}
+}
+
+func TestPodmanEvents(t *testing.T) {
+	line := `{"ID":"abc","Image":"grafana/grafana","Name":"runtime-grafana-1","Status":"start","Type":"container",` +
+		`"Time":"2026-10-15T08:00:00Z","Attributes":{"com.docker.compose.service":"grafana"}}`
+
+	stream := &cliEventStream{decoder: json.NewDecoder(strings.NewReader(line)), podman: true}
+	event, err := stream.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+
+	if event.Type != "container" || event.Action != "start" || event.Actor.ID != "abc" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event.Actor.Attributes["name"] != "runtime-grafana-1" || event.Actor.Attributes["com.docker.compose.service"] != "grafana" {
+		t.Errorf("unexpected attributes: %v", event.Actor.Attributes)
+	}
+	if event.TimeNano == 0 {
+		t.Error("TimeNano should be set from Time")
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	env := map[string]string{"TZ": "Europe/Paris", "EMPTY": ""}
+	tests := map[string]string{
+		"${TZ}":              "Europe/Paris",
+		"$TZ/zone":           "Europe/Paris/zone",
+		"${EMPTY:-fallback}": "fallback",
+		"${EMPTY-fallback}":  "",
+		"${UNSET-fallback}":  "fallback",
+		"${UNSET}":           "",
+		"$$HOME":             "$HOME",
+		"${TZ:?required}":    "Europe/Paris",
+		"${EMPTY?required}":  "",
+		"${TZ:+set}":         "set",
+		"${EMPTY:+set}":      "",
+		"${EMPTY+set}":       "set",
+		"${UNSET+set}":       "",
+	}
+	for text, want := range tests {
+		if got, err := Interpolate(text, env); err != nil || got != want {
+			t.Errorf("Interpolate(%q) = %q, %v; want %q", text, got, err, want)
+		}
+	}
+
+	for _, text := range []string{"${UNSET:?set UNSET}", "${UNSET?set UNSET}", "${EMPTY:?}"} {
+		if _, err := Interpolate(text, env); err == nil {
+			t.Errorf("Interpolate(%q) should fail on a required variable", text)
+		}
+	}
+	if _, err := Interpolate("${UNSET:?set it in .env}", env); err == nil || !strings.Contains(err.Error(), "UNSET: set it in .env") {
+		t.Errorf("Interpolate() error = %v, want the variable and its message", err)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	got := Variables("image: app:${TAG:-latest}\nenv: $TZ ${TAG} $$HOME ${DB_PASSWORD:?required}")
+	want := []Variable{
+		{Name: "DB_PASSWORD"},
+		{Name: "TAG", Default: "latest", HasDefault: true},
+		{Name: "TZ"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Variables() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	words, err := splitWords(`sh -c "echo 'hi there'" a\ b`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"sh", "-c", "echo 'hi there'", "a b"}; !reflect.DeepEqual(words, want) {
+		t.Errorf("splitWords() = %q, want %q", words, want)
+	}
+
+	if _, err := splitWords(`echo "open`); err == nil {
+		t.Error("splitWords() should reject an unterminated quote")
+	}
+}
+
+// writeModel writes compose files to a temp dir and loads them as a project
+func writeModel(t *testing.T, files ...string) *model {
+	t.Helper()
+	dir := t.TempDir()
+
+	project := Project{Name: "runtime"}
+	for i, content := range files {
+		path := filepath.Join(dir, "compose"+string(rune('0'+i))+".yml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		project.Files = append(project.Files, path)
+	}
+
+	m, err := loadModel(project)
+	if err != nil {
+		t.Fatalf("loadModel() error = %v", err)
+	}
+	return m
+}
+
+func TestContainerSpec(t *testing.T) {
+	t.Setenv("GRAFANA_PORT", "3001")
+	m := writeModel(t, `
+services:
+  grafana:
+    image: grafana/grafana:10.1
+    command: --config "/etc/grafana/my config.ini"
+    environment:
+      GF_LOG_LEVEL: info
+    ports:
+      - "${GRAFANA_PORT:-3000}:3000"
+      - "127.0.0.1:8125:8125/udp"
+    volumes:
+      - grafana-data:/var/lib/grafana
+      - ./config:/etc/grafana:ro
+      - /cache
+    networks:
+      proxy:
+        aliases: [dashboards]
+    restart: unless-stopped
+    healthcheck:
+      test: curl -f localhost:3000
+      interval: 30s
+      retries: 3
+    labels:
+      homelabctl.stack: monitoring
+volumes:
+  grafana-data: {}
+networks:
+  proxy:
+    external: true
+`, `
+services:
+  grafana:
+    image: grafana/grafana:10.2
+`)
+
+	spec, err := m.containerSpec("grafana")
+	if err != nil {
+		t.Fatalf("containerSpec() error = %v", err)
+	}
+
+	if spec.Image != "grafana/grafana:10.2" {
+		t.Errorf("Image = %s, want the overlay's", spec.Image)
+	}
+	if want := []string{"--config", "/etc/grafana/my config.ini"}; !reflect.DeepEqual(spec.Cmd, want) {
+		t.Errorf("Cmd = %q, want %q", spec.Cmd, want)
+	}
+	if !reflect.DeepEqual(spec.Env, []string{"GF_LOG_LEVEL=info"}) {
+		t.Errorf("Env = %q", spec.Env)
+	}
+	if b := spec.HostConfig.PortBindings["3000/tcp"]; len(b) != 1 || b[0].HostPort != "3001" {
+		t.Errorf("3000/tcp bindings = %+v, want host port 3001", b)
+	}
+	if b := spec.HostConfig.PortBindings["8125/udp"]; len(b) != 1 || b[0].HostIP != "127.0.0.1" {
+		t.Errorf("8125/udp bindings = %+v", b)
+	}
+
+	config, _ := filepath.Abs(filepath.Join(m.dir, "config"))
+	if want := []string{"runtime_grafana-data:/var/lib/grafana", config + ":/etc/grafana:ro"}; !reflect.DeepEqual(spec.HostConfig.Binds, want) {
+		t.Errorf("Binds = %q, want %q", spec.HostConfig.Binds, want)
+	}
+	if _, ok := spec.Volumes["/cache"]; !ok {
+		t.Errorf("Volumes = %v, want anonymous /cache", spec.Volumes)
+	}
+
+	if spec.HostConfig.NetworkMode != "proxy" || !reflect.DeepEqual(spec.NetworkingConfig.EndpointsConfig["proxy"].Aliases, []string{"grafana", "dashboards"}) {
+		t.Errorf("networks = %s %+v", spec.HostConfig.NetworkMode, spec.NetworkingConfig.EndpointsConfig)
+	}
+	if spec.HostConfig.RestartPolicy.Name != "unless-stopped" {
+		t.Errorf("RestartPolicy = %+v", spec.HostConfig.RestartPolicy)
+	}
+	if hc := spec.Healthcheck; hc == nil || hc.Test[0] != "CMD-SHELL" || hc.Interval != 30*time.Second || hc.Retries != 3 {
+		t.Errorf("Healthcheck = %+v", hc)
+	}
+
+	labels := spec.Labels
+	if labels[ProjectLabel] != "runtime" || labels[ServiceLabel] != "grafana" || labels["homelabctl.stack"] != "monitoring" || labels[SpecHashLabel] == "" {
+		t.Errorf("Labels = %v", labels)
+	}
+	if m.containerName("grafana") != "runtime-grafana-1" {
+		t.Errorf("containerName() = %s", m.containerName("grafana"))
+	}
+}
+
+func TestContainerSpecHash(t *testing.T) {
+	base := "services:\n  app:\n    image: nginx\n"
+	m := writeModel(t, base)
+	first, _ := m.containerSpec("app")
+	again, _ := writeModel(t, base).containerSpec("app")
+	changed, _ := writeModel(t, base+"    environment: [DEBUG=1]\n").containerSpec("app")
+
+	if first.Labels[SpecHashLabel] != again.Labels[SpecHashLabel] {
+		t.Error("spec hash should be stable for the same service")
+	}
+	if first.Labels[SpecHashLabel] == changed.Labels[SpecHashLabel] {
+		t.Error("spec hash should change with the service")
+	}
+	if _, ok := first.NetworkingConfig.EndpointsConfig["runtime_default"]; !ok {
+		t.Errorf("service without networks should join runtime_default: %+v", first.NetworkingConfig)
+	}
+}
+
+func TestContainerSpecUnsupported(t *testing.T) {
+	m := writeModel(t, `
+services:
+  app:
+    build: .
+    deploy:
+      resources: {}
+`)
+	_, err := m.containerSpec("app")
+	if err == nil || !strings.Contains(err.Error(), "build, deploy") {
+		t.Errorf("containerSpec() error = %v, want unsupported keys", err)
+	}
+}
+
+func TestContainerSpecReplicas(t *testing.T) {
+	base := "services:\n  app:\n    image: nginx\n"
+	for _, extra := range []string{"    scale: 1\n", "    deploy:\n      replicas: 1\n"} {
+		if _, err := writeModel(t, base+extra).containerSpec("app"); err != nil {
+			t.Errorf("containerSpec() with %q error = %v, want one container", extra, err)
+		}
+	}
+	for _, extra := range []string{"    scale: 3\n", "    deploy:\n      replicas: 2\n", "    deploy:\n      replicas: 0\n"} {
+		_, err := writeModel(t, base+extra).containerSpec("app")
+		if err == nil || !strings.Contains(err.Error(), "replicas") {
+			t.Errorf("containerSpec() with %q error = %v, want replicas refused", extra, err)
+		}
+	}
+}
@@ -0,0 +1,812 @@
+package engine
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/docker"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// SpecHashLabel records the digest of the container spec a container was created from,
+// so the native deploy only recreates containers whose service changed
+const SpecHashLabel = "homelabctl.spec-hash"
+
+// Compose labels the native deploy sets, so compose, ps and events see its containers
+const (
+	containerNumberLabel = "com.docker.compose.container-number"
+	oneoffLabel          = "com.docker.compose.oneoff"
+	networkLabel         = "com.docker.compose.network"
+	volumeLabel          = "com.docker.compose.volume"
+)
+
+// waitTimeout bounds how long Up with Wait waits for a container to become healthy
+const waitTimeout = 5 * time.Minute
+
+// nativeKeys lists the service keys the native deploy translates
+// Any other key fails the deploy rather than being silently dropped
+var nativeKeys = map[string]bool{
+	"image": true, "container_name": true, "command": true, "entrypoint": true,
+	"environment": true, "env_file": true, "ports": true, "expose": true, "volumes": true,
+	"networks": true, "network_mode": true, "labels": true, "restart": true, "user": true,
+	"working_dir": true, "hostname": true, "healthcheck": true, "depends_on": true,
+	"extra_hosts": true, "cap_add": true, "cap_drop": true, "privileged": true,
+	"devices": true, "security_opt": true, "tty": true, "stdin_open": true,
+	"stop_grace_period": true,
+}
+
+// model is a compose project loaded for the native deploy
+type model struct {
+	file    *compose.ComposeFile
+	dir     string            // Project directory: relative paths resolve against it
+	env     map[string]string // Interpolation variables
+	project string
+}
+
+// loadModel reads the project's compose files with variables interpolated
+// Services of later files override the keys they set in earlier ones
+func loadModel(project Project) (*model, error) {
+	if len(project.Files) == 0 {
+		return nil, fmt.Errorf("no compose file")
+	}
+
+	env, err := ProjectEnv(project.EnvFile)
+	if err != nil {
+		return nil, err
+	}
+	return loadModelEnv(project, env)
+}
+
+// loadModelEnv reads the project's compose files with variables interpolated from env
+func loadModelEnv(project Project, env map[string]string) (*model, error) {
+	m := &model{
+		file:    &compose.ComposeFile{Services: map[string]interface{}{}, Volumes: map[string]interface{}{}, Networks: map[string]interface{}{}},
+		dir:     filepath.Dir(project.Files[0]),
+		env:     env,
+		project: project.Name,
+	}
+
+	for _, path := range project.Files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		text, err := Interpolate(string(data), env)
+		if err != nil {
+			return nil, fmt.Errorf("failed to interpolate %s: %w", path, err)
+		}
+		var file compose.ComposeFile
+		if err := yaml.Unmarshal([]byte(text), &file); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+
+		for name, svc := range file.Services {
+			override, ok := svc.(map[string]interface{})
+			base, exists := m.file.Services[name].(map[string]interface{})
+			if !ok || !exists {
+				m.file.Services[name] = svc
+				continue
+			}
+			for key, value := range override {
+				base[key] = value
+			}
+		}
+		for name, vol := range file.Volumes {
+			m.file.Volumes[name] = vol
+		}
+		for name, network := range file.Networks {
+			m.file.Networks[name] = network
+		}
+	}
+
+	return m, nil
+}
+
+// ProjectEnv returns the interpolation variables: the env file, overridden by the environment
+func ProjectEnv(envFile string) (map[string]string, error) {
+	env := make(map[string]string)
+	if envFile != "" {
+		values, err := readEnvFile(envFile)
+		if err != nil {
+			return nil, err
+		}
+		env = values
+	}
+
+	for _, entry := range os.Environ() {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
+
+// readEnvFile parses KEY=VALUE lines, skipping blank lines and comments
+func readEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values, scanner.Err()
+}
+
+// variablePattern matches $$, $VAR and ${VAR} with the modifiers of docker compose:
+// :- and - (default), :? and ? (required), :+ and + (alternate value)
+var variablePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?+])([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Interpolate substitutes variables like docker compose; $$ is a literal $. It fails on
+// the first variable required with ${VAR:?message} (unset or empty) or ${VAR?message}
+// (unset), with its message
+func Interpolate(text string, env map[string]string) (string, error) {
+	var missing error
+	result := variablePattern.ReplaceAllStringFunc(text, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := variablePattern.FindStringSubmatch(match)
+		if groups[4] != "" {
+			return env[groups[4]]
+		}
+
+		name, op, word := groups[1], groups[2], groups[3]
+		value, set := env[name]
+		switch op {
+		case ":-":
+			if value == "" {
+				return word
+			}
+		case "-":
+			if !set {
+				return word
+			}
+		case ":+":
+			if value != "" {
+				return word
+			}
+			return ""
+		case "+":
+			if set {
+				return word
+			}
+			return ""
+		case ":?", "?":
+			if !set || (op == ":?" && value == "") {
+				if missing == nil {
+					if word == "" {
+						word = "required variable is missing a value"
+					}
+					missing = fmt.Errorf("variable %s: %s", name, word)
+				}
+			}
+		}
+		return value
+	})
+	if missing != nil {
+		return "", missing
+	}
+	return result, nil
+}
+
+// Variable is a variable a compose file references
+type Variable struct {
+	Name       string
+	Default    string
+	HasDefault bool // ${VAR:-default} or ${VAR-default}
+}
+
+// Variables lists the variables referenced in text, sorted by name
+func Variables(text string) []Variable {
+	byName := make(map[string]Variable)
+	for _, groups := range variablePattern.FindAllStringSubmatch(text, -1) {
+		v := Variable{Name: groups[1]}
+		if groups[2] == ":-" || groups[2] == "-" {
+			v.Default, v.HasDefault = groups[3], true
+		}
+		if v.Name == "" {
+			v.Name = groups[4]
+		}
+		if v.Name == "" {
+			continue // $$
+		}
+		if existing, ok := byName[v.Name]; !ok || !existing.HasDefault {
+			byName[v.Name] = v
+		}
+	}
+
+	variables := make([]Variable, 0, len(byName))
+	for _, v := range byName {
+		variables = append(variables, v)
+	}
+	sort.Slice(variables, func(i, j int) bool { return variables[i].Name < variables[j].Name })
+	return variables
+}
+
+// containerName returns the name of a service's container
+func (m *model) containerName(service string) string {
+	if svc, ok := m.file.Services[service].(map[string]interface{}); ok {
+		if name, ok := svc["container_name"].(string); ok && name != "" {
+			return name
+		}
+	}
+	return m.project + "-" + service + "-1"
+}
+
+// networkName returns the docker network name compose uses for a top-level network key
+func (m *model) networkName(key string) string {
+	if def, ok := m.file.Networks[key].(map[string]interface{}); ok {
+		if name, ok := def["name"].(string); ok && name != "" {
+			return name
+		}
+		if external, ok := def["external"].(bool); ok && external {
+			return key
+		}
+	}
+	return m.project + "_" + key
+}
+
+// external reports whether a top-level volume or network definition is external
+func external(def interface{}) bool {
+	defMap, ok := def.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	value, _ := defMap["external"].(bool)
+	return value
+}
+
+// serviceNetworks returns the network keys a service joins with their aliases
+// Services without networks or network_mode join the project's default network
+func (m *model) serviceNetworks(service string) map[string][]string {
+	svc, _ := m.file.Services[service].(map[string]interface{})
+	if _, ok := svc["network_mode"]; ok {
+		return nil
+	}
+
+	networks := make(map[string][]string)
+	switch list := svc["networks"].(type) {
+	case []interface{}:
+		for _, key := range list {
+			networks[fmt.Sprint(key)] = nil
+		}
+	case map[string]interface{}:
+		for key, def := range list {
+			var aliases []string
+			if defMap, ok := def.(map[string]interface{}); ok {
+				aliases = stringList(defMap["aliases"])
+			}
+			networks[key] = aliases
+		}
+	}
+	if len(networks) == 0 {
+		networks["default"] = nil
+	}
+	return networks
+}
+
+// containerSpec translates a service to a container create request
+func (m *model) containerSpec(service string) (*docker.ContainerSpec, error) {
+	svc, ok := m.file.Services[service].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("service %s is not defined", service)
+	}
+
+	// One container per service: scale and deploy.replicas are accepted when they ask for one
+	replicas, deployOther, err := serviceReplicas(svc)
+	if err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	if replicas != 1 {
+		return nil, errors.New(
+			fmt.Sprintf("service %s asks for %d replicas (scale or deploy.replicas); the docker-api engine deploys one container per service", service, replicas),
+			"Deploy with the compose CLI: --engine compose",
+		).WithClass(errors.ClassValidation)
+	}
+
+	var unsupported []string
+	for key := range svc {
+		if key == "scale" || (key == "deploy" && !deployOther) {
+			continue
+		}
+		if !nativeKeys[key] {
+			unsupported = append(unsupported, key)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return nil, errors.New(
+			fmt.Sprintf("service %s uses keys the docker-api engine does not deploy: %s", service, strings.Join(unsupported, ", ")),
+			"Deploy with the compose CLI: --engine compose",
+		).WithClass(errors.ClassValidation)
+	}
+
+	image, _ := svc["image"].(string)
+	if image == "" {
+		return nil, errors.New(
+			fmt.Sprintf("service %s has no image", service),
+			"Services built from source deploy with the compose CLI: --engine compose",
+		).WithClass(errors.ClassValidation)
+	}
+
+	spec := &docker.ContainerSpec{
+		Image:      image,
+		User:       scalar(svc["user"]),
+		WorkingDir: scalar(svc["working_dir"]),
+		Hostname:   scalar(svc["hostname"]),
+		Labels:     compose.ServiceLabels(m.file, service),
+		HostConfig: docker.HostConfig{
+			RestartPolicy: docker.RestartPolicy{Name: scalar(svc["restart"])},
+			ExtraHosts:    hostEntries(svc["extra_hosts"]),
+			CapAdd:        stringList(svc["cap_add"]),
+			CapDrop:       stringList(svc["cap_drop"]),
+			SecurityOpt:   stringList(svc["security_opt"]),
+		},
+	}
+	spec.HostConfig.Privileged, _ = svc["privileged"].(bool)
+	spec.Tty, _ = svc["tty"].(bool)
+	spec.OpenStdin, _ = svc["stdin_open"].(bool)
+
+	if spec.Cmd, err = commandList(svc["command"]); err != nil {
+		return nil, fmt.Errorf("service %s: command: %w", service, err)
+	}
+	if spec.Entrypoint, err = commandList(svc["entrypoint"]); err != nil {
+		return nil, fmt.Errorf("service %s: entrypoint: %w", service, err)
+	}
+	if spec.Env, err = m.environment(svc); err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	if err := m.ports(svc, spec); err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	if err := m.mounts(svc, spec); err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	if err := devices(svc, spec); err != nil {
+		return nil, fmt.Errorf("service %s: %w", service, err)
+	}
+	if spec.Healthcheck, err = healthcheck(svc["healthcheck"]); err != nil {
+		return nil, fmt.Errorf("service %s: healthcheck: %w", service, err)
+	}
+	if grace := scalar(svc["stop_grace_period"]); grace != "" {
+		d, err := time.ParseDuration(grace)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: stop_grace_period: %w", service, err)
+		}
+		seconds := int(d.Seconds())
+		spec.StopTimeout = &seconds
+	}
+
+	if mode := scalar(svc["network_mode"]); mode != "" {
+		if target, ok := strings.CutPrefix(mode, "service:"); ok {
+			mode = "container:" + m.containerName(target)
+		}
+		spec.HostConfig.NetworkMode = mode
+	} else {
+		spec.NetworkingConfig.EndpointsConfig = make(map[string]docker.EndpointSettings)
+		for key, aliases := range m.serviceNetworks(service) {
+			name := m.networkName(key)
+			if spec.HostConfig.NetworkMode == "" || name < spec.HostConfig.NetworkMode {
+				spec.HostConfig.NetworkMode = name
+			}
+			spec.NetworkingConfig.EndpointsConfig[name] = docker.EndpointSettings{Aliases: append([]string{service}, aliases...)}
+		}
+	}
+
+	if spec.Labels == nil {
+		spec.Labels = make(map[string]string)
+	}
+	spec.Labels[ProjectLabel] = m.project
+	spec.Labels[ServiceLabel] = service
+	spec.Labels[containerNumberLabel] = "1"
+	spec.Labels[oneoffLabel] = "False"
+	spec.Labels[SpecHashLabel] = specHash(spec)
+
+	return spec, nil
+}
+
+// serviceReplicas returns the number of containers a service asks for with scale or
+// deploy.replicas (1 when neither is set), and whether its deploy section sets more
+// than replicas
+func serviceReplicas(svc map[string]interface{}) (int, bool, error) {
+	replicas := 1
+	if value, ok := svc["scale"]; ok {
+		n, err := strconv.Atoi(scalar(value))
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid scale: %v", value)
+		}
+		replicas = n
+	}
+
+	deploy, _ := svc["deploy"].(map[string]interface{})
+	other := false
+	for key, value := range deploy {
+		if key != "replicas" {
+			other = true
+			continue
+		}
+		n, err := strconv.Atoi(scalar(value))
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid deploy.replicas: %v", value)
+		}
+		replicas = n
+	}
+	if value, ok := svc["deploy"]; ok && value != nil && deploy == nil {
+		other = true
+	}
+	return replicas, other, nil
+}
+
+// specHash returns a digest of a container spec, ignoring its own label
+func specHash(spec *docker.ContainerSpec) string {
+	copied := *spec
+	copied.Labels = make(map[string]string, len(spec.Labels))
+	for k, v := range spec.Labels {
+		if k != SpecHashLabel {
+			copied.Labels[k] = v
+		}
+	}
+	data, _ := json.Marshal(copied) // Map keys are sorted, so the digest is stable
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// environment merges env_file entries with environment, which takes precedence
+func (m *model) environment(svc map[string]interface{}) ([]string, error) {
+	values := make(map[string]string)
+	for _, path := range stringList(svc["env_file"]) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(m.dir, path)
+		}
+		fileValues, err := readEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fileValues {
+			values[k] = v
+		}
+	}
+
+	switch env := svc["environment"].(type) {
+	case map[string]interface{}:
+		for k, v := range env {
+			if v == nil {
+				values[k] = m.env[k]
+				continue
+			}
+			values[k] = scalar(v)
+		}
+	case []interface{}:
+		for _, entry := range env {
+			key, value, ok := strings.Cut(fmt.Sprint(entry), "=")
+			if !ok {
+				value = m.env[key] // Bare name: passed through from the environment
+			}
+			values[key] = value
+		}
+	}
+
+	entries := make([]string, 0, len(values))
+	for k, v := range values {
+		entries = append(entries, k+"="+v)
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
+// ports translates ports and expose to exposed ports and port bindings
+func (m *model) ports(svc map[string]interface{}, spec *docker.ContainerSpec) error {
+	expose := func(port string) {
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		if spec.ExposedPorts == nil {
+			spec.ExposedPorts = make(map[string]struct{})
+		}
+		spec.ExposedPorts[port] = struct{}{}
+	}
+	bind := func(container string, binding docker.PortBinding) {
+		if !strings.Contains(container, "/") {
+			container += "/tcp"
+		}
+		expose(container)
+		if spec.HostConfig.PortBindings == nil {
+			spec.HostConfig.PortBindings = make(map[string][]docker.PortBinding)
+		}
+		spec.HostConfig.PortBindings[container] = append(spec.HostConfig.PortBindings[container], binding)
+	}
+
+	for _, port := range stringList(svc["expose"]) {
+		expose(port)
+	}
+
+	ports, _ := svc["ports"].([]interface{})
+	for _, entry := range ports {
+		if long, ok := entry.(map[string]interface{}); ok {
+			container := scalar(long["target"])
+			if protocol := scalar(long["protocol"]); protocol != "" {
+				container += "/" + protocol
+			}
+			bind(container, docker.PortBinding{HostIP: scalar(long["host_ip"]), HostPort: scalar(long["published"])})
+			continue
+		}
+
+		short := scalar(entry)
+		protocol := ""
+		if i := strings.LastIndex(short, "/"); i >= 0 {
+			short, protocol = short[:i], short[i:]
+		}
+		if strings.Contains(short, "-") {
+			return fmt.Errorf("port ranges are not supported: %s", scalar(entry))
+		}
+
+		// [[host_ip:]host_port:]container_port; IPv6 host IPs are bracketed
+		var binding docker.PortBinding
+		parts := strings.Split(short, ":")
+		if strings.HasPrefix(short, "[") {
+			end := strings.Index(short, "]")
+			binding.HostIP = short[1:end]
+			parts = strings.Split(strings.TrimPrefix(short[end+1:], ":"), ":")
+		} else if len(parts) == 3 {
+			binding.HostIP, parts = parts[0], parts[1:]
+		}
+		if len(parts) == 2 {
+			binding.HostPort = parts[0]
+		}
+		bind(parts[len(parts)-1]+protocol, binding)
+	}
+	return nil
+}
+
+// mounts translates volumes to binds and anonymous volumes
+// Named volumes get their project name; relative host paths resolve against the project directory
+func (m *model) mounts(svc map[string]interface{}, spec *docker.ContainerSpec) error {
+	volumes, _ := svc["volumes"].([]interface{})
+	for _, entry := range volumes {
+		var source, target, mode string
+		switch vol := entry.(type) {
+		case string:
+			parts := strings.Split(vol, ":")
+			switch len(parts) {
+			case 1:
+				target = parts[0]
+			case 2:
+				source, target = parts[0], parts[1]
+			case 3:
+				source, target, mode = parts[0], parts[1], parts[2]
+			default:
+				return fmt.Errorf("invalid volume: %s", vol)
+			}
+		case map[string]interface{}:
+			kind := scalar(vol["type"])
+			if kind != "" && kind != "bind" && kind != "volume" {
+				return fmt.Errorf("volume type %s is not supported", kind)
+			}
+			source, target = scalar(vol["source"]), scalar(vol["target"])
+			if readOnly, _ := vol["read_only"].(bool); readOnly {
+				mode = "ro"
+			}
+		default:
+			return fmt.Errorf("invalid volume: %v", entry)
+		}
+
+		if source == "" {
+			if spec.Volumes == nil {
+				spec.Volumes = make(map[string]struct{})
+			}
+			spec.Volumes[target] = struct{}{}
+			continue
+		}
+
+		if isHostPath(source) {
+			if strings.HasPrefix(source, "~") {
+				home, err := os.UserHomeDir()
+				if err != nil {
+					return err
+				}
+				source = filepath.Join(home, source[1:])
+			} else if !filepath.IsAbs(source) {
+				abs, err := filepath.Abs(filepath.Join(m.dir, source))
+				if err != nil {
+					return err
+				}
+				source = abs
+			}
+		} else {
+			source = compose.VolumeName(m.file, m.project, source)
+		}
+
+		bind := source + ":" + target
+		if mode != "" {
+			bind += ":" + mode
+		}
+		spec.HostConfig.Binds = append(spec.HostConfig.Binds, bind)
+	}
+	return nil
+}
+
+// isHostPath reports whether a volume source is a host path rather than a named volume
+func isHostPath(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
+
+// devices translates host:container[:permissions] device mappings
+func devices(svc map[string]interface{}, spec *docker.ContainerSpec) error {
+	for _, entry := range stringList(svc["devices"]) {
+		parts := strings.Split(entry, ":")
+		device := docker.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+		switch len(parts) {
+		case 1:
+		case 2:
+			device.PathInContainer = parts[1]
+		case 3:
+			device.PathInContainer, device.CgroupPermissions = parts[1], parts[2]
+		default:
+			return fmt.Errorf("invalid device: %s", entry)
+		}
+		spec.HostConfig.Devices = append(spec.HostConfig.Devices, device)
+	}
+	return nil
+}
+
+// healthcheck translates a service healthcheck; disable: true turns off the image's one
+func healthcheck(value interface{}) (*docker.Healthcheck, error) {
+	def, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	if disable, _ := def["disable"].(bool); disable {
+		return &docker.Healthcheck{Test: []string{"NONE"}}, nil
+	}
+
+	check := &docker.Healthcheck{}
+	switch test := def["test"].(type) {
+	case string:
+		check.Test = []string{"CMD-SHELL", test}
+	case []interface{}:
+		check.Test = stringList(test)
+	}
+
+	for key, field := range map[string]*time.Duration{"interval": &check.Interval, "timeout": &check.Timeout, "start_period": &check.StartPeriod} {
+		if text := scalar(def[key]); text != "" {
+			d, err := time.ParseDuration(text)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			*field = d
+		}
+	}
+	if retries := scalar(def["retries"]); retries != "" {
+		n, err := strconv.Atoi(retries)
+		if err != nil {
+			return nil, fmt.Errorf("retries: %w", err)
+		}
+		check.Retries = n
+	}
+	return check, nil
+}
+
+// hostEntries returns extra_hosts as host:ip entries, from list or map form
+func hostEntries(value interface{}) []string {
+	if hosts, ok := value.(map[string]interface{}); ok {
+		entries := make([]string, 0, len(hosts))
+		for host, ip := range hosts {
+			entries = append(entries, host+":"+scalar(ip))
+		}
+		sort.Strings(entries)
+		return entries
+	}
+	return stringList(value)
+}
+
+// commandList returns a command in exec form; strings are split like a shell would
+func commandList(value interface{}) ([]string, error) {
+	switch command := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return splitWords(command)
+	case []interface{}:
+		return stringList(command), nil
+	default:
+		return nil, fmt.Errorf("invalid command: %v", value)
+	}
+}
+
+// splitWords splits a command line on whitespace, honoring quotes and backslashes
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == '\'':
+			word.WriteRune(r)
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case quote == '"':
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// stringList returns a string or list value as strings
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			list = append(list, scalar(item))
+		}
+		return list
+	}
+	return nil
+}
+
+// scalar formats a YAML scalar as a string; nil is empty
+func scalar(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
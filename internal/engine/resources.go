@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"sort"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/docker"
+)
+
+// Resources are the Docker objects the native deploy creates for a project, for
+// tools that reconcile them another way (e.g. Terraform)
+type Resources struct {
+	Project    string
+	Networks   []NetworkResource   // Sorted by key
+	Volumes    []VolumeResource    // Sorted by key
+	Containers []ContainerResource // Sorted by service
+}
+
+// NetworkResource is a network services join
+type NetworkResource struct {
+	Key      string // Top-level networks key
+	Name     string // Docker network name
+	External bool   // Created outside the project
+	Labels   map[string]string
+}
+
+// VolumeResource is a named volume
+type VolumeResource struct {
+	Key        string // Top-level volumes key
+	Name       string // Docker volume name
+	External   bool   // Created outside the project
+	Driver     string
+	DriverOpts map[string]string
+	Labels     map[string]string
+}
+
+// ContainerResource is a service's container
+type ContainerResource struct {
+	Service   string
+	Name      string // Container name
+	Spec      *docker.ContainerSpec
+	DependsOn []string // Services started first
+}
+
+// LoadResources translates a project's compose files to Docker resources, with
+// variables interpolated from env instead of the project's env file
+func LoadResources(project Project, env map[string]string) (*Resources, error) {
+	m, err := loadModelEnv(project, env)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Resources{Project: m.project}
+	deps := compose.ServiceDependencies(m.file)
+
+	services := make([]string, 0, len(m.file.Services))
+	for service := range m.file.Services {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	networks := make(map[string]bool)
+	for _, service := range services {
+		spec, err := m.containerSpec(service)
+		if err != nil {
+			return nil, err
+		}
+		dependsOn := append([]string(nil), deps[service]...)
+		sort.Strings(dependsOn)
+		res.Containers = append(res.Containers, ContainerResource{
+			Service:   service,
+			Name:      m.containerName(service),
+			Spec:      spec,
+			DependsOn: dependsOn,
+		})
+		for key := range m.serviceNetworks(service) {
+			networks[key] = true
+		}
+	}
+
+	for key := range networks {
+		res.Networks = append(res.Networks, NetworkResource{
+			Key:      key,
+			Name:     m.networkName(key),
+			External: external(m.file.Networks[key]),
+			Labels:   map[string]string{ProjectLabel: m.project, networkLabel: key},
+		})
+	}
+	sort.Slice(res.Networks, func(i, j int) bool { return res.Networks[i].Key < res.Networks[j].Key })
+
+	for key, def := range m.file.Volumes {
+		volume := VolumeResource{
+			Key:      key,
+			Name:     compose.VolumeName(m.file, m.project, key),
+			External: external(def),
+			Labels:   map[string]string{ProjectLabel: m.project, volumeLabel: key},
+		}
+		if defMap, ok := def.(map[string]interface{}); ok {
+			volume.Driver = scalar(defMap["driver"])
+			if opts, ok := defMap["driver_opts"].(map[string]interface{}); ok {
+				volume.DriverOpts = make(map[string]string, len(opts))
+				for k, v := range opts {
+					volume.DriverOpts[k] = scalar(v)
+				}
+			}
+		}
+		res.Volumes = append(res.Volumes, volume)
+	}
+	sort.Slice(res.Volumes, func(i, j int) bool { return res.Volumes[i].Key < res.Volumes[j].Key })
+
+	return res, nil
+}
@@ -1,56 +1,30 @@
 package errors
 
 import (
-	"os"
+	"github.com/monkeymonk/homelabctl/internal/style"
 )
 
-var (
-	colorRed    = "\033[31m"
-	colorYellow = "\033[33m"
-	colorGreen  = "\033[32m"
-	colorReset  = "\033[0m"
-	colorBold   = "\033[1m"
-)
-
-// colorsEnabled checks if color output is supported
+// colorsEnabled checks if color output is supported (NO_COLOR, the user config, a terminal)
 func colorsEnabled() bool {
-	// Disable colors if NO_COLOR is set
-	if os.Getenv("NO_COLOR") != "" {
-		return false
-	}
-
-	// Check if output is a terminal
-	if fileInfo, _ := os.Stdout.Stat(); (fileInfo.Mode() & os.ModeCharDevice) != 0 {
-		return true
-	}
-
-	return false
-}
-
-// colorize applies color if supported
-func colorize(color, text string) string {
-	if colorsEnabled() {
-		return color + text + colorReset
-	}
-	return text
+	return style.ColorEnabled()
 }
 
-// Red returns red colored text
+// Red returns red colored text (the theme's error color)
 func Red(text string) string {
-	return colorize(colorRed, text)
+	return style.Colorize(style.ThemeColor("error", "red"), text)
 }
 
-// Yellow returns yellow colored text
+// Yellow returns yellow colored text (the theme's warning color)
 func Yellow(text string) string {
-	return colorize(colorYellow, text)
+	return style.Colorize(style.ThemeColor("warning", "yellow"), text)
 }
 
-// Green returns green colored text
+// Green returns green colored text (the theme's success color)
 func Green(text string) string {
-	return colorize(colorGreen, text)
+	return style.Colorize(style.ThemeColor("success", "green"), text)
 }
 
 // Bold returns bold text
 func Bold(text string) string {
-	return colorize(colorBold, text)
+	return style.Colorize("bold", text)
 }
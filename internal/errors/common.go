@@ -2,24 +2,21 @@ package errors
 
 import "fmt"
 
-// CommandNotFound creates an error for unknown commands
-func CommandNotFound(command string, availableCommands []string) *Error {
-	suggestions := []string{
-		"Run: homelabctl help",
-	}
-
-	context := []string{
-		"Available commands:",
-	}
-
-	for _, cmd := range availableCommands {
-		context = append(context, fmt.Sprintf("  - %s", cmd))
+// CommandNotFound creates an error for unknown commands, suggesting the similar ones
+func CommandNotFound(command string, similar []string) *Error {
+	var suggestions []string
+	for _, name := range similar {
+		suggestions = append(suggestions, fmt.Sprintf("Did you mean: homelabctl %s", name))
 	}
+	suggestions = append(suggestions,
+		"Run: homelabctl help",
+		fmt.Sprintf("Or run it with docker compose: homelabctl compose -- %s", command),
+	)
 
 	return New(
 		fmt.Sprintf("unknown command: %s", command),
 		suggestions...,
-	).WithContext(context...)
+	).WithClass(ClassUsage)
 }
 
 // MissingArgument creates an error for missing required arguments
@@ -28,7 +25,7 @@ func MissingArgument(argName, command string) *Error {
 		fmt.Sprintf("missing required argument: %s", argName),
 		fmt.Sprintf("Run: homelabctl %s --help", command),
 		fmt.Sprintf("Usage: homelabctl %s <%s>", command, argName),
-	)
+	).WithClass(ClassUsage)
 }
 
 // FileNotFound creates an error for missing files
@@ -38,7 +35,7 @@ func FileNotFound(path, purpose string) *Error {
 		fmt.Sprintf("Purpose: %s", purpose),
 		"Check that the file path is correct",
 		"Run: homelabctl init (if in a new repository)",
-	)
+	).WithClass(ClassNotFound)
 }
 
 // InvalidYAML creates an error for YAML parsing failures
@@ -51,7 +48,7 @@ func InvalidYAML(path string, parseError error) *Error {
 	).WithContext(
 		"Parse error:",
 		parseError.Error(),
-	)
+	).WithClass(ClassValidation)
 }
 
 // DependencyCycle creates an error for circular dependencies
@@ -80,5 +77,5 @@ func DependencyCycle(cycle []string) *Error {
 	return New(
 		"circular dependency detected",
 		suggestions...,
-	).WithContext(context...)
+	).WithContext(context...).WithClass(ClassDependency).WithKind(ErrCycle)
 }
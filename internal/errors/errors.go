@@ -1,8 +1,34 @@
 package errors
 
 import (
+	stderrors "errors"
 	"fmt"
 	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/style"
+)
+
+// Error classes identify the kind of failure for scripts and UIs
+const (
+	ClassGeneric    = "error"
+	ClassUsage      = "usage"
+	ClassNotFound   = "not_found"
+	ClassDependency = "dependency"
+	ClassValidation = "validation"
+	ClassRender     = "render"
+	ClassDocker     = "docker"
+	ClassLocked     = "locked"
+)
+
+// Sentinel errors identify the kind of failure for callers of the internal packages.
+// Match them with Is (or the standard errors.Is) anywhere in an error chain.
+var (
+	ErrStackNotFound     = stderrors.New("stack does not exist")
+	ErrAlreadyEnabled    = stderrors.New("already enabled")
+	ErrDependencyMissing = stderrors.New("dependency missing")
+	ErrCycle             = stderrors.New("circular dependency")
+	ErrRenderFailed      = stderrors.New("render failed")
+	ErrLocked            = stderrors.New("repository locked")
 )
 
 // Error represents an error with actionable suggestions
@@ -10,6 +36,9 @@ type Error struct {
 	Message     string   // The error message
 	Suggestions []string // Actionable suggestions to fix the issue
 	Context     []string // Additional context (optional)
+	Class       string   // Kind of failure (ClassGeneric when empty)
+	Kind        error    // Sentinel matched by errors.Is (optional)
+	Err         error    // Underlying cause, set by Wrap (optional)
 }
 
 // Error implements the error interface
@@ -42,7 +71,19 @@ func (e *Error) Error() string {
 		}
 	}
 
-	return b.String()
+	return style.Glyphs(b.String())
+}
+
+// Unwrap exposes the sentinel kind and the underlying cause to errors.Is/As
+func (e *Error) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
 }
 
 // New creates a new error with suggestions
@@ -59,6 +100,23 @@ func (e *Error) WithContext(context ...string) *Error {
 	return e
 }
 
+// WithClass sets the class of an error
+func (e *Error) WithClass(class string) *Error {
+	e.Class = class
+	return e
+}
+
+// WithKind sets the sentinel error matched by errors.Is
+func (e *Error) WithKind(kind error) *Error {
+	e.Kind = kind
+	return e
+}
+
+// Is reports whether any error in err's chain matches target
+func Is(err, target error) bool {
+	return stderrors.Is(err, target)
+}
+
 // Wrap wraps an existing error with suggestions
 func Wrap(err error, message string, suggestions ...string) *Error {
 	if err == nil {
@@ -68,5 +126,6 @@ func Wrap(err error, message string, suggestions ...string) *Error {
 	return &Error{
 		Message:     fmt.Sprintf("%s: %v", message, err),
 		Suggestions: suggestions,
+		Err:         err,
 	}
 }
@@ -1,6 +1,7 @@
 package errors
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -222,3 +223,104 @@ func TestIs(t *testing.T) {
 		t.Error("errors.Is should return true for same error")
 	}
 }
+
+func TestKind(t *testing.T) {
+	kinded := New("stack 'media' does not exist").WithKind(ErrStackNotFound)
+
+	// The sentinel survives fmt.Errorf wrapping
+	wrapped := fmt.Errorf("stage 1 failed: %w", kinded)
+	if !Is(wrapped, ErrStackNotFound) {
+		t.Error("Is() should match the sentinel through wrapping")
+	}
+	if Is(wrapped, ErrCycle) {
+		t.Error("Is() should not match an unrelated sentinel")
+	}
+
+	// Wrap keeps the underlying chain
+	rewrapped := Wrap(DependencyCycle([]string{"a", "b"}), "validation failed")
+	if !errors.Is(rewrapped, ErrCycle) {
+		t.Error("Wrap() should keep the wrapped error's sentinel")
+	}
+
+	// The enhanced error itself is still reachable
+	if enhanced, ok := As(wrapped); !ok || enhanced != kinded {
+		t.Error("As() should find the enhanced error")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	enhanced := New("stack 'media' does not exist", "Run: homelabctl list").
+		WithContext("Available stacks: core").
+		WithClass(ClassNotFound)
+
+	var decoded struct {
+		Error struct {
+			Message     string   `json:"message"`
+			Class       string   `json:"class"`
+			Suggestions []string `json:"suggestions"`
+			Context     []string `json:"context"`
+			Operation   string   `json:"operation"`
+		} `json:"error"`
+	}
+
+	// Wrapped enhanced errors keep their fields and report the wrapping operation
+	wrapped := fmt.Errorf("stage 1 failed: %w", enhanced)
+	if err := json.Unmarshal([]byte(JSON(wrapped)), &decoded); err != nil {
+		t.Fatalf("JSON() produced invalid JSON: %v", err)
+	}
+
+	if decoded.Error.Message != enhanced.Message {
+		t.Errorf("message = %q, want %q", decoded.Error.Message, enhanced.Message)
+	}
+	if decoded.Error.Class != ClassNotFound {
+		t.Errorf("class = %q, want %q", decoded.Error.Class, ClassNotFound)
+	}
+	if len(decoded.Error.Suggestions) != 1 || len(decoded.Error.Context) != 1 {
+		t.Errorf("suggestions/context not preserved: %+v", decoded.Error)
+	}
+	if decoded.Error.Operation != "stage 1 failed" {
+		t.Errorf("operation = %q, want %q", decoded.Error.Operation, "stage 1 failed")
+	}
+
+	// Plain errors get the generic class
+	if err := json.Unmarshal([]byte(JSON(fmt.Errorf("boom"))), &decoded); err != nil {
+		t.Fatalf("JSON() produced invalid JSON: %v", err)
+	}
+	if decoded.Error.Class != ClassGeneric || decoded.Error.Message != "boom" {
+		t.Errorf("plain error = %+v", decoded.Error)
+	}
+}
+
+func TestJSONReport(t *testing.T) {
+	var decoded struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		Warnings []string `json:"warnings"`
+	}
+
+	// Warnings without an error omit the error object
+	if err := json.Unmarshal([]byte(JSONReport(nil, []string{"deprecated format"})), &decoded); err != nil {
+		t.Fatalf("JSONReport() produced invalid JSON: %v", err)
+	}
+	if decoded.Error != nil || len(decoded.Warnings) != 1 {
+		t.Errorf("JSONReport(nil, warnings) = %+v", decoded)
+	}
+
+	// Without warnings the output matches JSON()
+	err := fmt.Errorf("boom")
+	if JSONReport(err, nil) != JSON(err) {
+		t.Errorf("JSONReport(err, nil) = %s, want %s", JSONReport(err, nil), JSON(err))
+	}
+}
+
+func TestFormatWarnings(t *testing.T) {
+	if FormatWarnings(nil) != "" {
+		t.Error("FormatWarnings(nil) should be empty")
+	}
+
+	output := FormatWarnings([]string{"first", "second"})
+	if !strings.Contains(output, "Warnings (2)") || !strings.Contains(output, "first") || !strings.Contains(output, "second") {
+		t.Errorf("FormatWarnings() = %q", output)
+	}
+}
@@ -0,0 +1,93 @@
+package errors
+
+import (
+	"encoding/json"
+	stderrors "errors"
+)
+
+// Details is the machine-readable form of an error
+type Details struct {
+	Message     string   `json:"message" yaml:"message"`
+	Class       string   `json:"class" yaml:"class"`
+	Suggestions []string `json:"suggestions" yaml:"suggestions"`
+	Context     []string `json:"context" yaml:"context"`
+	Operation   string   `json:"operation,omitempty" yaml:"operation,omitempty"`
+}
+
+// jsonReport is the top-level JSON document printed on stderr
+type jsonReport struct {
+	Error    *Details `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// As returns the enhanced error in err's chain, if any
+func As(err error) (*Error, bool) {
+	var enhanced *Error
+	if stderrors.As(err, &enhanced) {
+		return enhanced, true
+	}
+	return nil, false
+}
+
+// JSON renders err as a single-line JSON object: {"error": {...}}
+// Enhanced errors keep their suggestions, context and class; other errors get ClassGeneric
+func JSON(err error) string {
+	return JSONReport(err, nil)
+}
+
+// JSONReport renders err (may be nil) and collected warnings as a single-line JSON object:
+// {"error": {...}, "warnings": [...]}; empty parts are omitted
+func JSONReport(err error, warnings []string) string {
+	report := jsonReport{Warnings: warnings}
+	if err != nil {
+		out := DetailsOf(err)
+		report.Error = &out
+	}
+
+	data, marshalErr := json.Marshal(report)
+	if marshalErr != nil {
+		return `{"error":{"message":"failed to encode error","class":"error","suggestions":[],"context":[]}}`
+	}
+
+	return string(data)
+}
+
+// DetailsOf converts err into its machine-readable form
+func DetailsOf(err error) Details {
+	out := Details{
+		Message:     err.Error(),
+		Class:       ClassGeneric,
+		Suggestions: []string{},
+		Context:     []string{},
+	}
+
+	if enhanced, ok := As(err); ok {
+		out.Message = enhanced.Message
+		if enhanced.Class != "" {
+			out.Class = enhanced.Class
+		}
+		out.Suggestions = append(out.Suggestions, enhanced.Suggestions...)
+		out.Context = append(out.Context, enhanced.Context...)
+
+		// Keep the outer wrapping (e.g. "stage 5 failed") when there is one
+		if enhanced != err {
+			out.Operation = stripEnhanced(err, enhanced)
+		}
+	}
+
+	return out
+}
+
+// stripEnhanced returns the wrapping message of err without the enhanced error's
+// formatted (multi-line, colored) text
+func stripEnhanced(err error, enhanced *Error) string {
+	full := err.Error()
+	inner := enhanced.Error()
+	if len(full) > len(inner) && full[len(full)-len(inner):] == inner {
+		full = full[:len(full)-len(inner)]
+	}
+	for len(full) > 0 && (full[len(full)-1] == ' ' || full[len(full)-1] == ':') {
+		full = full[:len(full)-1]
+	}
+	return full
+}
@@ -0,0 +1,35 @@
+package errors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/style"
+)
+
+// FormatWarnings renders collected warnings as a single block for stderr
+func FormatWarnings(warnings []string) string {
+	if len(warnings) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(Yellow(fmt.Sprintf("Warnings (%d):", len(warnings))))
+	b.WriteString("\n")
+	for _, warning := range warnings {
+		b.WriteString(Yellow("  ⚠ "))
+		b.WriteString(warning)
+		b.WriteString("\n")
+	}
+
+	return style.Glyphs(b.String())
+}
+
+// StrictWarnings creates the error returned when --strict turns warnings into failures
+func StrictWarnings(warnings []string) *Error {
+	return New(
+		fmt.Sprintf("%d warning(s) treated as errors (--strict)", len(warnings)),
+		"Fix the warnings listed above",
+		"Or run without --strict to continue despite warnings",
+	).WithClass(ClassValidation)
+}
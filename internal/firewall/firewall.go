@@ -0,0 +1,196 @@
+// Package firewall generates host firewall rules (ufw, nftables, firewalld) for the
+// ports the deployment publishes
+package firewall
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Firewall formats
+const (
+	FormatUFW       = "ufw"       // Shell script of ufw commands
+	FormatNftables  = "nftables"  // nft file of port set definitions, included by the ruleset
+	FormatFirewalld = "firewalld" // firewalld service definition
+)
+
+// Formats lists the available formats
+var Formats = []string{FormatUFW, FormatNftables, FormatFirewalld}
+
+// header is the comment on top of the generated files
+const header = "Generated by homelabctl firewall - do not edit"
+
+// Rule allows a port the deployment publishes
+type Rule struct {
+	Port    string // Port or range, e.g. 8000-8005
+	Proto   string // tcp or udp
+	Service string // First service publishing it
+	Stack   string
+}
+
+// Rules returns the ports the services of a compose file publish, once per port and protocol
+// Ports bound to loopback are not reachable from outside and are skipped
+func Rules(generated *compose.ComposeFile) []Rule {
+	services := make([]string, 0, len(generated.Services))
+	for svc := range generated.Services {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+
+	var rules []Rule
+	seen := make(map[string]bool)
+	for _, svc := range services {
+		for _, port := range compose.PublishedPorts(generated, svc) {
+			if port.HostIP == "127.0.0.1" || port.HostIP == "::1" {
+				continue
+			}
+			key := port.Port + "/" + port.Protocol
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			rules = append(rules, Rule{
+				Port:    port.Port,
+				Proto:   port.Protocol,
+				Service: svc,
+				Stack:   compose.ServiceLabels(generated, svc)[compose.LabelStack],
+			})
+		}
+	}
+	return rules
+}
+
+// FileName returns the name of a format's file in runtime/firewall/
+func FileName(format string) string {
+	switch format {
+	case FormatNftables:
+		return "homelab.nft"
+	case FormatFirewalld:
+		return "firewalld.xml"
+	default:
+		return "ufw.sh"
+	}
+}
+
+// Render returns a format's file for the rules of a compose project
+func Render(format, project string, rules []Rule) (string, error) {
+	switch format {
+	case FormatUFW:
+		return renderUFW(project, rules), nil
+	case FormatNftables:
+		return renderNftables(rules), nil
+	case FormatFirewalld:
+		return renderFirewalld(project, rules)
+	default:
+		return "", fmt.Errorf("unknown firewall format: %s (available: %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// Write renders every format to runtime/firewall/
+func Write(project string, rules []Rule) error {
+	if err := os.MkdirAll(paths.FirewallDir, paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create %s: %w", paths.FirewallDir, err)
+	}
+
+	for _, format := range Formats {
+		content, err := Render(format, project, rules)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(paths.FirewallDir, FileName(format))
+		if err := os.WriteFile(path, []byte(content), paths.FilePermissions); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// renderUFW returns a script replacing the ufw rules of its previous run with the current ones
+// Rules are tagged with a comment naming the project, so other rules are left alone
+func renderUFW(project string, rules []Rule) string {
+	tag := "homelabctl:" + project
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n# %s\n# Allows the host ports published by project %s\nset -e\n\n", header, project)
+	b.WriteString("# Remove the rules of the previous run, highest number first\n")
+	fmt.Fprintf(&b, "ufw status numbered | sed -n 's/^\\[ *\\([0-9]*\\)\\].*# %s\\( .*\\)\\{0,1\\}$/\\1/p' | sort -rn | while read -r n; do\n", tag)
+	b.WriteString("\tufw --force delete \"$n\"\ndone\n\n")
+	for _, r := range rules {
+		// ufw writes ranges with a colon
+		fmt.Fprintf(&b, "ufw allow %s/%s comment '%s %s'\n", strings.Replace(r.Port, "-", ":", 1), r.Proto, tag, ruleOwner(r))
+	}
+	return b.String()
+}
+
+// renderNftables returns port set definitions for an nftables ruleset to include:
+//
+//	include "/path/to/runtime/firewall/homelab.nft"
+//	tcp dport $homelab_tcp_ports accept
+func renderNftables(rules []Rule) string {
+	ports := map[string][]string{"tcp": nil, "udp": nil}
+	for _, r := range rules {
+		ports[r.Proto] = append(ports[r.Proto], r.Port)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/usr/sbin/nft -f\n# %s\n", header)
+	b.WriteString("# Include from the ruleset and accept with: tcp dport $homelab_tcp_ports accept\n")
+	for _, r := range rules {
+		fmt.Fprintf(&b, "#   %s/%s %s\n", r.Port, r.Proto, ruleOwner(r))
+	}
+	for _, proto := range []string{"tcp", "udp"} {
+		list := ports[proto]
+		if len(list) == 0 {
+			// nft sets cannot be empty; port 0 never matches
+			list = []string{"0"}
+		}
+		fmt.Fprintf(&b, "define homelab_%s_ports = { %s }\n", proto, strings.Join(list, ", "))
+	}
+	return b.String()
+}
+
+// firewalldService is the schema of a firewalld service definition
+type firewalldService struct {
+	XMLName     xml.Name        `xml:"service"`
+	Short       string          `xml:"short"`
+	Description string          `xml:"description"`
+	Ports       []firewalldPort `xml:"port"`
+}
+
+type firewalldPort struct {
+	Protocol string `xml:"protocol,attr"`
+	Port     string `xml:"port,attr"`
+}
+
+// renderFirewalld returns a service definition named after the project, for
+// /etc/firewalld/services/<project>.xml
+func renderFirewalld(project string, rules []Rule) (string, error) {
+	service := firewalldService{
+		Short:       project,
+		Description: "Host ports published by homelabctl project " + project,
+	}
+	for _, r := range rules {
+		service.Ports = append(service.Ports, firewalldPort{Protocol: r.Proto, Port: r.Port})
+	}
+
+	data, err := xml.MarshalIndent(service, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal firewalld service: %w", err)
+	}
+	return xml.Header + "<!-- " + header + " -->\n" + string(data) + "\n", nil
+}
+
+// ruleOwner names the stack and service a rule is for
+func ruleOwner(r Rule) string {
+	if r.Stack == "" {
+		return r.Service
+	}
+	return r.Stack + "/" + r.Service
+}
@@ -0,0 +1,82 @@
+package firewall
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+)
+
+func testCompose() *compose.ComposeFile {
+	return &compose.ComposeFile{Services: map[string]interface{}{
+		"traefik": map[string]interface{}{
+			"ports":  []interface{}{"80:80", "443:443", "127.0.0.1:8080:8080"},
+			"labels": map[string]interface{}{compose.LabelStack: "core"},
+		},
+		"adguard": map[string]interface{}{
+			"ports": []interface{}{"53:53/udp", "53:53/tcp", "3000-3002:3000-3002"},
+		},
+		"proxy": map[string]interface{}{
+			"ports": []interface{}{"80:8080"},
+		},
+	}}
+}
+
+func TestRules(t *testing.T) {
+	want := []Rule{
+		{Port: "53", Proto: "udp", Service: "adguard"},
+		{Port: "53", Proto: "tcp", Service: "adguard"},
+		{Port: "3000-3002", Proto: "tcp", Service: "adguard"},
+		{Port: "80", Proto: "tcp", Service: "proxy"},
+		{Port: "443", Proto: "tcp", Service: "traefik", Stack: "core"},
+	}
+	if got := Rules(testCompose()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Rules() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	rules := Rules(testCompose())
+
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{FormatUFW, []string{
+			"# homelabctl:homelab\\( .*\\)\\{0,1\\}$",
+			"ufw allow 3000:3002/tcp comment 'homelabctl:homelab adguard'",
+			"ufw allow 443/tcp comment 'homelabctl:homelab core/traefik'",
+		}},
+		{FormatNftables, []string{
+			"define homelab_tcp_ports = { 53, 3000-3002, 80, 443 }",
+			"define homelab_udp_ports = { 53 }",
+		}},
+		{FormatFirewalld, []string{
+			"<short>homelab</short>",
+			`<port protocol="udp" port="53"></port>`,
+			`<port protocol="tcp" port="3000-3002"></port>`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := Render(tt.format, "homelab", rules)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Render() missing %q in:\n%s", want, got)
+				}
+			}
+		})
+	}
+
+	if got, _ := Render(FormatNftables, "homelab", nil); !strings.Contains(got, "define homelab_udp_ports = { 0 }") {
+		t.Errorf("Render() without ports = %s, want placeholder sets", got)
+	}
+	if _, err := Render("iptables", "homelab", rules); err == nil {
+		t.Error("Render(iptables) should fail")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/monkeymonk/homelabctl/internal/errors"
 	"github.com/monkeymonk/homelabctl/internal/paths"
 )
 
@@ -87,11 +88,11 @@ func IsStackEnabled(name string) bool {
 // EnableStack creates a symlink in enabled/
 func EnableStack(name string) error {
 	if !StackExists(name) {
-		return fmt.Errorf("stack does not exist: %s", name)
+		return fmt.Errorf("%w: %s", errors.ErrStackNotFound, name)
 	}
 
 	if IsStackEnabled(name) {
-		return fmt.Errorf("stack already enabled: %s", name)
+		return fmt.Errorf("stack %w: %s", errors.ErrAlreadyEnabled, name)
 	}
 
 	linkPath := paths.EnabledStackLink(name)
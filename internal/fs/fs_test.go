@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
 )
 
 func setupTestRepo(t *testing.T) (string, func()) {
@@ -277,8 +279,8 @@ func TestEnableStack_AlreadyEnabled(t *testing.T) {
 
 	// Try to enable again
 	err := EnableStack(stackName)
-	if err == nil {
-		t.Error("EnableStack() should return error when already enabled")
+	if !errors.Is(err, errors.ErrAlreadyEnabled) {
+		t.Errorf("EnableStack() error = %v, want ErrAlreadyEnabled", err)
 	}
 }
 
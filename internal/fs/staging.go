@@ -0,0 +1,103 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// stagedMove records one file swapped into place, so it can be undone
+type stagedMove struct {
+	target string
+	backup string // Empty if target did not exist before
+}
+
+// CommitStaged moves every file under stagingDir to the same relative path under targetDir.
+// Each file is swapped in with an atomic rename; nested files move before top-level ones
+// so that e.g. configs are in place before the compose file referencing them.
+// If any move fails, the files already moved are restored and targetDir is left as it was.
+// Files in targetDir that are not in stagingDir are never touched.
+func CommitStaged(stagingDir, targetDir string) error {
+	var files []string
+	err := filepath.Walk(stagingDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(stagingDir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read staging dir %s: %w", stagingDir, err)
+	}
+
+	// Deepest paths first, top-level files last
+	sort.SliceStable(files, func(i, j int) bool {
+		return strings.Count(files[i], string(filepath.Separator)) > strings.Count(files[j], string(filepath.Separator))
+	})
+
+	backupDir := stagingDir + "-previous"
+	if err := os.RemoveAll(backupDir); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", backupDir, err)
+	}
+
+	var done []stagedMove
+	for _, rel := range files {
+		move, err := swapIn(filepath.Join(stagingDir, rel), filepath.Join(targetDir, rel), filepath.Join(backupDir, rel))
+		if err != nil {
+			rollback(done)
+			return fmt.Errorf("failed to commit %s: %w", rel, err)
+		}
+		done = append(done, move)
+	}
+
+	_ = os.RemoveAll(backupDir)
+	return os.RemoveAll(stagingDir)
+}
+
+// swapIn moves src to target, keeping any previous target at backup
+func swapIn(src, target, backup string) (stagedMove, error) {
+	move := stagedMove{target: target}
+
+	if err := EnsureDir(filepath.Dir(target)); err != nil {
+		return move, err
+	}
+
+	if _, err := os.Lstat(target); err == nil {
+		if err := EnsureDir(filepath.Dir(backup)); err != nil {
+			return move, err
+		}
+		if err := os.Rename(target, backup); err != nil {
+			return move, err
+		}
+		move.backup = backup
+	}
+
+	if err := os.Rename(src, target); err != nil {
+		// Put the previous file back before reporting
+		if move.backup != "" {
+			_ = os.Rename(move.backup, target)
+		}
+		return move, err
+	}
+
+	return move, nil
+}
+
+// rollback restores files replaced by CommitStaged, most recent first
+func rollback(done []stagedMove) {
+	for i := len(done) - 1; i >= 0; i-- {
+		if done[i].backup != "" {
+			_ = os.Rename(done[i].backup, done[i].target)
+		} else {
+			_ = os.Remove(done[i].target)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir for %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestCommitStaged(t *testing.T) {
+	tmpDir := t.TempDir()
+	runtime := filepath.Join(tmpDir, "runtime")
+	staging := filepath.Join(runtime, ".staging")
+
+	writeTestFile(t, filepath.Join(runtime, "docker-compose.yml"), "old")
+	writeTestFile(t, filepath.Join(runtime, "traefik", "acme.json"), "certs")
+	writeTestFile(t, filepath.Join(staging, "docker-compose.yml"), "new")
+	writeTestFile(t, filepath.Join(staging, "traefik", "dynamic", "app-router.yml"), "router")
+
+	if err := CommitStaged(staging, runtime); err != nil {
+		t.Fatalf("CommitStaged() unexpected error: %v", err)
+	}
+
+	if got := readTestFile(t, filepath.Join(runtime, "docker-compose.yml")); got != "new" {
+		t.Errorf("docker-compose.yml = %q, want %q", got, "new")
+	}
+	if got := readTestFile(t, filepath.Join(runtime, "traefik", "dynamic", "app-router.yml")); got != "router" {
+		t.Errorf("app-router.yml = %q, want %q", got, "router")
+	}
+
+	// Files not produced by the run are left alone
+	if got := readTestFile(t, filepath.Join(runtime, "traefik", "acme.json")); got != "certs" {
+		t.Errorf("acme.json = %q, want %q", got, "certs")
+	}
+
+	if _, err := os.Stat(staging); !os.IsNotExist(err) {
+		t.Error("CommitStaged() should remove the staging dir")
+	}
+}
+
+func TestCommitStaged_Rollback(t *testing.T) {
+	tmpDir := t.TempDir()
+	runtime := filepath.Join(tmpDir, "runtime")
+	staging := filepath.Join(runtime, ".staging")
+
+	writeTestFile(t, filepath.Join(runtime, "app", "conf", "app.ini"), "old")
+	writeTestFile(t, filepath.Join(staging, "app", "conf", "app.ini"), "new")
+
+	// A file where a directory is expected makes the second move fail
+	writeTestFile(t, filepath.Join(runtime, "web"), "not a directory")
+	writeTestFile(t, filepath.Join(staging, "web", "site.conf"), "site")
+
+	if err := CommitStaged(staging, runtime); err == nil {
+		t.Fatal("CommitStaged() should fail when a file cannot be moved")
+	}
+
+	// The file already swapped in is restored
+	if got := readTestFile(t, filepath.Join(runtime, "app", "conf", "app.ini")); got != "old" {
+		t.Errorf("app.ini = %q, want %q after rollback", got, "old")
+	}
+}
@@ -0,0 +1,90 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SetYAMLValue sets the value at a dotted path in a YAML file, creating the file and
+// nested mappings as needed. The file is edited as a node tree so comments and ordering are kept
+func SetYAMLValue(file, path string, value interface{}, perm os.FileMode) error {
+	data, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	data, err = SetYAMLBytes(data, file, path, value)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(file, data, perm); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+
+	return nil
+}
+
+// SetYAMLBytes sets the value at a dotted path in a YAML document, as SetYAMLValue does
+// for a file; file names the document in errors
+func SetYAMLBytes(data []byte, file, path string, value interface{}) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	if doc.Kind == 0 {
+		doc.Kind = yaml.DocumentNode
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	valueNode := &yaml.Node{}
+	if err := valueNode.Encode(value); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+
+	current := doc.Content[0]
+	keys := strings.Split(path, ".")
+	for i, key := range keys {
+		if current.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("cannot set %s in %s: %s is not a mapping", path, file, strings.Join(keys[:i], "."))
+		}
+
+		var next *yaml.Node
+		for j := 0; j+1 < len(current.Content); j += 2 {
+			if current.Content[j].Value == key {
+				next = current.Content[j+1]
+				if i == len(keys)-1 {
+					current.Content[j+1] = valueNode
+				}
+				break
+			}
+		}
+
+		if next == nil {
+			next = valueNode
+			if i < len(keys)-1 {
+				next = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			current.Content = append(current.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, next)
+		}
+		current = next
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", file, err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", file, err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,124 @@
+// Package health verifies a deployment: it waits for the containers that have a
+// healthcheck to report healthy, each within the timeout of its stack
+package health
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultTimeout is how long the services of a stack without health_timeout may take
+// to become healthy
+const DefaultTimeout = 2 * time.Minute
+
+// Container is the state of one container of a service
+type Container struct {
+	Service  string
+	Status   string // created, running, restarting, exited, dead...
+	Health   string // healthy, unhealthy, starting; empty without a healthcheck
+	ExitCode string
+}
+
+// Check is a deployed service and how long its stack allows it to become healthy
+type Check struct {
+	Service string
+	Stack   string
+	Timeout time.Duration
+}
+
+// Failure is a service that was not healthy within its timeout, or stopped
+type Failure struct {
+	Service string
+	Stack   string
+	State   string // Last state seen, e.g. "running, unhealthy"
+}
+
+// Result lists the services verified: healthy ones, and failures in service order
+// Services without a healthcheck are in neither
+type Result struct {
+	Healthy  []string
+	Failures []Failure
+}
+
+// States returns the containers of each service
+type States func() (map[string][]Container, error)
+
+// now and sleep are replaced in tests
+var (
+	now   = time.Now
+	sleep = time.Sleep
+)
+
+// Verify polls the containers every interval until each checked service that has a
+// healthcheck is healthy or its timeout has passed
+// A container that exited with an error or died fails its service at once
+func Verify(checks []Check, states States, interval time.Duration) (*Result, error) {
+	start := now()
+	result := &Result{}
+	pending := append([]Check(nil), checks...)
+
+	for {
+		containers, err := states()
+		if err != nil {
+			return nil, err
+		}
+
+		var waiting []Check
+		for _, check := range pending {
+			done, state := serviceHealth(containers[check.Service])
+			switch {
+			case done && state == "":
+				// No healthcheck: nothing to verify
+			case done && state == "healthy":
+				result.Healthy = append(result.Healthy, check.Service)
+			case done:
+				result.Failures = append(result.Failures, Failure{Service: check.Service, Stack: check.Stack, State: state})
+			case now().Sub(start) >= check.Timeout:
+				result.Failures = append(result.Failures, Failure{
+					Service: check.Service,
+					Stack:   check.Stack,
+					State:   fmt.Sprintf("%s after %s", state, check.Timeout),
+				})
+			default:
+				waiting = append(waiting, check)
+			}
+		}
+
+		if pending = waiting; len(pending) == 0 {
+			break
+		}
+		sleep(interval)
+	}
+
+	sort.Strings(result.Healthy)
+	sort.Slice(result.Failures, func(i, j int) bool {
+		return result.Failures[i].Service < result.Failures[j].Service
+	})
+	return result, nil
+}
+
+// serviceHealth reports whether a service is settled, with its state: "" when none of
+// its containers has a healthcheck, "healthy", or why it failed; while not settled,
+// the state is the one of the first container not yet healthy
+func serviceHealth(containers []Container) (bool, string) {
+	hasHealthcheck := false
+	for _, c := range containers {
+		if c.Status == "dead" || (c.Status == "exited" && c.ExitCode != "0") {
+			return true, fmt.Sprintf("%s (exit code %s)", c.Status, c.ExitCode)
+		}
+		if c.Health != "" {
+			hasHealthcheck = true
+		}
+	}
+	if !hasHealthcheck {
+		return true, ""
+	}
+
+	for _, c := range containers {
+		if c.Health != "" && (c.Status != "running" || c.Health != "healthy") {
+			return false, c.Status + ", " + c.Health
+		}
+	}
+	return true, "healthy"
+}
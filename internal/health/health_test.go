@@ -0,0 +1,76 @@
+package health
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	sleep = func(d time.Duration) { clock = clock.Add(d) }
+	defer func() { now, sleep = time.Now, time.Sleep }()
+
+	polls := 0
+	states := func() (map[string][]Container, error) {
+		polls++
+		web := "starting"
+		if polls >= 3 {
+			web = "healthy"
+		}
+		return map[string][]Container{
+			"web":    {{Service: "web", Status: "running", Health: web}},
+			"db":     {{Service: "db", Status: "running", Health: "unhealthy"}},
+			"worker": {{Service: "worker", Status: "running"}},
+			"job":    {{Service: "job", Status: "exited", ExitCode: "1", Health: "starting"}},
+		}, nil
+	}
+
+	checks := []Check{
+		{Service: "web", Stack: "site", Timeout: time.Minute},
+		{Service: "db", Stack: "site", Timeout: 30 * time.Second},
+		{Service: "worker", Stack: "site", Timeout: time.Minute},
+		{Service: "job", Stack: "batch", Timeout: time.Minute},
+	}
+	result, err := Verify(checks, states, 10*time.Second)
+	if err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+
+	if want := []string{"web"}; !reflect.DeepEqual(result.Healthy, want) {
+		t.Errorf("Healthy = %v, want %v", result.Healthy, want)
+	}
+	want := []Failure{
+		{Service: "db", Stack: "site", State: "running, unhealthy after 30s"},
+		{Service: "job", Stack: "batch", State: "exited (exit code 1)"},
+	}
+	if !reflect.DeepEqual(result.Failures, want) {
+		t.Errorf("Failures = %+v, want %+v", result.Failures, want)
+	}
+	if polls != 4 {
+		t.Errorf("polled %d times, want 4 (until db's timeout)", polls)
+	}
+}
+
+func TestServiceHealth(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers []Container
+		done       bool
+		state      string
+	}{
+		{"no healthcheck", []Container{{Status: "running"}}, true, ""},
+		{"healthy", []Container{{Status: "running", Health: "healthy"}, {Status: "running"}}, true, "healthy"},
+		{"starting", []Container{{Status: "running", Health: "healthy"}, {Status: "running", Health: "starting"}}, false, "running, starting"},
+		{"restarting", []Container{{Status: "restarting", Health: "healthy"}}, false, "restarting, healthy"},
+		{"dead", []Container{{Status: "dead", ExitCode: "137"}}, true, "dead (exit code 137)"},
+		{"completed", []Container{{Status: "exited", ExitCode: "0"}}, true, ""},
+	}
+	for _, tt := range tests {
+		done, state := serviceHealth(tt.containers)
+		if done != tt.done || state != tt.state {
+			t.Errorf("%s: serviceHealth() = %v, %q, want %v, %q", tt.name, done, state, tt.done, tt.state)
+		}
+	}
+}
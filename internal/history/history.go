@@ -0,0 +1,164 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// timestampFormat names history entries so they sort chronologically; generations
+// recorded within the same second get a sequence suffix: 20240312-031500-2
+const timestampFormat = "20060102-150405"
+
+// Snapshot records what a single generate produced
+type Snapshot struct {
+	ID     string                   `yaml:"-"`
+	Time   time.Time                `yaml:"time"`
+	Stacks map[string]StackSnapshot `yaml:"stacks"`
+}
+
+// StackSnapshot records a stack's category and the image used by each of its services
+type StackSnapshot struct {
+	Category string            `yaml:"category"`
+	Services map[string]string `yaml:"services"`
+}
+
+// NewSnapshot creates an empty snapshot for the current time
+func NewSnapshot() *Snapshot {
+	now := time.Now()
+	return &Snapshot{
+		ID:     now.Format(timestampFormat),
+		Time:   now,
+		Stacks: make(map[string]StackSnapshot),
+	}
+}
+
+// Record writes a snapshot to runtime/history/<timestamp>/snapshot.yaml; when a
+// generation was already recorded under that ID, the snapshot's ID gets the next
+// sequence suffix
+func Record(snapshot *Snapshot) error {
+	if err := os.MkdirAll(paths.HistoryDir, paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create history dir: %w", err)
+	}
+
+	base := snapshot.ID
+	for seq := 2; ; seq++ {
+		err := os.Mkdir(paths.HistoryEntryDir(snapshot.ID), paths.DirPermissions)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("failed to create history dir: %w", err)
+		}
+		snapshot.ID = fmt.Sprintf("%s-%d", base, seq)
+	}
+	dir := paths.HistoryEntryDir(snapshot.ID)
+
+	data, err := yaml.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history snapshot: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, paths.HistorySnapshot), data, paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write history snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// List returns all recorded snapshots, oldest first
+// Entries without a readable snapshot are skipped
+func List() ([]*Snapshot, error) {
+	entries, err := os.ReadDir(paths.HistoryDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", paths.HistoryDir, err)
+	}
+
+	var snapshots []*Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		snapshot, err := Load(entry.Name())
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return idLess(snapshots[i].ID, snapshots[j].ID)
+	})
+
+	return snapshots, nil
+}
+
+// idLess orders generation IDs chronologically: by timestamp, then by sequence suffix
+func idLess(a, b string) bool {
+	stampA, seqA := splitID(a)
+	stampB, seqB := splitID(b)
+	if stampA != stampB {
+		return stampA < stampB
+	}
+	return seqA < seqB
+}
+
+// splitID returns the timestamp of a generation ID and its sequence number (1 without
+// a suffix)
+func splitID(id string) (string, int) {
+	if len(id) > len(timestampFormat)+1 && id[len(timestampFormat)] == '-' {
+		if seq, err := strconv.Atoi(id[len(timestampFormat)+1:]); err == nil {
+			return id[:len(timestampFormat)], seq
+		}
+	}
+	return id, 1
+}
+
+// Load reads a single snapshot by ID
+func Load(id string) (*Snapshot, error) {
+	data, err := os.ReadFile(filepath.Join(paths.HistoryEntryDir(id), paths.HistorySnapshot))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history snapshot %s: %w", id, err)
+	}
+
+	var snapshot Snapshot
+	if err := yaml.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse history snapshot %s: %w", id, err)
+	}
+
+	snapshot.ID = id
+	if snapshot.Stacks == nil {
+		snapshot.Stacks = make(map[string]StackSnapshot)
+	}
+
+	return &snapshot, nil
+}
+
+// ImagesByStack collects every image each stack has used across all snapshots
+func ImagesByStack(snapshots []*Snapshot) map[string]map[string]bool {
+	images := make(map[string]map[string]bool)
+
+	for _, snapshot := range snapshots {
+		for stackName, stack := range snapshot.Stacks {
+			if images[stackName] == nil {
+				images[stackName] = make(map[string]bool)
+			}
+			for _, image := range stack.Services {
+				images[stackName][image] = true
+			}
+		}
+	}
+
+	return images
+}
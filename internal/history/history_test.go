@@ -0,0 +1,232 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestRecordAndList(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	// No history yet
+	snapshots, err := List()
+	if err != nil {
+		t.Fatalf("List() unexpected error: %v", err)
+	}
+	if len(snapshots) != 0 {
+		t.Errorf("Expected no snapshots, got %d", len(snapshots))
+	}
+
+	first := NewSnapshot()
+	first.Stacks["media"] = StackSnapshot{
+		Category: "media",
+		Services: map[string]string{"jellyfin": "jellyfin/jellyfin:10.8"},
+	}
+	if err := Record(first); err != nil {
+		t.Fatalf("Record() unexpected error: %v", err)
+	}
+
+	second := NewSnapshot()
+	second.ID = first.Time.Add(time.Minute).Format(timestampFormat)
+	second.Stacks["media"] = StackSnapshot{
+		Category: "media",
+		Services: map[string]string{"jellyfin": "jellyfin/jellyfin:10.9"},
+	}
+	if err := Record(second); err != nil {
+		t.Fatalf("Record() unexpected error: %v", err)
+	}
+
+	snapshots, err = List()
+	if err != nil {
+		t.Fatalf("List() unexpected error: %v", err)
+	}
+
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(snapshots))
+	}
+
+	if snapshots[0].ID != first.ID {
+		t.Errorf("Snapshots should be sorted oldest first, got %s", snapshots[0].ID)
+	}
+
+	images := ImagesByStack(snapshots)
+	if len(images["media"]) != 2 {
+		t.Errorf("Expected 2 images recorded for media, got %v", images["media"])
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	now := time.Now()
+	record := func(ages ...time.Duration) {
+		for _, age := range ages {
+			snapshot := NewSnapshot()
+			snapshot.ID = now.Add(-age).Format(timestampFormat)
+			if err := Record(snapshot); err != nil {
+				t.Fatalf("Record() unexpected error: %v", err)
+			}
+		}
+	}
+	record(72*time.Hour, 48*time.Hour, 24*time.Hour, time.Hour)
+
+	// Dry run keeps everything
+	removed, err := Prune(Retention{Keep: 2}, now, true)
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("Prune(keep 2, dry run) = %v, %v, want 2 generations", removed, err)
+	}
+	if snapshots, _ := List(); len(snapshots) != 4 {
+		t.Errorf("Prune() with dry run removed generations, %d left", len(snapshots))
+	}
+
+	if removed, _ := Prune(Retention{MaxAge: 36 * time.Hour}, now, false); len(removed) != 2 {
+		t.Errorf("Prune(max age 36h) removed %v, want the 2 oldest", removed)
+	}
+
+	// The latest generation is kept whatever the limits
+	if removed, _ := Prune(Retention{Keep: 1, MaxSize: 1}, now, false); len(removed) != 1 {
+		t.Errorf("Prune(keep 1, max size 1) removed %v, want all but the latest", removed)
+	}
+	snapshots, _ := List()
+	if len(snapshots) != 1 || snapshots[0].ID != now.Add(-time.Hour).Format(timestampFormat) {
+		t.Errorf("Prune() left %v, want the latest generation", snapshots)
+	}
+
+	if removed, err := Purge(false); err != nil || len(removed) != 1 {
+		t.Errorf("Purge() = %v, %v", removed, err)
+	}
+	if snapshots, _ := List(); len(snapshots) != 0 {
+		t.Errorf("Purge() left %d generations", len(snapshots))
+	}
+}
+
+func TestRecord_SameSecond(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	var ids []string
+	for i := 0; i < 11; i++ {
+		snapshot := NewSnapshot()
+		snapshot.ID = "20240101-000000"
+		if err := Record(snapshot); err != nil {
+			t.Fatalf("Record() unexpected error: %v", err)
+		}
+		ids = append(ids, snapshot.ID)
+	}
+	if ids[0] != "20240101-000000" || ids[1] != "20240101-000000-2" || ids[10] != "20240101-000000-11" {
+		t.Errorf("Record() IDs = %v, want sequence suffixes after the first", ids)
+	}
+
+	snapshots, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, snapshot := range snapshots {
+		listed = append(listed, snapshot.ID)
+	}
+	if !reflect.DeepEqual(listed, ids) {
+		t.Errorf("List() = %v, want them in recording order", listed)
+	}
+}
+
+func TestArchiveAndRestore(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "runtime/docker-compose.yml", "services: {}\n")
+	testutil.WriteFile(t, "runtime/monitoring/grafana.ini", "[server]\n")
+	if err := os.Chmod("runtime/monitoring/grafana.ini", 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, err := Outputs("20240101-000000"); err != nil || len(files) != 0 {
+		t.Errorf("Outputs() without archive = %v, %v; want none", files, err)
+	}
+
+	files := []string{"runtime/docker-compose.yml", "runtime/monitoring/grafana.ini", "runtime/missing.yml"}
+	if err := Archive("20240101-000000", files); err != nil {
+		t.Fatalf("Archive() failed: %v", err)
+	}
+	if err := Archive("20240101-000000", []string{"stacks/monitoring/stack.yaml"}); err == nil {
+		t.Error("Archive() should refuse a file outside runtime/")
+	}
+
+	restored, err := Restore("20240101-000000", "staging")
+	if err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	want := []string{"docker-compose.yml", filepath.Join("monitoring", "grafana.ini")}
+	if !reflect.DeepEqual(restored, want) {
+		t.Errorf("Restore() = %v, want %v", restored, want)
+	}
+	info, err := os.Stat("staging/monitoring/grafana.ini")
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("restored grafana.ini = %v, %v; want mode 0600", info, err)
+	}
+
+	if _, err := Restore("20240102-000000", "staging"); err == nil {
+		t.Error("Restore() should fail for a generation without archived outputs")
+	}
+
+	if Current() != "" {
+		t.Errorf("Current() = %q before any generation was recorded", Current())
+	}
+	if err := SetCurrent("20240101-000000"); err != nil || Current() != "20240101-000000" {
+		t.Errorf("SetCurrent() = %v, Current() = %q", err, Current())
+	}
+}
+
+func TestArchiveAndRestore_Owner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the owner of a file requires root")
+	}
+
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "runtime/.secrets/app/db_password", "secret")
+	if err := os.Chown("runtime/.secrets/app/db_password", 1234, 1234); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(paths.HistoryEntryDir("20240101-000000"), paths.DirPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if err := Archive("20240101-000000", []string{"runtime/.secrets/app/db_password"}); err != nil {
+		t.Fatalf("Archive() failed: %v", err)
+	}
+
+	if _, err := Restore("20240101-000000", "staging"); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+	info, err := os.Stat("staging/.secrets/app/db_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner, _ := fileOwner(info); owner != (Owner{UID: 1234, GID: 1234}) {
+		t.Errorf("restored owner = %v, want 1234:1234", owner)
+	}
+}
@@ -0,0 +1,202 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// outputsDir returns where a generation's generated files are archived
+func outputsDir(id string) string {
+	return filepath.Join(paths.HistoryEntryDir(id), paths.HistoryOutputs)
+}
+
+// Owner is the user and group of an archived file
+type Owner struct {
+	UID int `yaml:"uid"`
+	GID int `yaml:"gid"`
+}
+
+// Archive copies generated files (paths in runtime/) into a generation's outputs, with
+// their modes, and records their owners, so the generation can be restored; missing
+// files are skipped
+func Archive(id string, files []string) error {
+	owners := make(map[string]Owner)
+	for _, file := range files {
+		rel, err := filepath.Rel(paths.Runtime, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("cannot archive %s: not in %s", file, paths.Runtime)
+		}
+
+		info, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", file, err)
+		}
+		if err := copyFile(file, filepath.Join(outputsDir(id), rel), info.Mode().Perm(), nil); err != nil {
+			return fmt.Errorf("failed to archive %s: %w", file, err)
+		}
+		if owner, ok := fileOwner(info); ok {
+			owners[filepath.ToSlash(rel)] = owner
+		}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+
+	// The archived copies belong to whoever runs generate, so the owners are kept aside
+	data, err := yaml.Marshal(owners)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the owners of generation %s: %w", id, err)
+	}
+	if err := os.WriteFile(filepath.Join(paths.HistoryEntryDir(id), paths.HistoryOwners), data, paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to record the owners of generation %s: %w", id, err)
+	}
+	return nil
+}
+
+// owners returns the owners recorded with a generation's outputs, keyed by slash-separated
+// path relative to runtime/; none for generations archived without them
+func owners(id string) (map[string]Owner, error) {
+	data, err := os.ReadFile(filepath.Join(paths.HistoryEntryDir(id), paths.HistoryOwners))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the owners of generation %s: %w", id, err)
+	}
+	var recorded map[string]Owner
+	if err := yaml.Unmarshal(data, &recorded); err != nil {
+		return nil, fmt.Errorf("failed to parse the owners of generation %s: %w", id, err)
+	}
+	return recorded, nil
+}
+
+// Outputs returns the files archived with a generation, as paths relative to runtime/,
+// sorted; none for generations recorded before outputs were archived
+func Outputs(id string) ([]string, error) {
+	dir := outputsDir(id)
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the outputs of generation %s: %w", id, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// OutputFile returns where a generated file (a path relative to runtime/) of a
+// generation is archived
+func OutputFile(id, rel string) string {
+	return filepath.Join(outputsDir(id), rel)
+}
+
+// Restore copies a generation's archived files into stagingDir, laid out as in
+// runtime/ and with the modes and owners they had, and returns them (paths relative to
+// runtime/)
+func Restore(id, stagingDir string) ([]string, error) {
+	files, err := Outputs(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("generation %s has no archived outputs", id)
+	}
+	recorded, err := owners(id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rel := range files {
+		src := filepath.Join(outputsDir(id), rel)
+		info, err := os.Stat(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", rel, err)
+		}
+		var owner *Owner
+		if o, ok := recorded[filepath.ToSlash(rel)]; ok {
+			owner = &o
+		}
+		if err := copyFile(src, filepath.Join(stagingDir, rel), info.Mode().Perm(), owner); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", rel, err)
+		}
+	}
+	return files, nil
+}
+
+// copyFile copies a file, creating the directories of dst; with owner, the copy is given
+// that owner unless it already has it
+func copyFile(src, dst string, mode os.FileMode, owner *Owner) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), paths.DirPermissions); err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, data, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, mode); err != nil {
+		return err
+	}
+	if owner == nil {
+		return nil
+	}
+
+	info, err := os.Lstat(dst)
+	if err != nil {
+		return err
+	}
+	if current, ok := fileOwner(info); ok && current == *owner {
+		return nil
+	}
+	if err := os.Lchown(dst, owner.UID, owner.GID); err != nil {
+		return fmt.Errorf("cannot give it owner %d:%d: %w", owner.UID, owner.GID, err)
+	}
+	return nil
+}
+
+// Current returns the generation runtime/ holds: the one generate last recorded or
+// rollback last restored; empty when unknown
+func Current() string {
+	data, err := os.ReadFile(paths.HistoryCurrent)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// SetCurrent records the generation runtime/ holds
+func SetCurrent(id string) error {
+	if err := os.MkdirAll(paths.HistoryDir, paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create history dir: %w", err)
+	}
+	if err := os.WriteFile(paths.HistoryCurrent, []byte(id+"\n"), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to record the current generation: %w", err)
+	}
+	return nil
+}
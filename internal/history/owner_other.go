@@ -0,0 +1,10 @@
+//go:build !unix
+
+package history
+
+import "os"
+
+// fileOwner reports no owner where files have no uid and gid
+func fileOwner(info os.FileInfo) (Owner, bool) {
+	return Owner{}, false
+}
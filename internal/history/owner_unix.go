@@ -0,0 +1,17 @@
+//go:build unix
+
+package history
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the owner of a file
+func fileOwner(info os.FileInfo) (Owner, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Owner{}, false
+	}
+	return Owner{UID: int(stat.Uid), GID: int(stat.Gid)}, true
+}
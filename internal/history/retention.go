@@ -0,0 +1,120 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Retention limits the generations kept in runtime/history/; zero values are no limit
+// The latest generation is always kept
+type Retention struct {
+	Keep        int           // Number of generations
+	MaxAge      time.Duration // Age of a generation
+	MaxSize     int64         // Total size of runtime/history/, in bytes
+	DefaultKeep bool          // Keep was not configured, so the first prune it causes is reported
+}
+
+// Prune removes the generations the retention policy no longer keeps, oldest first, and
+// returns their IDs; with dryRun nothing is removed
+func Prune(retention Retention, now time.Time, dryRun bool) ([]string, error) {
+	ids, err := entries()
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, len(ids))
+	var total int64
+	for _, id := range ids {
+		size, err := dirSize(paths.HistoryEntryDir(id))
+		if err != nil {
+			return nil, err
+		}
+		sizes[id] = size
+		total += size
+	}
+
+	var removed []string
+	for i, id := range ids[:max(len(ids)-1, 0)] {
+		tooMany := retention.Keep > 0 && len(ids)-i > retention.Keep
+		tooLarge := retention.MaxSize > 0 && total > retention.MaxSize
+		tooOld := false
+		stamp, _ := splitID(id)
+		if recorded, err := time.ParseInLocation(timestampFormat, stamp, now.Location()); err == nil {
+			tooOld = retention.MaxAge > 0 && now.Sub(recorded) > retention.MaxAge
+		}
+		if !tooMany && !tooLarge && !tooOld {
+			continue
+		}
+
+		if !dryRun {
+			if err := os.RemoveAll(paths.HistoryEntryDir(id)); err != nil {
+				return removed, fmt.Errorf("failed to remove history entry %s: %w", id, err)
+			}
+		}
+		removed = append(removed, id)
+		total -= sizes[id]
+	}
+
+	return removed, nil
+}
+
+// Purge removes every generation and returns their IDs; with dryRun nothing is removed
+func Purge(dryRun bool) ([]string, error) {
+	ids, err := entries()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		if dryRun {
+			continue
+		}
+		if err := os.RemoveAll(paths.HistoryEntryDir(id)); err != nil {
+			return ids[:i], fmt.Errorf("failed to remove history entry %s: %w", id, err)
+		}
+	}
+	return ids, nil
+}
+
+// entries returns the IDs of the generation directories in runtime/history/, oldest first
+func entries() ([]string, error) {
+	dirEntries, err := os.ReadDir(paths.HistoryDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", paths.HistoryDir, err)
+	}
+
+	var ids []string
+	for _, entry := range dirEntries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return idLess(ids[i], ids[j]) })
+	return ids, nil
+}
+
+// dirSize returns the total size of the files under a directory
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", dir, err)
+	}
+	return size, nil
+}
@@ -0,0 +1,129 @@
+// Package hooks runs the shell commands stacks attach to generate and deploy events
+// (the hooks section of stack.yaml), with the stack's merged variables in the environment
+package hooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/catalog"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// VarPrefix starts the environment variables hooks receive the merged variables in,
+// e.g. HOMELAB_VAR_GRAFANA_PORT for grafana.port
+const VarPrefix = "HOMELAB_VAR_"
+
+// outputLines is how many of the last output lines a failure reports
+const outputLines = 10
+
+// Output receives what hook commands print; replaced in tests
+var Output io.Writer = os.Stdout
+
+// Run runs a stack's commands for an event in order, in the stack directory, stopping at
+// the first one that fails; vars are the stack's merged variables
+// Hooks of a stack installed from an untrusted catalog are refused: they would run on
+// the host, outside the sandbox its templates render in
+func Run(stackName, event string, commands []string, vars map[string]interface{}) error {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	untrusted, err := catalog.Untrusted(stackName)
+	if err != nil {
+		return err
+	}
+	if untrusted {
+		return errors.New(
+			fmt.Sprintf("refusing to run the %s hook of stack %s, installed from an untrusted catalog", event, stackName),
+			fmt.Sprintf("Review the hooks section of %s", paths.StackYAMLPath(stackName)),
+			fmt.Sprintf("Then trust its catalog in %s (trusted: true), or remove the hooks", paths.InventoryCatalogs),
+		).WithClass(errors.ClassValidation)
+	}
+
+	env := append(os.Environ(), "HOMELAB_STACK="+stackName, "HOMELAB_HOOK="+event)
+	env = append(env, Env(vars)...)
+
+	for _, command := range commands {
+		fmt.Fprintf(Output, "Running %s hook of %s: %s\n", event, stackName, command)
+
+		var captured bytes.Buffer
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Dir = paths.StackDir(stackName)
+		cmd.Env = env
+		cmd.Stdout = io.MultiWriter(Output, &captured)
+		cmd.Stderr = io.MultiWriter(Output, &captured)
+
+		if err := cmd.Run(); err != nil {
+			e := errors.New(
+				fmt.Sprintf("%s hook of stack %s failed: %v", event, stackName, err),
+				fmt.Sprintf("Fix the command in the hooks section of %s", paths.StackYAMLPath(stackName)),
+			).WithContext("Command: " + command)
+			if tail := lastLines(captured.String(), outputLines); len(tail) > 0 {
+				e = e.WithContext("Output:")
+				for _, line := range tail {
+					e = e.WithContext("  " + line)
+				}
+			}
+			return e
+		}
+	}
+	return nil
+}
+
+// envNameInvalid matches what cannot be part of an environment variable name
+var envNameInvalid = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// Env turns merged variables into sorted NAME=value entries: nested keys joined with _,
+// upper-cased, behind VarPrefix; lists are JSON
+func Env(vars map[string]interface{}) []string {
+	var env []string
+	envInto(VarPrefix, vars, &env)
+	sort.Strings(env)
+	return env
+}
+
+func envInto(prefix string, vars map[string]interface{}, env *[]string) {
+	for key, value := range vars {
+		name := prefix + strings.Trim(envNameInvalid.ReplaceAllString(strings.ToUpper(key), "_"), "_")
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			envInto(name+"_", v, env)
+		case nil:
+			*env = append(*env, name+"=")
+		case string:
+			*env = append(*env, name+"="+v)
+		case []interface{}:
+			data, err := json.Marshal(v)
+			if err != nil {
+				data = []byte(fmt.Sprint(v))
+			}
+			*env = append(*env, name+"="+string(data))
+		default:
+			*env = append(*env, fmt.Sprintf("%s=%v", name, v))
+		}
+	}
+}
+
+// lastLines returns the last n non-empty lines of output
+func lastLines(output string, n int) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimRight(line, "\r "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,111 @@
+package hooks
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestRun(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	var output bytes.Buffer
+	Output = &output
+	defer func() { Output = os.Stdout }()
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\n")
+	vars := map[string]interface{}{"jellyfin": map[string]interface{}{"port": 8096}}
+	commands := []string{
+		"echo \"$HOMELAB_STACK $HOMELAB_HOOK $HOMELAB_VAR_JELLYFIN_PORT\" > hook.out",
+		"echo done",
+	}
+	if err := Run("media", "pre-deploy", commands, vars); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join("stacks", "media", "hook.out"))
+	if err != nil {
+		t.Fatalf("hook did not run in the stack directory: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "media pre-deploy 8096" {
+		t.Errorf("hook environment = %q, want stack, event and variable", got)
+	}
+	if !strings.Contains(output.String(), "done") {
+		t.Errorf("output = %q, want the second command's", output.String())
+	}
+
+	err = Run("media", "post-deploy", []string{"echo checking; echo 'disk full' >&2; exit 3", "echo never"}, nil)
+	if err == nil {
+		t.Fatal("Run() should fail when a command fails")
+	}
+	for _, want := range []string{"post-deploy hook of stack media failed", "exit status 3", "disk full"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %q, want it to contain %q", err.Error(), want)
+		}
+	}
+	if strings.Contains(output.String(), "never") {
+		t.Error("Run() should stop at the first failing command")
+	}
+}
+
+func TestRun_RefusesUntrustedStacks(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	var output bytes.Buffer
+	Output = &output
+	defer func() { Output = os.Stdout }()
+
+	testutil.WriteFile(t, "stacks/immich/stack.yaml", "name: immich\n")
+	testutil.WriteFile(t, "stacks.lock", "stacks:\n  immich:\n    catalog: community\n    source: https://example.com/stacks.git\n")
+	testutil.WriteFile(t, "inventory/catalogs.yaml", "catalogs:\n  community: https://example.com/stacks.git\n")
+
+	err := Run("immich", "post-deploy", []string{"touch hook.out"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "untrusted catalog") {
+		t.Fatalf("Run() error = %v, want the untrusted stack's hook refused", err)
+	}
+	if _, err := os.Stat(filepath.Join("stacks", "immich", "hook.out")); !os.IsNotExist(err) {
+		t.Error("Run() ran the hook of an untrusted stack")
+	}
+
+	// Trusting the catalog lets its hooks run
+	testutil.WriteFile(t, "inventory/catalogs.yaml", "catalogs:\n  community:\n    source: https://example.com/stacks.git\n    trusted: true\n")
+	if err := Run("immich", "post-deploy", []string{"touch hook.out"}, nil); err != nil {
+		t.Fatalf("Run() error = %v for a trusted catalog", err)
+	}
+	if _, err := os.Stat(filepath.Join("stacks", "immich", "hook.out")); err != nil {
+		t.Errorf("hook of a trusted catalog did not run: %v", err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	vars := map[string]interface{}{
+		"domain":  "example.com",
+		"tls":     true,
+		"grafana": map[string]interface{}{"admin-user": "admin", "plugins": []interface{}{"a", "b"}},
+		"empty":   nil,
+	}
+
+	want := []string{
+		"HOMELAB_VAR_DOMAIN=example.com",
+		"HOMELAB_VAR_EMPTY=",
+		"HOMELAB_VAR_GRAFANA_ADMIN_USER=admin",
+		`HOMELAB_VAR_GRAFANA_PLUGINS=["a","b"]`,
+		"HOMELAB_VAR_TLS=true",
+	}
+	if got := Env(vars); !reflect.DeepEqual(got, want) {
+		t.Errorf("Env() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,48 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// BuildOptions are the build cache settings of inventory/builds.yaml, applied to
+// services built from source that don't set their own
+type BuildOptions struct {
+	CacheFrom []string `yaml:"cache_from"` // e.g. type=registry,ref=registry.lan/cache/{service}
+	CacheTo   []string `yaml:"cache_to"`
+}
+
+// LoadBuildOptions reads inventory/builds.yaml; a missing file means no cache settings
+func LoadBuildOptions() (*BuildOptions, error) {
+	var options BuildOptions
+
+	data, err := os.ReadFile(paths.InventoryBuilds)
+	if os.IsNotExist(err) {
+		return &options, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryBuilds, err)
+	}
+
+	if err := yaml.Unmarshal(data, &options); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryBuilds, err)
+	}
+	return &options, nil
+}
+
+// ForService returns the cache settings with {service} replaced by the service name
+func (o *BuildOptions) ForService(service string) (cacheFrom, cacheTo []string) {
+	expand := func(values []string) []string {
+		var expanded []string
+		for _, v := range values {
+			expanded = append(expanded, strings.ReplaceAll(v, "{service}", service))
+		}
+		return expanded
+	}
+	return expand(o.CacheFrom), expand(o.CacheTo)
+}
@@ -0,0 +1,41 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Capacity holds the guardrails of inventory/capacity.yaml, which validate checks the
+// generated services against; sizes are like 512MiB or 2GB
+// Exceeding one is a warning, not an enforced limit
+type Capacity struct {
+	MaxServices    int               `yaml:"max_services"`    // Services the host should run at most
+	MemoryHeadroom string            `yaml:"memory_headroom"` // Memory kept free for the host: a size or a percentage
+	HostMemory     string            `yaml:"host_memory"`     // Memory of the host (default: detected, on Linux)
+	ServiceMemory  string            `yaml:"service_memory"`  // Assumed for services without a memory limit
+	Categories     map[string]string `yaml:"categories"`      // Category -> memory assumed for its services without a limit
+}
+
+// LoadCapacity reads inventory/capacity.yaml; nil when there is none
+func LoadCapacity() (*Capacity, error) {
+	data, err := os.ReadFile(paths.InventoryCapacity)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryCapacity, err)
+	}
+
+	capacity := &Capacity{}
+	if err := yaml.Unmarshal(data, capacity); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryCapacity, err)
+	}
+	if capacity.MaxServices < 0 {
+		return nil, fmt.Errorf("invalid max_services in %s: %d", paths.InventoryCapacity, capacity.MaxServices)
+	}
+	return capacity, nil
+}
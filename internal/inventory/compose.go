@@ -0,0 +1,75 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// projectNamePattern is what docker compose accepts as a project name
+var projectNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ComposeSettings name the docker compose project in inventory/compose.yaml
+type ComposeSettings struct {
+	Project      string            `yaml:"project"`      // Project name (default: runtime, from the compose file's directory)
+	Environments map[string]string `yaml:"environments"` // Project name per environment (default: <project>-<env>)
+}
+
+// LoadComposeSettings reads inventory/compose.yaml; a missing file means the defaults
+func LoadComposeSettings() (*ComposeSettings, error) {
+	settings := &ComposeSettings{}
+
+	data, err := os.ReadFile(paths.InventoryCompose)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryCompose, err)
+	}
+
+	if err := yaml.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryCompose, err)
+	}
+
+	if settings.Project != "" {
+		if err := ValidateProjectName(settings.Project); err != nil {
+			return nil, fmt.Errorf("%s: %w", paths.InventoryCompose, err)
+		}
+	}
+	envs := make([]string, 0, len(settings.Environments))
+	for env := range settings.Environments {
+		envs = append(envs, env)
+	}
+	sort.Strings(envs)
+	for _, env := range envs {
+		if err := ValidateProjectName(settings.Environments[env]); err != nil {
+			return nil, fmt.Errorf("%s: environment %s: %w", paths.InventoryCompose, env, err)
+		}
+	}
+	return settings, nil
+}
+
+// ProjectName returns the project name the settings give an environment ("" for none),
+// or "" when they leave it to docker compose
+func (s *ComposeSettings) ProjectName(environment string) string {
+	if name, ok := s.Environments[environment]; ok && environment != "" {
+		return name
+	}
+	if s.Project != "" && environment != "" {
+		return s.Project + "-" + environment
+	}
+	return s.Project
+}
+
+// ValidateProjectName checks a name is a valid docker compose project name
+func ValidateProjectName(name string) error {
+	if !projectNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid project name '%s' (lowercase letters, digits, '-' and '_', starting with a letter or digit)", name)
+	}
+	return nil
+}
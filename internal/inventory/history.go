@@ -0,0 +1,36 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// HistorySettings is the retention policy of runtime/history/ in inventory/history.yaml
+// Ages are durations like 72h or 30d, sizes like 500MB or 1GiB
+type HistorySettings struct {
+	Keep    *int   `yaml:"keep"`     // Generations kept (default 50, 0 for no limit)
+	MaxAge  string `yaml:"max_age"`  // Generations older than this are removed
+	MaxSize string `yaml:"max_size"` // Oldest generations are removed while runtime/history/ is larger
+}
+
+// LoadHistorySettings reads inventory/history.yaml; a missing file means the defaults
+func LoadHistorySettings() (*HistorySettings, error) {
+	settings := &HistorySettings{}
+
+	data, err := os.ReadFile(paths.InventoryHistory)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryHistory, err)
+	}
+
+	if err := yaml.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryHistory, err)
+	}
+	return settings, nil
+}
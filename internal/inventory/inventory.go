@@ -3,138 +3,161 @@ package inventory
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
 	"github.com/monkeymonk/homelabctl/internal/paths"
 )
 
-// LoadVars loads inventory/vars.yaml
+// LoadVars loads inventory/vars.yaml, with the overrides of the selected environment
+// (inventory/environments/<env>/vars.yaml) merged on top
 func LoadVars() (map[string]interface{}, error) {
-	data, err := os.ReadFile(paths.InventoryVars)
+	vars, err := LoadBaseVars()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read inventory/vars.yaml: %w", err)
+		return nil, err
 	}
 
-	var vars map[string]interface{}
-	if err := yaml.Unmarshal(data, &vars); err != nil {
-		return nil, fmt.Errorf("failed to parse inventory/vars.yaml: %w", err)
+	env := Environment()
+	if env == "" {
+		return vars, nil
 	}
 
-	if vars == nil {
-		vars = make(map[string]interface{})
+	data, err := os.ReadFile(paths.EnvironmentVars(env))
+	if os.IsNotExist(err) {
+		available, _ := Environments()
+		suggestion := "Create " + paths.EnvironmentVars(env)
+		if len(available) > 0 {
+			suggestion = "Available environments: " + strings.Join(available, ", ")
+		}
+		return nil, errors.New(fmt.Sprintf("unknown environment '%s'", env), suggestion).WithClass(errors.ClassNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.EnvironmentVars(env), err)
 	}
 
-	return vars, nil
+	var overrides map[string]interface{}
+	if err := yaml.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.EnvironmentVars(env), err)
+	}
+
+	return mergeVars(vars, overrides), nil
 }
 
-// State represents the tool-managed state
-type State struct {
-	DisabledServices []string `yaml:"disabled_services"`
+// Environment returns the environment selected with --env (HOMELAB_ENV), "" for none
+func Environment() string {
+	return os.Getenv("HOMELAB_ENV")
 }
 
-// LoadState loads inventory/state.yaml
-func LoadState() (*State, error) {
-	data, err := os.ReadFile(paths.InventoryState)
-	if err != nil {
-		// If state file doesn't exist, create it
-		if os.IsNotExist(err) {
-			state := &State{DisabledServices: []string{}}
-			if err := writeState(state); err != nil {
-				return nil, err
-			}
-			return state, nil
+// ComposeProfiles returns the docker compose profiles active for this run, from
+// COMPOSE_PROFILES (comma-separated), as docker compose reads them
+func ComposeProfiles() []string {
+	var profiles []string
+	for _, profile := range strings.Split(os.Getenv("COMPOSE_PROFILES"), ",") {
+		if profile = strings.TrimSpace(profile); profile != "" {
+			profiles = append(profiles, profile)
 		}
-		return nil, fmt.Errorf("failed to read inventory/state.yaml: %w", err)
 	}
+	return profiles
+}
 
-	var state State
-	if err := yaml.Unmarshal(data, &state); err != nil {
-		return nil, fmt.Errorf("failed to parse inventory/state.yaml: %w", err)
+// Environments returns the names of the directories of inventory/environments/, sorted
+func Environments() ([]string, error) {
+	entries, err := os.ReadDir(paths.InventoryEnvs)
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
-
-	if state.DisabledServices == nil {
-		state.DisabledServices = []string{}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryEnvs, err)
 	}
 
-	return &state, nil
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
-// writeState writes the state to inventory/state.yaml
-func writeState(state *State) error {
-	data, err := yaml.Marshal(state)
-	if err != nil {
-		return fmt.Errorf("failed to marshal state: %w", err)
+// mergeVars returns base with overrides applied: maps are merged key by key, any other
+// value (lists included) replaces the base one
+func mergeVars(base, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base))
+	for k, v := range base {
+		merged[k] = v
 	}
 
-	// Use secure permissions (0600) for state file as it may contain sensitive service info
-	if err := os.WriteFile(paths.InventoryState, data, paths.SecureFilePermissions); err != nil {
-		return fmt.Errorf("failed to write inventory/state.yaml: %w", err)
+	for k, v := range overrides {
+		baseMap, baseOK := merged[k].(map[string]interface{})
+		overrideMap, overrideOK := v.(map[string]interface{})
+		if baseOK && overrideOK {
+			merged[k] = mergeVars(baseMap, overrideMap)
+			continue
+		}
+		merged[k] = v
 	}
 
-	return nil
+	return merged
 }
 
-// GetDisabledServices returns the list of disabled services from state
-func GetDisabledServices() ([]string, error) {
-	state, err := LoadState()
+// LoadBaseVars loads inventory/vars.yaml alone, whatever the environment
+func LoadBaseVars() (map[string]interface{}, error) {
+	data, err := os.ReadFile(paths.InventoryVars)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to read inventory/vars.yaml: %w", err)
 	}
-	return state.DisabledServices, nil
-}
 
-// DisableService adds a service to the disabled_services list in state
-func DisableService(serviceName string) error {
-	state, err := LoadState()
-	if err != nil {
-		return err
+	var vars map[string]interface{}
+	if err := yaml.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory/vars.yaml: %w", err)
 	}
 
-	// Check if already disabled
-	for _, s := range state.DisabledServices {
-		if s == serviceName {
-			return fmt.Errorf("service '%s' is already disabled", serviceName)
-		}
+	if vars == nil {
+		vars = make(map[string]interface{})
 	}
 
-	// Add the service
-	state.DisabledServices = append(state.DisabledServices, serviceName)
+	return vars, nil
+}
 
-	return writeState(state)
+// RenderEngine returns the template engine set with render.engine in inventory/vars.yaml (empty when unset)
+func RenderEngine(vars map[string]interface{}) string {
+	section, _ := vars["render"].(map[string]interface{})
+	engine, _ := section["engine"].(string)
+	return engine
 }
 
-// EnableService removes a service from the disabled_services list in state
-func EnableService(serviceName string) error {
-	state, err := LoadState()
-	if err != nil {
-		return err
-	}
+// PersistenceRoot returns persistence.root of inventory/vars.yaml, the directory the
+// persistence paths stacks set permissions on must be under (empty when unset)
+func PersistenceRoot(vars map[string]interface{}) string {
+	section, _ := vars["persistence"].(map[string]interface{})
+	root, _ := section["root"].(string)
+	return root
+}
 
-	// Find and remove the service
-	found := false
-	newList := make([]string, 0, len(state.DisabledServices))
-	for _, s := range state.DisabledServices {
-		if s == serviceName {
-			found = true
-			continue
-		}
-		newList = append(newList, s)
-	}
+// CombinedContributions returns the providers listed in contributions.combine of
+// inventory/vars.yaml, whose YAML contributions are also merged into a single file
+func CombinedContributions(vars map[string]interface{}) []string {
+	section, _ := vars["contributions"].(map[string]interface{})
+	list, _ := section["combine"].([]interface{})
 
-	if !found {
-		return fmt.Errorf("service '%s' is not disabled", serviceName)
+	var providers []string
+	for _, item := range list {
+		if provider, ok := item.(string); ok && provider != "" {
+			providers = append(providers, provider)
+		}
 	}
-
-	state.DisabledServices = newList
-
-	return writeState(state)
+	return providers
 }
 
 // MigrateDisabledServices moves disabled_services from vars.yaml to state.yaml (one-time migration)
 func MigrateDisabledServices() error {
 	// Load vars
-	vars, err := LoadVars()
+	vars, err := LoadBaseVars()
 	if err != nil {
 		return err
 	}
@@ -151,27 +174,17 @@ func MigrateDisabledServices() error {
 		return err
 	}
 
-	// Convert and migrate
+	// Convert to the flat legacy list, then scope each service by stack
 	if disabledList, ok := disabled.([]interface{}); ok {
 		for _, item := range disabledList {
 			if s, ok := item.(string); ok {
-				// Avoid duplicates
-				found := false
-				for _, existing := range state.DisabledServices {
-					if existing == s {
-						found = true
-						break
-					}
-				}
-				if !found {
-					state.DisabledServices = append(state.DisabledServices, s)
-				}
+				state.LegacyDisabledServices = append(state.LegacyDisabledServices, s)
 			}
 		}
 	}
 
-	// Write state
-	if err := writeState(state); err != nil {
+	// Migrate and write state
+	if err := migrateState(state); err != nil {
 		return err
 	}
 
@@ -180,3 +193,8 @@ func MigrateDisabledServices() error {
 
 	return nil
 }
+
+// SetVar sets the value at a dotted path in inventory/vars.yaml, keeping comments and ordering
+func SetVar(path string, value interface{}) error {
+	return fs.SetYAMLValue(paths.InventoryVars, path, value, paths.FilePermissions)
+}
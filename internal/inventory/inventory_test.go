@@ -0,0 +1,179 @@
+package inventory
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestSetVar(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	testutil.WriteFile(t, paths.InventoryVars, `# Homelab settings
+domain: home.lan # base domain
+
+grafana:
+  image: grafana/grafana:10.0.0
+`)
+
+	if err := SetVar("grafana.admin_password", "s3cret"); err != nil {
+		t.Fatalf("SetVar() error = %v", err)
+	}
+	if err := SetVar("timezone", "Europe/Brussels"); err != nil {
+		t.Fatalf("SetVar() error = %v", err)
+	}
+	if err := SetVar("grafana.image", "grafana/grafana:11.0.0"); err != nil {
+		t.Fatalf("SetVar() error = %v", err)
+	}
+
+	data, err := os.ReadFile(paths.InventoryVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+
+	for _, want := range []string{
+		"# Homelab settings",
+		"domain: home.lan # base domain",
+		"  admin_password: s3cret",
+		"  image: grafana/grafana:11.0.0",
+		"timezone: Europe/Brussels",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("vars.yaml missing %q\n%s", want, out)
+		}
+	}
+
+	vars, err := LoadVars()
+	if err != nil {
+		t.Fatalf("LoadVars() error = %v", err)
+	}
+	if grafana := vars["grafana"].(map[string]interface{}); grafana["admin_password"] != "s3cret" {
+		t.Errorf("grafana = %v", grafana)
+	}
+
+	// domain is a scalar, it can't hold nested values
+	if err := SetVar("domain.name", "x"); err == nil {
+		t.Error("SetVar() should fail when crossing a scalar")
+	}
+}
+
+func TestLoadVars_Environment(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	testutil.WriteFile(t, paths.InventoryVars, "domain: home.lan\ngrafana:\n  image: grafana/grafana:11.0.0\n  port: 3000\nnetworks: [proxy, monitoring]\n")
+	testutil.WriteFile(t, paths.EnvironmentVars("staging"), "domain: staging.home.lan\ngrafana:\n  image: grafana/grafana:11.2.0\nnetworks: [proxy]\n")
+
+	t.Setenv("HOMELAB_ENV", "")
+	vars, err := LoadVars()
+	if err != nil {
+		t.Fatalf("LoadVars() error = %v", err)
+	}
+	if vars["domain"] != "home.lan" {
+		t.Errorf("domain = %v without an environment, want home.lan", vars["domain"])
+	}
+
+	t.Setenv("HOMELAB_ENV", "staging")
+	vars, err = LoadVars()
+	if err != nil {
+		t.Fatalf("LoadVars() error = %v", err)
+	}
+	grafana := vars["grafana"].(map[string]interface{})
+	if vars["domain"] != "staging.home.lan" || grafana["image"] != "grafana/grafana:11.2.0" || grafana["port"] != 3000 {
+		t.Errorf("vars = %v, want staging overrides merged into the base", vars)
+	}
+	if networks := vars["networks"].([]interface{}); len(networks) != 1 {
+		t.Errorf("networks = %v, want the list replaced", networks)
+	}
+
+	// The base file is left alone
+	base, err := LoadBaseVars()
+	if err != nil {
+		t.Fatalf("LoadBaseVars() error = %v", err)
+	}
+	if base["domain"] != "home.lan" {
+		t.Errorf("LoadBaseVars() domain = %v, want home.lan", base["domain"])
+	}
+
+	t.Setenv("HOMELAB_ENV", "prod")
+	if _, err := LoadVars(); err == nil || !strings.Contains(err.Error(), "unknown environment") {
+		t.Errorf("LoadVars() error = %v, want unknown environment", err)
+	}
+	if envs, _ := Environments(); len(envs) != 1 || envs[0] != "staging" {
+		t.Errorf("Environments() = %v, want [staging]", envs)
+	}
+}
+
+func TestLoadPlugins(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	plugins, err := LoadPlugins()
+	if err != nil || len(plugins) != 0 {
+		t.Fatalf("LoadPlugins() without a file = %v, %v; want none", plugins, err)
+	}
+
+	testutil.WriteFile(t, paths.InventoryPlugins, `plugins:
+  vault:
+    after: merge-variables
+    plan: true
+  notify:
+    command: /usr/local/bin/notify-deploy
+    args: [--channel, ops]
+    after: record-history
+`)
+	plugins, err = LoadPlugins()
+	if err != nil {
+		t.Fatalf("LoadPlugins() error = %v", err)
+	}
+	if len(plugins) != 2 || plugins[0].Name != "notify" || plugins[1].Name != "vault" {
+		t.Fatalf("LoadPlugins() = %v, want notify and vault in name order", plugins)
+	}
+	if plugins[1].Command != "plugins/vault" || !plugins[1].Plan {
+		t.Errorf("vault = %+v, want the default executable and plan", plugins[1])
+	}
+	if plugins[0].Command != "/usr/local/bin/notify-deploy" || len(plugins[0].Args) != 2 {
+		t.Errorf("notify = %+v, want its command and args", plugins[0])
+	}
+
+	testutil.WriteFile(t, paths.InventoryPlugins, "plugins:\n  vault: {before: render-templates, after: merge-variables}\n")
+	if _, err := LoadPlugins(); err == nil || !strings.Contains(err.Error(), "exactly one of before or after") {
+		t.Errorf("LoadPlugins() error = %v, want before and after rejected together", err)
+	}
+}
+
+func TestLoadComposeSettings(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	settings, err := LoadComposeSettings()
+	if err != nil {
+		t.Fatalf("LoadComposeSettings() error = %v", err)
+	}
+	if name := settings.ProjectName("staging"); name != "" {
+		t.Errorf("ProjectName() = %q without inventory/compose.yaml, want \"\"", name)
+	}
+
+	testutil.WriteFile(t, paths.InventoryCompose, "project: homelab\nenvironments:\n  prod: homelab\n")
+	settings, err = LoadComposeSettings()
+	if err != nil {
+		t.Fatalf("LoadComposeSettings() error = %v", err)
+	}
+	for env, want := range map[string]string{"": "homelab", "staging": "homelab-staging", "prod": "homelab"} {
+		if name := settings.ProjectName(env); name != want {
+			t.Errorf("ProjectName(%q) = %q, want %q", env, name, want)
+		}
+	}
+
+	for _, content := range []string{"project: Homelab\n", "environments:\n  staging: -staging\n"} {
+		testutil.WriteFile(t, paths.InventoryCompose, content)
+		if _, err := LoadComposeSettings(); err == nil || !strings.Contains(err.Error(), "invalid project name") {
+			t.Errorf("LoadComposeSettings(%q) error = %v, want invalid project name", content, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Plugin is an executable from inventory/plugins.yaml that generate runs as an extra
+// pipeline stage, before or after a named stage
+type Plugin struct {
+	Name    string   `yaml:"-"`
+	Command string   `yaml:"command"` // Executable (default plugins/<name>)
+	Args    []string `yaml:"args"`    // Passed to the executable
+	Before  string   `yaml:"before"`  // Stage (or plugin) it runs before
+	After   string   `yaml:"after"`   // Stage (or plugin) it runs after
+	Plan    bool     `yaml:"plan"`    // Also run by plan and deploy --dry-run
+}
+
+// pluginsFile is the layout of inventory/plugins.yaml
+type pluginsFile struct {
+	Plugins map[string]*Plugin `yaml:"plugins"`
+}
+
+// LoadPlugins reads inventory/plugins.yaml, sorted by name; a missing file means none
+func LoadPlugins() ([]*Plugin, error) {
+	data, err := os.ReadFile(paths.InventoryPlugins)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryPlugins, err)
+	}
+
+	var file pluginsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryPlugins, err)
+	}
+
+	plugins := make([]*Plugin, 0, len(file.Plugins))
+	for name, plugin := range file.Plugins {
+		if plugin == nil {
+			plugin = &Plugin{}
+		}
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("invalid plugin name %q in %s", name, paths.InventoryPlugins)
+		}
+		if (plugin.Before == "") == (plugin.After == "") {
+			return nil, fmt.Errorf("plugin %s in %s needs exactly one of before or after (a stage name)", name, paths.InventoryPlugins)
+		}
+		plugin.Name = name
+		if plugin.Command == "" {
+			plugin.Command = paths.PluginExecutable(name)
+		}
+		plugins = append(plugins, plugin)
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+	return plugins, nil
+}
@@ -0,0 +1,81 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Policy names, as listed in exemptions
+const (
+	PolicyPrivileged  = "privileged"
+	PolicyPublicPorts = "public_ports"
+	PolicyRegistries  = "registries"
+)
+
+// policyNames lists the policies a stack can be exempted from
+var policyNames = []string{PolicyPrivileged, PolicyPublicPorts, PolicyRegistries}
+
+// Policies are the deployment constraints of inventory/policies.yaml, which generate and
+// validate enforce on the generated compose file
+type Policies struct {
+	ForbidPrivileged  bool     `yaml:"forbid_privileged"`   // No privileged: true
+	ForbidPublicPorts []string `yaml:"forbid_public_ports"` // Host ports or ranges that may not bind every interface
+	AllowedRegistries []string `yaml:"allowed_registries"`  // Registries (or registry/path prefixes) images come from
+
+	// Exemptions maps a stack to the policies its services are exempt from
+	Exemptions map[string][]string `yaml:"exemptions"`
+}
+
+// LoadPolicies reads inventory/policies.yaml; a missing file means no constraints
+func LoadPolicies() (*Policies, error) {
+	policies := &Policies{}
+
+	data, err := os.ReadFile(paths.InventoryPolicies)
+	if os.IsNotExist(err) {
+		return policies, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryPolicies, err)
+	}
+
+	if err := yaml.Unmarshal(data, policies); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryPolicies, err)
+	}
+
+	stackNames := make([]string, 0, len(policies.Exemptions))
+	for stack := range policies.Exemptions {
+		stackNames = append(stackNames, stack)
+	}
+	sort.Strings(stackNames)
+	for _, stack := range stackNames {
+		for _, policy := range policies.Exemptions[stack] {
+			if !contains(policyNames, policy) {
+				return nil, fmt.Errorf("unknown policy %q in the exemptions of %s in %s (available: %s)",
+					policy, stack, paths.InventoryPolicies, strings.Join(policyNames, ", "))
+			}
+		}
+	}
+
+	return policies, nil
+}
+
+// Exempt reports whether a stack is exempt from a policy
+func (p *Policies) Exempt(stack, policy string) bool {
+	return contains(p.Exemptions[stack], policy)
+}
+
+// contains reports whether a list holds a value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
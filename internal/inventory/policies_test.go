@@ -0,0 +1,44 @@
+package inventory
+
+import (
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestLoadPolicies(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	// No file: no constraints
+	policies, err := LoadPolicies()
+	if err != nil {
+		t.Fatalf("LoadPolicies() error = %v", err)
+	}
+	if policies.ForbidPrivileged || len(policies.ForbidPublicPorts) > 0 || len(policies.AllowedRegistries) > 0 {
+		t.Errorf("LoadPolicies() = %+v, want no constraints", policies)
+	}
+
+	testutil.WriteFile(t, paths.InventoryPolicies, `forbid_privileged: true
+forbid_public_ports: [5432, "6000-6010"]
+allowed_registries: [docker.io, ghcr.io/linuxserver]
+exemptions:
+  vpn: [privileged]
+`)
+	policies, err = LoadPolicies()
+	if err != nil {
+		t.Fatalf("LoadPolicies() error = %v", err)
+	}
+	if len(policies.ForbidPublicPorts) != 2 || policies.ForbidPublicPorts[0] != "5432" {
+		t.Errorf("ForbidPublicPorts = %v, want [5432 6000-6010]", policies.ForbidPublicPorts)
+	}
+	if !policies.Exempt("vpn", PolicyPrivileged) || policies.Exempt("vpn", PolicyRegistries) || policies.Exempt("app", PolicyPrivileged) {
+		t.Errorf("unexpected exemptions: %v", policies.Exemptions)
+	}
+
+	testutil.WriteFile(t, paths.InventoryPolicies, "exemptions:\n  vpn: [root]\n")
+	if _, err := LoadPolicies(); err == nil {
+		t.Error("LoadPolicies() should reject an unknown policy")
+	}
+}
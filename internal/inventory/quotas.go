@@ -0,0 +1,35 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Quotas are the disk usage thresholds of inventory/quotas.yaml, as sizes like 50GB or 1.5TiB
+// Exceeding one is a warning of homelabctl du, not an enforced limit
+type Quotas struct {
+	Stacks     map[string]string `yaml:"stacks"`
+	Categories map[string]string `yaml:"categories"`
+}
+
+// LoadQuotas reads inventory/quotas.yaml; a missing file means no thresholds
+func LoadQuotas() (*Quotas, error) {
+	quotas := &Quotas{}
+
+	data, err := os.ReadFile(paths.InventoryQuotas)
+	if os.IsNotExist(err) {
+		return quotas, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryQuotas, err)
+	}
+
+	if err := yaml.Unmarshal(data, quotas); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryQuotas, err)
+	}
+	return quotas, nil
+}
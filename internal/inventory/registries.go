@@ -0,0 +1,89 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Registries is the layout of inventory/registries.yaml
+type Registries struct {
+	// Mirrors maps a registry (docker.io, ghcr.io) to the prefix its images are pulled through,
+	// e.g. a pull-through cache: docker.io: registry.lan/dockerhub
+	Mirrors map[string]string `yaml:"mirrors"`
+
+	// Credentials maps a registry host to the account used to pull from it
+	Credentials map[string]RegistryCredential `yaml:"credentials"`
+}
+
+// RegistryCredential is a registry account; the password is never stored in the inventory
+type RegistryCredential struct {
+	Username     string `yaml:"username"`
+	PasswordEnv  string `yaml:"password_env"`  // Environment variable holding the password
+	PasswordFile string `yaml:"password_file"` // File holding the password, e.g. a secret mount
+}
+
+// LoadRegistries reads inventory/registries.yaml; a missing file means images are pulled as written
+func LoadRegistries() (*Registries, error) {
+	registries := &Registries{}
+
+	data, err := os.ReadFile(paths.InventoryRegistry)
+	if os.IsNotExist(err) {
+		return registries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryRegistry, err)
+	}
+
+	if err := yaml.Unmarshal(data, registries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryRegistry, err)
+	}
+
+	for registry, credential := range registries.Credentials {
+		if credential.Username == "" || (credential.PasswordEnv == "") == (credential.PasswordFile == "") {
+			return nil, fmt.Errorf("credentials for %s in %s need a username and one of password_env or password_file",
+				registry, paths.InventoryRegistry)
+		}
+	}
+
+	return registries, nil
+}
+
+// Rewrite returns the image pulled through its registry's mirror, if one is configured
+func (r *Registries) Rewrite(image string) (string, bool) {
+	registry, path := compose.ImageRegistry(image)
+	prefix, ok := r.Mirrors[registry]
+	if !ok || prefix == "" {
+		return image, false
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + path, true
+}
+
+// Credential returns the credential for the registry an image is pulled from
+func (r *Registries) Credential(image string) (string, RegistryCredential, bool) {
+	registry, _ := compose.ImageRegistry(image)
+	credential, ok := r.Credentials[registry]
+	return registry, credential, ok
+}
+
+// Password reads the credential's password from its environment variable or file
+func (c RegistryCredential) Password() (string, error) {
+	if c.PasswordEnv != "" {
+		password := os.Getenv(c.PasswordEnv)
+		if password == "" {
+			return "", fmt.Errorf("environment variable %s is not set", c.PasswordEnv)
+		}
+		return password, nil
+	}
+
+	data, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
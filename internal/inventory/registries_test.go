@@ -0,0 +1,66 @@
+package inventory
+
+import (
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestLoadRegistries(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	// No file: images are pulled as written
+	registries, err := LoadRegistries()
+	if err != nil {
+		t.Fatalf("LoadRegistries() error = %v", err)
+	}
+	if image, ok := registries.Rewrite("nginx"); ok || image != "nginx" {
+		t.Errorf("Rewrite() = %s, %v; want unchanged", image, ok)
+	}
+
+	testutil.WriteFile(t, paths.InventoryRegistry, `mirrors:
+  docker.io: registry.lan/dockerhub/
+credentials:
+  registry.lan:
+    username: homelab
+    password_env: TEST_REGISTRY_PASSWORD
+`)
+
+	registries, err = LoadRegistries()
+	if err != nil {
+		t.Fatalf("LoadRegistries() error = %v", err)
+	}
+
+	tests := map[string]string{
+		"nginx:1.27":                "registry.lan/dockerhub/library/nginx:1.27",
+		"grafana/grafana":           "registry.lan/dockerhub/grafana/grafana",
+		"docker.io/library/redis:7": "registry.lan/dockerhub/library/redis:7",
+		"ghcr.io/home/app:1":        "ghcr.io/home/app:1",
+		"registry.lan/team/app":     "registry.lan/team/app",
+	}
+	for image, want := range tests {
+		if got, _ := registries.Rewrite(image); got != want {
+			t.Errorf("Rewrite(%s) = %s, want %s", image, got, want)
+		}
+	}
+
+	registry, credential, ok := registries.Credential("registry.lan/dockerhub/library/nginx")
+	if !ok || registry != "registry.lan" || credential.Username != "homelab" {
+		t.Fatalf("Credential() = %s, %+v, %v", registry, credential, ok)
+	}
+	if _, err := credential.Password(); err == nil {
+		t.Error("Password() should fail while the variable is unset")
+	}
+	t.Setenv("TEST_REGISTRY_PASSWORD", "secret")
+	if password, err := credential.Password(); err != nil || password != "secret" {
+		t.Errorf("Password() = %q, %v", password, err)
+	}
+
+	// A credential needs a username and exactly one password source
+	testutil.WriteFile(t, paths.InventoryRegistry, "credentials:\n  ghcr.io:\n    username: me\n")
+	if _, err := LoadRegistries(); err == nil {
+		t.Error("LoadRegistries() should reject a credential without password source")
+	}
+}
@@ -0,0 +1,56 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// How generate renders secrets into runtime/docker-compose.yml
+const (
+	SecretsRenderInline  = "inline"  // Values are written where templates use them (default)
+	SecretsRenderCompose = "compose" // Environment values from secrets become compose secrets, mounted as files
+)
+
+// SecretsSettings configure secrets in inventory/secrets.yaml: how they are rendered and
+// the encryption of secrets/<stack>.age
+type SecretsSettings struct {
+	Render string      `yaml:"render"` // inline or compose
+	Age    AgeSettings `yaml:"age"`
+}
+
+// AgeSettings are the keys of age-encrypted secrets
+type AgeSettings struct {
+	Recipients []string `yaml:"recipients"` // Public keys files are encrypted to (default: those of the identity file)
+	Identity   string   `yaml:"identity"`   // Private key file (default: SOPS_AGE_KEY_FILE, then the sops location)
+}
+
+// LoadSecretsSettings reads inventory/secrets.yaml; a missing file means the defaults
+func LoadSecretsSettings() (*SecretsSettings, error) {
+	settings := &SecretsSettings{Render: SecretsRenderInline}
+
+	data, err := os.ReadFile(paths.InventorySecrets)
+	if os.IsNotExist(err) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventorySecrets, err)
+	}
+
+	if err := yaml.Unmarshal(data, settings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventorySecrets, err)
+	}
+
+	switch settings.Render {
+	case "":
+		settings.Render = SecretsRenderInline
+	case SecretsRenderInline, SecretsRenderCompose:
+	default:
+		return nil, fmt.Errorf("%s: invalid render '%s' (available: %s, %s)",
+			paths.InventorySecrets, settings.Render, SecretsRenderInline, SecretsRenderCompose)
+	}
+	return settings, nil
+}
@@ -0,0 +1,410 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// StateVersion is the current inventory/state.yaml format
+// Version 1 (no version field) had a flat disabled_services list
+const StateVersion = 2
+
+// State represents the tool-managed state
+type State struct {
+	Version int                    `yaml:"version"`
+	Stacks  map[string]*StackState `yaml:"stacks"`
+
+	// LastDeploy is when deploy last completed, as recorded before runtime/.last-deploy
+	LastDeploy *time.Time `yaml:"last_deploy,omitempty"`
+
+	// LegacyDisabledServices is the version 1 flat list, migrated on load
+	LegacyDisabledServices []string `yaml:"disabled_services,omitempty"`
+}
+
+// StackState is the state tracked for a single stack
+type StackState struct {
+	EnabledAt        *time.Time `yaml:"enabled_at,omitempty"`
+	DisabledServices []string   `yaml:"disabled_services,omitempty"`
+}
+
+// QualifiedName returns the stack-scoped name of a service (stack/service)
+func QualifiedName(stackName, serviceName string) string {
+	return stackName + "/" + serviceName
+}
+
+// SplitQualifiedName splits stack/service; stack is empty for a bare service name
+func SplitQualifiedName(name string) (string, string) {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// LoadState loads inventory/state.yaml, migrating older formats
+func LoadState() (*State, error) {
+	data, err := os.ReadFile(paths.InventoryState)
+	if err != nil {
+		// If state file doesn't exist, create it
+		if os.IsNotExist(err) {
+			state := newState()
+			if err := writeState(state); err != nil {
+				return nil, err
+			}
+			return state, nil
+		}
+		return nil, fmt.Errorf("failed to read inventory/state.yaml: %w", err)
+	}
+
+	var state State
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory/state.yaml: %w", err)
+	}
+
+	if state.Stacks == nil {
+		state.Stacks = make(map[string]*StackState)
+	}
+
+	if state.Version < StateVersion {
+		if err := migrateState(&state); err != nil {
+			return nil, err
+		}
+	}
+
+	return &state, nil
+}
+
+// newState returns an empty state in the current format
+func newState() *State {
+	return &State{
+		Version: StateVersion,
+		Stacks:  make(map[string]*StackState),
+	}
+}
+
+// migrateState upgrades a version 1 state (flat disabled_services) and saves it
+// Each service is scoped to every stack that defines it, which is what the flat list disabled
+func migrateState(state *State) error {
+	legacy := state.LegacyDisabledServices
+	state.LegacyDisabledServices = nil
+	state.Version = StateVersion
+
+	if len(legacy) > 0 {
+		owners, err := serviceOwners()
+		if err != nil {
+			return err
+		}
+
+		for _, serviceName := range legacy {
+			stackNames := owners[serviceName]
+			if len(stackNames) == 0 {
+				fmt.Fprintf(os.Stderr, "Dropped disabled service '%s' from state: no stack defines it\n", serviceName)
+				continue
+			}
+			for _, stackName := range stackNames {
+				state.addDisabled(stackName, serviceName)
+			}
+		}
+	}
+
+	if err := writeState(state); err != nil {
+		return err
+	}
+
+	if len(legacy) > 0 {
+		fmt.Fprintf(os.Stderr, "Migrated inventory/state.yaml to version %d (disabled services scoped by stack)\n", StateVersion)
+	}
+
+	return nil
+}
+
+// serviceOwners maps each service name to the available stacks defining it
+func serviceOwners() (map[string][]string, error) {
+	available, err := fs.GetAvailableStacks()
+	if err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string][]string)
+	for _, stackName := range available {
+		services, err := stacks.GetServiceNames(stackName)
+		if err != nil {
+			// Skip broken stacks; validate reports them
+			continue
+		}
+		for _, svc := range services {
+			owners[svc] = append(owners[svc], stackName)
+		}
+	}
+
+	return owners, nil
+}
+
+// stack returns the state of a stack, creating it if needed
+func (s *State) stack(stackName string) *StackState {
+	st, ok := s.Stacks[stackName]
+	if !ok {
+		st = &StackState{}
+		s.Stacks[stackName] = st
+	}
+	return st
+}
+
+// addDisabled adds a service to a stack's disabled list, reporting false if already there
+func (s *State) addDisabled(stackName, serviceName string) bool {
+	st := s.stack(stackName)
+	for _, existing := range st.DisabledServices {
+		if existing == serviceName {
+			return false
+		}
+	}
+	st.DisabledServices = append(st.DisabledServices, serviceName)
+	sort.Strings(st.DisabledServices)
+	return true
+}
+
+// IsServiceDisabled reports whether a stack's service is disabled
+func (s *State) IsServiceDisabled(stackName, serviceName string) bool {
+	st, ok := s.Stacks[stackName]
+	if !ok {
+		return false
+	}
+	for _, svc := range st.DisabledServices {
+		if svc == serviceName {
+			return true
+		}
+	}
+	return false
+}
+
+// prune drops stacks with nothing left to track
+func (s *State) prune() {
+	for name, st := range s.Stacks {
+		if st.EnabledAt == nil && len(st.DisabledServices) == 0 {
+			delete(s.Stacks, name)
+		}
+	}
+}
+
+// writeState writes the state to inventory/state.yaml
+func writeState(state *State) error {
+	state.prune()
+
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	// Use secure permissions (0600) for state file as it may contain sensitive service info
+	if err := os.WriteFile(paths.InventoryState, data, paths.SecureFilePermissions); err != nil {
+		return fmt.Errorf("failed to write inventory/state.yaml: %w", err)
+	}
+
+	return nil
+}
+
+// GetDisabledServices returns the disabled services as qualified names (stack/service), sorted
+func GetDisabledServices() ([]string, error) {
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+
+	var disabled []string
+	for stackName, st := range state.Stacks {
+		for _, svc := range st.DisabledServices {
+			disabled = append(disabled, QualifiedName(stackName, svc))
+		}
+	}
+	sort.Strings(disabled)
+
+	return disabled, nil
+}
+
+// DisableService adds a service to its stack's disabled list in state
+func DisableService(stackName, serviceName string) error {
+	state, err := LoadState()
+	if err != nil {
+		return err
+	}
+
+	if !state.addDisabled(stackName, serviceName) {
+		return fmt.Errorf("service '%s' is already disabled", QualifiedName(stackName, serviceName))
+	}
+
+	return writeState(state)
+}
+
+// EnableService removes a service from its stack's disabled list in state
+func EnableService(stackName, serviceName string) error {
+	state, err := LoadState()
+	if err != nil {
+		return err
+	}
+
+	if !state.IsServiceDisabled(stackName, serviceName) {
+		return fmt.Errorf("service '%s' is not disabled", QualifiedName(stackName, serviceName))
+	}
+
+	st := state.Stacks[stackName]
+	remaining := make([]string, 0, len(st.DisabledServices))
+	for _, svc := range st.DisabledServices {
+		if svc != serviceName {
+			remaining = append(remaining, svc)
+		}
+	}
+	st.DisabledServices = remaining
+
+	return writeState(state)
+}
+
+// MarkStackEnabled records when a stack was enabled
+func MarkStackEnabled(stackName string) error {
+	state, err := LoadState()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	state.stack(stackName).EnabledAt = &now
+
+	return writeState(state)
+}
+
+// MarkStackDisabled clears a stack's enable timestamp (its disabled services are kept)
+func MarkStackDisabled(stackName string) error {
+	state, err := LoadState()
+	if err != nil {
+		return err
+	}
+
+	if st, ok := state.Stacks[stackName]; ok {
+		st.EnabledAt = nil
+	}
+
+	return writeState(state)
+}
+
+// StackEnabledAt returns when a stack was enabled, if recorded
+func StackEnabledAt(stackName string) (time.Time, bool) {
+	state, err := LoadState()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	st, ok := state.Stacks[stackName]
+	if !ok || st.EnabledAt == nil {
+		return time.Time{}, false
+	}
+	return *st.EnabledAt, true
+}
+
+// MarkDeployed records that a deploy completed now, in runtime/ so a deploy leaves
+// the git-tracked inventory/ unchanged
+func MarkDeployed() error {
+	now := time.Now().UTC().Truncate(time.Second)
+	if err := os.MkdirAll(paths.Runtime, paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to record the deploy time: %w", err)
+	}
+	if err := os.WriteFile(paths.LastDeployFile, []byte(now.Format(time.RFC3339)+"\n"), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to record the deploy time: %w", err)
+	}
+	return nil
+}
+
+// LastDeployAt returns when deploy last completed, if recorded; repositories deployed
+// before runtime/.last-deploy existed have it in inventory/state.yaml
+func LastDeployAt() (time.Time, bool) {
+	if data, err := os.ReadFile(paths.LastDeployFile); err == nil {
+		at, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+		return at, err == nil
+	}
+
+	state, err := LoadState()
+	if err != nil || state.LastDeploy == nil {
+		return time.Time{}, false
+	}
+	return *state.LastDeploy, true
+}
+
+// StaleDisabledServices returns disabled services (stack/service) that no enabled stack defines
+// These are left behind when a stack is disabled or deleted, or a service is removed from it
+func StaleDisabledServices(enabledStacks []string) ([]string, error) {
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+
+	return state.staleDisabled(enabledStacks), nil
+}
+
+// PruneDisabledServices removes stale disabled services from state and returns them
+func PruneDisabledServices(enabledStacks []string) ([]string, error) {
+	state, err := LoadState()
+	if err != nil {
+		return nil, err
+	}
+
+	stale := state.staleDisabled(enabledStacks)
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	for _, name := range stale {
+		stackName, serviceName := SplitQualifiedName(name)
+		st := state.Stacks[stackName]
+		remaining := make([]string, 0, len(st.DisabledServices))
+		for _, svc := range st.DisabledServices {
+			if svc != serviceName {
+				remaining = append(remaining, svc)
+			}
+		}
+		st.DisabledServices = remaining
+	}
+
+	if err := writeState(state); err != nil {
+		return nil, err
+	}
+
+	return stale, nil
+}
+
+// staleDisabled lists disabled services not defined by any of the enabled stacks, sorted
+func (s *State) staleDisabled(enabledStacks []string) []string {
+	defined := make(map[string]bool)
+	for _, stackName := range enabledStacks {
+		services, err := stacks.GetServiceNames(stackName)
+		if err != nil {
+			// An unreadable stack keeps its entries; validate reports the stack itself
+			if st, ok := s.Stacks[stackName]; ok {
+				for _, svc := range st.DisabledServices {
+					defined[QualifiedName(stackName, svc)] = true
+				}
+			}
+			continue
+		}
+		for _, svc := range services {
+			defined[QualifiedName(stackName, svc)] = true
+		}
+	}
+
+	var stale []string
+	for stackName, st := range s.Stacks {
+		for _, svc := range st.DisabledServices {
+			if name := QualifiedName(stackName, svc); !defined[name] {
+				stale = append(stale, name)
+			}
+		}
+	}
+	sort.Strings(stale)
+
+	return stale
+}
@@ -0,0 +1,156 @@
+package inventory
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func setupStateTest(t *testing.T) func() {
+	t.Helper()
+
+	tmpDir, cleanup := testutil.TempDir(t)
+	restoreDir := testutil.Chdir(t, tmpDir)
+
+	testutil.CreateRepoStructure(t)
+	testutil.CreateStack(t, "monitoring", []string{}, []string{"grafana", "prometheus"})
+	testutil.CreateStack(t, "dashboards", []string{}, []string{"grafana"})
+
+	return func() {
+		restoreDir()
+		cleanup()
+	}
+}
+
+func TestLoadState_MigratesFlatList(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	testutil.WriteFile(t, paths.InventoryState, "disabled_services:\n  - grafana\n  - prometheus\n  - removed\n")
+
+	state, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+
+	if state.Version != StateVersion {
+		t.Errorf("Version = %d, want %d", state.Version, StateVersion)
+	}
+
+	// A service defined by several stacks is disabled in each, as the flat list did
+	if !state.IsServiceDisabled("monitoring", "grafana") || !state.IsServiceDisabled("dashboards", "grafana") {
+		t.Error("grafana should be disabled in both stacks defining it")
+	}
+	if !state.IsServiceDisabled("monitoring", "prometheus") {
+		t.Error("prometheus should be disabled in monitoring")
+	}
+
+	// The migrated state is written back in the new format
+	disabled, err := GetDisabledServices()
+	if err != nil {
+		t.Fatalf("GetDisabledServices() error = %v", err)
+	}
+	want := []string{"dashboards/grafana", "monitoring/grafana", "monitoring/prometheus"}
+	if !reflect.DeepEqual(disabled, want) {
+		t.Errorf("GetDisabledServices() = %v, want %v", disabled, want)
+	}
+}
+
+func TestDisableEnableService_Scoped(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	if err := DisableService("monitoring", "grafana"); err != nil {
+		t.Fatalf("DisableService() error = %v", err)
+	}
+	if err := DisableService("monitoring", "grafana"); err == nil {
+		t.Error("DisableService() should fail when already disabled")
+	}
+
+	// The same service name in another stack is unaffected
+	state, err := LoadState()
+	if err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	if state.IsServiceDisabled("dashboards", "grafana") {
+		t.Error("dashboards/grafana should not be disabled")
+	}
+
+	if err := EnableService("dashboards", "grafana"); err == nil {
+		t.Error("EnableService() should fail for a service that is not disabled")
+	}
+	if err := EnableService("monitoring", "grafana"); err != nil {
+		t.Errorf("EnableService() error = %v", err)
+	}
+}
+
+func TestMarkStackEnabled(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	if err := MarkStackEnabled("monitoring"); err != nil {
+		t.Fatalf("MarkStackEnabled() error = %v", err)
+	}
+	if _, ok := StackEnabledAt("monitoring"); !ok {
+		t.Error("StackEnabledAt() should return the recorded time")
+	}
+
+	if err := MarkStackDisabled("monitoring"); err != nil {
+		t.Fatalf("MarkStackDisabled() error = %v", err)
+	}
+	if _, ok := StackEnabledAt("monitoring"); ok {
+		t.Error("StackEnabledAt() should be cleared after disable")
+	}
+
+	// Nothing left to track, so the stack entry is dropped
+	data, err := os.ReadFile(paths.InventoryState)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	if string(data) != "version: 2\nstacks: {}\n" {
+		t.Errorf("state.yaml = %q", string(data))
+	}
+}
+
+func TestPruneDisabledServices(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	for _, name := range []string{"monitoring/grafana", "monitoring/removed", "dashboards/grafana"} {
+		stackName, serviceName := SplitQualifiedName(name)
+		if err := DisableService(stackName, serviceName); err != nil {
+			t.Fatalf("DisableService(%s) error = %v", name, err)
+		}
+	}
+
+	// dashboards is not enabled and monitoring no longer defines "removed"
+	enabled := []string{"monitoring"}
+	want := []string{"dashboards/grafana", "monitoring/removed"}
+
+	stale, err := StaleDisabledServices(enabled)
+	if err != nil {
+		t.Fatalf("StaleDisabledServices() error = %v", err)
+	}
+	if !reflect.DeepEqual(stale, want) {
+		t.Errorf("StaleDisabledServices() = %v, want %v", stale, want)
+	}
+
+	pruned, err := PruneDisabledServices(enabled)
+	if err != nil {
+		t.Fatalf("PruneDisabledServices() error = %v", err)
+	}
+	if !reflect.DeepEqual(pruned, want) {
+		t.Errorf("PruneDisabledServices() = %v, want %v", pruned, want)
+	}
+
+	disabled, err := GetDisabledServices()
+	if err != nil {
+		t.Fatalf("GetDisabledServices() error = %v", err)
+	}
+	if !reflect.DeepEqual(disabled, []string{"monitoring/grafana"}) {
+		t.Errorf("GetDisabledServices() after prune = %v", disabled)
+	}
+}
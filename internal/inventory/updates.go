@@ -0,0 +1,64 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// updatesFile is the layout of inventory/updates.yaml
+type updatesFile struct {
+	Categories map[string]categories.UpdatePolicy `yaml:"categories"`
+}
+
+// LoadUpdatePolicies reads the per-category update policies of inventory/updates.yaml
+// A missing file means the built-in category policies apply
+func LoadUpdatePolicies() (map[string]categories.UpdatePolicy, error) {
+	data, err := os.ReadFile(paths.InventoryUpdates)
+	if os.IsNotExist(err) {
+		return map[string]categories.UpdatePolicy{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryUpdates, err)
+	}
+
+	var file updatesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryUpdates, err)
+	}
+
+	for name, policy := range file.Categories {
+		if policy.Policy != "" && !categories.ValidUpdatePolicy(policy.Policy) {
+			return nil, fmt.Errorf("invalid update policy '%s' for category %s in %s (available: auto, notify, manual)",
+				policy.Policy, name, paths.InventoryUpdates)
+		}
+	}
+
+	if file.Categories == nil {
+		file.Categories = map[string]categories.UpdatePolicy{}
+	}
+	return file.Categories, nil
+}
+
+// UpdatePolicy returns a category's update policy: its built-in policy, with the
+// fields set in inventory/updates.yaml replacing the built-in ones ("any" clears the window)
+func UpdatePolicy(category string, overrides map[string]categories.UpdatePolicy) categories.UpdatePolicy {
+	policy := categories.GetOrDefault(category).Update
+	if override, ok := overrides[category]; ok {
+		if override.Policy != "" {
+			policy.Policy = override.Policy
+		}
+		switch override.Window {
+		case "":
+		case "any":
+			policy.Window = ""
+		default:
+			policy.Window = override.Window
+		}
+	}
+	return policy
+}
@@ -0,0 +1,53 @@
+package inventory
+
+import (
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestLoadUpdatePolicies(t *testing.T) {
+	cleanup := setupStateTest(t)
+	defer cleanup()
+
+	// No file: built-in policies
+	overrides, err := LoadUpdatePolicies()
+	if err != nil {
+		t.Fatalf("LoadUpdatePolicies() error = %v", err)
+	}
+	if got := UpdatePolicy("core", overrides).Policy; got != categories.UpdateManual {
+		t.Errorf("core policy = %s, want built-in manual", got)
+	}
+
+	testutil.WriteFile(t, paths.InventoryUpdates, `categories:
+  media:
+    window: 03:00-04:00
+  monitoring:
+    policy: auto
+    window: any
+  core:
+    policy: notify
+`)
+
+	overrides, err = LoadUpdatePolicies()
+	if err != nil {
+		t.Fatalf("LoadUpdatePolicies() error = %v", err)
+	}
+
+	if got := UpdatePolicy("media", overrides); got.Policy != categories.UpdateAuto || got.Window != "03:00-04:00" {
+		t.Errorf("media policy = %+v, want auto 03:00-04:00", got)
+	}
+	if got := UpdatePolicy("monitoring", overrides); got.Policy != categories.UpdateAuto || got.Window != "" {
+		t.Errorf("monitoring policy = %+v, want auto without window", got)
+	}
+	if got := UpdatePolicy("core", overrides).Policy; got != categories.UpdateNotify {
+		t.Errorf("core policy = %s, want notify", got)
+	}
+
+	testutil.WriteFile(t, paths.InventoryUpdates, "categories:\n  media:\n    policy: nightly\n")
+	if _, err := LoadUpdatePolicies(); err == nil {
+		t.Error("LoadUpdatePolicies() should reject an unknown policy")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package lock
+
+import "os"
+
+// tryLock always succeeds where flock is unavailable (no cross-process protection)
+func tryLock(file *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlock is a no-op where flock is unavailable
+func unlock(file *os.File) {}
@@ -0,0 +1,75 @@
+// Package lock prevents concurrent homelabctl runs in the same repository
+package lock
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+var (
+	mu    sync.Mutex
+	held  *os.File // Open lock file while this process holds the lock
+	depth int      // Nested Acquire calls (deploy runs generate)
+)
+
+// Acquire takes the repository lock (runtime/.lock) without waiting
+// Nested calls from the same process share the lock; each must call the returned release func
+func Acquire() (func(), error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if held == nil {
+		if err := os.MkdirAll(paths.Runtime, paths.DirPermissions); err != nil {
+			return nil, fmt.Errorf("failed to create runtime dir: %w", err)
+		}
+
+		file, err := os.OpenFile(paths.LockFile, os.O_CREATE|os.O_RDWR, paths.FilePermissions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", paths.LockFile, err)
+		}
+
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", paths.LockFile, err)
+		}
+		if !locked {
+			file.Close()
+			return nil, errors.New(
+				"another homelabctl is running in this repository",
+				"Wait for the other run (e.g. a scheduled deploy) to finish, then retry",
+				"The lock is released automatically when that process exits",
+			).WithContext(
+				fmt.Sprintf("Lock file: %s", paths.LockFile),
+			).WithClass(errors.ClassLocked).WithKind(errors.ErrLocked)
+		}
+
+		held = file
+	}
+
+	depth++
+	return release, nil
+}
+
+// release drops one nesting level and unlocks once the outermost caller is done
+func release() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if held == nil {
+		return
+	}
+
+	depth--
+	if depth > 0 {
+		return
+	}
+
+	unlock(held)
+	held.Close()
+	held = nil
+}
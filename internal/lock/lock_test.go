@@ -0,0 +1,57 @@
+//go:build unix
+
+package lock
+
+import (
+	"os"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+func TestAcquire(t *testing.T) {
+	originalDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current dir: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to change to temp dir: %v", err)
+	}
+	defer func() { _ = os.Chdir(originalDir) }()
+
+	release, err := Acquire()
+	if err != nil {
+		t.Fatalf("Acquire() unexpected error: %v", err)
+	}
+
+	// Nested acquisition from the same process shares the lock
+	releaseNested, err := Acquire()
+	if err != nil {
+		t.Fatalf("nested Acquire() unexpected error: %v", err)
+	}
+	releaseNested()
+
+	// Another open file description (as another process would have) cannot lock
+	other, err := os.OpenFile(paths.LockFile, os.O_RDWR, paths.FilePermissions)
+	if err != nil {
+		t.Fatalf("Failed to open lock file: %v", err)
+	}
+	defer other.Close()
+
+	if locked, err := tryLock(other); err != nil || locked {
+		t.Errorf("tryLock() while held = %v, %v; want false", locked, err)
+	}
+
+	// Once released by the outermost caller, the lock is free again
+	release()
+	if locked, err := tryLock(other); err != nil || !locked {
+		t.Errorf("tryLock() after release = %v, %v; want true", locked, err)
+	}
+
+	// A lock held elsewhere makes Acquire fail with ErrLocked
+	if _, err := Acquire(); !errors.Is(err, errors.ErrLocked) {
+		t.Errorf("Acquire() while locked elsewhere error = %v, want ErrLocked", err)
+	}
+	unlock(other)
+}
@@ -0,0 +1,284 @@
+// Package notify sends notifications to the channels of inventory/notifications.yaml
+// Routes decide which channels get a notification, by stack, category, event and
+// severity, and can be muted during quiet hours
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Severities, lowest first
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// severityRank orders the severities
+var severityRank = map[string]int{SeverityInfo: 0, SeverityWarning: 1, SeverityCritical: 2}
+
+// Channel types
+const (
+	ChannelNtfy    = "ntfy"    // POST the message to an ntfy topic URL
+	ChannelWebhook = "webhook" // POST the notification as JSON
+)
+
+// sendTimeout bounds each delivery
+const sendTimeout = 10 * time.Second
+
+// Notification is something worth telling about
+type Notification struct {
+	Time     time.Time `json:"time"`
+	Severity string    `json:"severity"`
+	Title    string    `json:"title"`
+	Message  string    `json:"message"`
+	Stack    string    `json:"stack,omitempty"`
+	Category string    `json:"category,omitempty"`
+	Service  string    `json:"service,omitempty"`
+	Event    string    `json:"event"` // e.g. die, unhealthy, update-available
+}
+
+// Config is the layout of inventory/notifications.yaml
+type Config struct {
+	Channels map[string]Channel `yaml:"channels"`
+	Routes   []Route            `yaml:"routes"`
+}
+
+// Channel is a notification destination
+type Channel struct {
+	Type     string `yaml:"type"`      // ntfy or webhook
+	URL      string `yaml:"url"`       // ntfy topic URL or webhook endpoint
+	TokenEnv string `yaml:"token_env"` // Environment variable holding a bearer token (optional)
+}
+
+// Route sends matching notifications to channels; every matching route delivers
+// Empty match lists match everything
+type Route struct {
+	Channels    []string `yaml:"channels"`
+	Stacks      []string `yaml:"stacks"`
+	Categories  []string `yaml:"categories"`
+	Events      []string `yaml:"events"`
+	MinSeverity string   `yaml:"min_severity"` // Lowest severity delivered (default info)
+	QuietHours  string   `yaml:"quiet_hours"`  // HH:MM-HH:MM local time the route is muted, may cross midnight
+}
+
+// LoadConfig reads inventory/notifications.yaml; a missing file means no notifications
+func LoadConfig() (*Config, error) {
+	config := &Config{}
+
+	data, err := os.ReadFile(paths.InventoryNotify)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryNotify, err)
+	}
+
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryNotify, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", paths.InventoryNotify, err)
+	}
+	return config, nil
+}
+
+// validate checks channel types and the references, severities and quiet hours of routes
+func (c *Config) validate() error {
+	for name, channel := range c.Channels {
+		if channel.Type != ChannelNtfy && channel.Type != ChannelWebhook {
+			return fmt.Errorf("channel %s has invalid type '%s' (use %s or %s)", name, channel.Type, ChannelNtfy, ChannelWebhook)
+		}
+		if channel.URL == "" {
+			return fmt.Errorf("channel %s has no url", name)
+		}
+	}
+
+	for i, route := range c.Routes {
+		if len(route.Channels) == 0 {
+			return fmt.Errorf("route %d has no channels", i+1)
+		}
+		for _, name := range route.Channels {
+			if _, ok := c.Channels[name]; !ok {
+				return fmt.Errorf("route %d sends to unknown channel '%s'", i+1, name)
+			}
+		}
+		if _, ok := severityRank[route.MinSeverity]; route.MinSeverity != "" && !ok {
+			return fmt.Errorf("route %d has invalid min_severity '%s' (use %s, %s or %s)",
+				i+1, route.MinSeverity, SeverityInfo, SeverityWarning, SeverityCritical)
+		}
+		if route.QuietHours != "" {
+			if _, _, err := parseQuietHours(route.QuietHours); err != nil {
+				return fmt.Errorf("route %d: %w", i+1, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Enabled reports whether any route is configured
+func (c *Config) Enabled() bool {
+	return len(c.Routes) > 0
+}
+
+// Route returns the channels a notification goes to at the given time, in route order
+func (c *Config) Route(n Notification, now time.Time) []string {
+	seen := make(map[string]bool)
+	var channels []string
+	for _, route := range c.Routes {
+		if !route.matches(n, now) {
+			continue
+		}
+		for _, name := range route.Channels {
+			if !seen[name] {
+				seen[name] = true
+				channels = append(channels, name)
+			}
+		}
+	}
+	return channels
+}
+
+// matches reports whether the route delivers the notification at the given time
+func (r Route) matches(n Notification, now time.Time) bool {
+	if !listMatches(r.Stacks, n.Stack) || !listMatches(r.Categories, n.Category) || !listMatches(r.Events, n.Event) {
+		return false
+	}
+	if severityRank[n.Severity] < severityRank[r.MinSeverity] {
+		return false
+	}
+	if r.QuietHours != "" {
+		start, end, _ := parseQuietHours(r.QuietHours)
+		if inWindow(now.Local(), start, end) {
+			return false
+		}
+	}
+	return true
+}
+
+// listMatches reports whether value is in list; an empty list matches anything
+func listMatches(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseQuietHours parses HH:MM-HH:MM into offsets from midnight
+func parseQuietHours(value string) (time.Duration, time.Duration, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if ok {
+		start, err1 := parseClock(from)
+		end, err2 := parseClock(to)
+		if err1 == nil && err2 == nil && start != end {
+			return start, end, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid quiet_hours: %s (use HH:MM-HH:MM, e.g. 22:00-07:00)", value)
+}
+
+// parseClock parses HH:MM into an offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// inWindow reports whether now's time of day falls in [start, end), crossing midnight when end < start
+func inWindow(now time.Time, start, end time.Duration) bool {
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	if start < end {
+		return clock >= start && clock < end
+	}
+	return clock >= start || clock < end
+}
+
+// Send delivers a notification to the channels its routes select, and returns them
+// Every channel is tried; the error lists the deliveries that failed
+func (c *Config) Send(n Notification) ([]string, error) {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+
+	channels := c.Route(n, n.Time)
+	var failed []string
+	for _, name := range channels {
+		if err := deliver(c.Channels[name], n); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return channels, fmt.Errorf("failed to notify %s", strings.Join(failed, "; "))
+	}
+	return channels, nil
+}
+
+// ntfyPriority maps severities to ntfy priorities (3 is the default)
+var ntfyPriority = map[string]int{SeverityInfo: 3, SeverityWarning: 4, SeverityCritical: 5}
+
+// deliver posts a notification to one channel
+func deliver(channel Channel, n Notification) error {
+	var req *http.Request
+	var err error
+
+	switch channel.Type {
+	case ChannelNtfy:
+		req, err = http.NewRequest(http.MethodPost, channel.URL, strings.NewReader(n.Message))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", n.Title)
+		req.Header.Set("Priority", strconv.Itoa(ntfyPriority[n.Severity]))
+		req.Header.Set("Tags", n.Severity)
+	default:
+		data, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		req, err = http.NewRequest(http.MethodPost, channel.URL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if channel.TokenEnv != "" {
+		token := os.Getenv(channel.TokenEnv)
+		if token == "" {
+			return fmt.Errorf("environment variable %s is not set", channel.TokenEnv)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: sendTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", channel.URL, resp.Status)
+	}
+	return nil
+}
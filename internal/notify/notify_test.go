@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	config, err := LoadConfig()
+	if err != nil || config.Enabled() {
+		t.Fatalf("LoadConfig() without a file = %+v, %v, want no routes", config, err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", "channels:\n  phone: {type: ntfy, url: https://ntfy.sh/lab}\nroutes:\n  - channels: [phone]\n    min_severity: warning\n    quiet_hours: 22:00-07:00\n", ""},
+		{"bad type", "channels:\n  phone: {type: sms, url: x}\n", "invalid type"},
+		{"no url", "channels:\n  phone: {type: ntfy}\n", "no url"},
+		{"unknown channel", "routes:\n  - channels: [pager]\n", "unknown channel"},
+		{"bad severity", "channels:\n  phone: {type: ntfy, url: x}\nroutes:\n  - channels: [phone]\n    min_severity: urgent\n", "min_severity"},
+		{"bad quiet hours", "channels:\n  phone: {type: ntfy, url: x}\nroutes:\n  - channels: [phone]\n    quiet_hours: 22:00\n", "quiet_hours"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.WriteFile(t, paths.InventoryNotify, tt.content)
+			_, err := LoadConfig()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("LoadConfig() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	config := &Config{
+		Channels: map[string]Channel{
+			"phone": {Type: ChannelNtfy, URL: "x"},
+			"chat":  {Type: ChannelWebhook, URL: "x"},
+		},
+		Routes: []Route{
+			{Channels: []string{"chat"}},
+			{Channels: []string{"phone"}, Categories: []string{"core"}, MinSeverity: SeverityWarning, QuietHours: "22:00-07:00"},
+			{Channels: []string{"phone", "chat"}, Events: []string{"die"}, MinSeverity: SeverityCritical},
+		},
+	}
+	day := time.Date(2026, 10, 15, 14, 0, 0, 0, time.Local)
+	night := time.Date(2026, 10, 15, 3, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name string
+		n    Notification
+		now  time.Time
+		want string
+	}{
+		{"catch-all", Notification{Severity: SeverityInfo, Category: "core"}, day, "chat"},
+		{"category over threshold", Notification{Severity: SeverityWarning, Category: "core"}, day, "chat phone"},
+		{"other category", Notification{Severity: SeverityWarning, Category: "test"}, day, "chat"},
+		{"quiet hours", Notification{Severity: SeverityCritical, Category: "core"}, night, "chat"},
+		{"critical crash at night", Notification{Severity: SeverityCritical, Category: "test", Event: "die"}, night, "chat phone"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(config.Route(tt.n, tt.now), " ")
+			if got != tt.want {
+				t.Errorf("Route() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	start, end, err := parseQuietHours("22:30-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for clock, want := range map[string]bool{"22:29": false, "22:30": true, "00:00": true, "06:59": true, "07:00": false, "12:00": false} {
+		now, _ := time.Parse("15:04", clock)
+		if got := inWindow(now, start, end); got != want {
+			t.Errorf("inWindow(%s) = %v, want %v", clock, got, want)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("HOOK_TOKEN", "secret")
+	config := &Config{
+		Channels: map[string]Channel{
+			"phone": {Type: ChannelNtfy, URL: server.URL + "/lab"},
+			"chat":  {Type: ChannelWebhook, URL: server.URL + "/hook", TokenEnv: "HOOK_TOKEN"},
+		},
+		Routes: []Route{{Channels: []string{"phone", "chat"}}},
+	}
+	n := Notification{Severity: SeverityCritical, Title: "jellyfin crashed", Message: "exit 137", Stack: "media", Event: "die"}
+
+	channels, err := config.Send(n)
+	if err != nil || len(channels) != 2 {
+		t.Fatalf("Send() = %v, %v", channels, err)
+	}
+
+	if requests[0].Header.Get("Title") != "jellyfin crashed" || requests[0].Header.Get("Priority") != "5" || bodies[0] != "exit 137" {
+		t.Errorf("ntfy request headers = %v, body %q", requests[0].Header, bodies[0])
+	}
+	var sent Notification
+	if err := json.Unmarshal([]byte(bodies[1]), &sent); err != nil || sent.Stack != "media" || sent.Event != "die" {
+		t.Errorf("webhook body = %s (%v)", bodies[1], err)
+	}
+	if requests[1].Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("webhook Authorization = %q", requests[1].Header.Get("Authorization"))
+	}
+
+	config.Channels["phone"] = Channel{Type: ChannelNtfy, URL: server.URL + "/broken"}
+	if _, err := config.Send(n); err == nil || !strings.Contains(err.Error(), "phone") {
+		t.Errorf("Send() error = %v, want the failed channel", err)
+	}
+}
@@ -9,14 +9,52 @@ const (
 	Inventory = "inventory"
 	Secrets   = "secrets"
 	Runtime   = "runtime"
+	Plugins   = "plugins" // Executables adding generate pipeline stages
 )
 
 // File paths
 const (
 	InventoryVars     = "inventory/vars.yaml"
 	InventoryState    = "inventory/state.yaml"
+	InventoryHosts    = "inventory/hosts.yaml"
+	InventoryUpdates  = "inventory/updates.yaml"
+	InventoryCatalogs = "inventory/catalogs.yaml"
+	InventoryBuilds   = "inventory/builds.yaml"
+	InventoryRegistry = "inventory/registries.yaml"
+	InventoryQuotas   = "inventory/quotas.yaml"
+	InventoryNotify   = "inventory/notifications.yaml"
+	InventoryEnvs     = "inventory/environments"
+	InventoryPower    = "inventory/power.yaml"
+	InventoryHistory  = "inventory/history.yaml"
+	InventoryPolicies = "inventory/policies.yaml"
+	InventoryPlugins  = "inventory/plugins.yaml"
+	InventoryCapacity = "inventory/capacity.yaml"
+	InventorySecrets  = "inventory/secrets.yaml"
+	InventoryCompose  = "inventory/compose.yaml"
+	StacksLock        = "stacks.lock"
+	InventoryExample  = "inventory/vars.example.yaml"
 	DockerCompose     = "runtime/docker-compose.yml"
 	TraefikDynamicDir = "runtime/traefik/dynamic"
+	ContributionsDir  = "runtime/contributions"
+	HistoryDir        = "runtime/history"
+	HistoryCurrent    = "runtime/history/current" // ID of the generation runtime/ holds
+	HistoryPruned     = "runtime/history/.pruned" // Written once pruning by the default keep was reported
+	UptimeDir         = "runtime/uptime"
+	DebugDir          = "runtime/debug"
+	TerraformFile     = "runtime/terraform/main.tf.json"
+	AnsibleDir        = "runtime/ansible"
+	FirewallDir       = "runtime/firewall"
+	ProxyDir          = "runtime/proxy"
+	LockFile          = "runtime/.lock"
+	RuntimeStaging    = "runtime/.staging"
+	RuntimeSecrets    = "runtime/.secrets"     // Secret files mounted as compose secrets
+	FirstRunMarker    = "runtime/.first-run"   // Written once the first-run guidance was shown
+	LastDeployFile    = "runtime/.last-deploy" // When deploy last completed, kept out of the git-tracked inventory/
+	CanaryOverride    = "runtime/canary.override.yml"
+	BlueGreenOverride = "runtime/bluegreen.override.yml"
+
+	ContributionManifest = "runtime/.contributions.yaml"
+	GeneratedManifest    = "runtime/.generated.yaml"
 )
 
 // File names
@@ -25,6 +63,16 @@ const (
 	ComposeTemplate = "compose.yml.tmpl"
 	SecretsEncExt   = ".enc.yaml"
 	SecretsExt      = ".yaml"
+	SecretsAgeExt   = ".age"       // Secrets encrypted with age, without sops
+	SOPSConfig      = ".sops.yaml" // sops creation rules, at the repository root
+	HistorySnapshot = "snapshot.yaml"
+	HistoryOutputs  = "outputs"     // Generated files of a generation, as laid out in runtime/
+	HistoryOwners   = "owners.yaml" // Owner of each archived file
+	ReadmeTemplate  = "README.md.tmpl"
+	NotesTemplate   = "NOTES.md.tmpl"
+	ReadmeFile      = "README.md"
+	NotesFile       = "NOTES.md"
+	NotesShownFile  = ".notes-shown"
 )
 
 // Template extensions
@@ -39,6 +87,11 @@ const (
 	SecureFilePermissions = 0600 // For sensitive files (state, secrets, temp files)
 )
 
+// PluginExecutable returns the default executable of a plugin: plugins/<name>
+func PluginExecutable(name string) string {
+	return filepath.Join(Plugins, name)
+}
+
 // Stack-related path helpers
 
 // StackDir returns the path to a stack directory
@@ -56,6 +109,21 @@ func StackComposeTemplate(name string) string {
 	return filepath.Join(Stacks, name, ComposeTemplate)
 }
 
+// StackComposeVariantTemplate returns the path to a stack's compose.<variant>.yml.tmpl
+func StackComposeVariantTemplate(name, variant string) string {
+	return filepath.Join(Stacks, name, "compose."+variant+".yml"+TemplateExt)
+}
+
+// StackReadmeTemplate returns the path to a stack's README.md.tmpl
+func StackReadmeTemplate(name string) string {
+	return filepath.Join(Stacks, name, ReadmeTemplate)
+}
+
+// StackNotesTemplate returns the path to a stack's NOTES.md.tmpl (post-install notes)
+func StackNotesTemplate(name string) string {
+	return filepath.Join(Stacks, name, NotesTemplate)
+}
+
 // StackContributeDir returns the path to a stack's contribute directory for a provider
 func StackContributeDir(stackName, provider string) string {
 	return filepath.Join(Stacks, stackName, "contribute", provider)
@@ -76,11 +144,70 @@ func RuntimeComposeFile(stackName string) string {
 	return filepath.Join(Runtime, stackName+"-compose.yml")
 }
 
+// RuntimeSecretFile returns the file a stack's secret (its dotted key) is written to when
+// secrets render as compose secrets
+func RuntimeSecretFile(stackName, key string) string {
+	return filepath.Join(RuntimeSecrets, stackName, key)
+}
+
+// RuntimeComposeVariantFile returns the path to a stack's temporary compose variant file in runtime/
+func RuntimeComposeVariantFile(stackName, variant string) string {
+	return filepath.Join(Runtime, stackName+"-compose."+variant+".yml")
+}
+
 // TraefikContributionFile returns the path to a Traefik contribution file in runtime/
 func TraefikContributionFile(stackName, filename string) string {
 	return filepath.Join(TraefikDynamicDir, stackName+"-"+filename)
 }
 
+// ContributionDir returns the runtime directory of a provider's contributions: the Traefik
+// dynamic configuration directory for traefik, runtime/contributions/<provider>/ otherwise
+func ContributionDir(provider string) string {
+	if provider == "traefik" {
+		return TraefikDynamicDir
+	}
+	return filepath.Join(ContributionsDir, provider)
+}
+
+// ContributionFile returns the path of a stack's contribution file to a provider in runtime/
+func ContributionFile(provider, stackName, filename string) string {
+	return filepath.Join(ContributionDir(provider), stackName+"-"+filename)
+}
+
+// ContributionIndexFile returns the path of the index listing a provider's contribution files
+func ContributionIndexFile(provider string) string {
+	return filepath.Join(ContributionsDir, provider+".index.yaml")
+}
+
+// CombinedContributionFile returns the path of the single file merging a provider's
+// YAML contributions
+func CombinedContributionFile(provider string) string {
+	return filepath.Join(ContributionsDir, provider+".yaml")
+}
+
+// SecurityContributionFile returns the path of a stack's security contribution in the
+// runtime config of the stack running the security service: runtime/<target>/<service>/<dir>/<stack>-<name>
+func SecurityContributionFile(targetStack, service, relPath, stackName string) string {
+	return filepath.Join(Runtime, targetStack, service, filepath.Dir(relPath), stackName+"-"+filepath.Base(relPath))
+}
+
+// TunnelConfigFile returns the path of the cloudflared config generated from expose_via
+// tunnel declarations, in the runtime config of the stack running cloudflared
+func TunnelConfigFile(targetStack string) string {
+	return filepath.Join(Runtime, targetStack, "cloudflared", "config.yml")
+}
+
+// TailscaleServeFile returns the path of a service's tailscale serve config, in the
+// runtime config of the stack running tailscale
+func TailscaleServeFile(targetStack, service string) string {
+	return filepath.Join(Runtime, targetStack, "tailscale", "serve", service+".json")
+}
+
+// EnvironmentVars returns the path of an environment's overrides of inventory/vars.yaml
+func EnvironmentVars(env string) string {
+	return filepath.Join(InventoryEnvs, env, "vars.yaml")
+}
+
 // StackConfigDir returns the path to a stack's config/ directory
 func StackConfigDir(stackName string) string {
 	return filepath.Join(Stacks, stackName, "config")
@@ -95,3 +222,34 @@ func RuntimeStackDir(stackName string) string {
 func RuntimeConfigFile(stackName, filename string) string {
 	return filepath.Join(Runtime, stackName, filename)
 }
+
+// HistoryEntryDir returns the path to one generation's directory in runtime/history/
+func HistoryEntryDir(id string) string {
+	return filepath.Join(HistoryDir, id)
+}
+
+// DebugContextFile returns the path to the template context of a stack, kept in
+// runtime/debug/ by --debug
+func DebugContextFile(stackName string) string {
+	return filepath.Join(DebugDir, stackName+".context.yaml")
+}
+
+// RuntimeReadme returns the path to a stack's rendered README in runtime/<stack>/
+func RuntimeReadme(stackName string) string {
+	return filepath.Join(Runtime, stackName, ReadmeFile)
+}
+
+// RuntimeNotes returns the path to a stack's rendered post-install notes in runtime/<stack>/
+func RuntimeNotes(stackName string) string {
+	return filepath.Join(Runtime, stackName, NotesFile)
+}
+
+// RuntimeNotesShown returns the path recording which notes were already printed after deploy
+func RuntimeNotesShown(stackName string) string {
+	return filepath.Join(Runtime, stackName, NotesShownFile)
+}
+
+// DevOverride returns the path of the compose overlay `homelabctl dev` writes for a stack
+func DevOverride(stackName string) string {
+	return filepath.Join(Runtime, stackName, "dev.override.yml")
+}
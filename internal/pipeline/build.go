@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// BuildContextStage prepares services built from source for the merged compose file
+// Relative build contexts in a stack template point into the stack directory, but
+// compose resolves them against runtime/, so they are rewritten relative to runtime/
+// The cache settings of inventory/builds.yaml fill in cache_from and cache_to
+func BuildContextStage() Stage {
+	return func(ctx *Context) error {
+		built := compose.BuiltServices(ctx.MergedCompose)
+		if len(built) == 0 {
+			return nil
+		}
+
+		options, err := inventory.LoadBuildOptions()
+		if err != nil {
+			return err
+		}
+
+		for _, svc := range built {
+			svcMap := ctx.MergedCompose.Services[svc].(map[string]interface{})
+
+			build, ok := svcMap["build"].(map[string]interface{})
+			if !ok {
+				build = map[string]interface{}{"context": fmt.Sprintf("%v", svcMap["build"])}
+			}
+
+			if stackName := ctx.ServiceStacks[svc]; stackName != "" {
+				context, _ := build["context"].(string)
+				if context == "" {
+					context = "."
+				}
+				if !filepath.IsAbs(context) && !isRemoteContext(context) {
+					rel, err := filepath.Rel(paths.Runtime, filepath.Join(paths.StackDir(stackName), context))
+					if err != nil {
+						return fmt.Errorf("failed to resolve build context of %s: %w", svc, err)
+					}
+					build["context"] = rel
+				}
+			}
+
+			cacheFrom, cacheTo := options.ForService(svc)
+			if _, set := build["cache_from"]; !set && len(cacheFrom) > 0 {
+				build["cache_from"] = cacheFrom
+			}
+			if _, set := build["cache_to"]; !set && len(cacheTo) > 0 {
+				build["cache_to"] = cacheTo
+			}
+
+			svcMap["build"] = build
+		}
+
+		ui.Step("Prepared %d service(s) built from source", len(built))
+		return nil
+	}
+}
+
+// isRemoteContext reports whether a build context is a Git repository or URL
+func isRemoteContext(context string) bool {
+	return strings.Contains(context, "://") || strings.HasPrefix(context, "git@")
+}
@@ -1,7 +1,13 @@
 package pipeline
 
 import (
+	"fmt"
+	"path/filepath"
+
 	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
 )
 
 // Context holds state that flows through the pipeline
@@ -9,21 +15,104 @@ type Context struct {
 	// Input
 	EnabledStacks    []string
 	InventoryVars    map[string]interface{}
-	DisabledServices map[string]bool
+	Environment      string                         // Inventory environment of this run ("" for none)
+	Profiles         []string                       // Compose profiles active for this run (COMPOSE_PROFILES)
+	DisabledServices map[string]bool                // Keyed by stack/service
+	StackFilter      map[string]bool                // Stacks this run renders (nil: all)
 
 	// Intermediate state
 	RenderedFiles    []string                      // For cleanup
 	StackConfigs     map[string]*StackConfig       // Per-stack merged config
 	RenderedCompose  map[string]string             // stack name -> compose file path
+	ServiceStacks    map[string]string             // service name -> stack name
+	Contributions    map[string][]string           // stack name -> contribution files rendered in runtime/
+	ContributionFrom map[string]string             // contribution file -> stack that rendered it, when recorded under another stack
+	Configs          map[string][]string           // stack name -> config files rendered in runtime/ (runtime/<stack>/ by default)
+	SecurityLogs     map[string][]string           // security service -> host log files its contributions read
 
 	// Output
 	MergedCompose    *compose.ComposeFile
+	StagingDir       string                        // Outputs are written here, then swapped into runtime/ (empty: write in place)
+	StaleOutputs     []string                      // runtime/ files to remove once outputs are committed
+	CommittedOutputs []string                      // runtime/ files the commit swapped in, archived in history
+
+	// Diagnostics
+	Warnings         []string                      // Non-fatal problems, reported once the run ends
+}
+
+// OutputPath maps a path in runtime/ to where it is written during this run
+func (c *Context) OutputPath(runtimePath string) string {
+	if c.StagingDir == "" {
+		return runtimePath
+	}
+
+	rel, err := filepath.Rel(paths.Runtime, runtimePath)
+	if err != nil {
+		return runtimePath
+	}
+	return filepath.Join(c.StagingDir, rel)
+}
+
+// Selected reports whether this run renders a stack
+func (c *Context) Selected(stackName string) bool {
+	return c.StackFilter == nil || c.StackFilter[stackName]
+}
+
+// IsServiceDisabled reports whether a stack's service is disabled
+func (c *Context) IsServiceDisabled(stackName, serviceName string) bool {
+	return c.DisabledServices[inventory.QualifiedName(stackName, serviceName)]
+}
+
+// Warn records a non-fatal problem
+func (c *Context) Warn(format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
 }
 
 // StackConfig holds the processed configuration for a single stack
 type StackConfig struct {
-	Name         string
-	MergedVars   map[string]interface{}
-	FilteredVars map[string]interface{}
-	Services     []string
+	Name             string
+	Category         string
+	CategoryDefaults map[string]interface{} // Defaults of the stack's category
+	Requires         []string
+	Tags             []string
+	MergedVars       map[string]interface{}
+	FilteredVars     map[string]interface{}
+	Secrets          map[string]interface{} // The stack's own secrets, as loaded from secrets/
+	Services         []string
+	Disabled         []string          // Services of this stack disabled in inventory/state.yaml
+	ConfigOutputs    map[string]string // Config file -> path in runtime/ it is rendered to
+	Sandboxed        bool              // Installed from an untrusted catalog, rendered sandboxed
+	ContextVersion   int               // Template context version the stack expects
+	ComposeVariants  map[string]stacks.ComposeVariant
+	Warnings         []string
+}
+
+// EnabledServices returns the stack's services that are not disabled
+func (c *StackConfig) EnabledServices() []string {
+	enabled := []string{}
+	for _, svc := range c.Services {
+		if !c.IsDisabled(svc) {
+			enabled = append(enabled, svc)
+		}
+	}
+	return enabled
+}
+
+// ConfigOutput returns where a config file of the stack (its path in config/, without
+// .tmpl) is rendered: the path config_outputs gives it in runtime/, or runtime/<stack>/
+func (c *StackConfig) ConfigOutput(relPath string) string {
+	if target, ok := c.ConfigOutputs[filepath.ToSlash(relPath)]; ok {
+		return filepath.Join(paths.Runtime, target)
+	}
+	return paths.RuntimeConfigFile(c.Name, relPath)
+}
+
+// IsDisabled reports whether one of the stack's services is disabled
+func (c *StackConfig) IsDisabled(service string) bool {
+	for _, svc := range c.Disabled {
+		if svc == service {
+			return true
+		}
+	}
+	return false
 }
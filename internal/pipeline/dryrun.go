@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/render"
+)
+
+// StackTemplates returns the templates of a stack, sorted: its compose template, config
+// files, contributions and docs
+func StackTemplates(stackName string) ([]string, error) {
+	var templates []string
+	err := filepath.Walk(paths.StackDir(stackName), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == paths.TemplateExt {
+			templates = append(templates, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates of %s: %w", stackName, err)
+	}
+
+	sort.Strings(templates)
+	return templates, nil
+}
+
+// DryRender renders every template of a stack into memory, without writing anything,
+// and checks that the rendered YAML files parse; it returns the templates rendered
+func DryRender(config *StackConfig, enabledStacks []string) ([]string, error) {
+	templates, err := StackTemplates(config.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	templateCtx := TemplateContext(config, enabledStacks)
+	for _, tmplPath := range templates {
+		output, err := render.RenderTemplate(tmplPath, templateCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		switch filepath.Ext(strings.TrimSuffix(tmplPath, paths.TemplateExt)) {
+		case ".yml", ".yaml":
+			var parsed interface{}
+			if err := yaml.Unmarshal([]byte(output), &parsed); err != nil {
+				return nil, errors.New(
+					fmt.Sprintf("%s does not render valid YAML", tmplPath),
+					fmt.Sprintf("Check template output: %s", tmplPath),
+				).WithContext(
+					"YAML error:",
+					err.Error(),
+				).WithClass(errors.ClassRender).WithKind(errors.ErrRenderFailed)
+			}
+		}
+	}
+
+	return templates, nil
+}
@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/proxy"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// ExposeStage publishes the services of each stack's expose section through the reverse
+// proxy set with proxy.provider in inventory/vars.yaml: Traefik labels on the services,
+// or runtime/proxy/Caddyfile, or runtime/proxy/npm-proxy-hosts.json
+// Labels a template sets itself are left as they are
+func ExposeStage() Stage {
+	return func(ctx *Context) error {
+		settings, err := proxy.Load(ctx.InventoryVars)
+		if err != nil {
+			return errors.New(err.Error(), "Set proxy.provider in inventory/vars.yaml to "+strings.Join(proxy.Providers, ", ")).
+				WithClass(errors.ClassValidation)
+		}
+
+		routes, err := exposeRoutes(ctx)
+		if err != nil {
+			return err
+		}
+
+		// The file of another provider would keep routing after a switch
+		for _, provider := range proxy.Providers {
+			if name := proxy.FileName(provider); name != "" && provider != settings.Provider {
+				ctx.StaleOutputs = append(ctx.StaleOutputs, filepath.Join(paths.ProxyDir, name))
+			}
+		}
+
+		var content string
+		switch settings.Provider {
+		case proxy.ProviderTraefik:
+			labeled := 0
+			for _, route := range routes {
+				// Services of unselected stacks are kept with their labels
+				if _, ok := ctx.MergedCompose.Services[route.Service]; !ok || !ctx.Selected(route.Stack) {
+					continue
+				}
+				existing := compose.ServiceLabels(ctx.MergedCompose, route.Service)
+				for key, value := range proxy.TraefikLabels(route, settings) {
+					if _, ok := existing[key]; !ok {
+						compose.SetServiceLabel(ctx.MergedCompose, route.Service, key, value)
+					}
+				}
+				labeled++
+			}
+			if labeled > 0 {
+				ui.Step("Exposed %d service(s) through Traefik labels", labeled)
+			}
+			return nil
+		case proxy.ProviderCaddy:
+			content = proxy.Caddyfile(routes)
+		case proxy.ProviderNPM:
+			if content, err = proxy.NPMHosts(routes); err != nil {
+				return err
+			}
+		}
+
+		output := filepath.Join(paths.ProxyDir, proxy.FileName(settings.Provider))
+		outputPath := ctx.OutputPath(output)
+		if err := os.MkdirAll(filepath.Dir(outputPath), paths.DirPermissions); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(outputPath), err)
+		}
+		if err := os.WriteFile(outputPath, []byte(content), paths.FilePermissions); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		ui.Step("Exposed %d service(s) through %s: %s", len(routes), settings.Provider, output)
+		return nil
+	}
+}
+
+// ExposedRoutes returns the routes of the enabled stacks as generate would expose them,
+// without running the pipeline
+func ExposedRoutes() ([]proxy.Route, error) {
+	enabled, err := fs.GetEnabledStacks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enabled stacks: %w", err)
+	}
+	vars, err := inventory.LoadVars()
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := inventory.GetDisabledServices()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := &Context{EnabledStacks: enabled, InventoryVars: vars, DisabledServices: make(map[string]bool)}
+	for _, svc := range disabled {
+		ctx.DisabledServices[svc] = true
+	}
+	return exposeRoutes(ctx)
+}
+
+// exposeRoutes returns the routes of the expose sections of the enabled stacks, sorted by
+// host and path, leaving out disabled services
+// Host names without a dot are subdomains of the inventory's domain variable
+func exposeRoutes(ctx *Context) ([]proxy.Route, error) {
+	domain, _ := ctx.InventoryVars["domain"].(string)
+
+	var routes []proxy.Route
+	owners := make(map[string]string) // host+path -> stack/service routed there
+	for _, stackName := range ctx.EnabledStacks {
+		stack, err := stacks.LoadStack(stackName)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, svc := range stack.ExposedServices() {
+			if ctx.IsServiceDisabled(stackName, svc) {
+				continue
+			}
+			expose := stack.Expose[svc]
+
+			host := expose.Host
+			if !strings.Contains(host, ".") {
+				if domain == "" {
+					return nil, errors.New(
+						fmt.Sprintf("expose host '%s' of %s in stack %s needs a domain", host, svc, stackName),
+						"Set domain in inventory/vars.yaml, or give the full host name in stack.yaml",
+					).WithClass(errors.ClassValidation)
+				}
+				host += "." + domain
+			}
+
+			owner := stackName + "/" + svc
+			key := host + expose.Path
+			if other, ok := owners[key]; ok {
+				return nil, fmt.Errorf("%s%s is exposed by both %s and %s", host, expose.Path, other, owner)
+			}
+			owners[key] = owner
+
+			routes = append(routes, proxy.Route{Stack: stackName, Service: svc, Host: host, Port: expose.Port, Path: expose.Path, Via: expose.Via})
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Host != routes[j].Host {
+			return routes[i].Host < routes[j].Host
+		}
+		return routes[i].Path < routes[j].Path
+	})
+	return routes, nil
+}
@@ -0,0 +1,144 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// GeneratedManifest records the files the last generate rendered in runtime/, so
+// verify can tell when they were edited or deleted afterwards
+type GeneratedManifest struct {
+	Environment string                    `yaml:"environment,omitempty"` // Inventory environment of the run
+	Stacks      map[string]GeneratedStack `yaml:"stacks"`
+}
+
+// GeneratedStack lists a stack's rendered config and contribution files
+type GeneratedStack struct {
+	ConfigHash string            `yaml:"config_hash"` // Value of the homelabctl.config-hash label
+	Files      map[string]string `yaml:"files"`       // runtime path -> sha256 of its content
+}
+
+// ConfigHashStage labels each service with a digest of its stack's rendered config
+// and contribution files, and records every rendered file in runtime/.generated.yaml
+// A config change then changes the label, so compose recreates the stack's containers
+func ConfigHashStage() Stage {
+	return func(ctx *Context) error {
+		manifest := GeneratedManifest{Environment: ctx.Environment, Stacks: make(map[string]GeneratedStack)}
+
+		// Stacks outside the selection keep the files of the run that rendered them
+		previous := &GeneratedManifest{}
+		if ctx.StackFilter != nil {
+			if loaded, err := LoadGeneratedManifest(); err == nil {
+				previous = loaded
+			}
+		}
+
+		for _, stackName := range ctx.EnabledStacks {
+			files := append(append([]string(nil), ctx.Configs[stackName]...), ctx.Contributions[stackName]...)
+			if !ctx.Selected(stackName) {
+				kept, ok := previous.Stacks[stackName]
+				if ok && len(files) == 0 {
+					manifest.Stacks[stackName] = kept
+					continue
+				}
+				for file := range kept.Files {
+					files = append(files, file)
+				}
+			}
+			if len(files) == 0 {
+				continue
+			}
+			sort.Strings(files)
+			files = dedupe(files)
+
+			stack := GeneratedStack{Files: make(map[string]string)}
+			stackHash := sha256.New()
+			for _, file := range files {
+				// Rendered by this run (staged), or kept from the previous one
+				path := ctx.OutputPath(file)
+				if _, err := os.Stat(path); err != nil && !ctx.Selected(stackName) {
+					path = file
+				}
+				digest, err := FileDigest(path)
+				if err != nil {
+					return err
+				}
+				stack.Files[file] = digest
+				fmt.Fprintf(stackHash, "%s  %s\n", digest, file)
+			}
+			stack.ConfigHash = hex.EncodeToString(stackHash.Sum(nil))[:12]
+			manifest.Stacks[stackName] = stack
+		}
+
+		for svc := range ctx.MergedCompose.Services {
+			if stack, ok := manifest.Stacks[ctx.ServiceStacks[svc]]; ok {
+				compose.SetServiceLabel(ctx.MergedCompose, svc, compose.LabelConfigHash, stack.ConfigHash)
+			}
+		}
+
+		return writeGeneratedManifest(ctx.OutputPath(paths.GeneratedManifest), manifest)
+	}
+}
+
+// FileDigest returns the hex sha256 of a file's content
+func FileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LoadGeneratedManifest reads runtime/.generated.yaml
+func LoadGeneratedManifest() (*GeneratedManifest, error) {
+	data, err := os.ReadFile(paths.GeneratedManifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.GeneratedManifest, err)
+	}
+
+	var manifest GeneratedManifest
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.GeneratedManifest, err)
+	}
+	if manifest.Stacks == nil {
+		manifest.Stacks = make(map[string]GeneratedStack)
+	}
+
+	return &manifest, nil
+}
+
+// writeGeneratedManifest writes the manifest to path
+func writeGeneratedManifest(path string, manifest GeneratedManifest) error {
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal generated manifest: %w", err)
+	}
+
+	if err := fs.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+
+	header := "# Generated by homelabctl - rendered files and config hashes, checked by verify\n"
+	if err := os.WriteFile(path, append([]byte(header), data...), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
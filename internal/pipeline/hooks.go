@@ -0,0 +1,31 @@
+package pipeline
+
+import (
+	"github.com/monkeymonk/homelabctl/internal/hooks"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// HooksStage runs the hooks of the selected stacks for an event, in stack order, with
+// each stack's merged variables; the first command that fails stops the run
+func HooksStage(event string) Stage {
+	return func(ctx *Context) error {
+		for _, stackName := range ctx.EnabledStacks {
+			if !ctx.Selected(stackName) {
+				continue
+			}
+			stack, err := stacks.LoadStack(stackName)
+			if err != nil {
+				return err
+			}
+
+			var vars map[string]interface{}
+			if config, ok := ctx.StackConfigs[stackName]; ok {
+				vars = config.MergedVars
+			}
+			if err := hooks.Run(stackName, event, stack.Hooks[event], vars); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
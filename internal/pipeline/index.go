@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// contributionIndex is the layout of runtime/contributions/<provider>.index.yaml
+type contributionIndex struct {
+	Provider string                `yaml:"provider"`
+	Dir      string                `yaml:"dir"`
+	Combined string                `yaml:"combined,omitempty"`
+	Files    []indexedContribution `yaml:"files"`
+}
+
+// indexedContribution is a contribution file and the stack it comes from
+type indexedContribution struct {
+	Path  string `yaml:"path"`
+	Stack string `yaml:"stack"`
+}
+
+// ContributionIndexStage indexes the files contributed to each provider, from the
+// contribution manifest, in runtime/contributions/<provider>.index.yaml; the providers
+// listed in contributions.combine of inventory/vars.yaml also get their YAML
+// contributions merged into runtime/contributions/<provider>.yaml, to mount as one file
+// Indexes and combined files of providers no longer contributed to are removed
+func ContributionIndexStage() Stage {
+	return func(ctx *Context) error {
+		manifest, err := readContributionManifest(ctx.OutputPath(paths.ContributionManifest))
+		if err != nil {
+			return err
+		}
+
+		byProvider := make(map[string][]indexedContribution)
+		for stackName, files := range manifest.Stacks {
+			for _, file := range files {
+				if provider := contributionProvider(file); provider != "" {
+					byProvider[provider] = append(byProvider[provider], indexedContribution{Path: file, Stack: stackName})
+				}
+			}
+		}
+
+		combine := make(map[string]bool)
+		for _, provider := range inventory.CombinedContributions(ctx.InventoryVars) {
+			combine[provider] = true
+		}
+
+		written := make(map[string]bool)
+		for provider, files := range byProvider {
+			sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+			index := contributionIndex{Provider: provider, Dir: paths.ContributionDir(provider), Files: files}
+
+			if combine[provider] {
+				combined, err := combineContributions(ctx, provider, files)
+				if err != nil {
+					return err
+				}
+				index.Combined = paths.CombinedContributionFile(provider)
+				if err := writeGenerated(ctx.OutputPath(index.Combined), combined); err != nil {
+					return err
+				}
+				written[index.Combined] = true
+			}
+
+			data, err := yaml.Marshal(index)
+			if err != nil {
+				return fmt.Errorf("failed to marshal the %s contribution index: %w", provider, err)
+			}
+			header := fmt.Sprintf("# Generated by homelabctl - files contributed to %s\n", provider)
+			indexPath := paths.ContributionIndexFile(provider)
+			if err := writeGenerated(ctx.OutputPath(indexPath), append([]byte(header), data...)); err != nil {
+				return err
+			}
+			written[indexPath] = true
+		}
+
+		// Files of providers no longer contributed to, or no longer combined
+		entries, err := os.ReadDir(paths.ContributionsDir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", paths.ContributionsDir, err)
+		}
+		for _, entry := range entries {
+			file := filepath.Join(paths.ContributionsDir, entry.Name())
+			if !entry.IsDir() && filepath.Ext(file) == ".yaml" && !written[file] {
+				ui.Detail("- Removing stale contribution index: %s", file)
+				ctx.StaleOutputs = append(ctx.StaleOutputs, file)
+			}
+		}
+
+		return nil
+	}
+}
+
+// contributionProvider returns the provider a contribution file was rendered for,
+// or "" for files outside the providers' directories (security contributions)
+func contributionProvider(file string) string {
+	dir := filepath.Dir(file)
+	switch {
+	case dir == paths.TraefikDynamicDir:
+		return "traefik"
+	case filepath.Dir(dir) == paths.ContributionsDir:
+		return filepath.Base(dir)
+	}
+	return ""
+}
+
+// combineContributions merges the YAML contributions to a provider, in path order:
+// lists are concatenated and mappings merged, a key set to two different values fails
+// Other files are left out, with a warning
+func combineContributions(ctx *Context, provider string, files []indexedContribution) ([]byte, error) {
+	var combined interface{}
+	var stacks []string
+	for _, file := range files {
+		if ext := filepath.Ext(file.Path); ext != ".yaml" && ext != ".yml" {
+			ctx.Warn("%s is not YAML and is left out of %s", file.Path, paths.CombinedContributionFile(provider))
+			continue
+		}
+
+		// Rendered by this run, or kept from an earlier one for an unselected stack
+		path := ctx.OutputPath(file.Path)
+		if _, err := os.Stat(path); err != nil {
+			path = file.Path
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc interface{}
+			if err := decoder.Decode(&doc); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", file.Path, err)
+			}
+			if combined, err = mergeContribution(combined, doc, ""); err != nil {
+				return nil, fmt.Errorf("failed to combine %s into %s: %w", file.Path, paths.CombinedContributionFile(provider), err)
+			}
+		}
+		stacks = append(stacks, file.Stack)
+	}
+
+	data, err := yaml.Marshal(combined)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s: %w", paths.CombinedContributionFile(provider), err)
+	}
+	sort.Strings(stacks)
+	header := fmt.Sprintf("# Generated by homelabctl - contributions of %s to %s, do not edit\n",
+		strings.Join(dedupe(stacks), ", "), provider)
+	return append([]byte(header), data...), nil
+}
+
+// mergeContribution merges a YAML document into the documents merged so far
+func mergeContribution(into, doc interface{}, key string) (interface{}, error) {
+	if into == nil {
+		return doc, nil
+	}
+	if doc == nil {
+		return into, nil
+	}
+
+	switch existing := into.(type) {
+	case []interface{}:
+		if list, ok := doc.([]interface{}); ok {
+			return append(existing, list...), nil
+		}
+	case map[string]interface{}:
+		if mapping, ok := doc.(map[string]interface{}); ok {
+			for k, v := range mapping {
+				merged, err := mergeContribution(existing[k], v, strings.TrimPrefix(key+"."+k, "."))
+				if err != nil {
+					return nil, err
+				}
+				existing[k] = merged
+			}
+			return existing, nil
+		}
+	default:
+		if fmt.Sprint(into) == fmt.Sprint(doc) {
+			return into, nil
+		}
+	}
+
+	if key == "" {
+		return nil, fmt.Errorf("contributions mix lists, mappings and values at the top level")
+	}
+	return nil, fmt.Errorf("%s is set to conflicting values", key)
+}
+
+// writeGenerated writes a generated file, creating its directory
+func writeGenerated(path string, data []byte) error {
+	if err := fs.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// contributionManifest lists the contribution files generate rendered for each stack
+// Files listed by a previous run but not rendered again are removed
+type contributionManifest struct {
+	Stacks  map[string][]string `yaml:"stacks"`
+	Sources map[string]string   `yaml:"sources,omitempty"` // File -> stack that rendered it, when listed under another stack
+}
+
+// source returns the stack that rendered a file listed under stackName
+func (m contributionManifest) source(file, stackName string) string {
+	if source, ok := m.Sources[file]; ok {
+		return source
+	}
+	return stackName
+}
+
+// ContributionManifestStage records this run's contribution files in
+// runtime/.contributions.yaml and marks those of earlier runs that were not rendered
+// again (disabled or deleted stack, disabled service, removed template) for removal
+func ContributionManifestStage() Stage {
+	return func(ctx *Context) error {
+		previous, err := loadContributionManifest()
+		if err != nil {
+			return err
+		}
+
+		// Rendered now, or already removed as a disabled service's contribution
+		handled := make(map[string]bool)
+		for _, files := range ctx.Contributions {
+			for _, file := range files {
+				handled[file] = true
+			}
+		}
+		for _, file := range ctx.StaleOutputs {
+			handled[file] = true
+		}
+
+		// Files of stacks outside the selection are kept, security contributions listed
+		// under a selected stack included: the stack that rendered them decides
+		manifest := contributionManifest{Stacks: make(map[string][]string), Sources: make(map[string]string)}
+		for stackName, files := range previous.Stacks {
+			for _, file := range files {
+				source := previous.source(file, stackName)
+				if ctx.Selected(source) {
+					continue
+				}
+				manifest.Stacks[stackName] = append(manifest.Stacks[stackName], file)
+				if source != stackName {
+					manifest.Sources[file] = source
+				}
+				handled[file] = true
+			}
+		}
+
+		candidates, err := knownContributions(previous)
+		if err != nil {
+			return err
+		}
+
+		var stale []string
+		for file, stackName := range candidates {
+			if handled[file] || !ctx.Selected(stackName) {
+				continue
+			}
+			if _, err := os.Stat(file); err == nil {
+				stale = append(stale, file)
+			}
+		}
+		sort.Strings(stale)
+
+		for _, file := range stale {
+			ui.Detail("- Removing stale contribution: %s (from %s)", file, candidates[file])
+		}
+		ctx.StaleOutputs = append(ctx.StaleOutputs, stale...)
+
+		for stackName, files := range ctx.Contributions {
+			manifest.Stacks[stackName] = append(manifest.Stacks[stackName], files...)
+			for _, file := range files {
+				if source, ok := ctx.ContributionFrom[file]; ok && source != stackName {
+					manifest.Sources[file] = source
+				}
+			}
+		}
+		for stackName, files := range manifest.Stacks {
+			sort.Strings(files)
+			manifest.Stacks[stackName] = dedupe(files)
+		}
+
+		return writeContributionManifest(ctx.OutputPath(paths.ContributionManifest), manifest)
+	}
+}
+
+// knownContributions maps contribution files homelabctl may have written to the stack
+// that rendered them:
+// those in the previous manifest, plus the outputs of every stack's contribution
+// templates (covers files rendered before the manifest existed)
+func knownContributions(previous contributionManifest) (map[string]string, error) {
+	known := make(map[string]string)
+	for stackName, files := range previous.Stacks {
+		for _, file := range files {
+			known[file] = previous.source(file, stackName)
+		}
+	}
+
+	available, err := fs.GetAvailableStacks()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stackName := range available {
+		for _, provider := range contributionProviders(stackName) {
+			entries, err := os.ReadDir(paths.StackContributeDir(stackName, provider))
+			if err != nil {
+				continue // No contributions
+			}
+			for _, entry := range entries {
+				if entry.IsDir() || filepath.Ext(entry.Name()) != paths.TemplateExt {
+					continue
+				}
+				outputName := strings.TrimSuffix(entry.Name(), paths.TemplateExt)
+				known[paths.ContributionFile(provider, stackName, outputName)] = stackName
+			}
+		}
+	}
+
+	return known, nil
+}
+
+// loadContributionManifest reads runtime/.contributions.yaml; a missing file is an empty manifest
+func loadContributionManifest() (contributionManifest, error) {
+	return readContributionManifest(paths.ContributionManifest)
+}
+
+// readContributionManifest reads a contribution manifest; a missing file is an empty manifest
+func readContributionManifest(path string) (contributionManifest, error) {
+	var manifest contributionManifest
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return manifest, nil
+}
+
+// writeContributionManifest writes the manifest to path
+func writeContributionManifest(path string, manifest contributionManifest) error {
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal contribution manifest: %w", err)
+	}
+
+	if err := fs.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+
+	header := "# Generated by homelabctl - contribution files rendered per stack\n"
+	if err := os.WriteFile(path, append([]byte(header), data...), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// dedupe removes repeated entries from a sorted list
+func dedupe(sorted []string) []string {
+	var result []string
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
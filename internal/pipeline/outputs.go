@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// checkConfigOutputs fails when two config files, or a config file and a contribution,
+// were rendered to the same path in runtime/, and warns about config_outputs entries
+// naming no config template
+func checkConfigOutputs(ctx *Context) error {
+	stackNames := make([]string, 0, len(ctx.Configs))
+	for stackName := range ctx.Configs {
+		stackNames = append(stackNames, stackName)
+	}
+	sort.Strings(stackNames)
+
+	owners := make(map[string]string)
+	for _, stackName := range stackNames {
+		for _, file := range ctx.Configs[stackName] {
+			if owner, ok := owners[file]; ok {
+				return errors.New(
+					fmt.Sprintf("stacks %s and %s both render config file %s", owner, stackName, file),
+					fmt.Sprintf("Change config_outputs in stacks/%s/stack.yaml or stacks/%s/stack.yaml", owner, stackName),
+				).WithClass(errors.ClassValidation)
+			}
+			owners[file] = stackName
+		}
+	}
+
+	contributors := make([]string, 0, len(ctx.Contributions))
+	for stackName := range ctx.Contributions {
+		contributors = append(contributors, stackName)
+	}
+	sort.Strings(contributors)
+	for _, stackName := range contributors {
+		for _, file := range ctx.Contributions[stackName] {
+			if err := checkOutputOwner(ctx, file, fmt.Sprintf("a contribution file of stack %s", stackName)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, stackName := range ctx.EnabledStacks {
+		config, ok := ctx.StackConfigs[stackName]
+		if !ok || !ctx.Selected(stackName) {
+			continue
+		}
+		files := make([]string, 0, len(config.ConfigOutputs))
+		for file := range config.ConfigOutputs {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			tmplPath := filepath.Join(paths.StackConfigDir(stackName), file+paths.TemplateExt)
+			if _, err := os.Stat(tmplPath); err != nil {
+				ctx.Warn("config_outputs of stack %s names %s, but there is no %s", stackName, file, tmplPath)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkOutputOwner fails when a config file of a stack is rendered to runtimePath, which
+// the run also writes as what
+func checkOutputOwner(ctx *Context, runtimePath, what string) error {
+	for stackName, files := range ctx.Configs {
+		for _, file := range files {
+			if file == runtimePath {
+				return errors.New(
+					fmt.Sprintf("stack %s renders config file %s, which is also %s", stackName, file, what),
+					fmt.Sprintf("Change config_outputs in stacks/%s/stack.yaml", stackName),
+				).WithClass(errors.ClassValidation)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// chownHelper changes the owner of a path when homelabctl runs unprivileged; it must not
+// prompt for a password. Replaced in tests
+var chownHelper = []string{"sudo", "-n", "chown"}
+
+// PermissionsStage applies the permissions section of each rendered stack: the mode and
+// owner of its staged config files and directories and compose secret files, before they
+// are committed, and of its persistence directories under persistence.root of the
+// inventory, created when missing
+// Owners are changed as root, or through chownHelper; when neither works, the run warns.
+// Stacks of untrusted catalogs get no permissions: they would chown host paths
+func PermissionsStage() Stage {
+	return func(ctx *Context) error {
+		root := inventory.PersistenceRoot(ctx.InventoryVars)
+		applied := 0
+		for _, stackName := range ctx.EnabledStacks {
+			if !ctx.Selected(stackName) {
+				continue
+			}
+			stack, err := stacks.LoadStack(stackName)
+			if err != nil {
+				return err
+			}
+			if len(stack.Permissions.Configs) == 0 && len(stack.Permissions.Paths) == 0 && len(stack.Permissions.Secrets) == 0 {
+				continue
+			}
+			if config := ctx.StackConfigs[stackName]; config != nil && config.Sandboxed {
+				ctx.Warn("permissions of stack %s ignored: it is installed from an untrusted catalog", stackName)
+				continue
+			}
+
+			rendered := make(map[string]bool)
+			for _, file := range ctx.Configs[stackName] {
+				rendered[file] = true
+			}
+			files, err := configFiles(stackName)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				perm, ok := stack.ConfigPermission(file)
+				runtimePath := ctx.StackConfigs[stackName].ConfigOutput(file)
+				if !ok || !rendered[runtimePath] {
+					continue
+				}
+				if err := applyPermission(ctx, ctx.OutputPath(runtimePath), runtimePath, perm); err != nil {
+					return err
+				}
+				applied++
+			}
+
+			count, err := applyConfigDirPermissions(ctx, stack)
+			if err != nil {
+				return err
+			}
+			applied += count
+
+			// Compose bind-mounts secret files, so containers see their host mode and owner
+			keys := make([]string, 0, len(stack.Permissions.Secrets))
+			for key := range stack.Permissions.Secrets {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				runtimePath := paths.RuntimeSecretFile(stackName, key)
+				if !rendered[runtimePath] {
+					continue // Not mounted by this run
+				}
+				if err := applyPermission(ctx, ctx.OutputPath(runtimePath), runtimePath, stack.Permissions.Secrets[key]); err != nil {
+					return err
+				}
+				applied++
+			}
+
+			dirs := make([]string, 0, len(stack.Permissions.Paths))
+			for dir := range stack.Permissions.Paths {
+				if root == "" {
+					ctx.Warn("permissions of %s in stack %s ignored: set persistence.root in %s to the directory holding the persistence paths",
+						dir, stackName, paths.InventoryVars)
+					continue
+				}
+				if !underDir(dir, root) {
+					ctx.Warn("permissions of %s in stack %s ignored: not under persistence.root (%s)", dir, stackName, root)
+					continue
+				}
+				dirs = append(dirs, dir)
+			}
+			sort.Strings(dirs)
+			for _, dir := range dirs {
+				if err := os.MkdirAll(dir, paths.DirPermissions); err != nil {
+					ctx.Warn("failed to create persistence path %s of stack %s: %v", dir, stackName, err)
+					continue
+				}
+				if err := applyPermission(ctx, dir, dir, stack.Permissions.Paths[dir]); err != nil {
+					ctx.Warn("%v", err)
+					continue
+				}
+				applied++
+			}
+		}
+
+		if applied > 0 {
+			ui.Step("Applied permissions to %d path(s)", applied)
+		}
+		return nil
+	}
+}
+
+// applyConfigDirPermissions applies the permissions of config directories to the staged
+// directories themselves and those below them (the closest entry wins), as files only
+// get them otherwise. Returns how many directories were changed
+func applyConfigDirPermissions(ctx *Context, stack *stacks.Stack) (int, error) {
+	config := ctx.StackConfigs[stack.Name]
+	applied := make(map[string]bool)
+	for key := range stack.Permissions.Configs {
+		if info, err := os.Stat(filepath.Join(paths.StackConfigDir(stack.Name), key)); err != nil || !info.IsDir() {
+			continue
+		}
+
+		runtimeDir := config.ConfigOutput(key)
+		err := filepath.Walk(ctx.OutputPath(runtimeDir), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return filepath.SkipDir // Nothing rendered there
+				}
+				return err
+			}
+			if !info.IsDir() || applied[path] {
+				return nil
+			}
+			rel, err := filepath.Rel(ctx.OutputPath(runtimeDir), path)
+			if err != nil {
+				return err
+			}
+			perm, _ := stack.ConfigPermission(filepath.Join(key, rel))
+			applied[path] = true
+			return applyPermission(ctx, path, filepath.Join(runtimeDir, rel), perm)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(applied), nil
+}
+
+// underDir reports whether path is dir or below it
+func underDir(path, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// configFiles returns the config files of a stack: its config templates, as paths in
+// config/ without .tmpl
+func configFiles(stackName string) ([]string, error) {
+	configDir := paths.StackConfigDir(stackName)
+	var files []string
+	err := filepath.Walk(configDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == configDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != paths.TemplateExt {
+			return nil
+		}
+		rel, err := filepath.Rel(configDir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, strings.TrimSuffix(rel, paths.TemplateExt))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list config templates of %s: %w", stackName, err)
+	}
+	return files, nil
+}
+
+// applyPermission changes the owner, then the mode of a path (the owner change may clear
+// mode bits); name is the path as reported. A directory is also made searchable where
+// the mode makes it readable. A mode that cannot be set is an error, an owner that
+// cannot be changed a warning
+func applyPermission(ctx *Context, path, name string, perm stacks.Permission) error {
+	if perm.UID != nil || perm.GID != nil {
+		if err := chown(path, perm.UID, perm.GID); err != nil {
+			ctx.Warn("failed to set the owner of %s (%s): %v; run as root or allow '%s' without a password",
+				name, perm, err, strings.Join(chownHelper, " "))
+		}
+	}
+
+	if mode, ok := perm.FileMode(); ok {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			mode |= (mode & 0o444) >> 2
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to set the mode of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// chown changes the owner of a path, through chownHelper when not permitted; a nil ID
+// is left unchanged
+func chown(path string, uid, gid *int) error {
+	id := func(v *int) int {
+		if v == nil {
+			return -1
+		}
+		return *v
+	}
+	err := os.Lchown(path, id(uid), id(gid))
+	if err == nil || !errors.Is(err, os.ErrPermission) {
+		return err
+	}
+
+	owner := ""
+	if uid != nil {
+		owner = strconv.Itoa(*uid)
+	}
+	if gid != nil {
+		owner += ":" + strconv.Itoa(*gid)
+	}
+	args := append(append([]string{}, chownHelper[1:]...), owner, path)
+	if output, helperErr := exec.Command(chownHelper[0], args...).CombinedOutput(); helperErr != nil {
+		if detail := strings.TrimSpace(string(output)); detail != "" {
+			return fmt.Errorf("%w (%s: %s)", err, chownHelper[0], detail)
+		}
+		return fmt.Errorf("%w (%s: %v)", err, chownHelper[0], helperErr)
+	}
+	return nil
+}
@@ -2,40 +2,64 @@ package pipeline
 
 import (
 	"fmt"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
 )
 
 // Stage is a function that processes the pipeline context
 type Stage func(*Context) error
 
+// NamedStage is a stage with the name plugins are ordered against and errors report
+type NamedStage struct {
+	Name  string
+	Stage Stage
+}
+
 // Pipeline represents a sequence of processing stages
 type Pipeline struct {
-	stages []Stage
+	stages []NamedStage
 	ctx    *Context
 }
 
 // New creates a new pipeline with an initial context
 func New() *Pipeline {
 	return &Pipeline{
-		stages: []Stage{},
+		stages: []NamedStage{},
 		ctx: &Context{
 			RenderedFiles:    []string{},
 			StackConfigs:     make(map[string]*StackConfig),
 			RenderedCompose:  make(map[string]string),
+			ServiceStacks:    make(map[string]string),
+			Contributions:    make(map[string][]string),
+			ContributionFrom: make(map[string]string),
+			Configs:          make(map[string][]string),
+			SecurityLogs:     make(map[string][]string),
 			DisabledServices: make(map[string]bool),
+			Warnings:         []string{},
+			StagingDir:       paths.RuntimeStaging,
 		},
 	}
 }
 
 // AddStage adds a stage to the pipeline
 func (p *Pipeline) AddStage(stage Stage) *Pipeline {
-	p.stages = append(p.stages, stage)
+	p.stages = append(p.stages, NamedStage{Stage: stage})
+	return p
+}
+
+// AddStages adds named stages to the pipeline, in order
+func (p *Pipeline) AddStages(stages ...NamedStage) *Pipeline {
+	p.stages = append(p.stages, stages...)
 	return p
 }
 
 // Execute runs all stages in sequence
 func (p *Pipeline) Execute() error {
 	for i, stage := range p.stages {
-		if err := stage(p.ctx); err != nil {
+		if err := stage.Stage(p.ctx); err != nil {
+			if stage.Name != "" {
+				return fmt.Errorf("stage %d (%s) failed: %w", i+1, stage.Name, err)
+			}
 			return fmt.Errorf("stage %d failed: %w", i+1, err)
 		}
 	}
@@ -2,7 +2,17 @@ package pipeline
 
 import (
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/history"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/render"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
 )
 
 func setupPipelineTest(t *testing.T) (string, func()) {
@@ -222,8 +232,8 @@ func TestFilterServicesStage(t *testing.T) {
 
 	// Setup context with disabled services
 	p.ctx.DisabledServices = map[string]bool{
-		"disabled1": true,
-		"disabled2": true,
+		"stack1/disabled1": true,
+		"stack2/disabled2": true,
 	}
 
 	p.ctx.StackConfigs = map[string]*StackConfig{
@@ -313,3 +323,1591 @@ func TestContext_Initialization(t *testing.T) {
 
 	// EnabledStacks and InventoryVars are populated by stages, not in New()
 }
+
+func TestLabelServicesStage(t *testing.T) {
+	p := New()
+
+	p.ctx.MergedCompose = &compose.ComposeFile{
+		Services: map[string]interface{}{
+			"traefik": map[string]interface{}{"image": "traefik"},
+			"grafana": map[string]interface{}{
+				"image":  "grafana/grafana",
+				"labels": []interface{}{"traefik.enable=true"},
+			},
+		},
+	}
+	p.ctx.ServiceStacks = map[string]string{
+		"traefik": "proxy",
+		"grafana": "monitoring",
+	}
+	p.ctx.StackConfigs = map[string]*StackConfig{
+		"proxy":      {Name: "proxy", Category: "core"},
+		"monitoring": {Name: "monitoring", Category: "monitoring"},
+	}
+
+	p.AddStage(LabelServicesStage())
+
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	labels := compose.ServiceLabels(p.ctx.MergedCompose, "grafana")
+	if labels[compose.LabelStack] != "monitoring" {
+		t.Errorf("grafana stack label = %q, want monitoring", labels[compose.LabelStack])
+	}
+	if labels["traefik.enable"] != "true" {
+		t.Error("Existing labels should be preserved")
+	}
+
+	labels = compose.ServiceLabels(p.ctx.MergedCompose, "traefik")
+	if labels[compose.LabelCategory] != "core" {
+		t.Errorf("traefik category label = %q, want core", labels[compose.LabelCategory])
+	}
+}
+
+func TestConfigHashStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	if err := os.MkdirAll("runtime/monitoring", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("runtime/monitoring/grafana.ini", []byte("[server]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newContext := func() *Context {
+		return &Context{
+			EnabledStacks: []string{"monitoring", "proxy"},
+			Configs:       map[string][]string{"monitoring": {"runtime/monitoring/grafana.ini"}},
+			ServiceStacks: map[string]string{"grafana": "monitoring", "traefik": "proxy"},
+			MergedCompose: &compose.ComposeFile{Services: map[string]interface{}{
+				"grafana": map[string]interface{}{"image": "grafana/grafana"},
+				"traefik": map[string]interface{}{"image": "traefik"},
+			}},
+		}
+	}
+
+	ctx := newContext()
+	if err := ConfigHashStage()(ctx); err != nil {
+		t.Fatalf("ConfigHashStage() error = %v", err)
+	}
+
+	hash := compose.ServiceLabels(ctx.MergedCompose, "grafana")[compose.LabelConfigHash]
+	if hash == "" {
+		t.Fatal("grafana should carry a config hash label")
+	}
+	if _, ok := compose.ServiceLabels(ctx.MergedCompose, "traefik")[compose.LabelConfigHash]; ok {
+		t.Error("traefik renders no config and should have no config hash label")
+	}
+
+	manifest, err := LoadGeneratedManifest()
+	if err != nil {
+		t.Fatalf("LoadGeneratedManifest() error = %v", err)
+	}
+	stack := manifest.Stacks["monitoring"]
+	if stack.ConfigHash != hash || stack.Files["runtime/monitoring/grafana.ini"] == "" {
+		t.Errorf("unexpected manifest entry: %+v", stack)
+	}
+
+	// A config change changes the label, so compose recreates the containers
+	if err := os.WriteFile("runtime/monitoring/grafana.ini", []byte("[server]\nhttp_port = 3001\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx = newContext()
+	if err := ConfigHashStage()(ctx); err != nil {
+		t.Fatalf("ConfigHashStage() error = %v", err)
+	}
+	if compose.ServiceLabels(ctx.MergedCompose, "grafana")[compose.LabelConfigHash] == hash {
+		t.Error("config hash should change with the rendered config")
+	}
+}
+
+func TestStrictStage(t *testing.T) {
+	ctx := &Context{}
+	ctx.Warn("duplicate volume '%s'", "data")
+
+	if len(ctx.Warnings) != 1 || ctx.Warnings[0] != "duplicate volume 'data'" {
+		t.Fatalf("Warn() recorded %v", ctx.Warnings)
+	}
+
+	// Warnings only fail the pipeline in strict mode
+	if err := StrictStage(false)(ctx); err != nil {
+		t.Errorf("StrictStage(false) should ignore warnings, got %v", err)
+	}
+	if err := StrictStage(true)(ctx); err == nil {
+		t.Error("StrictStage(true) should fail when warnings were collected")
+	}
+	if err := StrictStage(true)(&Context{}); err != nil {
+		t.Errorf("StrictStage(true) should pass without warnings, got %v", err)
+	}
+}
+
+func TestContext_OutputPath(t *testing.T) {
+	ctx := &Context{}
+	if got := ctx.OutputPath("runtime/docker-compose.yml"); got != "runtime/docker-compose.yml" {
+		t.Errorf("OutputPath() without staging = %s", got)
+	}
+
+	ctx.StagingDir = "runtime/.staging"
+	if got := ctx.OutputPath("runtime/app/app.ini"); got != "runtime/.staging/app/app.ini" {
+		t.Errorf("OutputPath() with staging = %s", got)
+	}
+}
+
+func TestWriteOutputStage_Annotate(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	p := New()
+	p.ctx.StagingDir = ""
+	p.ctx.RenderedCompose = map[string]string{"monitoring": "runtime/monitoring-compose.yml"}
+	p.ctx.MergedCompose = &compose.ComposeFile{
+		Services:       map[string]interface{}{"grafana": map[string]interface{}{"image": "grafana/grafana"}},
+		Networks:       map[string]interface{}{"metrics": map[string]interface{}{}},
+		ServiceSources: map[string]string{"grafana": "runtime/monitoring-compose.yml"},
+		NetworkSources: map[string]string{"metrics": "runtime/monitoring-compose.yml"},
+	}
+
+	p.AddStage(WriteOutputStage(true))
+	if err := p.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	data, err := os.ReadFile("runtime/docker-compose.yml")
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+
+	want := "# From stack monitoring (stacks/monitoring/compose.yml.tmpl)"
+	if strings.Count(string(data), want) != 2 {
+		t.Errorf("Service and network should name their source stack:\n%s", data)
+	}
+}
+
+func TestRenderTemplates_SkipsDisabledServices(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	// Only templates of the disabled service: nothing reaches gomplate
+	files := map[string]string{
+		"stacks/monitoring/contribute/traefik/grafana.yml.tmpl": "http: {}\n",
+		"stacks/monitoring/config/grafana/grafana.ini.tmpl":     "[server]\n",
+		"stacks/monitoring/config/grafana.env.tmpl":             "A=1\n",
+		"runtime/traefik/dynamic/monitoring-grafana.yml":        "stale\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{
+		DisabledServices: map[string]bool{"monitoring/grafana": true},
+		StackConfigs: map[string]*StackConfig{
+			"monitoring": {Name: "monitoring", Services: []string{"grafana", "prometheus"}, Disabled: []string{"grafana"}},
+		},
+	}
+	templateCtx := TemplateContext(ctx.StackConfigs["monitoring"], []string{"monitoring"})
+
+	if err := renderContributions("monitoring", "traefik", templateCtx, ctx); err != nil {
+		t.Fatalf("renderContributions() error = %v", err)
+	}
+	if err := renderConfigs("monitoring", templateCtx, ctx); err != nil {
+		t.Fatalf("renderConfigs() error = %v", err)
+	}
+
+	want := []string{
+		"runtime/traefik/dynamic/monitoring-grafana.yml",
+		"runtime/monitoring/grafana/grafana.ini",
+		"runtime/monitoring/grafana.env",
+	}
+	if strings.Join(ctx.StaleOutputs, ",") != strings.Join(want, ",") {
+		t.Errorf("StaleOutputs = %v, want %v", ctx.StaleOutputs, want)
+	}
+
+	if services := templateCtx.Stack["services"].([]string); strings.Join(services, ",") != "prometheus" {
+		t.Errorf(".stack.services = %v, want [prometheus]", services)
+	}
+	if disabled := templateCtx.Stack["disabled_services"].([]string); strings.Join(disabled, ",") != "grafana" {
+		t.Errorf(".stack.disabled_services = %v, want [grafana]", disabled)
+	}
+}
+
+func TestRenderConfigs_ConfigOutputs(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	if err := render.SetEngine(render.EngineNative); err != nil {
+		t.Fatal(err)
+	}
+	defer render.SetEngine(render.EngineAuto)
+
+	files := map[string]string{
+		"stacks/dashboards/config/homepage/services.yaml.tmpl": "- {{ .stack.name }}\n",
+		"stacks/dashboards/config/app.env.tmpl":                "A=1\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{
+		EnabledStacks: []string{"dashboards"},
+		Configs:       make(map[string][]string),
+		StackConfigs: map[string]*StackConfig{
+			"dashboards": {
+				Name:     "dashboards",
+				Services: []string{"app"},
+				ConfigOutputs: map[string]string{
+					"homepage/services.yaml": "homepage/config/services.yaml",
+					"missing.yml":            "homepage/config/missing.yml",
+				},
+			},
+		},
+	}
+	templateCtx := TemplateContext(ctx.StackConfigs["dashboards"], ctx.EnabledStacks)
+
+	if err := renderConfigs("dashboards", templateCtx, ctx); err != nil {
+		t.Fatalf("renderConfigs() error = %v", err)
+	}
+
+	want := []string{"runtime/dashboards/app.env", "runtime/homepage/config/services.yaml"}
+	if strings.Join(ctx.Configs["dashboards"], ",") != strings.Join(want, ",") {
+		t.Errorf("Configs = %v, want %v", ctx.Configs["dashboards"], want)
+	}
+	if data, err := os.ReadFile("runtime/homepage/config/services.yaml"); err != nil || string(data) != "- dashboards\n" {
+		t.Errorf("overridden config = %q, %v", data, err)
+	}
+
+	if err := checkConfigOutputs(ctx); err != nil {
+		t.Fatalf("checkConfigOutputs() error = %v", err)
+	}
+	if len(ctx.Warnings) != 1 || !strings.Contains(ctx.Warnings[0], "missing.yml") {
+		t.Errorf("Warnings = %v, want the entry without template", ctx.Warnings)
+	}
+
+	// A contribution or a generated file at the same path
+	ctx.Contributions = map[string][]string{"homepage": {"runtime/homepage/config/services.yaml"}}
+	if err := checkConfigOutputs(ctx); err == nil || !strings.Contains(err.Error(), "also a contribution file of stack homepage") {
+		t.Errorf("checkConfigOutputs() error = %v, want the contribution overwritten", err)
+	}
+	ctx.Contributions = map[string][]string{}
+	if err := writeContribution(ctx, "homepage", "runtime/homepage/config/services.yaml", "x"); err == nil {
+		t.Error("writeContribution() should refuse to replace a redirected config file")
+	}
+
+	// Another stack rendering to the same path
+	ctx.Configs["homepage"] = []string{"runtime/homepage/config/services.yaml"}
+	if err := checkConfigOutputs(ctx); err == nil {
+		t.Error("checkConfigOutputs() should fail when two stacks render the same file")
+	}
+}
+
+func TestTemplateService(t *testing.T) {
+	config := &StackConfig{Services: []string{"grafana", "grafana-agent", "loki"}, Disabled: []string{"grafana"}}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"grafana.yml.tmpl", "grafana"},
+		{"grafana/grafana.ini.tmpl", "grafana"},
+		{"grafana-agent.yml.tmpl", ""},
+		{"loki.yaml.tmpl", ""},
+		{"routes.yml.tmpl", ""},
+		{"dashboards/grafana.json.tmpl", ""},
+	}
+
+	for _, tt := range tests {
+		if got := templateService(tt.path, config); got != tt.want {
+			t.Errorf("templateService(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	if got := templateService("grafana.yml.tmpl", nil); got != "" {
+		t.Errorf("templateService() without config = %q, want empty", got)
+	}
+}
+
+func TestContributionManifestStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	files := map[string]string{
+		// Enabled stack, rendered this run
+		"stacks/proxy/contribute/traefik/middlewares.yml.tmpl": "http: {}\n",
+		"runtime/traefik/dynamic/proxy-middlewares.yml":        "http: {}\n",
+		// Disabled stack whose output is still in runtime/
+		"stacks/media/contribute/traefik/jellyfin.yml.tmpl": "http: {}\n",
+		"runtime/traefik/dynamic/media-jellyfin.yml":        "http: {}\n",
+		// Deleted stack, only known from the previous manifest
+		"runtime/traefik/dynamic/old-routes.yml": "http: {}\n",
+		// Written by hand, never touched
+		"runtime/traefik/dynamic/custom.yml": "http: {}\n",
+		"runtime/.contributions.yaml":        "stacks:\n  old:\n    - runtime/traefik/dynamic/old-routes.yml\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{
+		StagingDir:    "runtime/.staging",
+		Contributions: map[string][]string{"proxy": {"runtime/traefik/dynamic/proxy-middlewares.yml"}},
+	}
+
+	if err := ContributionManifestStage()(ctx); err != nil {
+		t.Fatalf("ContributionManifestStage() error = %v", err)
+	}
+
+	want := "runtime/traefik/dynamic/media-jellyfin.yml,runtime/traefik/dynamic/old-routes.yml"
+	if got := strings.Join(ctx.StaleOutputs, ","); got != want {
+		t.Errorf("StaleOutputs = %s, want %s", got, want)
+	}
+
+	// The new manifest is staged with the other outputs
+	data, err := os.ReadFile("runtime/.staging/.contributions.yaml")
+	if err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+	if !strings.Contains(string(data), "proxy:\n        - runtime/traefik/dynamic/proxy-middlewares.yml") || strings.Contains(string(data), "old") {
+		t.Errorf("manifest = %s", data)
+	}
+}
+
+func TestContributionManifestStage_Sources(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	// Security contributions of auth and media, listed under the stack running crowdsec
+	manifest := `stacks:
+  security:
+    - runtime/security/crowdsec/acquis.d/auth.yaml
+    - runtime/security/crowdsec/acquis.d/media.yaml
+sources:
+  runtime/security/crowdsec/acquis.d/auth.yaml: auth
+  runtime/security/crowdsec/acquis.d/media.yaml: media
+`
+	for path, content := range map[string]string{
+		"runtime/.contributions.yaml":                   manifest,
+		"runtime/security/crowdsec/acquis.d/auth.yaml":  "source: file\n",
+		"runtime/security/crowdsec/acquis.d/media.yaml": "source: file\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// generate security media: media no longer contributes, auth is not rendered
+	ctx := &Context{
+		StagingDir:    "runtime/.staging",
+		StackFilter:   map[string]bool{"security": true, "media": true},
+		Contributions: map[string][]string{},
+	}
+	if err := ContributionManifestStage()(ctx); err != nil {
+		t.Fatalf("ContributionManifestStage() error = %v", err)
+	}
+
+	if got, want := strings.Join(ctx.StaleOutputs, ","), "runtime/security/crowdsec/acquis.d/media.yaml"; got != want {
+		t.Errorf("StaleOutputs = %s, want %s (auth's file kept)", got, want)
+	}
+	written, err := readContributionManifest("runtime/.staging/.contributions.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := contributionManifest{
+		Stacks:  map[string][]string{"security": {"runtime/security/crowdsec/acquis.d/auth.yaml"}},
+		Sources: map[string]string{"runtime/security/crowdsec/acquis.d/auth.yaml": "auth"},
+	}
+	if !reflect.DeepEqual(written, want) {
+		t.Errorf("manifest = %+v, want %+v", written, want)
+	}
+}
+
+func TestBuildContextStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	if err := os.WriteFile("inventory/builds.yaml", []byte("cache_from:\n  - type=registry,ref=registry.lan/cache/{service}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &Context{
+		ServiceStacks: map[string]string{"blog": "blog", "worker": "blog", "docs": "blog"},
+		MergedCompose: &compose.ComposeFile{Services: map[string]interface{}{
+			"blog":   map[string]interface{}{"build": "./app", "image": "blog:dev"},
+			"worker": map[string]interface{}{"build": map[string]interface{}{"context": "/srv/worker", "cache_from": []interface{}{"worker:cache"}}},
+			"docs":   map[string]interface{}{"build": "https://github.com/example/docs.git"},
+			"db":     map[string]interface{}{"image": "postgres"},
+		}},
+	}
+	if err := BuildContextStage()(ctx); err != nil {
+		t.Fatalf("BuildContextStage() error = %v", err)
+	}
+
+	build := func(svc string) map[string]interface{} {
+		return ctx.MergedCompose.Services[svc].(map[string]interface{})["build"].(map[string]interface{})
+	}
+
+	blog := build("blog")
+	if blog["context"] != filepath.Join("..", "stacks", "blog", "app") {
+		t.Errorf("blog context = %v, want relative to runtime/", blog["context"])
+	}
+	if cache, _ := blog["cache_from"].([]string); len(cache) != 1 || cache[0] != "type=registry,ref=registry.lan/cache/blog" {
+		t.Errorf("blog cache_from = %v, want the inventory setting", blog["cache_from"])
+	}
+
+	worker := build("worker")
+	if worker["context"] != "/srv/worker" {
+		t.Errorf("absolute context should be kept: %v", worker["context"])
+	}
+	if cache, _ := worker["cache_from"].([]interface{}); len(cache) != 1 || cache[0] != "worker:cache" {
+		t.Errorf("the service's own cache_from should be kept: %v", worker["cache_from"])
+	}
+
+	if docs := build("docs"); docs["context"] != "https://github.com/example/docs.git" {
+		t.Errorf("remote context should be kept: %v", docs["context"])
+	}
+	if _, ok := ctx.MergedCompose.Services["db"].(map[string]interface{})["build"]; ok {
+		t.Error("services without build should be left alone")
+	}
+}
+
+func TestRegistryMirrorStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	if err := os.WriteFile("inventory/registries.yaml", []byte("mirrors:\n  docker.io: cache.lan\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &Context{MergedCompose: &compose.ComposeFile{Services: map[string]interface{}{
+		"grafana": map[string]interface{}{"image": "grafana/grafana:11"},
+		"app":     map[string]interface{}{"image": "ghcr.io/home/app"},
+		"blog":    map[string]interface{}{"image": "blog", "build": "."},
+	}}}
+	if err := RegistryMirrorStage()(ctx); err != nil {
+		t.Fatalf("RegistryMirrorStage() error = %v", err)
+	}
+
+	images := compose.ServiceImages(ctx.MergedCompose, nil)
+	if images["grafana"] != "cache.lan/grafana/grafana:11" {
+		t.Errorf("grafana image = %s, want the mirror", images["grafana"])
+	}
+	if source := compose.ServiceLabels(ctx.MergedCompose, "grafana")[compose.LabelSourceImage]; source != "grafana/grafana:11" {
+		t.Errorf("source image label = %q, want the original", source)
+	}
+	if images["app"] != "ghcr.io/home/app" || images["blog"] != "blog" {
+		t.Errorf("images without mirror or built locally should be kept: %v", images)
+	}
+}
+
+func TestShareVolumesStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	if err := os.MkdirAll("stacks/media", 0755); err != nil {
+		t.Fatal(err)
+	}
+	stackYAML := "name: media\ncategory: media\nservices: [jellyfin]\n" +
+		"persistence:\n  shares:\n    media:\n      server: nas.lan\n      export: /volume1/media\n"
+	if err := os.WriteFile("stacks/media/stack.yaml", []byte(stackYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &Context{
+		EnabledStacks: []string{"media"},
+		MergedCompose: &compose.ComposeFile{Volumes: map[string]interface{}{"media": nil}},
+	}
+	if err := ShareVolumesStage()(ctx); err != nil {
+		t.Fatalf("ShareVolumesStage() error = %v", err)
+	}
+
+	volume, _ := ctx.MergedCompose.Volumes["media"].(map[string]interface{})
+	opts, _ := volume["driver_opts"].(map[string]interface{})
+	if volume["driver"] != "local" || opts["device"] != ":/volume1/media" || opts["o"] != "addr=nas.lan" {
+		t.Errorf("media volume = %v", volume)
+	}
+
+	// A template defining the volume itself conflicts with the share
+	ctx.MergedCompose = &compose.ComposeFile{
+		Volumes:       map[string]interface{}{"media": map[string]interface{}{"driver": "local"}},
+		VolumeSources: map[string]string{"media": "runtime/media/compose.yml"},
+	}
+	if err := ShareVolumesStage()(ctx); err == nil {
+		t.Error("ShareVolumesStage() should fail when a template also defines the volume")
+	}
+}
+
+func TestSecurityContributions(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"stacks/cloud/contribute/security/fail2ban/jail.d/nextcloud.conf.tmpl":   "[nextcloud]\nenabled = true\nlogpath = /srv/nextcloud/nextcloud.log\n          /srv/cloud/logs/*.log\n",
+		"stacks/cloud/contribute/security/crowdsec/acquis.d/nextcloud.yaml.tmpl": "filenames:\n  - /srv/nextcloud/nextcloud.log\nlabels:\n  type: nextcloud\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// crowdsec is not run by any enabled stack: its contribution is skipped
+	ctx := New().Context()
+	ctx.StagingDir = ""
+	ctx.EnabledStacks = []string{"security", "cloud"}
+	ctx.StackConfigs = map[string]*StackConfig{
+		"security": {Name: "security", Services: []string{"fail2ban"}},
+		"cloud":    {Name: "cloud", Services: []string{"nextcloud"}},
+	}
+	templateCtx := TemplateContext(ctx.StackConfigs["cloud"], ctx.EnabledStacks)
+
+	if err := renderSecurityContributions("cloud", templateCtx, ctx); err != nil {
+		t.Fatalf("renderSecurityContributions() error = %v", err)
+	}
+
+	jail := "runtime/security/fail2ban/jail.d/cloud-nextcloud.conf"
+	if _, err := os.Stat(jail); err != nil {
+		t.Errorf("jail not rendered: %v", err)
+	}
+	if got := strings.Join(ctx.Contributions["security"], ","); got != jail {
+		t.Errorf("Contributions[security] = %s, want %s", got, jail)
+	}
+	if _, err := os.Stat("runtime/security/crowdsec"); err == nil {
+		t.Error("crowdsec contribution should be skipped")
+	}
+
+	ctx.MergedCompose = &compose.ComposeFile{Services: map[string]interface{}{
+		"fail2ban": map[string]interface{}{"volumes": []interface{}{"/srv/cloud/logs:/srv/cloud/logs:ro"}},
+	}}
+	if err := SecurityLogsStage()(ctx); err != nil {
+		t.Fatalf("SecurityLogsStage() error = %v", err)
+	}
+
+	volumes := ctx.MergedCompose.Services["fail2ban"].(map[string]interface{})["volumes"].([]interface{})
+	want := []interface{}{"/srv/cloud/logs:/srv/cloud/logs:ro", "/srv/nextcloud:/srv/nextcloud:ro"}
+	if !reflect.DeepEqual(volumes, want) {
+		t.Errorf("fail2ban volumes = %v, want %v", volumes, want)
+	}
+}
+
+func TestLogPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		content string
+		want    []string
+	}{
+		{"jail", "jail.conf", "[app]\nlogpath = /var/log/app.log\nmaxretry = 3\n", []string{"/var/log/app.log"}},
+		{"jail continued", "jail.conf", "logpath = /a/x.log\n  /b/y.log\nport = 80\n", []string{"/a/x.log", "/b/y.log"}},
+		{"relative skipped", "jail.conf", "logpath = %(syslog_authpriv)s\n", nil},
+		{"acquisition", "acquis.yaml", "filename: /var/log/a.log\n---\nfilenames:\n  - /var/log/b/*.log\n", []string{"/var/log/a.log", "/var/log/b/*.log"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := logPaths(tt.path, []byte(tt.content)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("logPaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectStacks(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	ctx := New().Context()
+	ctx.EnabledStacks = []string{"media", "proxy"}
+
+	if err := SelectStacksStage([]string{"media"})(ctx); err == nil {
+		t.Error("SelectStacksStage() should fail without a generated compose file")
+	}
+
+	current := `services:
+  jellyfin:
+    image: jellyfin/jellyfin
+    labels:
+      homelabctl.stack: media
+  traefik:
+    image: traefik:v3
+    labels:
+      homelabctl.stack: proxy
+volumes:
+  media_data: {}
+  certs: {}
+`
+	if err := os.WriteFile("runtime/docker-compose.yml", []byte(current), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SelectStacksStage([]string{"cloud"})(ctx); err == nil {
+		t.Error("SelectStacksStage() should fail for a stack not enabled")
+	}
+	if err := SelectStacksStage([]string{"media"})(ctx); err != nil {
+		t.Fatalf("SelectStacksStage() error = %v", err)
+	}
+	if !ctx.Selected("media") || ctx.Selected("proxy") {
+		t.Errorf("StackFilter = %v, want media only", ctx.StackFilter)
+	}
+
+	// media is rendered again, with a new image and without its volume
+	ctx.MergedCompose = &compose.ComposeFile{Services: map[string]interface{}{
+		"jellyfin": map[string]interface{}{"image": "jellyfin/jellyfin:10.9"},
+	}}
+	if err := KeepUnselectedStage()(ctx); err != nil {
+		t.Fatalf("KeepUnselectedStage() error = %v", err)
+	}
+
+	services := ctx.MergedCompose.Services
+	if image := services["jellyfin"].(map[string]interface{})["image"]; image != "jellyfin/jellyfin:10.9" {
+		t.Errorf("jellyfin image = %v, want the rendered one", image)
+	}
+	if _, ok := services["traefik"]; !ok {
+		t.Error("traefik of unselected stack proxy should be kept")
+	}
+	if ctx.ServiceStacks["traefik"] != "proxy" {
+		t.Errorf("ServiceStacks[traefik] = %q, want proxy", ctx.ServiceStacks["traefik"])
+	}
+	if _, ok := ctx.MergedCompose.Volumes["certs"]; !ok {
+		t.Error("volume certs should be kept")
+	}
+
+	// A selected stack cannot take over a service of another stack
+	ctx.MergedCompose = &compose.ComposeFile{Services: map[string]interface{}{
+		"traefik": map[string]interface{}{"image": "traefik:v3"},
+	}}
+	if err := KeepUnselectedStage()(ctx); err == nil {
+		t.Error("KeepUnselectedStage() should fail on a service defined twice")
+	}
+}
+
+func TestExposeStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	if err := os.MkdirAll("stacks/media", 0755); err != nil {
+		t.Fatal(err)
+	}
+	stackYAML := "name: media\ncategory: media\nservices: [jellyfin, jellyseerr]\n" +
+		"expose:\n  jellyfin:\n    host: media\n    port: 8096\n  jellyseerr:\n    host: requests.example.org\n    port: 5055\n"
+	if err := os.WriteFile("stacks/media/stack.yaml", []byte(stackYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := New().Context()
+	ctx.StagingDir = ""
+	ctx.EnabledStacks = []string{"media"}
+	ctx.InventoryVars = map[string]interface{}{"domain": "home.lan"}
+	ctx.MergedCompose = &compose.ComposeFile{Services: map[string]interface{}{
+		"jellyfin":   map[string]interface{}{"labels": []interface{}{"traefik.http.routers.jellyfin.rule=Host(`tv.home.lan`)"}},
+		"jellyseerr": map[string]interface{}{},
+	}}
+
+	// Traefik (default): labels, without overriding the template's own
+	if err := ExposeStage()(ctx); err != nil {
+		t.Fatalf("ExposeStage() error = %v", err)
+	}
+	labels := compose.ServiceLabels(ctx.MergedCompose, "jellyfin")
+	if labels["traefik.http.routers.jellyfin.rule"] != "Host(`tv.home.lan`)" {
+		t.Errorf("template rule overridden: %s", labels["traefik.http.routers.jellyfin.rule"])
+	}
+	if labels["traefik.http.services.jellyfin.loadbalancer.server.port"] != "8096" {
+		t.Errorf("jellyfin labels = %v, want the loadbalancer port", labels)
+	}
+	if rule := compose.ServiceLabels(ctx.MergedCompose, "jellyseerr")["traefik.http.routers.jellyseerr.rule"]; rule != "Host(`requests.example.org`)" {
+		t.Errorf("jellyseerr rule = %q", rule)
+	}
+
+	// Caddy: a Caddyfile instead of labels, the npm file marked stale
+	ctx.InventoryVars["proxy"] = map[string]interface{}{"provider": "caddy"}
+	ctx.MergedCompose = &compose.ComposeFile{Services: map[string]interface{}{"jellyfin": map[string]interface{}{}}}
+	ctx.StaleOutputs = nil
+	if err := ExposeStage()(ctx); err != nil {
+		t.Fatalf("ExposeStage() error = %v", err)
+	}
+	data, err := os.ReadFile("runtime/proxy/Caddyfile")
+	if err != nil {
+		t.Fatalf("Caddyfile not written: %v", err)
+	}
+	if !strings.Contains(string(data), "media.home.lan {\n\treverse_proxy jellyfin:8096\n}") {
+		t.Errorf("Caddyfile =\n%s", data)
+	}
+	if labels := compose.ServiceLabels(ctx.MergedCompose, "jellyfin"); len(labels) != 0 {
+		t.Errorf("jellyfin labels = %v, want none with caddy", labels)
+	}
+	if !reflect.DeepEqual(ctx.StaleOutputs, []string{"runtime/proxy/npm-proxy-hosts.json"}) {
+		t.Errorf("StaleOutputs = %v", ctx.StaleOutputs)
+	}
+
+	// Short host names need the inventory domain
+	delete(ctx.InventoryVars, "domain")
+	if err := ExposeStage()(ctx); err == nil {
+		t.Error("ExposeStage() should fail without a domain for short host names")
+	}
+}
+
+func TestRemoteAccessStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"stacks/media/stack.yaml": "name: media\ncategory: media\nservices: [jellyfin, jellyseerr]\n" +
+			"expose:\n  jellyfin:\n    port: 8096\n    expose_via: tunnel\n  jellyseerr:\n    port: 5055\n    expose_via: tailscale\n",
+		"stacks/edge/stack.yaml": "name: edge\ncategory: core\nservices: [cloudflared]\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No stack runs tailscale: its route is skipped with a warning
+	ctx := New().Context()
+	ctx.StagingDir = ""
+	ctx.EnabledStacks = []string{"edge", "media"}
+	ctx.InventoryVars = map[string]interface{}{"domain": "home.lan"}
+	ctx.StackConfigs = map[string]*StackConfig{
+		"edge":  {Name: "edge", Services: []string{"cloudflared"}},
+		"media": {Name: "media", Services: []string{"jellyfin", "jellyseerr"}},
+	}
+
+	if err := RemoteAccessStage()(ctx); err != nil {
+		t.Fatalf("RemoteAccessStage() error = %v", err)
+	}
+
+	data, err := os.ReadFile("runtime/edge/cloudflared/config.yml")
+	if err != nil {
+		t.Fatalf("cloudflared config not written: %v", err)
+	}
+	if !strings.Contains(string(data), "hostname: jellyfin.home.lan") || strings.Contains(string(data), "jellyseerr") {
+		t.Errorf("cloudflared config =\n%s", data)
+	}
+	if got := strings.Join(ctx.Contributions["edge"], ","); got != "runtime/edge/cloudflared/config.yml" {
+		t.Errorf("Contributions[edge] = %s", got)
+	}
+	if len(ctx.Warnings) != 1 || !strings.Contains(ctx.Warnings[0], "tailscale") {
+		t.Errorf("Warnings = %v, want one about tailscale", ctx.Warnings)
+	}
+}
+
+func TestStartupOrderWarnings(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	for name, content := range map[string]string{
+		"database": "name: database\ncategory: core\nservices: [postgres]\n",
+		"cloud": "name: cloud\ncategory: apps\nservices: [nextcloud, cron, worker]\n" +
+			"startup_waivers:\n  worker: retries until postgres accepts connections\n",
+	} {
+		if err := os.MkdirAll("stacks/"+name, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile("stacks/"+name+"/stack.yaml", []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := &compose.ComposeFile{Services: map[string]interface{}{
+		"postgres": map[string]interface{}{"restart": "unless-stopped"},
+		// Waits for a healthy database
+		"nextcloud": map[string]interface{}{
+			"restart":     "unless-stopped",
+			"environment": map[string]interface{}{"POSTGRES_HOST": "postgres"},
+			"depends_on":  map[string]interface{}{"postgres": map[string]interface{}{"condition": "service_healthy"}},
+		},
+		// Only ordered after the database
+		"cron": map[string]interface{}{
+			"restart":    "always",
+			"depends_on": []interface{}{"postgres"},
+		},
+		// Waived
+		"worker": map[string]interface{}{
+			"restart":     "unless-stopped",
+			"environment": []interface{}{"DATABASE_URL=postgres://postgres:5432/cloud"},
+		},
+	}}
+	serviceStacks := map[string]string{"postgres": "database", "nextcloud": "cloud", "cron": "cloud", "worker": "cloud"}
+
+	warnings, err := StartupOrderWarnings(file, serviceStacks)
+	if err != nil {
+		t.Fatalf("StartupOrderWarnings() error = %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "'cron'") || !strings.Contains(warnings[0], "postgres (database)") {
+		t.Errorf("StartupOrderWarnings() = %v, want a warning for cron only", warnings)
+	}
+
+	// Without a boot restart policy, nothing restarts before the database
+	file.Services["cron"].(map[string]interface{})["restart"] = "no"
+	if warnings, _ := StartupOrderWarnings(file, serviceStacks); len(warnings) != 0 {
+		t.Errorf("StartupOrderWarnings() = %v, want none", warnings)
+	}
+}
+
+func TestPolicyViolations(t *testing.T) {
+	file := &compose.ComposeFile{Services: map[string]interface{}{
+		"wireguard": map[string]interface{}{"image": "linuxserver/wireguard", "privileged": true},
+		"postgres": map[string]interface{}{
+			"image": "postgres:16",
+			"ports": []interface{}{"5432:5432", "127.0.0.1:6000:6000"},
+		},
+		"redis": map[string]interface{}{
+			"image": "registry.lan/dockerhub/library/redis:7",
+			"ports": []interface{}{map[string]interface{}{"published": 6005, "target": 6379, "host_ip": "0.0.0.0"}},
+			"labels": map[string]interface{}{
+				"homelabctl.stack":        "database",
+				"homelabctl.source-image": "redis:7",
+			},
+		},
+		"sonarr":  map[string]interface{}{"image": "ghcr.io/linuxserver/sonarr"},
+		"plex":    map[string]interface{}{"image": "ghcr.io/plexinc/pms", "network_mode": "host"},
+		"builder": map[string]interface{}{"image": "app:local", "build": "."},
+		"adminer": map[string]interface{}{
+			"image": "adminer",
+			"ports": []interface{}{
+				"${ADMINER_PORT:-8080}:8080",
+				"127.0.0.1:${DEBUG_PORT}:9000",
+				map[string]interface{}{"published": "${ADMIN_PORT}", "target": 8081},
+			},
+		},
+	}}
+	serviceStacks := map[string]string{
+		"wireguard": "vpn", "postgres": "database", "sonarr": "media", "plex": "media", "builder": "app", "adminer": "database",
+	}
+	policies := &inventory.Policies{
+		ForbidPrivileged:  true,
+		ForbidPublicPorts: []string{"5432", "6000-6010"},
+		AllowedRegistries: []string{"docker.io", "ghcr.io/linuxserver/"},
+		Exemptions:        map[string][]string{"vpn": {"privileged"}},
+	}
+
+	violations, err := PolicyViolations(file, serviceStacks, policies)
+	if err != nil {
+		t.Fatalf("PolicyViolations() error = %v", err)
+	}
+	want := []string{
+		"[public_ports] service 'adminer' of stack database publishes port ${ADMINER_PORT:-8080}:8080 on every interface through a variable, which cannot be checked (use a literal port or bind it to a host IP)",
+		"[public_ports] service 'adminer' of stack database publishes port ${ADMIN_PORT} on every interface through a variable, which cannot be checked (use a literal port or bind it to a host IP)",
+		"[public_ports] service 'plex' of stack media uses network_mode: host, so every port it listens on is public (publish its ports instead)",
+		"[registries] service 'plex' of stack media pulls ghcr.io/plexinc/pms from a registry not in allowed_registries",
+		"[public_ports] service 'postgres' of stack database publishes port 5432/tcp on every interface (bind it to a host IP)",
+		"[public_ports] service 'redis' of stack database publishes port 6005/tcp on every interface (bind it to a host IP)",
+	}
+	if strings.Join(violations, "\n") != strings.Join(want, "\n") {
+		t.Errorf("PolicyViolations() =\n%s\nwant\n%s", strings.Join(violations, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without the exemption, the privileged VPN fails too
+	policies.Exemptions = nil
+	if err := CheckPolicies(file, serviceStacks, policies); err == nil || !strings.Contains(err.Error(), "7 policy violation(s)") {
+		t.Errorf("CheckPolicies() error = %v, want 7 violations", err)
+	}
+
+	policies.ForbidPublicPorts = []string{"http"}
+	if _, err := PolicyViolations(file, serviceStacks, policies); err == nil {
+		t.Error("PolicyViolations() should reject an invalid port")
+	}
+}
+
+func TestTemplateContextVersion(t *testing.T) {
+	config := &StackConfig{Name: "cloud", Services: []string{"nextcloud"}, ContextVersion: 1}
+	if got := TemplateContext(config, []string{"cloud"}).Version; got != 1 {
+		t.Errorf("Version = %d, want the stack's version 1", got)
+	}
+
+	// Configs built without a stack get the current structure
+	config.ContextVersion = 0
+	if got := TemplateContext(config, []string{"cloud"}).Version; got != render.ContextVersion {
+		t.Errorf("Version = %d, want %d", got, render.ContextVersion)
+	}
+}
+
+func TestTemplateContextStack(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	if err := os.MkdirAll("stacks/jellyfin", 0755); err != nil {
+		t.Fatal(err)
+	}
+	stackYAML := "name: jellyfin\ncategory: media\nrequires: [proxy]\ntags: [streaming]\nservices: [jellyfin]\nvars:\n  jellyfin:\n    port: 8096\n"
+	if err := os.WriteFile("stacks/jellyfin/stack.yaml", []byte(stackYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := BuildStackConfig("jellyfin", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("BuildStackConfig() error = %v", err)
+	}
+	stack := TemplateContext(config, []string{"proxy", "jellyfin"}).Stack
+
+	if stack["category"] != "media" {
+		t.Errorf("category = %v, want media", stack["category"])
+	}
+	if !reflect.DeepEqual(stack["requires"], []string{"proxy"}) || !reflect.DeepEqual(stack["tags"], []string{"streaming"}) {
+		t.Errorf("requires = %v, tags = %v", stack["requires"], stack["tags"])
+	}
+	if defaults := stack["category_defaults"].(map[string]interface{}); defaults["restart"] != "unless-stopped" {
+		t.Errorf("category_defaults = %v, want the media defaults", defaults)
+	}
+}
+
+func TestBuildStackConfig_SandboxedConfigOutputs(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"stacks/immich/stack.yaml": "name: immich\ncategory: media\nservices: [immich]\nvars:\n  immich: {}\n" +
+			"config_outputs:\n  app.env: traefik/app.env\n",
+		"stacks.lock":             "stacks:\n  immich:\n    catalog: community\n    source: https://example.com/stacks.git\n",
+		"inventory/catalogs.yaml": "catalogs:\n  community: https://example.com/stacks.git\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A stack of an untrusted catalog may not write into other stacks' directories
+	if _, err := BuildStackConfig("immich", map[string]interface{}{}); err == nil || !strings.Contains(err.Error(), "untrusted catalog") {
+		t.Errorf("BuildStackConfig() error = %v, want the redirect refused", err)
+	}
+}
+
+func TestContributionIndexStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"runtime/traefik/dynamic/media-jellyfin.yml":             "http: {}\n",
+		"runtime/contributions/homepage/media-jellyfin.yaml":     "- Media:\n    - Jellyfin: {href: https://jellyfin.test.local}\n",
+		"runtime/contributions/homepage/monitoring-grafana.yaml": "- Monitoring:\n    - Grafana: {href: https://grafana.test.local}\n",
+		"runtime/contributions/homepage/monitoring-notes.txt":    "not yaml\n",
+		// Index of a provider nothing contributes to anymore
+		"runtime/contributions/glance.index.yaml": "provider: glance\n",
+		"runtime/.contributions.yaml": `stacks:
+  media:
+    - runtime/contributions/homepage/media-jellyfin.yaml
+    - runtime/traefik/dynamic/media-jellyfin.yml
+  monitoring:
+    - runtime/contributions/homepage/monitoring-grafana.yaml
+    - runtime/contributions/homepage/monitoring-notes.txt
+  auth:
+    - runtime/auth/fail2ban/jail.d/auth-authelia.conf
+`,
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{InventoryVars: map[string]interface{}{
+		"contributions": map[string]interface{}{"combine": []interface{}{"homepage"}},
+	}}
+	if err := ContributionIndexStage()(ctx); err != nil {
+		t.Fatalf("ContributionIndexStage() error = %v", err)
+	}
+
+	data, err := os.ReadFile("runtime/contributions/homepage.index.yaml")
+	if err != nil {
+		t.Fatalf("homepage index not written: %v", err)
+	}
+	want := `# Generated by homelabctl - files contributed to homepage
+provider: homepage
+dir: runtime/contributions/homepage
+combined: runtime/contributions/homepage.yaml
+files:
+    - path: runtime/contributions/homepage/media-jellyfin.yaml
+      stack: media
+    - path: runtime/contributions/homepage/monitoring-grafana.yaml
+      stack: monitoring
+    - path: runtime/contributions/homepage/monitoring-notes.txt
+      stack: monitoring
+`
+	if string(data) != want {
+		t.Errorf("homepage index =\n%s\nwant\n%s", data, want)
+	}
+
+	// Lists are concatenated in path order; the text file is left out
+	data, err = os.ReadFile("runtime/contributions/homepage.yaml")
+	if err != nil {
+		t.Fatalf("combined homepage file not written: %v", err)
+	}
+	if !strings.Contains(string(data), "contributions of media, monitoring to homepage") ||
+		strings.Index(string(data), "Jellyfin") > strings.Index(string(data), "Grafana") {
+		t.Errorf("combined homepage file =\n%s", data)
+	}
+	if len(ctx.Warnings) != 1 || !strings.Contains(ctx.Warnings[0], "monitoring-notes.txt is not YAML") {
+		t.Errorf("Warnings = %v, want the text file left out", ctx.Warnings)
+	}
+
+	// Traefik is indexed, not combined; security contributions are not indexed
+	if _, err := os.Stat("runtime/contributions/traefik.index.yaml"); err != nil {
+		t.Errorf("traefik index not written: %v", err)
+	}
+	if _, err := os.Stat("runtime/contributions/traefik.yaml"); err == nil {
+		t.Error("traefik contributions should not be combined")
+	}
+	if strings.Join(ctx.StaleOutputs, ",") != "runtime/contributions/glance.index.yaml" {
+		t.Errorf("StaleOutputs = %v, want the glance index", ctx.StaleOutputs)
+	}
+}
+
+func TestMergeContribution(t *testing.T) {
+	merged, err := mergeContribution(
+		map[string]interface{}{"http": map[string]interface{}{"routers": map[string]interface{}{"a": 1}}, "version": 1},
+		map[string]interface{}{"http": map[string]interface{}{"routers": map[string]interface{}{"b": 2}}, "version": 1},
+		"",
+	)
+	if err != nil {
+		t.Fatalf("mergeContribution() error = %v", err)
+	}
+	routers := merged.(map[string]interface{})["http"].(map[string]interface{})["routers"].(map[string]interface{})
+	if len(routers) != 2 {
+		t.Errorf("routers = %v, want a and b", routers)
+	}
+
+	if _, err := mergeContribution(map[string]interface{}{"version": 1}, map[string]interface{}{"version": 2}, ""); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("mergeContribution() error = %v, want a conflict on version", err)
+	}
+	if _, err := mergeContribution([]interface{}{1}, map[string]interface{}{}, ""); err == nil {
+		t.Error("mergeContribution() should fail on a list and a mapping")
+	}
+}
+
+func TestPermissionsStage(t *testing.T) {
+	tmpDir, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	dataDir := filepath.Join(tmpDir, "data", "grafana")
+	outsideDir := filepath.Join(tmpDir, "etc", "grafana")
+	files := map[string]string{
+		"stacks/monitoring/stack.yaml": "name: monitoring\ncategory: monitoring\nservices: [grafana]\n" +
+			"persistence:\n  paths: [" + dataDir + ", " + outsideDir + "]\n" +
+			"permissions:\n" +
+			"  configs:\n    grafana: {mode: \"0640\", uid: " + strconv.Itoa(os.Getuid()) + "}\n" +
+			"  paths:\n    " + dataDir + ": {mode: \"0750\"}\n    " + outsideDir + ": {mode: \"0750\"}\n" +
+			"  secrets:\n    admin.password: {mode: \"0440\"}\n    unused: {mode: \"0440\"}\n",
+		".staging/monitoring/grafana/grafana.ini":           "[server]\n",
+		".staging/.secrets/monitoring/admin.password":       "s3cret",
+		".staging/monitoring/app.env":                       "A=1\n",
+		"stacks/monitoring/config/grafana/grafana.ini.tmpl": "[server]\n",
+		"stacks/monitoring/config/app.env.tmpl":             "A=1\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{
+		EnabledStacks: []string{"monitoring"},
+		StagingDir:    ".staging",
+		InventoryVars: map[string]interface{}{"persistence": map[string]interface{}{"root": filepath.Join(tmpDir, "data")}},
+		Configs: map[string][]string{
+			"monitoring": {"runtime/monitoring/app.env", "runtime/monitoring/grafana/grafana.ini", "runtime/.secrets/monitoring/admin.password"},
+		},
+		StackConfigs: map[string]*StackConfig{"monitoring": {Name: "monitoring", Services: []string{"grafana"}}},
+	}
+	if err := PermissionsStage()(ctx); err != nil {
+		t.Fatalf("PermissionsStage() error = %v", err)
+	}
+	if len(ctx.Warnings) != 1 || !strings.Contains(ctx.Warnings[0], "not under persistence.root") {
+		t.Errorf("Warnings = %v, want the path outside persistence.root reported", ctx.Warnings)
+	}
+	if _, err := os.Stat(outsideDir); !os.IsNotExist(err) {
+		t.Errorf("path outside persistence.root was created")
+	}
+
+	modes := map[string]os.FileMode{
+		".staging/monitoring/grafana/grafana.ini":     0640,
+		".staging/monitoring/grafana":                 0750, // The directory too, searchable
+		".staging/monitoring/app.env":                 0644, // No permission declared
+		".staging/.secrets/monitoring/admin.password": 0440, // Mounted compose secret
+		dataDir: 0750, // Created
+	}
+	for path, want := range modes {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", path, err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("mode of %s = %o, want %o", path, info.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestPermissionsStage_Sandboxed(t *testing.T) {
+	tmpDir, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	dataDir := filepath.Join(tmpDir, "data", "immich")
+	stackYAML := "name: immich\ncategory: apps\nservices: [immich]\n" +
+		"persistence:\n  paths: [" + dataDir + "]\n" +
+		"permissions:\n  paths:\n    " + dataDir + ": {mode: \"0777\"}\n"
+	if err := os.MkdirAll("stacks/immich", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("stacks/immich/stack.yaml", []byte(stackYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &Context{
+		EnabledStacks: []string{"immich"},
+		InventoryVars: map[string]interface{}{"persistence": map[string]interface{}{"root": tmpDir}},
+		StackConfigs:  map[string]*StackConfig{"immich": {Name: "immich", Sandboxed: true}},
+	}
+	if err := PermissionsStage()(ctx); err != nil {
+		t.Fatalf("PermissionsStage() error = %v", err)
+	}
+	if len(ctx.Warnings) != 1 || !strings.Contains(ctx.Warnings[0], "untrusted catalog") {
+		t.Errorf("Warnings = %v, want the untrusted stack's permissions ignored", ctx.Warnings)
+	}
+	if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+		t.Error("persistence path of an untrusted stack was created")
+	}
+}
+
+func TestRenderComposeVariants(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	if err := render.SetEngine(render.EngineNative); err != nil {
+		t.Fatal(err)
+	}
+	defer render.SetEngine(render.EngineAuto)
+
+	files := map[string]string{
+		"runtime/media-compose.yml":             "services:\n  jellyfin:\n    image: jellyfin/jellyfin\n",
+		"stacks/media/compose.gpu.yml.tmpl":     "services:\n  jellyfin:\n    devices: [/dev/dri]\n",
+		"stacks/media/compose.workers.yml.tmpl": "services:\n  {{ .stack.name }}-worker:\n    image: worker\n",
+		"stacks/media/compose.vpn.yml.tmpl":     "services:\n  gluetun:\n    image: qmcgaw/gluetun\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{
+		EnabledStacks:   []string{"media"},
+		Environment:     "prod",
+		RenderedCompose: map[string]string{"media": "runtime/media-compose.yml"},
+		StackConfigs: map[string]*StackConfig{
+			"media": {
+				Name:         "media",
+				Services:     []string{"jellyfin"},
+				FilteredVars: map[string]interface{}{"jellyfin": map[string]interface{}{"gpu": true}},
+				ComposeVariants: map[string]stacks.ComposeVariant{
+					"gpu": {When: "jellyfin.gpu"},
+					"vpn": {Environments: []string{"staging"}},
+				},
+			},
+		},
+	}
+	templateCtx := TemplateContext(ctx.StackConfigs["media"], ctx.EnabledStacks)
+
+	if err := renderComposeVariants("media", templateCtx, ctx); err != nil {
+		t.Fatalf("renderComposeVariants() error = %v", err)
+	}
+
+	merged, err := compose.LoadComposeFile("runtime/media-compose.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jellyfin, _ := merged.Services["jellyfin"].(map[string]interface{})
+	if jellyfin["image"] != "jellyfin/jellyfin" || jellyfin["devices"] == nil {
+		t.Errorf("jellyfin = %v, want the base image and the gpu variant's devices", jellyfin)
+	}
+	if _, ok := merged.Services["media-worker"]; !ok {
+		t.Error("a variant without selector should always be merged")
+	}
+	if _, ok := merged.Services["gluetun"]; ok {
+		t.Error("the vpn variant should be skipped outside staging")
+	}
+	if len(ctx.RenderedFiles) != 2 {
+		t.Errorf("RenderedFiles = %v, want the two rendered variants for cleanup", ctx.RenderedFiles)
+	}
+}
+
+func TestHooksStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"stacks/media/stack.yaml": "name: media\ncategory: media\nservices: [jellyfin]\n" +
+			"hooks:\n  pre-generate: echo \"$HOMELAB_VAR_DOMAIN\" > prepared\n  post-generate: exit 1\n",
+		"stacks/media/compose.yml.tmpl": "services: {}\n",
+		"stacks/other/stack.yaml":       "name: other\ncategory: tools\nservices: [app]\nhooks:\n  pre-generate: touch ran\n",
+		"stacks/other/compose.yml.tmpl": "services: {}\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{
+		EnabledStacks: []string{"media", "other"},
+		StackFilter:   map[string]bool{"media": true},
+		StackConfigs: map[string]*StackConfig{
+			"media": {Name: "media", MergedVars: map[string]interface{}{"domain": "test.local"}},
+		},
+	}
+	if err := HooksStage(stacks.HookPreGenerate)(ctx); err != nil {
+		t.Fatalf("HooksStage(pre-generate) error = %v", err)
+	}
+	if data, err := os.ReadFile("stacks/media/prepared"); err != nil || strings.TrimSpace(string(data)) != "test.local" {
+		t.Errorf("pre-generate hook wrote %q, %v; want the merged domain", data, err)
+	}
+	if _, err := os.Stat("stacks/other/ran"); err == nil {
+		t.Error("HooksStage() should skip stacks that are not selected")
+	}
+
+	err := HooksStage(stacks.HookPostGenerate)(ctx)
+	if err == nil || !strings.Contains(err.Error(), "post-generate hook of stack media failed") {
+		t.Errorf("HooksStage(post-generate) error = %v, want the failing hook", err)
+	}
+}
+
+func TestInsertPlugins(t *testing.T) {
+	noop := func(*Context) error { return nil }
+	stages := []NamedStage{{"load-stacks", noop}, {"merge-variables", noop}, {"render-templates", noop}, {"cleanup", noop}}
+	plugins := []*inventory.Plugin{
+		{Name: "audit", Before: "cleanup"},
+		{Name: "secrets-a", After: "merge-variables", Plan: true},
+		{Name: "secrets-b", After: "merge-variables", Plan: true},
+		{Name: "validate-secrets", After: "secrets-b", Plan: true},
+	}
+
+	names := func(stages []NamedStage) string {
+		var out []string
+		for _, stage := range stages {
+			out = append(out, stage.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	got, err := InsertPlugins(stages, plugins, true)
+	if err != nil {
+		t.Fatalf("InsertPlugins() error = %v", err)
+	}
+	want := "load-stacks,merge-variables,secrets-a,secrets-b,validate-secrets,render-templates,audit,cleanup"
+	if names(got) != want {
+		t.Errorf("InsertPlugins() = %s, want %s", names(got), want)
+	}
+
+	got, err = InsertPlugins(stages, plugins, false)
+	if err != nil {
+		t.Fatalf("InsertPlugins(plan) error = %v", err)
+	}
+	if strings.Contains(names(got), "audit") {
+		t.Errorf("InsertPlugins(plan) = %s, want plugins without plan skipped", names(got))
+	}
+
+	_, err = InsertPlugins(stages, []*inventory.Plugin{{Name: "x", After: "render"}}, true)
+	if err == nil || !strings.Contains(err.Error(), "unknown stage 'render'") {
+		t.Errorf("InsertPlugins() error = %v, want the unknown stage", err)
+	}
+
+	// A plugin may name one that comes later in name order
+	got, err = InsertPlugins(stages, []*inventory.Plugin{
+		{Name: "a-report", After: "z-collect"},
+		{Name: "m-check", Before: "a-report"},
+		{Name: "z-collect", After: "render-templates"},
+	}, true)
+	if err != nil {
+		t.Fatalf("InsertPlugins() error = %v", err)
+	}
+	want = "load-stacks,merge-variables,render-templates,z-collect,m-check,a-report,cleanup"
+	if names(got) != want {
+		t.Errorf("InsertPlugins() = %s, want %s", names(got), want)
+	}
+
+	_, err = InsertPlugins(stages, []*inventory.Plugin{
+		{Name: "a", After: "b"},
+		{Name: "b", Before: "a"},
+	}, true)
+	if err == nil || !strings.Contains(err.Error(), "cycle: a -> b -> a") {
+		t.Errorf("InsertPlugins() error = %v, want the cycle", err)
+	}
+
+	_, err = InsertPlugins(stages, []*inventory.Plugin{
+		{Name: "a", After: "b"},
+		{Name: "b", After: "render"},
+	}, true)
+	if err == nil || !strings.Contains(err.Error(), "plugin b in") || !strings.Contains(err.Error(), "unknown stage 'render'") {
+		t.Errorf("InsertPlugins() error = %v, want the unknown stage of b", err)
+	}
+}
+
+func TestPluginStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	script := "#!/bin/sh\n" +
+		"input=$(cat)\n" +
+		"echo \"$input\" > plugin-input.json\n" +
+		"echo '{\"vars\": {\"media\": {\"api_key\": \"from-vault\"}}, \"warnings\": [\"token expires soon\"]}'\n"
+	if err := os.MkdirAll("plugins", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("plugins/vault", []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &Context{
+		EnabledStacks: []string{"media"},
+		StagingDir:    ".staging",
+		StackConfigs: map[string]*StackConfig{
+			"media": {Name: "media", Category: "media", MergedVars: map[string]interface{}{"domain": "test.local"}},
+		},
+	}
+	plugin := &inventory.Plugin{Name: "vault", Command: "plugins/vault", After: "merge-variables"}
+	if err := PluginStage(plugin)(ctx); err != nil {
+		t.Fatalf("PluginStage() error = %v", err)
+	}
+
+	if got := ctx.StackConfigs["media"].MergedVars["api_key"]; got != "from-vault" {
+		t.Errorf("api_key = %v, want the plugin's value", got)
+	}
+	if len(ctx.Warnings) != 1 || ctx.Warnings[0] != "plugin vault: token expires soon" {
+		t.Errorf("Warnings = %v, want the plugin's warning", ctx.Warnings)
+	}
+	input, err := os.ReadFile("plugin-input.json")
+	if err != nil || !strings.Contains(string(input), `"domain":"test.local"`) || !strings.Contains(string(input), `"selected":true`) {
+		t.Errorf("plugin input = %s, %v; want the stack with its variables", input, err)
+	}
+
+	if err := os.WriteFile("plugins/vault", []byte("#!/bin/sh\nexit 2\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := PluginStage(plugin)(ctx); err == nil || !strings.Contains(err.Error(), "plugin vault failed") {
+		t.Errorf("PluginStage() error = %v, want the failing plugin", err)
+	}
+}
+
+func TestComposeSecretsStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	files := map[string]string{
+		"stacks/cloud/stack.yaml": "name: cloud\ncategory: apps\nservices: [nextcloud, db]\n" +
+			"secret_files:\n  NEXTCLOUD_ADMIN_PASSWORD: inline\n",
+		"inventory/secrets.yaml":            "render: compose\n",
+		"runtime/.secrets/cloud/db.removed": "old",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{
+		EnabledStacks: []string{"cloud"},
+		StackConfigs: map[string]*StackConfig{"cloud": {Name: "cloud", Secrets: map[string]interface{}{
+			"db": map[string]interface{}{"password": "s3cret", "port": 5432},
+		}}},
+		ServiceStacks: map[string]string{"nextcloud": "cloud", "db": "cloud"},
+		Configs:       make(map[string][]string),
+		StagingDir:    "runtime/.staging",
+		MergedCompose: &compose.ComposeFile{Services: map[string]interface{}{
+			"db": map[string]interface{}{
+				"environment": map[string]interface{}{"POSTGRES_PASSWORD": "s3cret", "PGPORT": 5432},
+				"healthcheck": map[string]interface{}{"test": []interface{}{"CMD", "pg_isready", "--password=s3cret"}},
+			},
+			"nextcloud": map[string]interface{}{
+				"environment": []interface{}{
+					"POSTGRES_PASSWORD=s3cret",
+					"NEXTCLOUD_ADMIN_PASSWORD=s3cret",
+					"DATABASE_URL=postgres://nextcloud:s3cret@db/nextcloud",
+				},
+				"labels": map[string]interface{}{"backup.password": "s3cret"},
+			},
+		}},
+	}
+	if err := ComposeSecretsStage()(ctx); err != nil {
+		t.Fatalf("ComposeSecretsStage() error = %v", err)
+	}
+
+	db := ctx.MergedCompose.Services["db"].(map[string]interface{})
+	wantEnv := map[string]interface{}{"POSTGRES_PASSWORD_FILE": "/run/secrets/cloud_db_password", "PGPORT": 5432}
+	if !reflect.DeepEqual(db["environment"], wantEnv) {
+		t.Errorf("db environment = %v, want %v", db["environment"], wantEnv)
+	}
+	if !reflect.DeepEqual(db["secrets"], []interface{}{"cloud_db_password"}) {
+		t.Errorf("db secrets = %v, want cloud_db_password", db["secrets"])
+	}
+
+	nextcloud := ctx.MergedCompose.Services["nextcloud"].(map[string]interface{})
+	wantList := []interface{}{
+		"POSTGRES_PASSWORD_FILE=/run/secrets/cloud_db_password",
+		"NEXTCLOUD_ADMIN_PASSWORD=s3cret", // inline in secret_files
+		"DATABASE_URL=postgres://nextcloud:s3cret@db/nextcloud",
+	}
+	if !reflect.DeepEqual(nextcloud["environment"], wantList) {
+		t.Errorf("nextcloud environment = %v, want %v", nextcloud["environment"], wantList)
+	}
+
+	want := map[string]interface{}{"cloud_db_password": map[string]interface{}{"file": "./.secrets/cloud/db.password"}}
+	if !reflect.DeepEqual(ctx.MergedCompose.Secrets, want) {
+		t.Errorf("secrets = %v, want %v", ctx.MergedCompose.Secrets, want)
+	}
+	data, err := os.ReadFile("runtime/.staging/.secrets/cloud/db.password")
+	if err != nil || string(data) != "s3cret" {
+		t.Errorf("secret file = %q, %v; want the secret", data, err)
+	}
+	if !reflect.DeepEqual(ctx.Configs["cloud"], []string{"runtime/.secrets/cloud/db.password"}) {
+		t.Errorf("Configs = %v, want the secret file hashed", ctx.Configs["cloud"])
+	}
+	if !reflect.DeepEqual(ctx.StaleOutputs, []string{"runtime/.secrets/cloud/db.removed"}) {
+		t.Errorf("StaleOutputs = %v, want the file of the removed secret", ctx.StaleOutputs)
+	}
+	// Secrets left in the compose file, in other fields or as numbers, are reported
+	wantWarnings := []string{
+		"service db: healthcheck.test holds secret db.password",
+		"service db: PGPORT holds secret db.port of stack cloud, which is not a string",
+		"service nextcloud: labels.backup.password holds secret db.password",
+		"service nextcloud: DATABASE_URL embeds secret db.password",
+	}
+	if len(ctx.Warnings) != len(wantWarnings) {
+		t.Fatalf("Warnings = %v, want %d", ctx.Warnings, len(wantWarnings))
+	}
+	for i, want := range wantWarnings {
+		if !strings.Contains(ctx.Warnings[i], want) {
+			t.Errorf("Warnings[%d] = %q, want %q", i, ctx.Warnings[i], want)
+		}
+	}
+}
+
+func TestScanSecretsStage(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	files := map[string]struct {
+		content string
+		mode    os.FileMode
+	}{
+		"runtime/.staging/docker-compose.yml":         {"services:\n  db:\n    environment:\n      POSTGRES_PASSWORD: s3cret-db\n", 0644},
+		"runtime/.staging/cloud/config.php":           {"'dbpassword' => 's3cret-db',\n", 0600},
+		"runtime/.staging/cloud/nginx.conf":           {"token s3cret-api;\n", 0644},
+		"runtime/.staging/.secrets/cloud/db.password": {"s3cret-db", 0600},
+	}
+	for path, f := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(f.content), f.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newContext := func() *Context {
+		return &Context{
+			EnabledStacks: []string{"cloud"},
+			StackConfigs: map[string]*StackConfig{"cloud": {Name: "cloud", Secrets: map[string]interface{}{
+				"db":  map[string]interface{}{"password": "s3cret-db", "port": "5432"},
+				"api": map[string]interface{}{"token": "s3cret-api"},
+			}}},
+			Configs:    map[string][]string{"cloud": {"runtime/cloud/config.php", "runtime/cloud/nginx.conf", "runtime/.secrets/cloud/db.password"}},
+			StagingDir: "runtime/.staging",
+		}
+	}
+
+	ctx := newContext()
+	if err := ScanSecretsStage(false)(ctx); err != nil {
+		t.Fatalf("ScanSecretsStage() error = %v", err)
+	}
+	want := []string{
+		"runtime/docker-compose.yml (mode 0644) contains secret db.password of stack cloud",
+		"runtime/cloud/nginx.conf (mode 0644) contains secret api.token of stack cloud",
+	}
+	if !reflect.DeepEqual(ctx.Warnings, want) {
+		t.Errorf("warnings = %v, want %v", ctx.Warnings, want)
+	}
+
+	ctx = newContext()
+	err := ScanSecretsStage(true)(ctx)
+	if err == nil || !strings.Contains(err.Error(), "2 secret value(s) in world-readable files") {
+		t.Errorf("ScanSecretsStage(strict) error = %v, want the leaks", err)
+	}
+	if len(ctx.Warnings) != 0 {
+		t.Errorf("warnings = %v with strict, want an error instead", ctx.Warnings)
+	}
+}
+
+func TestCleanupStage_DefaultRetention(t *testing.T) {
+	_, cleanup := setupPipelineTest(t)
+	defer cleanup()
+
+	for _, id := range []string{"20240101-000000", "20240102-000000", "20240103-000000"} {
+		snapshot := history.NewSnapshot()
+		snapshot.ID = id
+		if err := history.Record(snapshot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := &Context{}
+	retention := history.Retention{Keep: 2, DefaultKeep: true}
+	if err := CleanupStage(false, &retention)(ctx); err != nil {
+		t.Fatalf("CleanupStage() error = %v", err)
+	}
+	if len(ctx.Warnings) != 1 || !strings.Contains(ctx.Warnings[0], "keeps the latest 2 generations by default") {
+		t.Errorf("warnings = %v, want the default keep reported", ctx.Warnings)
+	}
+
+	// Reported once
+	snapshot := history.NewSnapshot()
+	snapshot.ID = "20240104-000000"
+	if err := history.Record(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	ctx = &Context{}
+	if err := CleanupStage(false, &retention)(ctx); err != nil {
+		t.Fatalf("CleanupStage() error = %v", err)
+	}
+	if len(ctx.Warnings) != 0 {
+		t.Errorf("warnings = %v, want the default keep reported only once", ctx.Warnings)
+	}
+}
@@ -0,0 +1,213 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// pluginRequest is the JSON document a plugin reads on stdin
+type pluginRequest struct {
+	Plugin      string                 `json:"plugin"`
+	Environment string                 `json:"environment"`
+	StagingDir  string                 `json:"staging_dir"` // Files written here are committed to runtime/
+	Stacks      map[string]pluginStack `json:"stacks"`
+}
+
+// pluginStack is an enabled stack as plugins see it; vars are empty before
+// merge-variables
+type pluginStack struct {
+	Category string                 `json:"category,omitempty"`
+	Services []string               `json:"services,omitempty"`
+	Selected bool                   `json:"selected"`
+	Vars     map[string]interface{} `json:"vars,omitempty"`
+}
+
+// pluginResponse is the JSON document a plugin may print on stdout
+type pluginResponse struct {
+	Vars     map[string]map[string]interface{} `json:"vars"`     // Stack -> top-level variables to set
+	Warnings []string                          `json:"warnings"` // Reported with the run's warnings
+}
+
+// PluginStage runs a plugin executable as a stage: it reads the enabled stacks as JSON
+// on stdin and may answer with variables to set and warnings on stdout; its stderr is
+// shown as is and a non-zero exit fails the run
+func PluginStage(plugin *inventory.Plugin) Stage {
+	return func(ctx *Context) error {
+		ui.Step("Running plugin %s...", plugin.Name)
+
+		request := pluginRequest{
+			Plugin:      plugin.Name,
+			Environment: ctx.Environment,
+			StagingDir:  ctx.StagingDir,
+			Stacks:      make(map[string]pluginStack, len(ctx.EnabledStacks)),
+		}
+		for _, stackName := range ctx.EnabledStacks {
+			stack := pluginStack{Selected: ctx.Selected(stackName)}
+			if config, ok := ctx.StackConfigs[stackName]; ok {
+				stack.Category = config.Category
+				stack.Services = config.Services
+				stack.Vars = config.MergedVars
+			}
+			request.Stacks[stackName] = stack
+		}
+		input, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("failed to encode the input of plugin %s: %w", plugin.Name, err)
+		}
+
+		var stdout bytes.Buffer
+		cmd := exec.Command(plugin.Command, plugin.Args...)
+		cmd.Env = append(os.Environ(), "HOMELAB_PLUGIN="+plugin.Name, "HOMELAB_STAGING_DIR="+ctx.StagingDir)
+		cmd.Stdin = bytes.NewReader(input)
+		cmd.Stdout = &stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return errors.New(
+				fmt.Sprintf("plugin %s failed: %v", plugin.Name, err),
+				fmt.Sprintf("Check the executable %s, or the entry of %s in %s", plugin.Command, plugin.Name, paths.InventoryPlugins),
+			).WithContext("Command: " + strings.Join(append([]string{plugin.Command}, plugin.Args...), " "))
+		}
+
+		if strings.TrimSpace(stdout.String()) == "" {
+			return nil
+		}
+		var response pluginResponse
+		if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+			return errors.New(
+				fmt.Sprintf("plugin %s printed invalid JSON: %v", plugin.Name, err),
+				"Plugins answer with a JSON object ({\"vars\": ..., \"warnings\": [...]}) or nothing; log to stderr",
+			)
+		}
+
+		for stackName, vars := range response.Vars {
+			config, ok := ctx.StackConfigs[stackName]
+			if !ok {
+				return fmt.Errorf("plugin %s set variables of %s, which is not an enabled stack with merged variables", plugin.Name, stackName)
+			}
+			for key, value := range vars {
+				config.MergedVars[key] = value
+				if config.FilteredVars != nil {
+					config.FilteredVars[key] = value
+				}
+			}
+		}
+		for _, warning := range response.Warnings {
+			ctx.Warn("plugin %s: %s", plugin.Name, warning)
+		}
+		return nil
+	}
+}
+
+// InsertPlugins places plugin stages before or after the stage or plugin they name,
+// whatever the order of the plugins: a plugin is placed once its anchor is, plugins
+// with the same position in name order; without apply, only plugins that also run
+// for plans are kept
+func InsertPlugins(stages []NamedStage, plugins []*inventory.Plugin, apply bool) ([]NamedStage, error) {
+	stages = append([]NamedStage{}, stages...)
+	var pending []*inventory.Plugin
+	for _, plugin := range plugins {
+		if !apply && !plugin.Plan {
+			continue
+		}
+		for _, stage := range stages {
+			if stage.Name == plugin.Name {
+				return nil, fmt.Errorf("plugin %s in %s has the name of a stage", plugin.Name, paths.InventoryPlugins)
+			}
+		}
+		pending = append(pending, plugin)
+	}
+
+	placedAfter := make(map[string]string) // Plugin -> stage it was placed after
+	for len(pending) > 0 {
+		var waiting []*inventory.Plugin
+		for _, plugin := range pending {
+			index := stageIndex(stages, pluginAnchor(plugin))
+			if index < 0 {
+				waiting = append(waiting, plugin)
+				continue
+			}
+
+			if plugin.After != "" {
+				index++
+				for index < len(stages) && placedAfter[stages[index].Name] == plugin.After {
+					index++
+				}
+				placedAfter[plugin.Name] = plugin.After
+			}
+			stage := NamedStage{Name: plugin.Name, Stage: PluginStage(plugin)}
+			stages = append(stages[:index], append([]NamedStage{stage}, stages[index:]...)...)
+		}
+
+		// No plugin placed in this pass: the rest wait on each other or on no stage
+		if len(waiting) == len(pending) {
+			return nil, unplacedPlugin(stages, waiting)
+		}
+		pending = waiting
+	}
+	return stages, nil
+}
+
+// pluginAnchor returns the stage or plugin a plugin is ordered against
+func pluginAnchor(plugin *inventory.Plugin) string {
+	if plugin.After != "" {
+		return plugin.After
+	}
+	return plugin.Before
+}
+
+// stageIndex returns the position of a stage by name, -1 when absent
+func stageIndex(stages []NamedStage, name string) int {
+	for i, stage := range stages {
+		if stage.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// unplacedPlugin reports why none of plugins could be placed, following the anchors of
+// the first one: to a plugin ordered against an unknown stage, or around a cycle
+func unplacedPlugin(stages []NamedStage, plugins []*inventory.Plugin) error {
+	waiting := make(map[string]*inventory.Plugin, len(plugins))
+	for _, plugin := range plugins {
+		waiting[plugin.Name] = plugin
+	}
+
+	plugin := plugins[0]
+	chain := []string{plugin.Name}
+	seen := map[string]bool{plugin.Name: true}
+	for {
+		anchor := pluginAnchor(plugin)
+		next, ok := waiting[anchor]
+		if !ok {
+			break
+		}
+		chain = append(chain, anchor)
+		if seen[anchor] {
+			return errors.New(
+				fmt.Sprintf("plugins in %s are ordered against each other in a cycle: %s", paths.InventoryPlugins, strings.Join(chain, " -> ")),
+				"Order one of them against a stage instead",
+			).WithClass(errors.ClassValidation)
+		}
+		seen[anchor] = true
+		plugin = next
+	}
+
+	names := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		names = append(names, stage.Name)
+	}
+	return errors.New(
+		fmt.Sprintf("plugin %s in %s is ordered against an unknown stage '%s'", plugin.Name, paths.InventoryPlugins, pluginAnchor(plugin)),
+		"Stages of this run: "+strings.Join(names, ", "),
+	).WithClass(errors.ClassValidation)
+}
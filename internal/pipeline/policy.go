@@ -0,0 +1,183 @@
+package pipeline
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// PolicyStage fails when services violate the constraints of inventory/policies.yaml,
+// before anything is written
+func PolicyStage() Stage {
+	return func(ctx *Context) error {
+		policies, err := inventory.LoadPolicies()
+		if err != nil {
+			return err
+		}
+		return CheckPolicies(ctx.MergedCompose, ctx.ServiceStacks, policies)
+	}
+}
+
+// CheckPolicies returns an error listing the violations of a compose file, if any
+// serviceStacks maps each service to its stack, whose exemptions apply
+func CheckPolicies(file *compose.ComposeFile, serviceStacks map[string]string, policies *inventory.Policies) error {
+	violations, err := PolicyViolations(file, serviceStacks, policies)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return errors.New(
+		fmt.Sprintf("%d policy violation(s) in %s", len(violations), paths.InventoryPolicies),
+		"Fix the services in their stack's compose.yml.tmpl",
+		fmt.Sprintf("Or exempt the stack: exemptions.<stack>: [<policy>] in %s", paths.InventoryPolicies),
+	).WithContext(violations...).WithClass(errors.ClassValidation)
+}
+
+// PolicyViolations checks each service of a compose file against the policies its
+// stack is not exempt from:
+//   - privileged: no privileged container with forbid_privileged
+//   - public_ports: no forbid_public_ports port published on every interface (no host
+//     IP, 0.0.0.0 or ::); ports published through a variable (${PORT}) on every
+//     interface and host networking are violations too, as they cannot be checked
+//   - registries: images pulled from allowed_registries only, as the stack sets them
+//     (before a registry mirror rewrote them); built images are not pulled
+func PolicyViolations(file *compose.ComposeFile, serviceStacks map[string]string, policies *inventory.Policies) ([]string, error) {
+	forbidden := make([][2]int, 0, len(policies.ForbidPublicPorts))
+	for _, port := range policies.ForbidPublicPorts {
+		low, high, err := compose.PortRange(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q in forbid_public_ports of %s", port, paths.InventoryPolicies)
+		}
+		forbidden = append(forbidden, [2]int{low, high})
+	}
+
+	names := make([]string, 0, len(file.Services))
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var violations []string
+	for _, name := range names {
+		svc, ok := file.Services[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		stackName := serviceStacks[name]
+		if stackName == "" {
+			stackName = compose.ServiceLabels(file, name)[compose.LabelStack]
+		}
+		violation := func(policy, format string, args ...interface{}) {
+			violations = append(violations, fmt.Sprintf("[%s] service '%s' of stack %s %s", policy, name, stackName, fmt.Sprintf(format, args...)))
+		}
+
+		if policies.ForbidPrivileged && !policies.Exempt(stackName, inventory.PolicyPrivileged) {
+			if privileged, _ := svc["privileged"].(bool); privileged {
+				violation(inventory.PolicyPrivileged, "runs privileged")
+			}
+		}
+
+		if len(forbidden) > 0 && !policies.Exempt(stackName, inventory.PolicyPublicPorts) {
+			if mode, _ := svc["network_mode"].(string); mode == "host" {
+				violation(inventory.PolicyPublicPorts, "uses network_mode: host, so every port it listens on is public (publish its ports instead)")
+			}
+			for _, entry := range variablePorts(svc) {
+				violation(inventory.PolicyPublicPorts, "publishes port %s on every interface through a variable, which cannot be checked (use a literal port or bind it to a host IP)", entry)
+			}
+			for _, published := range compose.PublishedPorts(file, name) {
+				if published.HostIP != "" && published.HostIP != "0.0.0.0" && published.HostIP != "::" {
+					continue
+				}
+				if strings.Contains(published.Port, "$") {
+					continue // Reported by variablePorts
+				}
+				low, high, err := compose.PortRange(published.Port)
+				if err != nil {
+					continue // Not a port compose would publish either
+				}
+				for _, r := range forbidden {
+					if low <= r[1] && r[0] <= high {
+						violation(inventory.PolicyPublicPorts, "publishes port %s/%s on every interface (bind it to a host IP)", published.Port, published.Protocol)
+						break
+					}
+				}
+			}
+		}
+
+		if len(policies.AllowedRegistries) > 0 && svc["build"] == nil && !policies.Exempt(stackName, inventory.PolicyRegistries) {
+			image, _ := svc["image"].(string)
+			if source := compose.ServiceLabels(file, name)[compose.LabelSourceImage]; source != "" {
+				image = source
+			}
+			if image != "" && !allowedImage(image, policies.AllowedRegistries) {
+				violation(inventory.PolicyRegistries, "pulls %s from a registry not in allowed_registries", image)
+			}
+		}
+	}
+
+	return violations, nil
+}
+
+// variablePorts returns the port entries of a service published through a variable
+// (${PORT}:80, or a long syntax published: ${PORT}) without a literal host IP that
+// limits them to one interface
+func variablePorts(svc map[string]interface{}) []string {
+	entries, _ := svc["ports"].([]interface{})
+	var found []string
+	for _, entry := range entries {
+		if long, ok := entry.(map[string]interface{}); ok {
+			published := fmt.Sprint(long["published"])
+			hostIP, _ := long["host_ip"].(string)
+			if long["published"] != nil && (strings.Contains(published, "$") || strings.Contains(hostIP, "$")) && !boundIP(hostIP) {
+				found = append(found, published)
+			}
+			continue
+		}
+
+		short := fmt.Sprint(entry)
+		if !strings.Contains(short, "$") {
+			continue
+		}
+		hostIP := ""
+		if strings.HasPrefix(short, "[") {
+			if end := strings.Index(short, "]"); end > 0 {
+				hostIP = short[1:end]
+			}
+		} else if i := strings.Index(short, ":"); i > 0 {
+			hostIP = short[:i]
+		}
+		if !boundIP(hostIP) {
+			found = append(found, short)
+		}
+	}
+	return found
+}
+
+// boundIP reports whether a host IP is a literal address other than every interface
+func boundIP(hostIP string) bool {
+	ip := net.ParseIP(hostIP)
+	return ip != nil && !ip.IsUnspecified()
+}
+
+// allowedImage reports whether an image comes from one of the allowed registries, a
+// registry host (ghcr.io) or a registry path prefix (ghcr.io/linuxserver)
+func allowedImage(image string, allowed []string) bool {
+	registry, path := compose.ImageRegistry(image)
+	ref := registry + "/" + path
+	for _, prefix := range allowed {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if ref == prefix || strings.HasPrefix(ref, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
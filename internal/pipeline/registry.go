@@ -0,0 +1,44 @@
+package pipeline
+
+import (
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// RegistryMirrorStage rewrites service images to the mirrors of inventory/registries.yaml,
+// e.g. docker.io images to a pull-through cache
+// The original reference is kept in the homelabctl.source-image label, which canary
+// promotion matches against stack.yaml
+func RegistryMirrorStage() Stage {
+	return func(ctx *Context) error {
+		registries, err := inventory.LoadRegistries()
+		if err != nil {
+			return err
+		}
+		if len(registries.Mirrors) == 0 {
+			return nil
+		}
+
+		rewritten := 0
+		for svc, image := range compose.ServiceImages(ctx.MergedCompose, nil) {
+			// A locally built image is tagged, not pulled
+			if ctx.MergedCompose.Services[svc].(map[string]interface{})["build"] != nil {
+				continue
+			}
+
+			mirrored, ok := registries.Rewrite(image)
+			if !ok {
+				continue
+			}
+			ctx.MergedCompose.Services[svc].(map[string]interface{})["image"] = mirrored
+			compose.SetServiceLabel(ctx.MergedCompose, svc, compose.LabelSourceImage, image)
+			rewritten++
+		}
+
+		if rewritten > 0 {
+			ui.Step("Rewrote %d image(s) to registry mirrors", rewritten)
+		}
+		return nil
+	}
+}
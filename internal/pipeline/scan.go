@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/secrets"
+)
+
+// ScanSecretsStage looks for the values of the stacks' secrets in the world-readable
+// files this run wrote: the compose file and the configs and contributions of the
+// selected stacks, with their final modes. Each leak is a warning; with strict, leaks
+// fail the run before outputs are committed
+func ScanSecretsStage(strict bool) Stage {
+	return func(ctx *Context) error {
+		stackSecrets := make(map[string]map[string]interface{})
+		for stackName, config := range ctx.StackConfigs {
+			if len(config.Secrets) > 0 {
+				stackSecrets[stackName] = config.Secrets
+			}
+		}
+		values := secrets.Values(stackSecrets)
+		if len(values) == 0 {
+			return nil
+		}
+
+		files := []string{paths.DockerCompose}
+		for _, stackName := range ctx.EnabledStacks {
+			if ctx.Selected(stackName) {
+				files = append(files, ctx.Configs[stackName]...)
+				files = append(files, ctx.Contributions[stackName]...)
+			}
+		}
+		sort.Strings(files[1:])
+
+		var leaks []string
+		scanned := make(map[string]bool)
+		for _, file := range files {
+			if scanned[file] {
+				continue
+			}
+			scanned[file] = true
+
+			found, err := secrets.ScanFile(ctx.OutputPath(file), file, values)
+			if err != nil {
+				return err
+			}
+			for _, leak := range found {
+				leaks = append(leaks, leak.String())
+			}
+		}
+		if len(leaks) == 0 {
+			return nil
+		}
+
+		if strict {
+			return SecretLeaksError(leaks)
+		}
+		for _, leak := range leaks {
+			ctx.Warn("%s", leak)
+		}
+		return nil
+	}
+}
+
+// SecretLeaksError returns the error listing secret values found in world-readable files
+func SecretLeaksError(leaks []string) error {
+	return errors.New(
+		fmt.Sprintf("%d secret value(s) in world-readable files", len(leaks)),
+		fmt.Sprintf("Mount secrets as files instead: render: compose in %s", paths.InventorySecrets),
+		"Or restrict the config's mode: permissions.configs in the stack's stack.yaml",
+	).WithContext(leaks...).WithClass(errors.ClassValidation)
+}
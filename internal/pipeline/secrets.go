@@ -0,0 +1,332 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// ComposeSecretsStage keeps secrets out of runtime/docker-compose.yml when
+// inventory/secrets.yaml has render: compose. An environment variable of a selected
+// stack's service whose value is one of the stack's secrets is replaced by <VAR>_FILE
+// (or the variable secret_files names) pointing at /run/secrets/<name>; the value is
+// written to runtime/.secrets/<stack>/<key> and declared as a top-level compose secret
+// mounted into the service. Values that only embed a secret stay inline, with a warning
+func ComposeSecretsStage() Stage {
+	return func(ctx *Context) error {
+		settings, err := inventory.LoadSecretsSettings()
+		if err != nil {
+			return err
+		}
+		render := settings.Render == inventory.SecretsRenderCompose
+
+		services := make([]string, 0, len(ctx.MergedCompose.Services))
+		for svc := range ctx.MergedCompose.Services {
+			services = append(services, svc)
+		}
+		sort.Strings(services)
+
+		moved := 0
+		for _, stackName := range ctx.EnabledStacks {
+			config, ok := ctx.StackConfigs[stackName]
+			if !ok || !ctx.Selected(stackName) {
+				continue
+			}
+
+			written := make(map[string]bool)
+			if render {
+				stack, err := stacks.LoadStack(stackName)
+				if err != nil {
+					return err
+				}
+				values, scanned := secretValues(config.Secrets, false), secretValues(config.Secrets, true)
+				for _, svc := range services {
+					if ctx.ServiceStacks[svc] != stackName {
+						continue
+					}
+					n, err := mountServiceSecrets(ctx, stack, svc, values, scanned, written)
+					if err != nil {
+						return err
+					}
+					moved += n
+				}
+			}
+
+			// Files of secrets no longer mounted are removed once outputs are committed
+			stale, err := staleSecretFiles(stackName, written)
+			if err != nil {
+				return err
+			}
+			ctx.StaleOutputs = append(ctx.StaleOutputs, stale...)
+		}
+
+		if moved > 0 {
+			ui.Step("Moved %d secret value(s) into compose secrets", moved)
+		}
+		return nil
+	}
+}
+
+// mountServiceSecrets moves the secret values of a service's environment into compose
+// secrets and returns how many it moved; written records the secret files written
+// Secrets left in the service, numbers included (scanned), are warned about
+func mountServiceSecrets(ctx *Context, stack *stacks.Stack, svc string, values, scanned map[string]string, written map[string]bool) (int, error) {
+	def, ok := ctx.MergedCompose.Services[svc].(map[string]interface{})
+	if !ok {
+		return 0, nil
+	}
+	env := serviceEnvironment(def["environment"])
+
+	// Only the environment can be moved; a secret anywhere else stays in the compose file
+	for _, leak := range inlineSecrets(def, scanned) {
+		ctx.Warn("service %s: %s holds secret %s of stack %s, so it stays in %s (pass it through an environment variable instead)",
+			svc, leak.field, leak.key, stack.Name, paths.DockerCompose)
+	}
+
+	moved := 0
+	for _, entry := range env {
+		key, ok := values[entry.value]
+		if !ok {
+			if key, ok := scanned[entry.value]; ok {
+				ctx.Warn("service %s: %s holds secret %s of stack %s, which is not a string, so it stays in %s",
+					svc, entry.name, key, stack.Name, paths.DockerCompose)
+			} else if embedded := embeddedSecret(entry.value, scanned); embedded != "" {
+				ctx.Warn("service %s: %s embeds secret %s of stack %s, so it stays in %s",
+					svc, entry.name, embedded, stack.Name, paths.DockerCompose)
+			}
+			continue
+		}
+		fileVar := stack.SecretFileVar(entry.name)
+		if fileVar == "" {
+			continue
+		}
+
+		name := composeSecretName(stack.Name, key)
+		runtimePath := paths.RuntimeSecretFile(stack.Name, key)
+		if !written[runtimePath] {
+			output := ctx.OutputPath(runtimePath)
+			if err := os.MkdirAll(filepath.Dir(output), paths.DirPermissions); err != nil {
+				return moved, fmt.Errorf("failed to create %s: %w", filepath.Dir(output), err)
+			}
+			if err := os.WriteFile(output, []byte(entry.value), paths.SecureFilePermissions); err != nil {
+				return moved, fmt.Errorf("failed to write secret %s of stack %s: %w", key, stack.Name, err)
+			}
+			// Hashed with the stack's configs, so a changed secret recreates its services
+			ctx.Configs[stack.Name] = append(ctx.Configs[stack.Name], runtimePath)
+			written[runtimePath] = true
+
+			if ctx.MergedCompose.Secrets == nil {
+				ctx.MergedCompose.Secrets = make(map[string]interface{})
+			}
+			rel, err := filepath.Rel(paths.Runtime, runtimePath)
+			if err != nil {
+				return moved, err
+			}
+			ctx.MergedCompose.Secrets[name] = map[string]interface{}{"file": "./" + filepath.ToSlash(rel)}
+		}
+
+		def["environment"] = replaceEnvironment(def["environment"], entry.name, fileVar, "/run/secrets/"+name)
+		addServiceSecret(def, name)
+		moved++
+	}
+
+	return moved, nil
+}
+
+// inlineSecret is a secret found in a service field other than its environment
+type inlineSecret struct {
+	field string // Dotted path in the service, e.g. labels or healthcheck.test
+	key   string
+}
+
+// inlineSecrets returns the secrets held or embedded in the string and number values of
+// a service's fields other than environment and secrets (command, entrypoint, labels,
+// healthcheck...), once per field, in field order
+func inlineSecrets(def map[string]interface{}, values map[string]string) []inlineSecret {
+	var found []inlineSecret
+	seen := make(map[inlineSecret]bool)
+	var walk func(field string, value interface{})
+	walk = func(field string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if field == "" && (key == "environment" || key == "secrets") {
+					continue
+				}
+				path := key
+				if field != "" {
+					path = field + "." + key
+				}
+				walk(path, v[key])
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(field, item)
+			}
+		case nil, bool:
+		default:
+			text := fmt.Sprint(v)
+			key, ok := values[text]
+			if !ok {
+				key = embeddedSecret(text, values)
+			}
+			if leak := (inlineSecret{field, key}); key != "" && !seen[leak] {
+				seen[leak] = true
+				found = append(found, leak)
+			}
+		}
+	}
+	walk("", def)
+	return found
+}
+
+// secretValues maps the string values of a stack's secrets, and with numbers their
+// number values, to their dotted key; a value stored under several keys maps to the
+// first, sorted
+func secretValues(secrets map[string]interface{}, numbers bool) map[string]string {
+	values := make(map[string]string)
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			// Keys become file names in runtime/.secrets/
+			if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+				continue
+			}
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			switch v := m[key].(type) {
+			case map[string]interface{}:
+				walk(path, v)
+			case string:
+				if _, ok := values[v]; !ok && v != "" {
+					values[v] = path
+				}
+			case int, int64, uint64, float64:
+				if text := fmt.Sprint(v); numbers && values[text] == "" {
+					values[text] = path
+				}
+			}
+		}
+	}
+	walk("", secrets)
+	return values
+}
+
+// embeddedSecret returns the first key, sorted, of a secret a value contains, or ""
+func embeddedSecret(value string, values map[string]string) string {
+	found := ""
+	for secret, key := range values {
+		if strings.Contains(value, secret) && (found == "" || key < found) {
+			found = key
+		}
+	}
+	return found
+}
+
+// composeSecretName names the compose secret of a stack's secret key
+func composeSecretName(stackName, key string) string {
+	return stackName + "_" + strings.ReplaceAll(key, ".", "_")
+}
+
+// environmentEntry is a variable of a service's environment
+type environmentEntry struct {
+	name  string
+	value string
+}
+
+// serviceEnvironment reads a service's environment, as a mapping (sorted) or a list of
+// NAME=value; variables without a value are skipped
+func serviceEnvironment(raw interface{}) []environmentEntry {
+	var env []environmentEntry
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if v[name] != nil {
+				env = append(env, environmentEntry{name: name, value: fmt.Sprint(v[name])})
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if name, value, ok := strings.Cut(fmt.Sprint(item), "="); ok {
+				env = append(env, environmentEntry{name: name, value: value})
+			}
+		}
+	}
+	return env
+}
+
+// replaceEnvironment replaces a variable of a service's environment (a mapping or a list)
+// with another
+func replaceEnvironment(raw interface{}, name, newName, value string) interface{} {
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		delete(v, name)
+		v[newName] = value
+	case []interface{}:
+		for i, item := range v {
+			if itemName, _, ok := strings.Cut(fmt.Sprint(item), "="); ok && itemName == name {
+				v[i] = newName + "=" + value
+			}
+		}
+	}
+	return raw
+}
+
+// addServiceSecret mounts a compose secret into a service, unless it already is
+func addServiceSecret(def map[string]interface{}, name string) {
+	list, _ := def["secrets"].([]interface{})
+	for _, item := range list {
+		if item == name {
+			return
+		}
+		if m, ok := item.(map[string]interface{}); ok && m["source"] == name {
+			return
+		}
+	}
+	def["secrets"] = append(list, name)
+}
+
+// staleSecretFiles returns the files of runtime/.secrets/<stack>/ this run did not write
+func staleSecretFiles(stackName string, written map[string]bool) ([]string, error) {
+	dir := filepath.Join(paths.RuntimeSecrets, stackName)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+
+	var stale []string
+	for _, entry := range entries {
+		file := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() && !written[file] {
+			stale = append(stale, file)
+		}
+	}
+	return stale, nil
+}
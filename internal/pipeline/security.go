@@ -0,0 +1,232 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/render"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// SecurityProvider is the contribute/ directory of intrusion-detection configs
+// contribute/security/<service>/ holds files for the service of that name (fail2ban,
+// crowdsec), delivered to runtime/<stack running it>/<service>/ with the layout kept
+const SecurityProvider = "security"
+
+// renderSecurityContributions renders a stack's contribute/security/ templates into the
+// runtime config of the stacks running the security services, and records the log
+// files they read so SecurityLogsStage can mount them
+func renderSecurityContributions(stackName string, templateCtx *render.Context, ctx *Context) error {
+	contributeDir := paths.StackContributeDir(stackName, SecurityProvider)
+
+	entries, err := os.ReadDir(contributeDir)
+	if err != nil {
+		return nil // No contributions, skip
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		service := entry.Name()
+
+		target := runningStack(service, ctx)
+		if target == "" {
+			ui.Detail("- Skipped %s contributions: no enabled stack runs %s", service, service)
+			continue
+		}
+
+		serviceDir := filepath.Join(contributeDir, service)
+		err := filepath.Walk(serviceDir, func(tmplPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || filepath.Ext(tmplPath) != paths.TemplateExt {
+				return nil
+			}
+
+			relPath, err := filepath.Rel(serviceDir, tmplPath)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path: %w", err)
+			}
+			runtimePath := paths.SecurityContributionFile(target, service, strings.TrimSuffix(relPath, paths.TemplateExt), stackName)
+
+			// A jail for a disabled service would watch a log nothing writes
+			if svc := templateService(filepath.Base(relPath), ctx.StackConfigs[stackName]); svc != "" {
+				ctx.StaleOutputs = append(ctx.StaleOutputs, runtimePath)
+				ui.Detail("- Skipped %s contribution: %s (service %s disabled)", service, relPath, svc)
+				return nil
+			}
+
+			outputPath := ctx.OutputPath(runtimePath)
+			if err := render.RenderToFile(tmplPath, outputPath, templateCtx); err != nil {
+				return fmt.Errorf("failed to render %s contribution for %s: %w", service, stackName, err)
+			}
+
+			// Recorded under the target stack: its config hash changes, so the
+			// security service is recreated and loads the new files
+			ctx.Contributions[target] = append(ctx.Contributions[target], runtimePath)
+			ctx.ContributionFrom[runtimePath] = stackName
+
+			content, err := os.ReadFile(outputPath)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", outputPath, err)
+			}
+			ctx.SecurityLogs[service] = append(ctx.SecurityLogs[service], logPaths(outputPath, content)...)
+
+			ui.Detail("✓ Rendered %s contribution: %s", service, filepath.ToSlash(relPath))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runningStack returns the enabled stack running a service, or "" when none does
+func runningStack(service string, ctx *Context) string {
+	for _, stackName := range ctx.EnabledStacks {
+		config, ok := ctx.StackConfigs[stackName]
+		if !ok || config.IsDisabled(service) {
+			continue
+		}
+		for _, svc := range config.Services {
+			if svc == service {
+				return stackName
+			}
+		}
+	}
+	return ""
+}
+
+// logPaths returns the absolute host paths a rendered file reads logs from: logpath
+// of fail2ban jails, filename and filenames of CrowdSec acquisitions (YAML files)
+func logPaths(path string, content []byte) []string {
+	var found []string
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			var doc map[string]interface{}
+			if err := decoder.Decode(&doc); err != nil {
+				if err != io.EOF {
+					// Not YAML after all: the service reports it
+					return nil
+				}
+				break
+			}
+			if name, ok := doc["filename"].(string); ok {
+				found = append(found, name)
+			}
+			if names, ok := doc["filenames"].([]interface{}); ok {
+				for _, name := range names {
+					found = append(found, fmt.Sprint(name))
+				}
+			}
+		}
+	default:
+		// logpath = <path> [<path>...], continued on indented lines
+		inLogpath := false
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := scanner.Text()
+			trimmed := strings.TrimSpace(line)
+			if inLogpath && trimmed != "" && (line[0] == ' ' || line[0] == '\t') && !strings.Contains(trimmed, "=") {
+				found = append(found, strings.Fields(trimmed)...)
+				continue
+			}
+			inLogpath = false
+			if key, value, ok := strings.Cut(trimmed, "="); ok && strings.TrimSpace(key) == "logpath" {
+				found = append(found, strings.Fields(value)...)
+				inLogpath = true
+			}
+		}
+	}
+
+	var absolute []string
+	for _, p := range found {
+		if filepath.IsAbs(p) {
+			absolute = append(absolute, p)
+		}
+	}
+	return absolute
+}
+
+// SecurityLogsStage mounts the directories of the log files security contributions
+// read into the security services, read-only and at the same path, so jails and
+// acquisitions work with host paths
+func SecurityLogsStage() Stage {
+	return func(ctx *Context) error {
+		services := make([]string, 0, len(ctx.SecurityLogs))
+		for service := range ctx.SecurityLogs {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+
+		for _, service := range services {
+			svcMap, ok := ctx.MergedCompose.Services[service].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			volumes, _ := svcMap["volumes"].([]interface{})
+
+			mounted := make(map[string]bool)
+			for _, v := range volumes {
+				if s, ok := v.(string); ok {
+					if parts := strings.Split(s, ":"); len(parts) >= 2 {
+						mounted[parts[1]] = true
+					}
+				}
+			}
+
+			var added int
+			for _, dir := range logDirs(ctx.SecurityLogs[service]) {
+				if mounted[dir] {
+					continue
+				}
+				mounted[dir] = true
+				volumes = append(volumes, dir+":"+dir+":ro")
+				added++
+			}
+			if added == 0 {
+				continue
+			}
+
+			svcMap["volumes"] = volumes
+			ui.Step("Mounted %d log path(s) read-only into %s", added, service)
+		}
+		return nil
+	}
+}
+
+// logDirs returns the sorted directories holding log files, up to the first glob
+// element: /srv/app/logs/*.log is in /srv/app/logs, /srv/*/access.log in /srv
+func logDirs(logs []string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, p := range logs {
+		if i := strings.IndexAny(p, "*?["); i >= 0 {
+			p = p[:i]
+		}
+		dir := filepath.Dir(p)
+		if dir == "/" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
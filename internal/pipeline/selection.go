@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// SelectStacksStage limits the run to some enabled stacks (all when names is empty)
+// Only their templates are rendered; KeepUnselectedStage takes the other stacks'
+// services, volumes and networks from the current runtime/docker-compose.yml
+func SelectStacksStage(names []string) Stage {
+	return func(ctx *Context) error {
+		if len(names) == 0 {
+			return nil
+		}
+
+		enabled := stacks.EnabledStacksMap(ctx.EnabledStacks)
+		ctx.StackFilter = make(map[string]bool)
+		for _, name := range names {
+			if !enabled[name] {
+				return errors.New(
+					fmt.Sprintf("stack '%s' is not enabled", name),
+					"Run: homelabctl enable "+name,
+					"List stacks: homelabctl list",
+				).WithClass(errors.ClassNotFound)
+			}
+			ctx.StackFilter[name] = true
+		}
+
+		if _, err := os.Stat(paths.DockerCompose); err != nil {
+			return errors.New(
+				"no runtime/docker-compose.yml to take the other stacks from",
+				"Generate all stacks first: homelabctl generate",
+			).WithClass(errors.ClassUsage)
+		}
+
+		selected := make([]string, 0, len(ctx.StackFilter))
+		for name := range ctx.StackFilter {
+			selected = append(selected, name)
+		}
+		sort.Strings(selected)
+		ui.Step("Selected %d of %d stack(s): %s", len(selected), len(ctx.EnabledStacks), strings.Join(selected, ", "))
+		return nil
+	}
+}
+
+// KeepUnselectedStage adds the services, volumes and networks of the stacks outside
+// the selection, as the current runtime/docker-compose.yml has them
+// Placed after the stages transforming rendered services, so kept ones are not transformed twice
+func KeepUnselectedStage() Stage {
+	return func(ctx *Context) error {
+		if ctx.StackFilter == nil {
+			return nil
+		}
+
+		// Kept services must come from the same environment as the rendered ones
+		if previous, err := LoadGeneratedManifest(); err == nil && previous.Environment != ctx.Environment {
+			return errors.New(
+				fmt.Sprintf("runtime/ was generated for environment %s, this run is for %s",
+					environmentName(previous.Environment), environmentName(ctx.Environment)),
+				"Generate all stacks for this environment: homelabctl generate",
+			).WithClass(errors.ClassUsage)
+		}
+
+		current, err := compose.LoadComposeFile(paths.DockerCompose)
+		if err != nil {
+			return err
+		}
+
+		merged := ctx.MergedCompose
+		if merged.ServiceSources == nil {
+			merged.ServiceSources = make(map[string]string)
+		}
+		kept := 0
+		for svc, def := range current.Services {
+			stackName := compose.ServiceLabels(current, svc)[compose.LabelStack]
+			if ctx.Selected(stackName) {
+				continue // Rendered again by this run
+			}
+			if _, ok := merged.Services[svc]; ok {
+				return fmt.Errorf("service %s is defined by a selected stack and by stack %s", svc, stackName)
+			}
+			merged.Services[svc] = def
+			merged.ServiceSources[svc] = paths.DockerCompose
+			ctx.ServiceStacks[svc] = stackName
+			kept++
+		}
+
+		// Definitions are shared between stacks: keep those not rendered again
+		for name, def := range current.Volumes {
+			if _, ok := merged.Volumes[name]; ok {
+				continue
+			}
+			if merged.Volumes == nil {
+				merged.Volumes = make(map[string]interface{})
+			}
+			if merged.VolumeSources == nil {
+				merged.VolumeSources = make(map[string]string)
+			}
+			merged.Volumes[name] = def
+			merged.VolumeSources[name] = paths.DockerCompose
+		}
+		for name, def := range current.Networks {
+			if _, ok := merged.Networks[name]; ok {
+				continue
+			}
+			if merged.Networks == nil {
+				merged.Networks = make(map[string]interface{})
+			}
+			if merged.NetworkSources == nil {
+				merged.NetworkSources = make(map[string]string)
+			}
+			merged.Networks[name] = def
+			merged.NetworkSources[name] = paths.DockerCompose
+		}
+		for name, def := range current.Secrets {
+			if _, ok := merged.Secrets[name]; ok {
+				continue
+			}
+			if merged.Secrets == nil {
+				merged.Secrets = make(map[string]interface{})
+			}
+			merged.Secrets[name] = def
+		}
+
+		ui.Step("Kept %d service(s) of unselected stacks", kept)
+		return nil
+	}
+}
+
+// environmentName names an inventory environment in messages
+func environmentName(env string) string {
+	if env == "" {
+		return "(none)"
+	}
+	return env
+}
@@ -0,0 +1,62 @@
+package pipeline
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// ShareVolumesStage declares the network shares of each stack's persistence section as
+// top-level volumes with the local driver's nfs or cifs driver_opts
+// A template may reference the volume without defining it; defining it differently is an error
+func ShareVolumesStage() Stage {
+	return func(ctx *Context) error {
+		declared := make(map[string]string) // volume -> stack declaring it as a share
+		for _, stackName := range ctx.EnabledStacks {
+			stack, err := stacks.LoadStack(stackName)
+			if err != nil {
+				return err
+			}
+
+			for _, name := range stack.ShareNames() {
+				share := stack.Persistence.Shares[name]
+				definition := map[string]interface{}{
+					"driver":      "local",
+					"driver_opts": share.DriverOpts(),
+				}
+
+				if other, ok := declared[name]; ok {
+					if !reflect.DeepEqual(ctx.MergedCompose.Volumes[name], definition) {
+						return fmt.Errorf("share %s is declared differently by stacks %s and %s", name, other, stackName)
+					}
+					continue
+				}
+				if existing, ok := ctx.MergedCompose.Volumes[name]; ok && !isEmptyVolume(existing) {
+					return fmt.Errorf("volume %s is declared as a share in stack %s but also defined in %s", name, stackName, ctx.MergedCompose.VolumeSources[name])
+				}
+
+				if ctx.MergedCompose.Volumes == nil {
+					ctx.MergedCompose.Volumes = make(map[string]interface{})
+				}
+				ctx.MergedCompose.Volumes[name] = definition
+				declared[name] = stackName
+			}
+		}
+
+		if len(declared) > 0 {
+			ui.Step("Declared %d network share volume(s)", len(declared))
+		}
+		return nil
+	}
+}
+
+// isEmptyVolume reports whether a volume definition sets nothing (e.g. `media:` or `media: {}`)
+func isEmptyVolume(definition interface{}) bool {
+	if definition == nil {
+		return true
+	}
+	m, ok := definition.(map[string]interface{})
+	return ok && len(m) == 0
+}
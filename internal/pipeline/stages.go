@@ -5,20 +5,26 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/monkeymonk/homelabctl/internal/catalog"
+	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/errors"
 	"github.com/monkeymonk/homelabctl/internal/fs"
+	"github.com/monkeymonk/homelabctl/internal/history"
 	"github.com/monkeymonk/homelabctl/internal/stacks"
 	"github.com/monkeymonk/homelabctl/internal/inventory"
 	"github.com/monkeymonk/homelabctl/internal/secrets"
 	"github.com/monkeymonk/homelabctl/internal/render"
 	"github.com/monkeymonk/homelabctl/internal/compose"
 	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/ui"
 )
 
 // LoadStacksStage loads enabled stacks and validates dependencies
 func LoadStacksStage() Stage {
 	return func(ctx *Context) error {
-		fmt.Println("Loading stacks...")
+		ui.Step("Loading stacks...")
 
 		// Load enabled stacks from filesystem
 		enabled, err := fs.GetEnabledStacks()
@@ -36,7 +42,7 @@ func LoadStacksStage() Stage {
 			return fmt.Errorf("failed to sort stacks: %w", err)
 		}
 
-		fmt.Printf("Found %d enabled stack(s) (sorted by category)\n", len(sorted))
+		ui.Step("Found %d enabled stack(s) (sorted by category)", len(sorted))
 
 		// Validate dependencies
 		if err := stacks.ValidateDependencies(sorted); err != nil {
@@ -51,7 +57,7 @@ func LoadStacksStage() Stage {
 // LoadInventoryStage loads global inventory variables and state
 func LoadInventoryStage() Stage {
 	return func(ctx *Context) error {
-		fmt.Println("Loading inventory...")
+		ui.Step("Loading inventory...")
 
 		// Load inventory vars
 		inventoryVars, err := inventory.LoadVars()
@@ -59,6 +65,14 @@ func LoadInventoryStage() Stage {
 			return fmt.Errorf("failed to load inventory vars: %w", err)
 		}
 		ctx.InventoryVars = inventoryVars
+		if ctx.Environment = inventory.Environment(); ctx.Environment != "" {
+			ui.Step("Environment: %s", ctx.Environment)
+		}
+		ctx.Profiles = inventory.ComposeProfiles()
+
+		if err := render.SetEngine(inventory.RenderEngine(inventoryVars)); err != nil {
+			return err
+		}
 
 		// Load disabled services
 		disabledServices, err := inventory.GetDisabledServices()
@@ -73,7 +87,7 @@ func LoadInventoryStage() Stage {
 		}
 
 		if len(disabledServices) > 0 {
-			fmt.Printf("Loaded %d disabled service(s)\n", len(disabledServices))
+			ui.Step("Loaded %d disabled service(s)", len(disabledServices))
 		}
 
 		return nil
@@ -83,50 +97,131 @@ func LoadInventoryStage() Stage {
 // MergeVariablesStage merges variables for all stacks
 func MergeVariablesStage() Stage {
 	return func(ctx *Context) error {
-		fmt.Println("Merging variables...")
+		ui.Step("Merging variables...")
 
 		for _, stackName := range ctx.EnabledStacks {
-			fmt.Printf("Processing stack: %s\n", stackName)
+			ui.Step("Processing stack: %s", stackName)
 
-			// Load stack
-			stack, err := stacks.LoadStack(stackName)
+			config, err := BuildStackConfig(stackName, ctx.InventoryVars)
 			if err != nil {
-				return fmt.Errorf("failed to load stack %s: %w", stackName, err)
+				return err
 			}
 
-			// Validate service definitions
-			if err := stacks.ValidateServiceDefinitions(stackName); err != nil {
-				return fmt.Errorf("invalid services in %s: %w", stackName, err)
-			}
+			// Store in context
+			ctx.StackConfigs[stackName] = config
+			ctx.Warnings = append(ctx.Warnings, config.Warnings...)
+		}
 
-			// Load stack vars
-			stackVars, err := stacks.GetStackVars(stackName)
-			if err != nil {
-				return fmt.Errorf("failed to get vars for %s: %w", stackName, err)
-			}
+		return nil
+	}
+}
 
-			// Load secrets (optional)
-			stackSecrets, err := secrets.LoadSecrets(stackName)
-			if err != nil {
-				return fmt.Errorf("failed to load secrets for %s: %w", stackName, err)
-			}
+// BuildStackConfig loads a stack and merges its variables with inventory vars and secrets
+func BuildStackConfig(stackName string, inventoryVars map[string]interface{}) (*StackConfig, error) {
+	// Load stack
+	stack, err := stacks.LoadStack(stackName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stack %s: %w", stackName, err)
+	}
 
-			// Merge according to precedence (including category defaults)
-			mergedVars, err := stacks.MergeWithCategoryDefaults(stackName, stackVars, ctx.InventoryVars, stackSecrets)
-			if err != nil {
-				return fmt.Errorf("failed to merge vars for %s: %w", stackName, err)
-			}
+	// Validate service definitions
+	if err := stacks.ValidateServiceDefinitions(stackName); err != nil {
+		return nil, fmt.Errorf("invalid services in %s: %w", stackName, err)
+	}
 
-			// Store in context
-			ctx.StackConfigs[stackName] = &StackConfig{
-				Name:       stackName,
-				MergedVars: mergedVars,
-				Services:   stack.Services,
-			}
-		}
+	// Load stack vars
+	stackVars, err := stacks.GetStackVars(stackName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vars for %s: %w", stackName, err)
+	}
 
-		return nil
+	// Load secrets (optional)
+	stackSecrets, err := secrets.LoadSecrets(stackName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets for %s: %w", stackName, err)
+	}
+
+	// Merge according to precedence (including category defaults)
+	mergedVars, err := stacks.MergeWithCategoryDefaults(stackName, stackVars, inventoryVars, stackSecrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge vars for %s: %w", stackName, err)
+	}
+
+	cat, err := categories.Get(stack.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stacks of third-party catalogs render sandboxed
+	sandboxed, err := catalog.Untrusted(stackName)
+	if err != nil {
+		return nil, err
+	}
+	// Their config files stay in their own runtime directory
+	if sandboxed && len(stack.ConfigOutputs) > 0 {
+		return nil, errors.New(
+			fmt.Sprintf("config_outputs of stack %s are refused: it is installed from an untrusted catalog", stackName),
+			fmt.Sprintf("Review the config_outputs in stacks/%s/stack.yaml", stackName),
+			fmt.Sprintf("If you trust the catalog, set trusted: true for it in %s", paths.InventoryCatalogs),
+		).WithClass(errors.ClassValidation)
 	}
+
+	return &StackConfig{
+		Name:             stackName,
+		Category:         stack.Category,
+		CategoryDefaults: cat.Defaults,
+		Requires:         stack.Requires,
+		Tags:             stack.Tags,
+		ConfigOutputs:    stack.ConfigOutputs,
+		MergedVars:       mergedVars,
+		FilteredVars:     mergedVars,
+		Secrets:          stackSecrets,
+		Sandboxed:        sandboxed,
+		ContextVersion:   stack.ContextVersion,
+		ComposeVariants:  stack.ComposeVariants,
+		Warnings:         stack.Warnings,
+		Services:         stack.Services,
+	}, nil
+}
+
+// TemplateContext builds the render context for a stack, in the version it expects
+// Version 1 is the only structure so far; later versions keep building the older ones
+func TemplateContext(config *StackConfig, enabledStacks []string) *render.Context {
+	sandbox := ""
+	if config.Sandboxed {
+		sandbox = paths.StackDir(config.Name)
+	}
+
+	version := config.ContextVersion
+	if version == 0 {
+		version = render.ContextVersion
+	}
+
+	return &render.Context{
+		Version: version,
+		Vars:    config.FilteredVars,
+		Stack: map[string]interface{}{
+			"name":              config.Name,
+			"category":          config.Category,
+			"category_defaults": orEmpty(config.CategoryDefaults),
+			"requires":          append([]string{}, config.Requires...),
+			"tags":              append([]string{}, config.Tags...),
+			"services":          config.EnabledServices(),
+			"disabled_services": append([]string{}, config.Disabled...),
+		},
+		Stacks: map[string]interface{}{
+			"enabled": enabledStacks,
+		},
+		Sandbox: sandbox,
+	}
+}
+
+// orEmpty returns an empty map for nil, so templates can index it
+func orEmpty(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
 }
 
 // FilterServicesStage reports disabled services but doesn't filter variables
@@ -142,21 +237,23 @@ func FilterServicesStage() Stage {
 			return nil
 		}
 
-		fmt.Println("Disabled services will be filtered from final compose:")
+		ui.Step("Disabled services will be filtered from final compose:")
 
 		for stackName, config := range ctx.StackConfigs {
 			// Create a copy of MergedVars without disabled services
 			config.FilteredVars = make(map[string]interface{})
 			for key, value := range config.MergedVars {
-				if !ctx.DisabledServices[key] {
+				if !ctx.IsServiceDisabled(stackName, key) {
 					config.FilteredVars[key] = value
 				}
 			}
 
 			// Report which services are disabled in this stack
+			config.Disabled = nil
 			for _, svc := range config.Services {
-				if ctx.DisabledServices[svc] {
-					fmt.Printf("  - %s (from %s)\n", svc, stackName)
+				if ctx.IsServiceDisabled(stackName, svc) {
+					config.Disabled = append(config.Disabled, svc)
+					ui.Detail("- %s (from %s)", svc, stackName)
 				}
 			}
 		}
@@ -168,26 +265,31 @@ func FilterServicesStage() Stage {
 // RenderTemplatesStage renders all templates for all stacks
 func RenderTemplatesStage() Stage {
 	return func(ctx *Context) error {
-		fmt.Println("Rendering templates...")
+		ui.Step("Rendering templates...")
 
 		// Ensure runtime directory exists
 		if err := fs.EnsureDir(paths.Runtime); err != nil {
 			return fmt.Errorf("failed to create runtime dir: %w", err)
 		}
 
+		// Start from an empty staging dir (a failed run may have left one behind)
+		if ctx.StagingDir != "" {
+			if err := os.RemoveAll(ctx.StagingDir); err != nil {
+				return fmt.Errorf("failed to clear staging dir: %w", err)
+			}
+			if err := fs.EnsureDir(ctx.StagingDir); err != nil {
+				return fmt.Errorf("failed to create staging dir: %w", err)
+			}
+		}
+
 		for stackName, config := range ctx.StackConfigs {
-			// Build template context
-			templateCtx := &render.Context{
-				Vars: config.FilteredVars,
-				Stack: map[string]interface{}{
-					"name":     stackName,
-					"category": "", // Load from stack if needed
-				},
-				Stacks: map[string]interface{}{
-					"enabled": ctx.EnabledStacks,
-				},
+			if !ctx.Selected(stackName) {
+				continue
 			}
 
+			// Build template context
+			templateCtx := TemplateContext(config, ctx.EnabledStacks)
+
 			// Render main compose template
 			composeTemplate := paths.StackComposeTemplate(stackName)
 			composeOutput := paths.RuntimeComposeFile(stackName)
@@ -199,8 +301,20 @@ func RenderTemplatesStage() Stage {
 			ctx.RenderedFiles = append(ctx.RenderedFiles, composeOutput)
 			ctx.RenderedCompose[stackName] = composeOutput
 
-			// Render Traefik contributions
-			if err := renderContributions(stackName, "traefik", templateCtx, ctx); err != nil {
+			// Merge the selected compose.<variant>.yml.tmpl files into it
+			if err := renderComposeVariants(stackName, templateCtx, ctx); err != nil {
+				return err
+			}
+
+			// Render Traefik and other providers' contributions
+			for _, provider := range contributionProviders(stackName) {
+				if err := renderContributions(stackName, provider, templateCtx, ctx); err != nil {
+					return err
+				}
+			}
+
+			// Render intrusion-detection contributions into the security stacks
+			if err := renderSecurityContributions(stackName, templateCtx, ctx); err != nil {
 				return err
 			}
 
@@ -208,10 +322,32 @@ func RenderTemplatesStage() Stage {
 			if err := renderConfigs(stackName, templateCtx, ctx); err != nil {
 				return err
 			}
+
+			// Render README and post-install notes
+			if err := renderStackDocs(stackName, templateCtx, ctx); err != nil {
+				return err
+			}
 		}
 
-		return nil
+		return checkConfigOutputs(ctx)
+	}
+}
+
+// contributionProviders returns the providers a stack contributes files to, sorted: the
+// directories of its contribute/, but the security one, delivered to the security stacks
+func contributionProviders(stackName string) []string {
+	entries, err := os.ReadDir(filepath.Dir(paths.StackContributeDir(stackName, SecurityProvider)))
+	if err != nil {
+		return nil // No contributions
+	}
+
+	var providers []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != SecurityProvider {
+			providers = append(providers, entry.Name())
+		}
 	}
+	return providers
 }
 
 // Helper function for rendering contributions
@@ -235,21 +371,32 @@ func renderContributions(stackName, provider string, templateCtx *render.Context
 
 		tmplPath := filepath.Join(contributeDir, entry.Name())
 		outputName := strings.TrimSuffix(entry.Name(), paths.TemplateExt)
-		outputPath := paths.TraefikContributionFile(stackName, outputName)
+
+		// A contribution named after a disabled service would route to nothing
+		if svc := templateService(entry.Name(), ctx.StackConfigs[stackName]); svc != "" {
+			ctx.StaleOutputs = append(ctx.StaleOutputs, paths.ContributionFile(provider, stackName, outputName))
+			ui.Detail("- Skipped %s contribution: %s (service %s disabled)", provider, outputName, svc)
+			continue
+		}
+
+		outputPath := ctx.OutputPath(paths.ContributionFile(provider, stackName, outputName))
 
 		if err := render.RenderToFile(tmplPath, outputPath, templateCtx); err != nil {
 			return fmt.Errorf("failed to render %s contribution for %s: %w", provider, stackName, err)
 		}
+		ctx.Contributions[stackName] = append(ctx.Contributions[stackName], paths.ContributionFile(provider, stackName, outputName))
 
-		fmt.Printf("  ✓ Rendered %s contribution: %s\n", provider, outputName)
+		ui.Detail("✓ Rendered %s contribution: %s", provider, outputName)
 	}
 
 	return nil
 }
 
 // Helper function for rendering config files
+// They land in runtime/<stack>/, or where the stack's config_outputs puts them
 func renderConfigs(stackName string, templateCtx *render.Context, ctx *Context) error {
 	configDir := paths.StackConfigDir(stackName)
+	config := ctx.StackConfigs[stackName]
 
 	info, err := os.Stat(configDir)
 	if err != nil || !info.IsDir() {
@@ -271,7 +418,16 @@ func renderConfigs(stackName string, templateCtx *render.Context, ctx *Context)
 		}
 
 		outputRelPath := strings.TrimSuffix(relPath, paths.TemplateExt)
-		outputPath := paths.RuntimeConfigFile(stackName, outputRelPath)
+		runtimePath := config.ConfigOutput(outputRelPath)
+
+		// Config files of a disabled service are not mounted by anything
+		if svc := templateService(relPath, config); svc != "" {
+			ctx.StaleOutputs = append(ctx.StaleOutputs, runtimePath)
+			ui.Detail("- Skipped config: %s (service %s disabled)", outputRelPath, svc)
+			return nil
+		}
+
+		outputPath := ctx.OutputPath(runtimePath)
 
 		outputDir := filepath.Dir(outputPath)
 		if err := fs.EnsureDir(outputDir); err != nil {
@@ -281,21 +437,72 @@ func renderConfigs(stackName string, templateCtx *render.Context, ctx *Context)
 		if err := render.RenderToFile(tmplPath, outputPath, templateCtx); err != nil {
 			return fmt.Errorf("failed to render config %s: %w", relPath, err)
 		}
+		ctx.Configs[stackName] = append(ctx.Configs[stackName], runtimePath)
 
-		fmt.Printf("  ✓ Rendered config: %s\n", outputRelPath)
+		if runtimePath != paths.RuntimeConfigFile(stackName, outputRelPath) {
+			ui.Detail("✓ Rendered config: %s → %s", outputRelPath, runtimePath)
+			return nil
+		}
+		ui.Detail("✓ Rendered config: %s", outputRelPath)
 		return nil
 	})
 }
 
+// templateService returns the disabled service a contribution or config template
+// belongs to, or "" to render it. A template belongs to a service when its first
+// path element, up to the first dot, is the service name: grafana.yml.tmpl,
+// grafana/grafana.ini.tmpl
+func templateService(relPath string, config *StackConfig) string {
+	if config == nil || len(config.Disabled) == 0 {
+		return ""
+	}
+
+	first := strings.SplitN(filepath.ToSlash(relPath), "/", 2)[0]
+	name := strings.SplitN(first, ".", 2)[0]
+	if config.IsDisabled(name) {
+		return name
+	}
+	return ""
+}
+
+// Helper function for rendering a stack's README.md.tmpl and NOTES.md.tmpl
+// Unlike temporary compose files, these are kept in runtime/<stack>/ for info and deploy
+func renderStackDocs(stackName string, templateCtx *render.Context, ctx *Context) error {
+	docs := map[string]string{
+		paths.StackReadmeTemplate(stackName): paths.RuntimeReadme(stackName),
+		paths.StackNotesTemplate(stackName):  paths.RuntimeNotes(stackName),
+	}
+
+	for tmplPath, outputPath := range docs {
+		if _, err := os.Stat(tmplPath); err != nil {
+			// Remove output left over from a template that no longer exists
+			ctx.StaleOutputs = append(ctx.StaleOutputs, outputPath)
+			continue
+		}
+
+		if err := render.RenderToFile(tmplPath, ctx.OutputPath(outputPath), templateCtx); err != nil {
+			return fmt.Errorf("failed to render %s for %s: %w", filepath.Base(tmplPath), stackName, err)
+		}
+	}
+
+	return nil
+}
+
 // MergeComposeStage merges all rendered compose files
 func MergeComposeStage() Stage {
 	return func(ctx *Context) error {
-		fmt.Println("Merging compose files...")
+		ui.Step("Merging compose files...")
 
-		// Collect rendered compose file paths
+		// Collect rendered compose file paths in deployment order
 		var composeFiles []string
-		for _, path := range ctx.RenderedCompose {
+		fileStacks := make(map[string]string)
+		for _, stackName := range ctx.EnabledStacks {
+			path, ok := ctx.RenderedCompose[stackName]
+			if !ok {
+				continue
+			}
 			composeFiles = append(composeFiles, path)
+			fileStacks[path] = stackName
 		}
 
 		// Merge all compose files
@@ -304,6 +511,12 @@ func MergeComposeStage() Stage {
 			return fmt.Errorf("failed to merge compose files: %w", err)
 		}
 
+		// Record which stack each service came from
+		for svc, file := range merged.ServiceSources {
+			ctx.ServiceStacks[svc] = fileStacks[file]
+		}
+
+		ctx.Warnings = append(ctx.Warnings, merged.Warnings...)
 		ctx.MergedCompose = merged
 		return nil
 	}
@@ -316,57 +529,248 @@ func FilterDisabledComposeStage() Stage {
 			return nil
 		}
 
-		// Convert disabled services map to slice
+		// Collect merged services whose own stack disabled them
 		var disabled []string
-		for svc := range ctx.DisabledServices {
-			disabled = append(disabled, svc)
+		for svc := range ctx.MergedCompose.Services {
+			if ctx.IsServiceDisabled(ctx.ServiceStacks[svc], svc) {
+				disabled = append(disabled, svc)
+			}
 		}
 
 		// Filter disabled services from the merged compose
 		removed := compose.FilterDisabledServices(ctx.MergedCompose, disabled)
 		if len(removed) > 0 {
-			fmt.Printf("Removed %d disabled service(s) from final compose: %v\n", len(removed), removed)
+			ui.Step("Removed %d disabled service(s) from final compose: %v", len(removed), removed)
 		}
 
 		return nil
 	}
 }
 
+// LabelServicesStage adds provenance labels (stack, category) to every service
+// These let later commands map running containers back to their stack
+func LabelServicesStage() Stage {
+	return func(ctx *Context) error {
+		for svc := range ctx.MergedCompose.Services {
+			stackName := ctx.ServiceStacks[svc]
+			if stackName == "" {
+				continue
+			}
+
+			compose.SetServiceLabel(ctx.MergedCompose, svc, compose.LabelStack, stackName)
+			if config, ok := ctx.StackConfigs[stackName]; ok && config.Category != "" {
+				compose.SetServiceLabel(ctx.MergedCompose, svc, compose.LabelCategory, config.Category)
+			}
+		}
+
+		return nil
+	}
+}
+
+// StrictStage fails the pipeline if any warnings were collected so far
+// Placed before WriteOutputStage so a strict run leaves the previous output untouched
+func StrictStage(strict bool) Stage {
+	return func(ctx *Context) error {
+		if !strict || len(ctx.Warnings) == 0 {
+			return nil
+		}
+
+		return errors.StrictWarnings(ctx.Warnings)
+	}
+}
+
 // WriteOutputStage writes the final docker-compose.yml
-func WriteOutputStage() Stage {
+// With annotate, each service, volume and network is preceded by a comment naming
+// the stack and template it came from
+func WriteOutputStage(annotate bool) Stage {
 	return func(ctx *Context) error {
-		fmt.Println("Writing output...")
+		ui.Step("Writing output...")
 
-		if err := compose.WriteComposeFile(paths.DockerCompose, ctx.MergedCompose); err != nil {
+		output := ctx.OutputPath(paths.DockerCompose)
+		if !annotate {
+			if err := compose.WriteComposeFile(output, ctx.MergedCompose); err != nil {
+				return fmt.Errorf("failed to write compose file: %w", err)
+			}
+			return nil
+		}
+
+		header := "Generated by homelabctl generate - do not edit\nEdit stacks/<stack>/compose.yml.tmpl and run: homelabctl generate"
+		if err := compose.WriteAnnotatedComposeFile(output, ctx.MergedCompose, header, provenanceAnnotations(ctx)); err != nil {
 			return fmt.Errorf("failed to write compose file: %w", err)
 		}
 
-		fmt.Printf("\n✓ Generation complete\n")
-		fmt.Printf("✓ Written: %s\n", paths.DockerCompose)
+		return nil
+	}
+}
+
+// provenanceAnnotations describes the source stack and template of every merged entry
+func provenanceAnnotations(ctx *Context) compose.Annotations {
+	fileStacks := make(map[string]string)
+	for stackName, file := range ctx.RenderedCompose {
+		fileStacks[file] = stackName
+	}
+
+	describe := func(sources map[string]string) map[string]string {
+		comments := make(map[string]string)
+		for name, file := range sources {
+			if stackName, ok := fileStacks[file]; ok {
+				comments[name] = fmt.Sprintf("From stack %s (%s)", stackName, paths.StackComposeTemplate(stackName))
+			}
+		}
+		return comments
+	}
+
+	merged := ctx.MergedCompose
+	return compose.Annotations{
+		"services": describe(merged.ServiceSources),
+		"volumes":  describe(merged.VolumeSources),
+		"networks": describe(merged.NetworkSources),
+	}
+}
+
+// CommitOutputStage swaps the staged outputs into runtime/ once every earlier stage succeeded
+// A run that fails before this stage leaves runtime/ untouched
+func CommitOutputStage() Stage {
+	return func(ctx *Context) error {
+		if ctx.StagingDir != "" {
+			staged, err := stagedOutputs(ctx.StagingDir)
+			if err != nil {
+				return err
+			}
+			if err := fs.CommitStaged(ctx.StagingDir, paths.Runtime); err != nil {
+				return fmt.Errorf("failed to commit generated files: %w", err)
+			}
+			ctx.CommittedOutputs = staged
+		}
+
+		for _, file := range ctx.StaleOutputs {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				ctx.Warn("failed to remove stale %s: %v", file, err)
+			}
+		}
+
+		ui.Blank()
+		ui.Success("Generation complete")
+		ui.Success("Written: %s", paths.DockerCompose)
+
+		return nil
+	}
+}
+
+// reportDefaultPrune warns, the first time only, that generations were removed by the
+// keep limit homelabctl applies when inventory/history.yaml sets none
+func reportDefaultPrune(ctx *Context, retention history.Retention, removed int) {
+	if !retention.DefaultKeep {
+		return
+	}
+	if _, err := os.Stat(paths.HistoryPruned); err == nil {
+		return
+	}
+	ctx.Warn("%s keeps the latest %d generations by default, so %d older one(s) were removed; set keep in %s to change it (0 keeps them all)",
+		paths.HistoryDir, retention.Keep, removed, paths.InventoryHistory)
+	if err := os.WriteFile(paths.HistoryPruned, nil, paths.FilePermissions); err != nil {
+		ctx.Warn("failed to write %s: %v", paths.HistoryPruned, err)
+	}
+}
+
+// stagedOutputs returns the files under stagingDir as the runtime/ paths they are committed to
+func stagedOutputs(stagingDir string) ([]string, error) {
+	var outputs []string
+	err := filepath.Walk(stagingDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(stagingDir, path)
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, filepath.Join(paths.Runtime, rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read staging dir %s: %w", stagingDir, err)
+	}
+	return outputs, nil
+}
+
+// RecordHistoryStage records a snapshot of the generated stacks and images in runtime/history/,
+// with a copy of the generated compose file and configs `rollback` restores
+func RecordHistoryStage() Stage {
+	return func(ctx *Context) error {
+		snapshot := history.NewSnapshot()
+
+		for _, stackName := range ctx.EnabledStacks {
+			stack := history.StackSnapshot{
+				Services: make(map[string]string),
+			}
+			if config, ok := ctx.StackConfigs[stackName]; ok {
+				stack.Category = config.Category
+			}
+			snapshot.Stacks[stackName] = stack
+		}
+
+		for svc, image := range compose.ServiceImages(ctx.MergedCompose, nil) {
+			if stack, ok := snapshot.Stacks[ctx.ServiceStacks[svc]]; ok {
+				stack.Services[svc] = image
+			}
+		}
+
+		if err := history.Record(snapshot); err != nil {
+			return fmt.Errorf("failed to record history: %w", err)
+		}
+
+		// Every file the run wrote, for rollback; when written in place, the compose file
+		// with the rendered configs and contributions
+		outputs := ctx.CommittedOutputs
+		if ctx.StagingDir == "" {
+			outputs = []string{paths.DockerCompose}
+			for _, stackName := range ctx.EnabledStacks {
+				outputs = append(outputs, ctx.Configs[stackName]...)
+				outputs = append(outputs, ctx.Contributions[stackName]...)
+			}
+		}
+		if err := history.Archive(snapshot.ID, outputs); err != nil {
+			return fmt.Errorf("failed to record history: %w", err)
+		}
+		if err := history.SetCurrent(snapshot.ID); err != nil {
+			return fmt.Errorf("failed to record history: %w", err)
+		}
 
 		return nil
 	}
 }
 
-// CleanupStage removes temporary files
+// CleanupStage removes temporary files, then the generations of runtime/history/ the
+// retention policy no longer keeps (none when retention is nil)
 // Set skip=true to preserve files for debugging
-func CleanupStage(skip bool) Stage {
+func CleanupStage(skip bool, retention *history.Retention) Stage {
 	return func(ctx *Context) error {
 		if skip {
-			fmt.Println("Skipping cleanup (temporary files preserved)")
+			ui.Step("Skipping cleanup (temporary files preserved)")
 			return nil
 		}
 
-		if len(ctx.RenderedFiles) == 0 {
-			return nil
+		if len(ctx.RenderedFiles) > 0 {
+			ui.Step("Cleaning up temporary files...")
 		}
 
-		fmt.Println("Cleaning up temporary files...")
-
 		for _, file := range ctx.RenderedFiles {
 			if err := os.Remove(file); err != nil {
-				// Log but don't fail on cleanup errors
-				fmt.Printf("Warning: failed to remove %s: %v\n", file, err)
+				// Report but don't fail on cleanup errors
+				ctx.Warn("failed to remove %s: %v", file, err)
+			}
+		}
+
+		if retention != nil {
+			removed, err := history.Prune(*retention, time.Now(), false)
+			if err != nil {
+				ctx.Warn("failed to prune %s: %v", paths.HistoryDir, err)
+			}
+			if len(removed) > 0 {
+				ui.Step("Removed %d generation(s) from %s (retention)", len(removed), paths.HistoryDir)
+				reportDefaultPrune(ctx, *retention, len(removed))
 			}
 		}
 
@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+)
+
+// bootRestartPolicies are the restart policies that start a container again when docker
+// starts, all at once and whatever the state of the services it depends on
+var bootRestartPolicies = map[string]bool{"always": true, "unless-stopped": true}
+
+// gatingConditions are the depends_on conditions that wait for a dependency to be ready
+var gatingConditions = map[string]bool{"service_healthy": true, "service_completed_successfully": true}
+
+// StartupOrderStage warns about services that restart at boot but do not wait for the
+// services of other stacks they depend on, and crash-loop while e.g. a database starts
+func StartupOrderStage() Stage {
+	return func(ctx *Context) error {
+		warnings, err := StartupOrderWarnings(ctx.MergedCompose, ctx.ServiceStacks)
+		if err != nil {
+			return err
+		}
+		ctx.Warnings = append(ctx.Warnings, warnings...)
+		return nil
+	}
+}
+
+// StartupOrderWarnings checks the services of a compose file restarted at boot (restart
+// always or unless-stopped) against the services of other stacks they depend on
+// A service depends on another through depends_on, network_mode, volumes_from, or an
+// environment value naming it as a host; each such dependency must be gated with
+// depends_on condition service_healthy, unless the stack waives it in startup_waivers
+// serviceStacks maps each service to its stack
+func StartupOrderWarnings(file *compose.ComposeFile, serviceStacks map[string]string) ([]string, error) {
+	names := make([]string, 0, len(file.Services))
+	for name := range file.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	deps := compose.ServiceDependencies(file)
+	loaded := make(map[string]*stacks.Stack)
+
+	var warnings []string
+	for _, name := range names {
+		svc, ok := file.Services[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		restart, _ := svc["restart"].(string)
+		stackName := serviceStacks[name]
+		if !bootRestartPolicies[restart] || stackName == "" {
+			continue
+		}
+
+		var ungated []string
+		for _, dep := range crossStackDependencies(name, svc, deps[name], serviceStacks) {
+			if !gated(svc, dep) {
+				ungated = append(ungated, fmt.Sprintf("%s (%s)", dep, serviceStacks[dep]))
+			}
+		}
+		if len(ungated) == 0 {
+			continue
+		}
+
+		stack, ok := loaded[stackName]
+		if !ok {
+			var err error
+			if stack, err = stacks.LoadStack(stackName); err != nil {
+				return nil, err
+			}
+			loaded[stackName] = stack
+		}
+		if _, waived := stack.StartupWaivers[name]; waived {
+			continue
+		}
+
+		warnings = append(warnings, fmt.Sprintf(
+			"service '%s' of stack %s restarts %s but does not wait for %s at boot "+
+				"(add depends_on with condition: service_healthy, or a startup_waivers entry to stacks/%s/stack.yaml)",
+			name, stackName, restart, strings.Join(ungated, ", "), stackName))
+	}
+
+	return warnings, nil
+}
+
+// crossStackDependencies returns the services of other stacks a service depends on,
+// sorted: its compose dependencies and the services its environment names as hosts
+func crossStackDependencies(name string, svc map[string]interface{}, deps []string, serviceStacks map[string]string) []string {
+	stackName := serviceStacks[name]
+	found := make(map[string]bool)
+	for _, dep := range deps {
+		if other, ok := serviceStacks[dep]; ok && other != stackName {
+			found[dep] = true
+		}
+	}
+
+	for _, value := range environmentValues(svc["environment"]) {
+		words := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+		})
+		for _, word := range words {
+			if other, ok := serviceStacks[word]; ok && other != stackName {
+				found[word] = true
+			}
+		}
+	}
+
+	result := make([]string, 0, len(found))
+	for dep := range found {
+		result = append(result, dep)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// environmentValues returns the values of an environment section (map or KEY=value list)
+func environmentValues(environment interface{}) []string {
+	var values []string
+	switch env := environment.(type) {
+	case map[string]interface{}:
+		for _, value := range env {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	case []interface{}:
+		for _, entry := range env {
+			if s, ok := entry.(string); ok {
+				if _, value, ok := strings.Cut(s, "="); ok {
+					values = append(values, value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// gated reports whether a service waits for a dependency to be ready before starting
+func gated(svc map[string]interface{}, dep string) bool {
+	dependsOn, ok := svc["depends_on"].(map[string]interface{})
+	if !ok {
+		return false // The list form only orders the starts
+	}
+	options, ok := dependsOn[dep].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	condition, _ := options["condition"].(string)
+	return gatingConditions[condition]
+}
@@ -0,0 +1,100 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/proxy"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// Services the remote access configs are for
+const (
+	tunnelService    = "cloudflared"
+	tailscaleService = "tailscale"
+)
+
+// RemoteAccessStage renders the expose_via declarations as contributions to the stacks
+// running the remote access services: the ingress rules of cloudflared's config.yml,
+// and one tailscale serve config per service
+// Recorded under those stacks, so their config hash changes and the services reload
+func RemoteAccessStage() Stage {
+	return func(ctx *Context) error {
+		settings, err := proxy.Load(ctx.InventoryVars)
+		if err != nil {
+			return errors.New(err.Error(), "Check the proxy section of inventory/vars.yaml").WithClass(errors.ClassValidation)
+		}
+
+		routes, err := exposeRoutes(ctx)
+		if err != nil {
+			return err
+		}
+
+		var tunnel, tailscale []proxy.Route
+		for _, route := range routes {
+			switch route.Via {
+			case proxy.ViaTunnel:
+				tunnel = append(tunnel, route)
+			case proxy.ViaTailscale:
+				tailscale = append(tailscale, route)
+			}
+		}
+
+		if len(tunnel) > 0 {
+			target := runningStack(tunnelService, ctx)
+			if target == "" {
+				ctx.Warn("%d service(s) declare expose_via: tunnel, but no enabled stack runs %s", len(tunnel), tunnelService)
+			} else {
+				content, err := proxy.CloudflaredConfig(tunnel, settings)
+				if err != nil {
+					return err
+				}
+				if err := writeContribution(ctx, target, paths.TunnelConfigFile(target), content); err != nil {
+					return err
+				}
+				ui.Step("Routed %d service(s) through the Cloudflare Tunnel (%s)", len(tunnel), target)
+			}
+		}
+
+		if len(tailscale) > 0 {
+			target := runningStack(tailscaleService, ctx)
+			if target == "" {
+				ctx.Warn("%d service(s) declare expose_via: tailscale, but no enabled stack runs %s", len(tailscale), tailscaleService)
+				return nil
+			}
+			for _, route := range tailscale {
+				content, err := proxy.TailscaleServe(route)
+				if err != nil {
+					return err
+				}
+				if err := writeContribution(ctx, target, paths.TailscaleServeFile(target, route.Service), content); err != nil {
+					return err
+				}
+			}
+			ui.Step("Served %d service(s) on the tailnet (%s)", len(tailscale), target)
+		}
+
+		return nil
+	}
+}
+
+// writeContribution writes a generated file into a stack's runtime config and records it
+// as a contribution to that stack, so the manifest removes it once it is not generated
+func writeContribution(ctx *Context, target, runtimePath, content string) error {
+	if err := checkOutputOwner(ctx, runtimePath, "generated for stack "+target); err != nil {
+		return err
+	}
+	outputPath := ctx.OutputPath(runtimePath)
+	if err := os.MkdirAll(filepath.Dir(outputPath), paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(outputPath), err)
+	}
+	if err := os.WriteFile(outputPath, []byte(content), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", runtimePath, err)
+	}
+
+	ctx.Contributions[target] = append(ctx.Contributions[target], runtimePath)
+	return nil
+}
@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/render"
+	"github.com/monkeymonk/homelabctl/internal/stacks"
+	"github.com/monkeymonk/homelabctl/internal/ui"
+)
+
+// renderComposeVariants renders a stack's compose.<variant>.yml.tmpl files its
+// compose_variants select, and merges them into its rendered compose file, in variant
+// order, before the stacks are merged together
+func renderComposeVariants(stackName string, templateCtx *render.Context, ctx *Context) error {
+	variants, err := stacks.ComposeVariants(stackName)
+	if err != nil || len(variants) == 0 {
+		return err
+	}
+
+	config := ctx.StackConfigs[stackName]
+	layers := []string{ctx.RenderedCompose[stackName]}
+	for _, variant := range variants {
+		if selector, ok := config.ComposeVariants[variant]; ok && !selector.Selected(config.FilteredVars, ctx.Environment, ctx.Profiles) {
+			ui.Detail("- Skipped compose variant: %s", variant)
+			continue
+		}
+
+		output := paths.RuntimeComposeVariantFile(stackName, variant)
+		if err := render.RenderToFile(paths.StackComposeVariantTemplate(stackName, variant), output, templateCtx); err != nil {
+			return fmt.Errorf("failed to render compose variant %s for %s: %w", variant, stackName, err)
+		}
+		ctx.RenderedFiles = append(ctx.RenderedFiles, output)
+		layers = append(layers, output)
+		ui.Detail("✓ Merged compose variant: %s", variant)
+	}
+
+	if len(layers) == 1 {
+		return nil
+	}
+	if err := compose.MergeLayers(layers, ctx.RenderedCompose[stackName]); err != nil {
+		return fmt.Errorf("failed to merge compose variants for %s: %w", stackName, err)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package power follows the UPS through NUT or apcupsd: on battery, the stacks of each
+// category stop once the outage outlasts the category's grace period, last category
+// first, and they start again when power is back
+package power
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// UPS daemons the status is read from
+const (
+	SourceNUT     = "nut"     // upsc <ups>: ups.status OL (online), OB (on battery), LB (low battery)
+	SourceApcupsd = "apcupsd" // apcaccess status: STATUS ONLINE, ONBATT, LOWBATT
+)
+
+// Sources lists the available UPS daemons
+var Sources = []string{SourceNUT, SourceApcupsd}
+
+// Never is the grace period of categories kept running until the battery is low
+const Never = "never"
+
+// Defaults of inventory/power.yaml
+const (
+	defaultUPS   = "ups@localhost"
+	defaultGrace = 5 * time.Minute
+)
+
+// Config is the layout of inventory/power.yaml
+type Config struct {
+	Source       string            `yaml:"source"`        // nut or apcupsd
+	UPS          string            `yaml:"ups"`           // NUT UPS name (default ups@localhost)
+	DefaultGrace string            `yaml:"default_grace"` // Grace period of the categories not listed (default 5m)
+	Grace        map[string]string `yaml:"grace"`         // Category -> time on battery before its stacks stop, or never
+}
+
+// Status is the state of the UPS
+type Status struct {
+	OnBattery  bool
+	LowBattery bool
+	Raw        string // Status as the daemon reports it, e.g. "OB LB"
+}
+
+// LoadConfig reads inventory/power.yaml; a missing file means no UPS is followed
+func LoadConfig() (*Config, error) {
+	config := &Config{}
+
+	data, err := os.ReadFile(paths.InventoryPower)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryPower, err)
+	}
+
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryPower, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", paths.InventoryPower, err)
+	}
+	return config, nil
+}
+
+// validate checks the source and the grace periods
+func (c *Config) validate() error {
+	if c.Source != SourceNUT && c.Source != SourceApcupsd {
+		return fmt.Errorf("invalid source '%s' (use %s)", c.Source, strings.Join(Sources, " or "))
+	}
+	if c.DefaultGrace != "" {
+		if _, _, err := parseGrace(c.DefaultGrace); err != nil {
+			return fmt.Errorf("default_grace: %w", err)
+		}
+	}
+	for category, grace := range c.Grace {
+		if _, _, err := parseGrace(grace); err != nil {
+			return fmt.Errorf("grace of %s: %w", category, err)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether a UPS source is configured
+func (c *Config) Enabled() bool {
+	return c.Source != ""
+}
+
+// GraceOf returns how long the UPS may be on battery before a category's stacks stop;
+// ok is false for a category kept running until the battery is low
+func (c *Config) GraceOf(category string) (time.Duration, bool) {
+	value, set := c.Grace[category]
+	if !set {
+		if c.DefaultGrace == "" {
+			return defaultGrace, true
+		}
+		value = c.DefaultGrace
+	}
+	grace, ok, _ := parseGrace(value)
+	return grace, ok
+}
+
+// Due reports whether a category's stacks must stop after onBattery on battery
+// A low battery stops every category, whatever its grace period
+func (c *Config) Due(category string, onBattery time.Duration, lowBattery bool) bool {
+	if lowBattery {
+		return true
+	}
+	grace, ok := c.GraceOf(category)
+	return ok && onBattery >= grace
+}
+
+// parseGrace parses a grace period: a duration, or never (ok false)
+func parseGrace(value string) (time.Duration, bool, error) {
+	if value == Never {
+		return 0, false, nil
+	}
+	grace, err := time.ParseDuration(value)
+	if err != nil || grace < 0 {
+		return 0, false, fmt.Errorf("invalid grace period '%s' (use a duration, e.g. 2m, or %s)", value, Never)
+	}
+	return grace, true, nil
+}
+
+// ReadStatus asks the UPS daemon for the status of the UPS
+func (c *Config) ReadStatus() (Status, error) {
+	var cmd *exec.Cmd
+	if c.Source == SourceApcupsd {
+		cmd = exec.Command("apcaccess", "status")
+	} else {
+		ups := c.UPS
+		if ups == "" {
+			ups = defaultUPS
+		}
+		cmd = exec.Command("upsc", ups, "ups.status")
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return Status{}, fmt.Errorf("%s failed: %w", strings.Join(cmd.Args, " "), err)
+	}
+	if c.Source == SourceApcupsd {
+		return ParseApcupsd(string(out))
+	}
+	return ParseNUT(string(out))
+}
+
+// ParseNUT parses the output of upsc <ups> ups.status, e.g. "OB LB"
+func ParseNUT(out string) (Status, error) {
+	raw := strings.TrimSpace(out)
+	// upsc <ups> without a variable lists every variable
+	for _, line := range strings.Split(raw, "\n") {
+		if value, ok := strings.CutPrefix(line, "ups.status:"); ok {
+			raw = strings.TrimSpace(value)
+		}
+	}
+
+	flags := strings.Fields(raw)
+	if len(flags) == 0 {
+		return Status{}, fmt.Errorf("upsc reported no ups.status")
+	}
+	status := Status{Raw: raw}
+	for _, flag := range flags {
+		switch flag {
+		case "OB":
+			status.OnBattery = true
+		case "LB":
+			status.LowBattery = true
+		}
+	}
+	return status, nil
+}
+
+// ParseApcupsd parses the output of apcaccess status, looking for its STATUS line
+func ParseApcupsd(out string) (Status, error) {
+	for _, line := range strings.Split(out, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) != "STATUS" {
+			continue
+		}
+
+		raw := strings.TrimSpace(value)
+		status := Status{Raw: raw}
+		for _, flag := range strings.Fields(raw) {
+			switch flag {
+			case "ONBATT":
+				status.OnBattery = true
+			case "LOWBATT":
+				status.LowBattery = true
+			}
+		}
+		return status, nil
+	}
+	return Status{}, fmt.Errorf("apcaccess reported no STATUS")
+}
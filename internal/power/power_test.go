@@ -0,0 +1,111 @@
+package power
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	config, err := LoadConfig()
+	if err != nil || config.Enabled() {
+		t.Fatalf("LoadConfig() without a file = %+v, %v, want no UPS", config, err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", "source: nut\nups: eaton@nas\ndefault_grace: 2m\ngrace:\n  media: 30s\n  core: never\n", ""},
+		{"bad source", "source: snmp\n", "invalid source"},
+		{"no source", "grace:\n  media: 1m\n", "invalid source"},
+		{"bad grace", "source: apcupsd\ngrace:\n  media: soon\n", "grace of media"},
+		{"bad default", "source: apcupsd\ndefault_grace: -1m\n", "default_grace"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.WriteFile(t, paths.InventoryPower, tt.content)
+			_, err := LoadConfig()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("LoadConfig() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("LoadConfig() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDue(t *testing.T) {
+	config := &Config{Source: SourceNUT, DefaultGrace: "2m", Grace: map[string]string{"media": "30s", "core": Never}}
+
+	tests := []struct {
+		category   string
+		onBattery  time.Duration
+		lowBattery bool
+		want       bool
+	}{
+		{"media", 10 * time.Second, false, false},
+		{"media", 30 * time.Second, false, true},
+		{"monitoring", time.Minute, false, false}, // default_grace
+		{"monitoring", 2 * time.Minute, false, true},
+		{"core", time.Hour, false, false},
+		{"core", time.Second, true, true}, // A low battery stops everything
+	}
+
+	for _, tt := range tests {
+		if got := config.Due(tt.category, tt.onBattery, tt.lowBattery); got != tt.want {
+			t.Errorf("Due(%s, %s, low %v) = %v, want %v", tt.category, tt.onBattery, tt.lowBattery, got, tt.want)
+		}
+	}
+
+	if grace, ok := (&Config{}).GraceOf("media"); !ok || grace != defaultGrace {
+		t.Errorf("GraceOf() without settings = %s, %v, want %s", grace, ok, defaultGrace)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		parse  func(string) (Status, error)
+		out    string
+		onBatt bool
+		low    bool
+	}{
+		{"nut online", ParseNUT, "OL CHRG\n", false, false},
+		{"nut on battery", ParseNUT, "OB DISCHRG\n", true, false},
+		{"nut low battery", ParseNUT, "battery.charge: 9\nups.status: OB LB\n", true, true},
+		{"apcupsd online", ParseApcupsd, "APC      : 001,036,0879\nSTATUS   : ONLINE \nBCHARGE  : 100.0 Percent\n", false, false},
+		{"apcupsd on battery", ParseApcupsd, "STATUS   : ONBATT \n", true, false},
+		{"apcupsd low battery", ParseApcupsd, "STATUS   : ONBATT LOWBATT\n", true, true},
+	}
+
+	for _, tt := range tests {
+		status, err := tt.parse(tt.out)
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.name, err)
+			continue
+		}
+		if status.OnBattery != tt.onBatt || status.LowBattery != tt.low {
+			t.Errorf("%s: status = %+v, want on battery %v, low %v", tt.name, status, tt.onBatt, tt.low)
+		}
+	}
+
+	if _, err := ParseApcupsd("BCHARGE  : 100.0 Percent\n"); err == nil {
+		t.Error("ParseApcupsd() without STATUS should fail")
+	}
+	if _, err := ParseNUT("\n"); err == nil {
+		t.Error("ParseNUT() without a status should fail")
+	}
+}
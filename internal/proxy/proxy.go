@@ -0,0 +1,307 @@
+// Package proxy turns the expose declarations of stacks into the configuration of the
+// reverse proxy the inventory selects: Traefik labels, a Caddyfile, or Nginx Proxy
+// Manager proxy hosts; and of the remote access ones (Cloudflare Tunnel, Tailscale)
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Reverse-proxy providers
+const (
+	ProviderTraefik = "traefik" // Labels on the services, read by Traefik's docker provider
+	ProviderCaddy   = "caddy"   // Caddyfile site blocks, imported by the Caddy config
+	ProviderNPM     = "npm"     // Proxy hosts for the Nginx Proxy Manager API
+)
+
+// Providers lists the available providers
+var Providers = []string{ProviderTraefik, ProviderCaddy, ProviderNPM}
+
+// Remote access, on top of the reverse proxy (expose_via in stack.yaml)
+const (
+	ViaTunnel    = "tunnel"    // Cloudflare Tunnel ingress rule, in the config of the cloudflared service
+	ViaTailscale = "tailscale" // tailscale serve config, for a tailscale sidecar of the service
+)
+
+// Vias lists the available remote accesses
+var Vias = []string{ViaTunnel, ViaTailscale}
+
+// header is the comment on top of the generated files
+const header = "Generated by homelabctl generate from the expose sections of stack.yaml - do not edit"
+
+// Settings is the proxy section of inventory/vars.yaml
+type Settings struct {
+	Provider     string // Defaults to traefik
+	Entrypoint   string // Traefik entrypoint of the routers, e.g. websecure
+	CertResolver string // Traefik certificate resolver of the routers, e.g. letsencrypt
+
+	TunnelID          string // Cloudflare Tunnel UUID (proxy.tunnel.id)
+	TunnelCredentials string // Credentials file in the cloudflared container (proxy.tunnel.credentials_file)
+}
+
+// Load reads the proxy section of the inventory variables
+func Load(vars map[string]interface{}) (Settings, error) {
+	section, _ := vars["proxy"].(map[string]interface{})
+	settings := Settings{Provider: ProviderTraefik}
+	if provider, ok := section["provider"].(string); ok && provider != "" {
+		settings.Provider = provider
+	}
+	settings.Entrypoint, _ = section["entrypoint"].(string)
+	settings.CertResolver, _ = section["certresolver"].(string)
+
+	tunnel, _ := section["tunnel"].(map[string]interface{})
+	settings.TunnelID, _ = tunnel["id"].(string)
+	settings.TunnelCredentials, _ = tunnel["credentials_file"].(string)
+	if settings.TunnelCredentials == "" {
+		settings.TunnelCredentials = "/etc/cloudflared/credentials.json"
+	}
+
+	for _, p := range Providers {
+		if p == settings.Provider {
+			return settings, nil
+		}
+	}
+	return settings, fmt.Errorf("unknown proxy provider: %s (available: %s)", settings.Provider, strings.Join(Providers, ", "))
+}
+
+// Route publishes a service's port on a host name, optionally under a path prefix
+type Route struct {
+	Stack   string
+	Service string
+	Host    string
+	Port    int
+	Path    string
+	Via     string // Remote access, if any (ViaTunnel, ViaTailscale)
+}
+
+// FileName returns the name of a provider's file in runtime/proxy/ ("" for Traefik, configured by labels)
+func FileName(provider string) string {
+	switch provider {
+	case ProviderCaddy:
+		return "Caddyfile"
+	case ProviderNPM:
+		return "npm-proxy-hosts.json"
+	default:
+		return ""
+	}
+}
+
+// TraefikLabels returns the labels routing a route's requests to its service
+// The router and service are named after the compose service
+func TraefikLabels(route Route, settings Settings) map[string]string {
+	router := "traefik.http.routers." + route.Service
+	rule := "Host(`" + route.Host + "`)"
+	if route.Path != "" {
+		rule += " && PathPrefix(`" + route.Path + "`)"
+	}
+
+	labels := map[string]string{
+		"traefik.enable":    "true",
+		router + ".rule":    rule,
+		router + ".service": route.Service,
+		"traefik.http.services." + route.Service + ".loadbalancer.server.port": strconv.Itoa(route.Port),
+	}
+	if settings.Entrypoint != "" {
+		labels[router+".entrypoints"] = settings.Entrypoint
+	}
+	if settings.CertResolver != "" {
+		labels[router+".tls.certresolver"] = settings.CertResolver
+	}
+	return labels
+}
+
+// Caddyfile returns one site block per host, reverse proxying to the services by their
+// compose name: the Caddy container must share a network with them
+func Caddyfile(routes []Route) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n# Import from the Caddy config: import /path/to/runtime/proxy/Caddyfile\n", header)
+
+	for _, host := range hostNames(routes) {
+		hostRoutes := routesOf(routes, host)
+		fmt.Fprintf(&b, "\n%s {\n", host)
+		if len(hostRoutes) == 1 && hostRoutes[0].Path == "" {
+			fmt.Fprintf(&b, "\treverse_proxy %s\n}\n", upstream(hostRoutes[0]))
+			continue
+		}
+		// Caddy tries handle blocks from the most specific path
+		for _, r := range hostRoutes {
+			matcher := ""
+			if r.Path != "" {
+				matcher = strings.TrimSuffix(r.Path, "/") + "* "
+			}
+			fmt.Fprintf(&b, "\thandle %s{\n\t\treverse_proxy %s\n\t}\n", matcher, upstream(r))
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// npmHost is a proxy host as the Nginx Proxy Manager API creates it
+type npmHost struct {
+	DomainNames           []string      `json:"domain_names"`
+	ForwardScheme         string        `json:"forward_scheme"`
+	ForwardHost           string        `json:"forward_host"`
+	ForwardPort           int           `json:"forward_port"`
+	BlockExploits         bool          `json:"block_exploits"`
+	AllowWebsocketUpgrade bool          `json:"allow_websocket_upgrade"`
+	Locations             []npmLocation `json:"locations"`
+}
+
+type npmLocation struct {
+	Path          string `json:"path"`
+	ForwardScheme string `json:"forward_scheme"`
+	ForwardHost   string `json:"forward_host"`
+	ForwardPort   int    `json:"forward_port"`
+}
+
+// NPMHosts returns the bodies to POST to /api/nginx/proxy-hosts, one per host, as a JSON list
+// A host without a route at its root forwards there to its shortest path route
+func NPMHosts(routes []Route) (string, error) {
+	hosts := []npmHost{}
+	for _, host := range hostNames(routes) {
+		hostRoutes := routesOf(routes, host)
+
+		root := hostRoutes[len(hostRoutes)-1]
+		for _, r := range hostRoutes {
+			if r.Path == "" {
+				root = r
+			}
+		}
+
+		h := npmHost{
+			DomainNames:           []string{host},
+			ForwardScheme:         "http",
+			ForwardHost:           root.Service,
+			ForwardPort:           root.Port,
+			BlockExploits:         true,
+			AllowWebsocketUpgrade: true,
+			Locations:             []npmLocation{},
+		}
+		for _, r := range hostRoutes {
+			if r.Path != "" {
+				h.Locations = append(h.Locations, npmLocation{Path: r.Path, ForwardScheme: "http", ForwardHost: r.Service, ForwardPort: r.Port})
+			}
+		}
+		hosts = append(hosts, h)
+	}
+
+	data, err := json.MarshalIndent(hosts, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal proxy hosts: %w", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// cloudflaredConfig is the schema of a locally managed cloudflared tunnel config
+type cloudflaredConfig struct {
+	Tunnel          string               `yaml:"tunnel,omitempty"`
+	CredentialsFile string               `yaml:"credentials-file,omitempty"`
+	Ingress         []cloudflaredIngress `yaml:"ingress"`
+}
+
+type cloudflaredIngress struct {
+	Hostname string `yaml:"hostname,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+	Service  string `yaml:"service"`
+}
+
+// CloudflaredConfig returns the cloudflared config routing the tunnel routes to their
+// services, most specific path first, and everything else to a 404
+// Without a tunnel ID, the tunnel and credentials-file keys are left out
+func CloudflaredConfig(routes []Route, settings Settings) (string, error) {
+	config := cloudflaredConfig{}
+	if settings.TunnelID != "" {
+		config.Tunnel = settings.TunnelID
+		config.CredentialsFile = settings.TunnelCredentials
+	}
+
+	for _, host := range hostNames(routes) {
+		for _, r := range routesOf(routes, host) {
+			ingress := cloudflaredIngress{Hostname: host, Service: "http://" + upstream(r)}
+			if r.Path != "" {
+				// cloudflared matches paths with a regular expression
+				ingress.Path = "^" + regexp.QuoteMeta(r.Path)
+			}
+			config.Ingress = append(config.Ingress, ingress)
+		}
+	}
+	config.Ingress = append(config.Ingress, cloudflaredIngress{Service: "http_status:404"})
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cloudflared config: %w", err)
+	}
+	return "# " + header + "\n" + string(data), nil
+}
+
+// TailscaleServe returns the serve config (TS_SERVE_CONFIG) of a tailscale sidecar
+// publishing a route's service over HTTPS on the tailnet; the container fills in
+// ${TS_CERT_DOMAIN}
+func TailscaleServe(route Route) (string, error) {
+	path := route.Path
+	if path == "" {
+		path = "/"
+	}
+
+	config := map[string]interface{}{
+		"TCP": map[string]interface{}{
+			"443": map[string]interface{}{"HTTPS": true},
+		},
+		"Web": map[string]interface{}{
+			"${TS_CERT_DOMAIN}:443": map[string]interface{}{
+				"Handlers": map[string]interface{}{
+					path: map[string]interface{}{"Proxy": "http://" + upstream(route)},
+				},
+			},
+		},
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal tailscale serve config: %w", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// hostNames returns the sorted host names of the routes
+func hostNames(routes []Route) []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, r := range routes {
+		if !seen[r.Host] {
+			seen[r.Host] = true
+			hosts = append(hosts, r.Host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// routesOf returns the routes of a host, longest path first and the root route last
+func routesOf(routes []Route, host string) []Route {
+	var result []Route
+	for _, r := range routes {
+		if r.Host == host {
+			result = append(result, r)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if len(result[i].Path) != len(result[j].Path) {
+			return len(result[i].Path) > len(result[j].Path)
+		}
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// upstream returns the address a route forwards to
+func upstream(r Route) string {
+	return r.Service + ":" + strconv.Itoa(r.Port)
+}
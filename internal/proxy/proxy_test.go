@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var testRoutes = []Route{
+	{Stack: "media", Service: "jellyfin", Host: "media.home.lan", Port: 8096},
+	{Stack: "media", Service: "jellyseerr", Host: "media.home.lan", Port: 5055, Path: "/requests"},
+	{Stack: "monitoring", Service: "grafana", Host: "grafana.home.lan", Port: 3000},
+}
+
+func TestLoad(t *testing.T) {
+	settings, err := Load(map[string]interface{}{})
+	if err != nil || settings.Provider != ProviderTraefik {
+		t.Errorf("Load() = %+v, %v, want the traefik default", settings, err)
+	}
+
+	settings, err = Load(map[string]interface{}{"proxy": map[string]interface{}{"provider": "caddy"}})
+	if err != nil || settings.Provider != ProviderCaddy {
+		t.Errorf("Load() = %+v, %v, want caddy", settings, err)
+	}
+
+	if _, err := Load(map[string]interface{}{"proxy": map[string]interface{}{"provider": "haproxy"}}); err == nil {
+		t.Error("Load() should fail for an unknown provider")
+	}
+}
+
+func TestTraefikLabels(t *testing.T) {
+	labels := TraefikLabels(testRoutes[1], Settings{Entrypoint: "websecure", CertResolver: "letsencrypt"})
+
+	want := map[string]string{
+		"traefik.enable":                                            "true",
+		"traefik.http.routers.jellyseerr.rule":                      "Host(`media.home.lan`) && PathPrefix(`/requests`)",
+		"traefik.http.routers.jellyseerr.service":                   "jellyseerr",
+		"traefik.http.routers.jellyseerr.entrypoints":               "websecure",
+		"traefik.http.routers.jellyseerr.tls.certresolver":          "letsencrypt",
+		"traefik.http.services.jellyseerr.loadbalancer.server.port": "5055",
+	}
+	if len(labels) != len(want) {
+		t.Errorf("TraefikLabels() = %v, want %v", labels, want)
+	}
+	for key, value := range want {
+		if labels[key] != value {
+			t.Errorf("label %s = %q, want %q", key, labels[key], value)
+		}
+	}
+}
+
+func TestCaddyfile(t *testing.T) {
+	got := Caddyfile(testRoutes)
+
+	for _, want := range []string{
+		"\ngrafana.home.lan {\n\treverse_proxy grafana:3000\n}\n",
+		"\nmedia.home.lan {\n\thandle /requests* {\n\t\treverse_proxy jellyseerr:5055\n\t}\n\thandle {\n\t\treverse_proxy jellyfin:8096\n\t}\n}\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Caddyfile() missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Index(got, "grafana.home.lan") > strings.Index(got, "media.home.lan") {
+		t.Error("Caddyfile() should sort hosts")
+	}
+}
+
+func TestNPMHosts(t *testing.T) {
+	got, err := NPMHosts(testRoutes)
+	if err != nil {
+		t.Fatalf("NPMHosts() error = %v", err)
+	}
+
+	var hosts []npmHost
+	if err := json.Unmarshal([]byte(got), &hosts); err != nil {
+		t.Fatalf("NPMHosts() is not JSON: %v", err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("NPMHosts() = %d host(s), want 2", len(hosts))
+	}
+
+	media := hosts[1]
+	if media.ForwardHost != "jellyfin" || media.ForwardPort != 8096 {
+		t.Errorf("media forwards to %s:%d, want jellyfin:8096", media.ForwardHost, media.ForwardPort)
+	}
+	if len(media.Locations) != 1 || media.Locations[0].Path != "/requests" || media.Locations[0].ForwardHost != "jellyseerr" {
+		t.Errorf("media locations = %+v, want /requests to jellyseerr", media.Locations)
+	}
+}
+
+func TestCloudflaredConfig(t *testing.T) {
+	got, err := CloudflaredConfig(testRoutes[:2], Settings{TunnelID: "6ff42ae2", TunnelCredentials: "/etc/cloudflared/creds.json"})
+	if err != nil {
+		t.Fatalf("CloudflaredConfig() error = %v", err)
+	}
+
+	want := `tunnel: 6ff42ae2
+credentials-file: /etc/cloudflared/creds.json
+ingress:
+    - hostname: media.home.lan
+      path: ^/requests
+      service: http://jellyseerr:5055
+    - hostname: media.home.lan
+      service: http://jellyfin:8096
+    - service: http_status:404
+`
+	if !strings.HasSuffix(got, want) {
+		t.Errorf("CloudflaredConfig() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTailscaleServe(t *testing.T) {
+	got, err := TailscaleServe(testRoutes[1])
+	if err != nil {
+		t.Fatalf("TailscaleServe() error = %v", err)
+	}
+
+	var config struct {
+		Web map[string]struct {
+			Handlers map[string]struct{ Proxy string }
+		}
+	}
+	if err := json.Unmarshal([]byte(got), &config); err != nil {
+		t.Fatalf("TailscaleServe() is not JSON: %v", err)
+	}
+	if proxy := config.Web["${TS_CERT_DOMAIN}:443"].Handlers["/requests"].Proxy; proxy != "http://jellyseerr:5055" {
+		t.Errorf("handler proxies to %q, want http://jellyseerr:5055", proxy)
+	}
+}
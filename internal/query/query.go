@@ -0,0 +1,404 @@
+// Package query implements a small expression language for selecting services
+//
+//	labels["traefik.enable"] == "true" && !network_mode
+//	image =~ "^ghcr.io/" || stack == "media"
+//
+// Paths read fields of a service record (dot or bracket syntax), string literals use
+// single or double quotes. Operators: == != =~ (regex) && || ! and parentheses.
+// A bare path is true when the field is set and not empty, "false" or 0. Comparing a
+// list matches if any element matches
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expr is a parsed query
+type Expr struct {
+	root node
+}
+
+// Match reports whether a record satisfies the query
+func (e *Expr) Match(record map[string]interface{}) bool {
+	return truthy(e.root.eval(record))
+}
+
+// Parse parses a query expression
+func Parse(input string) (*Expr, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos+1)
+	}
+
+	return &Expr{root: root}, nil
+}
+
+// Tokens
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokOp
+	tokLBracket
+	tokRBracket
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize splits a query into tokens
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			var sb strings.Builder
+			for end < len(input) && input[end] != c {
+				if input[end] == '\\' && end+1 < len(input) {
+					end++
+				}
+				sb.WriteByte(input[end])
+				end++
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("unterminated string at position %d", i+1)
+			}
+			tokens = append(tokens, token{tokString, sb.String(), i})
+			i = end + 1
+		case c == '[':
+			tokens = append(tokens, token{tokLBracket, "[", i})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tokRBracket, "]", i})
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case strings.HasPrefix(input[i:], "==") || strings.HasPrefix(input[i:], "!=") ||
+			strings.HasPrefix(input[i:], "=~") || strings.HasPrefix(input[i:], "&&") ||
+			strings.HasPrefix(input[i:], "||"):
+			tokens = append(tokens, token{tokOp, input[i : i+2], i})
+			i += 2
+		case c == '!':
+			tokens = append(tokens, token{tokOp, "!", i})
+			i++
+		case isIdentChar(c):
+			start := i
+			for i < len(input) && isIdentChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, input[start:i], start})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at position %d", c, i+1)
+		}
+	}
+
+	return append(tokens, token{tokEOF, "end of query", len(input)}), nil
+}
+
+// isIdentChar reports whether c can appear in a field path or bare literal
+func isIdentChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.'
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// parseOr parses: and ('||' and)*
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: unary ('&&' unary)*
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOp && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+// parseUnary parses: '!' unary | '(' or ')' | comparison
+func (p *parser) parseUnary() (node, error) {
+	tok := p.peek()
+
+	if tok.kind == tokOp && tok.text == "!" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+
+	if tok.kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, fmt.Errorf("expected ')' at position %d, got %q", closing.pos+1, closing.text)
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+// parseComparison parses: operand (('==' | '!=' | '=~') operand)?
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	if tok.kind != tokOp || (tok.text != "==" && tok.text != "!=" && tok.text != "=~") {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok.text == "=~" {
+		pattern, ok := right.(literalNode)
+		if !ok {
+			return nil, fmt.Errorf("=~ needs a quoted regular expression at position %d", tok.pos+1)
+		}
+		re, err := regexp.Compile(string(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", string(pattern), err)
+		}
+		return regexNode{left, re}, nil
+	}
+
+	return compareNode{left, right, tok.text == "!="}, nil
+}
+
+// parseOperand parses a string literal, a bare number/boolean literal or a field path
+func (p *parser) parseOperand() (node, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokString:
+		return literalNode(tok.text), nil
+	case tokIdent:
+		if tok.text == "true" || tok.text == "false" || isNumber(tok.text) {
+			return literalNode(tok.text), nil
+		}
+
+		path := strings.Split(tok.text, ".")
+		for _, segment := range path {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid field path %q at position %d", tok.text, tok.pos+1)
+			}
+		}
+
+		// Bracket keys allow any characters, e.g. labels["traefik.enable"]
+		for p.peek().kind == tokLBracket {
+			p.next()
+			key := p.next()
+			if key.kind != tokString && key.kind != tokIdent {
+				return nil, fmt.Errorf("expected a key at position %d, got %q", key.pos+1, key.text)
+			}
+			if closing := p.next(); closing.kind != tokRBracket {
+				return nil, fmt.Errorf("expected ']' at position %d, got %q", closing.pos+1, closing.text)
+			}
+			path = append(path, key.text)
+		}
+
+		return pathNode(path), nil
+	default:
+		return nil, fmt.Errorf("expected a field or value at position %d, got %q", tok.pos+1, tok.text)
+	}
+}
+
+// isNumber reports whether s is an integer or decimal literal
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// Evaluation
+
+type node interface {
+	eval(record map[string]interface{}) interface{}
+}
+
+type literalNode string
+
+func (n literalNode) eval(map[string]interface{}) interface{} { return string(n) }
+
+type pathNode []string
+
+func (n pathNode) eval(record map[string]interface{}) interface{} {
+	var current interface{} = record
+	for _, segment := range n {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			current = v[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil
+			}
+			current = v[index]
+		default:
+			return nil
+		}
+	}
+	return current
+}
+
+type notNode struct{ operand node }
+
+func (n notNode) eval(record map[string]interface{}) interface{} {
+	return !truthy(n.operand.eval(record))
+}
+
+type andNode struct{ left, right node }
+
+func (n andNode) eval(record map[string]interface{}) interface{} {
+	return truthy(n.left.eval(record)) && truthy(n.right.eval(record))
+}
+
+type orNode struct{ left, right node }
+
+func (n orNode) eval(record map[string]interface{}) interface{} {
+	return truthy(n.left.eval(record)) || truthy(n.right.eval(record))
+}
+
+type compareNode struct {
+	left, right node
+	negate      bool
+}
+
+func (n compareNode) eval(record map[string]interface{}) interface{} {
+	right := toString(n.right.eval(record))
+	equal := anyValue(n.left.eval(record), func(s string) bool { return s == right })
+	return equal != n.negate
+}
+
+type regexNode struct {
+	left    node
+	pattern *regexp.Regexp
+}
+
+func (n regexNode) eval(record map[string]interface{}) interface{} {
+	return anyValue(n.left.eval(record), n.pattern.MatchString)
+}
+
+// anyValue applies match to a value, or to each element of a list
+func anyValue(value interface{}, match func(string) bool) bool {
+	if list, ok := value.([]interface{}); ok {
+		for _, item := range list {
+			if match(toString(item)) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(toString(value))
+}
+
+// toString formats a scalar for comparison; missing fields are the empty string
+func toString(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// truthy reports whether a value counts as true in a boolean context
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false" && v != "0"
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
@@ -0,0 +1,72 @@
+package query
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	record := map[string]interface{}{
+		"name":     "grafana",
+		"stack":    "monitoring",
+		"image":    "grafana/grafana:11.2.0",
+		"restart":  "unless-stopped",
+		"ports":    []interface{}{"3000:3000"},
+		"labels":   map[string]interface{}{"traefik.enable": "true", "homelabctl.category": "monitoring"},
+		"deploy":   map[string]interface{}{"replicas": 2},
+		"disabled": "false",
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`labels["traefik.enable"] == "true"`, true},
+		{`labels['traefik.enable'] != "true"`, false},
+		{`labels["missing"] == ""`, true},
+		{`ports`, true},
+		{`!ports`, false},
+		{`network_mode`, false},
+		{`disabled`, false},
+		{`ports == "3000:3000"`, true},
+		{`ports == "80:80"`, false},
+		{`image =~ "^grafana/"`, true},
+		{`image =~ "^ghcr.io/"`, false},
+		{`deploy.replicas == 2`, true},
+		{`deploy["replicas"] == "2"`, true},
+		{`ports.0 == "3000:3000"`, true},
+		{`stack == "media" || name == "grafana"`, true},
+		{`stack == "monitoring" && !(restart == "always")`, true},
+		{`(stack == "media" || stack == "apps") && ports`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse() unexpected error: %v", err)
+			}
+			if got := expr.Match(record); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	invalid := []string{
+		``,
+		`labels["traefik.enable"`,
+		`stack == "media`,
+		`stack == `,
+		`(stack == "media"`,
+		`stack == "a" extra`,
+		`image =~ name`,
+		`image =~ "["`,
+		`stack $ "a"`,
+		`a..b`,
+	}
+
+	for _, input := range invalid {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q) should fail", input)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// Package registry queries container registries through the Docker Registry HTTP API v2:
+// the tags of a repository and the digest a tag points to, anonymously or with an
+// account, through the registry's token service when it has one
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/compose"
+)
+
+// requestTimeout bounds each API call
+const requestTimeout = 15 * time.Second
+
+// scheme of the registry API, replaced in tests
+var scheme = "https"
+
+// dockerHubAPI is the API host of Docker Hub images (docker.io)
+const dockerHubAPI = "registry-1.docker.io"
+
+// manifestTypes are the manifests a digest is asked for: the multi-platform index a
+// pull resolves first, or the single manifest of a one-platform image
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Reference is an image reference split into its registry, repository and tag or digest
+type Reference struct {
+	Registry   string // Registry host (docker.io for Docker Hub)
+	Repository string // Repository path, library/ for official Docker Hub images
+	Tag        string // latest when the reference has neither tag nor digest
+	Digest     string
+}
+
+// ParseReference splits an image reference; the implicit tag is latest
+func ParseReference(image string) Reference {
+	registry, path := compose.ImageRegistry(compose.NormalizeImage(image))
+
+	ref := Reference{Registry: registry}
+	if name, digest, ok := strings.Cut(path, "@"); ok {
+		path, ref.Digest = name, digest
+	}
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "/") {
+		path, ref.Tag = path[:i], path[i+1:]
+	}
+	ref.Repository = path
+	return ref
+}
+
+// Credentials returns the account of a registry host; ok is false to query it anonymously
+type Credentials func(registry string) (username, password string, ok bool, err error)
+
+// Client queries registries, keeping the tokens it was granted per repository
+// It is safe for concurrent use
+type Client struct {
+	http        *http.Client
+	credentials Credentials
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// NewClient returns a client authenticating with credentials (nil: anonymously)
+func NewClient(credentials Credentials) *Client {
+	return &Client{
+		http:        &http.Client{Timeout: requestTimeout},
+		credentials: credentials,
+		tokens:      make(map[string]string),
+	}
+}
+
+// Tags returns the tags of a reference's repository, following the pages of the list
+func (c *Client) Tags(ref Reference) ([]string, error) {
+	var tags []string
+	next := fmt.Sprintf("/v2/%s/tags/list", ref.Repository)
+	for next != "" {
+		resp, err := c.request(ref, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the tags of %s: %w", ref.Repository, err)
+		}
+		tags = append(tags, page.Tags...)
+		next = nextPage(resp.Header.Get("Link"))
+	}
+	return tags, nil
+}
+
+// Digest returns the digest the reference's tag currently points to
+func (c *Client) Digest(ref Reference) (string, error) {
+	resp, err := c.request(ref, http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", ref.Repository, ref.Tag), manifestTypes)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("%s/%s:%s: the registry did not return a digest", ref.Registry, ref.Repository, ref.Tag)
+	}
+	return digest, nil
+}
+
+// request calls the registry API, authenticating as the registry asks on a 401 reply
+func (c *Client) request(ref Reference, method, path string, accept []string) (*http.Response, error) {
+	key := ref.Registry + "/" + ref.Repository
+	send := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequest(method, apiURL(ref.Registry, path), nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, mediaType := range accept {
+			req.Header.Add("Accept", mediaType)
+		}
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %w", ref.Registry, err)
+		}
+		return resp, nil
+	}
+
+	c.mu.Lock()
+	token := c.tokens[key]
+	c.mu.Unlock()
+
+	resp, err := send(token)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		authorization, err := c.authorize(ref, challenge)
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.tokens[key] = authorization
+		c.mu.Unlock()
+		if resp, err = send(authorization); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", ref.Registry, ref.Repository, resp.Status)
+	}
+	return resp, nil
+}
+
+// authorize answers a WWW-Authenticate challenge with an Authorization header: a bearer
+// token from the registry's token service, or the account for basic authentication
+func (c *Client) authorize(ref Reference, challenge string) (string, error) {
+	username, password, hasAccount := "", "", false
+	if c.credentials != nil {
+		var err error
+		if username, password, hasAccount, err = c.credentials(ref.Registry); err != nil {
+			return "", err
+		}
+	}
+
+	kind, params := parseChallenge(challenge)
+	switch kind {
+	case "basic":
+		if !hasAccount {
+			return "", fmt.Errorf("%s requires an account: add credentials for it to inventory/registries.yaml", ref.Registry)
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("%s: unsupported authentication challenge %q", ref.Registry, challenge)
+	}
+
+	query := url.Values{}
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", ref.Repository)
+	}
+	query.Set("scope", scope)
+
+	req, err := http.NewRequest(http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", fmt.Errorf("%s: invalid token realm %q: %w", ref.Registry, params["realm"], err)
+	}
+	if hasAccount {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a token for %s: %w", ref.Registry, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get a token for %s: %s", ref.Registry, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("failed to parse the token of %s: %w", ref.Registry, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// apiURL returns the URL of an API path on a registry host
+func apiURL(registry, path string) string {
+	if strings.HasPrefix(path, scheme+"://") {
+		return path // A Link to the next page may be absolute
+	}
+	if registry == compose.DockerHub {
+		registry = dockerHubAPI
+	}
+	return scheme + "://" + registry + path
+}
+
+// parseChallenge splits a WWW-Authenticate header into its lowercased scheme and parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	kind, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+	for _, match := range challengeParam.FindAllStringSubmatch(rest, -1) {
+		params[strings.ToLower(match[1])] = match[2]
+	}
+	return strings.ToLower(kind), params
+}
+
+// challengeParam matches a key="value" parameter of an authentication challenge
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// nextPage returns the path of the next page from a Link header, or ""
+func nextPage(link string) string {
+	target, params, ok := strings.Cut(link, ";")
+	if !ok || !strings.Contains(params, `rel="next"`) {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(target), "<>")
+}
+
+// versionTag matches a tag made of a version number, with an optional v prefix and a
+// suffix naming a variant: 1.25, v2.10.4, 16.2-alpine
+var versionTag = regexp.MustCompile(`^(v?)(\d+(?:\.\d+)*)(.*)$`)
+
+// NewerTag returns the highest tag of the same shape as current, if higher: the same
+// prefix and suffix and as many version components; "" when there is none, or when
+// current (latest, stable) is not a version
+func NewerTag(current string, tags []string) string {
+	match := versionTag.FindStringSubmatch(current)
+	if match == nil {
+		return ""
+	}
+	prefix, suffix := match[1], match[3]
+	best := versionNumbers(match[2])
+
+	newer := ""
+	for _, tag := range tags {
+		m := versionTag.FindStringSubmatch(tag)
+		if m == nil || m[1] != prefix || m[3] != suffix {
+			continue
+		}
+		numbers := versionNumbers(m[2])
+		if len(numbers) != len(best) || !versionLess(best, numbers) {
+			continue
+		}
+		best, newer = numbers, tag
+	}
+	return newer
+}
+
+// versionNumbers splits a dotted version number
+func versionNumbers(version string) []int {
+	parts := strings.Split(version, ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		numbers[i], _ = strconv.Atoi(part)
+	}
+	return numbers
+}
+
+// versionLess compares two versions of as many components
+func versionLess(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		image string
+		want  Reference
+	}{
+		{"nginx", Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"}},
+		{"grafana/grafana:10.2.0", Reference{Registry: "docker.io", Repository: "grafana/grafana", Tag: "10.2.0"}},
+		{"ghcr.io/org/app:v1.2", Reference{Registry: "ghcr.io", Repository: "org/app", Tag: "v1.2"}},
+		{"registry.lan:5000/app", Reference{Registry: "registry.lan:5000", Repository: "app", Tag: "latest"}},
+		{"redis@sha256:abc", Reference{Registry: "docker.io", Repository: "library/redis", Digest: "sha256:abc"}},
+	}
+	for _, tt := range tests {
+		if got := ParseReference(tt.image); got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestNewerTag(t *testing.T) {
+	tags := []string{"latest", "1.24", "1.25", "1.26", "1.26-alpine", "1.27-alpine", "1.26.1", "v2.0", "2.0rc1"}
+	tests := []struct {
+		current string
+		want    string
+	}{
+		{"1.24", "1.26"},
+		{"1.26", ""},
+		{"1.26-alpine", "1.27-alpine"},
+		{"1.25.0", "1.26.1"},
+		{"v1.0", "v2.0"},
+		{"latest", ""},
+	}
+	for _, tt := range tests {
+		if got := NewerTag(tt.current, tags); got != tt.want {
+			t.Errorf("NewerTag(%q) = %q, want %q", tt.current, got, tt.want)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	scheme = "http"
+	defer func() { scheme = "https" }()
+
+	var server *httptest.Server
+	tokens := 0
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if scope := r.URL.Query().Get("scope"); !strings.HasPrefix(scope, "repository:team/") || !strings.HasSuffix(scope, ":pull") {
+				t.Errorf("token scope = %q", scope)
+			}
+			if user, password, ok := r.BasicAuth(); !ok || user != "bot" || password != "secret" {
+				t.Errorf("token request without the account")
+			}
+			tokens++
+			fmt.Fprint(w, `{"token":"t0k3n"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/v2/team/app/tags/list" && r.URL.Query().Get("last") == "":
+			w.Header().Set("Link", `</v2/team/app/tags/list?last=1.1&n=2>; rel="next"`)
+			fmt.Fprint(w, `{"name":"team/app","tags":["1.0","1.1"]}`)
+		case r.URL.Path == "/v2/team/app/tags/list":
+			fmt.Fprint(w, `{"name":"team/app","tags":["1.2","latest"]}`)
+		case r.URL.Path == "/v2/team/app/manifests/latest" && r.Method == http.MethodHead:
+			if !strings.Contains(strings.Join(r.Header.Values("Accept"), ","), "manifest.list.v2+json") {
+				t.Errorf("manifest request does not accept manifest lists")
+			}
+			w.Header().Set("Docker-Content-Digest", "sha256:feed")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	client := NewClient(func(registry string) (string, string, bool, error) {
+		return "bot", "secret", registry == host, nil
+	})
+
+	ref := ParseReference(host + "/team/app")
+	tags, err := client.Tags(ref)
+	if err != nil {
+		t.Fatalf("Tags() failed: %v", err)
+	}
+	if want := []string{"1.0", "1.1", "1.2", "latest"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("Tags() = %v, want %v", tags, want)
+	}
+
+	digest, err := client.Digest(ref)
+	if err != nil {
+		t.Fatalf("Digest() failed: %v", err)
+	}
+	if digest != "sha256:feed" {
+		t.Errorf("Digest() = %q, want sha256:feed", digest)
+	}
+	if tokens != 1 {
+		t.Errorf("token requested %d times, want once", tokens)
+	}
+
+	if _, err := client.Tags(ParseReference(host + "/team/missing")); err == nil {
+		t.Error("Tags() of a missing repository should fail")
+	}
+}
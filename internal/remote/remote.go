@@ -0,0 +1,209 @@
+// Package remote runs the generated deployment on another machine over SSH
+// runtime/ is copied with rsync and docker commands are executed remotely, so the
+// remote host only needs sshd, rsync and docker
+package remote
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Host is an SSH deployment target from inventory/hosts.yaml
+type Host struct {
+	Name     string `yaml:"-"`
+	SSH      string `yaml:"ssh"`      // SSH destination, e.g. admin@nas.lan
+	Port     int    `yaml:"port"`     // SSH port (optional)
+	Identity string `yaml:"identity"` // Private key file (optional)
+	Path     string `yaml:"path"`     // Remote directory receiving runtime/
+}
+
+// hostsFile is the layout of inventory/hosts.yaml
+type hostsFile struct {
+	Hosts map[string]*Host `yaml:"hosts"`
+}
+
+// syncExcludes are runtime/ entries that only matter on the local machine
+// The leading / anchors them to runtime/ itself, so a stack's config/history or .lock
+// file is still synced
+var syncExcludes = []string{"/.lock", "/.staging", "/.staging-previous", "/history"}
+
+// LoadHost reads a host definition from inventory/hosts.yaml
+func LoadHost(name string) (*Host, error) {
+	file, err := loadHostsFile()
+	if os.IsNotExist(err) {
+		return nil, errors.New(
+			fmt.Sprintf("host '%s' is not defined: %s does not exist", name, paths.InventoryHosts),
+			fmt.Sprintf("Create %s with a hosts.%s entry (ssh, path)", paths.InventoryHosts, name),
+		).WithClass(errors.ClassNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	host, ok := file.Hosts[name]
+	if !ok || host == nil {
+		var names []string
+		for n := range file.Hosts {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		return nil, errors.New(
+			fmt.Sprintf("host '%s' is not defined in %s", name, paths.InventoryHosts),
+			fmt.Sprintf("Defined hosts: %s", strings.Join(names, ", ")),
+		).WithClass(errors.ClassNotFound)
+	}
+
+	host.Name = name
+	if err := host.check(); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+// LoadHosts reads every host of inventory/hosts.yaml, sorted by name
+// A missing file means no hosts
+func LoadHosts() ([]*Host, error) {
+	file, err := loadHostsFile()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make([]*Host, 0, len(file.Hosts))
+	for name, host := range file.Hosts {
+		if host == nil {
+			return nil, fmt.Errorf("host %s in %s is missing 'ssh'", name, paths.InventoryHosts)
+		}
+		host.Name = name
+		if err := host.check(); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts, nil
+}
+
+// loadHostsFile parses inventory/hosts.yaml; a missing file is returned as is
+func loadHostsFile() (*hostsFile, error) {
+	data, err := os.ReadFile(paths.InventoryHosts)
+	if os.IsNotExist(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.InventoryHosts, err)
+	}
+
+	var file hostsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", paths.InventoryHosts, err)
+	}
+	return &file, nil
+}
+
+// check validates the required fields of a host
+func (h *Host) check() error {
+	if h.SSH == "" {
+		return fmt.Errorf("host %s in %s is missing 'ssh'", h.Name, paths.InventoryHosts)
+	}
+	if !path.IsAbs(h.Path) {
+		return fmt.Errorf("host %s in %s needs an absolute 'path', got %q", h.Name, paths.InventoryHosts, h.Path)
+	}
+	return nil
+}
+
+// sshArgs returns the ssh options selecting the port and identity
+func (h *Host) sshArgs() []string {
+	var args []string
+	if h.Port != 0 {
+		args = append(args, "-p", strconv.Itoa(h.Port))
+	}
+	if h.Identity != "" {
+		args = append(args, "-i", h.Identity)
+	}
+	return args
+}
+
+// Command returns an ssh command running a program in the host's directory
+// tty allocates a terminal for interactive commands (exec, attach)
+func (h *Host) Command(tty bool, name string, args ...string) *exec.Cmd {
+	sshArgs := h.sshArgs()
+	if tty {
+		sshArgs = append(sshArgs, "-t")
+	}
+
+	words := []string{"cd", shellQuote(h.Path), "&&", shellQuote(name)}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+
+	sshArgs = append(sshArgs, h.SSH, strings.Join(words, " "))
+	return exec.Command("ssh", sshArgs...)
+}
+
+// SyncArgs returns the rsync arguments copying a local file or directory to the host
+// Nothing is deleted remotely: containers may write files under runtime/ (certificates)
+func (h *Host) SyncArgs(local, remote string) []string {
+	args := []string{"-az"}
+	for _, exclude := range syncExcludes {
+		args = append(args, "--exclude", exclude)
+	}
+
+	if sshArgs := h.sshArgs(); len(sshArgs) > 0 {
+		args = append(args, "-e", "ssh "+strings.Join(sshArgs, " "))
+	}
+
+	// Create the remote directory on first sync
+	args = append(args, "--rsync-path", fmt.Sprintf("mkdir -p %s && rsync", shellQuote(h.Path)))
+
+	return append(args, local, h.SSH+":"+path.Join(h.Path, remote))
+}
+
+// Sync copies runtime/ (and .env if present) to the host
+func (h *Host) Sync() error {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		return fmt.Errorf("rsync not found in PATH - install it to deploy to %s", h.Name)
+	}
+
+	transfers := [][2]string{{paths.Runtime + "/", paths.Runtime + "/"}}
+	if _, err := os.Stat(".env"); err == nil {
+		transfers = append(transfers, [2]string{".env", ".env"})
+	}
+
+	for _, transfer := range transfers {
+		cmd := exec.Command("rsync", h.SyncArgs(transfer[0], transfer[1])...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to sync %s to %s", transfer[0], h.Name),
+				fmt.Sprintf("Check SSH access: ssh %s", h.SSH),
+				"Check that rsync is installed on the remote host",
+			)
+		}
+	}
+
+	return nil
+}
+
+// shellQuote quotes a word for the remote POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:@,+", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,84 @@
+package remote
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestLoadHost(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	if _, err := LoadHost("nas"); err == nil {
+		t.Error("LoadHost() should fail without inventory/hosts.yaml")
+	}
+
+	testutil.WriteFile(t, "inventory/hosts.yaml", `hosts:
+  nas:
+    ssh: admin@nas.lan
+    port: 2222
+    path: /srv/homelab
+  broken:
+    ssh: admin@pi.lan
+    path: homelab
+`)
+
+	host, err := LoadHost("nas")
+	if err != nil {
+		t.Fatalf("LoadHost(nas) unexpected error: %v", err)
+	}
+	if host.Name != "nas" || host.SSH != "admin@nas.lan" || host.Port != 2222 || host.Path != "/srv/homelab" {
+		t.Errorf("LoadHost(nas) = %+v", host)
+	}
+
+	if _, err := LoadHost("broken"); err == nil {
+		t.Error("LoadHost(broken) should fail with a relative path")
+	}
+
+	_, err = LoadHost("missing")
+	if err == nil || !strings.Contains(err.Error(), "broken, nas") {
+		t.Errorf("LoadHost(missing) error = %v, want defined hosts listed", err)
+	}
+}
+
+func TestHostCommand(t *testing.T) {
+	host := &Host{Name: "nas", SSH: "admin@nas.lan", Port: 2222, Identity: "/keys/id", Path: "/srv/my homelab"}
+
+	cmd := host.Command(false, "docker", "compose", "-f", "runtime/docker-compose.yml", "exec", "app", "sh", "-c", "echo 'hi'")
+	want := []string{"ssh", "-p", "2222", "-i", "/keys/id", "admin@nas.lan",
+		`cd '/srv/my homelab' && docker compose -f runtime/docker-compose.yml exec app sh -c 'echo '\''hi'\'''`}
+	if strings.Join(cmd.Args, "|") != strings.Join(want, "|") {
+		t.Errorf("Command() args = %q, want %q", cmd.Args, want)
+	}
+
+	if cmd := host.Command(true, "docker", "ps"); cmd.Args[5] != "-t" {
+		t.Errorf("Command(tty) should request a terminal, got %q", cmd.Args)
+	}
+}
+
+func TestHostSyncArgs(t *testing.T) {
+	host := &Host{Name: "nas", SSH: "admin@nas.lan", Path: "/srv/homelab"}
+
+	args := strings.Join(host.SyncArgs("runtime/", "runtime/"), " ")
+
+	if !strings.HasSuffix(args, "runtime/ admin@nas.lan:/srv/homelab/runtime") {
+		t.Errorf("SyncArgs() = %s, want remote destination under path", args)
+	}
+	if !strings.Contains(args, "--exclude /.lock") {
+		t.Errorf("SyncArgs() = %s, should exclude the lock file", args)
+	}
+	if strings.Contains(args, "--exclude history") {
+		t.Errorf("SyncArgs() = %s, excludes must be anchored to runtime/", args)
+	}
+	if strings.Contains(args, "--delete") {
+		t.Errorf("SyncArgs() = %s, must not delete remote files", args)
+	}
+	if strings.Contains(args, " -e ") {
+		t.Errorf("SyncArgs() = %s, should use plain ssh without port or identity", args)
+	}
+}
@@ -0,0 +1,365 @@
+package render
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// funcMap returns the functions of the native engine: sprig-style functions, plus the
+// gomplate namespaces templates commonly use (strings.ToUpper, data.ToYAML, ...)
+// Argument order follows sprig and gomplate: the piped value comes last
+func funcMap() template.FuncMap {
+	return template.FuncMap{
+		// Defaults and conditions
+		"default":  defaultValue,
+		"empty":    empty,
+		"coalesce": coalesce,
+		"ternary":  ternary,
+		"required": required,
+		"has":      has,
+
+		// Conversion
+		"int":      toInt,
+		"toString": toString,
+		"toYaml":   toYAML,
+		"toJson":   toJSON,
+		"b64enc":   b64enc,
+		"b64dec":   b64dec,
+
+		// Strings
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      title,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"quote":      func(v interface{}) string { return strconv.Quote(toString(v)) },
+		"squote":     func(v interface{}) string { return "'" + toString(v) + "'" },
+		"indent":     indent,
+		"nindent":    func(n int, s string) string { return "\n" + indent(n, s) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       join,
+
+		// Collections
+		"list": func(items ...interface{}) []interface{} { return items },
+		"dict": dict,
+		"keys": keys,
+
+		// Arithmetic
+		"add": func(a, b interface{}) int { return toInt(a) + toInt(b) },
+		"sub": func(a, b interface{}) int { return toInt(a) - toInt(b) },
+		"mul": func(a, b interface{}) int { return toInt(a) * toInt(b) },
+		"div": div,
+
+		// Environment
+		"env":    os.Getenv,
+		"getenv": getenv,
+
+		// gomplate namespaces
+		"strings": func() stringsNS { return stringsNS{} },
+		"coll":    func() collNS { return collNS{} },
+		"conv":    func() convNS { return convNS{} },
+		"data":    func() dataNS { return dataNS{} },
+		"file":    func() fileNS { return fileNS{} },
+	}
+}
+
+// defaultValue returns the value, or def when it is missing or empty
+func defaultValue(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+// empty reports whether a value is missing, false, zero or has no elements
+func empty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return rv.IsZero()
+	}
+}
+
+// coalesce returns the first non-empty value
+func coalesce(values ...interface{}) interface{} {
+	for _, v := range values {
+		if !empty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// ternary returns a when cond is true, b otherwise
+func ternary(a, b interface{}, cond bool) interface{} {
+	if cond {
+		return a
+	}
+	return b
+}
+
+// required fails the render with msg when the value is missing or empty
+func required(msg string, v interface{}) (interface{}, error) {
+	if empty(v) {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return v, nil
+}
+
+// has reports whether a list contains an item or a map has a key
+// Both sprig's order (has item list) and gomplate's (has list item) are accepted
+func has(a, b interface{}) bool {
+	return contains(b, a) || contains(a, b)
+}
+
+// contains reports whether a list contains an item or a map has a key
+func contains(in, item interface{}) bool {
+	if in == nil {
+		return false
+	}
+	rv := reflect.ValueOf(in)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if reflect.DeepEqual(rv.Index(i).Interface(), item) {
+				return true
+			}
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return false
+		}
+		key, ok := item.(string)
+		return ok && rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).IsValid()
+	}
+	return false
+}
+
+// toInt converts numbers, numeric strings and booleans; anything else is 0
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case uint64:
+		return int(n)
+	case float64:
+		return int(n)
+	case bool:
+		if n {
+			return 1
+		}
+		return 0
+	case string:
+		if i, err := strconv.Atoi(strings.TrimSpace(n)); err == nil {
+			return i
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(n), 64); err == nil {
+			return int(f)
+		}
+	}
+	return 0
+}
+
+// toString formats a value as text; missing values are empty
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// toYAML marshals a value, without the trailing newline
+func toYAML(v interface{}) (string, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// toJSON marshals a value
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func b64enc(v interface{}) string {
+	return base64.StdEncoding.EncodeToString([]byte(toString(v)))
+}
+
+func b64dec(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// title upper-cases the first letter of every word
+func title(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+	return strings.Join(words, " ")
+}
+
+// indent prefixes every line with n spaces
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// join joins a list with a separator, in sprig's order (join sep list) or gomplate's (join list sep)
+func join(a, b interface{}) string {
+	sep, list := a, b
+	if _, ok := a.(string); !ok {
+		sep, list = b, a
+	}
+
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return toString(list)
+	}
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = toString(rv.Index(i).Interface())
+	}
+	return strings.Join(parts, toString(sep))
+}
+
+// dict builds a map from key/value pairs
+func dict(pairs ...interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		m[toString(pairs[i])] = pairs[i+1]
+	}
+	return m
+}
+
+// keys returns the sorted keys of a map
+func keys(m map[string]interface{}) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func div(a, b interface{}) (int, error) {
+	if toInt(b) == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+	return toInt(a) / toInt(b), nil
+}
+
+// getenv returns an environment variable, or the default when it is unset or empty
+func getenv(name string, def ...string) string {
+	if v := os.Getenv(name); v != "" || len(def) == 0 {
+		return v
+	}
+	return def[0]
+}
+
+// stringsNS is gomplate's strings namespace
+type stringsNS struct{}
+
+func (stringsNS) ToUpper(s interface{}) string { return strings.ToUpper(toString(s)) }
+func (stringsNS) ToLower(s interface{}) string { return strings.ToLower(toString(s)) }
+func (stringsNS) Title(s interface{}) string   { return title(toString(s)) }
+func (stringsNS) TrimSpace(s interface{}) string {
+	return strings.TrimSpace(toString(s))
+}
+func (stringsNS) Contains(substr string, s interface{}) bool {
+	return strings.Contains(toString(s), substr)
+}
+func (stringsNS) HasPrefix(prefix string, s interface{}) bool {
+	return strings.HasPrefix(toString(s), prefix)
+}
+func (stringsNS) HasSuffix(suffix string, s interface{}) bool {
+	return strings.HasSuffix(toString(s), suffix)
+}
+func (stringsNS) ReplaceAll(old, new string, s interface{}) string {
+	return strings.ReplaceAll(toString(s), old, new)
+}
+func (stringsNS) Split(sep string, s interface{}) []string {
+	return strings.Split(toString(s), sep)
+}
+func (stringsNS) Indent(n int, s interface{}) string { return indent(n, toString(s)) }
+func (stringsNS) Quote(s interface{}) string         { return strconv.Quote(toString(s)) }
+
+// collNS is gomplate's coll namespace
+type collNS struct{}
+
+func (collNS) Has(in, item interface{}) bool                    { return contains(in, item) }
+func (collNS) Slice(items ...interface{}) []interface{}         { return items }
+func (collNS) Dict(pairs ...interface{}) map[string]interface{} { return dict(pairs...) }
+func (collNS) Keys(m map[string]interface{}) []string           { return keys(m) }
+
+// convNS is gomplate's conv namespace
+type convNS struct{}
+
+func (convNS) ToInt(v interface{}) int       { return toInt(v) }
+func (convNS) ToString(v interface{}) string { return toString(v) }
+func (convNS) Default(def interface{}, given ...interface{}) interface{} {
+	return defaultValue(def, given...)
+}
+
+// dataNS is gomplate's data namespace
+type dataNS struct{}
+
+func (dataNS) ToYAML(v interface{}) (string, error) { return toYAML(v) }
+func (dataNS) ToJSON(v interface{}) (string, error) { return toJSON(v) }
+
+// fileNS is gomplate's file namespace
+type fileNS struct{}
+
+func (fileNS) Read(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (fileNS) Exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,72 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// renderNative renders a template file with text/template, so homelabctl works without gomplate
+// Templates use gomplate syntax; funcs.go provides its common functions
+func renderNative(templatePath string, context *Context) (string, error) {
+	text, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read template: %w", err)
+	}
+
+	// gomplate reads the context from YAML: a round trip gives templates the same keys and types
+	contextData, err := yaml.Marshal(context)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal context: %w", err)
+	}
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(contextData, &data); err != nil {
+		return "", fmt.Errorf("failed to unmarshal context: %w", err)
+	}
+
+	contextPath := ""
+	if debug {
+		if contextPath, err = writeDebugContext(context, contextData); err != nil {
+			return "", err
+		}
+	}
+
+	// A missing key fails the render, as with gomplate, instead of printing <no value>
+	tmpl, err := template.New(filepath.Base(templatePath)).Option("missingkey=error").Funcs(funcMap()).Parse(string(text))
+	if err != nil {
+		return "", debugError(nativeError(templatePath, err), contextPath)
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", debugError(nativeError(templatePath, err), contextPath)
+	}
+
+	return out.String(), nil
+}
+
+// debugError points a render error at the context kept by --debug, if any
+func debugError(err *errors.Error, contextPath string) error {
+	if contextPath != "" {
+		err.Suggestions = append(err.Suggestions, fmt.Sprintf("View context: cat %s", contextPath))
+	}
+	return err
+}
+
+// nativeError reports a template that failed to parse or execute
+func nativeError(templatePath string, err error) *errors.Error {
+	return errors.New(
+		fmt.Sprintf("failed to render %s", templatePath),
+		fmt.Sprintf("Check template syntax in: %s", templatePath),
+		"The built-in engine supports a subset of gomplate functions; install gomplate for the rest",
+	).WithContext(
+		"Template error:",
+		err.Error(),
+	).WithClass(errors.ClassRender).WithKind(errors.ErrRenderFailed)
+}
@@ -0,0 +1,131 @@
+package render
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestRenderNative(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	context := &Context{
+		Vars: map[string]interface{}{
+			"domain": "example.com",
+			"myapp":  map[string]interface{}{"name": "myapp", "port": "8080", "env": map[string]interface{}{"A": "1"}},
+		},
+		Stack:  map[string]interface{}{"name": "myapp"},
+		Stacks: map[string]interface{}{"enabled": []string{"traefik", "myapp"}},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"variable", `{{ .vars.domain }}`, "example.com"},
+		{"default for missing", `{{ index .vars "timezone" | default "UTC" }}`, "UTC"},
+		{"default keeps value", `{{ .vars.domain | default "local" }}`, "example.com"},
+		{"has sprig order", `{{ if has "traefik" .stacks.enabled }}yes{{ end }}`, "yes"},
+		{"has gomplate order", `{{ if has .stacks.enabled "traefik" }}yes{{ end }}`, "yes"},
+		{"has missing", `{{ if has "grafana" .stacks.enabled }}yes{{ else }}no{{ end }}`, "no"},
+		{"gomplate namespace", `{{ .vars.myapp.name | strings.ToUpper }}`, "MYAPP"},
+		{"int", `{{ if eq (.vars.myapp.port | int) 8080 }}yes{{ end }}`, "yes"},
+		{"join", `{{ join "," .stacks.enabled }} {{ .stacks.enabled | join "+" }}`, "traefik,myapp traefik+myapp"},
+		{"toYaml and nindent", `env:{{ .vars.myapp.env | toYaml | nindent 2 }}`, "env:\n  A: \"1\""},
+		{"data namespace", `{{ .vars.myapp.env | data.ToJSON }}`, `{"A":"1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, "compose.yml.tmpl")
+			testutil.WriteFile(t, path, tt.template)
+
+			got, err := renderNative(path, context)
+			if err != nil {
+				t.Fatalf("renderNative() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderNative() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderNativeError(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(tmpDir, "compose.yml.tmpl")
+	testutil.WriteFile(t, path, `{{ if .vars.ssl }}missing end`)
+
+	_, err := renderNative(path, &Context{})
+	if err == nil || !strings.Contains(err.Error(), "failed to render") {
+		t.Errorf("renderNative() error = %v, want render failure", err)
+	}
+
+	// A missing key fails, as with gomplate, instead of rendering <no value>
+	testutil.WriteFile(t, path, `image: {{ .vars.image }}`)
+	_, err = renderNative(path, &Context{Vars: map[string]interface{}{"domain": "example.com"}})
+	if err == nil || !strings.Contains(strings.Join(errors.DetailsOf(err).Context, "\n"), `map has no entry for key "image"`) {
+		t.Errorf("renderNative() error = %v, want the missing key", err)
+	}
+}
+
+func TestRenderNativeDebugContext(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	SetDebug(true)
+	defer SetDebug(false)
+
+	testutil.WriteFile(t, "compose.yml.tmpl", `{{ nosuchfunc .vars.domain }}`)
+	context := &Context{
+		Vars:  map[string]interface{}{"domain": "example.com"},
+		Stack: map[string]interface{}{"name": "myapp"},
+	}
+
+	_, err := renderNative("compose.yml.tmpl", context)
+	if err == nil {
+		t.Fatal("renderNative() should fail on an unknown function")
+	}
+	details := errors.DetailsOf(err)
+	want := "View context: cat " + filepath.Join("runtime", "debug", "myapp.context.yaml")
+	if !strings.Contains(strings.Join(details.Suggestions, "\n"), want) {
+		t.Errorf("renderNative() suggestions = %v, want %q", details.Suggestions, want)
+	}
+
+	info, err := os.Stat(filepath.Join("runtime", "debug", "myapp.context.yaml"))
+	if err != nil {
+		t.Fatalf("context not kept: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("context mode = %o, want 0600", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(filepath.Join("runtime", "debug", "myapp.context.yaml"))
+	if !strings.Contains(string(data), "domain: example.com") {
+		t.Errorf("context = %q, want the vars", data)
+	}
+}
+
+func TestSetEngine(t *testing.T) {
+	defer SetEngine(EngineAuto)
+
+	if err := SetEngine(EngineNative); err != nil || engine != EngineNative {
+		t.Errorf("SetEngine(native) = %v, engine %s", err, engine)
+	}
+	if err := SetEngine(""); err != nil || engine != EngineAuto {
+		t.Errorf("SetEngine(\"\") = %v, engine %s", err, engine)
+	}
+	if err := SetEngine("jinja"); err == nil {
+		t.Error("SetEngine(jinja) should fail")
+	}
+}
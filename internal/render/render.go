@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
@@ -13,15 +14,86 @@ import (
 	"github.com/monkeymonk/homelabctl/internal/paths"
 )
 
+// Template engines, selected with render.engine in inventory/vars.yaml
+const (
+	EngineAuto     = "auto"     // gomplate when installed, native otherwise (default)
+	EngineGomplate = "gomplate" // gomplate binary
+	EngineNative   = "native"   // Built-in text/template with sprig-style functions
+)
+
+// Engines lists the available template engines
+var Engines = []string{EngineAuto, EngineGomplate, EngineNative}
+
+// engine is the selected template engine
+var engine = EngineAuto
+
+// debug keeps the context of each stack in runtime/debug/, for render errors to point at
+var debug = false
+
+// ContextVersion is the version of the template context structure (.vars, .stack,
+// .stacks); it changes when keys are renamed, moved or change meaning, and stacks
+// declare the version their templates expect with context_version in stack.yaml
+const ContextVersion = 1
+
 // Context represents the template context passed to gomplate
 type Context struct {
-	Vars   map[string]interface{} `yaml:"vars"`
-	Stack  map[string]interface{} `yaml:"stack"`
-	Stacks map[string]interface{} `yaml:"stacks"`
+	Version int                    `yaml:"version"` // Structure version, as the stack expects it
+	Vars    map[string]interface{} `yaml:"vars"`
+	Stack   map[string]interface{} `yaml:"stack"`
+	Stacks  map[string]interface{} `yaml:"stacks"`
+
+	// Sandbox confines the templates of an untrusted stack to its directory: no
+	// environment, datasources or files outside it (empty: unrestricted)
+	Sandbox string `yaml:"-"`
+}
+
+// SetEngine selects the template engine; empty selects auto
+func SetEngine(kind string) error {
+	if kind == "" {
+		kind = EngineAuto
+	}
+	for _, k := range Engines {
+		if k == kind {
+			engine = kind
+			return nil
+		}
+	}
+	return errors.New(
+		fmt.Sprintf("unknown template engine: %s", kind),
+		fmt.Sprintf("Set render.engine in inventory/vars.yaml to one of: %s", strings.Join(Engines, ", ")),
+	).WithClass(errors.ClassValidation)
 }
 
-// RenderTemplate renders a template file using gomplate
+// SetDebug keeps the template context of each rendered stack in runtime/debug/
+func SetDebug(enabled bool) {
+	debug = enabled
+}
+
+// SelectedEngine returns the selected template engine
+func SelectedEngine() string {
+	return engine
+}
+
+// RenderTemplate renders a template file with the selected engine
+// A sandboxed context first audits the template and refuses what it may not use
 func RenderTemplate(templatePath string, context *Context) (string, error) {
+	if err := checkSandbox(templatePath, context); err != nil {
+		return "", err
+	}
+
+	switch engine {
+	case EngineNative:
+		return renderNative(templatePath, context)
+	case EngineAuto:
+		if _, err := exec.LookPath("gomplate"); err != nil {
+			return renderNative(templatePath, context)
+		}
+	}
+	return renderGomplate(templatePath, context)
+}
+
+// renderGomplate renders a template file using gomplate
+func renderGomplate(templatePath string, context *Context) (string, error) {
 	// Check gomplate is available
 	if _, err := exec.LookPath("gomplate"); err != nil {
 		return "", errors.New(
@@ -29,39 +101,24 @@ func RenderTemplate(templatePath string, context *Context) (string, error) {
 			"Install gomplate: https://docs.gomplate.ca/installing/",
 			"On Linux: curl -o /usr/local/bin/gomplate -sSL https://github.com/hairyhenderson/gomplate/releases/download/v3.11.5/gomplate_linux-amd64",
 			"On macOS: brew install gomplate",
-		)
+			"Or use the built-in engine: set render.engine: native in inventory/vars.yaml",
+		).WithClass(errors.ClassRender).WithKind(errors.ErrRenderFailed)
 	}
 
-	// Marshal context to YAML
-	contextData, err := yaml.Marshal(context)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal context: %w", err)
-	}
-
-	// Create temp file for context
-	tmpfile, err := os.CreateTemp("", "homelabctl-context-*.yaml")
+	contextPath, cleanup, err := contextFile(context)
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %w", err)
+		return "", err
 	}
-	defer os.Remove(tmpfile.Name())
-
-	// Set secure permissions (0600) to prevent other users from reading context data
-	if err := tmpfile.Chmod(paths.SecureFilePermissions); err != nil {
-		tmpfile.Close()
-		return "", fmt.Errorf("failed to set temp file permissions: %w", err)
-	}
-
-	if _, err := tmpfile.Write(contextData); err != nil {
-		tmpfile.Close()
-		return "", fmt.Errorf("failed to write context: %w", err)
-	}
-	tmpfile.Close()
+	defer cleanup()
 
 	// Run gomplate
 	cmd := exec.Command("gomplate",
 		"-f", templatePath,
-		"-c", ".="+tmpfile.Name(),
+		"-c", ".="+contextPath,
 	)
+	if context.Sandbox != "" {
+		cmd.Env = []string{"PATH=" + os.Getenv("PATH")} // Nothing for .Env to leak
+	}
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -73,8 +130,14 @@ func RenderTemplate(templatePath string, context *Context) (string, error) {
 
 		suggestions := []string{
 			fmt.Sprintf("Check template syntax in: %s", templatePath),
-			fmt.Sprintf("View context: cat %s", tmpfile.Name()),
-			"Run: gomplate -f <template> -c .=<context> to debug",
+		}
+		if debug {
+			suggestions = append(suggestions,
+				fmt.Sprintf("View context: cat %s", contextPath),
+				fmt.Sprintf("Run: gomplate -f %s -c .=%s to debug", templatePath, contextPath),
+			)
+		} else {
+			suggestions = append(suggestions, "Re-run with --debug to keep the template context in "+paths.DebugDir+"/")
 		}
 
 		return "", errors.New(
@@ -83,12 +146,71 @@ func RenderTemplate(templatePath string, context *Context) (string, error) {
 		).WithContext(
 			"Gomplate error:",
 			stderrStr,
-		)
+		).WithClass(errors.ClassRender).WithKind(errors.ErrRenderFailed)
 	}
 
 	return stdout.String(), nil
 }
 
+// contextFile writes the context as YAML for gomplate: to the stack's file in
+// runtime/debug/ in debug mode, else to a temporary file cleanup removes
+func contextFile(context *Context) (string, func(), error) {
+	contextData, err := yaml.Marshal(context)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal context: %w", err)
+	}
+
+	if debug {
+		path, err := writeDebugContext(context, contextData)
+		return path, func() {}, err
+	}
+
+	// Create temp file for context
+	tmpfile, err := os.CreateTemp("", "homelabctl-context-*.yaml")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	cleanup := func() { os.Remove(tmpfile.Name()) }
+
+	// Set secure permissions (0600) to prevent other users from reading context data
+	if err := tmpfile.Chmod(paths.SecureFilePermissions); err != nil {
+		tmpfile.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to set temp file permissions: %w", err)
+	}
+
+	if _, err := tmpfile.Write(contextData); err != nil {
+		tmpfile.Close()
+		cleanup()
+		return "", nil, fmt.Errorf("failed to write context: %w", err)
+	}
+	tmpfile.Close()
+
+	return tmpfile.Name(), cleanup, nil
+}
+
+// writeDebugContext keeps a stack's context YAML in runtime/debug/<stack>.context.yaml
+// It holds secrets, so only the owner can read it
+func writeDebugContext(context *Context, contextData []byte) (string, error) {
+	name, _ := context.Stack["name"].(string)
+	if name == "" {
+		name = "context"
+	}
+	path := paths.DebugContextFile(name)
+
+	if err := os.MkdirAll(paths.DebugDir, paths.DirPermissions); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", paths.DebugDir, err)
+	}
+	if err := os.WriteFile(path, contextData, paths.SecureFilePermissions); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(path, paths.SecureFilePermissions); err != nil {
+		return "", fmt.Errorf("failed to set %s permissions: %w", path, err)
+	}
+	return path, nil
+}
+
 // RenderToFile renders a template and writes to output file
 func RenderToFile(templatePath, outputPath string, context *Context) error {
 	content, err := RenderTemplate(templatePath, context)
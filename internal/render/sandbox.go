@@ -0,0 +1,210 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+)
+
+// sandboxForbidden lists the functions and gomplate namespaces sandboxed templates may
+// not call, with what they would reach
+var sandboxForbidden = map[string]string{
+	"env":                 "reads the environment",
+	"getenv":              "reads the environment",
+	"expandenv":           "reads the environment",
+	"datasource":          "reads a datasource",
+	"ds":                  "reads a datasource",
+	"datasourceExists":    "reads a datasource",
+	"datasourceReachable": "reads a datasource",
+	"defineDatasource":    "defines a datasource",
+	"include":             "reads a datasource",
+	"net":                 "makes network lookups",
+	"sockaddr":            "reads the network interfaces",
+	"aws":                 "queries cloud metadata",
+	"gcp":                 "queries cloud metadata",
+	"tmpl":                "renders a template the audit can't see",
+	"call":                "calls a function the audit can't see",
+	"file":                "reads a file other than with file.<function> \"<path>\"",
+}
+
+// Audit returns the constructs of a template a sandboxed render refuses: functions
+// reaching the environment, datasources or the network, gomplate's .Env, templates and
+// functions called indirectly (tmpl, call), and file functions whose path is not a
+// literal inside sandboxDir. file is only allowed as file.<function> "<path>": held in
+// a variable or parenthesised, it could read anything
+func Audit(templatePath, sandboxDir string) ([]string, error) {
+	text, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+
+	tree := parse.New(filepath.Base(templatePath))
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(string(text), "", "", trees); err != nil {
+		return nil, nativeError(templatePath, err)
+	}
+
+	found := make(map[string]bool)
+	flag := func(node parse.Node, what string) {
+		found[fmt.Sprintf("%s %s", strings.TrimSpace(node.String()), what)] = true
+	}
+
+	var walk func(node parse.Node)
+	walkPipe := func(pipe *parse.PipeNode) {
+		if pipe != nil {
+			walk(pipe)
+		}
+	}
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walkPipe(n.Pipe)
+		case *parse.IfNode:
+			walkPipe(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walkPipe(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walkPipe(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walkPipe(n.Pipe)
+		case *parse.PipeNode:
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			args := n.Args
+			if len(args) > 0 {
+				if ident, ok := args[0].(*parse.IdentifierNode); ok && ident.Ident == "call" {
+					flag(n, sandboxForbidden["call"])
+					return
+				}
+			}
+			if auditFileCommand(n, sandboxDir, flag) {
+				args = args[1:] // The file function itself is audited
+			}
+			for _, arg := range args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.IdentifierNode:
+			if what, ok := sandboxForbidden[n.Ident]; ok {
+				flag(n, what)
+			}
+		case *parse.FieldNode:
+			if len(n.Ident) > 0 && n.Ident[0] == "Env" {
+				flag(n, "reads the environment")
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == "Env" {
+				flag(n, "reads the environment")
+			}
+		}
+	}
+
+	names := make([]string, 0, len(trees))
+	for name := range trees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		walk(trees[name].Root)
+	}
+
+	findings := make([]string, 0, len(found))
+	for finding := range found {
+		findings = append(findings, finding)
+	}
+	sort.Strings(findings)
+	return findings, nil
+}
+
+// auditFileCommand audits a command calling a function of gomplate's file namespace,
+// file.<function> "<path>", and reports whether it was one. The path must be a literal
+// inside sandboxDir, so what is read is known before rendering; nothing is written
+func auditFileCommand(cmd *parse.CommandNode, sandboxDir string, flag func(parse.Node, string)) bool {
+	if len(cmd.Args) == 0 {
+		return false
+	}
+	chain, ok := cmd.Args[0].(*parse.ChainNode)
+	if !ok || len(chain.Field) != 1 {
+		return false
+	}
+	if ident, ok := chain.Node.(*parse.IdentifierNode); !ok || ident.Ident != "file" {
+		return false
+	}
+
+	if chain.Field[0] == "Write" {
+		flag(cmd, "writes a file")
+		return true
+	}
+	if len(cmd.Args) < 2 {
+		flag(cmd, "reads a file")
+		return true
+	}
+	path, ok := cmd.Args[1].(*parse.StringNode)
+	if !ok {
+		flag(cmd, "reads a file whose path is not a literal")
+		return true
+	}
+	if !insideDir(path.Text, sandboxDir) {
+		flag(cmd, fmt.Sprintf("reads a file outside %s", sandboxDir))
+	}
+	return true
+}
+
+// insideDir reports whether a path relative to the repository root stays in dir,
+// symbolic links followed
+func insideDir(path, dir string) bool {
+	if filepath.IsAbs(path) {
+		return false
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		if resolvedDir, err := filepath.EvalSymlinks(dir); err == nil {
+			path, dir = resolved, resolvedDir
+		}
+	}
+	rel, err := filepath.Rel(dir, filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkSandbox refuses a template a sandboxed context may not render
+func checkSandbox(templatePath string, context *Context) error {
+	if context.Sandbox == "" {
+		return nil
+	}
+
+	findings, err := Audit(templatePath, context.Sandbox)
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+
+	return errors.New(
+		fmt.Sprintf("refusing to render %s: catalog stack templates may not read the environment or files outside their stack", templatePath),
+		"Review the template; if the catalog is trusted, set trusted: true on it in inventory/catalogs.yaml",
+	).WithContext(
+		append([]string{"Sandbox violations:"}, findings...)...,
+	).WithClass(errors.ClassRender).WithKind(errors.ErrRenderFailed)
+}
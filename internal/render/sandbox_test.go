@@ -0,0 +1,87 @@
+package render
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestAudit(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	defer testutil.Chdir(t, tmpDir)()
+
+	testutil.WriteFile(t, "stacks/app/config.yml", "a: 1\n")
+	testutil.WriteFile(t, "secrets/app.yaml", "password: x\n")
+	testutil.CreateSymlink(t, "../../secrets/app.yaml", "stacks/app/linked.yml")
+
+	tests := []struct {
+		name     string
+		template string
+		want     string // Substring of the single finding, empty for none
+	}{
+		{"vars", `{{ .vars.domain | default "local" }}`, ""},
+		{"file inside stack", `{{ file.Read "stacks/app/config.yml" }}`, ""},
+		{"env function", `{{ env "HOME" }}`, "reads the environment"},
+		{"getenv namespace", `{{ .vars.x | default (getenv "TOKEN") }}`, "reads the environment"},
+		{"env field", `{{ .Env.TOKEN }}`, "reads the environment"},
+		{"env variable", `{{ range .vars.items }}{{ $.Env.TOKEN }}{{ end }}`, "reads the environment"},
+		{"datasource", `{{ (datasource "config").key }}`, "reads a datasource"},
+		{"network", `{{ net.LookupIP "example.com" }}`, "makes network lookups"},
+		{"file outside stack", `{{ file.Read "secrets/app.yaml" }}`, "reads a file outside stacks/app"},
+		{"file traversal", `{{ file.Read "stacks/app/../../secrets/app.yaml" }}`, "reads a file outside stacks/app"},
+		{"file symlink", `{{ file.Read "stacks/app/linked.yml" }}`, "reads a file outside stacks/app"},
+		{"file absolute", `{{ file.Read "/etc/passwd" }}`, "reads a file outside stacks/app"},
+		{"file computed path", `{{ file.Read .vars.path }}`, "path is not a literal"},
+		{"file piped path", `{{ "stacks/app/config.yml" | file.Read }}`, "reads a file"},
+		{"file variable", `{{ $f := file }}{{ $f.Read "/etc/passwd" }}`, "reads a file other than"},
+		{"file parenthesised", `{{ (file).Read "/etc/passwd" }}`, "reads a file other than"},
+		{"file call", `{{ call file.Read "/etc/passwd" }}`, "calls a function"},
+		{"file argument", `{{ print file.Read }}`, "reads a file other than"},
+		{"file write", `{{ file.Write "stacks/app/out.yml" "x" }}`, "writes a file"},
+		{"tmpl exec", `{{ tmpl.Exec "x" }}`, "renders a template"},
+		{"tmpl inline", `{{ tmpl.Inline "{{ env \"HOME\" }}" }}`, "renders a template"},
+		{"nested define", `{{ define "x" }}{{ env "HOME" }}{{ end }}{{ template "x" }}`, "reads the environment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join("stacks", "app", "compose.yml.tmpl")
+			testutil.WriteFile(t, path, tt.template)
+
+			findings, err := Audit(path, filepath.Join("stacks", "app"))
+			if err != nil {
+				t.Fatalf("Audit() error = %v", err)
+			}
+			if tt.want == "" {
+				if len(findings) != 0 {
+					t.Errorf("Audit() = %v, want no findings", findings)
+				}
+				return
+			}
+			if len(findings) != 1 || !strings.Contains(findings[0], tt.want) {
+				t.Errorf("Audit() = %v, want one finding containing %q", findings, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateSandbox(t *testing.T) {
+	defer SetEngine(EngineAuto)
+	if err := SetEngine(EngineNative); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	path := filepath.Join(tmpDir, "compose.yml.tmpl")
+	testutil.WriteFile(t, path, `token: {{ env "TOKEN" }}`)
+
+	_, err := RenderTemplate(path, &Context{Sandbox: tmpDir})
+	if err == nil || !strings.Contains(err.Error(), "refusing to render") {
+		t.Errorf("RenderTemplate() error = %v, want sandbox refusal", err)
+	}
+}
@@ -0,0 +1,207 @@
+// Package sbom builds software bill of materials documents for deployed images
+// Both CycloneDX 1.5 and SPDX 2.3 JSON are supported; each image is one component
+package sbom
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Supported output formats
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+)
+
+// Image is a container image used by the deployment
+type Image struct {
+	Ref      string   // Image reference as written in the compose file
+	Digest   string   // Repository digest (sha256:...), empty if unknown
+	Services []string // Services running the image
+	Stacks   []string // Stacks those services belong to
+}
+
+// Name returns the image repository without tag or digest
+func (i Image) Name() string {
+	ref := strings.SplitN(i.Ref, "@", 2)[0]
+
+	// A colon after the last slash is a tag; before it, a registry port
+	lastSlash := strings.LastIndex(ref, "/")
+	if colon := strings.LastIndex(ref, ":"); colon > lastSlash {
+		return ref[:colon]
+	}
+	return ref
+}
+
+// Tag returns the image tag, "latest" when the reference has neither tag nor digest
+func (i Image) Tag() string {
+	ref := strings.SplitN(i.Ref, "@", 2)[0]
+
+	lastSlash := strings.LastIndex(ref, "/")
+	if colon := strings.LastIndex(ref, ":"); colon > lastSlash {
+		return ref[colon+1:]
+	}
+	if strings.Contains(i.Ref, "@") {
+		return ""
+	}
+	return "latest"
+}
+
+// PURL returns the package URL of the image (pkg:oci)
+func (i Image) PURL() string {
+	name := i.Name()
+	purl := "pkg:oci/" + strings.ToLower(name[strings.LastIndex(name, "/")+1:])
+	if i.Digest != "" {
+		purl += "@" + url.QueryEscape(i.Digest)
+	}
+
+	query := url.Values{}
+	query.Set("repository_url", name)
+	if tag := i.Tag(); tag != "" {
+		query.Set("tag", tag)
+	}
+
+	return purl + "?" + query.Encode()
+}
+
+// Generate renders the images as an SBOM document in the given format
+func Generate(format string, images []Image, now time.Time) ([]byte, error) {
+	sorted := append([]Image(nil), images...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Ref < sorted[b].Ref })
+
+	var doc interface{}
+	switch format {
+	case FormatCycloneDX:
+		doc = cycloneDX(sorted, now)
+	case FormatSPDX:
+		doc = spdx(sorted, now)
+	default:
+		return nil, fmt.Errorf("unknown SBOM format: %s (available: %s, %s)", format, FormatCycloneDX, FormatSPDX)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal SBOM: %w", err)
+	}
+
+	return append(data, '\n'), nil
+}
+
+// cycloneDX builds a CycloneDX 1.5 document with one container component per image
+func cycloneDX(images []Image, now time.Time) map[string]interface{} {
+	components := make([]map[string]interface{}, 0, len(images))
+	for _, image := range images {
+		component := map[string]interface{}{
+			"type":       "container",
+			"bom-ref":    image.Ref,
+			"name":       image.Name(),
+			"purl":       image.PURL(),
+			"properties": properties(image),
+		}
+		if tag := image.Tag(); tag != "" {
+			component["version"] = tag
+		}
+		if hash := digestHex(image.Digest); hash != "" {
+			component["hashes"] = []map[string]string{{"alg": "SHA-256", "content": hash}}
+		}
+		components = append(components, component)
+	}
+
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + newUUID(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": now.UTC().Format(time.RFC3339),
+			"tools": map[string]interface{}{
+				"components": []map[string]string{{"type": "application", "name": "homelabctl"}},
+			},
+		},
+		"components": components,
+	}
+}
+
+// properties records which services and stacks use an image
+func properties(image Image) []map[string]string {
+	var props []map[string]string
+	for _, svc := range image.Services {
+		props = append(props, map[string]string{"name": "homelabctl:service", "value": svc})
+	}
+	for _, stack := range image.Stacks {
+		props = append(props, map[string]string{"name": "homelabctl:stack", "value": stack})
+	}
+	return props
+}
+
+// spdx builds an SPDX 2.3 document with one package per image
+func spdx(images []Image, now time.Time) map[string]interface{} {
+	packages := make([]map[string]interface{}, 0, len(images))
+	relationships := make([]map[string]string, 0, len(images))
+
+	for n, image := range images {
+		id := fmt.Sprintf("SPDXRef-Image-%d", n+1)
+
+		pkg := map[string]interface{}{
+			"SPDXID":           id,
+			"name":             image.Name(),
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"comment":          fmt.Sprintf("Used by services: %s", strings.Join(image.Services, ", ")),
+			"externalRefs": []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  image.PURL(),
+			}},
+		}
+		if tag := image.Tag(); tag != "" {
+			pkg["versionInfo"] = tag
+		}
+		if hash := digestHex(image.Digest); hash != "" {
+			pkg["checksums"] = []map[string]string{{"algorithm": "SHA256", "checksumValue": hash}}
+		}
+		packages = append(packages, pkg)
+
+		relationships = append(relationships, map[string]string{
+			"spdxElementId":      "SPDXRef-DOCUMENT",
+			"relationshipType":   "DESCRIBES",
+			"relatedSpdxElement": id,
+		})
+	}
+
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              "homelab-deployment",
+		"documentNamespace": "https://homelabctl.local/spdx/" + newUUID(),
+		"creationInfo": map[string]interface{}{
+			"created":  now.UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: homelabctl"},
+		},
+		"packages":      packages,
+		"relationships": relationships,
+	}
+}
+
+// digestHex returns the hex part of a sha256 digest, empty for other digests
+func digestHex(digest string) string {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return ""
+	}
+	return strings.TrimPrefix(digest, "sha256:")
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,102 @@
+package sbom
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestImageRefParts(t *testing.T) {
+	tests := []struct {
+		ref, name, tag string
+	}{
+		{"nginx", "nginx", "latest"},
+		{"grafana/grafana:11.2.0", "grafana/grafana", "11.2.0"},
+		{"registry.lan:5000/app", "registry.lan:5000/app", "latest"},
+		{"registry.lan:5000/app:v1", "registry.lan:5000/app", "v1"},
+		{"redis@sha256:abc", "redis", ""},
+		{"redis:7@sha256:abc", "redis", "7"},
+	}
+
+	for _, tt := range tests {
+		image := Image{Ref: tt.ref}
+		if got := image.Name(); got != tt.name {
+			t.Errorf("Name(%s) = %s, want %s", tt.ref, got, tt.name)
+		}
+		if got := image.Tag(); got != tt.tag {
+			t.Errorf("Tag(%s) = %s, want %s", tt.ref, got, tt.tag)
+		}
+	}
+
+	image := Image{Ref: "ghcr.io/home-assistant/home-assistant:2024.6", Digest: "sha256:abc"}
+	want := "pkg:oci/home-assistant@sha256%3Aabc?repository_url=ghcr.io%2Fhome-assistant%2Fhome-assistant&tag=2024.6"
+	if got := image.PURL(); got != want {
+		t.Errorf("PURL() = %s, want %s", got, want)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	images := []Image{
+		{Ref: "redis:7", Services: []string{"cache"}},
+		{Ref: "grafana/grafana:11.2.0", Digest: "sha256:abc", Services: []string{"grafana"}, Stacks: []string{"monitoring"}},
+	}
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	data, err := Generate(FormatCycloneDX, images, now)
+	if err != nil {
+		t.Fatalf("Generate(cyclonedx) unexpected error: %v", err)
+	}
+
+	var bom struct {
+		BomFormat  string `json:"bomFormat"`
+		Components []struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+			Hashes  []struct {
+				Content string `json:"content"`
+			} `json:"hashes"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &bom); err != nil {
+		t.Fatalf("CycloneDX output is not valid JSON: %v", err)
+	}
+	if bom.BomFormat != "CycloneDX" || len(bom.Components) != 2 {
+		t.Fatalf("unexpected CycloneDX document: %s", data)
+	}
+	// Components are sorted by reference
+	if bom.Components[0].Name != "grafana/grafana" || bom.Components[0].Hashes[0].Content != "abc" {
+		t.Errorf("first component = %+v", bom.Components[0])
+	}
+	if len(bom.Components[1].Hashes) != 0 {
+		t.Error("images without digest should have no hashes")
+	}
+
+	data, err = Generate(FormatSPDX, images, now)
+	if err != nil {
+		t.Fatalf("Generate(spdx) unexpected error: %v", err)
+	}
+
+	var doc struct {
+		SpdxVersion string `json:"spdxVersion"`
+		Packages    []struct {
+			SPDXID      string `json:"SPDXID"`
+			VersionInfo string `json:"versionInfo"`
+		} `json:"packages"`
+		Relationships []struct {
+			RelatedSpdxElement string `json:"relatedSpdxElement"`
+		} `json:"relationships"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("SPDX output is not valid JSON: %v", err)
+	}
+	if doc.SpdxVersion != "SPDX-2.3" || len(doc.Packages) != 2 || len(doc.Relationships) != 2 {
+		t.Fatalf("unexpected SPDX document: %s", data)
+	}
+	if doc.Packages[1].VersionInfo != "7" || doc.Relationships[1].RelatedSpdxElement != doc.Packages[1].SPDXID {
+		t.Errorf("unexpected SPDX package: %+v", doc.Packages[1])
+	}
+
+	if _, err := Generate("xml", images, now); err == nil {
+		t.Error("Generate() with unknown format should fail")
+	}
+}
@@ -0,0 +1,268 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/inventory"
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// sopsAgeKeyFile is where sops looks for age keys, relative to the home directory
+const sopsAgeKeyFile = ".config/sops/age/keys.txt"
+
+// AgeIdentityFile returns the age private key file: the identity of inventory/secrets.yaml,
+// else SOPS_AGE_KEY_FILE, else the file sops uses (~/.config/sops/age/keys.txt)
+func AgeIdentityFile() (string, error) {
+	settings, err := inventory.LoadSecretsSettings()
+	if err != nil {
+		return "", err
+	}
+
+	candidates := []string{settings.Age.Identity, os.Getenv("SOPS_AGE_KEY_FILE")}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, sopsAgeKeyFile))
+	}
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if strings.HasPrefix(candidate, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				candidate = filepath.Join(home, candidate[2:])
+			}
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+		if candidate == settings.Age.Identity {
+			return "", fmt.Errorf("age identity %s of %s does not exist", candidate, paths.InventorySecrets)
+		}
+	}
+
+	return "", errors.New(
+		"no age key found",
+		"Generate one: age-keygen -o ~/.config/sops/age/keys.txt",
+		fmt.Sprintf("Or point to it: SOPS_AGE_KEY_FILE, or age.identity in %s", paths.InventorySecrets),
+	).WithClass(errors.ClassNotFound)
+}
+
+// AgeRecipients returns the public keys secrets are encrypted to: the recipients of
+// inventory/secrets.yaml, else the public keys noted in the identity file
+func AgeRecipients() ([]string, error) {
+	settings, err := inventory.LoadSecretsSettings()
+	if err != nil {
+		return nil, err
+	}
+	if len(settings.Age.Recipients) > 0 {
+		return settings.Age.Recipients, nil
+	}
+
+	identity, err := AgeIdentityFile()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", identity, err)
+	}
+	defer file.Close()
+
+	// age-keygen writes "# public key: age1..." above each key
+	var recipients []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if key := strings.TrimPrefix(line, "# public key: "); key != line {
+			recipients = append(recipients, strings.TrimSpace(key))
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New(
+			fmt.Sprintf("no public key in %s", identity),
+			fmt.Sprintf("List the recipients in %s (age.recipients)", paths.InventorySecrets),
+			fmt.Sprintf("Or print it: age-keygen -y %s", identity),
+		).WithClass(errors.ClassNotFound)
+	}
+	return recipients, nil
+}
+
+// Encrypt encrypts the plain secrets/<stack>.yaml into secrets/<stack>.age, then
+// removes the plain file
+func Encrypt(stackName string) error {
+	plain := paths.SecretsFilePath(stackName, paths.SecretsExt)
+	data, err := os.ReadFile(plain)
+	if err != nil {
+		return fmt.Errorf("failed to read secrets for %s: %w", stackName, err)
+	}
+	if err := checkYAML(stackName, data); err != nil {
+		return err
+	}
+
+	if err := encryptWithAge(data, paths.SecretsFilePath(stackName, paths.SecretsAgeExt)); err != nil {
+		return err
+	}
+	if err := os.Remove(plain); err != nil {
+		return fmt.Errorf("encrypted, but failed to remove %s: %w", plain, err)
+	}
+	return nil
+}
+
+// Decrypt returns the decrypted content of secrets/<stack>.age
+func Decrypt(stackName string) ([]byte, error) {
+	return decryptWithAge(paths.SecretsFilePath(stackName, paths.SecretsAgeExt))
+}
+
+// Edit lets edit change the decrypted secrets of a stack in a private temporary file,
+// then encrypts them back into secrets/<stack>.age (created when missing); unchanged
+// secrets are not rewritten. It reports whether they changed
+func Edit(stackName string, edit func(path string) error) (bool, error) {
+	encrypted := paths.SecretsFilePath(stackName, paths.SecretsAgeExt)
+	if _, err := os.Stat(paths.SecretsFilePath(stackName, paths.SecretsEncExt)); err == nil {
+		return false, fmt.Errorf("secrets for %s are encrypted with sops - edit them with: sops %s",
+			stackName, paths.SecretsFilePath(stackName, paths.SecretsEncExt))
+	}
+	if _, err := os.Stat(paths.SecretsFilePath(stackName, paths.SecretsExt)); err == nil {
+		return false, fmt.Errorf("secrets for %s are not encrypted - run: homelabctl secrets encrypt %s", stackName, stackName)
+	}
+
+	var original []byte
+	if _, err := os.Stat(encrypted); err == nil {
+		if original, err = decryptWithAge(encrypted); err != nil {
+			return false, err
+		}
+	}
+
+	// CreateTemp creates the file readable by its owner only
+	tmp, err := os.CreateTemp("", "homelabctl-secrets-*.yaml")
+	if err != nil {
+		return false, fmt.Errorf("failed to create a temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(original); err != nil {
+		tmp.Close()
+		return false, fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", tmp.Name(), err)
+	}
+
+	if err := edit(tmp.Name()); err != nil {
+		return false, err
+	}
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", tmp.Name(), err)
+	}
+	if bytes.Equal(edited, original) {
+		return false, nil
+	}
+	if err := checkYAML(stackName, edited); err != nil {
+		return false, err
+	}
+
+	return true, encryptWithAge(edited, encrypted)
+}
+
+// checkYAML refuses secrets that do not parse, before they are encrypted
+func checkYAML(stackName string, data []byte) error {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("secrets for %s are not valid YAML: %w", stackName, err)
+	}
+	return nil
+}
+
+// encryptWithAge encrypts data, ASCII-armored, to the recipients into file
+func encryptWithAge(data []byte, file string) error {
+	keys, err := AgeRecipients()
+	if err != nil {
+		return err
+	}
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, key := range keys {
+		recipient, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			return errors.New(
+				fmt.Sprintf("invalid age recipient %q: %v", key, err),
+				"Recipients are age public keys (age1...): age-keygen -y <identity>",
+			).WithClass(errors.ClassValidation)
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	var encrypted bytes.Buffer
+	if err := armorEncrypt(&encrypted, data, recipients); err != nil {
+		return fmt.Errorf("age encryption failed: %w\nFile: %s", err, filepath.Base(file))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(file), err)
+	}
+	if err := os.WriteFile(file, encrypted.Bytes(), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
+
+// armorEncrypt writes data encrypted to the recipients as an ASCII-armored age file,
+// as age --encrypt --armor does
+func armorEncrypt(dst io.Writer, data []byte, recipients []age.Recipient) error {
+	armored := armor.NewWriter(dst)
+	w, err := age.Encrypt(armored, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return armored.Close()
+}
+
+// decryptWithAge decrypts an age-encrypted file, armored or binary, with the identity file
+func decryptWithAge(file string) ([]byte, error) {
+	identityFile, err := AgeIdentityFile()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := os.ReadFile(identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", identityFile, err)
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(keys))
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity file %s: %w", identityFile, err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("age decryption failed: %w\nFile: %s", err, filepath.Base(file))
+	}
+	output, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("age decryption failed: %w\nFile: %s", err, filepath.Base(file))
+	}
+	return output, nil
+}
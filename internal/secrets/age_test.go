@@ -0,0 +1,167 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func setupAgeTest(t *testing.T) (string, func()) {
+	t.Helper()
+
+	tmpDir, cleanup := testutil.TempDir(t)
+	restoreDir := testutil.Chdir(t, tmpDir)
+	testutil.CreateRepoStructure(t)
+
+	home := os.Getenv("HOME")
+	keyFile := os.Getenv("SOPS_AGE_KEY_FILE")
+	os.Setenv("HOME", filepath.Join(tmpDir, "home"))
+	os.Unsetenv("SOPS_AGE_KEY_FILE")
+
+	return tmpDir, func() {
+		os.Setenv("HOME", home)
+		os.Setenv("SOPS_AGE_KEY_FILE", keyFile)
+		restoreDir()
+		cleanup()
+	}
+}
+
+func TestAgeIdentityFile(t *testing.T) {
+	tmpDir, cleanup := setupAgeTest(t)
+	defer cleanup()
+
+	if _, err := AgeIdentityFile(); err == nil {
+		t.Fatal("AgeIdentityFile() error = nil without any key, want an error")
+	}
+
+	sopsKeys := filepath.Join(tmpDir, "home", ".config/sops/age/keys.txt")
+	testutil.WriteFile(t, sopsKeys, "# public key: age1home\nAGE-SECRET-KEY-1HOME\n")
+	if got, err := AgeIdentityFile(); err != nil || got != sopsKeys {
+		t.Errorf("AgeIdentityFile() = %q, %v, want %q", got, err, sopsKeys)
+	}
+
+	envKeys := filepath.Join(tmpDir, "env-keys.txt")
+	testutil.WriteFile(t, envKeys, "AGE-SECRET-KEY-1ENV\n")
+	os.Setenv("SOPS_AGE_KEY_FILE", envKeys)
+	if got, err := AgeIdentityFile(); err != nil || got != envKeys {
+		t.Errorf("AgeIdentityFile() = %q, %v, want %q", got, err, envKeys)
+	}
+
+	testutil.WriteFile(t, filepath.Join(tmpDir, "home", "inventory-keys.txt"), "AGE-SECRET-KEY-1INV\n")
+	testutil.WriteFile(t, paths.InventorySecrets, "age:\n  identity: ~/inventory-keys.txt\n")
+	want := filepath.Join(tmpDir, "home", "inventory-keys.txt")
+	if got, err := AgeIdentityFile(); err != nil || got != want {
+		t.Errorf("AgeIdentityFile() = %q, %v, want %q", got, err, want)
+	}
+
+	testutil.WriteFile(t, paths.InventorySecrets, "age:\n  identity: missing.txt\n")
+	if _, err := AgeIdentityFile(); err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Errorf("AgeIdentityFile() error = %v, want the missing identity named", err)
+	}
+}
+
+func TestAgeRecipients(t *testing.T) {
+	tmpDir, cleanup := setupAgeTest(t)
+	defer cleanup()
+
+	keys := filepath.Join(tmpDir, "keys.txt")
+	testutil.WriteFile(t, keys, "# created: 2024-01-01T00:00:00Z\n# public key: age1first\nAGE-SECRET-KEY-1A\n# public key: age1second\nAGE-SECRET-KEY-1B\n")
+	os.Setenv("SOPS_AGE_KEY_FILE", keys)
+
+	got, err := AgeRecipients()
+	if err != nil {
+		t.Fatalf("AgeRecipients() error = %v", err)
+	}
+	if want := []string{"age1first", "age1second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AgeRecipients() = %v, want %v", got, want)
+	}
+
+	testutil.WriteFile(t, paths.InventorySecrets, "age:\n  recipients:\n    - age1team\n")
+	got, err = AgeRecipients()
+	if err != nil {
+		t.Fatalf("AgeRecipients() error = %v", err)
+	}
+	if want := []string{"age1team"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AgeRecipients() = %v, want %v", got, want)
+	}
+}
+
+func TestAgeRoundTrip(t *testing.T) {
+	tmpDir, cleanup := setupAgeTest(t)
+	defer cleanup()
+
+	// A key as age-keygen writes it
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := filepath.Join(tmpDir, "keys.txt")
+	testutil.WriteFile(t, keys, "# public key: "+identity.Recipient().String()+"\n"+identity.String()+"\n")
+	os.Setenv("SOPS_AGE_KEY_FILE", keys)
+
+	testutil.WriteFile(t, paths.SecretsFilePath("app", paths.SecretsExt), "app:\n  password: hunter2\n")
+	if err := Encrypt("app"); err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if _, err := os.Stat(paths.SecretsFilePath("app", paths.SecretsExt)); !os.IsNotExist(err) {
+		t.Error("Encrypt() kept the plain secrets file")
+	}
+
+	loaded, err := LoadSecrets("app")
+	if err != nil {
+		t.Fatalf("LoadSecrets() error = %v", err)
+	}
+	app, _ := loaded["app"].(map[string]interface{})
+	if app["password"] != "hunter2" {
+		t.Errorf("LoadSecrets() = %v, want the decrypted password", loaded)
+	}
+
+	changed, err := Edit("app", func(path string) error {
+		return os.WriteFile(path, []byte("app:\n  password: changed\n"), 0600)
+	})
+	if err != nil || !changed {
+		t.Fatalf("Edit() = %v, %v, want a change", changed, err)
+	}
+	data, err := Decrypt("app")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if !strings.Contains(string(data), "changed") {
+		t.Errorf("Decrypt() = %q, want the edited secrets", data)
+	}
+	encrypted, err := os.ReadFile(paths.SecretsFilePath("app", paths.SecretsAgeExt))
+	if err != nil || !strings.HasPrefix(string(encrypted), armor.Header) {
+		t.Errorf("encrypted secrets = %.40q, %v, want an armored age file", encrypted, err)
+	}
+
+	if err := SetVar("app", "app.api_key", "abc"); err != nil {
+		t.Fatalf("SetVar() error = %v", err)
+	}
+	loaded, err = LoadSecrets("app")
+	if err != nil {
+		t.Fatalf("LoadSecrets() error = %v", err)
+	}
+	app, _ = loaded["app"].(map[string]interface{})
+	if app["password"] != "changed" || app["api_key"] != "abc" {
+		t.Errorf("LoadSecrets() = %v, want the set secret kept encrypted", loaded)
+	}
+}
+
+func TestEdit_RefusesPlainSecrets(t *testing.T) {
+	_, cleanup := setupAgeTest(t)
+	defer cleanup()
+
+	testutil.WriteFile(t, paths.SecretsFilePath("app", paths.SecretsExt), "app:\n  password: x\n")
+	_, err := Edit("app", func(string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "secrets encrypt app") {
+		t.Errorf("Edit() error = %v, want a hint to encrypt first", err)
+	}
+}
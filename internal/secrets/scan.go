@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// MinScanLength is the length under which secret values are not scanned for: short
+// values like "true" or "1000" appear in files for other reasons
+const MinScanLength = 6
+
+// Value is the value of a stack's secret
+type Value struct {
+	Stack string
+	Key   string // Dotted path in the secrets file
+	Value string
+}
+
+// Leak is a secret value found in a world-readable file
+type Leak struct {
+	File   string
+	Mode   os.FileMode
+	Secret Value
+}
+
+// String describes the leak without the secret's value
+func (l Leak) String() string {
+	return fmt.Sprintf("%s (mode %04o) contains secret %s of stack %s", l.File, l.Mode.Perm(), l.Secret.Key, l.Secret.Stack)
+}
+
+// Values flattens the string values of stacks' secrets, by stack then key; values
+// shorter than MinScanLength are left out
+func Values(stackSecrets map[string]map[string]interface{}) []Value {
+	var values []Value
+	var walk func(stackName, prefix string, m map[string]interface{})
+	walk = func(stackName, prefix string, m map[string]interface{}) {
+		for key, value := range m {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			switch v := value.(type) {
+			case map[string]interface{}:
+				walk(stackName, path, v)
+			case string:
+				if len(v) >= MinScanLength {
+					values = append(values, Value{Stack: stackName, Key: path, Value: v})
+				}
+			}
+		}
+	}
+	for stackName, secrets := range stackSecrets {
+		walk(stackName, "", secrets)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Stack != values[j].Stack {
+			return values[i].Stack < values[j].Stack
+		}
+		return values[i].Key < values[j].Key
+	})
+	return values
+}
+
+// WorldReadable reports whether a file mode lets any user read the file
+func WorldReadable(mode os.FileMode) bool {
+	return mode.Perm()&0004 != 0
+}
+
+// ScanFile returns the secret values a world-readable file contains; file is read from
+// path and reported as name. Files others cannot read, and missing files, have no leaks
+func ScanFile(path, name string, values []Value) ([]Leak, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", name, err)
+	}
+	if info.IsDir() || !WorldReadable(info.Mode()) {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", name, err)
+	}
+
+	var leaks []Leak
+	for _, value := range values {
+		if bytes.Contains(data, []byte(value.Value)) {
+			leaks = append(leaks, Leak{File: name, Mode: info.Mode(), Secret: value})
+		}
+	}
+	return leaks, nil
+}
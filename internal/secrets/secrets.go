@@ -1,6 +1,7 @@
 package secrets
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
@@ -9,16 +10,19 @@ import (
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/monkeymonk/homelabctl/internal/fs"
 	"github.com/monkeymonk/homelabctl/internal/paths"
 )
 
-// LoadSecrets loads secrets/<stack>.yaml or secrets/<stack>.enc.yaml if it exists
-// Automatically decrypts .enc.yaml files using SOPS
+// LoadSecrets loads secrets/<stack>.enc.yaml, secrets/<stack>.age or secrets/<stack>.yaml
+// if it exists
+// Automatically decrypts .enc.yaml files using SOPS, and .age files using age
 // Returns empty map if file doesn't exist (secrets are optional)
 func LoadSecrets(stackName string) (map[string]interface{}, error) {
-	// Try both .enc.yaml and .yaml extensions (encrypted first)
+	// Try encrypted files first
 	secretsPaths := []string{
 		paths.SecretsFilePath(stackName, paths.SecretsEncExt),
+		paths.SecretsFilePath(stackName, paths.SecretsAgeExt),
 		paths.SecretsFilePath(stackName, paths.SecretsExt),
 	}
 
@@ -38,12 +42,17 @@ func LoadSecrets(stackName string) (map[string]interface{}, error) {
 	var data []byte
 	var err error
 
-	// Check if file needs SOPS decryption
+	// Check if file needs SOPS or age decryption
 	if strings.HasSuffix(secretsFile, paths.SecretsEncExt) {
 		data, err = decryptWithSOPS(secretsFile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decrypt secrets for %s: %w", stackName, err)
 		}
+	} else if strings.HasSuffix(secretsFile, paths.SecretsAgeExt) {
+		data, err = decryptWithAge(secretsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secrets for %s: %w", stackName, err)
+		}
 	} else {
 		// Plain YAML file - read directly
 		data, err = os.ReadFile(secretsFile)
@@ -64,12 +73,97 @@ func LoadSecrets(stackName string) (map[string]interface{}, error) {
 	return secrets, nil
 }
 
+// Formats of a stack's secrets file
+const (
+	FormatSOPS  = "sops"  // secrets/<stack>.enc.yaml, encrypted with sops
+	FormatAge   = "age"   // secrets/<stack>.age, encrypted with age
+	FormatPlain = "plain" // secrets/<stack>.yaml
+)
+
+// File returns a stack's secrets file and its format, in the order LoadSecrets reads
+// them; "" when the stack has none
+func File(stackName string) (string, string) {
+	files := []struct{ ext, format string }{
+		{paths.SecretsEncExt, FormatSOPS},
+		{paths.SecretsAgeExt, FormatAge},
+		{paths.SecretsExt, FormatPlain},
+	}
+	for _, f := range files {
+		file := paths.SecretsFilePath(stackName, f.ext)
+		if _, err := os.Stat(file); err == nil {
+			return file, f.format
+		}
+	}
+	return "", ""
+}
+
+// SetVar sets the value at a dotted path in a stack's secrets file, in its format:
+// through sops for .enc.yaml, decrypted and encrypted again with age for .age, else in
+// the plain secrets/<stack>.yaml
+func SetVar(stackName, path string, value interface{}) error {
+	file, format := File(stackName)
+	switch format {
+	case FormatSOPS:
+		return setWithSOPS(file, path, value)
+	case FormatAge:
+		data, err := decryptWithAge(file)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt secrets for %s: %w", stackName, err)
+		}
+		if data, err = fs.SetYAMLBytes(data, file, path, value); err != nil {
+			return err
+		}
+		return encryptWithAge(data, file)
+	}
+
+	if err := fs.EnsureDir(paths.Secrets); err != nil {
+		return fmt.Errorf("failed to create %s: %w", paths.Secrets, err)
+	}
+
+	return fs.SetYAMLValue(paths.SecretsFilePath(stackName, paths.SecretsExt), path, value, paths.SecureFilePermissions)
+}
+
+// LookupSOPS returns the path of the sops binary
+func LookupSOPS(file string) (string, error) {
+	sopsPath, err := exec.LookPath("sops")
+	if err != nil {
+		return "", fmt.Errorf("sops not found in PATH - install from https://github.com/getsops/sops\nFile: %s", file)
+	}
+	return sopsPath, nil
+}
+
+// setWithSOPS sets a value in a sops-encrypted file with sops --set, which encrypts it
+// in place
+func setWithSOPS(file, path string, value interface{}) error {
+	sopsPath, err := LookupSOPS(file)
+	if err != nil {
+		return err
+	}
+
+	// sops addresses keys as ["a"]["b"] and takes the value as JSON
+	var index strings.Builder
+	for _, key := range strings.Split(path, ".") {
+		encoded, _ := json.Marshal(key)
+		index.WriteString("[" + string(encoded) + "]")
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+
+	output, err := exec.Command(sopsPath, "--set", index.String()+" "+string(encoded), file).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("sops failed to set %s: %s\nFile: %s", path, strings.TrimSpace(string(output)), filepath.Base(file))
+	}
+	return nil
+}
+
 // decryptWithSOPS uses the sops command to decrypt an encrypted file
 func decryptWithSOPS(filePath string) ([]byte, error) {
 	// Check if sops is available
-	sopsPath, err := exec.LookPath("sops")
+	sopsPath, err := LookupSOPS(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("sops not found in PATH - install from https://github.com/getsops/sops\nFile: %s", filePath)
+		return nil, err
 	}
 
 	// Run: sops -d <file>
@@ -0,0 +1,96 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestFile(t *testing.T) {
+	_, cleanup := setupAgeTest(t)
+	defer cleanup()
+
+	if file, format := File("app"); file != "" || format != "" {
+		t.Errorf("File() = %q, %q, want none", file, format)
+	}
+
+	files := []struct{ ext, format string }{
+		{paths.SecretsExt, FormatPlain},
+		{paths.SecretsAgeExt, FormatAge},
+		{paths.SecretsEncExt, FormatSOPS},
+	}
+	// Each file takes precedence over the previous ones
+	for _, f := range files {
+		testutil.WriteFile(t, paths.SecretsFilePath("app", f.ext), "app: {}\n")
+		file, format := File("app")
+		if file != paths.SecretsFilePath("app", f.ext) || format != f.format {
+			t.Errorf("File() = %q, %q, want %q, %q", file, format, paths.SecretsFilePath("app", f.ext), f.format)
+		}
+	}
+}
+
+func TestSetVar_Plain(t *testing.T) {
+	_, cleanup := setupAgeTest(t)
+	defer cleanup()
+
+	if err := SetVar("app", "app.db_password", "s3cret"); err != nil {
+		t.Fatalf("SetVar() error = %v", err)
+	}
+	if err := SetVar("app", "app.api_key", "abc"); err != nil {
+		t.Fatalf("SetVar() error = %v", err)
+	}
+
+	loaded, err := LoadSecrets("app")
+	if err != nil {
+		t.Fatalf("LoadSecrets() error = %v", err)
+	}
+	app, _ := loaded["app"].(map[string]interface{})
+	if app["db_password"] != "s3cret" || app["api_key"] != "abc" {
+		t.Errorf("LoadSecrets() = %v, want both secrets", loaded)
+	}
+
+	if err := SetVar("app", "app.db_password.user", "x"); err == nil || !strings.Contains(err.Error(), "not a mapping") {
+		t.Errorf("SetVar() error = %v, want a scalar crossed", err)
+	}
+}
+
+func TestScanFile(t *testing.T) {
+	dir, cleanup := setupAgeTest(t)
+	defer cleanup()
+
+	values := Values(map[string]map[string]interface{}{
+		"cloud": {"db": map[string]interface{}{"password": "s3cret-db", "user": "admin"}},
+		"app":   {"token": "app-token-123", "port": 8080},
+	})
+	want := []Value{{Stack: "app", Key: "token", Value: "app-token-123"}, {Stack: "cloud", Key: "db.password", Value: "s3cret-db"}}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("Values() = %v, want %v (short and non-string values left out)", values, want)
+	}
+
+	public := filepath.Join(dir, "public.yml")
+	private := filepath.Join(dir, "private.yml")
+	testutil.WriteFile(t, public, "password: s3cret-db\nuser: admin\n")
+	testutil.WriteFile(t, private, "password: s3cret-db\n")
+	if err := os.Chmod(private, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	leaks, err := ScanFile(public, "runtime/public.yml", values)
+	if err != nil {
+		t.Fatalf("ScanFile() error = %v", err)
+	}
+	if len(leaks) != 1 || leaks[0].String() != "runtime/public.yml (mode 0644) contains secret db.password of stack cloud" {
+		t.Errorf("ScanFile() = %v, want the cloud password", leaks)
+	}
+
+	for _, path := range []string{private, filepath.Join(dir, "missing.yml")} {
+		if leaks, err := ScanFile(path, path, values); err != nil || len(leaks) != 0 {
+			t.Errorf("ScanFile(%s) = %v, %v, want no leak", path, leaks, err)
+		}
+	}
+}
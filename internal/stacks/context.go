@@ -0,0 +1,30 @@
+package stacks
+
+import (
+	"fmt"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/render"
+)
+
+// validateContextVersion checks the template context version a stack expects
+// A stack without context_version expects version 1, the structure before versioning
+func validateContextVersion(stack *Stack) error {
+	if stack.ContextVersion == 0 {
+		stack.ContextVersion = 1
+	}
+
+	if stack.ContextVersion < 0 {
+		return fmt.Errorf("invalid context_version %d in stack %s", stack.ContextVersion, stack.Name)
+	}
+	if stack.ContextVersion > render.ContextVersion {
+		return errors.New(
+			fmt.Sprintf("stack %s expects template context version %d, this homelabctl provides version %d",
+				stack.Name, stack.ContextVersion, render.ContextVersion),
+			"Upgrade homelabctl to render this stack",
+			fmt.Sprintf("Or lower context_version in stacks/%s/stack.yaml after checking its templates", stack.Name),
+		).WithClass(errors.ClassValidation)
+	}
+
+	return nil
+}
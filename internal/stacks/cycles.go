@@ -1,5 +1,7 @@
 package stacks
 
+import "sort"
+
 const (
 	stateUnvisited = 0
 	stateVisiting  = 1
@@ -35,6 +37,35 @@ func NewCycleDetector(stackNames []string) (*CycleDetector, error) {
 	return detector, nil
 }
 
+// Stacks returns the stacks of the graph, sorted
+func (d *CycleDetector) Stacks() []string {
+	names := make([]string, 0, len(d.graph))
+	for name := range d.graph {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dependencies returns the stacks a stack requires, as listed in its stack.yaml
+func (d *CycleDetector) Dependencies(stack string) []string {
+	return d.graph[stack]
+}
+
+// Dependents returns the stacks of the graph requiring a stack, sorted
+func (d *CycleDetector) Dependents(stack string) []string {
+	var dependents []string
+	for _, name := range d.Stacks() {
+		for _, dep := range d.graph[name] {
+			if dep == stack {
+				dependents = append(dependents, name)
+				break
+			}
+		}
+	}
+	return dependents
+}
+
 // DetectCycles finds all cycles in the dependency graph
 func (d *CycleDetector) DetectCycles() [][]string {
 	var cycles [][]string
@@ -3,6 +3,7 @@ package stacks
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -326,3 +327,29 @@ func TestValidateDependencies_WithoutCycle(t *testing.T) {
 		t.Errorf("ValidateDependencies should pass for valid DAG, got error: %v", err)
 	}
 }
+
+func TestCycleDetector_Adjacency(t *testing.T) {
+	// Create test stacks: C → A, C → B → A
+	stacks := map[string][]string{
+		"a": {},
+		"b": {"a"},
+		"c": {"a", "b"},
+	}
+
+	setupTestStacks(t, stacks)
+
+	detector, err := NewCycleDetector([]string{"c", "b", "a"})
+	if err != nil {
+		t.Fatalf("Failed to create detector: %v", err)
+	}
+
+	if got := strings.Join(detector.Stacks(), ","); got != "a,b,c" {
+		t.Errorf("Stacks() = %s, want a,b,c", got)
+	}
+	if got := strings.Join(detector.Dependencies("c"), ","); got != "a,b" {
+		t.Errorf("Dependencies(c) = %s, want a,b", got)
+	}
+	if got := strings.Join(detector.Dependents("a"), ","); got != "b,c" {
+		t.Errorf("Dependents(a) = %s, want b,c", got)
+	}
+}
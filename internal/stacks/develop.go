@@ -0,0 +1,72 @@
+package stacks
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// Watch actions, as docker compose watch names them
+const (
+	WatchSync        = "sync"         // Copy changed files into the container
+	WatchRebuild     = "rebuild"      // Rebuild the image and recreate the container
+	WatchSyncRestart = "sync+restart" // Copy changed files, then restart the container
+	WatchRestart     = "restart"      // Restart the container
+)
+
+// WatchRule is a path `homelabctl dev` watches for one service
+type WatchRule struct {
+	Path   string   `yaml:"path"`   // Host path, relative to the stack directory
+	Target string   `yaml:"target"` // Path in the container, required to sync
+	Action string   `yaml:"action"` // Defaults to sync
+	Ignore []string `yaml:"ignore"` // Patterns relative to Path
+}
+
+// WatchPath returns the host path of a rule, resolved against the stack directory
+func (s *Stack) WatchPath(rule WatchRule) (string, error) {
+	path := rule.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(paths.StackDir(s.Name), path)
+	}
+	return filepath.Abs(path)
+}
+
+// validateDevelop checks the develop section and applies the default action
+func validateDevelop(stack *Stack) error {
+	declared := make(map[string]bool)
+	for _, svc := range stack.Services {
+		declared[svc] = true
+	}
+
+	for svc, rules := range stack.Develop {
+		if !declared[svc] {
+			return fmt.Errorf("develop section of stack %s names unknown service '%s' (services: %s)",
+				stack.Name, svc, strings.Join(stack.Services, ", "))
+		}
+
+		for i := range rules {
+			rule := &rules[i]
+			if rule.Path == "" {
+				return fmt.Errorf("develop rule %d of %s in stack %s has no path", i+1, svc, stack.Name)
+			}
+			if rule.Action == "" {
+				rule.Action = WatchSync
+			}
+
+			switch rule.Action {
+			case WatchSync, WatchSyncRestart:
+				if rule.Target == "" {
+					return fmt.Errorf("develop rule %s of %s in stack %s needs a target to %s", rule.Path, svc, stack.Name, rule.Action)
+				}
+			case WatchRebuild, WatchRestart:
+			default:
+				return fmt.Errorf("invalid develop action '%s' for %s in stack %s (use %s, %s, %s or %s)",
+					rule.Action, svc, stack.Name, WatchSync, WatchRebuild, WatchSyncRestart, WatchRestart)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package stacks
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// loadStackNode reads stack.yaml as a YAML node tree so edits keep comments and ordering
+func loadStackNode(name string) (*yaml.Node, error) {
+	data, err := os.ReadFile(paths.StackYAMLPath(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stack.yaml for %s: %w", name, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse stack.yaml for %s: %w", name, err)
+	}
+
+	return &doc, nil
+}
+
+// writeStackNode writes an edited node tree back to stack.yaml
+func writeStackNode(name string, doc *yaml.Node) error {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode stack.yaml for %s: %w", name, err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to encode stack.yaml for %s: %w", name, err)
+	}
+
+	if err := os.WriteFile(paths.StackYAMLPath(name), buf.Bytes(), paths.FilePermissions); err != nil {
+		return fmt.Errorf("failed to write stack.yaml for %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// ReplaceStackValue replaces every string value equal to oldValue in a stack's stack.yaml
+// Mapping keys are left untouched. Returns the number of replaced values
+func ReplaceStackValue(name, oldValue, newValue string) (int, error) {
+	doc, err := loadStackNode(name)
+	if err != nil {
+		return 0, err
+	}
+
+	count := replaceScalarValues(doc, oldValue, newValue)
+	if count == 0 {
+		return 0, nil
+	}
+
+	if err := writeStackNode(name, doc); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ReplaceStackValueAt replaces the string value at a path of mapping keys in a stack's
+// stack.yaml (e.g. vars, nginx, image) when it equals oldValue, leaving equal values
+// elsewhere untouched. Reports whether it was replaced
+func ReplaceStackValueAt(name string, path []string, oldValue, newValue string) (bool, error) {
+	doc, err := loadStackNode(name)
+	if err != nil {
+		return false, err
+	}
+
+	node := doc
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	for _, key := range path {
+		node = mappingValue(node, key)
+		if node == nil {
+			return false, nil
+		}
+	}
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!str" || node.Value != oldValue {
+		return false, nil
+	}
+
+	node.Value = newValue
+	if err := writeStackNode(name, doc); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// mappingValue returns the value of a key in a mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// CountStackValue returns how many string values in a stack's stack.yaml equal value
+func CountStackValue(name, value string) (int, error) {
+	doc, err := loadStackNode(name)
+	if err != nil {
+		return 0, err
+	}
+
+	// Replacing a value with itself only counts the matches
+	return replaceScalarValues(doc, value, value), nil
+}
+
+// replaceScalarValues walks a node tree replacing matching scalar values
+func replaceScalarValues(node *yaml.Node, oldValue, newValue string) int {
+	count := 0
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			count += replaceScalarValues(child, oldValue, newValue)
+		}
+	case yaml.MappingNode:
+		// Content alternates key, value
+		for i := 1; i < len(node.Content); i += 2 {
+			count += replaceScalarValues(node.Content[i], oldValue, newValue)
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!str" && node.Value == oldValue {
+			node.Value = newValue
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,113 @@
+package stacks
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestReplaceStackValue(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	content := `name: media
+category: media
+services:
+  - jellyfin
+vars:
+  jellyfin:
+    image: jellyfin/jellyfin:latest
+    # Volume holding the library database
+    config_volume: jellyfin_config
+persistence:
+  volumes:
+    - jellyfin_config
+`
+	testutil.WriteFile(t, "stacks/media/stack.yaml", content)
+
+	count, err := ReplaceStackValue("media", "jellyfin_config", "jellyfin_library")
+	if err != nil {
+		t.Fatalf("ReplaceStackValue() unexpected error: %v", err)
+	}
+
+	if count != 2 {
+		t.Errorf("Expected 2 replacements, got %d", count)
+	}
+
+	data, err := os.ReadFile("stacks/media/stack.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read stack.yaml: %v", err)
+	}
+
+	if strings.Contains(string(data), "jellyfin_config") {
+		t.Errorf("Old value should be replaced:\n%s", data)
+	}
+
+	if !strings.Contains(string(data), "# Volume holding the library database") {
+		t.Errorf("Comments should be preserved:\n%s", data)
+	}
+
+	stack, err := LoadStack("media")
+	if err != nil {
+		t.Fatalf("Edited stack should still load: %v", err)
+	}
+
+	if stack.Persistence.Volumes[0] != "jellyfin_library" {
+		t.Errorf("Persistence volume = %s, want jellyfin_library", stack.Persistence.Volumes[0])
+	}
+}
+
+func TestReplaceStackValueAt(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "stacks/web/stack.yaml", "name: web\ncategory: apps\nservices:\n  - nginx\n  - docs\nvars:\n  nginx:\n    image: nginx:1.25\n  docs:\n    image: nginx:1.25\n")
+
+	if replaced, err := ReplaceStackValueAt("web", []string{"vars", "nginx", "image"}, "nginx:1.24", "nginx:1.27"); err != nil || replaced {
+		t.Errorf("ReplaceStackValueAt() = %v, %v, want no replacement of another value", replaced, err)
+	}
+	if replaced, err := ReplaceStackValueAt("web", []string{"vars", "nginx", "image"}, "nginx:1.25", "nginx:1.27"); err != nil || !replaced {
+		t.Fatalf("ReplaceStackValueAt() = %v, %v, want a replacement", replaced, err)
+	}
+
+	stack, err := LoadStack("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nginx, _ := stack.Vars["nginx"].(map[string]interface{})
+	docs, _ := stack.Vars["docs"].(map[string]interface{})
+	if nginx["image"] != "nginx:1.27" || docs["image"] != "nginx:1.25" {
+		t.Errorf("vars = %v, want only nginx's image replaced", stack.Vars)
+	}
+}
+
+func TestCountStackValue(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "stacks/web/stack.yaml", "name: web\ncategory: apps\nservices:\n  - nginx\nvars:\n  nginx:\n    image: nginx:1.25\n")
+
+	count, err := CountStackValue("web", "nginx:1.25")
+	if err != nil {
+		t.Fatalf("CountStackValue() unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("CountStackValue() = %d, want 1", count)
+	}
+
+	data, _ := os.ReadFile("stacks/web/stack.yaml")
+	if !strings.Contains(string(data), "image: nginx:1.25") {
+		t.Errorf("CountStackValue() should not modify stack.yaml:\n%s", data)
+	}
+}
@@ -0,0 +1,213 @@
+package stacks
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// templateVarPattern matches .vars.<path> references in templates
+var templateVarPattern = regexp.MustCompile(`\.vars((?:\.[A-Za-z_][A-Za-z0-9_]*)+)`)
+
+// TemplateVarReferences returns the sorted dotted variable paths referenced by a stack's templates
+func TemplateVarReferences(name string) ([]string, error) {
+	found := make(map[string]bool)
+
+	err := filepath.Walk(paths.StackDir(name), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != paths.TemplateExt {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		for _, match := range templateVarPattern.FindAllStringSubmatch(string(data), -1) {
+			found[strings.TrimPrefix(match[1], ".")] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan templates of %s: %w", name, err)
+	}
+
+	refs := make([]string, 0, len(found))
+	for ref := range found {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	return refs, nil
+}
+
+// VarsExample builds an annotated inventory/vars.yaml example for the given stacks
+// Values are the stack defaults; variables referenced by templates or required by
+// vars_schema without a default are left empty and marked as required. vars_schema
+// descriptions become comments
+func VarsExample(stackNames []string) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	seen := make(map[string]bool)
+
+	for _, stackName := range stackNames {
+		stack, err := LoadStack(stackName)
+		if err != nil {
+			return nil, err
+		}
+
+		refs, err := TemplateVarReferences(stackName)
+		if err != nil {
+			return nil, err
+		}
+
+		vars := copyVars(stack.Vars)
+		required := make(map[string]bool)
+		for _, ref := range refs {
+			if addMissingPath(vars, strings.Split(ref, ".")) {
+				required[ref] = true
+			}
+		}
+		descriptions := make(map[string]string)
+		for _, path := range stack.SchemaPaths() {
+			schema := stack.VarsSchema[path]
+			if addMissingPath(vars, strings.Split(path, ".")) && schema.Required {
+				required[path] = true
+			}
+			descriptions[path] = schema.Description
+		}
+
+		keys := sortedKeys(vars)
+		first := true
+		for _, key := range keys {
+			// Top-level keys replace stack defaults as a whole, so each is listed once
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			keyNode, valueNode := exampleNodes(key, vars[key], key, required, descriptions)
+			if first {
+				keyNode.HeadComment = strings.TrimSpace(fmt.Sprintf("Stack: %s (%s)\n%s", stack.Name, stack.Category, keyNode.HeadComment))
+				first = false
+			}
+			root.Content = append(root.Content, keyNode, valueNode)
+		}
+	}
+
+	doc := &yaml.Node{
+		Kind: yaml.DocumentNode,
+		HeadComment: "Example inventory/vars.yaml generated by: homelabctl vars example\n" +
+			"Copy the values you want to change into inventory/vars.yaml.\n" +
+			"A top-level key in inventory/vars.yaml replaces the whole stack default,\n" +
+			"so copy complete blocks. Empty values marked 'required' have no default.",
+		Content: []*yaml.Node{root},
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode vars example: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode vars example: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// requiredVar marks a variable referenced by templates without a default
+type requiredVar struct{}
+
+// addMissingPath inserts a required placeholder for a path absent from vars
+// Returns false when the path already has a value (or crosses a scalar default)
+func addMissingPath(vars map[string]interface{}, path []string) bool {
+	current := vars
+	for i, key := range path {
+		value, exists := current[key]
+		if i == len(path)-1 {
+			if exists {
+				return false
+			}
+			current[key] = requiredVar{}
+			return true
+		}
+
+		if !exists {
+			nested := make(map[string]interface{})
+			current[key] = nested
+			current = nested
+			continue
+		}
+
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = nested
+	}
+	return false
+}
+
+// exampleNodes converts one variable to key/value nodes, annotating required values
+// and described ones
+func exampleNodes(key string, value interface{}, path string, required map[string]bool, descriptions map[string]string) (*yaml.Node, *yaml.Node) {
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: key}
+	keyNode.HeadComment = descriptions[path]
+
+	switch v := value.(type) {
+	case requiredVar:
+		valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: ""}
+		if required[path] {
+			keyNode.LineComment = "required"
+		}
+		return keyNode, valueNode
+	case map[string]interface{}:
+		valueNode := &yaml.Node{Kind: yaml.MappingNode}
+		for _, nestedKey := range sortedKeys(v) {
+			k, val := exampleNodes(nestedKey, v[nestedKey], path+"."+nestedKey, required, descriptions)
+			valueNode.Content = append(valueNode.Content, k, val)
+		}
+		return keyNode, valueNode
+	default:
+		valueNode := &yaml.Node{}
+		if err := valueNode.Encode(v); err != nil {
+			valueNode = &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprintf("%v", v)}
+		}
+		return keyNode, valueNode
+	}
+}
+
+// copyVars deep-copies nested variable maps so placeholders don't leak into the stack
+func copyVars(vars map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		if nested, ok := v.(map[string]interface{}); ok {
+			result[k] = copyVars(nested)
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// sortedKeys returns the keys of a variable map in sorted order
+func sortedKeys(vars map[string]interface{}) []string {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
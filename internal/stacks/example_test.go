@@ -0,0 +1,79 @@
+package stacks
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestVarsExample(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", `name: media
+category: media
+services:
+  - jellyfin
+vars:
+  jellyfin:
+    image: jellyfin/jellyfin:latest
+    port: 8096
+vars_schema:
+  jellyfin.port:
+    description: Web UI port
+  jellyfin.api_key:
+    description: Key for the metadata provider
+    required: true
+`)
+	testutil.WriteFile(t, "stacks/media/compose.yml.tmpl", `services:
+  jellyfin:
+    image: {{ .vars.jellyfin.image }}
+    environment:
+      TZ: {{ .vars.timezone }}
+      URL: {{ $.vars.jellyfin.public_url }}
+`)
+	testutil.CreateStack(t, "web", nil, []string{"app"})
+
+	refs, err := TemplateVarReferences("media")
+	if err != nil {
+		t.Fatalf("TemplateVarReferences() error: %v", err)
+	}
+	if strings.Join(refs, ",") != "jellyfin.image,jellyfin.public_url,timezone" {
+		t.Errorf("TemplateVarReferences() = %v", refs)
+	}
+
+	data, err := VarsExample([]string{"media", "web"})
+	if err != nil {
+		t.Fatalf("VarsExample() error: %v", err)
+	}
+	out := string(data)
+
+	for _, want := range []string{
+		"# Stack: media (media)",
+		"# Stack: web (other)",
+		"public_url: # required",
+		"timezone: # required",
+		"port: 8096",
+		"# Web UI port\n  port: 8096",
+		"# Key for the metadata provider\n  api_key: # required",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("example missing %q\n%s", want, out)
+		}
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal(data, &parsed); err != nil {
+		t.Fatalf("example is not valid YAML: %v\n%s", err, out)
+	}
+	jellyfin := parsed["jellyfin"].(map[string]interface{})
+	if jellyfin["image"] != "jellyfin/jellyfin:latest" {
+		t.Errorf("jellyfin.image = %v", jellyfin["image"])
+	}
+}
@@ -0,0 +1,56 @@
+package stacks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/proxy"
+)
+
+// Expose declares how the reverse proxy publishes a service, whatever the proxy is
+type Expose struct {
+	Host string `yaml:"host"`       // Host name; without a dot, a subdomain of the inventory domain (defaults to the service name)
+	Port int    `yaml:"port"`       // Container port the proxy forwards to
+	Path string `yaml:"path"`       // Only requests under this path prefix, e.g. /api
+	Via  string `yaml:"expose_via"` // Remote access on top of the proxy: tunnel or tailscale
+}
+
+// ExposedServices returns the services of the expose section, sorted
+func (s *Stack) ExposedServices() []string {
+	services := make([]string, 0, len(s.Expose))
+	for svc := range s.Expose {
+		services = append(services, svc)
+	}
+	sort.Strings(services)
+	return services
+}
+
+// validateExpose checks the expose section and applies the default host
+func validateExpose(stack *Stack) error {
+	declared := make(map[string]bool)
+	for _, svc := range stack.Services {
+		declared[svc] = true
+	}
+
+	for svc, expose := range stack.Expose {
+		switch {
+		case !declared[svc]:
+			return fmt.Errorf("expose section of stack %s names unknown service '%s' (services: %s)",
+				stack.Name, svc, strings.Join(stack.Services, ", "))
+		case expose.Port <= 0 || expose.Port > 65535:
+			return fmt.Errorf("expose entry of %s in stack %s needs a container port (1-65535)", svc, stack.Name)
+		case expose.Path != "" && !strings.HasPrefix(expose.Path, "/"):
+			return fmt.Errorf("expose path of %s in stack %s must start with /: %s", svc, stack.Name, expose.Path)
+		case expose.Via != "" && expose.Via != proxy.ViaTunnel && expose.Via != proxy.ViaTailscale:
+			return fmt.Errorf("invalid expose_via '%s' for %s in stack %s (use %s)", expose.Via, svc, stack.Name, strings.Join(proxy.Vias, " or "))
+		}
+
+		if expose.Host == "" {
+			expose.Host = svc
+		}
+		stack.Expose[svc] = expose
+	}
+
+	return nil
+}
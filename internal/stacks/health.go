@@ -0,0 +1,27 @@
+package stacks
+
+import (
+	"fmt"
+	"time"
+)
+
+// validateHealthTimeout checks the health_timeout of a stack is a positive duration
+func validateHealthTimeout(stack *Stack) error {
+	if stack.HealthTimeout == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(stack.HealthTimeout)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid health_timeout '%s' in stack %s (a duration, e.g. 90s or 5m)", stack.HealthTimeout, stack.Name)
+	}
+	return nil
+}
+
+// HealthTimeoutDuration returns the stack's health_timeout, or fallback when not set
+func (s *Stack) HealthTimeoutDuration(fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(s.HealthTimeout); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
@@ -0,0 +1,57 @@
+package stacks
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Hook events a stack can run commands on
+const (
+	HookPreGenerate  = "pre-generate"  // After its variables are merged, before it is rendered
+	HookPostGenerate = "post-generate" // After the generated files are written to runtime/
+	HookPreDeploy    = "pre-deploy"    // Before docker compose brings its services up
+	HookPostDeploy   = "post-deploy"   // After docker compose brought its services up
+)
+
+// HookEvents lists the hook events in the order they run
+var HookEvents = []string{HookPreGenerate, HookPostGenerate, HookPreDeploy, HookPostDeploy}
+
+// HookCommands are the shell commands of a hook, run in order
+type HookCommands []string
+
+// UnmarshalYAML accepts a single command as well as a list
+func (h *HookCommands) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*h = HookCommands{node.Value}
+		return nil
+	}
+
+	var commands []string
+	if err := node.Decode(&commands); err != nil {
+		return err
+	}
+	*h = commands
+	return nil
+}
+
+// validateHooks checks the hooks section: known events with non-empty commands
+func validateHooks(stack *Stack) error {
+	for event, commands := range stack.Hooks {
+		known := false
+		for _, e := range HookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return fmt.Errorf("hooks of stack %s: unknown event '%s' (use %s)", stack.Name, event, strings.Join(HookEvents, ", "))
+		}
+		for _, command := range commands {
+			if strings.TrimSpace(command) == "" {
+				return fmt.Errorf("hooks of stack %s: empty command for %s", stack.Name, event)
+			}
+		}
+	}
+
+	return nil
+}
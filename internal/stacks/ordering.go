@@ -1,9 +1,11 @@
 package stacks
 
 import (
+	"fmt"
 	"sort"
 
 	"github.com/monkeymonk/homelabctl/internal/categories"
+	"github.com/monkeymonk/homelabctl/internal/errors"
 )
 
 // StackWithCategory pairs a stack name with its category info
@@ -68,3 +70,63 @@ func GroupByCategory(stackNames []string) (map[string][]string, error) {
 
 	return groups, nil
 }
+
+// SortByDependencies sorts stack names so that every stack comes after the stacks it requires
+// Stacks are otherwise kept in category order; requirements outside the list are ignored
+func SortByDependencies(stackNames []string) ([]string, error) {
+	byCategory, err := SortByCategory(stackNames)
+	if err != nil {
+		return nil, err
+	}
+
+	requires := make(map[string][]string)
+	for _, name := range byCategory {
+		stack, err := LoadStack(name)
+		if err != nil {
+			return nil, err
+		}
+		requires[name] = stack.Requires
+	}
+
+	included := EnabledStacksMap(byCategory)
+	sorted := make([]string, 0, len(byCategory))
+	placed := make(map[string]bool)
+
+	for len(sorted) < len(byCategory) {
+		progress := false
+
+		for _, name := range byCategory {
+			if placed[name] {
+				continue
+			}
+
+			ready := true
+			for _, dep := range requires[name] {
+				if included[dep] && !placed[dep] {
+					ready = false
+					break
+				}
+			}
+
+			if ready {
+				sorted = append(sorted, name)
+				placed[name] = true
+				progress = true
+				break
+			}
+		}
+
+		if !progress {
+			detector, err := NewCycleDetector(byCategory)
+			if err != nil {
+				return nil, err
+			}
+			if cycles := detector.DetectCycles(); len(cycles) > 0 {
+				return nil, errors.DependencyCycle(cycles[0])
+			}
+			return nil, fmt.Errorf("unable to order stacks by dependencies")
+		}
+	}
+
+	return sorted, nil
+}
@@ -0,0 +1,61 @@
+package stacks
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// reservedOutputDirs are the directories of runtime/ homelabctl writes itself
+var reservedOutputDirs = []string{
+	paths.ContributionsDir, paths.TraefikDynamicDir, paths.HistoryDir, paths.UptimeDir, paths.DebugDir,
+	filepath.Dir(paths.TerraformFile), paths.AnsibleDir, paths.FirewallDir, paths.ProxyDir,
+}
+
+// reservedOutputFiles are the files homelabctl writes in each stack's directory of runtime/
+var reservedOutputFiles = []string{paths.ReadmeFile, paths.NotesFile, paths.NotesShownFile}
+
+// validateConfigOutputs checks the config_outputs of a stack: each config file must be
+// a relative path in config/, rendered to a file inside a directory of runtime/
+// Targets may not leave runtime/, nor land at its top level, in a hidden directory or
+// a directory homelabctl writes itself, nor over a stack's README or notes
+func validateConfigOutputs(stack *Stack) error {
+	for file, target := range stack.ConfigOutputs {
+		if !cleanRelative(file) || strings.HasSuffix(file, ".tmpl") {
+			return fmt.Errorf("config_outputs of stack %s: invalid config file '%s' (a path in config/, without .tmpl)",
+				stack.Name, file)
+		}
+
+		first := strings.SplitN(filepath.ToSlash(target), "/", 2)[0]
+		if !cleanRelative(target) || !strings.Contains(filepath.ToSlash(target), "/") || strings.HasPrefix(first, ".") {
+			return fmt.Errorf("config_outputs of stack %s: invalid target '%s' for %s (a file in a directory of runtime/, e.g. homepage/services.yaml)",
+				stack.Name, target, file)
+		}
+
+		runtimePath := filepath.Join(paths.Runtime, target)
+		for _, dir := range reservedOutputDirs {
+			if runtimePath == dir || strings.HasPrefix(runtimePath, dir+string(filepath.Separator)) {
+				return fmt.Errorf("config_outputs of stack %s: target '%s' for %s is in %s, which homelabctl writes itself",
+					stack.Name, target, file, dir)
+			}
+		}
+		if filepath.Dir(target) == first {
+			for _, name := range reservedOutputFiles {
+				if filepath.Base(target) == name {
+					return fmt.Errorf("config_outputs of stack %s: target '%s' for %s is the %s homelabctl renders for stack %s",
+						stack.Name, target, file, name, first)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// cleanRelative reports whether a path is relative, clean, and stays where it starts
+func cleanRelative(path string) bool {
+	return path != "" && !filepath.IsAbs(path) && filepath.Clean(path) == path &&
+		path != ".." && !strings.HasPrefix(path, ".."+string(filepath.Separator))
+}
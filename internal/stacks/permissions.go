@@ -0,0 +1,114 @@
+package stacks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Permission is the mode and owner given to a rendered config file or a persistence
+// directory; unset fields are left as they are
+type Permission struct {
+	Mode string `yaml:"mode"` // Octal, e.g. "0640"
+	UID  *int   `yaml:"uid"`
+	GID  *int   `yaml:"gid"`
+}
+
+// Permissions of a stack's rendered files and host directories
+type Permissions struct {
+	Configs map[string]Permission `yaml:"configs"` // Config file (path in config/, without .tmpl) or directory
+	Paths   map[string]Permission `yaml:"paths"`   // Directory listed in persistence.paths
+	Secrets map[string]Permission `yaml:"secrets"` // Secret key mounted as a compose secret file
+}
+
+// FileMode returns the permission's mode; ok is false when it has none
+func (p Permission) FileMode() (os.FileMode, bool) {
+	if p.Mode == "" {
+		return 0, false
+	}
+	mode, err := strconv.ParseUint(p.Mode, 8, 32)
+	if err != nil {
+		return 0, false
+	}
+	return os.FileMode(mode), true
+}
+
+// String describes the permission, e.g. mode 0640, owner 472:472
+func (p Permission) String() string {
+	var parts []string
+	if p.Mode != "" {
+		parts = append(parts, "mode "+p.Mode)
+	}
+	if p.UID != nil || p.GID != nil {
+		owner := func(id *int) string {
+			if id == nil {
+				return "-"
+			}
+			return strconv.Itoa(*id)
+		}
+		parts = append(parts, "owner "+owner(p.UID)+":"+owner(p.GID))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ConfigPermission returns the permission of a config file (path in config/, without
+// .tmpl): its own entry, else the one of its closest directory
+func (s *Stack) ConfigPermission(file string) (Permission, bool) {
+	for path := filepath.ToSlash(file); path != "."; path = filepath.ToSlash(filepath.Dir(path)) {
+		if perm, ok := s.Permissions.Configs[path]; ok {
+			return perm, true
+		}
+	}
+	return Permission{}, false
+}
+
+// validatePermissions checks the permissions section: modes are octal permission bits,
+// owners are numeric IDs, configs are paths in config/ and paths are persistence paths
+func validatePermissions(stack *Stack) error {
+	check := func(what string, perm Permission) error {
+		if perm.Mode != "" {
+			if mode, err := strconv.ParseUint(perm.Mode, 8, 32); err != nil || mode > 0o777 {
+				return fmt.Errorf("permissions of %s in stack %s: invalid mode '%s' (octal, e.g. \"0640\")", what, stack.Name, perm.Mode)
+			}
+		}
+		if (perm.UID != nil && *perm.UID < 0) || (perm.GID != nil && *perm.GID < 0) {
+			return fmt.Errorf("permissions of %s in stack %s: uid and gid cannot be negative", what, stack.Name)
+		}
+		return nil
+	}
+
+	for file, perm := range stack.Permissions.Configs {
+		if !cleanRelative(file) || strings.HasSuffix(file, ".tmpl") {
+			return fmt.Errorf("permissions of stack %s: invalid config '%s' (a file or directory in config/, without .tmpl)", stack.Name, file)
+		}
+		if err := check(file, perm); err != nil {
+			return err
+		}
+	}
+
+	for key, perm := range stack.Permissions.Secrets {
+		if key == "" || strings.ContainsAny(key, `/\`) {
+			return fmt.Errorf("permissions of stack %s: invalid secret '%s' (a dotted key of the stack's secrets)", stack.Name, key)
+		}
+		if err := check("secret "+key, perm); err != nil {
+			return err
+		}
+	}
+
+	declared := make(map[string]bool)
+	for _, path := range stack.Persistence.Paths {
+		declared[path] = true
+	}
+	for path, perm := range stack.Permissions.Paths {
+		if !declared[path] || !filepath.IsAbs(path) {
+			return fmt.Errorf("permissions of stack %s: '%s' is not an absolute path of persistence.paths", stack.Name, path)
+		}
+		if err := check(path, perm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
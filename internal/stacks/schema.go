@@ -0,0 +1,166 @@
+package stacks
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VarSchema describes a variable a stack accepts, keyed by its dotted path in vars_schema
+//
+//	vars_schema:
+//	  grafana.admin_user:
+//	    description: Login of the initial admin
+//	    default: admin
+//	  domain:
+//	    description: Base domain of the routers
+//	    required: true
+//	    pattern: '^[a-z0-9.-]+$'
+type VarSchema struct {
+	Description string      `yaml:"description"`
+	Default     interface{} `yaml:"default"`
+	Required    bool        `yaml:"required"`
+	Type        string      `yaml:"type"`    // string (default), int, float, bool
+	Pattern     string      `yaml:"pattern"` // Regular expression the input must match
+	Choices     []string    `yaml:"choices"` // Accepted values
+}
+
+// varTypes are the accepted vars_schema types
+var varTypes = map[string]bool{"": true, "string": true, "int": true, "float": true, "bool": true}
+
+// SchemaPaths returns the variable paths declared in vars_schema, sorted
+func (s *Stack) SchemaPaths() []string {
+	paths := make([]string, 0, len(s.VarsSchema))
+	for path := range s.VarsSchema {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// MissingRequiredVars returns the required schema variables without a value in vars
+func (s *Stack) MissingRequiredVars(vars map[string]interface{}) []string {
+	var missing []string
+	for _, path := range s.SchemaPaths() {
+		if !s.VarsSchema[path].Required {
+			continue
+		}
+		if value, ok := LookupVar(vars, path); !ok || value == nil || value == "" {
+			missing = append(missing, path)
+		}
+	}
+	return missing
+}
+
+// validateSchema checks vars_schema types and applies its defaults to vars
+// Values already set in vars win over schema defaults
+func validateSchema(stack *Stack) error {
+	for _, path := range stack.SchemaPaths() {
+		schema := stack.VarsSchema[path]
+		if !varTypes[schema.Type] {
+			return fmt.Errorf("invalid type '%s' for %s in vars_schema of %s (available: string, int, float, bool)", schema.Type, path, stack.Name)
+		}
+		if _, err := regexp.Compile(schema.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern for %s in vars_schema of %s: %w", path, stack.Name, err)
+		}
+		if schema.Default == nil {
+			continue
+		}
+
+		if stack.Vars == nil {
+			stack.Vars = make(map[string]interface{})
+		}
+		if _, ok := LookupVar(stack.Vars, path); !ok {
+			if !SetVar(stack.Vars, path, schema.Default) {
+				return fmt.Errorf("vars_schema default for %s conflicts with a value in vars of %s", path, stack.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// LookupVar returns the value at a dotted path in nested variables
+func LookupVar(vars map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = vars
+	for _, key := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// SetVar sets the value at a dotted path, creating intermediate maps
+// Returns false when the path crosses a value that is not a map
+func SetVar(vars map[string]interface{}, path string, value interface{}) bool {
+	keys := strings.Split(path, ".")
+	current := vars
+	for _, key := range keys[:len(keys)-1] {
+		next, exists := current[key]
+		if !exists {
+			nested := make(map[string]interface{})
+			current[key] = nested
+			current = nested
+			continue
+		}
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		current = nested
+	}
+	current[keys[len(keys)-1]] = value
+	return true
+}
+
+// ParseValue validates user input against the schema's pattern and choices and
+// converts it to the schema's type
+func (v VarSchema) ParseValue(input string) (interface{}, error) {
+	if v.Pattern != "" {
+		re, err := regexp.Compile(v.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", v.Pattern, err)
+		}
+		if !re.MatchString(input) {
+			return nil, fmt.Errorf("%q does not match %s", input, v.Pattern)
+		}
+	}
+	if len(v.Choices) > 0 {
+		valid := false
+		for _, choice := range v.Choices {
+			valid = valid || choice == input
+		}
+		if !valid {
+			return nil, fmt.Errorf("%q is not one of: %s", input, strings.Join(v.Choices, ", "))
+		}
+	}
+
+	switch v.Type {
+	case "int":
+		n, err := strconv.Atoi(input)
+		if err != nil {
+			return nil, fmt.Errorf("expected a whole number, got %q", input)
+		}
+		return n, nil
+	case "float":
+		f, err := strconv.ParseFloat(input, 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got %q", input)
+		}
+		return f, nil
+	case "bool":
+		b, err := strconv.ParseBool(input)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", input)
+		}
+		return b, nil
+	default:
+		return input, nil
+	}
+}
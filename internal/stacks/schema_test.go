@@ -0,0 +1,137 @@
+package stacks
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestLoadStack_VarsSchema(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "stacks/monitoring/stack.yaml", `name: monitoring
+category: monitoring
+services:
+  - grafana
+vars:
+  grafana:
+    image: grafana/grafana:latest
+    port: 3000
+vars_schema:
+  grafana.port:
+    description: Web UI port
+    default: 8080
+    type: int
+  grafana.admin_user:
+    description: Initial admin login
+    default: admin
+  grafana.admin_password:
+    description: Initial admin password
+    required: true
+`)
+
+	stack, err := LoadStack("monitoring")
+	if err != nil {
+		t.Fatalf("LoadStack() error = %v", err)
+	}
+
+	// Schema defaults fill gaps but don't override vars
+	if value, _ := LookupVar(stack.Vars, "grafana.admin_user"); value != "admin" {
+		t.Errorf("grafana.admin_user = %v, want schema default admin", value)
+	}
+	if value, _ := LookupVar(stack.Vars, "grafana.port"); value != 3000 {
+		t.Errorf("grafana.port = %v, want stack value 3000", value)
+	}
+
+	if got := strings.Join(stack.SchemaPaths(), ","); got != "grafana.admin_password,grafana.admin_user,grafana.port" {
+		t.Errorf("SchemaPaths() = %s", got)
+	}
+
+	missing := stack.MissingRequiredVars(stack.Vars)
+	if len(missing) != 1 || missing[0] != "grafana.admin_password" {
+		t.Errorf("MissingRequiredVars() = %v, want [grafana.admin_password]", missing)
+	}
+
+	vars := map[string]interface{}{"grafana": map[string]interface{}{"admin_password": "s3cret"}}
+	if missing := stack.MissingRequiredVars(vars); len(missing) != 0 {
+		t.Errorf("MissingRequiredVars() = %v, want none", missing)
+	}
+
+	testutil.WriteFile(t, "stacks/broken/stack.yaml", `name: broken
+category: tools
+services:
+  - app
+vars_schema:
+  app.port:
+    type: number
+`)
+	if _, err := LoadStack("broken"); err == nil || !strings.Contains(err.Error(), "invalid type 'number'") {
+		t.Errorf("LoadStack(broken) error = %v, want invalid type", err)
+	}
+}
+
+func TestVarSchemaParseValue(t *testing.T) {
+	tests := []struct {
+		typ     string
+		input   string
+		want    interface{}
+		wantErr bool
+	}{
+		{"", "grafana.lan", "grafana.lan", false},
+		{"int", "8080", 8080, false},
+		{"int", "80a", nil, true},
+		{"float", "0.5", 0.5, false},
+		{"bool", "true", true, false},
+		{"bool", "maybe", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := VarSchema{Type: tt.typ}.ParseValue(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseValue(%s, %q) error = %v, wantErr %v", tt.typ, tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseValue(%s, %q) = %v, want %v", tt.typ, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestVarSchemaValidation(t *testing.T) {
+	domain := VarSchema{Pattern: `^[a-z0-9.-]+$`}
+	if _, err := domain.ParseValue("home.lan"); err != nil {
+		t.Errorf("ParseValue(home.lan) error = %v", err)
+	}
+	if _, err := domain.ParseValue("Home Lan"); err == nil {
+		t.Error("ParseValue(Home Lan) should not match the pattern")
+	}
+
+	vendor := VarSchema{Choices: []string{"nvidia", "amd", "intel"}}
+	if _, err := vendor.ParseValue("amd"); err != nil {
+		t.Errorf("ParseValue(amd) error = %v", err)
+	}
+	if _, err := vendor.ParseValue("arm"); err == nil || !strings.Contains(err.Error(), "nvidia, amd, intel") {
+		t.Errorf("ParseValue(arm) error = %v, want choices", err)
+	}
+}
+
+func TestSetVar(t *testing.T) {
+	vars := map[string]interface{}{"domain": "lan"}
+
+	if !SetVar(vars, "grafana.auth.enabled", true) {
+		t.Fatal("SetVar() should create nested maps")
+	}
+	if value, ok := LookupVar(vars, "grafana.auth.enabled"); !ok || value != true {
+		t.Errorf("LookupVar() = %v, %v", value, ok)
+	}
+
+	// domain is a scalar, it can't hold nested values
+	if SetVar(vars, "domain.name", "x") {
+		t.Error("SetVar() should fail when crossing a scalar")
+	}
+}
@@ -0,0 +1,40 @@
+package stacks
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// SecretInline is the secret_files value keeping a secret inline in the environment
+const SecretInline = "inline"
+
+// envVarPattern matches an environment variable name
+var envVarPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretFileVar returns the variable that gets the file path of the secret an
+// environment variable holds, or "" to keep the variable inline
+func (s *Stack) SecretFileVar(envVar string) string {
+	target, ok := s.SecretFiles[envVar]
+	if !ok {
+		return envVar + "_FILE"
+	}
+	if target == SecretInline {
+		return ""
+	}
+	return target
+}
+
+// validateSecretFiles checks the secret_files section: environment variable names,
+// mapped to another name or inline
+func validateSecretFiles(stack *Stack) error {
+	for envVar, target := range stack.SecretFiles {
+		if !envVarPattern.MatchString(envVar) {
+			return fmt.Errorf("secret_files of stack %s: invalid environment variable '%s'", stack.Name, envVar)
+		}
+		if target != SecretInline && !envVarPattern.MatchString(target) {
+			return fmt.Errorf("secret_files of stack %s: %s maps to '%s' (an environment variable name, or %s)",
+				stack.Name, envVar, target, SecretInline)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package stacks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Network share types
+const (
+	ShareNFS = "nfs"
+	ShareSMB = "smb" // Mounted with the cifs filesystem
+)
+
+// Share is a network share declared in a stack's persistence section, rendered as a
+// named volume of the local driver
+type Share struct {
+	Type    string   `yaml:"type"`    // nfs (default) or smb
+	Server  string   `yaml:"server"`  // Host name or address
+	Export  string   `yaml:"export"`  // NFS export path, or SMB share name
+	Options []string `yaml:"options"` // Mount options, e.g. nfsvers=4 or username=${SMB_USER}
+}
+
+// DriverOpts returns the driver_opts of the local volume driver mounting the share
+func (s Share) DriverOpts() map[string]interface{} {
+	if s.Type == ShareSMB {
+		opts := map[string]interface{}{
+			"type":   "cifs",
+			"device": "//" + s.Server + "/" + strings.TrimPrefix(s.Export, "/"),
+		}
+		if len(s.Options) > 0 {
+			opts["o"] = strings.Join(s.Options, ",")
+		}
+		return opts
+	}
+
+	return map[string]interface{}{
+		"type":   ShareNFS,
+		"device": ":" + s.Export,
+		"o":      strings.Join(append([]string{"addr=" + s.Server}, s.Options...), ","),
+	}
+}
+
+// String describes the share, e.g. nfs nas.lan:/volume1/media
+func (s Share) String() string {
+	if s.Type == ShareSMB {
+		return fmt.Sprintf("smb //%s/%s", s.Server, strings.TrimPrefix(s.Export, "/"))
+	}
+	return fmt.Sprintf("nfs %s:%s", s.Server, s.Export)
+}
+
+// ShareNames returns the names of a stack's shares, sorted
+func (s *Stack) ShareNames() []string {
+	names := make([]string, 0, len(s.Persistence.Shares))
+	for name := range s.Persistence.Shares {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateShares checks the shares of the persistence section and applies the default type
+func validateShares(stack *Stack) error {
+	for name, share := range stack.Persistence.Shares {
+		if share.Type == "" {
+			share.Type = ShareNFS
+		}
+
+		switch {
+		case share.Type != ShareNFS && share.Type != ShareSMB:
+			return fmt.Errorf("invalid type '%s' for share %s in stack %s (use %s or %s)", share.Type, name, stack.Name, ShareNFS, ShareSMB)
+		case share.Server == "":
+			return fmt.Errorf("share %s in stack %s has no server", name, stack.Name)
+		case share.Export == "":
+			return fmt.Errorf("share %s in stack %s has no export", name, stack.Name)
+		case share.Type == ShareNFS && !strings.HasPrefix(share.Export, "/"):
+			return fmt.Errorf("NFS export of share %s in stack %s must be an absolute path: %s", name, stack.Name, share.Export)
+		}
+
+		stack.Persistence.Shares[name] = share
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package stacks
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
@@ -16,12 +17,72 @@ type Stack struct {
 	Name        string                 `yaml:"name"`
 	Category    string                 `yaml:"category"`
 	Requires    []string               `yaml:"requires"`
+	Tags        []string               `yaml:"tags"` // Free-form labels, e.g. for templates and filters
 	Services    []string               `yaml:"services"`
 	Vars        map[string]interface{} `yaml:"vars"`
+	VarsSchema  map[string]VarSchema   `yaml:"vars_schema"`
+	SmokeTests  map[string]string      `yaml:"smoke_tests"`
 	Persistence struct {
-		Volumes []string `yaml:"volumes"`
-		Paths   []string `yaml:"paths"`
+		Volumes []string         `yaml:"volumes"`
+		Paths   []string         `yaml:"paths"`
+		Shares  map[string]Share `yaml:"shares"` // Named volumes backed by NFS or SMB shares
 	} `yaml:"persistence"`
+
+	// Develop maps services to the paths `homelabctl dev` watches
+	Develop map[string][]WatchRule `yaml:"develop"`
+
+	// Expose maps services to the host name the reverse proxy publishes them on
+	Expose map[string]Expose `yaml:"expose"`
+
+	// StartupWaivers maps services allowed to start without waiting for the services
+	// of other stacks they depend on to the reason why (e.g. the service retries itself)
+	StartupWaivers map[string]string `yaml:"startup_waivers"`
+
+	// ConfigOutputs maps config files (paths in config/, without .tmpl) to where they are
+	// rendered in runtime/ instead of runtime/<stack>/, e.g. a directory another stack's
+	// service mounts
+	ConfigOutputs map[string]string `yaml:"config_outputs"`
+
+	// HealthTimeout is how long deploy waits for the stack's containers with a
+	// healthcheck to become healthy, as a duration (default 2m)
+	HealthTimeout string `yaml:"health_timeout"`
+
+	// ComposeVariants selects when compose.<variant>.yml.tmpl files are merged into the
+	// stack's compose file; a variant without an entry is always merged
+	ComposeVariants map[string]ComposeVariant `yaml:"compose_variants"`
+
+	// Permissions sets the mode and owner of rendered config files and persistence paths,
+	// e.g. for services running as a non-root user
+	Permissions Permissions `yaml:"permissions"`
+
+	// Hooks maps events (pre-generate, post-generate, pre-deploy, post-deploy) to shell
+	// commands run in the stack directory
+	Hooks map[string]HookCommands `yaml:"hooks"`
+
+	// SecretFiles maps environment variables holding a secret to the variable that gets
+	// the secret's file path instead, when secrets render as compose secrets (default
+	// <VAR>_FILE); "inline" keeps the value, for images that read no such variable
+	SecretFiles map[string]string `yaml:"secret_files"`
+
+	// ContextVersion is the template context version the stack's templates expect
+	// (render.ContextVersion); 1 when not set
+	ContextVersion int `yaml:"context_version"`
+
+	// RequireSources maps requires given as catalog/stack to their catalog (not serialized)
+	// Requires itself holds the local stack names
+	RequireSources map[string]string `yaml:"-"`
+
+	// Warnings lists non-fatal problems found while loading (not serialized)
+	Warnings []string `yaml:"-"`
+}
+
+// SplitRequire splits a requires entry into its catalog and stack name
+// A plain stack name has no catalog
+func SplitRequire(dep string) (string, string) {
+	if source, name, ok := strings.Cut(dep, "/"); ok {
+		return source, name
+	}
+	return "", dep
 }
 
 // LoadStack reads and parses a stack.yaml file
@@ -29,6 +90,9 @@ func LoadStack(name string) (*Stack, error) {
 	stackPath := paths.StackYAMLPath(name)
 
 	data, err := os.ReadFile(stackPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read stack.yaml for %s: %w: %w", name, errors.ErrStackNotFound, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read stack.yaml for %s: %w", name, err)
 	}
@@ -61,7 +125,8 @@ func LoadStack(name string) (*Stack, error) {
 
 	// Temporary migration: if services list is missing, derive from vars keys
 	if len(stack.Services) == 0 && len(stack.Vars) > 0 {
-		fmt.Fprintf(os.Stderr, "WARNING: stack %s missing 'services' field, deriving from vars (deprecated)\n", name)
+		stack.Warnings = append(stack.Warnings,
+			fmt.Sprintf("stack %s missing 'services' field, deriving from vars (deprecated)", name))
 		for key := range stack.Vars {
 			stack.Services = append(stack.Services, key)
 		}
@@ -72,6 +137,71 @@ func LoadStack(name string) (*Stack, error) {
 		return nil, fmt.Errorf("stack.yaml for %s has no services defined", name)
 	}
 
+	// Apply vars_schema defaults before anything reads the variables
+	if err := validateSchema(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateDevelop(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateShares(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateExpose(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateStartupWaivers(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateContextVersion(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateConfigOutputs(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateHealthTimeout(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validatePermissions(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateComposeVariants(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateHooks(&stack); err != nil {
+		return nil, err
+	}
+
+	if err := validateSecretFiles(&stack); err != nil {
+		return nil, err
+	}
+
+	// Namespaced requires are satisfied by the local copy of the catalog stack
+	for i, dep := range stack.Requires {
+		source, local := SplitRequire(dep)
+		if source == "" {
+			continue
+		}
+		if local == "" || strings.Contains(local, "/") {
+			return nil, fmt.Errorf("invalid requires entry '%s' in stack %s (expected <stack> or <catalog>/<stack>)", dep, name)
+		}
+		if stack.RequireSources == nil {
+			stack.RequireSources = make(map[string]string)
+		}
+		stack.RequireSources[local] = source
+		stack.Requires[i] = local
+	}
+
 	// Validate dependencies - check for self-dependency
 	for _, dep := range stack.Requires {
 		if dep == name {
@@ -79,7 +209,7 @@ func LoadStack(name string) (*Stack, error) {
 				fmt.Sprintf("stack '%s' cannot depend on itself", name),
 				fmt.Sprintf("Edit: stacks/%s/stack.yaml", name),
 				fmt.Sprintf("Remove '%s' from requires list", name),
-			)
+			).WithClass(errors.ClassDependency)
 		}
 	}
 
@@ -100,7 +230,11 @@ func ValidateDependencies(enabledStacks []string) error {
 
 		for _, dep := range stack.Requires {
 			if !enabled[dep] {
-				return fmt.Errorf("stack %s requires %s but it is not enabled", name, dep)
+				return errors.New(
+					fmt.Sprintf("stack %s requires %s but it is not enabled", name, dep),
+					fmt.Sprintf("Run: homelabctl enable %s", dep),
+					fmt.Sprintf("Or remove the dependency in stacks/%s/stack.yaml", name),
+				).WithClass(errors.ClassDependency).WithKind(errors.ErrDependencyMissing)
 			}
 		}
 	}
@@ -143,6 +277,7 @@ func CheckDependenciesForStack(stackName string, enabledStacks []string) error {
 			suggestions = append(suggestions, fmt.Sprintf("Run: homelabctl enable %s", dep))
 		}
 		suggestions = append(suggestions, fmt.Sprintf("Then run: homelabctl enable %s", stackName))
+		suggestions = append(suggestions, fmt.Sprintf("Or enable (and install) them all: homelabctl enable --with-deps %s", stackName))
 		suggestions = append(suggestions, fmt.Sprintf("Or remove dependencies in stacks/%s/stack.yaml", stackName))
 
 		// Build context showing dependency chain
@@ -155,12 +290,41 @@ func CheckDependenciesForStack(stackName string, enabledStacks []string) error {
 		return errors.New(
 			fmt.Sprintf("stack '%s' has unsatisfied dependencies", stackName),
 			suggestions...,
-		).WithContext(context...)
+		).WithContext(context...).WithClass(errors.ClassDependency).WithKind(errors.ErrDependencyMissing)
 	}
 
 	return nil
 }
 
+// EnabledDependents returns the enabled stacks requiring a stack, directly or through
+// other stacks, in dependency order: disabled in reverse order, no stack is left
+// without its requires
+func EnabledDependents(stackName string, enabledStacks []string) ([]string, error) {
+	graph, err := NewCycleDetector(enabledStacks)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	queue := []string{stackName}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, dependent := range graph.Dependents(name) {
+			if dependent != stackName && !found[dependent] {
+				found[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	dependents := make([]string, 0, len(found))
+	for name := range found {
+		dependents = append(dependents, name)
+	}
+	return SortByDependencies(dependents)
+}
+
 // HasComposeTemplate checks if stack has compose.yml.tmpl
 func HasComposeTemplate(name string) bool {
 	composePath := paths.StackComposeTemplate(name)
@@ -239,3 +403,23 @@ func ServiceExists(serviceName string, enabledStacks []string) (bool, string) {
 
 	return false, ""
 }
+
+// StacksDefiningService returns the stacks (among the given ones) that define a service
+func StacksDefiningService(serviceName string, stackNames []string) ([]string, error) {
+	var owners []string
+
+	for _, stackName := range stackNames {
+		services, err := GetServiceNames(stackName)
+		if err != nil {
+			return nil, err
+		}
+		for _, svc := range services {
+			if svc == serviceName {
+				owners = append(owners, stackName)
+				break
+			}
+		}
+	}
+
+	return owners, nil
+}
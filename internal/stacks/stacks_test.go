@@ -1,10 +1,16 @@
 package stacks
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/render"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
 )
 
 // setupTestStacksForDeps creates test stack definitions for dependency testing
@@ -159,6 +165,9 @@ func TestValidateDependencies_Missing(t *testing.T) {
 			if tt.wantErr && err != nil && !strings.Contains(err.Error(), tt.errContains) {
 				t.Errorf("ValidateDependencies() error = %v, should contain %q", err, tt.errContains)
 			}
+			if tt.wantErr && !errors.Is(err, errors.ErrDependencyMissing) {
+				t.Errorf("ValidateDependencies() error = %v, want ErrDependencyMissing", err)
+			}
 		})
 	}
 }
@@ -342,3 +351,409 @@ func TestServiceExists(t *testing.T) {
 		})
 	}
 }
+
+func TestSortByDependencies(t *testing.T) {
+	cleanup := setupTestStacksForDeps(t)
+	defer cleanup()
+
+	// All stacks share a category, so only requires determines the order
+	sorted, err := SortByDependencies([]string{"app", "databases", "monitoring", "core"})
+	if err != nil {
+		t.Fatalf("SortByDependencies() unexpected error: %v", err)
+	}
+
+	position := make(map[string]int)
+	for i, name := range sorted {
+		position[name] = i
+	}
+
+	if position["core"] > position["databases"] || position["core"] > position["monitoring"] {
+		t.Errorf("core should come before its dependents: %v", sorted)
+	}
+
+	if position["databases"] > position["app"] {
+		t.Errorf("databases should come before app: %v", sorted)
+	}
+}
+
+func TestLoadStack_NamespacedRequires(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.CreateStack(t, "app", []string{"catalog/postgres", "traefik"}, []string{"app"})
+
+	stack, err := LoadStack("app")
+	if err != nil {
+		t.Fatalf("LoadStack() error = %v", err)
+	}
+
+	if strings.Join(stack.Requires, ",") != "postgres,traefik" {
+		t.Errorf("Requires = %v, want local names", stack.Requires)
+	}
+	if stack.RequireSources["postgres"] != "catalog" || stack.RequireSources["traefik"] != "" {
+		t.Errorf("RequireSources = %v", stack.RequireSources)
+	}
+
+	testutil.CreateStack(t, "bad", []string{"a/b/c"}, []string{"bad"})
+	if _, err := LoadStack("bad"); err == nil {
+		t.Error("LoadStack() should reject a requires entry with several namespaces")
+	}
+}
+
+func TestLoadStack_Develop(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	tests := map[string]string{
+		"unknown service": "develop:\n  db:\n    - path: ./src\n      target: /app\n",
+		"missing target":  "develop:\n  app:\n    - path: ./src\n",
+		"invalid action":  "develop:\n  app:\n    - path: ./src\n      action: copy\n",
+	}
+	for name, develop := range tests {
+		testutil.WriteFile(t, "stacks/app/stack.yaml", "name: app\ncategory: apps\nservices: [app]\n"+develop)
+		if _, err := LoadStack("app"); err == nil {
+			t.Errorf("%s: LoadStack() should fail", name)
+		}
+	}
+
+	testutil.WriteFile(t, "stacks/app/stack.yaml", "name: app\ncategory: apps\nservices: [app]\n"+
+		"develop:\n  app:\n    - path: ./src\n      target: /app\n")
+	stack, err := LoadStack("app")
+	if err != nil {
+		t.Fatalf("LoadStack() error = %v", err)
+	}
+	if rule := stack.Develop["app"][0]; rule.Action != WatchSync {
+		t.Errorf("Action = %q, want default %q", rule.Action, WatchSync)
+	}
+}
+
+func TestLoadStack_Shares(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	tests := map[string]string{
+		"invalid type":     "    media:\n      type: iscsi\n      server: nas\n      export: /media\n",
+		"missing server":   "    media:\n      export: /media\n",
+		"relative export":  "    media:\n      server: nas\n      export: media\n",
+		"missing smb name": "    media:\n      type: smb\n      server: nas\n",
+	}
+	for name, share := range tests {
+		testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\ncategory: media\nservices: [jellyfin]\npersistence:\n  shares:\n"+share)
+		if _, err := LoadStack("media"); err == nil {
+			t.Errorf("%s: LoadStack() should fail", name)
+		}
+	}
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\ncategory: media\nservices: [jellyfin]\npersistence:\n  shares:\n"+
+		"    media:\n      server: nas.lan\n      export: /volume1/media\n      options: [nfsvers=4, ro]\n"+
+		"    photos:\n      type: smb\n      server: nas.lan\n      export: photos\n      options: [\"username=${SMB_USER}\"]\n")
+	stack, err := LoadStack("media")
+	if err != nil {
+		t.Fatalf("LoadStack() error = %v", err)
+	}
+
+	nfs := stack.Persistence.Shares["media"].DriverOpts()
+	if nfs["type"] != "nfs" || nfs["device"] != ":/volume1/media" || nfs["o"] != "addr=nas.lan,nfsvers=4,ro" {
+		t.Errorf("nfs driver_opts = %v", nfs)
+	}
+	smb := stack.Persistence.Shares["photos"].DriverOpts()
+	if smb["type"] != "cifs" || smb["device"] != "//nas.lan/photos" || smb["o"] != "username=${SMB_USER}" {
+		t.Errorf("smb driver_opts = %v", smb)
+	}
+	if names := stack.ShareNames(); len(names) != 2 || names[0] != "media" {
+		t.Errorf("ShareNames() = %v", names)
+	}
+}
+
+func TestLoadStack_Expose(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	tests := map[string]string{
+		"unknown service": "  sonarr:\n    port: 8989\n",
+		"missing port":    "  jellyfin:\n    host: media\n",
+		"relative path":   "  jellyfin:\n    port: 8096\n    path: api\n",
+		"unknown via":     "  jellyfin:\n    port: 8096\n    expose_via: vpn\n",
+	}
+	for name, expose := range tests {
+		testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\ncategory: media\nservices: [jellyfin]\nexpose:\n"+expose)
+		if _, err := LoadStack("media"); err == nil {
+			t.Errorf("%s: LoadStack() should fail", name)
+		}
+	}
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\ncategory: media\nservices: [jellyfin]\nexpose:\n  jellyfin:\n    port: 8096\n")
+	stack, err := LoadStack("media")
+	if err != nil {
+		t.Fatalf("LoadStack() error = %v", err)
+	}
+	if expose := stack.Expose["jellyfin"]; expose.Host != "jellyfin" || expose.Port != 8096 {
+		t.Errorf("Expose[jellyfin] = %+v, want host jellyfin (default) and port 8096", expose)
+	}
+}
+
+func TestLoadStack_StartupWaivers(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	tests := map[string]string{
+		"unknown service": "  sonarr: retries on its own\n",
+		"no reason":       "  jellyfin: \"\"\n",
+	}
+	for name, waivers := range tests {
+		testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\ncategory: media\nservices: [jellyfin]\nstartup_waivers:\n"+waivers)
+		if _, err := LoadStack("media"); err == nil {
+			t.Errorf("%s: LoadStack() should fail", name)
+		}
+	}
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\ncategory: media\nservices: [jellyfin]\nstartup_waivers:\n  jellyfin: retries on its own\n")
+	if _, err := LoadStack("media"); err != nil {
+		t.Errorf("LoadStack() error = %v", err)
+	}
+}
+
+func TestLoadStack_ContextVersion(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	// Stacks written before versioning expect version 1
+	testutil.WriteFile(t, "stacks/media/stack.yaml", "name: media\ncategory: media\nservices: [jellyfin]\n")
+	if stack, err := LoadStack("media"); err != nil || stack.ContextVersion != 1 {
+		t.Errorf("LoadStack() = %v, %v; want context version 1", stack, err)
+	}
+
+	for _, version := range []int{-1, render.ContextVersion + 1} {
+		testutil.WriteFile(t, "stacks/media/stack.yaml", fmt.Sprintf("name: media\ncategory: media\nservices: [jellyfin]\ncontext_version: %d\n", version))
+		if _, err := LoadStack("media"); err == nil {
+			t.Errorf("LoadStack() should fail for context_version %d", version)
+		}
+	}
+}
+
+func TestLoadStack_ConfigOutputs(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	base := "name: dashboards\ncategory: tools\nservices: [app]\n"
+	testutil.WriteFile(t, "stacks/dashboards/stack.yaml", base+"config_outputs:\n  homepage/services.yaml: homepage/config/services.yaml\n")
+	stack, err := LoadStack("dashboards")
+	if err != nil {
+		t.Fatalf("LoadStack() failed: %v", err)
+	}
+	if stack.ConfigOutputs["homepage/services.yaml"] != "homepage/config/services.yaml" {
+		t.Errorf("ConfigOutputs = %v", stack.ConfigOutputs)
+	}
+
+	invalid := []string{
+		"app.yml: /etc/app.yml",                 // Absolute
+		"app.yml: ../outside/app.yml",           // Leaves runtime/
+		"app.yml: docker-compose.yml",           // Top level of runtime/
+		"app.yml: .staging/app/app.yml",         // Hidden directory
+		"app.yml.tmpl: app/app.yml",             // Template name
+		"../app.yml: app/app.yml",               // Outside config/
+		"app.yml: app/../../docker-compose.yml", // Not clean
+		"app.yml: history/app.yml",              // Written by homelabctl
+		"app.yml: traefik/dynamic/app.yml",      // Contributions
+		"README.md: homepage/README.md",         // Another stack's README
+	}
+	for _, entry := range invalid {
+		testutil.WriteFile(t, "stacks/dashboards/stack.yaml", base+"config_outputs:\n  "+entry+"\n")
+		if _, err := LoadStack("dashboards"); err == nil {
+			t.Errorf("LoadStack() should reject config_outputs entry %q", entry)
+		}
+	}
+}
+
+func TestLoadStack_HealthTimeout(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	base := "name: media\ncategory: media\nservices: [jellyfin]\n"
+	testutil.WriteFile(t, "stacks/media/stack.yaml", base)
+	stack, err := LoadStack("media")
+	if err != nil {
+		t.Fatalf("LoadStack() failed: %v", err)
+	}
+	if got := stack.HealthTimeoutDuration(time.Minute); got != time.Minute {
+		t.Errorf("HealthTimeoutDuration() without health_timeout = %s, want the fallback", got)
+	}
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", base+"health_timeout: 5m\n")
+	if stack, err = LoadStack("media"); err != nil {
+		t.Fatalf("LoadStack() failed: %v", err)
+	}
+	if got := stack.HealthTimeoutDuration(time.Minute); got != 5*time.Minute {
+		t.Errorf("HealthTimeoutDuration() = %s, want 5m", got)
+	}
+
+	for _, value := range []string{"soon", "0s", "-1m"} {
+		testutil.WriteFile(t, "stacks/media/stack.yaml", base+"health_timeout: "+value+"\n")
+		if _, err := LoadStack("media"); err == nil {
+			t.Errorf("LoadStack() should reject health_timeout %q", value)
+		}
+	}
+}
+
+func TestLoadStack_Permissions(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	base := "name: monitoring\ncategory: monitoring\nservices: [grafana]\npersistence:\n  paths: [/srv/grafana]\n"
+	testutil.WriteFile(t, "stacks/monitoring/stack.yaml", base+
+		"permissions:\n  configs:\n    grafana: {mode: \"0640\", uid: 472, gid: 472}\n    grafana/provisioning/datasources.yaml: {mode: \"0600\"}\n"+
+		"  paths:\n    /srv/grafana: {uid: 472}\n")
+	stack, err := LoadStack("monitoring")
+	if err != nil {
+		t.Fatalf("LoadStack() failed: %v", err)
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"grafana/grafana.ini", "mode 0640, owner 472:472"},
+		{"grafana/provisioning/datasources.yaml", "mode 0600"},
+		{"app.env", ""},
+	}
+	for _, tt := range tests {
+		perm, ok := stack.ConfigPermission(tt.file)
+		if got := perm.String(); got != tt.want || ok != (tt.want != "") {
+			t.Errorf("ConfigPermission(%s) = %q, %v; want %q", tt.file, got, ok, tt.want)
+		}
+	}
+	if mode, ok := stack.Permissions.Configs["grafana"].FileMode(); !ok || mode != 0640 {
+		t.Errorf("FileMode() = %o, %v; want 0640", mode, ok)
+	}
+
+	invalid := []string{
+		"  configs:\n    grafana: {mode: rw}\n",        // Not octal
+		"  configs:\n    grafana: {mode: \"01777\"}\n", // Not permission bits
+		"  configs:\n    grafana: {uid: -1}\n",         // Negative ID
+		"  configs:\n    grafana.ini.tmpl: {uid: 1}\n", // Template name
+		"  paths:\n    /srv/other: {uid: 1}\n",         // Not a persistence path
+	}
+	for _, entry := range invalid {
+		testutil.WriteFile(t, "stacks/monitoring/stack.yaml", base+"permissions:\n"+entry)
+		if _, err := LoadStack("monitoring"); err == nil {
+			t.Errorf("LoadStack() should reject permissions %q", entry)
+		}
+	}
+}
+
+func TestLoadStack_ComposeVariants(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	base := "name: media\ncategory: media\nservices: [jellyfin]\n"
+	testutil.WriteFile(t, "stacks/media/compose.yml.tmpl", "services: {}\n")
+	testutil.WriteFile(t, "stacks/media/compose.gpu.yml.tmpl", "services: {}\n")
+	testutil.WriteFile(t, "stacks/media/compose.storage.yml.tmpl", "services: {}\n")
+	testutil.WriteFile(t, "stacks/media/stack.yaml", base+
+		"compose_variants:\n  gpu: {when: jellyfin.gpu, environments: [prod]}\n  missing: {when: jellyfin.x}\n")
+
+	stack, err := LoadStack("media")
+	if err != nil {
+		t.Fatalf("LoadStack() failed: %v", err)
+	}
+	if len(stack.Warnings) != 1 || !strings.Contains(stack.Warnings[0], "compose.missing.yml.tmpl") {
+		t.Errorf("Warnings = %v, want the variant without template", stack.Warnings)
+	}
+
+	variants, err := ComposeVariants("media")
+	if err != nil || strings.Join(variants, ",") != "gpu,storage" {
+		t.Errorf("ComposeVariants() = %v, %v; want gpu,storage", variants, err)
+	}
+
+	gpu := stack.ComposeVariants["gpu"]
+	tests := []struct {
+		vars map[string]interface{}
+		env  string
+		want bool
+	}{
+		{map[string]interface{}{"jellyfin": map[string]interface{}{"gpu": true}}, "prod", true},
+		{map[string]interface{}{"jellyfin": map[string]interface{}{"gpu": true}}, "", false},
+		{map[string]interface{}{"jellyfin": map[string]interface{}{"gpu": "false"}}, "prod", false},
+		{map[string]interface{}{"jellyfin": map[string]interface{}{}}, "prod", false},
+	}
+	for _, tt := range tests {
+		if got := gpu.Selected(tt.vars, tt.env, nil); got != tt.want {
+			t.Errorf("Selected(%v, %q) = %v, want %v", tt.vars, tt.env, got, tt.want)
+		}
+	}
+
+	// One of the profiles must be active
+	debug := ComposeVariant{Profiles: []string{"debug", "dev"}}
+	if debug.Selected(nil, "", nil) || debug.Selected(nil, "", []string{"gpu"}) || !debug.Selected(nil, "", []string{"gpu", "dev"}) {
+		t.Error("Selected() should require one of the variant's profiles")
+	}
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", base+"compose_variants:\n  gpu/nvidia: {}\n")
+	if _, err := LoadStack("media"); err == nil {
+		t.Error("LoadStack() should reject a variant name with a slash")
+	}
+}
+
+func TestLoadStack_Hooks(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	base := "name: media\ncategory: media\nservices: [jellyfin]\n"
+	testutil.WriteFile(t, "stacks/media/compose.yml.tmpl", "services: {}\n")
+	testutil.WriteFile(t, "stacks/media/stack.yaml", base+
+		"hooks:\n  pre-generate: ./scripts/prepare.sh\n  post-deploy:\n    - echo one\n    - echo two\n")
+
+	stack, err := LoadStack("media")
+	if err != nil {
+		t.Fatalf("LoadStack() failed: %v", err)
+	}
+	if got := stack.Hooks[HookPreGenerate]; len(got) != 1 || got[0] != "./scripts/prepare.sh" {
+		t.Errorf("pre-generate hooks = %v, want the single command", got)
+	}
+	if got := stack.Hooks[HookPostDeploy]; len(got) != 2 || got[1] != "echo two" {
+		t.Errorf("post-deploy hooks = %v, want both commands", got)
+	}
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", base+"hooks:\n  pre-start: echo\n")
+	if _, err := LoadStack("media"); err == nil || !strings.Contains(err.Error(), "unknown event") {
+		t.Errorf("LoadStack() error = %v, want an unknown event", err)
+	}
+
+	testutil.WriteFile(t, "stacks/media/stack.yaml", base+"hooks:\n  pre-deploy: ['  ']\n")
+	if _, err := LoadStack("media"); err == nil {
+		t.Error("LoadStack() should reject an empty command")
+	}
+}
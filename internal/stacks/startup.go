@@ -0,0 +1,27 @@
+package stacks
+
+import (
+	"fmt"
+	"strings"
+)
+
+// validateStartupWaivers checks that each startup waiver names a service of the stack
+// and gives the reason it may start without waiting for its dependencies
+func validateStartupWaivers(stack *Stack) error {
+	declared := make(map[string]bool)
+	for _, svc := range stack.Services {
+		declared[svc] = true
+	}
+
+	for svc, reason := range stack.StartupWaivers {
+		if !declared[svc] {
+			return fmt.Errorf("startup_waivers section of stack %s names unknown service '%s' (services: %s)",
+				stack.Name, svc, strings.Join(stack.Services, ", "))
+		}
+		if strings.TrimSpace(reason) == "" {
+			return fmt.Errorf("startup waiver of %s in stack %s needs a reason", svc, stack.Name)
+		}
+	}
+
+	return nil
+}
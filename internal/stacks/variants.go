@@ -0,0 +1,109 @@
+package stacks
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// ComposeVariant selects when a compose.<variant>.yml.tmpl is merged into the stack's
+// compose file; every condition set must hold
+type ComposeVariant struct {
+	When         string   `yaml:"when"`         // Variable path that must be set and true, e.g. jellyfin.gpu
+	Environments []string `yaml:"environments"` // Inventory environments (HOMELAB_ENV) it applies to
+	Profiles     []string `yaml:"profiles"`     // Compose profiles (COMPOSE_PROFILES), one of which must be active
+}
+
+// Selected reports whether the variant applies with the stack's merged variables, in
+// an environment ("" for none), with the active compose profiles
+func (v ComposeVariant) Selected(vars map[string]interface{}, environment string, profiles []string) bool {
+	if v.When != "" {
+		value, ok := LookupVar(vars, v.When)
+		if !ok || !enabledValue(value) {
+			return false
+		}
+	}
+	if len(v.Environments) > 0 && !containsAny(v.Environments, environment) {
+		return false
+	}
+	if len(v.Profiles) > 0 && !containsAny(v.Profiles, profiles...) {
+		return false
+	}
+	return true
+}
+
+// containsAny reports whether list holds one of values
+func containsAny(list []string, values ...string) bool {
+	for _, item := range list {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// enabledValue reports whether a variable turns a variant on: true, a non-empty string
+// other than "false" or "0", a non-zero number, or a non-empty list or map
+func enabledValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false" && v != "0"
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// ComposeVariants returns the variants of a stack's compose.<variant>.yml.tmpl files, sorted
+func ComposeVariants(name string) ([]string, error) {
+	matches, err := filepath.Glob(paths.StackComposeVariantTemplate(name, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compose variants of %s: %w", name, err)
+	}
+
+	variants := make([]string, 0, len(matches))
+	prefix, suffix := "compose.", ".yml"+paths.TemplateExt
+	for _, match := range matches {
+		variants = append(variants, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), prefix), suffix))
+	}
+	sort.Strings(variants)
+	return variants, nil
+}
+
+// validateComposeVariants checks the compose_variants section: each names a compose
+// variant template of the stack
+func validateComposeVariants(stack *Stack) error {
+	for variant, selector := range stack.ComposeVariants {
+		if variant == "" || strings.ContainsAny(variant, `/\`) {
+			return fmt.Errorf("compose_variants of stack %s: invalid variant '%s' (the <variant> of compose.<variant>.yml.tmpl)", stack.Name, variant)
+		}
+		if _, err := os.Stat(paths.StackComposeVariantTemplate(stack.Name, variant)); err != nil {
+			stack.Warnings = append(stack.Warnings,
+				fmt.Sprintf("compose_variants of stack %s names %s, but there is no %s", stack.Name, variant,
+					paths.StackComposeVariantTemplate(stack.Name, variant)))
+		}
+		for _, env := range selector.Environments {
+			if strings.TrimSpace(env) == "" {
+				return fmt.Errorf("compose_variants of stack %s: empty environment for variant %s", stack.Name, variant)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package style
+
+import "strings"
+
+// asciiGlyphs replaces the glyphs homelabctl prints with ASCII
+var asciiGlyphs = strings.NewReplacer(
+	"✓", "+",
+	"⨯", "x",
+	"✗", "x",
+	"→", "->",
+	"⇄", "<->",
+	"⚠", "!",
+	"•", "*",
+	"·", "-",
+	"├", "|",
+	"└", "`",
+	"│", "|",
+	"─", "-",
+)
+
+// ToASCII replaces glyphs in text with ASCII
+func ToASCII(text string) string {
+	return asciiGlyphs.Replace(text)
+}
+
+// Glyphs returns text with its glyphs replaced with ASCII when ASCII() holds
+// Only homelabctl's own messages go through it (ui, errors): data and the output of the
+// programs it runs are printed as they are
+func Glyphs(text string) string {
+	if !ASCII() {
+		return text
+	}
+	return ToASCII(text)
+}
@@ -0,0 +1,215 @@
+package style
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Color modes of output.color
+const (
+	ColorAuto   = "auto"   // Color when stdout is a terminal (default)
+	ColorAlways = "always" // Color even when piped
+	ColorNever  = "never"  // No color
+)
+
+// Colors are the ANSI codes of the color names themes use; "none" leaves text uncolored
+var Colors = map[string]string{
+	"black":   "30",
+	"red":     "31",
+	"green":   "32",
+	"yellow":  "33",
+	"blue":    "34",
+	"magenta": "35",
+	"cyan":    "36",
+	"white":   "37",
+	"gray":    "90",
+	"bold":    "1",
+	"none":    "",
+}
+
+// Config is the user config (~/.config/homelabctl/config.yaml): settings of the person
+// running homelabctl rather than of the repository
+type Config struct {
+	Output Output `yaml:"output"`
+}
+
+// Output is how homelabctl prints
+type Output struct {
+	ASCII bool   `yaml:"ascii"` // Replace ✓, ⨯, → and other glyphs with ASCII
+	Color string `yaml:"color"` // auto, always or never
+	Quiet bool   `yaml:"quiet"` // Drop progress, results and hints: errors, warnings and data remain
+	Hints *bool  `yaml:"hints"` // Print next steps and first-run guidance (default true)
+	Theme Theme  `yaml:"theme"`
+}
+
+// Theme overrides the colors of messages and category badges
+type Theme struct {
+	Success    string            `yaml:"success"`
+	Warning    string            `yaml:"warning"`
+	Error      string            `yaml:"error"`
+	Categories map[string]string `yaml:"categories"` // category -> color
+}
+
+var (
+	loadOnce sync.Once
+	loaded   *Config
+	loadErr  error
+)
+
+// ConfigFile returns the user config: HOMELAB_CONFIG, else homelabctl/config.yaml in
+// XDG_CONFIG_HOME or ~/.config
+func ConfigFile() string {
+	if file := os.Getenv("HOMELAB_CONFIG"); file != "" {
+		return file
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "homelabctl", "config.yaml")
+}
+
+// LoadConfig loads the user config; defaults when it doesn't exist
+func LoadConfig(file string) (*Config, error) {
+	config := &Config{Output: Output{Color: ColorAuto}}
+	if file == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	switch config.Output.Color {
+	case "":
+		config.Output.Color = ColorAuto
+	case ColorAuto, ColorAlways, ColorNever:
+	default:
+		return nil, fmt.Errorf("%s: invalid output.color '%s' (available: auto, always, never)", file, config.Output.Color)
+	}
+	theme := map[string]string{
+		"success": config.Output.Theme.Success,
+		"warning": config.Output.Theme.Warning,
+		"error":   config.Output.Theme.Error,
+	}
+	for category, color := range config.Output.Theme.Categories {
+		theme["categories."+category] = color
+	}
+	for key, color := range theme {
+		if _, ok := Colors[color]; color != "" && !ok {
+			return nil, fmt.Errorf("%s: unknown color '%s' for output.theme.%s (available: %s)", file, color, key, colorNames())
+		}
+	}
+	return config, nil
+}
+
+// colorNames lists the color names, sorted
+func colorNames() string {
+	names := make([]string, 0, len(Colors))
+	for name := range Colors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Load loads the user config once; the style functions use it, with defaults if it
+// doesn't load
+func Load() error {
+	loadOnce.Do(func() {
+		loaded, loadErr = LoadConfig(ConfigFile())
+	})
+	return loadErr
+}
+
+// current returns the user config, loading it if needed
+func current() *Config {
+	if Load() != nil || loaded == nil {
+		return &Config{Output: Output{Color: ColorAuto}}
+	}
+	return loaded
+}
+
+// ASCII reports whether glyphs are replaced with ASCII: --ascii (HOMELAB_ASCII) or
+// output.ascii
+func ASCII() bool {
+	return os.Getenv("HOMELAB_ASCII") != "" || current().Output.ASCII
+}
+
+// Quiet reports whether decorative output is dropped: --quiet (HOMELAB_QUIET) or
+// output.quiet
+func Quiet() bool {
+	return os.Getenv("HOMELAB_QUIET") != "" || current().Output.Quiet
+}
+
+// Hints reports whether next steps and first-run guidance are printed: not when quiet,
+// nor with output.hints: false
+func Hints() bool {
+	if Quiet() {
+		return false
+	}
+	hints := current().Output.Hints
+	return hints == nil || *hints
+}
+
+// ColorEnabled reports whether output is colored: never with NO_COLOR, else per
+// output.color, by default when stdout is a terminal
+func ColorEnabled() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	switch current().Output.Color {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Colorize wraps text in a named color, when output is colored
+func Colorize(color, text string) string {
+	code := Colors[color]
+	if code == "" || !ColorEnabled() {
+		return text
+	}
+	return "\033[" + code + "m" + text + "\033[0m"
+}
+
+// ThemeColor returns the color the theme gives a role (success, warning or error),
+// else fallback
+func ThemeColor(role, fallback string) string {
+	theme := current().Output.Theme
+	color := map[string]string{"success": theme.Success, "warning": theme.Warning, "error": theme.Error}[role]
+	if color == "" {
+		return fallback
+	}
+	return color
+}
+
+// CategoryColor returns the color the theme gives a category, else fallback (the
+// category's own color)
+func CategoryColor(category, fallback string) string {
+	if color := current().Output.Theme.Categories[category]; color != "" {
+		return color
+	}
+	return fallback
+}
@@ -0,0 +1,97 @@
+package style
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	config, err := LoadConfig(filepath.Join(tmpDir, "missing.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.Output.Color != ColorAuto || config.Output.ASCII {
+		t.Errorf("LoadConfig() = %+v, want the defaults", config.Output)
+	}
+
+	file := filepath.Join(tmpDir, "config.yaml")
+	testutil.WriteFile(t, file, "output:\n  ascii: true\n  color: never\n  theme:\n    error: magenta\n    categories:\n      media: green\n")
+	config, err = LoadConfig(file)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !config.Output.ASCII || config.Output.Color != ColorNever {
+		t.Errorf("Output = %+v, want ascii and color never", config.Output)
+	}
+	if config.Output.Theme.Categories["media"] != "green" || config.Output.Theme.Error != "magenta" {
+		t.Errorf("Theme = %+v, want media green and error magenta", config.Output.Theme)
+	}
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"output:\n  color: sometimes\n", "invalid output.color"},
+		{"output:\n  theme:\n    categories:\n      media: purple\n", "unknown color 'purple' for output.theme.categories.media"},
+	}
+	for _, tt := range tests {
+		testutil.WriteFile(t, file, tt.content)
+		if _, err := LoadConfig(file); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("LoadConfig(%q) error = %v, want %q", tt.content, err, tt.want)
+		}
+	}
+}
+
+func TestToASCII(t *testing.T) {
+	got := ToASCII("✓ Written → runtime/\n├── a\n└── b ⨯")
+	want := "+ Written -> runtime/\n|-- a\n`-- b x"
+	if got != want {
+		t.Errorf("ToASCII() = %q, want %q", got, want)
+	}
+}
+
+func TestGlyphs(t *testing.T) {
+	t.Setenv("HOMELAB_ASCII", "")
+	if got := Glyphs("✓ done"); got != "✓ done" && !current().Output.ASCII {
+		t.Errorf("Glyphs() = %q without --ascii, want the glyphs kept", got)
+	}
+	t.Setenv("HOMELAB_ASCII", "1")
+	if got := Glyphs("✓ done → ok"); got != "+ done -> ok" {
+		t.Errorf("Glyphs() = %q with --ascii, want ASCII", got)
+	}
+}
+
+func TestQuietAndHints(t *testing.T) {
+	loadOnce.Do(func() {})
+	saved := loaded
+	defer func() { loaded = saved }()
+
+	t.Setenv("HOMELAB_QUIET", "")
+	loaded = &Config{Output: Output{Color: ColorAuto}}
+	if Quiet() || !Hints() {
+		t.Errorf("Quiet() = %v, Hints() = %v by default, want false, true", Quiet(), Hints())
+	}
+
+	hints := false
+	loaded = &Config{Output: Output{Color: ColorAuto, Hints: &hints}}
+	if Quiet() || Hints() {
+		t.Errorf("Quiet() = %v, Hints() = %v with output.hints: false, want false, false", Quiet(), Hints())
+	}
+
+	loaded = &Config{Output: Output{Color: ColorAuto, Quiet: true}}
+	if !Quiet() || Hints() {
+		t.Errorf("Quiet() = %v, Hints() = %v with output.quiet, want true, false", Quiet(), Hints())
+	}
+
+	loaded = &Config{Output: Output{Color: ColorAuto}}
+	t.Setenv("HOMELAB_QUIET", "1")
+	if !Quiet() {
+		t.Error("Quiet() should hold with --quiet (HOMELAB_QUIET)")
+	}
+}
@@ -0,0 +1,428 @@
+// Package terraform renders the generated deployment as Terraform configuration for
+// the kreuzwerker/docker provider, in JSON syntax, for users who reconcile containers
+// with Terraform while stacks stay the source of truth
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/monkeymonk/homelabctl/internal/docker"
+	"github.com/monkeymonk/homelabctl/internal/engine"
+)
+
+// Docker provider the configuration is written for
+const (
+	providerSource  = "kreuzwerker/docker"
+	providerVersion = "~> 3.0"
+)
+
+// Header is the comment on top of the generated configuration
+const Header = "Generated by homelabctl generate --terraform - do not edit"
+
+// expressionPattern matches the placeholders that become Terraform expressions; they
+// keep references apart from literal text until that text is escaped, and are plain
+// YAML scalars so variables can be substituted before the compose file is parsed
+var expressionPattern = regexp.MustCompile(`__tf\(([A-Za-z0-9_.\-]+)\)__`)
+
+// invalidName matches the characters Terraform does not allow in resource names
+var invalidName = regexp.MustCompile(`[^A-Za-z0-9_\-]`)
+
+// expression returns a placeholder for a Terraform expression
+func expression(expr string) string {
+	return "__tf(" + expr + ")__"
+}
+
+// Render translates a project's compose files to Terraform configuration
+// Compose variables become sensitive Terraform variables (set them with TF_VAR_<name>
+// or a tfvars file), so values from .env are not written out
+func Render(project engine.Project) ([]byte, error) {
+	var text strings.Builder
+	for _, path := range project.Files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		text.Write(data)
+		text.WriteByte('\n')
+	}
+
+	variables := engine.Variables(text.String())
+	env := make(map[string]string, len(variables))
+	for _, v := range variables {
+		env[v.Name] = expression("var." + v.Name)
+	}
+
+	res, err := engine.LoadResources(project, env)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Build(res, variables)
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal terraform configuration: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Build returns the Terraform configuration of the resources, ready to marshal as JSON
+func Build(res *engine.Resources, variables []engine.Variable) map[string]interface{} {
+	resources := make(map[string]map[string]interface{})
+	add := func(kind, name string, body map[string]interface{}) {
+		if resources[kind] == nil {
+			resources[kind] = make(map[string]interface{})
+		}
+		resources[kind][name] = body
+	}
+
+	// Networks and volumes created by the project are resources; external ones are referenced by name
+	networks := make(map[string]string)
+	for _, n := range res.Networks {
+		if n.External {
+			networks[n.Name] = n.Name
+			continue
+		}
+		name := resourceName(n.Key)
+		add("docker_network", name, map[string]interface{}{"name": n.Name, "labels": labelBlocks(n.Labels)})
+		networks[n.Name] = expression("docker_network." + name + ".name")
+	}
+
+	volumes := make(map[string]string)
+	for _, v := range res.Volumes {
+		if v.External {
+			volumes[v.Name] = v.Name
+			continue
+		}
+		name := resourceName(v.Key)
+		body := map[string]interface{}{"name": v.Name, "labels": labelBlocks(v.Labels)}
+		if v.Driver != "" {
+			body["driver"] = v.Driver
+		}
+		if len(v.DriverOpts) > 0 {
+			body["driver_opts"] = v.DriverOpts
+		}
+		add("docker_volume", name, body)
+		volumes[v.Name] = expression("docker_volume." + name + ".name")
+	}
+
+	images := make(map[string]string)
+	usedImages := make(map[string]bool)
+	for _, c := range res.Containers {
+		image, ok := images[c.Spec.Image]
+		if !ok {
+			image = resourceName(c.Spec.Image)
+			for base, i := image, 2; usedImages[image]; i++ {
+				image = fmt.Sprintf("%s_%d", base, i)
+			}
+			usedImages[image] = true
+			images[c.Spec.Image] = image
+			add("docker_image", image, map[string]interface{}{"name": c.Spec.Image, "keep_locally": true})
+		}
+
+		body := container(c, networks, volumes)
+		body["image"] = expression("docker_image." + image + ".image_id")
+		add("docker_container", resourceName(c.Service), body)
+	}
+
+	config := map[string]interface{}{
+		"//": Header,
+		"terraform": map[string]interface{}{
+			"required_providers": map[string]interface{}{
+				"docker": map[string]string{"source": providerSource, "version": providerVersion},
+			},
+		},
+		"provider": map[string]interface{}{"docker": map[string]interface{}{}},
+	}
+
+	if len(variables) > 0 {
+		declared := make(map[string]interface{}, len(variables))
+		for _, v := range variables {
+			variable := map[string]interface{}{"type": "string", "sensitive": true}
+			if v.HasDefault {
+				variable["default"] = v.Default
+			}
+			declared[v.Name] = variable
+		}
+		config["variable"] = declared
+	}
+	if len(resources) > 0 {
+		config["resource"] = resources
+	}
+
+	return finalize(config).(map[string]interface{})
+}
+
+// container returns the docker_container arguments of a service
+func container(c engine.ContainerResource, networks, volumes map[string]string) map[string]interface{} {
+	spec := c.Spec
+	body := map[string]interface{}{"name": c.Name}
+
+	set := func(key string, value interface{}) {
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				return
+			}
+		case []string:
+			if len(v) == 0 {
+				return
+			}
+		case []map[string]interface{}:
+			if len(v) == 0 {
+				return
+			}
+		case bool:
+			if !v {
+				return
+			}
+		}
+		body[key] = value
+	}
+
+	set("command", spec.Cmd)
+	set("entrypoint", spec.Entrypoint)
+	set("env", spec.Env)
+	set("user", spec.User)
+	set("working_dir", spec.WorkingDir)
+	set("hostname", spec.Hostname)
+	set("restart", spec.HostConfig.RestartPolicy.Name)
+	set("privileged", spec.HostConfig.Privileged)
+	set("tty", spec.Tty)
+	set("stdin_open", spec.OpenStdin)
+	set("security_opts", spec.HostConfig.SecurityOpt)
+	// The spec hash only means something to the native deploy
+	labels := make(map[string]string, len(spec.Labels))
+	for key, value := range spec.Labels {
+		if key != engine.SpecHashLabel {
+			labels[key] = value
+		}
+	}
+	set("labels", labelBlocks(labels))
+	if spec.StopTimeout != nil {
+		body["stop_timeout"] = *spec.StopTimeout
+	}
+
+	if len(spec.HostConfig.CapAdd) > 0 || len(spec.HostConfig.CapDrop) > 0 {
+		capabilities := make(map[string]interface{})
+		if len(spec.HostConfig.CapAdd) > 0 {
+			capabilities["add"] = spec.HostConfig.CapAdd
+		}
+		if len(spec.HostConfig.CapDrop) > 0 {
+			capabilities["drop"] = spec.HostConfig.CapDrop
+		}
+		body["capabilities"] = []interface{}{capabilities}
+	}
+
+	if check := spec.Healthcheck; check != nil {
+		healthcheck := map[string]interface{}{"test": check.Test}
+		for key, d := range map[string]interface{}{"interval": check.Interval, "timeout": check.Timeout, "start_period": check.StartPeriod} {
+			if s := fmt.Sprint(d); s != "0s" {
+				healthcheck[key] = s
+			}
+		}
+		if check.Retries > 0 {
+			healthcheck["retries"] = check.Retries
+		}
+		body["healthcheck"] = []interface{}{healthcheck}
+	}
+
+	set("ports", portBlocks(spec.HostConfig.PortBindings))
+	set("volumes", volumeBlocks(spec.HostConfig.Binds, spec.Volumes, volumes))
+
+	var hosts []map[string]interface{}
+	for _, entry := range spec.HostConfig.ExtraHosts {
+		host, ip, _ := strings.Cut(entry, ":")
+		hosts = append(hosts, map[string]interface{}{"host": host, "ip": ip})
+	}
+	set("host", hosts)
+
+	var devices []map[string]interface{}
+	for _, d := range spec.HostConfig.Devices {
+		devices = append(devices, map[string]interface{}{
+			"host_path": d.PathOnHost, "container_path": d.PathInContainer, "permissions": d.CgroupPermissions,
+		})
+	}
+	set("devices", devices)
+
+	// The primary network is the network mode, like the native deploy creates containers
+	if mode := spec.HostConfig.NetworkMode; mode != "" {
+		if ref, ok := networks[mode]; ok {
+			mode = ref
+		}
+		body["network_mode"] = mode
+	}
+	names := make([]string, 0, len(spec.NetworkingConfig.EndpointsConfig))
+	for name := range spec.NetworkingConfig.EndpointsConfig {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var attached []map[string]interface{}
+	for _, name := range names {
+		network := name
+		if ref, ok := networks[name]; ok {
+			network = ref
+		}
+		attached = append(attached, map[string]interface{}{
+			"name":    network,
+			"aliases": spec.NetworkingConfig.EndpointsConfig[name].Aliases,
+		})
+	}
+	set("networks_advanced", attached)
+
+	var dependsOn []string
+	for _, service := range c.DependsOn {
+		dependsOn = append(dependsOn, "docker_container."+resourceName(service))
+	}
+	set("depends_on", dependsOn)
+
+	return body
+}
+
+// portBlocks translates port bindings, sorted by container port
+func portBlocks(bindings map[string][]docker.PortBinding) []map[string]interface{} {
+	keys := make([]string, 0, len(bindings))
+	for key := range bindings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var blocks []map[string]interface{}
+	for _, key := range keys {
+		port, protocol, _ := strings.Cut(key, "/")
+		for _, binding := range bindings[key] {
+			block := map[string]interface{}{"internal": number(port)}
+			if protocol != "" && protocol != "tcp" {
+				block["protocol"] = protocol
+			}
+			if binding.HostPort != "" {
+				block["external"] = number(binding.HostPort)
+			}
+			if binding.HostIP != "" {
+				block["ip"] = binding.HostIP
+			}
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// volumeBlocks translates binds and anonymous volumes
+func volumeBlocks(binds []string, anonymous map[string]struct{}, volumes map[string]string) []map[string]interface{} {
+	var blocks []map[string]interface{}
+	for _, bind := range binds {
+		parts := strings.SplitN(bind, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		block := map[string]interface{}{"container_path": parts[1]}
+		if strings.HasPrefix(parts[0], "/") {
+			block["host_path"] = parts[0]
+		} else if ref, ok := volumes[parts[0]]; ok {
+			block["volume_name"] = ref
+		} else {
+			block["volume_name"] = parts[0]
+		}
+		if len(parts) == 3 && strings.Contains(parts[2], "ro") {
+			block["read_only"] = true
+		}
+		blocks = append(blocks, block)
+	}
+
+	paths := make([]string, 0, len(anonymous))
+	for path := range anonymous {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		blocks = append(blocks, map[string]interface{}{"container_path": path})
+	}
+	return blocks
+}
+
+// labelBlocks translates labels to label blocks, sorted by label
+func labelBlocks(labels map[string]string) []map[string]interface{} {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	blocks := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		blocks = append(blocks, map[string]interface{}{"label": key, "value": labels[key]})
+	}
+	return blocks
+}
+
+// number returns a port as a number, or as a string when it is an expression
+func number(value string) interface{} {
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	return value
+}
+
+// resourceName turns a compose name into a Terraform resource name
+func resourceName(name string) string {
+	name = expressionPattern.ReplaceAllString(name, "$1")
+	name = invalidName.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// finalize escapes the template sequences of literal strings, then turns the
+// expression placeholders into Terraform interpolations
+func finalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		v = strings.ReplaceAll(v, "${", "$${")
+		v = strings.ReplaceAll(v, "%{", "%%{")
+		return expressionPattern.ReplaceAllString(v, "$${$1}")
+	case []string:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = finalize(item)
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = finalize(item)
+		}
+		return list
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = finalize(item)
+		}
+		return list
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = finalize(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = finalize(item)
+		}
+		return m
+	case map[string]map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = finalize(item)
+		}
+		return m
+	default:
+		return value
+	}
+}
@@ -0,0 +1,112 @@
+package terraform
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/monkeymonk/homelabctl/internal/engine"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestRender(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	testutil.WriteFile(t, "runtime/docker-compose.yml", `services:
+  db:
+    image: postgres:16
+    environment:
+      POSTGRES_PASSWORD: ${DB_PASSWORD}
+      PGDATA: /var/lib/postgresql/data
+    volumes:
+      - db_data:/var/lib/postgresql/data
+  app:
+    image: ghcr.io/example/app:${APP_TAG:-latest}
+    command: ["sh", "-c", "echo $$HOME $${literal}"]
+    ports:
+      - "127.0.0.1:8080:80"
+      - "53:53/udp"
+    volumes:
+      - ./app/config.yml:/config.yml:ro
+      - media:/media
+    depends_on:
+      - db
+    restart: unless-stopped
+    healthcheck:
+      test: ["CMD", "curl", "-f", "http://localhost"]
+      interval: 30s
+      retries: 3
+volumes:
+  db_data:
+  media:
+    driver: local
+    driver_opts:
+      type: nfs
+      o: addr=nas
+      device: ":/media"
+`)
+
+	data, err := Render(engine.Project{Name: "homelab", Files: []string{"runtime/docker-compose.yml"}})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	text := string(data)
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("Render() output is not JSON: %v", err)
+	}
+
+	variables := config["variable"].(map[string]interface{})
+	if _, ok := variables["DB_PASSWORD"]; !ok {
+		t.Errorf("variables = %v, want DB_PASSWORD", variables)
+	}
+	if tag := variables["APP_TAG"].(map[string]interface{}); tag["default"] != "latest" || tag["sensitive"] != true {
+		t.Errorf("APP_TAG = %v, want a sensitive variable defaulting to latest", tag)
+	}
+
+	resources := config["resource"].(map[string]interface{})
+	containers := resources["docker_container"].(map[string]interface{})
+	app := containers["app"].(map[string]interface{})
+	db := containers["db"].(map[string]interface{})
+
+	if app["name"] != "homelab-app-1" || app["restart"] != "unless-stopped" {
+		t.Errorf("app = %v", app)
+	}
+	if app["image"] != "${docker_image.ghcr_io_example_app_var_APP_TAG.image_id}" {
+		t.Errorf("app image = %v", app["image"])
+	}
+	if deps := app["depends_on"].([]interface{}); len(deps) != 1 || deps[0] != "docker_container.db" {
+		t.Errorf("app depends_on = %v", deps)
+	}
+	if command := app["command"].([]interface{}); command[2] != "echo $HOME $${literal}" {
+		t.Errorf("app command = %v, want literal text escaped", command)
+	}
+
+	for _, want := range []string{
+		`"POSTGRES_PASSWORD=${var.DB_PASSWORD}"`,
+		`"volume_name": "${docker_volume.db_data.name}"`,
+		`"host_path": "` + filepath.Join(tmpDir, "runtime", "app", "config.yml") + `"`,
+		`"name": "${docker_network.default.name}"`,
+		`"internal": 53`,
+		`"protocol": "udp"`,
+		`"ip": "127.0.0.1"`,
+		`"device": ":/media"`,
+		`"interval": "30s"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Render() output lacks %s", want)
+		}
+	}
+	if strings.Contains(text, "spec-hash") || strings.Contains(text, "__tf(") {
+		t.Error("Render() output leaks native deploy labels or placeholders")
+	}
+	if db["network_mode"] != "${docker_network.default.name}" {
+		t.Errorf("db network_mode = %v", db["network_mode"])
+	}
+}
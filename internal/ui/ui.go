@@ -0,0 +1,80 @@
+// Package ui presents what commands report to the person running them. Progress,
+// outcomes and hints are decorative: --quiet (or output.quiet in the user config)
+// drops them, so cron jobs only print errors, warnings and the data they asked for.
+// Data, such as list tables or JSON documents, is printed with fmt as before
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/monkeymonk/homelabctl/internal/style"
+)
+
+// stderr sends the presenter's output to stderr, inside ToStderr
+var stderr bool
+
+// ToStderr runs print with the presenter writing to stderr instead of stdout, for
+// guidance that must not mix with the data a command prints
+func ToStderr(print func()) {
+	stderr = true
+	defer func() { stderr = false }()
+	print()
+}
+
+// out returns where the presenter writes
+func out() io.Writer {
+	if stderr {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// Step prints the progress of a command, e.g. "Loading stacks..."
+func Step(format string, args ...interface{}) {
+	printf(format+"\n", args...)
+}
+
+// Success prints an outcome after a check mark, e.g. "✓ Enabled stack: media"
+func Success(format string, args ...interface{}) {
+	printf("✓ "+format+"\n", args...)
+}
+
+// Detail prints a line under a step, indented
+func Detail(format string, args ...interface{}) {
+	printf("  "+format+"\n", args...)
+}
+
+// Blank prints an empty line between sections
+func Blank() {
+	printf("\n")
+}
+
+// Hint prints guidance on what to run next, indented; output.hints: false drops it
+// even when not quiet
+func Hint(format string, args ...interface{}) {
+	if style.Hints() {
+		fmt.Fprint(out(), style.Glyphs(fmt.Sprintf("  "+format+"\n", args...)))
+	}
+}
+
+// printf prints decorative output on stdout, unless quiet, in ASCII with --ascii
+func printf(format string, args ...interface{}) {
+	if style.Quiet() {
+		return
+	}
+	fmt.Fprint(out(), style.Glyphs(fmt.Sprintf(format, args...)))
+}
+
+// NextSteps prints numbered commands to run next, under a "Next steps:" heading; like
+// hints, they are dropped when quiet or with output.hints: false
+func NextSteps(steps ...string) {
+	if !style.Hints() {
+		return
+	}
+	fmt.Fprintln(out(), "Next steps:")
+	for i, step := range steps {
+		fmt.Fprint(out(), style.Glyphs(fmt.Sprintf("  %d. %s\n", i+1, step)))
+	}
+}
@@ -0,0 +1,63 @@
+package ui
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+// capture returns what print writes to os.Stdout
+func capture(t *testing.T, print func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	print()
+	os.Stdout = stdout
+	w.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPresenter(t *testing.T) {
+	t.Setenv("HOMELAB_CONFIG", "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOMELAB_QUIET", "")
+
+	print := func() {
+		Step("Loading %d stack(s)...", 2)
+		Detail("✓ Rendered config: %s", "app.ini")
+		Blank()
+		Success("Written: %s", "runtime/docker-compose.yml")
+		Hint("Run 'homelabctl deploy' to apply changes")
+		NextSteps("Deploy: homelabctl deploy")
+	}
+
+	want := "Loading 2 stack(s)...\n" +
+		"  ✓ Rendered config: app.ini\n" +
+		"\n" +
+		"✓ Written: runtime/docker-compose.yml\n" +
+		"  Run 'homelabctl deploy' to apply changes\n" +
+		"Next steps:\n" +
+		"  1. Deploy: homelabctl deploy\n"
+	if got := capture(t, print); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	if got := capture(t, func() { ToStderr(print) }); got != "" {
+		t.Errorf("output = %q on stdout inside ToStderr, want none", got)
+	}
+
+	t.Setenv("HOMELAB_QUIET", "1")
+	if got := capture(t, print); got != "" {
+		t.Errorf("output = %q when quiet, want none", got)
+	}
+}
@@ -0,0 +1,239 @@
+// Package uptime records service availability in runtime/uptime/ and computes reports
+// A recorder appends a poll sample of every container at a fixed interval, plus the
+// container events in between; uptime is the share of polls a service was up in
+package uptime
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+)
+
+// dayFormat names the record files, one per UTC day
+const dayFormat = "2006-01-02"
+
+// Record kinds
+const (
+	KindPoll  = "poll"  // Container state sampled by the recorder
+	KindEvent = "event" // Container event reported by the runtime
+)
+
+// Record is one line of a runtime/uptime/<day>.jsonl file
+type Record struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Service string    `json:"service"`
+	Stack   string    `json:"stack,omitempty"`
+	Up      bool      `json:"up,omitempty"`    // Poll: running and not unhealthy
+	State   string    `json:"state,omitempty"` // Poll: status, with health when set (running/healthy)
+	Event   string    `json:"event,omitempty"` // Event: start, die, healthy or unhealthy
+}
+
+// Append writes records to the file of their day
+func Append(records []Record) error {
+	if err := os.MkdirAll(paths.UptimeDir, paths.DirPermissions); err != nil {
+		return fmt.Errorf("failed to create %s: %w", paths.UptimeDir, err)
+	}
+
+	byDay := make(map[string][]Record)
+	for _, r := range records {
+		day := r.Time.UTC().Format(dayFormat)
+		byDay[day] = append(byDay[day], r)
+	}
+
+	for day, dayRecords := range byDay {
+		path := filepath.Join(paths.UptimeDir, day+".jsonl")
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, paths.FilePermissions)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+
+		encoder := json.NewEncoder(file)
+		for _, r := range dayRecords {
+			if err := encoder.Encode(r); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load returns the records between since and until, oldest first
+// Unparseable lines (e.g. one cut short by a crash) are skipped
+func Load(since, until time.Time) ([]Record, error) {
+	entries, err := os.ReadDir(paths.UptimeDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", paths.UptimeDir, err)
+	}
+
+	first, last := since.UTC().Format(dayFormat), until.UTC().Format(dayFormat)
+
+	var records []Record
+	for _, entry := range entries {
+		day := strings.TrimSuffix(entry.Name(), ".jsonl")
+		if entry.IsDir() || day == entry.Name() || day < first || day > last {
+			continue
+		}
+
+		file, err := os.Open(filepath.Join(paths.UptimeDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read uptime records: %w", err)
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var r Record
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				continue
+			}
+			if !r.Time.Before(since) && !r.Time.After(until) {
+				records = append(records, r)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Name(), err)
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records, nil
+}
+
+// Report is the availability of each service over a period
+type Report struct {
+	Since    time.Time       `json:"since"`
+	Until    time.Time       `json:"until"`
+	Polls    int             `json:"polls"`
+	Services []ServiceReport `json:"services"`
+}
+
+// ServiceReport is the availability of one service
+type ServiceReport struct {
+	Stack     string  `json:"stack"`
+	Service   string  `json:"service"`
+	Uptime    float64 `json:"uptime"`    // Percent of polls the service was up in, since first seen
+	Polls     int     `json:"polls"`     // Polls since the service was first seen
+	Restarts  int     `json:"restarts"`  // Starts following a die: crashes, restarts, redeploys
+	Unhealthy int     `json:"unhealthy"` // Times its healthcheck turned unhealthy
+}
+
+// Compute builds a report from records sorted by time
+// A service is up in a poll when all its containers are; a poll it is missing from,
+// after it was first seen, counts as down
+func Compute(records []Record, since, until time.Time) *Report {
+	report := &Report{Since: since, Until: until, Services: []ServiceReport{}}
+
+	var rounds []time.Time
+	up := make(map[string]map[time.Time]bool) // service -> poll -> up
+	stacks := make(map[string]string)
+	firstRound := make(map[string]int)
+	lastEvent := make(map[string]string)
+	services := make(map[string]*ServiceReport)
+
+	service := func(name string) *ServiceReport {
+		if s, ok := services[name]; ok {
+			return s
+		}
+		s := &ServiceReport{Service: name}
+		services[name] = s
+		return s
+	}
+
+	for _, r := range records {
+		if r.Stack != "" {
+			stacks[r.Service] = r.Stack
+		}
+
+		switch r.Kind {
+		case KindPoll:
+			if len(rounds) == 0 || !rounds[len(rounds)-1].Equal(r.Time) {
+				rounds = append(rounds, r.Time)
+			}
+			service(r.Service)
+			if up[r.Service] == nil {
+				up[r.Service] = make(map[time.Time]bool)
+				firstRound[r.Service] = len(rounds) - 1
+			}
+			if previous, seen := up[r.Service][r.Time]; seen {
+				up[r.Service][r.Time] = previous && r.Up
+			} else {
+				up[r.Service][r.Time] = r.Up
+			}
+		case KindEvent:
+			s := service(r.Service)
+			switch r.Event {
+			case "start":
+				if lastEvent[r.Service] == "die" {
+					s.Restarts++
+				}
+			case "unhealthy":
+				s.Unhealthy++
+			}
+			lastEvent[r.Service] = r.Event
+		}
+	}
+
+	report.Polls = len(rounds)
+	for name, s := range services {
+		s.Stack = stacks[name]
+		if samples, ok := up[name]; ok {
+			s.Polls = len(rounds) - firstRound[name]
+			count := 0
+			for _, isUp := range samples {
+				if isUp {
+					count++
+				}
+			}
+			s.Uptime = float64(count) * 100 / float64(s.Polls)
+		}
+		report.Services = append(report.Services, *s)
+	}
+
+	sort.Slice(report.Services, func(i, j int) bool {
+		a, b := report.Services[i], report.Services[j]
+		if a.Stack != b.Stack {
+			return a.Stack < b.Stack
+		}
+		return a.Service < b.Service
+	})
+	return report
+}
+
+// Markdown renders the report as a markdown table
+func (r *Report) Markdown() string {
+	var b strings.Builder
+	b.WriteString("# Uptime report\n\n")
+	fmt.Fprintf(&b, "%s to %s, %d poll(s)\n\n", r.Since.Local().Format("2006-01-02 15:04"), r.Until.Local().Format("2006-01-02 15:04"), r.Polls)
+	b.WriteString("| Stack | Service | Uptime | Restarts | Unhealthy |\n")
+	b.WriteString("|-------|---------|-------:|---------:|----------:|\n")
+	for _, s := range r.Services {
+		uptime := "-"
+		if s.Polls > 0 {
+			uptime = fmt.Sprintf("%.2f%%", s.Uptime)
+		}
+		stack := s.Stack
+		if stack == "" {
+			stack = "-"
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %d | %d |\n", stack, s.Service, uptime, s.Restarts, s.Unhealthy)
+	}
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package uptime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/paths"
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestAppendAndLoad(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	restoreDir := testutil.Chdir(t, tmpDir)
+	defer restoreDir()
+
+	day := time.Date(2026, 10, 14, 23, 59, 0, 0, time.UTC)
+	records := []Record{
+		{Time: day, Kind: KindPoll, Service: "grafana", Stack: "monitoring", Up: true},
+		{Time: day.Add(2 * time.Minute), Kind: KindPoll, Service: "grafana", Stack: "monitoring"},
+		{Time: day.Add(48 * time.Hour), Kind: KindPoll, Service: "grafana", Stack: "monitoring"},
+	}
+	if err := Append(records); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	// One file per UTC day; a truncated line is skipped
+	for _, name := range []string{"2026-10-14.jsonl", "2026-10-15.jsonl", "2026-10-16.jsonl"} {
+		if _, err := os.Stat(filepath.Join(paths.UptimeDir, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+	file, err := os.OpenFile(filepath.Join(paths.UptimeDir, "2026-10-15.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"time":"2026-10-15T00:05`)
+	file.Close()
+
+	loaded, err := Load(day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(loaded) != 2 || !loaded[0].Up || loaded[1].Up {
+		t.Errorf("Load() = %+v, want the first two records in order", loaded)
+	}
+}
+
+func TestCompute(t *testing.T) {
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	poll := func(minutes int, service string, up bool) Record {
+		return Record{Time: at(minutes), Kind: KindPoll, Service: service, Stack: "media", Up: up}
+	}
+	event := func(minutes int, service, name string) Record {
+		return Record{Time: at(minutes), Kind: KindEvent, Service: service, Stack: "media", Event: name}
+	}
+
+	records := []Record{
+		poll(0, "jellyfin", true),
+		poll(1, "jellyfin", true),
+		event(1, "jellyfin", "die"),
+		event(1, "jellyfin", "start"),
+		poll(2, "jellyfin", false),
+		poll(2, "sonarr", true), // First seen in the third poll
+		poll(3, "jellyfin", true),
+		poll(3, "sonarr", true),
+		event(3, "sonarr", "unhealthy"),
+		poll(4, "jellyfin", true),
+		// sonarr missing from the last poll: down
+	}
+
+	report := Compute(records, start, at(5))
+	if report.Polls != 5 || len(report.Services) != 2 {
+		t.Fatalf("Compute() = %+v", report)
+	}
+
+	jellyfin, sonarr := report.Services[0], report.Services[1]
+	if jellyfin.Service != "jellyfin" || jellyfin.Polls != 5 || jellyfin.Uptime != 80 || jellyfin.Restarts != 1 {
+		t.Errorf("jellyfin = %+v, want 80%% over 5 polls with 1 restart", jellyfin)
+	}
+	if sonarr.Polls != 3 || sonarr.Uptime*3 != 200 || sonarr.Unhealthy != 1 || sonarr.Restarts != 0 {
+		t.Errorf("sonarr = %+v, want 2 of 3 polls up and 1 unhealthy", sonarr)
+	}
+
+	markdown := report.Markdown()
+	if !strings.Contains(markdown, "| media | jellyfin | 80.00% | 1 | 0 |") {
+		t.Errorf("Markdown() = %s", markdown)
+	}
+}
@@ -0,0 +1,180 @@
+// Package watch reports changes to the files of directories, from fsnotify events
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watcher follows the directories of a watch and collects the files changed under them
+type watcher struct {
+	fsw     *fsnotify.Watcher
+	dirs    []string
+	ignored map[string]bool
+	pending map[string]bool
+}
+
+// Watch calls onChange with the files changed under dirs, once they have been quiet for
+// debounce, so an editor saving several files triggers one call. A missing directory is
+// watched from its parent until it is created. Hidden directories and editor swap and
+// backup files are left out, as are the ignored files, which onChange itself writes.
+// Changes made while onChange runs are reported by the next call.
+// It returns when onChange returns false, or when the directories can no longer be watched
+func Watch(dirs, ignored []string, debounce time.Duration, onChange func(changed []string) bool) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", strings.Join(dirs, ", "), err)
+	}
+	defer fsw.Close()
+
+	w := &watcher{fsw: fsw, ignored: make(map[string]bool), pending: make(map[string]bool)}
+	for _, dir := range dirs {
+		w.dirs = append(w.dirs, filepath.Clean(dir))
+	}
+	for _, file := range ignored {
+		w.ignored[filepath.Clean(file)] = true
+	}
+	for _, dir := range w.dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := fsw.Add(filepath.Dir(dir)); err != nil {
+				return fmt.Errorf("failed to watch %s: %w", filepath.Dir(dir), err)
+			}
+			continue
+		}
+		if err := w.add(dir, false); err != nil {
+			return err
+		}
+	}
+
+	// Stopped until a change arms it
+	settled := time.NewTimer(0)
+	if !settled.Stop() {
+		<-settled.C
+	}
+	for {
+		select {
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			if w.handle(event) {
+				rearm(settled, debounce)
+			}
+
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("failed to watch %s: %w", strings.Join(dirs, ", "), err)
+
+		case <-settled.C:
+			changed := make([]string, 0, len(w.pending))
+			for path := range w.pending {
+				changed = append(changed, path)
+			}
+			sort.Strings(changed)
+			w.pending = make(map[string]bool)
+
+			if !onChange(changed) {
+				return nil
+			}
+		}
+	}
+}
+
+// handle records the file of an event and follows a created directory; it reports
+// whether the event is a change
+func (w *watcher) handle(event fsnotify.Event) bool {
+	// The parent of a missing directory may be ".", which fsnotify keeps in names
+	event.Name = filepath.Clean(event.Name)
+	// Permissions and timestamps only, which editors and touch alter without a change
+	if event.Op == fsnotify.Chmod || !w.watched(event.Name) || w.ignored[event.Name] || editorFile(filepath.Base(event.Name)) {
+		return false
+	}
+
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Lstat(event.Name); err == nil && info.IsDir() {
+			if hiddenDir(event.Name, w.dirs) {
+				return false
+			}
+			// Files written before the directory was watched are changes too
+			if err := w.add(event.Name, true); err != nil {
+				w.pending[event.Name] = true
+			}
+			return true
+		}
+	}
+
+	w.pending[event.Name] = true
+	return true
+}
+
+// add watches dir and its subdirectories, except hidden ones; with record, the files
+// found are recorded as changed
+func (w *watcher) add(dir string, record bool) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Removed while walking
+			}
+			return err
+		}
+		if !info.IsDir() {
+			if record && !editorFile(info.Name()) {
+				w.pending[path] = true
+			}
+			return nil
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir // .git and the like
+		}
+		if err := w.fsw.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// rearm restarts a timer for d, dropping a tick it fired that was not received
+func rearm(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// watched reports whether a path is one of the watched directories or under one, as
+// opposed to a sibling in the parent of a missing directory
+func (w *watcher) watched(path string) bool {
+	for _, dir := range w.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenDir reports whether a directory is hidden, other than a watched directory itself
+func hiddenDir(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir {
+			return false
+		}
+	}
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// editorFile reports whether a file name is an editor's swap, backup or lock file
+func editorFile(name string) bool {
+	return strings.HasSuffix(name, "~") || strings.HasSuffix(name, ".swp") || strings.HasSuffix(name, ".swx") ||
+		strings.HasPrefix(name, ".#") || (strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#"))
+}
@@ -0,0 +1,90 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/monkeymonk/homelabctl/internal/testutil"
+)
+
+func TestWatch(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	stacks := filepath.Join(tmpDir, "stacks")
+	inventory := filepath.Join(tmpDir, "inventory")
+	secrets := filepath.Join(tmpDir, "secrets") // Created while watching
+	testutil.WriteFile(t, filepath.Join(inventory, "vars.yaml"), "domain: home.lan\n")
+	testutil.WriteFile(t, filepath.Join(stacks, "app", "stack.yaml"), "name: app\n")
+	testutil.WriteFile(t, filepath.Join(stacks, ".git", "HEAD"), "ref\n")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		// Modified, added in a new directory, removed, created with its directory, and
+		// ignored: an editor swap file, a hidden directory and a sibling of secrets/
+		testutil.WriteFile(t, filepath.Join(inventory, "vars.yaml"), "domain: example.com\n")
+		testutil.WriteFile(t, filepath.Join(stacks, "media", "stack.yaml"), "name: media\n")
+		os.Remove(filepath.Join(stacks, "app", "stack.yaml"))
+		testutil.WriteFile(t, filepath.Join(secrets, "app.yaml"), "password: x\n")
+		testutil.WriteFile(t, filepath.Join(stacks, "app", ".stack.yaml.swp"), "x\n")
+		testutil.WriteFile(t, filepath.Join(stacks, ".git", "HEAD"), "other\n")
+		testutil.WriteFile(t, filepath.Join(tmpDir, "notes.txt"), "x\n")
+	}()
+
+	var calls [][]string
+	err := Watch([]string{stacks, inventory, secrets}, nil, 100*time.Millisecond, func(changed []string) bool {
+		calls = append(calls, changed)
+		return false
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	want := []string{
+		filepath.Join(inventory, "vars.yaml"),
+		filepath.Join(secrets, "app.yaml"),
+		filepath.Join(stacks, "app", "stack.yaml"),
+		filepath.Join(stacks, "media", "stack.yaml"),
+	}
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0], want) {
+		t.Errorf("Watch() called back with %v, want [%v]", calls, want)
+	}
+}
+
+func TestWatch_ChangesDuringRun(t *testing.T) {
+	tmpDir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	vars := filepath.Join(tmpDir, "inventory", "vars.yaml")
+	state := filepath.Join(tmpDir, "inventory", "state.yaml")
+	testutil.WriteFile(t, vars, "domain: home.lan\n")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		testutil.WriteFile(t, vars, "domain: example.com\n")
+	}()
+
+	var calls [][]string
+	err := Watch([]string{filepath.Join(tmpDir, "inventory")}, []string{state}, 100*time.Millisecond, func(changed []string) bool {
+		calls = append(calls, changed)
+		if len(calls) > 1 {
+			return false
+		}
+		// The run writes its own file, while the user saves again
+		testutil.WriteFile(t, state, "last_deploy: now\n")
+		testutil.WriteFile(t, vars, "domain: example.org\n")
+		time.Sleep(50 * time.Millisecond)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	want := [][]string{{vars}, {vars}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Watch() called back with %v, want %v", calls, want)
+	}
+}
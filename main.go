@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/monkeymonk/homelabctl/cmd"
 	"github.com/monkeymonk/homelabctl/internal/errors"
+	"github.com/monkeymonk/homelabctl/internal/style"
 )
 
 func main() {
@@ -14,6 +16,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Completion scripts pass the command line as is, global flags included
+	if os.Args[1] == "__complete" {
+		cmd.Complete(os.Args[2:])
+		return
+	}
+
+	// Everything after -- is passed on untouched, global flags included, e.g.
+	// homelabctl compose -- config --output out.yml
+	var passedOn []string
+	for i, arg := range os.Args {
+		if arg == "--" {
+			passedOn = append(passedOn, os.Args[i:]...)
+			os.Args = os.Args[:i]
+			break
+		}
+	}
+
 	// Parse debug flag
 	for i := 1; i < len(os.Args); i++ {
 		if os.Args[i] == "--debug" {
@@ -24,6 +43,86 @@ func main() {
 		}
 	}
 
+	// Parse error format flag (--error-format json|text), before the command
+	errorFormat := "text"
+	if rest, value, ok := takeLeadingFlag(os.Args, "--error-format"); ok {
+		errorFormat = value
+		os.Args = rest
+	}
+	// Parse strict flag (treat warnings as errors), before the command
+	if rest, ok := takeLeadingSwitch(os.Args, "--strict"); ok {
+		os.Setenv("HOMELAB_STRICT", "1")
+		os.Args = rest
+	}
+
+	// Parse host flag (run docker on a remote host over SSH), before the command
+	if rest, value, ok := takeLeadingFlag(os.Args, "--host"); ok {
+		os.Setenv("HOMELAB_HOST", value)
+		os.Args = rest
+	}
+
+	// Parse environment flag (inventory/environments/<env>/vars.yaml overrides)
+	// Only before the command: docker compose run and exec have an --env of their own
+	if rest, value, ok := takeLeadingFlag(os.Args, "--env"); ok {
+		os.Setenv("HOMELAB_ENV", value)
+		os.Args = rest
+	}
+
+	// Parse engine flag (container runtime: compose, podman, docker-api), before the command
+	if rest, value, ok := takeLeadingFlag(os.Args, "--engine"); ok {
+		os.Setenv("HOMELAB_ENGINE", value)
+		os.Args = rest
+	}
+
+	// Parse project flag (docker compose project name), before the command
+	if rest, value, ok := takeLeadingFlag(os.Args, "--project"); ok {
+		os.Setenv("HOMELAB_PROJECT", value)
+		os.Args = rest
+	}
+
+	// Parse output flag (list, validate and doctor print JSON or YAML)
+	// Only before the command: docker compose config has an --output of its own
+	if rest, value, ok := takeLeadingFlag(os.Args, "--output"); ok {
+		os.Setenv("HOMELAB_OUTPUT", value)
+		os.Args = rest
+	}
+
+	// Parse ascii flag (replace ✓, ⨯, → and other glyphs of homelabctl's messages)
+	// Only before the command, so arguments passed on keep it
+	if rest, ok := takeLeadingSwitch(os.Args, "--ascii"); ok {
+		os.Setenv("HOMELAB_ASCII", "1")
+		os.Args = rest
+	}
+
+	// Parse quiet flag (drop progress, results and hints, e.g. for cron)
+	// Only before the command: docker compose subcommands have a --quiet of their own
+	if rest, ok := takeLeadingSwitch(os.Args, "--quiet"); ok {
+		os.Setenv("HOMELAB_QUIET", "1")
+		os.Args = rest
+	}
+
+	os.Args = append(os.Args, passedOn...)
+
+	if errorFormat != "text" && errorFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --error-format value: %s (available: text, json)\n", errorFormat)
+		os.Exit(1)
+	}
+
+	if output := os.Getenv("HOMELAB_OUTPUT"); output != "" && output != "text" && output != "json" && output != "yaml" {
+		fmt.Fprintf(os.Stderr, "Error: invalid --output value: %s (available: text, json, yaml)\n", output)
+		os.Exit(1)
+	}
+
+	// The user config sets the output style (~/.config/homelabctl/config.yaml)
+	if err := style.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
 	command := os.Args[1]
 	args := os.Args[2:]
 
@@ -35,25 +134,33 @@ func main() {
 
 	var err error
 
-	switch command {
-	case "init":
-		err = cmd.Init()
-	case "enable":
-		err = cmd.Enable(args)
-	case "disable":
-		err = cmd.Disable(args)
-	case "list":
-		err = cmd.List()
-	case "validate":
-		err = cmd.Validate()
-	case "generate":
-		err = cmd.Generate()
-	case "deploy":
-		err = cmd.Deploy()
-	default:
-		// Pass through to docker compose for all other commands
-		// This allows ps, logs, restart, stop, down, exec, pull, config, etc.
-		err = cmd.Compose(command, args)
+	if c, ok := cmd.LookupCommand(command); ok {
+		err = c.Run(args)
+	} else {
+		// Known docker compose commands (images, port, up...) are passed through; typos
+		// get suggestions, and homelabctl compose -- runs anything else
+		err = cmd.Passthrough(command, args)
+	}
+
+	// Guidance after the first command in a repository with nothing enabled
+	if err == nil {
+		cmd.FirstRun(command)
+	}
+
+	// Warnings are reported once, at the end, separately from fatal errors
+	warnings := cmd.Warnings()
+	if errorFormat == "json" {
+		if err != nil || len(warnings) > 0 {
+			fmt.Fprintln(os.Stderr, errors.JSONReport(err, warnings))
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(warnings) > 0 {
+		fmt.Fprint(os.Stderr, "\n"+errors.FormatWarnings(warnings))
 	}
 
 	if err != nil {
@@ -63,44 +170,172 @@ func main() {
 			fmt.Fprint(os.Stderr, enhancedErr.Error())
 		} else {
 			// Standard error
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			fmt.Fprint(os.Stderr, style.Glyphs(fmt.Sprintf("Error: %v\n", err)))
 		}
 		os.Exit(1)
 	}
 }
 
+// globalValueFlags are the global flags taking a value, as --name value or --name=value
+var globalValueFlags = map[string]bool{
+	"--error-format": true,
+	"--host":         true,
+	"--env":          true,
+	"--engine":       true,
+	"--project":      true,
+	"--output":       true,
+}
+
+// commandIndex returns the index of the command in args, after the global flags given
+// before it (len(args) when there is none)
+func commandIndex(args []string) int {
+	i := 1
+	for i < len(args) && strings.HasPrefix(args[i], "-") {
+		if globalValueFlags[args[i]] {
+			i++ // Its value
+		}
+		i++
+	}
+	return min(i, len(args))
+}
+
+// takeLeadingFlag removes a global value flag given before the command from args, and
+// returns its value. After the command, the flag is the command's own: docker compose
+// run and exec take --env, and compose config --output
+func takeLeadingFlag(args []string, name string) ([]string, string, bool) {
+	for i := 1; i < commandIndex(args); i++ {
+		if args[i] == name && i+1 < len(args) {
+			return append(args[:i:i], args[i+2:]...), args[i+1], true
+		}
+		if value, ok := strings.CutPrefix(args[i], name+"="); ok {
+			return append(args[:i:i], args[i+1:]...), value, true
+		}
+	}
+	return args, "", false
+}
+
+// takeLeadingSwitch removes a global flag without a value given before the command from
+// args, and reports whether it was there
+func takeLeadingSwitch(args []string, name string) ([]string, bool) {
+	for i := 1; i < commandIndex(args); i++ {
+		if args[i] == name {
+			return append(args[:i:i], args[i+1:]...), true
+		}
+	}
+	return args, false
+}
+
 func printUsage() {
 	fmt.Println("homelabctl - Homelab Stack Runtime CLI")
 	fmt.Println()
 	fmt.Println("Setup:")
 	fmt.Println("  homelabctl init                            Initialize new repository or verify existing")
+	fmt.Println("  homelabctl init --template <name|git-url>  Initialize with a curated bundle of stacks")
 	fmt.Println("  homelabctl enable <stack> [--suggest-category]  Enable a stack")
-	fmt.Println("  homelabctl enable -s <service>             Re-enable a disabled service")
-	fmt.Println("  homelabctl disable <stack>        Disable a stack")
-	fmt.Println("  homelabctl disable -s <service>   Disable a service (keeps stack enabled)")
+	fmt.Println("  homelabctl enable --with-deps <[catalog/]stack>  Enable with dependencies, installing missing ones")
+	fmt.Println("  homelabctl enable --configure <stack>      Prompt for the stack's variables (vars_schema)")
+	fmt.Println("  homelabctl enable -s <[stack/]service>     Re-enable a disabled service")
+	fmt.Println("  homelabctl disable <stack> [--cascade|--force]  Disable a stack (--cascade: and its dependents)")
+	fmt.Println("  homelabctl disable -s <[stack/]service>  Disable a service (keeps stack enabled)")
 	fmt.Println("  homelabctl list                   List enabled stacks and disabled services")
-	fmt.Println("  homelabctl validate               Validate configuration")
+	fmt.Println("  homelabctl info <stack>           Show stack details, README and notes")
+	fmt.Println("  homelabctl graph [--all] [--format dot]  Stack dependency tree, or Graphviz DOT")
+	fmt.Println("  homelabctl validate [--render]    Validate configuration (--render: render templates with the built-in engine)")
+	fmt.Println("  homelabctl doctor                 Check docker, compose, gomplate, sops and the repository, with fixes")
+	fmt.Println("  homelabctl vars example [--all]   Write inventory/vars.example.yaml from stack variables")
+	fmt.Println("  homelabctl docs [--out <dir>] [--format html]  Generate stack documentation site")
+	fmt.Println("  homelabctl secrets encrypt|decrypt|edit <stack>  Encrypt secrets/<stack>.yaml with age, print or edit them")
+	fmt.Println("  homelabctl secrets set <stack> <key> <value|->  Set one secret, encrypted as its file is (sops or age)")
+	fmt.Println("  homelabctl scan-secrets [stack...]  Find secret values in world-readable files of runtime/")
+	fmt.Println("  homelabctl state prune [--dry-run]  Remove disabled services no enabled stack defines")
 	fmt.Println()
 	fmt.Println("Deployment:")
 	fmt.Println("  homelabctl generate               Generate runtime files")
+	fmt.Println("  homelabctl generate --annotate    Comment each service with its source stack and template")
+	fmt.Println("  homelabctl generate --terraform   Also write runtime/terraform/main.tf.json (docker provider)")
+	fmt.Println("  homelabctl generate <stack>...    Render only these stacks, keeping the others as generated")
+	fmt.Println("  homelabctl generate --stages      List the pipeline stages, with plugins from inventory/plugins.yaml")
+	fmt.Println("  homelabctl generate --watch [--deploy]  Generate (or deploy) again whenever stacks/, inventory/, enabled/ or secrets/ change")
+	fmt.Println("  homelabctl export ansible [--out <dir>]  Write an Ansible inventory and group_vars (runtime/ansible)")
+	fmt.Println("  homelabctl firewall generate      Write ufw, nftables and firewalld rules for published ports (runtime/firewall)")
+	fmt.Println("  homelabctl firewall apply [--format <f>]  Regenerate and load rules into the host firewall (default: ufw)")
+	fmt.Println("  homelabctl plan [stack...]        Diff what generate would write against the deployed compose file")
 	fmt.Println("  homelabctl deploy                 Generate and deploy")
+	fmt.Println("  homelabctl deploy <stack>...      Regenerate and deploy only these stacks' services")
+	fmt.Println("  homelabctl deploy --waves         Deploy one category at a time, waiting for health")
+	fmt.Println("  homelabctl deploy --at 03:00      Wait for a time (or --window 02:00-05:00), then deploy")
+	fmt.Println("  homelabctl deploy --dry-run       Show the containers a deploy would create or recreate")
+	fmt.Println("  homelabctl deploy --no-health-check  Skip waiting for healthchecks after deploying")
+	fmt.Println("  homelabctl bundle [--out <file>]  Save images and runtime/ into a tarball for offline hosts")
+	fmt.Println("  homelabctl deploy --from-bundle <file>  Load a bundle's images and runtime/, then deploy")
+	fmt.Println("  homelabctl rollback [--to <timestamp>]  Redeploy the compose file and configs of an earlier generate (--list)")
+	fmt.Println("  homelabctl badge <validate|stacks|deploy>  Print a shields.io endpoint badge as JSON")
+	fmt.Println("  homelabctl badge --out <dir>  Write every badge to <dir>/<kind>.json")
+	fmt.Println("  homelabctl canary <[stack/]service> --image <image>  Try an image, smoke test, promote or roll back")
+	fmt.Println("  homelabctl blue-green <[stack/]service>  Zero-downtime switch of a Traefik-exposed service")
+	fmt.Println("  homelabctl dev <stack>            Watch the stack's develop paths with docker compose watch")
 	fmt.Println()
-	fmt.Println("Flags:")
+	fmt.Println("Flags (before the command; --debug also after it):")
 	fmt.Println("  --debug                           Enable debug mode (preserve temporary files)")
+	fmt.Println("  --error-format json               Print errors as JSON on stderr (for scripts)")
+	fmt.Println("  --strict                          Treat warnings as errors (validate, generate, deploy)")
+	fmt.Println("  --host <name>                     Sync runtime/ and run docker over SSH (inventory/hosts.yaml)")
+	fmt.Println("  --engine <name>                   Container engine: compose (default), podman, docker-api")
+	fmt.Println("  --env <name>                      Apply inventory/environments/<name>/vars.yaml (also HOMELAB_ENV)")
+	fmt.Println("  --project <name>                  Docker compose project name (also HOMELAB_PROJECT, inventory/compose.yaml)")
+	fmt.Println("  --output <text|json|yaml>         Print list, validate and doctor results as JSON or YAML on stdout")
+	fmt.Println("  --quiet                           Only errors, warnings and data, for cron (also HOMELAB_QUIET)")
+	fmt.Println("  --ascii                           Replace ✓, ⨯, → and other glyphs with ASCII (also HOMELAB_ASCII)")
 	fmt.Println()
 	fmt.Println("Operations:")
 	fmt.Println("  homelabctl ps                     Show service status")
-	fmt.Println("  homelabctl logs [service...]      Show logs (default: follow all)")
+	fmt.Println("  homelabctl top --by-stack         CPU, memory and network usage per stack and category")
+	fmt.Println("  homelabctl du [stack...]          Disk usage of volumes and persistence paths per stack")
+	fmt.Println("  homelabctl report record          Record container states and events for uptime reports")
+	fmt.Println("  homelabctl report [--since 7d] [--format markdown|json]  Uptime and restarts per service")
+	fmt.Println("  homelabctl notify test [--severity critical] [--stack <name>]  Send a test notification through the routes")
+	fmt.Println("  homelabctl ddns [--dry-run]       Point the DNS records of exposed hosts at the WAN IP (Cloudflare, deSEC)")
+	fmt.Println("  homelabctl ddns watch [--interval 5m]  Update the DNS records whenever the WAN IP changes")
+	fmt.Println("  homelabctl power status           UPS state and when an outage stops each category")
+	fmt.Println("  homelabctl power watch            Stop categories on battery after their grace period, restart on power restore")
+	fmt.Println("  homelabctl install-service [--compose]  Write and enable a systemd unit deploying the homelab at boot")
+	fmt.Println("  homelabctl logs [stack|service...] [-f]  Show logs prefixed with stack/service")
+	fmt.Println("  homelabctl logs <stack...> --grep <regex> --since 1h  Filter several stacks' logs")
+	fmt.Println("  homelabctl events [stack|service...] [--format json]  Stream container events with stack/category")
+	fmt.Println("  homelabctl events --exit-on grafana=healthy --timeout 2m  Block until a condition (--fail-on to abort)")
+	fmt.Println("  homelabctl wait <service|stack>... [--timeout 120s]  Block until containers are running and healthy")
+	fmt.Println("  homelabctl verify [stack]...      Check containers, images, config hashes and rendered files match the last generate")
 	fmt.Println("  homelabctl restart [service...]   Restart services (default: all)")
+	fmt.Println("  homelabctl restart [--stack <stack>] --ordered  Restart in dependency order")
 	fmt.Println("  homelabctl stop [service...]      Stop services (default: all)")
+	fmt.Println("  homelabctl stop <stack>           Stop only the services of a stack")
+	fmt.Println("  homelabctl pause <stack> [--stop]  Pause (or stop) a stack's services, keeping their containers")
+	fmt.Println("  homelabctl resume <stack>         Unpause or start the services of a paused stack")
 	fmt.Println("  homelabctl down [--volumes]       Stop and remove containers")
+	fmt.Println("  homelabctl down <stack> [--volumes [--yes]]  Stop and remove only a stack's containers")
 	fmt.Println("  homelabctl exec <service> <cmd>   Execute command in container")
+	fmt.Println("  homelabctl pull [stack|service...]  Pull images of enabled services in parallel")
+	fmt.Println("  homelabctl outdated [--json]      Check registries for newer tags or digests of stack images")
+	fmt.Println("  homelabctl update [--scheduled] [--dry-run]  Pull and recreate updated services per category policy")
+	fmt.Println("  homelabctl prune --images [--dry-run]  Remove images of disabled/deleted stacks")
+	fmt.Println("  homelabctl clean [--history] [--dry-run]  Apply the runtime/history/ retention policy (--history: remove it all)")
+	fmt.Println("  homelabctl volumes migrate <old> <new>  Move volume (or bind path) data and update stacks")
+	fmt.Println("  homelabctl volumes check [stack]  Test-mount the NFS/SMB shares declared by stacks")
+	fmt.Println("  homelabctl sbom [--format spdx] [--out <file>]  Export deployed images (CycloneDX or SPDX)")
+	fmt.Println("  homelabctl query '<expr>' [--format json]  List generated services matching an expression")
 	fmt.Println()
 	fmt.Println("Passthrough:")
-	fmt.Println("  Any other command is passed to docker compose with the correct file:")
-	fmt.Println("  homelabctl pull             # docker compose pull")
+	fmt.Println("  Other docker compose commands are passed through with the correct file:")
 	fmt.Println("  homelabctl config           # docker compose config")
-	fmt.Println("  homelabctl top              # docker compose top")
+	fmt.Println("  homelabctl images           # docker compose images")
+	fmt.Println("  homelabctl compose -- <command> [args...]  # Any docker compose command, flags untouched")
+	fmt.Println("  eval \"$(homelabctl env)\"     # Export COMPOSE_FILE and COMPOSE_PROJECT_NAME for raw docker compose")
+	fmt.Println()
+	fmt.Println("Try it:")
+	fmt.Println("  homelabctl demo [--no-deploy]     Walk through enable → generate → deploy with demo stacks")
+	fmt.Println()
+	fmt.Println("Shell completion:")
+	fmt.Println("  homelabctl completion bash|zsh|fish  Print the completion script, e.g. source <(homelabctl completion bash)")
 	fmt.Println()
 	fmt.Println("Get started:")
 	fmt.Println("  mkdir homelab && cd homelab")
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTakeLeadingFlag(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		flag      string
+		wantArgs  []string
+		wantValue string
+		wantOK    bool
+	}{
+		{
+			name:      "before the command",
+			args:      []string{"homelabctl", "--env", "prod", "deploy"},
+			flag:      "--env",
+			wantArgs:  []string{"homelabctl", "deploy"},
+			wantValue: "prod",
+			wantOK:    true,
+		},
+		{
+			name:      "with equals, after another global flag",
+			args:      []string{"homelabctl", "--engine", "podman", "--output=json", "list"},
+			flag:      "--output",
+			wantArgs:  []string{"homelabctl", "--engine", "podman", "list"},
+			wantValue: "json",
+			wantOK:    true,
+		},
+		{
+			name:     "passthrough command's own flag",
+			args:     []string{"homelabctl", "exec", "--env", "FOO=bar", "app", "sh"},
+			flag:     "--env",
+			wantArgs: []string{"homelabctl", "exec", "--env", "FOO=bar", "app", "sh"},
+		},
+		{
+			name:      "both",
+			args:      []string{"homelabctl", "--env", "prod", "run", "--env", "K=V", "svc"},
+			flag:      "--env",
+			wantArgs:  []string{"homelabctl", "run", "--env", "K=V", "svc"},
+			wantValue: "prod",
+			wantOK:    true,
+		},
+		{
+			name:     "after the command",
+			args:     []string{"homelabctl", "logs", "--host", "nas", "grafana"},
+			flag:     "--host",
+			wantArgs: []string{"homelabctl", "logs", "--host", "nas", "grafana"},
+		},
+		{
+			name:     "compose config output",
+			args:     []string{"homelabctl", "config", "--output", "merged.yml"},
+			flag:     "--output",
+			wantArgs: []string{"homelabctl", "config", "--output", "merged.yml"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, value, ok := takeLeadingFlag(tt.args, tt.flag)
+			if !reflect.DeepEqual(args, tt.wantArgs) || value != tt.wantValue || ok != tt.wantOK {
+				t.Errorf("takeLeadingFlag() = %v, %q, %v, want %v, %q, %v", args, value, ok, tt.wantArgs, tt.wantValue, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCommandIndex(t *testing.T) {
+	tests := map[int][]string{
+		1: {"homelabctl", "deploy", "--env", "x"},
+		4: {"homelabctl", "--quiet", "--project", "lab", "up", "-d"},
+		3: {"homelabctl", "--strict", "--host=nas", "generate"},
+		2: {"homelabctl", "--debug"},
+	}
+	for want, args := range tests {
+		if got := commandIndex(args); got != want {
+			t.Errorf("commandIndex(%v) = %d, want %d", args, got, want)
+		}
+	}
+}
+
+func TestTakeLeadingSwitch(t *testing.T) {
+	args, ok := takeLeadingSwitch([]string{"homelabctl", "--ascii", "--env", "prod", "generate"}, "--ascii")
+	if want := []string{"homelabctl", "--env", "prod", "generate"}; !ok || !reflect.DeepEqual(args, want) {
+		t.Errorf("takeLeadingSwitch() = %v, %v, want %v, true", args, ok, want)
+	}
+
+	// After the command, the flag belongs to its arguments
+	passed := []string{"homelabctl", "exec", "app", "figlet", "--ascii"}
+	if args, ok := takeLeadingSwitch(passed, "--ascii"); ok || !reflect.DeepEqual(args, passed) {
+		t.Errorf("takeLeadingSwitch() = %v, %v, want the arguments untouched", args, ok)
+	}
+}